@@ -84,3 +84,93 @@ assert(not ok and string.find(msg, "level out of range"))
 
 assert(debug.getinfo(100) == nil)
 assert(debug.getinfo(1, "a") == nil)
+
+-- debug.traceback([thread,] [message [, level]])
+local co = coroutine.create(function()
+  local function inner() coroutine.yield() end
+  inner()
+end)
+coroutine.resume(co)
+local tb = debug.traceback(co, "co msg")
+assert(string.find(tb, "^co msg\nstack traceback:\n\t[^\n]*in function 'inner'"), tb)
+tb = debug.traceback(co, "co msg", 1)
+assert(string.find(tb, "^co msg\nstack traceback:\n") and not string.find(tb, "inner"), tb)
+assert(not string.find(debug.traceback(co), "co msg"))
+
+local t = {}
+assert(debug.traceback(t) == t)
+assert(string.find(debug.traceback(42), "^42\nstack traceback:"))
+assert(string.find(debug.traceback(nil), "^stack traceback:"))
+
+local function tailcalled() return debug.traceback() end
+local function tailcaller() return tailcalled() end
+tb = tailcaller()
+assert(string.find(tb, "\n\t%(tail call%): %?"), tb)
+
+local function deep(n)
+  if n == 0 then return debug.traceback("deep") end
+  local tb = deep(n - 1)
+  return tb
+end
+tb = deep(30)
+local count = 0
+for _ in string.gmatch(tb, "\n\t") do count = count + 1 end
+-- levels 1 to 11, "...", and the last 10 levels
+assert(count == 22, tb)
+assert(string.find(tb, "\n\t%.%.%.\n"))
+local _, n = string.gsub(deep(5), "\n\t", "")
+assert(n == 8) -- deep 6 times, the main chunk and [G]
+
+-- the debug functions accept a thread
+local co = coroutine.create(function(a)
+  local b = a * 2
+  coroutine.yield(b)
+end)
+assert(coroutine.resume(co, 21))
+local info = debug.getinfo(co, 0, "Sl")
+assert(info.currentline == 127 and info.source == "db.lua", info.currentline)
+assert(debug.getinfo(co, 5) == nil)
+local name, value = debug.getlocal(co, 0, 2)
+assert(name == "b" and value == 42)
+assert(debug.setlocal(co, 0, 2, 50) == "b")
+assert(select(2, debug.getlocal(co, 0, 2)) == 50)
+assert(not pcall(debug.getlocal, co, 5, 1))
+
+local events = {}
+local co = coroutine.create(function()
+  local x = 1
+  coroutine.yield()
+  return x
+end)
+local function hook(event, line)
+  if event == "line" then
+    events[#events + 1] = line
+  else
+    events[#events + 1] = event .. " " .. debug.getinfo(2, "S").what
+  end
+end
+debug.sethook(co, hook, "crl")
+local fn, mask, count = debug.gethook(co)
+assert(fn == hook and mask == "crl" and count == 0)
+assert(debug.gethook() == nil)
+coroutine.resume(co)
+coroutine.resume(co)
+assert(table.concat(events, ",") == "call main,141,142,call G,143,return main", table.concat(events, ","))
+
+local n = 0
+co = coroutine.create(function() for i = 1, 100 do end end)
+debug.sethook(co, function(event) n = n + 1 end, "", 10)
+coroutine.resume(co)
+assert(n >= 10 and n <= 12, tostring(n)) -- one FORLOOP per iteration
+debug.sethook(co)
+assert(debug.gethook(co) == nil)
+
+local lines = {}
+local function f()
+  local y = 1
+  return y + 1
+end
+debug.sethook(function(event, line) lines[#lines + 1] = line end, "l")
+f()
+debug.sethook()
+assert(table.concat(lines, ",") == "170,171,175", table.concat(lines, ","))
@@ -11,3 +11,12 @@ assert(ret1 == 1)
 assert(ret2 == 3)
 assert(ret3 == "aaa")
 assert(ret4 == 4)
+
+-- string.rep with a separator
+assert(string.rep("ab", 3, ",") == "ab,ab,ab")
+assert(string.rep("ab", 1, ",") == "ab")
+assert(string.rep("ab", 0, ",") == "")
+assert(string.rep("", 3, "-") == "--")
+assert(string.rep("x", 3) == "xxx")
+local ok, msg = pcall(string.rep, "x", 2^62, "yy")
+assert(not ok and string.find(msg, "resulting string too large"))
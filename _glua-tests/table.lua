@@ -35,3 +35,46 @@ for k, v in pairs(a) do
     error("unexpected key:" .. tostring(k))
   end
 end
+
+-- table.sort with a stable sort and failing comparators
+local records = {}
+for i = 1, 50 do
+  records[i] = {key = i % 3, seq = i}
+end
+table.sort(records, function(x, y) return x.key < y.key end, true)
+for i = 2, #records do
+  local x, y = records[i-1], records[i]
+  assert(x.key < y.key or (x.key == y.key and x.seq < y.seq))
+end
+
+a = {5, 3, 1, 4, 2}
+table.sort(a, nil, true)
+assert(table.concat(a, ",") == "1,2,3,4,5")
+
+a = {5, 3, 1, 4, 2}
+local calls = 0
+ok, msg = pcall(table.sort, a, function(x, y)
+  calls = calls + 1
+  if calls == 4 then error("comparator failed") end
+  return x < y
+end)
+assert(not ok and string.find(msg, "comparator failed"))
+assert(table.concat(a, ",") == "5,3,1,4,2")
+
+a = {}
+for i = 1, 100 do a[i] = i % 7 end
+ok, msg = pcall(table.sort, a, function(x, y) return true end)
+assert(not ok and string.find(msg, "invalid order function for sorting"))
+
+ok, msg = pcall(table.sort, {3, "x", 1})
+assert(not ok and string.find(msg, "attempt to compare"))
+
+-- table.concat of long tables
+a = {}
+for i = 1, 100000 do a[i] = i % 10 end
+local s = table.concat(a, ",")
+assert(#s == 100000 * 2 - 1)
+assert(string.sub(s, 1, 7) == "1,2,3,4")
+assert(table.concat(a, "", 99999) == "90")
+ok, msg = pcall(table.concat, {1, {}, 3})
+assert(not ok and string.find(msg, "invalid value %(table%) at index 2 in table for concat"))
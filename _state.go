@@ -1,9 +1,11 @@
 package lua
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"io"
+	"maps"
 	"math"
 	"os"
 	"runtime"
@@ -12,7 +14,7 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/r0kyi/gopher-lua/parse"
+	"github.com/r0kyi/gopher-lua/ast"
 )
 
 const MultRet = -1
@@ -26,12 +28,20 @@ type ApiError struct {
 	Type       ApiErrorType
 	Object     LValue
 	StackTrace string
+	// Frames holds the same traceback as StackTrace in a structured form.
+	Frames []Frame
 	// Underlying error. This attribute is set only if the Type is ApiErrorFile or ApiErrorSyntax
 	Cause error
+	// GoStackTrace is the stack of the goroutine a Go panic has been recovered in, for errors of type
+	// ApiErrorPanic and errors raised by functions wrapped with `SafeGFunction`.
+	GoStackTrace string
+	// threadTrace is true while StackTrace and Frames only cover the coroutine the error has been raised in. The
+	// traceback of the resuming thread is appended by addStackTrace.
+	threadTrace bool
 }
 
 func newApiError(code ApiErrorType, object LValue) *ApiError {
-	return &ApiError{code, object, "", nil}
+	return &ApiError{code, object, "", nil, nil, "", false}
 }
 
 func newApiErrorS(code ApiErrorType, message string) *ApiError {
@@ -39,7 +49,7 @@ func newApiErrorS(code ApiErrorType, message string) *ApiError {
 }
 
 func newApiErrorE(code ApiErrorType, err error) *ApiError {
-	return &ApiError{code, LString(err.Error()), "", err}
+	return &ApiError{code, LString(err.Error()), "", nil, err, "", false}
 }
 
 func (e *ApiError) Error() string {
@@ -49,6 +59,35 @@ func (e *ApiError) Error() string {
 	return e.Object.String()
 }
 
+// addStackTrace sets the traceback of the error to the call stack of L, or appends the call stack of L if the
+// error has been raised in a coroutine resumed by L.
+func (e *ApiError) addStackTrace(L *LState) {
+	switch {
+	case len(e.StackTrace) == 0:
+		e.StackTrace = L.stackTrace(0)
+		e.Frames = L.StackTrace()
+	case e.threadTrace:
+		e.StackTrace = strings.TrimSuffix(e.StackTrace, "\n\t[G]: ?") + "\n" + strings.TrimPrefix(L.stackTrace(0), "stack traceback:\n")
+		e.Frames = append(e.Frames, L.StackTrace()...)
+	}
+	e.threadTrace = false
+}
+
+// Frame is an entry of a stack traceback(see `LState.StackTrace`).
+type Frame struct {
+	// Source is the chunk name of a Lua function, or "[G]" for a Go function.
+	Source string
+	// Line is the line being executed by a Lua function, or 0 if unknown.
+	Line int
+	// FunctionName is the name of the function as shown in tracebacks, e.g. "level4", "<file.lua:10>" or
+	// "main chunk".
+	FunctionName string
+	// IsGo is true if the function is a Go function.
+	IsGo bool
+	// TailCalls is the number of frames that have been replaced by tail calls made from this function.
+	TailCalls int
+}
+
 type ApiErrorType int
 
 const (
@@ -93,7 +132,8 @@ type Options struct {
 	// Data stack size. This defaults to `lua.RegistrySize`.
 	RegistrySize int
 	// Allow the registry to grow from the registry size specified up to a value of RegistryMaxSize. A value of 0
-	// indicates no growth is permitted. The registry will not shrink again after any growth.
+	// indicates no growth is permitted. The registry will not shrink again after any growth unless it is compacted
+	// (see `CompactRegistry` and `RegistryAutoCompact`).
 	RegistryMaxSize int
 	// If growth is enabled, step up by an additional `RegistryGrowStep` each time to avoid having to resize too often.
 	// This defaults to `lua.RegistryGrowStep`
@@ -102,9 +142,144 @@ type Options struct {
 	SkipOpenLibs bool
 	// Tells whether a Go stacktrace should be included in a Lua stacktrace when panics occur.
 	IncludeGoStackTrace bool
+	// If `SafeGFunctions` is set, the functions created by `NewFunction` and `NewClosure`, and so all functions
+	// registered by `Register`, `SetFuncs` and `RegisterModule` including the standard libraries, are wrapped with
+	// `SafeGFunction`. A Go panic in a function then raises a Lua error the calling script can catch.
+	SafeGFunctions bool
+	// `PanicMode` controls how Go panics and overflows of the call stack and the registry are handled. See
+	// `PanicMode` for the available modes.
+	PanicMode PanicMode
+	// `PanicHandler` is called on Go panics and overflows if `PanicMode` is PanicModeHandler.
+	PanicHandler PanicHandler
+	// `OnUnhandledError` is called with the errors scripts do not catch, when they propagate out of the outermost
+	// `PCall`(and DoString, DoFile and CallByParam) or out of a `Call` without any PCall. It is called before the
+	// call stack is unwound, so `GetStack`, `GetLocal` and `StackTrace` inspect the functions the error has been
+	// raised in, e.g. to dump their locals into crash reports. Errors raised by `OnUnhandledError` are ignored.
+	OnUnhandledError func(L *LState, err error)
+	// If `GoroutineCoroutines` is set, coroutines run on goroutines of their own. This allows them to yield from
+	// within Go functions that call back into Lua(e.g. pcall, table.sort comparators and metamethods called from
+	// Go). Resuming and yielding is slower, and a coroutine that is suspended keeps its goroutine until it is
+	// resumed again or the main state is closed.
+	GoroutineCoroutines bool
 	// If `MinimizeStackMemory` is set, the call stack will be automatically grown or shrank up to a limit of
 	// `CallStackSize` in order to minimize memory usage. This does incur a slight performance penalty.
 	MinimizeStackMemory bool
+	// If `RegistryAutoCompact` is set, the registry is compacted(see `CompactRegistry`) whenever a top level call
+	// returns. This releases the values left above the top of the stack, not values Go code pushed and never
+	// popped.
+	RegistryAutoCompact bool
+	// If `CollectStats` is set, allocation counters, live object counters and the time spent in the VM are
+	// collected and reported by `Stats`. This does incur a performance penalty.
+	CollectStats bool
+	// If `Metrics` is set, the calls of Lua functions and the time spent in them are counted and reported by
+	// `LState.Metrics`. This is cheaper than profiling, but still slows down calls.
+	Metrics bool
+	// `Collator` orders strings for <, <=, >, >= and table.sort without a comparison function, e.g. by the rules of
+	// the locale of the application. Strings are compared byte by byte if it is nil.
+	Collator Collator
+	// If `FloatSuffix` is set, every integral number is converted to a string with a ".0" suffix, "3.0" instead of
+	// "3", by tostring, print, the concatenation operator and %s of string.format. GopherLua has no integer
+	// subtype to tell floats from integers like Lua 5.3 does, so the suffix is also added to numbers that are
+	// integers in Lua 5.3, e.g. integer constants, lengths like #t and select('#', ...), and loop counters.
+	FloatSuffix bool
+	// If `OptimizeBytecode` is set, chunks compiled by `Load` and its variants are run through a peephole
+	// optimizer (see `Optimize`). This makes loading slightly slower in exchange for faster execution.
+	OptimizeBytecode bool
+	// If `MaxChunkSize` is greater than 0, `Load` and its variants reject chunks larger than `MaxChunkSize` bytes.
+	MaxChunkSize int
+	// If `MaxNestingDepth` is greater than 0, `Load` and its variants reject chunks where statements and
+	// expressions are nested deeper than `MaxNestingDepth` levels. Set this when compiling untrusted sources, since
+	// the compiler uses Go stack proportional to the nesting depth.
+	MaxNestingDepth int
+	// If `MaxConstants` is greater than 0, `Load` and its variants reject chunks containing a function with more
+	// than `MaxConstants` constants.
+	MaxConstants int
+	// If `MaxStringSize` is greater than 0, string.rep and string.gsub raise an error instead of building strings
+	// longer than `MaxStringSize` bytes.
+	MaxStringSize int
+	// If `MaxGsubExpansion` is greater than 0, string.gsub raises an error instead of returning strings more than
+	// `MaxGsubExpansion` times longer than the subject(plus one byte). string.gsub also respects `MaxStringSize`.
+	MaxGsubExpansion int
+	// If `Declarative` is set, `Load` and its variants reject chunks containing function definitions, loops, gotos,
+	// calls of functions that are not listed in `AllowedCalls` and assignments to fields or to the globals the
+	// allowed calls start at(see `CompileLimits`). This turns Lua into a data description language for
+	// configuration files.
+	Declarative bool
+	// AllowedCalls lists the functions that may be called if `Declarative` is set, e.g. "os.getenv".
+	AllowedCalls []string
+	// If `Checker` is set, `Load` and its variants pass the syntax trees of chunks to it before compiling them and
+	// reject chunks it reports diagnostics for, e.g. to type check annotations(see `Checker`).
+	Checker Checker
+	// If `Trace` is set, it is called before instructions of Lua functions are executed. This is meant for
+	// diagnosing the compiler and the VM and does incur a large performance penalty. See also `NewTraceWriter`.
+	Trace TraceFunc
+	// If `TraceInterval` is greater than 1, `Trace` is only called for every `TraceInterval`-th instruction.
+	TraceInterval int
+	// Hooks are called when functions are called, return, are unwound by errors and yield, e.g. to report the
+	// time spent in functions to a tracing system.
+	Hooks CallHooks
+	// os.clock returns the CPU time used by the process. If `Clock` is set, it returns the time reported by
+	// `Clock` instead, e.g. to meter the CPU time of a single state.
+	Clock func() time.Duration
+	// If `WallClock` is set, os.clock returns the wall clock time elapsed since the program started, as it did in
+	// older versions.
+	WallClock bool
+	// Stdout, Stderr and Stdin replace the standard streams of the process for this state: print, io.write,
+	// io.read, io.stdout, io.stderr, io.stdin, os.execute and loading chunks from stdin use them. Nil values keep
+	// the streams of the process.
+	Stdout io.Writer
+	Stderr io.Writer
+	Stdin  io.Reader
+	// If `Log` is set, messages logged by the log library are passed to it instead of being written to Stderr.
+	Log func(L *LState, rec *LogRecord)
+	// If `PrintToLog` is set, print logs its arguments at LogInfo level like log.info does instead of writing
+	// them to Stdout.
+	PrintToLog bool
+	// If `SortedPairs` is set, next() and pairs() visit the keys of tables in sorted order: numbers, then strings,
+	// then booleans. Tables are otherwise traversed in the order their keys were inserted, after the array part.
+	// Sorting makes traversals slower, this is meant for reproducible output of scripts, e.g. in golden file tests.
+	SortedPairs bool
+	// Host replaces the clock, the random number generator, the environment and the file system the standard
+	// libraries use, e.g. to make tests deterministic or to confine scripts.
+	Host HostInterfaces
+	// If `Environ` is not nil, os.getenv only sees the environment variables in `Environ` instead of the environment
+	// of the process, e.g. a few whitelisted variables or a synthetic environment. The map is copied by NewState,
+	// os.setenv changes the copy. `HostInterfaces.Getenv` and `HostInterfaces.Setenv` can look variables up
+	// dynamically instead.
+	Environ map[string]string
+	// If `Policy` is set, it is asked before scripts open files, start processes, load chunks and perform other
+	// sensitive operations, and can deny them.
+	Policy Policy
+	// `Audit` is called when `Policy` denies an operation and when the state exceeds a quota, e.g. to monitor
+	// scripts of tenants. It is called before the error is raised.
+	Audit func(L *LState, ev *AuditEvent)
+	// Every state has a random number generator of its own for math.random. If `RandomSeed` is set, the generator
+	// is seeded like math.randomseed(RandomSeed[0], RandomSeed[1]) is called, otherwise with a random seed.
+	RandomSeed []int64
+}
+
+// stdout returns the standard output of the state(see `Options.Stdout`).
+func (ls *LState) stdout() io.Writer {
+	if ls.Options.Stdout != nil {
+		return ls.Options.Stdout
+	}
+	return os.Stdout
+}
+
+// stderr returns the standard error of the state(see `Options.Stderr`).
+func (ls *LState) stderr() io.Writer {
+	if ls.Options.Stderr != nil {
+		return ls.Options.Stderr
+	}
+	return os.Stderr
+}
+
+// stdin returns the standard input of the state(see `Options.Stdin`).
+func (ls *LState) stdin() io.Reader {
+	if ls.Options.Stdin != nil {
+		return ls.Options.Stdin
+	}
+	return os.Stdin
 }
 
 /* }}} */
@@ -151,12 +326,29 @@ type callFrameStack interface {
 	IsFull() bool
 	IsEmpty() bool
 
+	Stats() CallStackStats
+	ResetHighWaterMark()
+
 	FreeAll()
 }
 
+// CallStackStats describes the usage of the call stack of an LState.
+type CallStackStats struct {
+	// Depth is the number of frames currently on the stack.
+	Depth int
+	// HighWaterMark is the deepest the stack has been since the LState was created or since the last call to
+	// ResetCallStackHighWaterMark.
+	HighWaterMark int
+	// Allocated is the number of frames the stack currently holds memory for.
+	Allocated int
+	// Max is the maximum number of frames the stack can hold.
+	Max int
+}
+
 type fixedCallFrameStack struct {
 	array []callFrame
 	sp    int
+	hwm   int
 }
 
 func newFixedCallFrameStack(size int) callFrameStack {
@@ -178,6 +370,9 @@ func (cs *fixedCallFrameStack) Push(v callFrame) {
 	cs.array[cs.sp] = v
 	cs.array[cs.sp].Idx = cs.sp
 	cs.sp++
+	if cs.sp > cs.hwm {
+		cs.hwm = cs.sp
+	}
 }
 
 func (cs *fixedCallFrameStack) Sp() int {
@@ -204,6 +399,14 @@ func (cs *fixedCallFrameStack) Pop() *callFrame {
 	return &cs.array[cs.sp]
 }
 
+func (cs *fixedCallFrameStack) Stats() CallStackStats {
+	return CallStackStats{Depth: cs.sp, HighWaterMark: cs.hwm, Allocated: len(cs.array), Max: len(cs.array)}
+}
+
+func (cs *fixedCallFrameStack) ResetHighWaterMark() {
+	cs.hwm = cs.sp
+}
+
 func (cs *fixedCallFrameStack) FreeAll() {
 	// nothing to do for fixed callframestack
 }
@@ -224,6 +427,8 @@ type autoGrowingCallFrameStack struct {
 	// It points to the next stack slot to use, so 0 means to use the 0th element in the segment, and a value of
 	// FramesPerSegment indicates that the segment is full and cannot accommodate another frame.
 	segSp uint8
+	// hwm is the high water mark of the stack depth.
+	hwm int
 }
 
 var segmentPool sync.Pool
@@ -237,6 +442,8 @@ func newCallFrameStackSegment() *callFrameStackSegment {
 }
 
 func freeCallFrameStackSegment(seg *callFrameStackSegment) {
+	// drop references to functions and frames so that pooled segments do not keep them alive
+	*seg = callFrameStackSegment{}
 	segmentPool.Put(seg)
 }
 
@@ -262,16 +469,22 @@ func (cs *autoGrowingCallFrameStack) IsFull() bool {
 }
 
 func (cs *autoGrowingCallFrameStack) Clear() {
-	for i := segIdx(1); i <= cs.segIdx; i++ {
-		freeCallFrameStackSegment(cs.segments[i])
-		cs.segments[i] = nil
-	}
+	cs.freeSegmentsAbove(0)
 	cs.segIdx = 0
 	cs.segSp = 0
 }
 
 func (cs *autoGrowingCallFrameStack) FreeAll() {
-	for i := segIdx(0); i <= cs.segIdx; i++ {
+	cs.freeSegmentsAbove(0)
+	if cs.segments[0] != nil {
+		freeCallFrameStackSegment(cs.segments[0])
+		cs.segments[0] = nil
+	}
+}
+
+// freeSegmentsAbove frees all segments(including the spare one) above the segment idx.
+func (cs *autoGrowingCallFrameStack) freeSegmentsAbove(idx segIdx) {
+	for i := int(idx) + 1; i < len(cs.segments) && cs.segments[i] != nil; i++ {
 		freeCallFrameStackSegment(cs.segments[i])
 		cs.segments[i] = nil
 	}
@@ -282,19 +495,26 @@ func (cs *autoGrowingCallFrameStack) FreeAll() {
 func (cs *autoGrowingCallFrameStack) Push(v callFrame) {
 	curSeg := cs.segments[cs.segIdx]
 	if cs.segSp >= FramesPerSegment {
-		// segment full, push new segment if allowed
+		// segment full, push new segment(or reuse the spare one) if allowed
 		if cs.segIdx < segIdx(len(cs.segments)-1) {
-			curSeg = newCallFrameStackSegment()
 			cs.segIdx++
-			cs.segments[cs.segIdx] = curSeg
+			curSeg = cs.segments[cs.segIdx]
+			if curSeg == nil {
+				curSeg = newCallFrameStackSegment()
+				cs.segments[cs.segIdx] = curSeg
+			}
 			cs.segSp = 0
 		} else {
 			panic("lua callstack overflow")
 		}
 	}
 	curSeg.array[cs.segSp] = v
-	curSeg.array[cs.segSp].Idx = int(cs.segSp) + FramesPerSegment*int(cs.segIdx)
+	sp := int(cs.segSp) + FramesPerSegment*int(cs.segIdx)
+	curSeg.array[cs.segSp].Idx = sp
 	cs.segSp++
+	if sp >= cs.hwm {
+		cs.hwm = sp + 1
+	}
 }
 
 // Sp retrieves the current stack depth, which is the number of frames currently pushed on the stack.
@@ -307,13 +527,10 @@ func (cs *autoGrowingCallFrameStack) Sp() int {
 func (cs *autoGrowingCallFrameStack) SetSp(sp int) {
 	desiredSegIdx := segIdx(sp / FramesPerSegment)
 	desiredFramesInLastSeg := uint8(sp % FramesPerSegment)
-	for {
-		if cs.segIdx <= desiredSegIdx {
-			break
-		}
-		freeCallFrameStackSegment(cs.segments[cs.segIdx])
-		cs.segments[cs.segIdx] = nil
-		cs.segIdx--
+	if cs.segIdx > desiredSegIdx {
+		// keep the segment right above the new top as a spare
+		cs.freeSegmentsAbove(desiredSegIdx + 1)
+		cs.segIdx = desiredSegIdx
 	}
 	cs.segSp = desiredFramesInLastSeg
 }
@@ -345,8 +562,9 @@ func (cs *autoGrowingCallFrameStack) Pop() *callFrame {
 			// stack empty
 			return nil
 		}
-		freeCallFrameStackSegment(curSeg)
-		cs.segments[cs.segIdx] = nil
+		// keep the segment we are leaving as a spare, so that calls repeatedly crossing a segment boundary do
+		// not churn segments, but free everything above it
+		cs.freeSegmentsAbove(cs.segIdx)
 		cs.segIdx--
 		cs.segSp = FramesPerSegment
 		curSeg = cs.segments[cs.segIdx]
@@ -355,6 +573,26 @@ func (cs *autoGrowingCallFrameStack) Pop() *callFrame {
 	return &curSeg.array[cs.segSp]
 }
 
+func (cs *autoGrowingCallFrameStack) Stats() CallStackStats {
+	allocated := 0
+	for _, seg := range cs.segments {
+		if seg == nil {
+			break
+		}
+		allocated += FramesPerSegment
+	}
+	return CallStackStats{
+		Depth:         cs.Sp(),
+		HighWaterMark: cs.hwm,
+		Allocated:     allocated,
+		Max:           len(cs.segments) * FramesPerSegment,
+	}
+}
+
+func (cs *autoGrowingCallFrameStack) ResetHighWaterMark() {
+	cs.hwm = cs.Sp()
+}
+
 /* }}} */
 
 /* registry {{{ */
@@ -369,10 +607,20 @@ type registry struct {
 	maxSize int
 	alloc   *allocator
 	handler registryHandler
+
+	initialSize int
+	grows       int
 }
 
 func newRegistry(handler registryHandler, initialSize int, growBy int, maxSize int, alloc *allocator) *registry {
-	return &registry{make([]LValue, initialSize), 0, growBy, maxSize, alloc, handler}
+	return &registry{
+		array:       make([]LValue, initialSize),
+		growBy:      growBy,
+		maxSize:     maxSize,
+		alloc:       alloc,
+		handler:     handler,
+		initialSize: initialSize,
+	}
 }
 
 func (rg *registry) checkSize(requiredSize int) { // +inline-start
@@ -396,9 +644,35 @@ func (rg *registry) resize(requiredSize int) { // +inline-start
 func (rg *registry) forceResize(newSize int) {
 	newSlice := make([]LValue, newSize)
 	copy(newSlice, rg.array[:rg.top]) // should we copy the area beyond top? there shouldn't be any valid values there so it shouldn't be necessary.
+	if newSize > len(rg.array) {
+		rg.grows++
+	}
 	rg.array = newSlice
 }
 
+// stale returns the number of slots above top that still reference a value.
+func (rg *registry) stale() int {
+	n := 0
+	for _, v := range rg.array[rg.top:] {
+		if v != nil && v != LNil {
+			n++
+		}
+	}
+	return n
+}
+
+// compact drops references held by slots above top and shrinks a grown registry back towards its initial size.
+func (rg *registry) compact() {
+	clear(rg.array[rg.top:])
+	size := rg.top + rg.growBy
+	if size < rg.initialSize {
+		size = rg.initialSize
+	}
+	if size < len(rg.array) {
+		rg.forceResize(size)
+	}
+}
+
 func (rg *registry) SetTop(topi int) { // +inline-start
 	// +inline-call rg.checkSize topi
 	oldtopi := rg.top
@@ -542,7 +816,7 @@ func newGlobal() *Global {
 		Registry:   newLTable(0, 32),
 		Global:     newLTable(0, 64),
 		builtinMts: make(map[int]LValue),
-		tempFiles:  make([]*os.File, 0, 10),
+		tempFiles:  make([]tempFile, 0, 10),
 	}
 }
 
@@ -553,11 +827,15 @@ func newGlobal() *Global {
 func panicWithTraceback(L *LState) {
 	err := newApiError(ApiErrorRun, L.Get(-1))
 	err.StackTrace = L.stackTrace(0)
+	err.Frames = L.StackTrace()
+	err.GoStackTrace, L.goPanicStack = L.goPanicStack, ""
+	L.unhandledError(err)
 	panic(err)
 }
 
 func panicWithoutTraceback(L *LState) {
 	err := newApiError(ApiErrorRun, L.Get(-1))
+	err.GoStackTrace, L.goPanicStack = L.goPanicStack, ""
 	panic(err)
 }
 
@@ -576,9 +854,13 @@ func newLState(options Options) *LState {
 		wrapped:      false,
 		uvcache:      nil,
 		hasErrorFunc: false,
+		hooks:        newHookState(&options),
 		mainLoop:     mainLoop,
 		ctx:          nil,
 	}
+	if options.Trace != nil {
+		ls.mainLoop = mainLoopWithContext
+	}
 	if options.MinimizeStackMemory {
 		ls.stack = newAutoGrowingCallFrameStack(options.CallStackSize)
 	} else {
@@ -586,6 +868,14 @@ func newLState(options Options) *LState {
 	}
 	ls.reg = newRegistry(ls, options.RegistrySize, options.RegistryGrowStep, options.RegistryMaxSize, al)
 	ls.Env = ls.G.Global
+	ls.G.random = newRandom(options.RandomSeed)
+	if options.Environ != nil {
+		ls.G.environ = maps.Clone(options.Environ)
+	}
+	if options.CollectStats {
+		ls.G.stats = &vmStats{}
+		al.stats = ls.G.stats
+	}
 	return ls
 }
 
@@ -686,39 +976,71 @@ func (ls *LState) where(level int, skipg bool) string {
 		return ls.where(level+1, skipg)
 	}
 	line := ""
-	if proto != nil {
+	if proto != nil && cf.Pc > 0 {
 		line = fmt.Sprintf("%v:", proto.DbgSourcePositions[cf.Pc-1])
 	}
 	return fmt.Sprintf("%v:%v", sourcename, line)
 }
 
+// The number of levels at the top and at the bottom of long stack tracebacks, like C Lua.
+const (
+	tracebackTopLevels    = 12
+	tracebackBottomLevels = 10
+)
+
 func (ls *LState) stackTrace(level int) string {
-	buf := []string{}
-	header := "stack traceback:"
+	levels := []string{}
 	if ls.currentFrame != nil {
 		i := 0
 		for dbg, ok := ls.GetStack(i); ok; dbg, ok = ls.GetStack(i) {
 			cf := dbg.frame
-			buf = append(buf, fmt.Sprintf("\t%v in %v", ls.Where(i), ls.formattedFrameFuncName(cf)))
+			levels = append(levels, fmt.Sprintf("\t%v in %v", ls.Where(i), ls.formattedFrameFuncName(cf)))
 			if !cf.Fn.IsG && cf.TailCall > 0 {
 				for tc := cf.TailCall; tc > 0; tc-- {
-					buf = append(buf, "\t(tailcall): ?")
+					levels = append(levels, "\t(tail call): ?")
 					i++
 				}
 			}
 			i++
 		}
 	}
-	buf = append(buf, fmt.Sprintf("\t%v: %v", "[G]", "?"))
-	buf = buf[intMax(0, intMin(level, len(buf))):len(buf)]
-	if len(buf) > 20 {
-		newbuf := make([]string, 0, 20)
-		newbuf = append(newbuf, buf[0:7]...)
-		newbuf = append(newbuf, "\t...")
-		newbuf = append(newbuf, buf[len(buf)-7:len(buf)]...)
-		buf = newbuf
+	levels = append(levels, fmt.Sprintf("\t%v: %v", "[G]", "?"))
+	buf := []string{"stack traceback:"}
+	for lv := intMax(0, level); lv < len(levels); lv++ {
+		if lv == tracebackTopLevels && lv+tracebackBottomLevels+1 < len(levels) {
+			// too many levels, only the last ones are shown
+			buf = append(buf, "\t...")
+			lv = len(levels) - tracebackBottomLevels - 1
+			continue
+		}
+		buf = append(buf, levels[lv])
 	}
-	return fmt.Sprintf("%s\n%s", header, strings.Join(buf, "\n"))
+	return strings.Join(buf, "\n")
+}
+
+// StackTrace returns the call stack of this state, innermost function first.
+func (ls *LState) StackTrace() []Frame {
+	frames := []Frame{}
+	for cf := ls.currentFrame; cf != nil; cf = cf.Parent {
+		frames = append(frames, ls.frameOf(cf))
+	}
+	return frames
+}
+
+func (ls *LState) frameOf(cf *callFrame) Frame {
+	frame := Frame{
+		Source:       "[G]",
+		FunctionName: ls.rawFrameFuncName(cf),
+		IsGo:         cf.Fn.IsG,
+	}
+	if proto := cf.Fn.Proto; proto != nil {
+		frame.Source = proto.SourceName
+		if cf.Pc > 0 && cf.Pc <= len(proto.DbgSourcePositions) {
+			frame.Line = proto.DbgSourcePositions[cf.Pc-1]
+		}
+		frame.TailCalls = cf.TailCall
+	}
+	return frame
 }
 
 func (ls *LState) formattedFrameFuncName(fr *callFrame) string {
@@ -753,6 +1075,8 @@ func (ls *LState) frameFuncName(fr *callFrame) (string, bool) {
 				name := call.Name
 				if (name == "?" || fr.TailCall > 0) && !fr.Fn.IsG {
 					name = fmt.Sprintf("<%v:%v>", fr.Fn.Proto.SourceName, fr.Fn.Proto.LineDefined)
+				} else if gname := fr.Fn.goFunctionName(); name == "?" && gname != "" {
+					name = gname
 				}
 				return name, false
 			}
@@ -761,6 +1085,9 @@ func (ls *LState) frameFuncName(fr *callFrame) (string, bool) {
 	if !fr.Fn.IsG {
 		return fmt.Sprintf("<%v:%v>", fr.Fn.Proto.SourceName, fr.Fn.Proto.LineDefined), false
 	}
+	if gname := fr.Fn.goFunctionName(); gname != "" {
+		return gname, false
+	}
 	return "(anonymous)", false
 }
 
@@ -888,7 +1215,7 @@ func (ls *LState) metatable(lvalue LValue, rawget bool) LValue {
 	if !rawget && metatable != LNil {
 		oldmt := metatable
 		if tb, ok := metatable.(*LTable); ok {
-			metatable = tb.RawGetString("__metatable")
+			metatable = tb.metaField("__metatable")
 			if metatable == LNil {
 				metatable = oldmt
 			}
@@ -901,7 +1228,7 @@ func (ls *LState) metatable(lvalue LValue, rawget bool) LValue {
 func (ls *LState) metaOp1(lvalue LValue, event string) LValue {
 	if mt := ls.metatable(lvalue, true); mt != LNil {
 		if tb, ok := mt.(*LTable); ok {
-			return tb.RawGetString(event)
+			return tb.metaField(event)
 		}
 	}
 	return LNil
@@ -910,14 +1237,14 @@ func (ls *LState) metaOp1(lvalue LValue, event string) LValue {
 func (ls *LState) metaOp2(value1, value2 LValue, event string) LValue {
 	if mt := ls.metatable(value1, true); mt != LNil {
 		if tb, ok := mt.(*LTable); ok {
-			if ret := tb.RawGetString(event); ret != LNil {
+			if ret := tb.metaField(event); ret != LNil {
 				return ret
 			}
 		}
 	}
 	if mt := ls.metatable(value2, true); mt != LNil {
 		if tb, ok := mt.(*LTable); ok {
-			return tb.RawGetString(event)
+			return tb.metaField(event)
 		}
 	}
 	return LNil
@@ -927,6 +1254,9 @@ func (ls *LState) metaCall(lvalue LValue) (*LFunction, bool) {
 	if fn, ok := lvalue.(*LFunction); ok {
 		return fn, false
 	}
+	if lo, ok := lvalue.(*LObject); ok {
+		return lo.callFunction(ls), false
+	}
 	if fn, ok := ls.metaOp1(lvalue, "__call").(*LFunction); ok {
 		return fn, true
 	}
@@ -1019,18 +1349,24 @@ func (ls *LState) pushCallFrame(cf callFrame, fn LValue, meta bool) { // +inline
 		ls.reg.Insert(fn, cf.LocalBase)
 	}
 	if cf.Fn == nil {
-		ls.RaiseError("attempt to call a non-function object")
+		ls.RaiseError("attempt to call a non-function object%s", ls.varInfo(fn))
 	}
 	if ls.stack.IsFull() {
-		ls.RaiseError("stack overflow")
+		ls.internalError("stack overflow")
 	}
 	ls.stack.Push(cf)
 	newcf := ls.stack.Last()
 	// +inline-call ls.initCallFrame newcf
 	ls.currentFrame = newcf
+	if ls.hooks != nil {
+		ls.hookCall(newcf)
+	}
 } // +inline-end
 
 func (ls *LState) callR(nargs, nret, rbase int) {
+	if ls.G.stats != nil && ls.stack.IsEmpty() {
+		defer ls.G.stats.addVMTime(time.Now())
+	}
 	base := ls.reg.Top() - nargs - 1
 	if rbase < 0 {
 		rbase = base
@@ -1058,12 +1394,18 @@ func (ls *LState) callR(nargs, nret, rbase int) {
 	if nret != MultRet {
 		ls.reg.SetTop(rbase + nret)
 	}
+	if ls.Options.RegistryAutoCompact && ls.stack.IsEmpty() {
+		ls.reg.compact()
+	}
 }
 
 func (ls *LState) getField(obj LValue, key LValue) LValue {
 	curobj := obj
 	for i := 0; i < MaxTableGetLoop; i++ {
 		tb, istable := curobj.(*LTable)
+		if !istable && curobj.Type() == LTObject {
+			return ls.indexObject(curobj, key)
+		}
 		if istable {
 			ret := tb.RawGet(key)
 			if ret != LNil {
@@ -1073,7 +1415,7 @@ func (ls *LState) getField(obj LValue, key LValue) LValue {
 		metaindex := ls.metaOp1(curobj, "__index")
 		if metaindex == LNil {
 			if !istable {
-				ls.RaiseError("attempt to index a non-table object(%v) with key '%s'", curobj.Type().String(), key.String())
+				ls.RaiseError("attempt to index a non-table object(%v) with key '%s'%s", curobj.Type().String(), key.String(), ls.varInfo(curobj))
 			}
 			return LNil
 		}
@@ -1094,6 +1436,14 @@ func (ls *LState) getField(obj LValue, key LValue) LValue {
 func (ls *LState) getFieldString(obj LValue, key string) LValue {
 	curobj := obj
 	for i := 0; i < MaxTableGetLoop; i++ {
+		if curobj.Type() == LTObject {
+			return curobj.Index(ls, key)
+		}
+		ret := obj.Index(ls, key)
+		if ret != LNil {
+			return ret
+		}
+
 		tb, istable := curobj.(*LTable)
 		if istable {
 			ret := tb.RawGetString(key)
@@ -1104,7 +1454,7 @@ func (ls *LState) getFieldString(obj LValue, key string) LValue {
 		metaindex := ls.metaOp1(curobj, "__index")
 		if metaindex == LNil {
 			if !istable {
-				ls.RaiseError("attempt to index a non-table object(%v) with key '%s'", curobj.Type().String(), key)
+				ls.RaiseError("attempt to index a non-table object(%v) with key '%s'%s", curobj.Type().String(), key, ls.varInfo(curobj))
 			}
 			return LNil
 		}
@@ -1122,10 +1472,61 @@ func (ls *LState) getFieldString(obj LValue, key string) LValue {
 	return nil
 }
 
+// getFieldStringCached behaves like getFieldString, but consults the inline cache
+// of the instruction at pc in fn first. Raw hits on tables are cached, and so are
+// hits in the __index table of the metatable of a table, userdata or string, which
+// is where the methods called by obj:method() usually live.
+func (ls *LState) getFieldStringCached(fn *LFunction, pc int, obj LValue, key string) LValue {
+	slots := fn.Proto.inlineCacheSlots
+	if pc >= len(slots) || slots[pc] < 0 {
+		return ls.getFieldString(obj, key)
+	}
+	if fn.inlineCaches == nil {
+		fn.inlineCaches = make([]inlineCache, fn.Proto.numInlineCaches)
+	}
+	ic := &fn.inlineCaches[slots[pc]]
+	var mt LValue
+	switch o := obj.(type) {
+	case *LTable:
+		if ic.meta == nil && ic.table == o && ic.version == o.version {
+			return ic.value
+		}
+		if v := o.hash.getString(key); v != LNil {
+			*ic = inlineCache{table: o, version: o.version, value: v}
+			return v
+		}
+		mt = o.Metatable
+	case *LUserData:
+		mt = o.Metatable
+	case LString:
+		mt = ls.G.builtinMts[int(LTString)]
+	default:
+		return ls.getFieldString(obj, key)
+	}
+	meta, ok := mt.(*LTable)
+	if !ok {
+		return ls.getFieldString(obj, key)
+	}
+	if ic.meta == meta && ic.metaVersion == meta.version && ic.version == ic.table.version {
+		return ic.value
+	}
+	if index, ok := meta.metaField("__index").(*LTable); ok {
+		if v := index.hash.getString(key); v != LNil {
+			*ic = inlineCache{table: index, version: index.version, value: v, meta: meta, metaVersion: meta.version}
+			return v
+		}
+	}
+	return ls.getFieldString(obj, key)
+}
+
 func (ls *LState) setField(obj LValue, key LValue, value LValue) {
 	curobj := obj
 	for i := 0; i < MaxTableGetLoop; i++ {
 		tb, istable := curobj.(*LTable)
+		if !istable && curobj.Type() == LTObject {
+			ls.setIndexObject(curobj, key, value)
+			return
+		}
 		if istable {
 			if tb.RawGet(key) != LNil {
 				ls.RawSet(tb, key, value)
@@ -1135,7 +1536,7 @@ func (ls *LState) setField(obj LValue, key LValue, value LValue) {
 		metaindex := ls.metaOp1(curobj, "__newindex")
 		if metaindex == LNil {
 			if !istable {
-				ls.RaiseError("attempt to index a non-table object(%v) with key '%s'", curobj.Type().String(), key.String())
+				ls.RaiseError("attempt to index a non-table object(%v) with key '%s'%s", curobj.Type().String(), key.String(), ls.varInfo(curobj))
 			}
 			ls.RawSet(tb, key, value)
 			return
@@ -1158,6 +1559,10 @@ func (ls *LState) setFieldString(obj LValue, key string, value LValue) {
 	curobj := obj
 	for i := 0; i < MaxTableGetLoop; i++ {
 		tb, istable := curobj.(*LTable)
+		if !istable && curobj.Type() == LTObject {
+			curobj.SetIndex(ls, key, value)
+			return
+		}
 		if istable {
 			if tb.RawGetString(key) != LNil {
 				tb.RawSetString(key, value)
@@ -1167,7 +1572,7 @@ func (ls *LState) setFieldString(obj LValue, key string, value LValue) {
 		metaindex := ls.metaOp1(curobj, "__newindex")
 		if metaindex == LNil {
 			if !istable {
-				ls.RaiseError("attempt to index a non-table object(%v) with key '%s'", curobj.Type().String(), key)
+				ls.RaiseError("attempt to index a non-table object(%v) with key '%s'%s", curobj.Type().String(), key, ls.varInfo(curobj))
 			}
 			tb.RawSetString(key, value)
 			return
@@ -1221,21 +1626,89 @@ func NewState(opts ...Options) *LState {
 	return ls
 }
 
+// IsClosed reports whether Close has been called on this state.
 func (ls *LState) IsClosed() bool {
-	return ls.stack == nil
+	return atomic.LoadInt32(&ls.closed) != 0
 }
 
+// Close releases the resources of this state. Closing the main thread first calls the __close and __gc
+// metamethods of the tables and userdata given a metatable with these fields that have not been finalized yet, in
+// the reverse order the metatables were set, then stops suspended coroutines, flushes buffered files and removes the files created by io.tmpfile.
+// Closing a thread cancels the context it was created with by `LState.NewThread`.
+//
+// Close may be called more than once and from any goroutine; only the first call has an effect. A script still
+// running on the state must be stopped first, e.g. by cancelling its context.
 func (ls *LState) Close() {
+	if !atomic.CompareAndSwapInt32(&ls.closed, 0, 1) {
+		return
+	}
+	if ls.G.MainThread == ls {
+		ls.RemoveContext()
+		ls.runFinalizers()
+	}
 	atomic.AddInt32(&ls.stop, 1)
-	for _, file := range ls.G.tempFiles {
-		// ignore errors in these operations
-		file.Close()
-		os.Remove(file.Name())
+	if ls.G.MainThread == ls {
+		ls.G.killGoroutineThreads()
+		ls.G.flushBufferedFiles()
+		ls.RemoveTempFiles() // ignore errors
+	}
+	ls.killGoroutine()
+	if ls.ctxCancelFn != nil {
+		ls.ctxCancelFn()
 	}
 	ls.stack.FreeAll()
 	ls.stack = nil
 }
 
+// CallStackStats returns the current usage of the call stack. With `MinimizeStackMemory` set, the call stack
+// releases its memory again when calls return, so Allocated follows Depth rather than HighWaterMark.
+func (ls *LState) CallStackStats() CallStackStats {
+	return ls.stack.Stats()
+}
+
+// ResetCallStackHighWaterMark resets the high water mark reported by CallStackStats to the current depth.
+func (ls *LState) ResetCallStackHighWaterMark() {
+	ls.stack.ResetHighWaterMark()
+}
+
+// RegistryStats describes the usage of the registry(the data stack) of an LState.
+type RegistryStats struct {
+	// Top is the number of slots currently in use.
+	Top int
+	// Allocated is the number of slots the registry currently holds memory for.
+	Allocated int
+	// Max is the maximum number of slots the registry can grow to.
+	Max int
+	// Grows is the number of times the registry had to grow.
+	Grows int
+	// Stale is the number of slots above Top that still reference a value and keep it from being collected.
+	Stale int
+}
+
+// RegistryStats returns the current usage of the registry. A Top that keeps increasing between top level calls
+// usually means that Go code pushes values without popping them.
+func (ls *LState) RegistryStats() RegistryStats {
+	rg := ls.reg
+	return RegistryStats{
+		Top:       rg.top,
+		Allocated: len(rg.array),
+		Max:       max(rg.maxSize, rg.initialSize),
+		Grows:     rg.grows,
+		Stale:     rg.stale(),
+	}
+}
+
+// CompactRegistry releases the values left in the registry slots above the top of the stack and shrinks a grown
+// registry back towards its initial size. Values below the top are kept, including values Go code pushed
+// without popping them: they can not be told from values the caller still uses, so they are only reclaimed by
+// popping them. It must not be called while a Lua function is running.
+func (ls *LState) CompactRegistry() {
+	if !ls.stack.IsEmpty() {
+		ls.RaiseError("can not compact the registry while a function is running")
+	}
+	ls.reg.compact()
+}
+
 /* registry operations {{{ */
 
 func (ls *LState) GetTop() int {
@@ -1340,6 +1813,12 @@ func (ls *LState) Push(value LValue) {
 	ls.reg.Push(value)
 }
 
+// PushNumber pushes the number n onto the stack. Unlike Push(n), it does not allocate for every number(see
+// `allocator.LNumber2I`), so Go functions returning numbers should use it.
+func (ls *LState) PushNumber(n LNumber) {
+	ls.reg.SetNumber(ls.reg.Top(), n)
+}
+
 func (ls *LState) Pop(n int) {
 	for i := 0; i < n; i++ {
 		if ls.GetTop() == 0 {
@@ -1389,19 +1868,48 @@ func (ls *LState) Remove(index int) {
 /* object allocation {{{ */
 
 func (ls *LState) NewTable() *LTable {
-	return newLTable(defaultArrayCap, defaultHashCap)
+	tb := newLTable(defaultArrayCap, defaultHashCap)
+	if ls.G.stats != nil {
+		ls.G.stats.newTable(tb)
+	}
+	return tb
 }
 
 func (ls *LState) CreateTable(acap, hcap int) *LTable {
-	return newLTable(acap, hcap)
+	tb := newLTable(acap, hcap)
+	if ls.G.stats != nil {
+		ls.G.stats.newTable(tb)
+	}
+	return tb
 }
 
 // NewThread returns a new LState that shares with the original state all global objects.
 // If the original state has context.Context, the new state has a new child context of the original state and this function returns its cancel function.
+//
+// Threads are cheap: they share the number allocator of the original state, start with a registry of
+// `CoroutineRegistrySize` slots and a single call stack segment, and grow both on demand up to the limits of the
+// original state. Like the original state, a thread must not be used concurrently with any other state sharing
+// its globals.
 func (ls *LState) NewThread() (*LState, context.CancelFunc) {
-	thread := newLState(ls.Options)
-	thread.G = ls.G
-	thread.Env = ls.Env
+	thread := &LState{
+		G:        ls.G,
+		Env:      ls.Env,
+		Panic:    panicWithTraceback,
+		Options:  ls.Options,
+		alloc:    ls.alloc,
+		mainLoop: mainLoop,
+		hooks:    newHookState(&ls.Options),
+	}
+	if ls.Options.Trace != nil {
+		thread.mainLoop = mainLoopWithContext
+	}
+	thread.stack = newAutoGrowingCallFrameStack(ls.Options.CallStackSize)
+	maxSize := intMax(ls.Options.RegistrySize, ls.Options.RegistryMaxSize)
+	size := intMin(CoroutineRegistrySize, maxSize)
+	thread.reg = newRegistry(thread, size, intMax(ls.Options.RegistryGrowStep, size), maxSize, ls.alloc)
+	if ls.G.stats != nil {
+		ls.G.stats.newThread()
+	}
 	var f context.CancelFunc = nil
 	if ls.ctx != nil {
 		thread.mainLoop = mainLoopWithContext
@@ -1412,21 +1920,40 @@ func (ls *LState) NewThread() (*LState, context.CancelFunc) {
 }
 
 func (ls *LState) NewFunctionFromProto(proto *FunctionProto) *LFunction {
+	if ls.G.stats != nil {
+		ls.G.stats.newFunction()
+	}
 	return newLFunctionL(proto, ls.Env, int(proto.NumUpvalues))
 }
 
 func (ls *LState) NewUserData() *LUserData {
-	return &LUserData{
+	ud := &LUserData{
 		Env:       ls.currentEnv(),
 		Metatable: LNil,
 	}
+	if ls.G.stats != nil {
+		ls.G.stats.newUserData(ud)
+	}
+	return ud
 }
 
 func (ls *LState) NewFunction(fn LGFunction) *LFunction {
+	if ls.G.stats != nil {
+		ls.G.stats.newFunction()
+	}
+	if ls.Options.SafeGFunctions {
+		fn = SafeGFunction(fn)
+	}
 	return newLFunctionG(fn, ls.currentEnv(), 0)
 }
 
 func (ls *LState) NewClosure(fn LGFunction, upvalues ...LValue) *LFunction {
+	if ls.G.stats != nil {
+		ls.G.stats.newFunction()
+	}
+	if ls.Options.SafeGFunctions {
+		fn = SafeGFunction(fn)
+	}
 	cl := newLFunctionG(fn, ls.currentEnv(), len(upvalues))
 	for i, lv := range upvalues {
 		cl.Upvalues[i] = &Upvalue{}
@@ -1509,7 +2036,56 @@ func (ls *LState) ToThread(n int) *LState {
 /* error & debug operations {{{ */
 
 func (ls *LState) registryOverflow() {
-	ls.RaiseError("registry overflow")
+	ls.internalError("registry overflow")
+}
+
+// varInfo describes the variable that holds v in the instruction that is being
+// executed, e.g. " (global 'config')". It returns an empty string if the
+// current function is not a Lua function or nothing is known about v.
+func (ls *LState) varInfo(v LValue) string {
+	cf := ls.currentFrame
+	if cf == nil || cf.Fn == nil || cf.Fn.IsG || cf.Pc < 1 || cf.Pc > len(cf.Fn.Proto.Code) {
+		return ""
+	}
+	pc := cf.Pc - 1
+	inst := cf.Fn.Proto.Code[pc]
+	a, b, c := opGetArgA(inst), opGetArgB(inst), opGetArgC(inst)
+	regs := make([]int, 0, 2)
+	switch opGetOpCode(inst) {
+	case OP_GETTABLE, OP_GETTABLEKS, OP_SELF, OP_UNM, OP_LEN:
+		regs = append(regs, b)
+	case OP_SELFCALL:
+		if ls.reg.Get(cf.LocalBase+b) != v {
+			// the method is called by the CALL that follows
+			regs = append(regs, a)
+			pc++
+		} else {
+			regs = append(regs, b)
+		}
+	case OP_SETTABLE, OP_SETTABLEKS, OP_CALL, OP_TAILCALL, OP_SELECTVA:
+		regs = append(regs, a)
+	case OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_POW:
+		if !opIsK(b) {
+			regs = append(regs, b)
+		}
+		if !opIsK(c) {
+			regs = append(regs, c)
+		}
+	case OP_CONCAT:
+		for r := b; r <= c; r++ {
+			regs = append(regs, r)
+		}
+	}
+	for _, r := range regs {
+		if ls.reg.Get(cf.LocalBase+r) != v {
+			continue
+		}
+		if kind, name, ok := cf.Fn.VariableName(r, pc); ok {
+			return fmt.Sprintf(" (%s '%s')", kind, name)
+		}
+		break
+	}
+	return ""
 }
 
 // This function is equivalent to luaL_error( http://www.lua.org/manual/5.1/manual.html#luaL_error ).
@@ -1520,7 +2096,7 @@ func (ls *LState) RaiseError(format string, args ...interface{}) {
 // This function is equivalent to lua_error( http://www.lua.org/manual/5.1/manual.html#lua_error ).
 func (ls *LState) Error(lv LValue, level int) {
 	if str, ok := lv.(LString); ok {
-		ls.raiseError(level, string(str))
+		ls.raiseError(level, "%s", string(str))
 	} else {
 		if !ls.hasErrorFunc {
 			ls.closeAllUpvalues()
@@ -1755,6 +2331,8 @@ func (ls *LState) ObjLen(v1 LValue) int {
 		}
 	} else if v1.Type() == LTTable {
 		return v1.(*LTable).Len()
+	} else if lo, ok := v1.(*LObject); ok {
+		return lo.Object.Len(ls)
 	}
 	return 0
 }
@@ -1777,6 +2355,42 @@ func (ls *LState) LessThan(lhs, rhs LValue) bool {
 	return lessThan(ls, lhs, rhs)
 }
 
+func (ls *LState) LessThanOrEqual(lhs, rhs LValue) bool {
+	return lessThanOrEqual(ls, lhs, rhs)
+}
+
+// Arith performs the arithmetic operation op, one of OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_POW and OP_UNM,
+// like the VM does: strings are converted to numbers and metamethods are called for other values. rhs is ignored
+// by OP_UNM.
+func (ls *LState) Arith(op int, lhs, rhs LValue) LValue {
+	if op == OP_UNM {
+		if n, ok := lhs.(LNumber); ok {
+			return -n
+		}
+		if fn := ls.metaOp1(lhs, "__unm"); fn.Type() == LTFunction {
+			ls.reg.Push(fn)
+			ls.reg.Push(lhs)
+			ls.Call(1, 1)
+			return ls.reg.Pop()
+		}
+		if str, ok := lhs.(LString); ok {
+			if n, err := parseNumber(string(str)); err == nil {
+				return -n
+			}
+		}
+		ls.RaiseError("__unm undefined")
+	}
+	if op < OP_ADD || op > OP_POW {
+		ls.RaiseError("invalid arithmetic operation %d", op)
+	}
+	if v1, ok := lhs.(LNumber); ok {
+		if v2, ok := rhs.(LNumber); ok {
+			return numberArith(ls, op, v1, v2)
+		}
+	}
+	return objectArith(ls, op, lhs, rhs)
+}
+
 func (ls *LState) Equal(lhs, rhs LValue) bool {
 	return equals(ls, lhs, rhs, false)
 }
@@ -1797,16 +2411,83 @@ func (ls *LState) Register(name string, fn LGFunction) {
 
 /* load and function call operations {{{ */
 
+// chunkSizeReader is a reader that fails once more than limit bytes have been read.
+type chunkSizeReader struct {
+	reader io.Reader
+	limit  int
+	read   int
+}
+
+func (cr *chunkSizeReader) Read(p []byte) (int, error) {
+	if len(p) > cr.limit-cr.read+1 {
+		p = p[:cr.limit-cr.read+1]
+	}
+	n, err := cr.reader.Read(p)
+	if cr.read += n; cr.read > cr.limit {
+		return 0, fmt.Errorf("chunk is too large(limit is %d bytes)", cr.limit)
+	}
+	return n, err
+}
+
+// luaSignature is the signature binary chunks start with.
+const luaSignature = "\x1bLua"
+
 func (ls *LState) Load(reader io.Reader, name string) (*LFunction, error) {
-	chunk, err := parse.Parse(reader, name)
+	var sizeReader *chunkSizeReader
+	if ls.Options.MaxChunkSize > 0 {
+		sizeReader = &chunkSizeReader{reader: reader, limit: ls.Options.MaxChunkSize}
+		reader = sizeReader
+	}
+	chunk, err := ls.parseChunk(reader, name)
 	if err != nil {
+		if sizeReader != nil && sizeReader.read > sizeReader.limit {
+			ls.audit(AuditQuota, "chunk size", err)
+		}
 		return nil, newApiErrorE(ApiErrorSyntax, err)
 	}
-	proto, err := Compile(chunk, name)
+	return ls.compileChunk(chunk, name, nil)
+}
+
+// compileChunk compiles a parsed chunk with the limits of the state into a function with the current environment.
+// The names in upvalues are compiled as upvalues of the function(see compileWithUpvalues), which the caller has to
+// set.
+func (ls *LState) compileChunk(chunk []ast.Stmt, name string, upvalues []string) (*LFunction, error) {
+	proto, err := compileWithUpvalues(chunk, name, CompileLimits{
+		MaxNestingDepth: ls.Options.MaxNestingDepth,
+		MaxConstants:    ls.Options.MaxConstants,
+		Declarative:     ls.Options.Declarative,
+		AllowedCalls:    ls.Options.AllowedCalls,
+	}, upvalues)
 	if err != nil {
 		return nil, newApiErrorE(ApiErrorSyntax, err)
 	}
-	return newLFunctionL(proto, ls.currentEnv(), 0), nil
+	ls.G.names.internProto(proto)
+	if ls.Options.OptimizeBytecode {
+		optimize(proto, ls.Options.FloatSuffix)
+	}
+	if ls.G.stats != nil {
+		ls.G.stats.newFunction()
+	}
+	return newLFunctionL(proto, ls.currentEnv(), int(proto.NumUpvalues)), nil
+}
+
+// LoadWithMode works like Load, but only accepts the kinds of chunks given by mode: "t" for text chunks, "b" for
+// binary(precompiled) chunks and "bt" for both. Binary chunks start with the escape character "\x1b". GopherLua
+// can not load binary chunks, so they are always rejected; sandboxes should still pass "t" to keep rejecting them
+// should binary chunk support be added.
+func (ls *LState) LoadWithMode(reader io.Reader, name string, mode string) (*LFunction, error) {
+	br := bufio.NewReader(reader)
+	kind := "text"
+	if c, err := br.Peek(1); err == nil && c[0] == luaSignature[0] {
+		kind = "binary"
+	}
+	if !strings.Contains(mode, kind[:1]) {
+		return nil, newApiErrorS(ApiErrorSyntax, fmt.Sprintf("attempt to load a %s chunk (mode is '%s')", kind, mode))
+	}
+	if kind == "binary" {
+		return nil, newApiErrorS(ApiErrorSyntax, fmt.Sprintf("%s: binary chunks are not supported", name))
+	}
+	return ls.Load(br, name)
 }
 
 func (ls *LState) Call(nargs, nret int) {
@@ -1816,6 +2497,9 @@ func (ls *LState) Call(nargs, nret int) {
 func (ls *LState) PCall(nargs, nret int, errfunc *LFunction) (err error) {
 	err = nil
 	sp := ls.stack.Sp()
+	if sp == 0 {
+		ls.runPendingFinalizers()
+	}
 	base := ls.reg.Top() - nargs - 1
 	oldpanic := ls.Panic
 	ls.Panic = panicWithoutTraceback
@@ -1828,15 +2512,19 @@ func (ls *LState) PCall(nargs, nret int, errfunc *LFunction) (err error) {
 		rcv := recover()
 		if rcv != nil {
 			if _, ok := rcv.(*ApiError); !ok {
-				err = newApiErrorS(ApiErrorPanic, fmt.Sprint(rcv))
-				if ls.Options.IncludeGoStackTrace {
-					buf := make([]byte, 4096)
-					runtime.Stack(buf, false)
-					err.(*ApiError).StackTrace = strings.Trim(string(buf), "\000") + "\n" + ls.stackTrace(0)
+				if ls.Options.PanicMode == PanicModePropagate {
+					ls.stack.SetSp(sp)
+					ls.currentFrame = ls.stack.Last()
+					ls.reg.SetTop(base)
+					panic(rcv)
 				}
+				err = ls.goPanicError(rcv)
 			} else {
 				err = rcv.(*ApiError)
 			}
+			if ls.hooks != nil {
+				ls.hookUnwind(sp, err)
+			}
 			if errfunc != nil {
 				ls.Push(errfunc)
 				ls.Push(err.(*ApiError).Object)
@@ -1846,15 +2534,17 @@ func (ls *LState) PCall(nargs, nret int, errfunc *LFunction) (err error) {
 					rcv := recover()
 					if rcv != nil {
 						if _, ok := rcv.(*ApiError); !ok {
-							err = newApiErrorS(ApiErrorPanic, fmt.Sprint(rcv))
-							if ls.Options.IncludeGoStackTrace {
-								buf := make([]byte, 4096)
-								runtime.Stack(buf, false)
-								err.(*ApiError).StackTrace = strings.Trim(string(buf), "\000") + ls.stackTrace(0)
+							if ls.Options.PanicMode == PanicModePropagate {
+								ls.stack.SetSp(sp)
+								ls.currentFrame = ls.stack.Last()
+								ls.reg.SetTop(base)
+								panic(rcv)
 							}
+							err = ls.goPanicError(rcv)
 						} else {
 							err = rcv.(*ApiError)
 							err.(*ApiError).StackTrace = ls.stackTrace(0)
+							err.(*ApiError).Frames = ls.StackTrace()
 						}
 						ls.stack.SetSp(sp)
 						ls.currentFrame = ls.stack.Last()
@@ -1863,8 +2553,11 @@ func (ls *LState) PCall(nargs, nret int, errfunc *LFunction) (err error) {
 				}()
 				ls.Call(1, 1)
 				err = newApiError(ApiErrorError, ls.Get(-1))
-			} else if len(err.(*ApiError).StackTrace) == 0 {
-				err.(*ApiError).StackTrace = ls.stackTrace(0)
+			} else {
+				err.(*ApiError).addStackTrace(ls)
+			}
+			if sp == 0 {
+				ls.unhandledError(err)
 			}
 			ls.stack.SetSp(sp)
 			ls.currentFrame = ls.stack.Last()
@@ -1881,12 +2574,38 @@ func (ls *LState) PCall(nargs, nret int, errfunc *LFunction) (err error) {
 	return
 }
 
+// unhandledError calls Options.OnUnhandledError with err. The errors it raises are ignored.
+func (ls *LState) unhandledError(err error) {
+	handler := ls.Options.OnUnhandledError
+	if handler == nil {
+		return
+	}
+	oldpanic := ls.Panic
+	ls.Panic = panicWithoutTraceback
+	sp, top := ls.stack.Sp(), ls.reg.Top()
+	defer func() {
+		ls.Panic = oldpanic
+		if rcv := recover(); rcv != nil {
+			if _, ok := rcv.(*ApiError); !ok {
+				panic(rcv)
+			}
+			ls.stack.SetSp(sp)
+			ls.currentFrame = ls.stack.Last()
+		}
+		ls.reg.SetTop(top)
+	}()
+	handler(ls, err)
+}
+
 func (ls *LState) GPCall(fn LGFunction, data LValue) error {
 	ls.Push(newLFunctionG(fn, ls.currentEnv(), 0))
 	ls.Push(data)
 	return ls.PCall(1, MultRet, nil)
 }
 
+// CallByParam calls cp.Fn with the given arguments. It does not allocate on success, so it can be used to call
+// into Lua from hot Go code. Note that converting a Go value to an LValue may allocate by itself(e.g. an LNumber
+// outside of the preloaded range), so callers should reuse argument values where possible.
 func (ls *LState) CallByParam(cp P, args ...LValue) error {
 	ls.Push(cp.Fn)
 	for _, arg := range args {
@@ -1918,13 +2637,40 @@ func (ls *LState) SetMetatable(obj LValue, mt LValue) {
 	switch v := obj.(type) {
 	case *LTable:
 		v.Metatable = mt
+		ls.G.markFinalizer(v, mt)
 	case *LUserData:
 		v.Metatable = mt
+		ls.G.markFinalizer(v, mt)
 	default:
 		ls.G.builtinMts[int(obj.Type())] = mt
 	}
 }
 
+// SetDefaultMetatable sets the metatable shared by all values of type tp, e.g. to give strings or numbers extra
+// methods. A nil mt removes the metatable. The metatable of strings is the string module, so new string methods
+// are better added to the table returned by GetDefaultMetatable(LTString), or to one that falls back on it.
+// Tables and userdata have metatables of their own and objects do not use metatables, so an error is raised for
+// these types.
+func (ls *LState) SetDefaultMetatable(tp LValueType, mt *LTable) {
+	switch tp {
+	case LTTable, LTUserData, LTObject:
+		ls.RaiseError("can not set the default metatable of %v values", tp.String())
+	}
+	if mt == nil {
+		delete(ls.G.builtinMts, int(tp))
+		return
+	}
+	ls.G.builtinMts[int(tp)] = mt
+}
+
+// GetDefaultMetatable returns the metatable shared by all values of type tp, or LNil if there is none.
+func (ls *LState) GetDefaultMetatable(tp LValueType) LValue {
+	if mt, ok := ls.G.builtinMts[int(tp)]; ok {
+		return mt
+	}
+	return LNil
+}
+
 /* }}} */
 
 /* coroutine operations {{{ */
@@ -1943,7 +2689,7 @@ func (ls *LState) Status(th *LState) string {
 
 func (ls *LState) Resume(th *LState, fn *LFunction, args ...LValue) (ResumeState, error, []LValue) {
 	isstarted := th.isStarted()
-	if !isstarted {
+	if !isstarted && (fn != nil || th.stack.IsEmpty()) {
 		base := 0
 		th.stack.Push(callFrame{
 			Fn:         fn,
@@ -1976,6 +2722,9 @@ func (ls *LState) Resume(th *LState, fn *LFunction, args ...LValue) (ResumeState
 		cf.NArgs = len(args)
 		th.initCallFrame(cf)
 		th.Panic = panicWithoutTraceback
+		if th.hooks != nil {
+			th.hookCall(cf)
+		}
 	} else {
 		for _, arg := range args {
 			th.Push(arg)
@@ -2036,7 +2785,7 @@ func (ls *LState) SetMx(mx int) {
 		for atomic.LoadInt32(&ls.stop) == 0 {
 			runtime.ReadMemStats(&s)
 			if s.Alloc >= limit {
-				fmt.Println("out of memory")
+				fmt.Fprintln(ls.stderr(), "out of memory")
 				os.Exit(3)
 			}
 			time.Sleep(100 * time.Millisecond)
@@ -2058,7 +2807,9 @@ func (ls *LState) Context() context.Context {
 // RemoveContext removes the context associated with this LState and returns this context.
 func (ls *LState) RemoveContext() context.Context {
 	oldctx := ls.ctx
-	ls.mainLoop = mainLoop
+	if ls.Options.Trace == nil && (ls.debugHook == nil || !ls.debugHook.instructions()) {
+		ls.mainLoop = mainLoop
+	}
 	ls.ctx = nil
 	return oldctx
 }
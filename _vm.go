@@ -1,14 +1,24 @@
 package lua
 
 import (
-	"fmt"
 	"math"
 	"strings"
 )
 
+// contextCheckInterval is the number of instructions executed between two
+// checks of the LState context in mainLoopWithContext. The context is also
+// checked after every instruction that may call a (possibly blocking) Go
+// function.
+//
+// mainLoopWithContext is also used to run states that have a trace function
+// (Options.Trace), with or without a context, and the threads of tasks(see Task).
+const contextCheckInterval = 256
+
 func mainLoop(L *LState, baseframe *callFrame) {
 	var inst uint32
 	var cf *callFrame
+	var fn *LFunction
+	var code []uint32
 
 	if L.stack.IsEmpty() {
 		return
@@ -20,19 +30,35 @@ func mainLoop(L *LState, baseframe *callFrame) {
 		return
 	}
 
+	// cf and code are only reloaded when an instruction switches to another
+	// frame or replaces the function of the current frame(tail calls).
+	cf = L.currentFrame
+	fn = cf.Fn
+	code = fn.Proto.Code
 	for {
-		cf = L.currentFrame
-		inst = cf.Fn.Proto.Code[cf.Pc]
+		inst = code[cf.Pc]
 		cf.Pc++
-		if jumpTable[int(inst>>26)](L, inst, baseframe) == 1 {
+		if jumpTable[inst>>26](L, inst, baseframe) == 1 {
 			return
 		}
+		if L.currentFrame != cf || cf.Fn != fn {
+			if L.debugHook != nil && L.debugHook.instructions() {
+				// debug.sethook has been called in this loop
+				mainLoopWithContext(L, baseframe)
+				return
+			}
+			cf = L.currentFrame
+			fn = cf.Fn
+			code = fn.Proto.Code
+		}
 	}
 }
 
 func mainLoopWithContext(L *LState, baseframe *callFrame) {
 	var inst uint32
 	var cf *callFrame
+	var fn *LFunction
+	var code []uint32
 
 	if L.stack.IsEmpty() {
 		return
@@ -44,18 +70,55 @@ func mainLoopWithContext(L *LState, baseframe *callFrame) {
 		return
 	}
 
-	for {
-		cf = L.currentFrame
-		inst = cf.Fn.Proto.Code[cf.Pc]
-		cf.Pc++
-		select {
-		case <-L.ctx.Done():
-			L.RaiseError(L.ctx.Err().Error())
-			return
-		default:
-			if jumpTable[int(inst>>26)](L, inst, baseframe) == 1 {
+	var done <-chan struct{}
+	if L.ctx != nil {
+		done = L.ctx.Done()
+	}
+	trace := L.Options.Trace != nil
+	interval := L.Options.TraceInterval
+	traced := 0
+	cf = L.currentFrame
+	fn = cf.Fn
+	code = fn.Proto.Code
+	for count := 0; ; count++ {
+		if L.sliced {
+			// tasks can only be paused when no Go function is running in the thread
+			if L.slice <= 0 && baseframe == nil {
+				L.pauseTask()
 				return
 			}
+			L.slice--
+		}
+		if count&(contextCheckInterval-1) == 0 {
+			select {
+			case <-done:
+				L.audit(AuditQuota, "context", L.ctx.Err())
+				L.RaiseError("%s", L.ctx.Err().Error())
+				return
+			default:
+			}
+		}
+		if L.debugHook != nil && L.debugHook.instructions() {
+			L.debugHookInstruction(cf)
+		}
+		inst = code[cf.Pc]
+		if trace {
+			if traced++; interval <= 1 || traced%interval == 1 {
+				L.traceInstruction(cf, inst)
+			}
+		}
+		cf.Pc++
+		op := int(inst >> 26)
+		if jumpTable[op](L, inst, baseframe) == 1 {
+			return
+		}
+		if op == OP_CALL || op == OP_TAILCALL || op == OP_SELFCALL || op == OP_TFORLOOP || op == OP_TFORLOOPI || op == OP_SELECTVA {
+			count = -1
+		}
+		if L.currentFrame != cf || cf.Fn != fn {
+			cf = L.currentFrame
+			fn = cf.Fn
+			code = fn.Proto.Code
 		}
 	}
 }
@@ -105,6 +168,18 @@ func switchToParentThread(L *LState, nargs int, haserror bool, kill bool) {
 func callGFunction(L *LState, tailcall bool) bool {
 	frame := L.currentFrame
 	gfnret := frame.Fn.GFunction(L)
+	if gfnret < 0 {
+		if L.hooks != nil {
+			L.hookYield(frame)
+		}
+		if L.goroutine != nil {
+			gfnret = L.yieldGoroutine()
+		} else if L.Parent != nil && yieldsAcrossGoCall(frame) {
+			L.RaiseError("attempt to yield across a Go function call(see Options.GoroutineCoroutines)")
+		}
+	} else if L.hooks != nil {
+		L.hookReturn(frame)
+	}
 	if tailcall {
 		L.currentFrame = L.RemoveCallerFrame()
 	}
@@ -119,7 +194,9 @@ func callGFunction(L *LState, tailcall bool) bool {
 		wantret = gfnret
 	}
 
-	if tailcall && L.Parent != nil && L.stack.Sp() == 1 {
+	if L.Parent != nil && L.stack.Sp() == 1 {
+		// returning from the function of a coroutine
+
 		switchToParentThread(L, wantret, false, true)
 		return true
 	}
@@ -134,19 +211,49 @@ func threadRun(L *LState) {
 	if L.stack.IsEmpty() {
 		return
 	}
+	if L.Options.GoroutineCoroutines {
+		threadRunGoroutine(L)
+		return
+	}
+	runThread(L)
+}
+
+func runThread(L *LState) {
 
 	defer func() {
 		if rcv := recover(); rcv != nil {
 			var lv LValue
 			if v, ok := rcv.(*ApiError); ok {
 				lv = v.Object
+			} else if L.Options.PanicMode == PanicModePropagate {
+				panic(rcv)
 			} else {
-				lv = LString(fmt.Sprint(rcv))
+				lv = L.goPanicError(rcv).Object
+			}
+			if L.hooks != nil {
+				err, ok := rcv.(*ApiError)
+				if !ok {
+					err = newApiError(ApiErrorRun, lv)
+				}
+				L.hookUnwind(0, err)
 			}
 			if parent := L.Parent; parent != nil {
 				if L.wrapped {
-					L.Push(lv)
-					parent.Panic(L)
+					// raise the error in the resuming thread, keeping the original error object and the traceback
+					// of this coroutine
+					err, ok := rcv.(*ApiError)
+					if !ok {
+						err = newApiError(ApiErrorRun, lv)
+					}
+					err.addStackTrace(L)
+					err.threadTrace = true
+					L.G.CurrentThread = parent
+					L.Parent = nil
+					L.kill()
+					if !parent.hasErrorFunc {
+						parent.closeAllUpvalues()
+					}
+					panic(err)
 				} else {
 					L.SetTop(0)
 					L.Push(lv)
@@ -259,7 +366,7 @@ func init() {
 			RA := lbase + A
 			Bx := int(inst & 0x3ffff) //GETBX
 			//reg.Set(RA, L.getField(cf.Fn.Env, cf.Fn.Proto.Constants[Bx]))
-			v := L.getFieldString(cf.Fn.Env, cf.Fn.Proto.stringConstants[Bx])
+			v := L.getFieldStringCached(cf.Fn, cf.Pc-1, cf.Fn.Env, cf.Fn.Proto.stringConstants[Bx])
 			// +inline-call reg.Set RA v
 			return 0
 		},
@@ -283,7 +390,7 @@ func init() {
 			RA := lbase + A
 			B := int(inst & 0x1ff)    //GETB
 			C := int(inst>>9) & 0x1ff //GETC
-			v := L.getFieldString(reg.Get(lbase+B), L.rkString(C))
+			v := L.getFieldStringCached(cf.Fn, cf.Pc-1, reg.Get(lbase+B), L.rkString(C))
 			// +inline-call reg.Set RA v
 			return 0
 		},
@@ -338,7 +445,10 @@ func init() {
 			RA := lbase + A
 			B := int(inst & 0x1ff)    //GETB
 			C := int(inst>>9) & 0x1ff //GETC
-			v := newLTable(B, C)
+			v := newLTable(fb2Int(B), fb2Int(C))
+			if L.G.stats != nil {
+				L.G.stats.newTable(v)
+			}
 			// +inline-call reg.Set RA v
 			return 0
 		},
@@ -351,7 +461,7 @@ func init() {
 			B := int(inst & 0x1ff)    //GETB
 			C := int(inst>>9) & 0x1ff //GETC
 			selfobj := reg.Get(lbase + B)
-			v := L.getFieldString(selfobj, L.rkString(C))
+			v := L.getFieldStringCached(cf.Fn, cf.Pc-1, selfobj, L.rkString(C))
 			// +inline-call reg.Set RA v
 			// +inline-call reg.Set RA+1 selfobj
 			return 0
@@ -430,6 +540,8 @@ func init() {
 					}
 				} else if lv.Type() == LTTable {
 					// +inline-call reg.SetNumber RA LNumber(lv.(*LTable).Len())
+				} else if lo, ok := lv.(*LObject); ok {
+					// +inline-call reg.SetNumber RA LNumber(lo.Object.Len(L))
 				} else {
 					L.RaiseError("__len undefined")
 				}
@@ -491,35 +603,7 @@ func init() {
 			A := int(inst>>18) & 0xff //GETA
 			B := int(inst & 0x1ff)    //GETB
 			C := int(inst>>9) & 0x1ff //GETC
-			lhs := L.rkValue(B)
-			rhs := L.rkValue(C)
-			ret := false
-
-			if v1, ok1 := lhs.(LNumber); ok1 {
-				if v2, ok2 := rhs.(LNumber); ok2 {
-					ret = v1 <= v2
-				} else {
-					L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
-				}
-			} else {
-				if lhs.Type() != rhs.Type() {
-					L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
-				}
-				switch lhs.Type() {
-				case LTString:
-					ret = strCmp(string(lhs.(LString)), string(rhs.(LString))) <= 0
-				default:
-					switch objectRational(L, lhs, rhs, "__le") {
-					case 1:
-						ret = true
-					case 0:
-						ret = false
-					default:
-						ret = !objectRationalWithError(L, rhs, lhs, "__lt")
-					}
-				}
-			}
-
+			ret := lessThanOrEqual(L, L.rkValue(B), L.rkValue(C))
 			v := 1
 			if ret {
 				v = 0
@@ -572,7 +656,7 @@ func init() {
 			nret := C - 1
 			var callable *LFunction
 			var meta bool
-			if fn, ok := lv.(*LFunction); ok {
+			if fn, ok := lv.AssertFunction(); ok {
 				callable = fn
 				meta = false
 			} else {
@@ -605,9 +689,12 @@ func init() {
 				callable, meta = L.metaCall(lv)
 			}
 			if callable == nil {
-				L.RaiseError("attempt to call a non-function object")
+				L.RaiseError("attempt to call a non-function object%s", L.varInfo(lv))
 			}
 			// +inline-call L.closeUpvalues lbase
+			if L.hooks != nil {
+				L.hookReturn(cf)
+			}
 			if callable.IsG {
 				luaframe := cf
 				L.pushCallFrame(callFrame{
@@ -628,14 +715,14 @@ func init() {
 					return 1
 				}
 			} else {
+				// the callee replaces the caller in its frame and its arguments are moved down to the base of the
+				// caller, so tail calls grow neither the call stack nor the registry
 				base := cf.Base
 				cf.Fn = callable
 				cf.Pc = 0
 				cf.Base = RA
 				cf.LocalBase = RA + 1
-				cf.ReturnBase = cf.ReturnBase
 				cf.NArgs = nargs
-				cf.NRet = cf.NRet
 				cf.TailCall++
 				lbase := cf.LocalBase
 				if meta {
@@ -646,6 +733,9 @@ func init() {
 				// +inline-call L.reg.CopyRange base RA -1 reg.Top()-RA-1
 				cf.Base = base
 				cf.LocalBase = base + (cf.LocalBase - lbase + 1)
+				if L.hooks != nil {
+					L.hookCall(cf)
+				}
 			}
 			return 0
 		},
@@ -665,6 +755,9 @@ func init() {
 			if cf.NRet == MultRet {
 				n = nret
 			}
+			if L.hooks != nil {
+				L.hookReturn(cf)
+			}
 
 			if L.Parent != nil && L.stack.Sp() == 1 {
 				// +inline-call copyReturnValues L reg.Top() RA n B
@@ -689,12 +782,13 @@ func init() {
 				if limit, ok2 := reg.Get(RA + 1).(LNumber); ok2 {
 					if step, ok3 := reg.Get(RA + 2).(LNumber); ok3 {
 						init += step
-						v := LNumber(init)
-						// +inline-call reg.SetNumber RA v
+						// box the new value once and share it between the counter and the loop variable
+						v := reg.alloc.LNumber2I(init)
+						// +inline-call reg.Set RA v
 						if (step > 0 && init <= limit) || (step <= 0 && init >= limit) {
 							Sbx := int(inst&0x3ffff) - opMaxArgSbx //GETSBX
 							cf.Pc += Sbx
-							// +inline-call reg.SetNumber RA+3 v
+							// +inline-call reg.Set RA+3 v
 						} else {
 							// +inline-call reg.SetTop RA+1
 						}
@@ -789,6 +883,9 @@ func init() {
 			Bx := int(inst & 0x3ffff) //GETBX
 			proto := cf.Fn.Proto.FunctionPrototypes[Bx]
 			closure := newLFunctionL(proto, cf.Fn.Env, int(proto.NumUpvalues))
+			if L.G.stats != nil {
+				L.G.stats.newFunction()
+			}
 			// +inline-call reg.Set RA closure
 			for i := 0; i < int(proto.NumUpvalues); i++ {
 				inst = cf.Fn.Proto.Code[cf.Pc]
@@ -822,6 +919,183 @@ func init() {
 			// +inline-call reg.CopyRange RA cf.Base+nparams+1 cf.LocalBase nwant
 			return 0
 		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_TFORLOOPI
+			reg := L.reg
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			C := int(inst>>9) & 0x1ff //GETC
+			nret := C
+			tb, istable := reg.Get(RA + 1).(*LTable)
+			ctrl, isnumber := reg.Get(RA + 2).(LNumber)
+			if fn, ok := reg.Get(RA).(*LFunction); ok && fn == L.G.ipairsaux && istable && isnumber {
+				// same as calling ipairsaux, but without pushing a call frame
+				i := int(ctrl) + 1
+				var value LValue
+				if i > 0 && i <= len(tb.array) {
+					value = tb.array[i-1]
+				} else {
+					value = tb.RawGetInt(i)
+				}
+				// +inline-call reg.SetTop RA+3+nret
+				if value != LNil {
+					index := reg.alloc.LNumber2I(LNumber(i))
+					// +inline-call reg.Set RA+3 index
+					if nret > 1 {
+						// +inline-call reg.Set RA+4 value
+					}
+					for j := RA + 5; j < RA+3+nret; j++ {
+						// +inline-call reg.Set j LNil
+					}
+					// +inline-call reg.Set RA+2 index
+					pc := cf.Fn.Proto.Code[cf.Pc]
+					cf.Pc += int(pc&0x3ffff) - opMaxArgSbx
+				}
+				cf.Pc++
+				return 0
+			}
+			// +inline-call reg.SetTop RA+3+2
+			// +inline-call reg.Set RA+3+2 reg.Get(RA+2)
+			// +inline-call reg.Set RA+3+1 reg.Get(RA+1)
+			// +inline-call reg.Set RA+3 reg.Get(RA)
+			L.callR(2, nret, RA+3)
+			if value := reg.Get(RA + 3); value != LNil {
+				// +inline-call reg.Set RA+2 value
+				pc := cf.Fn.Proto.Code[cf.Pc]
+				cf.Pc += int(pc&0x3ffff) - opMaxArgSbx
+			}
+			cf.Pc++
+			return 0
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_SELECTVA
+			reg := L.reg
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			C := int(inst>>9) & 0x1ff //GETC
+			nret := C - 1
+			nparams := int(cf.Fn.Proto.NumParameters)
+			nvarargs := cf.NArgs - nparams
+			if nvarargs < 0 {
+				nvarargs = 0
+			}
+			if fn, ok := reg.Get(RA).(*LFunction); ok && fn == L.G.selectfn {
+				// same as calling select, but the varargs are read where they are instead of being copied as
+				// arguments. Invalid arguments are left to the call below to report.
+				switch lv := reg.Get(RA + 1).(type) {
+				case LNumber:
+					idx := int(lv)
+					if idx < 0 {
+						idx += nvarargs + 1
+					} else if idx > nvarargs+1 {
+						idx = nvarargs + 1
+					}
+					if idx >= 1 {
+						nwant := nret
+						if nret < 0 {
+							nwant = nvarargs - idx + 1
+						}
+						// +inline-call reg.CopyRange RA cf.Base+nparams+idx cf.LocalBase nwant
+						return 0
+					}
+				case LString:
+					if lv == "#" {
+						nwant := nret
+						if nret < 0 {
+							nwant = 1
+						}
+						// +inline-call reg.FillNil RA nwant
+						if nwant > 0 {
+							// +inline-call reg.SetNumber RA LNumber(nvarargs)
+						}
+						return 0
+					}
+				}
+			}
+			// +inline-call reg.CopyRange RA+2 cf.Base+nparams+1 cf.LocalBase nvarargs
+			return jumpTable[OP_CALL](L, opCreateABC(OP_CALL, A, 0, C), baseframe)
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_EQJMP
+			cf := L.currentFrame
+			A := int(inst>>18) & 0xff //GETA
+			B := int(inst & 0x1ff)    //GETB
+			C := int(inst>>9) & 0x1ff //GETC
+			if equals(L, L.rkValue(B), L.rkValue(C), false) == (A != 0) {
+				jmp := cf.Fn.Proto.Code[cf.Pc]
+				cf.Pc += int(jmp&0x3ffff) - opMaxArgSbx
+			}
+			cf.Pc++
+			return 0
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_LTJMP
+			cf := L.currentFrame
+			A := int(inst>>18) & 0xff //GETA
+			B := int(inst & 0x1ff)    //GETB
+			C := int(inst>>9) & 0x1ff //GETC
+			if lessThan(L, L.rkValue(B), L.rkValue(C)) == (A != 0) {
+				jmp := cf.Fn.Proto.Code[cf.Pc]
+				cf.Pc += int(jmp&0x3ffff) - opMaxArgSbx
+			}
+			cf.Pc++
+			return 0
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_LEJMP
+			cf := L.currentFrame
+			A := int(inst>>18) & 0xff //GETA
+			B := int(inst & 0x1ff)    //GETB
+			C := int(inst>>9) & 0x1ff //GETC
+			if lessThanOrEqual(L, L.rkValue(B), L.rkValue(C)) == (A != 0) {
+				jmp := cf.Fn.Proto.Code[cf.Pc]
+				cf.Pc += int(jmp&0x3ffff) - opMaxArgSbx
+			}
+			cf.Pc++
+			return 0
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_TESTJMP
+			reg := L.reg
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			C := int(inst>>9) & 0x1ff //GETC
+			if LVAsBool(reg.Get(RA)) != (C == 0) {
+				jmp := cf.Fn.Proto.Code[cf.Pc]
+				cf.Pc += int(jmp&0x3ffff) - opMaxArgSbx
+			}
+			cf.Pc++
+			return 0
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_SELFCALL
+			reg := L.reg
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			B := int(inst & 0x1ff)    //GETB
+			C := int(inst>>9) & 0x1ff //GETC
+			selfobj := reg.Get(lbase + B)
+			v := L.getFieldStringCached(cf.Fn, cf.Pc-1, selfobj, L.rkString(C))
+			// +inline-call reg.Set RA v
+			// +inline-call reg.Set RA+1 selfobj
+			inst = cf.Fn.Proto.Code[cf.Pc]
+			cf.Pc++
+			return jumpTable[OP_CALL](L, inst, baseframe)
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_RETURNK
+			reg := L.reg
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			Bx := int(inst & 0x3ffff) //GETBX
+			v := cf.Fn.Proto.Constants[Bx]
+			// +inline-call reg.Set RA v
+			inst = cf.Fn.Proto.Code[cf.Pc]
+			cf.Pc++
+			return jumpTable[OP_RETURN](L, inst, baseframe)
+		},
 		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_NOP
 			return 0
 		},
@@ -921,17 +1195,21 @@ func objectArith(L *LState, opcode int, lhs, rhs LValue) LValue {
 			return numberArith(L, opcode, LNumber(v1), LNumber(v2))
 		}
 	}
-	L.RaiseError(fmt.Sprintf("cannot perform %v operation between %v and %v",
-		strings.TrimLeft(event, "_"), lhs.Type().String(), rhs.Type().String()))
+	bad := lhs
+	if _, ok := lhs.(LNumber); ok {
+		bad = rhs
+	}
+	L.RaiseError("cannot perform %v operation between %v and %v%s",
+		strings.TrimLeft(event, "_"), lhs.Type().String(), rhs.Type().String(), L.varInfo(bad))
 
 	return LNil
 }
 
 func stringConcat(L *LState, total, last int) LValue {
-	rhs := L.reg.Get(last)
+	rhs := concatValue(L.reg.Get(last))
 	total--
 	for i := last - 1; total > 0; {
-		lhs := L.reg.Get(i)
+		lhs := concatValue(L.reg.Get(i))
 		if !(LVCanConvToString(lhs) && LVCanConvToString(rhs)) {
 			op := L.metaOp2(lhs, rhs, "__concat")
 			if op.Type() == LTFunction {
@@ -943,18 +1221,22 @@ func stringConcat(L *LState, total, last int) LValue {
 				total--
 				i--
 			} else {
-				L.RaiseError("cannot perform concat operation between %v and %v", lhs.Type().String(), rhs.Type().String())
+				bad := lhs
+				if LVCanConvToString(lhs) {
+					bad = rhs
+				}
+				L.RaiseError("cannot perform concat operation between %v and %v%s", lhs.Type().String(), rhs.Type().String(), L.varInfo(bad))
 				return LNil
 			}
 		} else {
 			buf := make([]string, total+1)
-			buf[total] = LVAsString(rhs)
+			buf[total] = L.lvToString(rhs)
 			for total > 0 {
-				lhs = L.reg.Get(i)
+				lhs = concatValue(L.reg.Get(i))
 				if !LVCanConvToString(lhs) {
 					break
 				}
-				buf[total-1] = LVAsString(lhs)
+				buf[total-1] = L.lvToString(lhs)
 				i--
 				total--
 			}
@@ -979,13 +1261,42 @@ func lessThan(L *LState, lhs, rhs LValue) bool {
 	ret := false
 	switch lhs.Type() {
 	case LTString:
-		ret = strCmp(string(lhs.(LString)), string(rhs.(LString))) < 0
+		ret = L.compareStrings(string(lhs.(LString)), string(rhs.(LString))) < 0
 	default:
 		ret = objectRationalWithError(L, lhs, rhs, "__lt")
 	}
 	return ret
 }
 
+func lessThanOrEqual(L *LState, lhs, rhs LValue) bool {
+	// optimization for numbers
+	if v1, ok1 := lhs.(LNumber); ok1 {
+		if v2, ok2 := rhs.(LNumber); ok2 {
+			return v1 <= v2
+		}
+		L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
+	}
+	if lhs.Type() != rhs.Type() {
+		L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
+		return false
+	}
+	ret := false
+	switch lhs.Type() {
+	case LTString:
+		ret = L.compareStrings(string(lhs.(LString)), string(rhs.(LString))) <= 0
+	default:
+		switch objectRational(L, lhs, rhs, "__le") {
+		case 1:
+			ret = true
+		case 0:
+			ret = false
+		default:
+			ret = !objectRationalWithError(L, rhs, lhs, "__lt")
+		}
+	}
+	return ret
+}
+
 func equals(L *LState, lhs, rhs LValue, raw bool) bool {
 	lt := lhs.Type()
 	if lt != rhs.Type() {
@@ -1015,6 +1326,12 @@ func equals(L *LState, lhs, rhs LValue, raw bool) bool {
 				ret = false
 			}
 		}
+	case LTObject:
+		if lhs == rhs {
+			ret = true
+		} else if eq, ok := lhs.(Equaler); ok && !raw {
+			ret = eq.Equals(L, rhs)
+		}
 	default:
 		ret = lhs == rhs
 	}
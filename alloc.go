@@ -1,6 +1,7 @@
 package lua
 
 import (
+	"math"
 	"reflect"
 	"unsafe"
 )
@@ -11,19 +12,42 @@ type iface struct {
 	word unsafe.Pointer
 }
 
-const preloadLimit LNumber = 128
+// Integral numbers in [preloadMin, preloadLimit) are boxed once at startup and shared by all states, so
+// loop counters, array indices and small results never allocate.
+const preloadMin LNumber = -256
+const preloadLimit LNumber = 1024
 
 var _fv float64
 var _uv uintptr
 
-var preloads [int(preloadLimit)]LValue
+var preloads [int(preloadLimit - preloadMin)]LValue
 
 func init() {
-	for i := 0; i < int(preloadLimit); i++ {
-		preloads[i] = LNumber(i)
+	for i := range preloads {
+		preloads[i] = LNumber(i) + preloadMin
 	}
 }
 
+// preloadedNumber returns the shared LValue for v if v is a preloaded number.
+func preloadedNumber(v LNumber) (LValue, bool) {
+	if v >= preloadMin && v < preloadLimit && float64(v) == float64(int64(v)) {
+		if v == 0 && math.Signbit(float64(v)) {
+			return nil, false // -0 must keep its sign
+		}
+		return preloads[int(v-preloadMin)], true
+	}
+	return nil, false
+}
+
+// numberValue converts v to an LValue, avoiding the allocation for preloaded numbers. It is meant for code
+// paths that have no allocator at hand.
+func numberValue(v LNumber) LValue {
+	if lv, ok := preloadedNumber(v); ok {
+		return lv
+	}
+	return v
+}
+
 // allocator is a fast bulk memory allocator for the LValue.
 type allocator struct {
 	size    int
@@ -32,6 +56,8 @@ type allocator struct {
 
 	scratchValue  LValue
 	scratchValueP *iface
+
+	stats *vmStats
 }
 
 func newAllocator(size int) *allocator {
@@ -56,8 +82,11 @@ func newAllocator(size int) *allocator {
 // as a whole can be gc-ed.
 func (al *allocator) LNumber2I(v LNumber) LValue {
 	// first check for shared preloaded numbers
-	if v >= 0 && v < preloadLimit && float64(v) == float64(int64(v)) {
-		return preloads[int(v)]
+	if lv, ok := preloadedNumber(v); ok {
+		return lv
+	}
+	if al.stats != nil {
+		al.stats.boxedNumber()
 	}
 
 	// check if we need a new alloc page
@@ -0,0 +1,39 @@
+package lua
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPreloadedNumbers(t *testing.T) {
+	al := newAllocator(32)
+	for _, v := range []LNumber{preloadMin, -1, 0, 1, 127, preloadLimit - 1} {
+		errorIfNotEqual(t, v, al.LNumber2I(v))
+		errorIfFalse(t, testing.AllocsPerRun(10, func() { _ = al.LNumber2I(v) }) == 0, "boxing %v allocates", v)
+		errorIfFalse(t, testing.AllocsPerRun(10, func() { _ = numberValue(v) }) == 0, "boxing %v allocates", v)
+	}
+	for _, v := range []LNumber{preloadMin - 1, preloadLimit, 0.5} {
+		errorIfNotEqual(t, v, al.LNumber2I(v))
+		errorIfNotEqual(t, v, numberValue(v))
+	}
+	negzero := LNumber(math.Copysign(0, -1))
+	errorIfFalse(t, math.Signbit(float64(al.LNumber2I(negzero).(LNumber))), "-0 lost its sign")
+	errorIfFalse(t, math.Signbit(float64(numberValue(negzero).(LNumber))), "-0 lost its sign")
+}
+
+func TestNumericLoopsDoNotAllocatePerIteration(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	fn, err := L.LoadString(`
+	local s, t = 0, {1, 2, 3}
+	for i = 1, 1000 do s = s + t[1] end
+	for i, v in ipairs(t) do s = s + i end
+	for k, v in pairs(t) do s = s + k end
+	`)
+	errorIfNotNil(t, err)
+	allocs := testing.AllocsPerRun(100, func() {
+		L.Push(fn)
+		L.Call(0, 0)
+	})
+	errorIfFalse(t, allocs < 20, "expected less than 20 allocations, got %v", allocs)
+}
@@ -0,0 +1,198 @@
+package lua
+
+import (
+	"fmt"
+)
+
+/* argument parsing {{{ */
+
+// Arg describes an argument of a Go function parsed by `LState.ParseArgs`.
+type Arg struct {
+	// Name is the name of the argument when it is passed as a keyword argument. It is also used in error messages.
+	Name string
+	// Dest is a pointer the value of the argument is stored to. Supported types are *LValue, *string, *bool,
+	// *int, *int64, *float64, *LNumber, **LTable, **LFunction, **LUserData, **LObject, **LState and *LChannel.
+	// Strings and numbers are converted into each other like CheckString and CheckNumber do.
+	Dest interface{}
+	// Optional arguments may be absent or nil, in which case Dest is left unchanged. Set Dest to the default value
+	// before calling ParseArgs.
+	Optional bool
+}
+
+// ParseArgs parses the arguments of the running Go function into the destinations of spec. Arguments are taken
+// by position, and the last argument may be a table of keyword arguments that are matched by name:
+//
+//	var sep string = ","
+//	var parts *lua.LTable
+//	L.ParseArgs(lua.Arg{Name: "parts", Dest: &parts}, lua.Arg{Name: "sep", Dest: &sep, Optional: true})
+//
+// accepts join(t), join(t, ";"), join(t, {sep = ";"}) and join(nil, {parts = t, sep = ";"}). A last table
+// argument is only taken as keyword arguments if it is not at the position of an argument whose Dest accepts a
+// table.
+//
+// An error is raised for arguments of the wrong type, missing arguments that are not optional, unknown keyword
+// arguments, arguments that are given both by position and by name, and positional arguments beyond spec.
+func (ls *LState) ParseArgs(spec ...Arg) {
+	top := ls.GetTop()
+	npos := top
+	var kwargs *LTable
+	if tb, ok := ls.Get(top).(*LTable); ok && (top > len(spec) || !argAcceptsTable(spec[top-1].Dest)) {
+		kwargs = tb
+		npos--
+	}
+	if npos > len(spec) {
+		ls.ArgError(len(spec)+1, fmt.Sprintf("too many arguments(expected at most %d)", len(spec)))
+	}
+	given := make([]bool, len(spec))
+	for i := 0; i < npos; i++ {
+		v := ls.Get(i + 1)
+		if v == LNil {
+			continue
+		}
+		given[i] = true
+		if !setArg(spec[i].Dest, v) {
+			ls.ArgError(i+1, fmt.Sprintf("%s expected, got %s", argTypeName(spec[i].Dest), v.Type().String()))
+		}
+	}
+	if kwargs != nil {
+		fname := ls.rawFrameFuncName(ls.currentFrame)
+		kwargs.ForEach(func(key, v LValue) {
+			name, ok := key.(LString)
+			i := -1
+			for j := range spec {
+				if ok && spec[j].Name == string(name) {
+					i = j
+					break
+				}
+			}
+			switch {
+			case i < 0:
+				ls.RaiseError("bad argument to %v (unknown keyword argument '%v')", fname, key.String())
+			case given[i]:
+				ls.RaiseError("bad argument to %v (argument '%v' given twice)", fname, spec[i].Name)
+			case !setArg(spec[i].Dest, v):
+				ls.RaiseError("bad argument '%v' to %v (%v expected, got %v)", spec[i].Name, fname, argTypeName(spec[i].Dest), v.Type().String())
+			}
+			given[i] = true
+		})
+	}
+	for i, arg := range spec {
+		if !given[i] && !arg.Optional {
+			ls.ArgError(i+1, fmt.Sprintf("missing argument '%s'", arg.Name))
+		}
+	}
+}
+
+// setArg stores v to dest, and returns false if v does not have the type of dest.
+func setArg(dest interface{}, v LValue) bool {
+	switch d := dest.(type) {
+	case *LValue:
+		*d = v
+	case *string:
+		if !LVCanConvToString(v) {
+			return false
+		}
+		*d = LVAsString(v)
+	case *bool:
+		lv, ok := v.(LBool)
+		if !ok {
+			return false
+		}
+		*d = bool(lv)
+	case *int, *int64, *float64, *LNumber:
+		num, ok := v.(LNumber)
+		if s, isstr := v.(LString); isstr {
+			n, err := parseNumber(string(s))
+			num, ok = n, err == nil
+		}
+		if !ok {
+			return false
+		}
+		switch d := dest.(type) {
+		case *int:
+			*d = int(num)
+		case *int64:
+			*d = int64(num)
+		case *float64:
+			*d = float64(num)
+		case *LNumber:
+			*d = num
+		}
+	case **LTable:
+		lv, ok := v.(*LTable)
+		if !ok {
+			return false
+		}
+		*d = lv
+	case **LFunction:
+		lv, ok := v.(*LFunction)
+		if !ok {
+			return false
+		}
+		*d = lv
+	case **LUserData:
+		lv, ok := v.(*LUserData)
+		if !ok {
+			return false
+		}
+		*d = lv
+	case **LObject:
+		lv, ok := v.(*LObject)
+		if !ok {
+			return false
+		}
+		*d = lv
+	case **LState:
+		lv, ok := v.(*LState)
+		if !ok {
+			return false
+		}
+		*d = lv
+	case *LChannel:
+		lv, ok := v.(LChannel)
+		if !ok {
+			return false
+		}
+		*d = lv
+	default:
+		panic(fmt.Sprintf("unsupported argument destination %T", dest))
+	}
+	return true
+}
+
+// argTypeName returns the name of the Lua type dest accepts.
+func argTypeName(dest interface{}) string {
+	switch dest.(type) {
+	case *LValue:
+		return "value"
+	case *string:
+		return LTString.String()
+	case *bool:
+		return LTBool.String()
+	case *int, *int64, *float64, *LNumber:
+		return LTNumber.String()
+	case **LTable:
+		return LTTable.String()
+	case **LFunction:
+		return LTFunction.String()
+	case **LUserData:
+		return LTUserData.String()
+	case **LObject:
+		return LTObject.String()
+	case **LState:
+		return LTThread.String()
+	case *LChannel:
+		return LTChannel.String()
+	}
+	return "?"
+}
+
+func argAcceptsTable(dest interface{}) bool {
+	switch dest.(type) {
+	case *LValue, **LTable:
+		return true
+	}
+	return false
+}
+
+/* }}} */
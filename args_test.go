@@ -0,0 +1,50 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("join", L.NewFunction(func(L *LState) int {
+		var parts *LTable
+		sep := ","
+		count := 0
+		var last bool
+		L.ParseArgs(
+			Arg{Name: "parts", Dest: &parts},
+			Arg{Name: "sep", Dest: &sep, Optional: true},
+			Arg{Name: "count", Dest: &count, Optional: true},
+			Arg{Name: "last", Dest: &last, Optional: true},
+		)
+		buf := []string{}
+		parts.ForEach(func(_, v LValue) {
+			if count == 0 || len(buf) < count {
+				buf = append(buf, v.String())
+			}
+		})
+		s := strings.Join(buf, sep)
+		if last {
+			s += sep
+		}
+		L.Push(LString(s))
+		return 1
+	}))
+	errorIfScriptFail(t, L, `
+local t = {"a", "b", "c"}
+assert(join(t) == "a,b,c")
+assert(join(t, ";") == "a;b;c")
+assert(join(t, ";", "2") == "a;b")
+assert(join(t, {sep = "-", last = true}) == "a-b-c-")
+assert(join(t, nil, 1) == "a")
+assert(join(nil, {parts = t, count = 2}) == "a,b")
+`)
+	errorIfScriptNotFail(t, L, `join()`, "bad argument #1 to join \\(missing argument 'parts'\\)")
+	errorIfScriptNotFail(t, L, `join({}, {}, 1)`, "bad argument #2 to join \\(string expected, got table\\)")
+	errorIfScriptNotFail(t, L, `join({}, ",", 1, true, 1)`, "too many arguments\\(expected at most 4\\)")
+	errorIfScriptNotFail(t, L, `join({}, {max = 1})`, "unknown keyword argument 'max'")
+	errorIfScriptNotFail(t, L, `join({}, ";", {sep = ","})`, "argument 'sep' given twice")
+	errorIfScriptNotFail(t, L, `join({}, {count = "x"})`, "bad argument 'count' to join \\(number expected, got string\\)")
+}
@@ -0,0 +1,59 @@
+package lua
+
+/* audit events {{{ */
+
+// AuditKind is the kind of an AuditEvent.
+type AuditKind int
+
+const (
+	// AuditDenied is reported when `Options.Policy` denies an operation.
+	AuditDenied AuditKind = iota
+	// AuditQuota is reported when a state exceeds a quota: the call stack or registry size, `Options.MaxChunkSize`,
+	// `Options.MaxStringSize` or the deadline of its context.
+	AuditQuota
+)
+
+func (k AuditKind) String() string {
+	switch k {
+	case AuditDenied:
+		return "denied"
+	case AuditQuota:
+		return "quota"
+	}
+	return "unknown"
+}
+
+// AuditEvent describes a denied operation or an exceeded quota(see `Options.Audit`).
+type AuditEvent struct {
+	Kind AuditKind
+	// Op is the operation(see `Policy`) or the exceeded quota: "stack overflow", "registry overflow", "chunk size",
+	// "string size" or "context".
+	Op string
+	// Args are the arguments of a denied operation.
+	Args []LValue
+	// Err is the reason the operation was denied or the error raised for the quota.
+	Err error
+	// Source and Line are the chunk name and the line of the innermost Lua function, if any.
+	Source string
+	Line   int
+	// Stack is the call stack, innermost function first.
+	Stack []Frame
+}
+
+// audit reports an event to `Options.Audit`.
+func (ls *LState) audit(kind AuditKind, op string, err error, args ...LValue) {
+	handler := ls.Options.Audit
+	if handler == nil {
+		return
+	}
+	ev := &AuditEvent{Kind: kind, Op: op, Args: args, Err: err, Stack: ls.StackTrace()}
+	for _, frame := range ev.Stack {
+		if !frame.IsGo {
+			ev.Source, ev.Line = frame.Source, frame.Line
+			break
+		}
+	}
+	handler(ls, ev)
+}
+
+/* }}} */
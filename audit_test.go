@@ -0,0 +1,58 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAudit(t *testing.T) {
+	var events []*AuditEvent
+	L := NewState(Options{
+		CallStackSize: 64,
+		MaxChunkSize:  64,
+		MaxStringSize: 1000,
+		Policy: PolicyFunc(func(L *LState, op string, args ...LValue) error {
+			if op == "os.remove" {
+				return errors.New("read-only")
+			}
+			return nil
+		}),
+		Audit: func(L *LState, ev *AuditEvent) { events = append(events, ev) },
+	})
+	defer L.Close()
+	errorIfNotNil(t, L.DoString("local function f()\n  os.remove('x')\nend\npcall(f)"))
+	errorIfNotEqual(t, 1, len(events))
+	ev := events[0]
+	errorIfNotEqual(t, AuditDenied, ev.Kind)
+	errorIfNotEqual(t, "os.remove", ev.Op)
+	errorIfNotEqual(t, "x", ev.Args[0].String())
+	errorIfNotEqual(t, "read-only", ev.Err.Error())
+	errorIfNotEqual(t, "<string>", ev.Source)
+	errorIfNotEqual(t, 2, ev.Line)
+	errorIfNotEqual(t, "remove", ev.Stack[0].FunctionName)
+
+	errorIfNil(t, L.DoString(`local function f() return f() + 1 end f()`))
+	errorIfNotEqual(t, 2, len(events))
+	errorIfNotEqual(t, AuditQuota, events[1].Kind)
+	errorIfNotEqual(t, "stack overflow", events[1].Op)
+
+	errorIfNil(t, L.DoString(strings.Repeat(" ", 100)))
+	errorIfNotEqual(t, 3, len(events))
+	errorIfNotEqual(t, "chunk size", events[2].Op)
+
+	errorIfNotNil(t, L.DoString(`assert(#string.rep("ab", 100, ",") == 299)`))
+	errorIfNil(t, L.DoString(`string.rep("ab", 1000)`))
+	errorIfNotEqual(t, 4, len(events))
+	errorIfNotEqual(t, "string size", events[3].Op)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	L.SetContext(ctx)
+	errorIfNil(t, L.DoString(`while true do end`))
+	errorIfNotEqual(t, 5, len(events))
+	errorIfNotEqual(t, "context", events[4].Op)
+	errorIfFalse(t, errors.Is(events[4].Err, context.DeadlineExceeded), "deadline expected, got %v", events[4].Err)
+}
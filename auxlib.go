@@ -2,8 +2,10 @@ package lua
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"strings"
 )
@@ -105,6 +107,15 @@ func (ls *LState) CheckThread(n int) *LState {
 	return nil
 }
 
+func (ls *LState) CheckObject(n int) *LObject {
+	v := ls.Get(n)
+	if lv, ok := v.(*LObject); ok {
+		return lv
+	}
+	ls.TypeError(n, LTObject)
+	return nil
+}
+
 func (ls *LState) CheckType(n int, typ LValueType) {
 	v := ls.Get(n)
 	if v.Type() != typ {
@@ -237,6 +248,51 @@ func (ls *LState) OptUserData(n int, d *LUserData) *LUserData {
 	return nil
 }
 
+func (ls *LState) OptThread(n int, d *LState) *LState {
+	v := ls.Get(n)
+	if v == LNil {
+		return d
+	}
+	if lv, ok := v.(*LState); ok {
+		return lv
+	}
+	ls.TypeError(n, LTThread)
+	return nil
+}
+
+func (ls *LState) OptObject(n int, d *LObject) *LObject {
+	v := ls.Get(n)
+	if v == LNil {
+		return d
+	}
+	if lv, ok := v.(*LObject); ok {
+		return lv
+	}
+	ls.TypeError(n, LTObject)
+	return nil
+}
+
+// OptAny returns the given argument, or d if it is absent or nil.
+func (ls *LState) OptAny(n int, d LValue) LValue {
+	if v := ls.Get(n); v != LNil {
+		return v
+	}
+	return d
+}
+
+// OptOption works like CheckOption, but returns the index of d in options if the argument is absent or nil.
+func (ls *LState) OptOption(n int, d string, options []string) int {
+	if ls.Get(n) == LNil {
+		for i, opt := range options {
+			if opt == d {
+				return i
+			}
+		}
+		ls.ArgError(n, fmt.Sprintf("invalid option: %s (must be one of %s)", d, strings.Join(options, ",")))
+	}
+	return ls.CheckOption(n, options)
+}
+
 /* }}} */
 
 /* error operations {{{ */
@@ -295,7 +351,9 @@ func (ls *LState) RegisterModule(name string, funcs map[string]LGFunction) LValu
 			ls.RaiseError("name conflict for module(%v)", name)
 		} else {
 			for fname, fn := range funcs {
-				newmodtb.RawSetString(fname, ls.NewFunction(fn))
+				cl := ls.NewFunction(fn)
+				cl.name = fname
+				newmodtb.RawSetString(fname, cl)
 			}
 			ls.SetField(tb, name, newmodtb)
 			return newmodtb
@@ -306,7 +364,9 @@ func (ls *LState) RegisterModule(name string, funcs map[string]LGFunction) LValu
 
 func (ls *LState) SetFuncs(tb *LTable, funcs map[string]LGFunction, upvalues ...LValue) *LTable {
 	for fname, fn := range funcs {
-		tb.RawSetString(fname, ls.NewClosure(fn, upvalues...))
+		cl := ls.NewClosure(fn, upvalues...)
+		cl.name = fname
+		tb.RawSetString(fname, cl)
 	}
 	return tb
 }
@@ -349,19 +409,39 @@ func (ls *LState) CallMeta(obj LValue, event string) LValue {
 
 /* load and function call operations {{{ */
 
+// LoadFile loads the script file path, or the standard input if path is empty. The policy of the state is asked
+// for the "load" operation(see `Policy`), so that require and dofile are denied like load.
 func (ls *LState) LoadFile(path string) (*LFunction, error) {
-	var file *os.File
-	var err error
+	name := path
 	if len(path) == 0 {
-		file = os.Stdin
-	} else {
-		file, err = os.Open(path)
-		defer file.Close()
-		if err != nil {
-			return nil, newApiErrorE(ApiErrorFile, err)
-		}
+		name = "<stdin>"
+	}
+	if err := ls.checkPolicy("load", LString(name), LString("bt")); err != nil {
+		return nil, newApiErrorE(ApiErrorFile, err)
+	}
+	if len(path) == 0 {
+		return ls.loadScript(ls.stdin(), path)
+	}
+	file, err := ls.openFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, newApiErrorE(ApiErrorFile, err)
+	}
+	defer file.Close()
+	return ls.loadScript(file, path)
+}
+
+// LoadFS works like LoadFile, but reads the file from fsys.
+func (ls *LState) LoadFS(fsys fs.FS, path string) (*LFunction, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, newApiErrorE(ApiErrorFile, err)
 	}
+	defer file.Close()
+	return ls.loadScript(file, path)
+}
 
+// loadScript loads a chunk from a script file, skipping a first line starting with '#'.
+func (ls *LState) loadScript(file io.Reader, name string) (*LFunction, error) {
 	reader := bufio.NewReader(file)
 	// get the first character.
 	c, err := reader.ReadByte()
@@ -386,7 +466,7 @@ func (ls *LState) LoadFile(path string) (*LFunction, error) {
 		}
 	}
 
-	return ls.Load(reader, path)
+	return ls.Load(reader, name)
 }
 
 func (ls *LState) LoadString(source string) (*LFunction, error) {
@@ -411,6 +491,46 @@ func (ls *LState) DoString(source string) error {
 	}
 }
 
+// DoReader loads a chunk named name from r and runs it in protected mode. Unlike DoString, the results of the
+// chunk are returned instead of being left on the stack.
+func (ls *LState) DoReader(name string, r io.Reader) ([]LValue, error) {
+	fn, err := ls.Load(r, name)
+	if err != nil {
+		return nil, err
+	}
+	return ls.doFunction(fn)
+}
+
+// DoBytes works like DoReader for the source src.
+func (ls *LState) DoBytes(name string, src []byte) ([]LValue, error) {
+	return ls.DoReader(name, bytes.NewReader(src))
+}
+
+// DoFS loads the file path from fsys like LoadFS, runs it in protected mode and returns its results.
+func (ls *LState) DoFS(fsys fs.FS, path string) ([]LValue, error) {
+	fn, err := ls.LoadFS(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	return ls.doFunction(fn)
+}
+
+// doFunction calls fn without arguments in protected mode and pops its results off the stack.
+func (ls *LState) doFunction(fn *LFunction) ([]LValue, error) {
+	base := ls.GetTop()
+	ls.Push(fn)
+	if err := ls.PCall(0, MultRet, nil); err != nil {
+		return nil, err
+	}
+	n := ls.GetTop() - base
+	results := make([]LValue, n)
+	for i := 0; i < n; i++ {
+		results[i] = ls.Get(base + i + 1)
+	}
+	ls.Pop(n)
+	return results, nil
+}
+
 /* }}} */
 
 /* GopherLua original APIs {{{ */
@@ -424,8 +544,17 @@ func (ls *LState) ToStringMeta(lv LValue) LValue {
 		ls.Call(1, 1)
 		return ls.reg.Pop()
 	} else {
-		return LString(lv.String())
+		return LString(ls.lvToString(lv))
+	}
+}
+
+// lvToString converts lv to a string like LValue.String, adding the suffix of `Options.FloatSuffix` to integral
+// numbers.
+func (ls *LState) lvToString(lv LValue) string {
+	if nm, ok := lv.(LNumber); ok && ls.Options.FloatSuffix {
+		return floatString(nm)
 	}
+	return lv.String()
 }
 
 // Set a module loader to the package.preload table.
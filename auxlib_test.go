@@ -2,7 +2,9 @@ package lua
 
 import (
 	"os"
+	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 func TestCheckInt(t *testing.T) {
@@ -298,7 +300,53 @@ func TestOptChannel(t *testing.T) {
 	}, "channel expected, got string")
 }
 
+func TestOptThread(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfGFuncNotFail(t, L, func(L *LState) int {
+		errorIfNotEqual(t, L, L.OptThread(1, L))
+		th, _ := L.NewThread()
+		L.Push(th)
+		errorIfNotEqual(t, th, L.OptThread(2, L))
+		L.Push(LNumber(10))
+		L.OptThread(3, L)
+		return 0
+	}, "thread expected, got number")
+}
+
+func TestOptObject(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfGFuncNotFail(t, L, func(L *LState) int {
+		defobj := L.NewObject(&testCounter{})
+		errorIfNotEqual(t, defobj, L.OptObject(1, defobj))
+		obj := L.NewObject([]int{1})
+		L.Push(obj)
+		errorIfNotEqual(t, obj, L.OptObject(2, defobj))
+		errorIfNotEqual(t, obj, L.CheckObject(2))
+		L.Push(LNumber(10))
+		L.OptObject(3, defobj)
+		return 0
+	}, "object expected, got number")
+}
+
+func TestOptOption(t *testing.T) {
+	opts := []string{"opt1", "opt2"}
+	L := NewState()
+	defer L.Close()
+	errorIfGFuncNotFail(t, L, func(L *LState) int {
+		errorIfNotEqual(t, 1, L.OptOption(1, "opt2", opts))
+		errorIfNotEqual(t, LTrue, L.OptAny(1, LTrue))
+		L.Push(LString("opt1"))
+		errorIfNotEqual(t, 0, L.OptOption(2, "opt2", opts))
+		L.Push(LString("opt5"))
+		L.OptOption(3, "opt2", opts)
+		return 0
+	}, "invalid option: opt5 \\(must be one of opt1,opt2\\)")
+}
+
 func TestLoadFileForShebang(t *testing.T) {
+	skipIfNoSys(t)
 	tmpFile, err := os.CreateTemp("", "")
 	errorIfNotNil(t, err)
 
@@ -320,6 +368,7 @@ print("hello")
 }
 
 func TestLoadFileForEmptyFile(t *testing.T) {
+	skipIfNoSys(t)
 	tmpFile, err := os.CreateTemp("", "")
 	errorIfNotNil(t, err)
 
@@ -334,3 +383,34 @@ func TestLoadFileForEmptyFile(t *testing.T) {
 	_, err = L.LoadFile(tmpFile.Name())
 	errorIfNotNil(t, err)
 }
+
+func TestDoReader(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	results, err := L.DoReader("chunk", strings.NewReader(`return 1, "a", ...`))
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, 2, len(results))
+	errorIfNotEqual(t, LNumber(1), results[0])
+	errorIfNotEqual(t, LString("a"), results[1])
+	errorIfNotEqual(t, 0, L.GetTop())
+
+	_, err = L.DoBytes("bytes", []byte(`error("failed")`))
+	errorIfFalse(t, err != nil && strings.Contains(err.Error(), "bytes:1: failed"), "unexpected error: %v", err)
+	errorIfNotEqual(t, 0, L.GetTop())
+	_, err = L.DoBytes("syntax", []byte(`return (`))
+	errorIfFalse(t, err != nil && err.(*ApiError).Type == ApiErrorSyntax, "syntax error expected, got %v", err)
+
+	fsys := fstest.MapFS{
+		"main.lua":  {Data: []byte("#!/usr/bin/env lua\nreturn x * 2")},
+		"error.lua": {Data: []byte("local t = nil\nreturn t.x")},
+	}
+	L.SetGlobal("x", LNumber(21))
+	results, err = L.DoFS(fsys, "main.lua")
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, 1, len(results))
+	errorIfNotEqual(t, LNumber(42), results[0])
+	_, err = L.DoFS(fsys, "error.lua")
+	errorIfFalse(t, err != nil && strings.Contains(err.Error(), "error.lua:2:"), "unexpected error: %v", err)
+	_, err = L.DoFS(fsys, "missing.lua")
+	errorIfFalse(t, err != nil && err.(*ApiError).Type == ApiErrorFile, "file error expected, got %v", err)
+}
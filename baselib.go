@@ -17,7 +17,10 @@ func OpenBase(L *LState) int {
 	L.SetGlobal("_VERSION", LString(LuaVersion))
 	L.SetGlobal("_GOPHER_LUA_VERSION", LString(PackageName+" "+PackageVersion))
 	basemod := L.RegisterModule("_G", baseFuncs)
-	global.RawSetString("ipairs", L.NewClosure(baseIpairs, L.NewFunction(ipairsaux)))
+	L.G.ipairsaux = L.NewFunction(ipairsaux)
+	L.G.selectfn = L.NewFunction(baseSelect)
+	global.RawSetString("select", L.G.selectfn)
+	global.RawSetString("ipairs", L.NewClosure(baseIpairs, L.G.ipairsaux))
 	global.RawSetString("pairs", L.NewClosure(basePairs, L.NewFunction(pairsaux)))
 	L.Push(basemod)
 	return 1
@@ -39,7 +42,6 @@ var baseFuncs = map[string]LGFunction{
 	"rawequal":       baseRawEqual,
 	"rawget":         baseRawGet,
 	"rawset":         baseRawSet,
-	"select":         baseSelect,
 	"_printregs":     base_PrintRegs,
 	"setfenv":        baseSetFEnv,
 	"setmetatable":   baseSetMetatable,
@@ -65,6 +67,7 @@ func baseAssert(L *LState) int {
 
 func baseCollectGarbage(L *LState) int {
 	runtime.GC()
+	L.runPendingFinalizers()
 	return 0
 }
 
@@ -141,8 +144,9 @@ func ipairsaux(L *LState) int {
 		return 0
 	} else {
 		L.Pop(1)
-		L.Push(LNumber(i))
-		L.Push(LNumber(i))
+		index := numberValue(LNumber(i))
+		L.Push(index)
+		L.Push(index)
 		L.Push(v)
 		return 2
 	}
@@ -152,12 +156,12 @@ func baseIpairs(L *LState) int {
 	tb := L.CheckTable(1)
 	L.Push(L.Get(UpvalueIndex(1)))
 	L.Push(tb)
-	L.Push(LNumber(0))
+	L.PushNumber(LNumber(0))
 	return 3
 }
 
-func loadaux(L *LState, reader io.Reader, chunkname string) int {
-	if fn, err := L.Load(reader, chunkname); err != nil {
+func loadaux(L *LState, reader io.Reader, chunkname string, mode string) int {
+	if fn, err := L.LoadWithMode(reader, chunkname, mode); err != nil {
 		L.Push(LNil)
 		L.Push(LString(err.Error()))
 		return 2
@@ -170,6 +174,8 @@ func loadaux(L *LState, reader io.Reader, chunkname string) int {
 func baseLoad(L *LState) int {
 	fn := L.CheckFunction(1)
 	chunkname := L.OptString(2, "?")
+	mode := L.OptString(3, "bt")
+	L.enforcePolicy("load", LString(chunkname), LString(mode))
 	top := L.GetTop()
 	buf := []string{}
 	for {
@@ -192,19 +198,22 @@ func baseLoad(L *LState) int {
 			return 2
 		}
 	}
-	return loadaux(L, strings.NewReader(strings.Join(buf, "")), chunkname)
+	return loadaux(L, strings.NewReader(strings.Join(buf, "")), chunkname, mode)
 }
 
 func baseLoadFile(L *LState) int {
 	var reader io.Reader
 	var chunkname string
 	var err error
-	if L.GetTop() < 1 {
-		reader = os.Stdin
+	mode := L.OptString(2, "bt")
+	if L.Get(1) == LNil {
+		L.enforcePolicy("load", LString("<stdin>"), LString(mode))
+		reader = L.stdin()
 		chunkname = "<stdin>"
 	} else {
 		chunkname = L.CheckString(1)
-		reader, err = os.Open(chunkname)
+		L.enforcePolicy("load", LString(chunkname), LString(mode))
+		reader, err = L.openFile(chunkname, os.O_RDONLY, 0)
 		if err != nil {
 			L.Push(LNil)
 			L.Push(LString(fmt.Sprintf("can not open file: %v", chunkname)))
@@ -212,20 +221,41 @@ func baseLoadFile(L *LState) int {
 		}
 		defer reader.(*os.File).Close()
 	}
-	return loadaux(L, reader, chunkname)
+	return loadaux(L, reader, chunkname, mode)
 }
 
 func baseLoadString(L *LState) int {
-	return loadaux(L, strings.NewReader(L.CheckString(1)), L.OptString(2, "<string>"))
+	chunkname, mode := L.OptString(2, "<string>"), L.OptString(3, "bt")
+	L.enforcePolicy("load", LString(chunkname), LString(mode))
+	return loadaux(L, strings.NewReader(L.CheckString(1)), chunkname, mode)
 }
 
 func baseNext(L *LState) int {
+	if obj := L.Get(1); obj.Type() == LTObject {
+		key, value := L.nextObject(obj, L.Get(2))
+		if key == LNil {
+			L.Push(LNil)
+			return 1
+		}
+		L.Push(key)
+		L.Push(value)
+		return 2
+	}
 	tb := L.CheckTable(1)
 	index := LNil
 	if L.GetTop() >= 2 {
 		index = L.Get(2)
 	}
-	key, value := tb.Next(index)
+	var key, value LValue
+	var found bool
+	if L.Options.SortedPairs {
+		key, value, found = L.sortedNext(tb, index)
+	} else {
+		key, value, found = tb.next(index)
+	}
+	if !found {
+		L.RaiseError("invalid key to 'next'")
+	}
 	if key == LNil {
 		L.Push(LNil)
 		return 1
@@ -237,7 +267,16 @@ func baseNext(L *LState) int {
 
 func pairsaux(L *LState) int {
 	tb := L.CheckTable(1)
-	key, value := tb.Next(L.Get(2))
+	var key, value LValue
+	var found bool
+	if L.Options.SortedPairs {
+		key, value, found = L.sortedNext(tb, L.Get(2))
+	} else {
+		key, value, found = tb.next(L.Get(2))
+	}
+	if !found {
+		L.RaiseError("invalid key to 'next'")
+	}
 	if key == LNil {
 		return 0
 	} else {
@@ -250,6 +289,18 @@ func pairsaux(L *LState) int {
 }
 
 func basePairs(L *LState) int {
+	if obj := L.Get(1); obj.Type() == LTObject {
+		iter := L.iterateObject(obj, 1)
+		L.Push(L.NewFunction(func(L *LState) int {
+			key, value := iter()
+			L.Push(key)
+			L.Push(value)
+			return 2
+		}))
+		L.Push(obj)
+		L.Push(LNil)
+		return 3
+	}
 	tb := L.CheckTable(1)
 	L.Push(L.Get(UpvalueIndex(1)))
 	L.Push(tb)
@@ -260,7 +311,7 @@ func basePairs(L *LState) int {
 func basePCall(L *LState) int {
 	L.CheckAny(1)
 	v := L.Get(1)
-	if v.Type() != LTFunction && L.GetMetaField(v, "__call").Type() != LTFunction {
+	if v.Type() != LTFunction && v.Type() != LTObject && L.GetMetaField(v, "__call").Type() != LTFunction {
 		L.Push(LFalse)
 		L.Push(LString("attempt to call a " + v.Type().String() + " value"))
 		return 2
@@ -281,14 +332,19 @@ func basePCall(L *LState) int {
 }
 
 func basePrint(L *LState) int {
+	if L.Options.PrintToLog {
+		L.log(LogInfo, L.joinArgs())
+		return 0
+	}
 	top := L.GetTop()
+	out := L.stdout()
 	for i := 1; i <= top; i++ {
-		fmt.Print(L.ToStringMeta(L.Get(i)).String())
+		fmt.Fprint(out, L.ToStringMeta(L.Get(i)).String())
 		if i != top {
-			fmt.Print("\t")
+			fmt.Fprint(out, "\t")
 		}
 	}
-	fmt.Println("")
+	fmt.Fprintln(out, "")
 	return 0
 }
 
@@ -335,7 +391,7 @@ func baseSelect(L *LState) int {
 		if string(lv) != "#" {
 			L.ArgError(1, "invalid string '"+string(lv)+"'")
 		}
-		L.Push(LNumber(L.GetTop() - 1))
+		L.PushNumber(LNumber(L.GetTop() - 1))
 		return 1
 	}
 	return 0
@@ -414,7 +470,7 @@ func baseToNumber(L *LState) int {
 			if v, err := strconv.ParseFloat(str, LNumberBit); err != nil {
 				L.Push(LNil)
 			} else {
-				L.Push(LNumber(v))
+				L.PushNumber(LNumber(v))
 			}
 		} else {
 			if noBase && strings.HasPrefix(strings.ToLower(str), "0x") {
@@ -423,7 +479,7 @@ func baseToNumber(L *LState) int {
 			if v, err := strconv.ParseInt(str, base, LNumberBit); err != nil {
 				L.Push(LNil)
 			} else {
-				L.Push(LNumber(v))
+				L.PushNumber(LNumber(v))
 			}
 		}
 	default:
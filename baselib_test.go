@@ -2,6 +2,7 @@ package lua
 
 import (
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -139,3 +140,99 @@ func assertOsDateFields(t *testing.T, key LValue, value LValue, expect time.Time
 		}
 	}
 }
+
+func TestLoadMode(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+assert(loadstring("return 1", "chunk", "t")() == 1)
+assert(loadstring("return 1", "chunk", "bt")() == 1)
+local f, err = loadstring("return 1", "chunk", "b")
+assert(f == nil and err == "attempt to load a text chunk (mode is 'b')", err)
+f, err = loadstring("\27Lua", "chunk", "t")
+assert(f == nil and err == "attempt to load a binary chunk (mode is 't')", err)
+f, err = loadstring("\27Lua", "chunk")
+assert(f == nil and string.find(err, "binary chunks are not supported"))
+local parts = {"return ", "2"}
+local i = 0
+local function reader() i = i + 1; return parts[i] end
+assert(load(reader, "chunk", "t")() == 2)
+i = 0
+assert(load(reader, "chunk", "b") == nil)
+`)
+	_, err := L.LoadWithMode(strings.NewReader("\x1bLua"), "chunk", "t")
+	errorIfFalse(t, err != nil && err.(*ApiError).Type == ApiErrorSyntax, "syntax error expected, got %v", err)
+	fn, err := L.LoadWithMode(strings.NewReader("return 1"), "chunk", "t")
+	errorIfNotNil(t, err)
+	errorIfNil(t, fn)
+}
+
+func TestSortedPairs(t *testing.T) {
+	L := NewState(Options{SortedPairs: true})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+local t = {"x", "y", zeta = 1, alpha = 2, [10] = "ten", [-1.5] = "neg", [true] = 3, [false] = 4, mid = 5}
+local keys = {}
+for k in pairs(t) do keys[#keys + 1] = tostring(k) end
+assert(table.concat(keys, ",") == "-1.5,1,2,10,alpha,mid,zeta,false,true", table.concat(keys, ","))
+
+keys = {}
+local k = next(t)
+while k ~= nil do
+	keys[#keys + 1] = tostring(k)
+	k = next(t, k)
+end
+assert(table.concat(keys, ",") == "-1.5,1,2,10,alpha,mid,zeta,false,true")
+
+local n = 0
+for k in pairs(t) do
+	t[k] = nil
+	n = n + 1
+	for k2 in pairs({b = 1, a = 2}) do end
+end
+assert(n == 9 and next(t) == nil)
+
+for k in pairs({a = 1, b = 2}) do break end
+assert(next({}) == nil)
+`)
+}
+
+func TestNextWhileModifying(t *testing.T) {
+	for _, sorted := range []bool{false, true} {
+		L := NewState(Options{SortedPairs: sorted})
+		errorIfScriptFail(t, L, `
+local t = {1, 2, 3, a = 1, b = 2, c = 3, [1.5] = 4}
+local seen, n = {}, 0
+for k, v in pairs(t) do
+	assert(not seen[k])
+	seen[k] = true
+	n = n + 1
+	if k == 2 then t[3] = nil; t.a = nil end
+	if k == "b" then t.b = "x"; t.c = "y" end
+end
+assert(n == 5 and t.c == "y")
+
+-- keys moved from the hash part to the array part by new keys are not visited twice
+t = {a = 1}
+for i = 1, 30 do t[i] = i end
+t[100], t[101] = 100, 101
+seen = {}
+for k in pairs(t) do
+	assert(not seen[k], tostring(k))
+	seen[k] = true
+	if k == 100 then for i = 31, 99 do t[i] = i end end
+end
+`)
+		if !sorted {
+			errorIfScriptNotFail(t, L, `
+local t = {a = 1}
+local k = next(t)
+t.a = nil
+for i = 1, 100 do t["k" .. i] = i end
+next(t, k)
+`, "invalid key to 'next'")
+		}
+		errorIfScriptNotFail(t, L, `next({}, {})`, "invalid key to 'next'")
+		L.Close()
+	}
+}
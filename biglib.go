@@ -0,0 +1,248 @@
+package lua
+
+import (
+	"math"
+	"math/big"
+)
+
+/* big integer library {{{ */
+
+const bigIntClass = "bigint"
+
+// OpenBig opens the big library, which provides integers of arbitrary precision:
+//
+//	local n = big.new("123456789012345678901234567890")
+//	print(n * n + 1, big.new(2) ^ 100)
+//
+// The arithmetic operators and the comparison operators work on big integers, and numbers and strings are
+// converted to big integers when they are mixed with them. Division floors the quotient like the modulo operator
+// does, so that a == (a / b) * b + a % b. Comparing a big integer with a number requires big.cmp, since Lua only
+// compares values of the same type.
+func OpenBig(L *LState) int {
+	ut := RegisterType(L, bigIntClass, bigIntMethods)
+	L.SetFuncs(ut.Metatable, map[string]LGFunction{
+		"__add":      bigArith(func(z, x, y *big.Int) *big.Int { return z.Add(x, y) }),
+		"__sub":      bigArith(func(z, x, y *big.Int) *big.Int { return z.Sub(x, y) }),
+		"__mul":      bigArith(func(z, x, y *big.Int) *big.Int { return z.Mul(x, y) }),
+		"__div":      bigDivOp(false),
+		"__mod":      bigDivOp(true),
+		"__pow":      bigPow,
+		"__unm":      bigUnm,
+		"__eq":       bigEq,
+		"__lt":       bigLt,
+		"__le":       bigLe,
+		"__tostring": bigToString,
+		"__concat":   bigConcat,
+	})
+	mod := L.RegisterModule(BigLibName, bigFuncs)
+	L.Push(mod)
+	return 1
+}
+
+var bigFuncs = map[string]LGFunction{
+	"new":   bigNew,
+	"isbig": bigIsBig,
+	"cmp":   bigCmp,
+}
+
+var bigIntMethods = Methods[*big.Int]{
+	"tostring": func(L *LState, x *big.Int) int {
+		base := L.OptInt(2, 10)
+		if base < 2 || base > 62 {
+			L.ArgError(2, "base out of range")
+		}
+		L.Push(LString(x.Text(base)))
+		return 1
+	},
+	"tonumber": func(L *LState, x *big.Int) int {
+		f, _ := new(big.Float).SetInt(x).Float64()
+		L.Push(LNumber(f))
+		return 1
+	},
+	"sign": func(L *LState, x *big.Int) int {
+		L.Push(LNumber(x.Sign()))
+		return 1
+	},
+	"abs": func(L *LState, x *big.Int) int {
+		pushBig(L, new(big.Int).Abs(x))
+		return 1
+	},
+	"cmp": func(L *LState, x *big.Int) int {
+		L.Push(LNumber(x.Cmp(checkBig(L, 2))))
+		return 1
+	},
+	"divmod": func(L *LState, x *big.Int) int {
+		y := checkBig(L, 2)
+		if y.Sign() == 0 {
+			L.RaiseError("attempt to divide by zero")
+		}
+		q, m := floorDivMod(x, y)
+		pushBig(L, q)
+		pushBig(L, m)
+		return 2
+	},
+	"pow": func(L *LState, x *big.Int) int {
+		e := checkBig(L, 2)
+		if e.Sign() < 0 {
+			L.ArgError(2, "negative exponent")
+		}
+		var m *big.Int
+		if L.Get(3) != LNil {
+			if m = checkBig(L, 3); m.Sign() == 0 {
+				L.RaiseError("attempt to divide by zero")
+			}
+		}
+		pushBig(L, new(big.Int).Exp(x, e, m))
+		return 1
+	},
+}
+
+func bigType(L *LState) *UserType[*big.Int] {
+	return &UserType[*big.Int]{Name: bigIntClass, Metatable: L.GetTypeMetatable(bigIntClass).(*LTable)}
+}
+
+func pushBig(L *LState, x *big.Int) {
+	bigType(L).Push(L, x)
+}
+
+// toBig converts lv into a big integer. Numbers must be integral, and strings may have a base prefix like "0x".
+func toBig(L *LState, lv LValue) (*big.Int, bool) {
+	switch v := lv.(type) {
+	case *LUserData:
+		if x, ok := v.Value.(*big.Int); ok && v.Metatable == L.GetTypeMetatable(bigIntClass) {
+			return x, true
+		}
+	case LNumber:
+		f := float64(v)
+		if math.IsInf(f, 0) || math.IsNaN(f) || f != math.Trunc(f) {
+			return nil, false
+		}
+		x, _ := big.NewFloat(f).Int(nil)
+		return x, true
+	case LString:
+		return new(big.Int).SetString(string(v), 0)
+	}
+	return nil, false
+}
+
+func checkBig(L *LState, n int) *big.Int {
+	x, ok := toBig(L, L.Get(n))
+	if !ok {
+		L.ArgError(n, "integer expected, got "+L.Get(n).Type().String())
+	}
+	return x
+}
+
+// floorDivMod returns the quotient rounded towards negative infinity and the modulo with the sign of y.
+func floorDivMod(x, y *big.Int) (*big.Int, *big.Int) {
+	q, m := new(big.Int).QuoRem(x, y, new(big.Int))
+	if m.Sign() != 0 && m.Sign() != y.Sign() {
+		q.Sub(q, big.NewInt(1))
+		m.Add(m, y)
+	}
+	return q, m
+}
+
+func bigNew(L *LState) int {
+	if L.GetTop() > 1 {
+		base := L.CheckInt(2)
+		if base < 2 || base > 62 {
+			L.ArgError(2, "base out of range")
+		}
+		x, ok := new(big.Int).SetString(L.CheckString(1), base)
+		if !ok {
+			L.ArgError(1, "invalid integer")
+		}
+		pushBig(L, x)
+		return 1
+	}
+	L.CheckAny(1)
+	pushBig(L, new(big.Int).Set(checkBig(L, 1)))
+	return 1
+}
+
+func bigIsBig(L *LState) int {
+	_, ok := bigType(L).Test(L.Get(1))
+	L.Push(LBool(ok))
+	return 1
+}
+
+func bigCmp(L *LState) int {
+	L.Push(LNumber(checkBig(L, 1).Cmp(checkBig(L, 2))))
+	return 1
+}
+
+func bigArith(op func(z, x, y *big.Int) *big.Int) LGFunction {
+	return func(L *LState) int {
+		pushBig(L, op(new(big.Int), checkBig(L, 1), checkBig(L, 2)))
+		return 1
+	}
+}
+
+func bigDivOp(mod bool) LGFunction {
+	return func(L *LState) int {
+		x, y := checkBig(L, 1), checkBig(L, 2)
+		if y.Sign() == 0 {
+			L.RaiseError("attempt to divide by zero")
+		}
+		q, m := floorDivMod(x, y)
+		if mod {
+			pushBig(L, m)
+		} else {
+			pushBig(L, q)
+		}
+		return 1
+	}
+}
+
+func bigPow(L *LState) int {
+	x, e := checkBig(L, 1), checkBig(L, 2)
+	if e.Sign() < 0 {
+		L.RaiseError("attempt to raise a big integer to a negative power")
+	}
+	pushBig(L, new(big.Int).Exp(x, e, nil))
+	return 1
+}
+
+func bigUnm(L *LState) int {
+	pushBig(L, new(big.Int).Neg(checkBig(L, 1)))
+	return 1
+}
+
+func bigEq(L *LState) int {
+	L.Push(LBool(checkBig(L, 1).Cmp(checkBig(L, 2)) == 0))
+	return 1
+}
+
+func bigLt(L *LState) int {
+	L.Push(LBool(checkBig(L, 1).Cmp(checkBig(L, 2)) < 0))
+	return 1
+}
+
+func bigLe(L *LState) int {
+	L.Push(LBool(checkBig(L, 1).Cmp(checkBig(L, 2)) <= 0))
+	return 1
+}
+
+func bigToString(L *LState) int {
+	L.Push(LString(checkBig(L, 1).String()))
+	return 1
+}
+
+func bigConcat(L *LState) int {
+	var buf []byte
+	for i := 1; i <= 2; i++ {
+		lv := L.Get(i)
+		if x, ok := bigType(L).Test(lv); ok {
+			buf = append(buf, x.String()...)
+		} else if LVCanConvToString(lv) {
+			buf = append(buf, LVAsString(lv)...)
+		} else {
+			L.RaiseError("attempt to concatenate a %s value", lv.Type().String())
+		}
+	}
+	L.Push(LString(buf))
+	return 1
+}
+
+/* }}} */
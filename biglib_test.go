@@ -0,0 +1,33 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestBigLib(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.PreloadExtraLibs()
+	errorIfScriptFail(t, L, `assert(require("big") == big)`)
+	errorIfScriptFail(t, L, `
+local n = big.new("123456789012345678901234567890")
+assert(tostring(n * n) == "15241578753238836750495351562536198787501905199875019052100")
+assert(tostring(n + 1) == "123456789012345678901234567891" and tostring(1 + n) == "123456789012345678901234567891")
+assert(tostring(big.new(2) ^ 100) == "1267650600228229401496703205376")
+assert(tostring(big.new(-7) / 2) == "-4" and tostring(big.new(-7) % 2) == "1")
+local q, m = big.new(7):divmod(-2)
+assert(tostring(q) == "-4" and tostring(m) == "-1")
+assert(big.new(10) == big.new("10") and big.new(9) < big.new(10) and big.new(10) <= big.new(10))
+assert(big.cmp(n, 2^53) == 1 and big.new(5):cmp("5") == 0)
+assert(tostring(-big.new(5)) == "-5" and big.new(-5):abs():tonumber() == 5 and big.new(-5):sign() == -1)
+assert(big.new("ff", 16):tostring(2) == "11111111" and tostring(big.new("0x10")) == "16")
+assert(tostring(big.new(3):pow(200, 1000)) == "1")
+assert("n=" .. big.new(3) == "n=3" and big.new(3) .. "!" == "3!")
+assert(big.isbig(n) and not big.isbig(1))
+assert(tostring(big.new(2^60)) == "1152921504606846976")
+`)
+	errorIfScriptNotFail(t, L, `return big.new(1) / 0`, "attempt to divide by zero")
+	errorIfScriptNotFail(t, L, `return big.new(1.5)`, "integer expected")
+	errorIfScriptNotFail(t, L, `return big.new("12x")`, "integer expected")
+	errorIfScriptNotFail(t, L, `return big.new(2) ^ -1`, "negative power")
+}
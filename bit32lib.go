@@ -0,0 +1,147 @@
+package lua
+
+import (
+	"math"
+)
+
+/* bit32 library {{{ */
+
+// OpenBit32 opens the bit32 library of Lua 5.2. Numbers are converted into unsigned 32-bit integers modulo 2^32,
+// and results are numbers in [0, 2^32).
+func OpenBit32(L *LState) int {
+	mod := L.RegisterModule(Bit32LibName, bit32Funcs)
+	L.Push(mod)
+	return 1
+}
+
+var bit32Funcs = map[string]LGFunction{
+	"arshift": bit32Arshift,
+	"band":    bit32Band,
+	"bnot":    bit32Bnot,
+	"bor":     bit32Bor,
+	"btest":   bit32Btest,
+	"bxor":    bit32Bxor,
+	"extract": bit32Extract,
+	"replace": bit32Replace,
+	"lrotate": bit32Lrotate,
+	"lshift":  bit32Lshift,
+	"rrotate": bit32Rrotate,
+	"rshift":  bit32Rshift,
+}
+
+const bit32Bits = 32
+
+func checkUnsigned(L *LState, n int) uint32 {
+	f := math.Floor(float64(L.CheckNumber(n)))
+	f = math.Mod(f, 1<<32)
+	if f < 0 {
+		f += 1 << 32
+	}
+	return uint32(f)
+}
+
+func pushUnsigned(L *LState, v uint32) int {
+	L.PushNumber(LNumber(v))
+	return 1
+}
+
+func bit32Reduce(L *LState, init uint32, op func(uint32, uint32) uint32) uint32 {
+	r := init
+	for i := 1; i <= L.GetTop(); i++ {
+		r = op(r, checkUnsigned(L, i))
+	}
+	return r
+}
+
+func bit32Band(L *LState) int {
+	return pushUnsigned(L, bit32Reduce(L, math.MaxUint32, func(r, v uint32) uint32 { return r & v }))
+}
+
+func bit32Bor(L *LState) int {
+	return pushUnsigned(L, bit32Reduce(L, 0, func(r, v uint32) uint32 { return r | v }))
+}
+
+func bit32Bxor(L *LState) int {
+	return pushUnsigned(L, bit32Reduce(L, 0, func(r, v uint32) uint32 { return r ^ v }))
+}
+
+func bit32Btest(L *LState) int {
+	L.Push(LBool(bit32Reduce(L, math.MaxUint32, func(r, v uint32) uint32 { return r & v }) != 0))
+	return 1
+}
+
+func bit32Bnot(L *LState) int {
+	return pushUnsigned(L, ^checkUnsigned(L, 1))
+}
+
+// bit32Shift shifts x left by disp bits, or right if disp is negative.
+func bit32Shift(x uint32, disp int) uint32 {
+	switch {
+	case disp <= -bit32Bits || disp >= bit32Bits:
+		return 0
+	case disp < 0:
+		return x >> uint(-disp)
+	}
+	return x << uint(disp)
+}
+
+func bit32Lshift(L *LState) int {
+	return pushUnsigned(L, bit32Shift(checkUnsigned(L, 1), L.CheckInt(2)))
+}
+
+func bit32Rshift(L *LState) int {
+	return pushUnsigned(L, bit32Shift(checkUnsigned(L, 1), -L.CheckInt(2)))
+}
+
+func bit32Arshift(L *LState) int {
+	x, disp := checkUnsigned(L, 1), L.CheckInt(2)
+	if disp < 0 || x&(1<<31) == 0 {
+		return pushUnsigned(L, bit32Shift(x, -disp))
+	}
+	if disp >= bit32Bits {
+		return pushUnsigned(L, math.MaxUint32)
+	}
+	return pushUnsigned(L, x>>uint(disp)|^(math.MaxUint32>>uint(disp)))
+}
+
+func bit32Rotate(x uint32, disp int) uint32 {
+	disp &= bit32Bits - 1
+	return x<<uint(disp) | x>>uint(bit32Bits-disp)
+}
+
+func bit32Lrotate(L *LState) int {
+	return pushUnsigned(L, bit32Rotate(checkUnsigned(L, 1), L.CheckInt(2)))
+}
+
+func bit32Rrotate(L *LState) int {
+	return pushUnsigned(L, bit32Rotate(checkUnsigned(L, 1), -L.CheckInt(2)))
+}
+
+// checkField checks the field and width arguments of extract and replace starting at argument n.
+func checkField(L *LState, n int) (int, uint32) {
+	field, width := L.CheckInt(n), L.OptInt(n+1, 1)
+	if field < 0 {
+		L.ArgError(n, "field cannot be negative")
+	}
+	if width <= 0 {
+		L.ArgError(n+1, "width must be positive")
+	}
+	if field+width > bit32Bits {
+		L.RaiseError("trying to access non-existent bits")
+	}
+	return field, math.MaxUint32 >> uint(bit32Bits-width)
+}
+
+func bit32Extract(L *LState) int {
+	x := checkUnsigned(L, 1)
+	field, mask := checkField(L, 2)
+	return pushUnsigned(L, x>>uint(field)&mask)
+}
+
+func bit32Replace(L *LState) int {
+	x, v := checkUnsigned(L, 1), checkUnsigned(L, 2)
+	field, mask := checkField(L, 3)
+	return pushUnsigned(L, x&^(mask<<uint(field))|(v&mask)<<uint(field))
+}
+
+/* }}} */
@@ -0,0 +1,36 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestBit32Lib(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.PreloadExtraLibs()
+	errorIfScriptFail(t, L, `assert(require("bit32") == bit32)`)
+	errorIfScriptFail(t, L, `
+assert(bit32.band() == bit32.bnot(0) and bit32.btest() == true and bit32.bor() == 0 and bit32.bxor() == 0)
+assert(bit32.band(1, 2) == 0 and bit32.band(-1, 1, 2, 3) == 0 and bit32.bor(1, 2, 4) == 7 and bit32.bxor(3, 5) == 6)
+assert(not bit32.btest(1, 2) and bit32.btest(3, 2))
+assert(bit32.bnot(0) == 0xffffffff and bit32.bnot(-1) == 0 and bit32.bnot(2^32) == 0xffffffff)
+assert(bit32.band(2^32 + 5) == 5 and bit32.band(-2^32 - 1) == 0xffffffff)
+assert(bit32.lshift(1, 31) == 2^31 and bit32.lshift(0x12345678, 4) == 0x23456780)
+assert(bit32.lshift(0x12345678, -4) == 0x01234567 and bit32.lshift(0x12345678, 32) == 0 and bit32.lshift(0x12345678, -32) == 0)
+assert(bit32.rshift(0x12345678, 4) == 0x01234567 and bit32.rshift(0x12345678, -4) == 0x23456780)
+assert(bit32.arshift(0x12345678, 1) == 0x091a2b3c and bit32.arshift(0xffffffff, 1) == 0xffffffff)
+assert(bit32.arshift(0x80000000, 1) == 0xc0000000 and bit32.arshift(-1, 40) == 0xffffffff)
+assert(bit32.arshift(0x12345678, -1) == 0x2468acf0)
+assert(bit32.lrotate(0x12345678, 4) == 0x23456781 and bit32.rrotate(0x12345678, -4) == 0x23456781)
+assert(bit32.lrotate(0x12345678, 36) == 0x23456781 and bit32.rrotate(0x12345678, 4) == 0x81234567)
+assert(bit32.extract(0x12345678, 0, 4) == 8 and bit32.extract(0x12345678, 4, 4) == 7)
+assert(bit32.extract(0xa0001111, 28, 4) == 0xa and bit32.extract(0xa0001111, 31) == 1)
+assert(bit32.extract(0xa0001111, 0, 32) == 0xa0001111)
+assert(bit32.replace(0x12345678, 5, 28, 4) == 0x52345678 and bit32.replace(0x12345678, 0x87654321, 0, 32) == 0x87654321)
+assert(bit32.replace(0, 1, 2) == 2^2 and bit32.replace(-1, 0, 31) == 2^31 - 1)
+`)
+	errorIfScriptNotFail(t, L, `bit32.extract(0, -1)`, "field cannot be negative")
+	errorIfScriptNotFail(t, L, `bit32.extract(0, 0, 0)`, "width must be positive")
+	errorIfScriptNotFail(t, L, `bit32.extract(0, 31, 2)`, "trying to access non-existent bits")
+	errorIfScriptNotFail(t, L, `bit32.band("x")`, "number expected")
+}
@@ -0,0 +1,238 @@
+package lua
+
+import (
+	"bytes"
+	"fmt"
+)
+
+/* bytes library {{{ */
+
+const bytesClass = "bytes"
+
+// Bytes is a byte slice shared by Go and Lua without copying. Scripts see changes made to Data by Go, and Go sees
+// changes made by scripts; appending may move Data to a larger array like Go's append does.
+type Bytes struct {
+	Data []byte
+}
+
+// NewBytes returns a bytes userdata for data. The userdata shares data with the caller.
+func (ls *LState) NewBytes(data []byte) *LUserData {
+	return bytesType(ls).New(ls, &Bytes{Data: data})
+}
+
+// CheckBytes checks whether the given argument is a bytes userdata and returns it.
+func (ls *LState) CheckBytes(n int) *Bytes {
+	return bytesType(ls).Check(ls, n)
+}
+
+// ToBytes returns the bytes of the given argument, or nil if it is not a bytes userdata.
+func (ls *LState) ToBytes(n int) *Bytes {
+	b, _ := testBytes(ls, ls.Get(n))
+	return b
+}
+
+func testBytes(L *LState, lv LValue) (*Bytes, bool) {
+	if ud, ok := lv.(*LUserData); ok && ud.Metatable == L.GetTypeMetatable(bytesClass) {
+		b, ok := ud.Value.(*Bytes)
+		return b, ok
+	}
+	return nil, false
+}
+
+// pushBytes pushes a bytes userdata for data. The bytes library must be open.
+func pushBytes(L *LState, data []byte) {
+	ud := L.NewUserData()
+	ud.Value = &Bytes{Data: data}
+	ud.Metatable = L.GetTypeMetatable(bytesClass)
+	L.Push(ud)
+}
+
+func bytesType(L *LState) *UserType[*Bytes] {
+	mt, ok := L.GetTypeMetatable(bytesClass).(*LTable)
+	if !ok {
+		// the library has not been opened
+		mt = registerBytesType(L).Metatable
+	}
+	return &UserType[*Bytes]{Name: bytesClass, Metatable: mt}
+}
+
+// OpenBytes opens the bytes library. Bytes are mutable byte arrays that are indexed from 1 like strings:
+//
+//	local b = bytes.new("hello")
+//	b[1] = 72                  -- b:tostring() == "Hello"
+//	local tail = b:sub(2)      -- shares the storage of b
+//
+// Bytes handed in by the host with `LState.NewBytes` share the storage with the host.
+func OpenBytes(L *LState) int {
+	bytesType(L)
+	mod := L.RegisterModule(BytesLibName, bytesFuncs)
+	L.Push(mod)
+	return 1
+}
+
+func registerBytesType(L *LState) *UserType[*Bytes] {
+	ut := RegisterType(L, bytesClass, bytesMethods)
+	methods := ut.Metatable.RawGetString("__index")
+	L.SetFuncs(ut.Metatable, map[string]LGFunction{
+		"__index": func(L *LState) int {
+			b := ut.Check(L, 1)
+			if key, ok := L.Get(2).(LNumber); ok {
+				if i := int(key); LNumber(i) == key && i >= 1 && i <= len(b.Data) {
+					L.Push(LNumber(b.Data[i-1]))
+				} else {
+					L.Push(LNil)
+				}
+				return 1
+			}
+			L.Push(L.GetField(methods, L.CheckString(2)))
+			return 1
+		},
+		"__newindex": func(L *LState) int {
+			b := ut.Check(L, 1)
+			i := L.CheckInt(2)
+			if i < 1 || i > len(b.Data) {
+				L.ArgError(2, "index out of range")
+			}
+			b.Data[i-1] = checkByte(L, 3)
+			return 0
+		},
+		"__len": func(L *LState) int {
+			L.Push(LNumber(len(ut.Check(L, 1).Data)))
+			return 1
+		},
+		"__eq": func(L *LState) int {
+			L.Push(LBool(bytes.Equal(ut.Check(L, 1).Data, ut.Check(L, 2).Data)))
+			return 1
+		},
+		"__tostring": func(L *LState) int {
+			L.Push(LString(ut.Check(L, 1).Data))
+			return 1
+		},
+		"__concat": func(L *LState) int {
+			var buf []byte
+			for i := 1; i <= 2; i++ {
+				buf = append(buf, checkByteSlice(L, i)...)
+			}
+			L.Push(LString(buf))
+			return 1
+		},
+	})
+	return ut
+}
+
+var bytesFuncs = map[string]LGFunction{
+	"new": func(L *LState) int {
+		switch lv := L.Get(1).(type) {
+		case LNumber:
+			if lv < 0 {
+				L.ArgError(1, "size must not be negative")
+			}
+			L.Push(L.NewBytes(make([]byte, int(lv))))
+		default:
+			L.Push(L.NewBytes(bytes.Clone(checkByteSlice(L, 1))))
+		}
+		return 1
+	},
+	"isbytes": func(L *LState) int {
+		L.Push(LBool(L.ToBytes(1) != nil))
+		return 1
+	},
+}
+
+var bytesMethods = Methods[*Bytes]{
+	"len": func(L *LState, b *Bytes) int {
+		L.Push(LNumber(len(b.Data)))
+		return 1
+	},
+	// sub returns the bytes from i to j, sharing the storage with b.
+	"sub": func(L *LState, b *Bytes) int {
+		start, end := bytesRange(L, len(b.Data), 2)
+		pushBytes(L, b.Data[start:end:end])
+		return 1
+	},
+	"tostring": func(L *LState, b *Bytes) int {
+		start, end := bytesRange(L, len(b.Data), 2)
+		L.Push(LString(b.Data[start:end]))
+		return 1
+	},
+	"append": func(L *LState, b *Bytes) int {
+		for i := 2; i <= L.GetTop(); i++ {
+			b.Data = append(b.Data, checkByteSlice(L, i)...)
+		}
+		L.Push(L.Get(1))
+		return 1
+	},
+	// copy copies src to b starting at position i, and returns the number of bytes copied.
+	"copy": func(L *LState, b *Bytes) int {
+		i := L.CheckInt(2)
+		if i < 1 || i > len(b.Data)+1 {
+			L.ArgError(2, "index out of range")
+		}
+		L.Push(LNumber(copy(b.Data[i-1:], checkByteSlice(L, 3))))
+		return 1
+	},
+	"clone": func(L *LState, b *Bytes) int {
+		pushBytes(L, bytes.Clone(b.Data))
+		return 1
+	},
+	"fill": func(L *LState, b *Bytes) int {
+		v := checkByte(L, 2)
+		for i := range b.Data {
+			b.Data[i] = v
+		}
+		return 0
+	},
+	// find returns the positions of the first occurrence of the plain string s at or after init.
+	"find": func(L *LState, b *Bytes) int {
+		s := checkByteSlice(L, 2)
+		init := L.OptInt(3, 1)
+		if init < 0 {
+			init = len(b.Data) + init + 1
+		}
+		init = intMin(intMax(init-1, 0), len(b.Data))
+		pos := bytes.Index(b.Data[init:], s)
+		if pos < 0 {
+			L.Push(LNil)
+			return 1
+		}
+		L.Push(LNumber(init + pos + 1))
+		L.Push(LNumber(init + pos + len(s)))
+		return 2
+	},
+}
+
+// bytesRange returns the range of the arguments i(default 1) and j(default -1) at n and n+1, which follow the rules
+// of string.sub.
+func bytesRange(L *LState, l int, n int) (int, int) {
+	start, end := L.OptInt(n, 1), L.OptInt(n+1, -1)
+	if start < 0 {
+		start = l + start + 1
+	}
+	if end < 0 {
+		end = l + end + 1
+	}
+	start, end = intMax(start-1, 0), intMin(end, l)
+	if start > end {
+		return start, start
+	}
+	return intMin(start, l), end
+}
+
+func checkByte(L *LState, n int) byte {
+	v := L.CheckInt(n)
+	if v < 0 || v > 255 {
+		L.ArgError(n, fmt.Sprintf("byte value %d out of range", v))
+	}
+	return byte(v)
+}
+
+// checkByteSlice returns the contents of a bytes userdata or a string at n without copying. The contents of
+// strings must not be modified.
+func checkByteSlice(L *LState, n int) []byte {
+	if b, ok := testBytes(L, L.Get(n)); ok {
+		return b.Data
+	}
+	return unsafeFastStringToReadOnlyBytes(L.CheckString(n))
+}
+
+/* }}} */
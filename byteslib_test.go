@@ -0,0 +1,42 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestBytesLib(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.PreloadExtraLibs()
+	errorIfScriptFail(t, L, `assert(require("bytes") == bytes)`)
+	errorIfScriptFail(t, L, `
+local b = bytes.new("hello")
+assert(#b == 5 and b:len() == 5 and b[1] == 104 and b[6] == nil and b[0] == nil)
+b[1] = 72
+assert(tostring(b) == "Hello" and b:tostring(2, -2) == "ell")
+local tail = b:sub(2)
+tail[1] = 69
+assert(tostring(b) == "HEllo" and tostring(tail) == "Ello")
+tail:append("!")
+assert(tostring(tail) == "Ello!" and tostring(b) == "HEllo")
+assert(b:append(" ", bytes.new("world")) == b and tostring(b) == "HEllo world")
+assert(b:find("o") == 5 and select(2, b:find("wor")) == 9 and b:find("o", 6) == 8 and b:find("x") == nil)
+assert(b:copy(1, "he") == 2 and b:tostring() == "hello world")
+local c = b:clone()
+c:fill(0)
+assert(c[1] == 0 and b[1] == 104 and b == bytes.new("hello world") and b ~= c)
+assert("<" .. b:sub(1, 5) .. ">" == "<hello>")
+assert(#bytes.new(3) == 3 and bytes.isbytes(b) and not bytes.isbytes("x"))
+`)
+	errorIfScriptNotFail(t, L, `bytes.new("x")[2] = 1`, "index out of range")
+	errorIfScriptNotFail(t, L, `bytes.new("x")[1] = 256`, "out of range")
+
+	data := []byte("payload")
+	L.SetGlobal("data", L.NewBytes(data))
+	errorIfScriptFail(t, L, `data[1] = string.byte("P")`)
+	errorIfNotEqual(t, "Payload", string(data))
+	data[1] = 'A'
+	errorIfScriptFail(t, L, `assert(tostring(data) == "PAyload")`)
+	L.Push(L.GetGlobal("data"))
+	errorIfNotEqual(t, &data[0], &L.CheckBytes(-1).Data[0])
+}
@@ -37,6 +37,7 @@ var channelFuncs = map[string]LGFunction{
 
 func channelMake(L *LState) int {
 	buffer := L.OptInt(1, 0)
+	L.enforcePolicy("channel.make", LNumber(buffer))
 	L.Push(LChannel(make(chan LValue, buffer)))
 	return 1
 }
@@ -91,19 +92,24 @@ func channelSelect(L *LState) int {
 		})
 	}
 
-	pos, recv, rok := reflect.Select(cases)
+	selected := L.Nondeterministic("channel.select", func() []LValue {
+		pos, recv, rok := reflect.Select(cases)
+		lv := LNil
+		if recv.Kind() != 0 {
+			lv, _ = recv.Interface().(LValue)
+			if lv == nil {
+				lv = LNil
+			}
+		}
+		return []LValue{LNumber(pos), lv, LBool(rok)}
+	})
+	pos := int(selected[0].(LNumber))
+	lv := selected[1]
+	rok := selected[2] == LTrue
 
 	if L.ctx != nil && pos == L.GetTop() {
 		return 0
 	}
-
-	lv := LNil
-	if recv.Kind() != 0 {
-		lv, _ = recv.Interface().(LValue)
-		if lv == nil {
-			lv = LNil
-		}
-	}
 	tbl := L.Get(pos + 1).(*LTable)
 	last := tbl.RawGetInt(tbl.Len())
 	if last.Type() == LTFunction {
@@ -142,29 +148,30 @@ var channelMethods = map[string]LGFunction{
 
 func channelReceive(L *LState) int {
 	rch := checkChannel(L, 1)
-	var v reflect.Value
-	var ok bool
-	if L.ctx != nil {
-		cases := []reflect.SelectCase{{
-			Dir:  reflect.SelectRecv,
-			Chan: reflect.ValueOf(L.ctx.Done()),
-			Send: reflect.ValueOf(nil),
-		}, {
-			Dir:  reflect.SelectRecv,
-			Chan: rch,
-			Send: reflect.ValueOf(nil),
-		}}
-		_, v, ok = reflect.Select(cases)
-	} else {
-		v, ok = rch.Recv()
-	}
-	if ok {
-		L.Push(LTrue)
-		L.Push(v.Interface().(LValue))
-	} else {
-		L.Push(LFalse)
-		L.Push(LNil)
-	}
+	received := L.Nondeterministic("channel.receive", func() []LValue {
+		var v reflect.Value
+		var ok bool
+		if L.ctx != nil {
+			cases := []reflect.SelectCase{{
+				Dir:  reflect.SelectRecv,
+				Chan: reflect.ValueOf(L.ctx.Done()),
+				Send: reflect.ValueOf(nil),
+			}, {
+				Dir:  reflect.SelectRecv,
+				Chan: rch,
+				Send: reflect.ValueOf(nil),
+			}}
+			_, v, ok = reflect.Select(cases)
+		} else {
+			v, ok = rch.Recv()
+		}
+		if ok {
+			return []LValue{LTrue, v.Interface().(LValue)}
+		}
+		return []LValue{LFalse, LNil}
+	})
+	L.Push(received[0])
+	L.Push(received[1])
 	return 2
 }
 
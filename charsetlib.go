@@ -0,0 +1,77 @@
+//go:build charset
+
+package lua
+
+import (
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+/* charset library {{{ */
+
+// The charset library is only built with the "charset" build tag, since it depends on golang.org/x/text.
+func init() {
+	luaLibs = append(luaLibs, luaLib{CharsetLibName, OpenCharset})
+}
+
+// OpenCharset opens the charset library for converting strings between character encodings, e.g.
+// charset.convert(payload, "Shift_JIS", "UTF-8"). Encodings are looked up by their IANA names and aliases, e.g.
+// "UTF-8", "ISO-8859-1", "latin1", "Shift_JIS", "GBK" or "windows-1252".
+func OpenCharset(L *LState) int {
+	mod := L.RegisterModule(CharsetLibName, charsetFuncs)
+	L.Push(mod)
+	return 1
+}
+
+var charsetFuncs = map[string]LGFunction{
+	"convert": charsetConvert,
+	"name":    charsetName,
+}
+
+func checkEncoding(L *LState, n int) encoding.Encoding {
+	name := L.CheckString(n)
+	enc, err := ianaindex.IANA.Encoding(name)
+	if err != nil || enc == nil {
+		L.ArgError(n, "unsupported encoding '"+name+"'")
+	}
+	return enc
+}
+
+// charsetConvert converts a string from one encoding to another. Bytes that are invalid in the source encoding are
+// decoded as U+FFFD. Characters that the target encoding cannot represent make it return nil and an error message,
+// or are replaced by the replacement character of the encoding if the fourth argument is true.
+func charsetConvert(L *LState) int {
+	str := L.CheckString(1)
+	from := checkEncoding(L, 2)
+	to := checkEncoding(L, 3)
+	decoded, err := from.NewDecoder().String(str)
+	if err == nil {
+		encoder := to.NewEncoder()
+		if LVAsBool(L.Get(4)) {
+			encoder = encoding.ReplaceUnsupported(encoder)
+		}
+		decoded, err = encoder.String(decoded)
+	}
+	if err != nil {
+		L.Push(LNil)
+		L.Push(LString(err.Error()))
+		return 2
+	}
+	L.Push(LString(decoded))
+	return 1
+}
+
+// charsetName returns the canonical IANA name of an encoding, or nil if it is not supported.
+func charsetName(L *LState) int {
+	enc, err := ianaindex.IANA.Encoding(L.CheckString(1))
+	if err == nil && enc != nil {
+		if name, err := ianaindex.IANA.Name(enc); err == nil {
+			L.Push(LString(name))
+			return 1
+		}
+	}
+	L.Push(LNil)
+	return 1
+}
+
+/* }}} */
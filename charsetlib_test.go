@@ -0,0 +1,30 @@
+//go:build charset
+
+package lua
+
+import (
+	"testing"
+)
+
+func TestCharsetLib(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+local utf8 = "abc \230\151\165\230\156\172" -- "abc 日本"
+assert(charset.convert("caf\233", "latin1", "UTF-8") == "caf\195\169")
+assert(charset.convert("caf\195\169", "UTF-8", "ISO-8859-1") == "caf\233")
+local sjis = charset.convert(utf8, "UTF-8", "Shift_JIS")
+assert(sjis == "abc \147\250\150\123")
+assert(charset.convert(sjis, "Shift_JIS", "UTF-8") == utf8)
+local gbk = charset.convert("\230\151\165\230\156\172", "UTF-8", "GBK")
+assert(gbk == "\200\213\177\190" and charset.convert(gbk, "gbk", "utf-8") == "\230\151\165\230\156\172")
+
+local s, err = charset.convert("\230\151\165", "UTF-8", "latin1")
+assert(s == nil and string.find(err, "not supported"))
+assert(charset.convert("a\230\151\165", "UTF-8", "latin1", true) == "a\26")
+
+assert(charset.name("latin1") == "ISO_8859-1:1987" and charset.name("shift_jis") == "Shift_JIS")
+assert(charset.name("no-such-encoding") == nil)
+`)
+	errorIfScriptNotFail(t, L, `charset.convert("x", "no-such-encoding", "UTF-8")`, "unsupported encoding 'no-such-encoding'")
+}
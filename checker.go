@@ -0,0 +1,96 @@
+package lua
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/r0kyi/gopher-lua/ast"
+	"github.com/r0kyi/gopher-lua/parse"
+)
+
+/* chunk checker {{{ */
+
+// Checker checks chunks after they are parsed and before they are compiled(see `Options.Checker`), e.g. a type
+// checker for annotations in comments. Check is called with the source and the syntax tree of every chunk loaded
+// by `LState.Load` and its variants, including chunks loaded by scripts, and returns the problems it found. A
+// chunk with diagnostics is not compiled, and loading it fails with a `CheckError`.
+type Checker interface {
+	Check(L *LState, name string, source []byte, chunk []ast.Stmt) []Diagnostic
+}
+
+// CheckerFunc is an adapter to use a function as a Checker.
+type CheckerFunc func(L *LState, name string, source []byte, chunk []ast.Stmt) []Diagnostic
+
+// Check calls fn.
+func (fn CheckerFunc) Check(L *LState, name string, source []byte, chunk []ast.Stmt) []Diagnostic {
+	return fn(L, name, source, chunk)
+}
+
+// SourceStripper can be implemented by a Checker to remove annotations from the source before it is parsed, e.g.
+// type annotations the parser does not accept like "local x: number = 1". Strip should replace annotations with
+// spaces and keep line breaks, so that the lines of errors and debug information stay the same. Check is still
+// called with the original source.
+type SourceStripper interface {
+	Strip(name string, source []byte) ([]byte, error)
+}
+
+// Diagnostic is a problem a Checker found in a chunk.
+type Diagnostic struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+// CheckError is the error loading a chunk fails with if the Checker of the state reports diagnostics. It is the
+// Cause of the ApiError returned by Load.
+type CheckError struct {
+	Name        string
+	Diagnostics []Diagnostic
+}
+
+func (e *CheckError) Error() string {
+	var buf strings.Builder
+	for i, diag := range e.Diagnostics {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		fmt.Fprintf(&buf, "%s line:%d(column:%d): %s", e.Name, diag.Line, diag.Column, diag.Message)
+	}
+	return buf.String()
+}
+
+// parseChunk parses the chunk read from reader and runs the Checker of the state on it.
+func (ls *LState) parseChunk(reader io.Reader, name string) ([]ast.Stmt, error) {
+	return ls.parseChecked(reader, name, parse.Parse)
+}
+
+// parseChecked parses the source read from reader with parser and runs the Checker of the state on the result.
+func (ls *LState) parseChecked(reader io.Reader, name string,
+	parser func(io.Reader, string) ([]ast.Stmt, error)) ([]ast.Stmt, error) {
+	checker := ls.Options.Checker
+	if checker == nil {
+		return parser(reader, name)
+	}
+	source, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	parsed := source
+	if stripper, ok := checker.(SourceStripper); ok {
+		if parsed, err = stripper.Strip(name, source); err != nil {
+			return nil, err
+		}
+	}
+	chunk, err := parser(bytes.NewReader(parsed), name)
+	if err != nil {
+		return nil, err
+	}
+	if diags := checker.Check(ls, name, source, chunk); len(diags) > 0 {
+		return nil, &CheckError{Name: name, Diagnostics: diags}
+	}
+	return chunk, nil
+}
+
+/* }}} */
@@ -0,0 +1,100 @@
+package lua
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/r0kyi/gopher-lua/ast"
+)
+
+// annotationChecker strips annotations like "local x: number = 1" and checks that the annotated locals are
+// initialized with constants of the annotated types.
+type annotationChecker struct{}
+
+var annotationPattern = regexp.MustCompile(`local (\w+): (\w+)`)
+
+func (annotationChecker) Strip(name string, source []byte) ([]byte, error) {
+	return annotationPattern.ReplaceAllFunc(source, func(m []byte) []byte {
+		sub := annotationPattern.FindSubmatch(m)
+		return []byte("local " + string(sub[1]) + strings.Repeat(" ", len(sub[2])+2))
+	}), nil
+}
+
+func (annotationChecker) Check(L *LState, name string, source []byte, chunk []ast.Stmt) []Diagnostic {
+	types := make(map[string]string)
+	for _, m := range annotationPattern.FindAllSubmatch(source, -1) {
+		types[string(m[1])] = string(m[2])
+	}
+	var diags []Diagnostic
+	for _, stmt := range chunk {
+		local, ok := stmt.(*ast.LocalAssignStmt)
+		if !ok {
+			continue
+		}
+		for i, name := range local.Names {
+			typ, ok := types[name]
+			if !ok || i >= len(local.Exprs) {
+				continue
+			}
+			actual := "unknown"
+			switch local.Exprs[i].(type) {
+			case *ast.NumberExpr:
+				actual = "number"
+			case *ast.StringExpr:
+				actual = "string"
+			}
+			if actual != typ {
+				diags = append(diags, Diagnostic{Line: stmt.Line(), Message: name + " is " + typ + ", got " + actual})
+			}
+		}
+	}
+	return diags
+}
+
+func TestChecker(t *testing.T) {
+	L := NewState(Options{Checker: annotationChecker{}})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local n: number = 10
+	local s: string = "a"
+	assert(n + #s == 11)
+	`)
+
+	err := L.DoString("local x = 1\nlocal n: number = 'ten'")
+	errorIfNil(t, err)
+	var checkErr *CheckError
+	errorIfFalse(t, errors.As(err.(*ApiError).Cause, &checkErr), "CheckError expected, got %v", err)
+	errorIfNotEqual(t, 1, len(checkErr.Diagnostics))
+	errorIfNotEqual(t, 2, checkErr.Diagnostics[0].Line)
+	errorIfNotEqual(t, "<string> line:2(column:0): n is number, got string", checkErr.Error())
+
+	// chunks loaded by scripts are checked too
+	errorIfScriptFail(t, L, `
+	local f, err = loadstring("local n" .. ": number = 'ten'")
+	assert(f == nil and err:find("n is number, got string"))
+	`)
+}
+
+func TestCheckerFunc(t *testing.T) {
+	var names []string
+	L := NewState(Options{Checker: CheckerFunc(func(L *LState, name string, source []byte, chunk []ast.Stmt) []Diagnostic {
+		names = append(names, name)
+		if strings.Contains(string(source), "forbidden") {
+			return []Diagnostic{{Line: 1, Message: "forbidden"}}
+		}
+		return nil
+	})})
+	defer L.Close()
+	errorIfScriptFail(t, L, `assert(loadstring("return 1", "inner"))`)
+	v, err := L.Eval("1 + 1")
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, LNumber(2), v)
+	errorIfNotEqual(t, "<string>,inner,<eval>", strings.Join(names, ","))
+	// expressions evaluated by Eval are checked too
+	_, err = L.Eval("forbidden")
+	var checkErr *CheckError
+	errorIfFalse(t, err != nil && errors.As(err.(*ApiError).Cause, &checkErr), "CheckError expected, got %v", err)
+	errorIfNotEqual(t, ApiErrorSyntax, err.(*ApiError).Type)
+}
@@ -57,6 +57,7 @@ Available options are:
 
 	L := lua.NewState()
 	defer L.Close()
+	L.PreloadExtraLibs()
 	if opt_m > 0 {
 		L.SetMx(opt_m)
 	}
@@ -127,9 +128,11 @@ func doREPL(L *lua.LState) {
 		panic(err)
 	}
 	defer rl.Close()
+	// local variables are kept between lines
+	session := L.NewSession()
 	for {
 		if str, err := loadline(rl, L); err == nil {
-			if err := L.DoString(str); err != nil {
+			if _, err := session.DoString(str); err != nil {
 				fmt.Println(err)
 			}
 		} else { // error on loadline
@@ -0,0 +1,594 @@
+// Package codegen compiles Lua chunks into Go source calling the APIs of the lua package, so that rarely changing,
+// performance critical scripts can be compiled into a program instead of being interpreted.
+//
+// A chunk is compiled into a function of type lua.LGFunction, which runs the chunk when it is called:
+//
+//	chunk, err := parse.Parse(file, "scripts/price.lua")
+//	src, err := codegen.Generate(chunk, "scripts/price.lua", codegen.Options{Package: "scripts", Func: "Price"})
+//	// write src to scripts/price.go, then
+//	L.Push(L.NewFunction(scripts.Price))
+//	L.Call(0, lua.MultRet)
+//
+// Only a subset of Lua is supported: variable arguments(...), goto and labels are rejected. Local variables become
+// Go variables and functions become Go closures, so generated functions have no upvalues and can not be inspected
+// by the debug library, and errors raised by them have no line information. The values of __concat metamethods are
+// converted to strings. Generated code imports this package for its runtime functions.
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"math"
+	"strconv"
+	"strings"
+
+	lua "github.com/r0kyi/gopher-lua"
+	"github.com/r0kyi/gopher-lua/ast"
+)
+
+/* generator {{{ */
+
+// Options configures the generated source.
+type Options struct {
+	// Package is the name of the package of the generated source. This defaults to "main".
+	Package string
+	// Func is the name of the generated function. This defaults to "Chunk".
+	Func string
+}
+
+// Error is the error returned by Generate for chunks that use unsupported features.
+type Error struct {
+	Source  string
+	Line    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s line:%d: %s", e.Source, e.Line, e.Message)
+}
+
+// Generate compiles the chunk named name into formatted Go source.
+func Generate(chunk []ast.Stmt, name string, opts Options) (src []byte, err error) {
+	if opts.Package == "" {
+		opts.Package = "main"
+	}
+	if opts.Func == "" {
+		opts.Func = "Chunk"
+	}
+	g := &generator{source: name, names: make(map[string]int)}
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			if gerr, ok := rcv.(*Error); ok {
+				src, err = nil, gerr
+				return
+			}
+			panic(rcv)
+		}
+	}()
+	body := g.funcBody(nil, chunk)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by codegen from %s. DO NOT EDIT.\n\n", name)
+	fmt.Fprintf(&buf, "package %s\n\n", opts.Package)
+	buf.WriteString("import (\n\tlua \"github.com/r0kyi/gopher-lua\"\n")
+	if g.runtime {
+		buf.WriteString("\t\"github.com/r0kyi/gopher-lua/codegen\"\n")
+	}
+	buf.WriteString(")\n\n")
+	fmt.Fprintf(&buf, "// %s runs %s.\n", opts.Func, name)
+	fmt.Fprintf(&buf, "func %s(L *lua.LState) int %s\n", opts.Func, body)
+	return format.Source(buf.Bytes())
+}
+
+type generator struct {
+	source string
+	buf    *bytes.Buffer
+	// scopes map the names of the local variables of the enclosing blocks to Go variables.
+	scopes []map[string]string
+	// names counts the Go variables declared for a Lua name, to name shadowing variables apart.
+	names   map[string]int
+	temps   int
+	runtime bool
+}
+
+func (g *generator) fail(node ast.PositionHolder, format string, args ...interface{}) {
+	panic(&Error{Source: g.source, Line: node.Line(), Message: fmt.Sprintf(format, args...)})
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(g.buf, format, args...)
+}
+
+// rt returns the qualified name of the runtime function name.
+func (g *generator) rt(name string) string {
+	g.runtime = true
+	return "codegen." + name
+}
+
+func (g *generator) temp() string {
+	g.temps++
+	return "t" + strconv.Itoa(g.temps)
+}
+
+func (g *generator) enterScope() {
+	g.scopes = append(g.scopes, make(map[string]string))
+}
+
+func (g *generator) leaveScope() {
+	g.scopes = g.scopes[:len(g.scopes)-1]
+}
+
+// declare returns a new Go variable for the local variable name. It is visible once bind is called. Shadowing
+// variables are numbered before the name, as in l2_name, since Lua names can not start with a digit.
+func (g *generator) declare(name string) string {
+	g.names[name]++
+	if n := g.names[name]; n > 1 {
+		return "l" + strconv.Itoa(n) + "_" + name
+	}
+	return "l_" + name
+}
+
+func (g *generator) bind(name, goname string) {
+	g.scopes[len(g.scopes)-1][name] = goname
+}
+
+func (g *generator) lookup(name string) (string, bool) {
+	for i := len(g.scopes) - 1; i >= 0; i-- {
+		if goname, ok := g.scopes[i][name]; ok {
+			return goname, true
+		}
+	}
+	return "", false
+}
+
+// funcBody returns the body of a Go function running stmts with the parameters params.
+func (g *generator) funcBody(params []string, stmts []ast.Stmt) string {
+	outer := g.buf
+	g.buf = &bytes.Buffer{}
+	defer func() { g.buf = outer }()
+	g.enterScope()
+	g.printf("{\n")
+	for i, param := range params {
+		goname := g.declare(param)
+		g.printf("var %s lua.LValue = L.Get(%d)\n_ = %s\n", goname, i+1, goname)
+		g.bind(param, goname)
+	}
+	g.stmts(stmts)
+	if len(stmts) == 0 {
+		g.printf("return 0\n")
+	} else if _, ok := stmts[len(stmts)-1].(*ast.ReturnStmt); !ok {
+		g.printf("return 0\n")
+	}
+	g.printf("}")
+	g.leaveScope()
+	return g.buf.String()
+}
+
+// block generates stmts in a scope of their own. The caller writes the braces.
+func (g *generator) block(stmts []ast.Stmt) {
+	g.enterScope()
+	g.stmts(stmts)
+	g.leaveScope()
+}
+
+/* }}} */
+
+/* statements {{{ */
+
+func (g *generator) stmts(stmts []ast.Stmt) {
+	for _, stmt := range stmts {
+		g.stmt(stmt)
+	}
+}
+
+func (g *generator) stmt(stmt ast.Stmt) {
+	switch st := stmt.(type) {
+	case *ast.LocalAssignStmt:
+		g.localAssign(st)
+	case *ast.AssignStmt:
+		g.assign(st)
+	case *ast.FuncCallStmt:
+		g.printf("%s\n", g.multi(st.Expr))
+	case *ast.DoBlockStmt:
+		g.printf("{\n")
+		g.block(st.Stmts)
+		g.printf("}\n")
+	case *ast.WhileStmt:
+		if cond := g.cond(st.Condition); cond == "true" {
+			g.printf("for {\n")
+		} else {
+			g.printf("for %s {\n", cond)
+		}
+		g.block(st.Stmts)
+		g.printf("}\n")
+	case *ast.RepeatStmt:
+		// the condition can refer to the local variables of the body
+		g.printf("for {\n")
+		g.enterScope()
+		g.stmts(st.Stmts)
+		g.printf("if %s {\nbreak\n}\n", g.cond(st.Condition))
+		g.leaveScope()
+		g.printf("}\n")
+	case *ast.IfStmt:
+		g.ifStmt(st)
+		g.printf("\n")
+	case *ast.NumberForStmt:
+		g.numberFor(st)
+	case *ast.GenericForStmt:
+		g.genericFor(st)
+	case *ast.FuncDefStmt:
+		if st.Name.Func == nil {
+			g.printf("L.SetField(%s, %s, %s)\n", g.expr(st.Name.Receiver), strconv.Quote(st.Name.Method),
+				g.function(st.Func, true))
+		} else {
+			g.assign(&ast.AssignStmt{Lhs: []ast.Expr{st.Name.Func}, Rhs: []ast.Expr{st.Func}})
+		}
+	case *ast.ReturnStmt:
+		if len(st.Exprs) == 0 {
+			g.printf("return 0\n")
+		} else {
+			g.printf("return %s\n", g.call("Return", "L", st.Exprs))
+		}
+	case *ast.BreakStmt:
+		g.printf("break\n")
+	case *ast.LabelStmt, *ast.GotoStmt:
+		g.fail(stmt, "goto is not supported")
+	default:
+		g.fail(stmt, "unsupported statement %T", stmt)
+	}
+}
+
+func (g *generator) localAssign(st *ast.LocalAssignStmt) {
+	if len(st.Names) == 1 && len(st.Exprs) == 1 {
+		if fn, ok := st.Exprs[0].(*ast.FunctionExpr); ok {
+			// like the compiler, the function can refer to itself
+			goname := g.declare(st.Names[0])
+			g.bind(st.Names[0], goname)
+			g.printf("var %s lua.LValue\n%s = %s\n_ = %s\n", goname, goname, g.function(fn, false), goname)
+			return
+		}
+	}
+	values := g.values(st.Exprs, len(st.Names))
+	gonames := make([]string, len(st.Names))
+	for i, name := range st.Names {
+		gonames[i] = g.declare(name)
+	}
+	g.printf("var %s lua.LValue = %s\n", strings.Join(gonames, ", "), strings.Join(values, ", "))
+	g.printf("%s = %s\n", strings.Repeat("_, ", len(gonames)-1)+"_", strings.Join(gonames, ", "))
+	for i, name := range st.Names {
+		g.bind(name, gonames[i])
+	}
+}
+
+// values evaluates exprs and returns the Go expressions of n values adjusted like assignments adjust them.
+func (g *generator) values(exprs []ast.Expr, n int) []string {
+	if len(exprs) == n && (n == 0 || !isMulti(exprs[n-1])) {
+		values := make([]string, n)
+		for i, expr := range exprs {
+			values[i] = g.expr(expr)
+		}
+		return values
+	}
+	t := g.temp()
+	g.printf("%s := %s\n", t, g.list(exprs))
+	values := make([]string, n)
+	for i := range values {
+		values[i] = fmt.Sprintf("%s(%s, %d)", g.rt("At"), t, i)
+	}
+	return values
+}
+
+func (g *generator) assign(st *ast.AssignStmt) {
+	if len(st.Lhs) == 1 && len(st.Rhs) == 1 {
+		g.store(st.Lhs[0], nil, g.expr(st.Rhs[0]))
+		return
+	}
+	// evaluate the tables and keys of the targets and all values before assigning
+	g.printf("{\n")
+	targets := make([][]string, len(st.Lhs))
+	for i, lhs := range st.Lhs {
+		if attr, ok := lhs.(*ast.AttrGetExpr); ok {
+			obj, key := g.temp(), g.temp()
+			g.printf("%s, %s := %s, %s\n", obj, key, g.expr(attr.Object), g.expr(attr.Key))
+			targets[i] = []string{obj, key}
+		}
+	}
+	values := g.values(st.Rhs, len(st.Lhs))
+	temps := make([]string, len(values))
+	for i, value := range values {
+		temps[i] = g.temp()
+		g.printf("%s := %s\n", temps[i], value)
+	}
+	for i, lhs := range st.Lhs {
+		g.store(lhs, targets[i], temps[i])
+	}
+	g.printf("}\n")
+}
+
+// store assigns value to the variable or field lhs. target holds the table and the key of a field if they are
+// evaluated already.
+func (g *generator) store(lhs ast.Expr, target []string, value string) {
+	switch ex := lhs.(type) {
+	case *ast.IdentExpr:
+		if goname, ok := g.lookup(ex.Value); ok {
+			g.printf("%s = %s\n", goname, value)
+		} else {
+			g.printf("L.SetGlobal(%s, %s)\n", strconv.Quote(ex.Value), value)
+		}
+	case *ast.AttrGetExpr:
+		if target != nil {
+			g.printf("L.SetTable(%s, %s, %s)\n", target[0], target[1], value)
+		} else if key, ok := ex.Key.(*ast.StringExpr); ok {
+			g.printf("L.SetField(%s, %s, %s)\n", g.expr(ex.Object), strconv.Quote(key.Value), value)
+		} else {
+			g.printf("L.SetTable(%s, %s, %s)\n", g.expr(ex.Object), g.expr(ex.Key), value)
+		}
+	default:
+		g.fail(lhs, "cannot assign to %T", lhs)
+	}
+}
+
+func (g *generator) ifStmt(st *ast.IfStmt) {
+	g.printf("if %s {\n", g.cond(st.Condition))
+	g.block(st.Then)
+	g.printf("}")
+	if len(st.Else) == 1 {
+		if elseif, ok := st.Else[0].(*ast.IfStmt); ok {
+			g.printf(" else ")
+			g.ifStmt(elseif)
+			return
+		}
+	}
+	if len(st.Else) > 0 {
+		g.printf(" else {\n")
+		g.block(st.Else)
+		g.printf("}")
+	}
+}
+
+func (g *generator) numberFor(st *ast.NumberForStmt) {
+	init, limit, step := g.temp(), g.temp(), g.temp()
+	stepExpr := "lua.LNumber(1)"
+	if st.Step != nil {
+		stepExpr = g.forNumber(st.Step, "step")
+	}
+	g.printf("for %s, %s, %s := %s, %s, %s; ", init, limit, step, g.forNumber(st.Init, "init"),
+		g.forNumber(st.Limit, "limit"), stepExpr)
+	g.printf("%s > 0 && %s <= %s || %s <= 0 && %s >= %s; %s += %s {\n", step, init, limit, step, init, limit, init, step)
+	g.enterScope()
+	goname := g.declare(st.Name)
+	g.printf("var %s lua.LValue = %s\n_ = %s\n", goname, init, goname)
+	g.bind(st.Name, goname)
+	g.stmts(st.Stmts)
+	g.leaveScope()
+	g.printf("}\n")
+}
+
+// forNumber returns a Go expression of type lua.LNumber holding the value of the part what of a numeric for loop.
+func (g *generator) forNumber(expr ast.Expr, what string) string {
+	if _, ok := expr.(*ast.NumberExpr); ok {
+		return g.expr(expr)
+	}
+	return g.rt("ForNumber") + "(L, " + g.expr(expr) + ", " + strconv.Quote(what) + ")"
+}
+
+func (g *generator) genericFor(st *ast.GenericForStmt) {
+	g.printf("{\n")
+	values := g.values(st.Exprs, 3)
+	fn, state, control := g.temp(), g.temp(), g.temp()
+	g.printf("%s, %s, %s := %s, %s, %s\n", fn, state, control, values[0], values[1], values[2])
+	results := g.temp()
+	g.printf("for {\n%s := %s(L, %s, %s, %s)\n", results, g.rt("Call"), fn, state, control)
+	g.printf("if %s = %s(%s, 0); %s == lua.LNil {\nbreak\n}\n", control, g.rt("At"), results, control)
+	g.enterScope()
+	gonames := make([]string, len(st.Names))
+	values = make([]string, len(st.Names))
+	for i, name := range st.Names {
+		gonames[i] = g.declare(name)
+		values[i] = fmt.Sprintf("%s(%s, %d)", g.rt("At"), results, i)
+	}
+	g.printf("var %s lua.LValue = %s\n", strings.Join(gonames, ", "), strings.Join(values, ", "))
+	g.printf("%s = %s\n", strings.Repeat("_, ", len(gonames)-1)+"_", strings.Join(gonames, ", "))
+	for i, name := range st.Names {
+		g.bind(name, gonames[i])
+	}
+	g.stmts(st.Stmts)
+	g.leaveScope()
+	g.printf("}\n}\n")
+}
+
+/* }}} */
+
+/* expressions {{{ */
+
+func isMulti(expr ast.Expr) bool {
+	switch ex := expr.(type) {
+	case *ast.FuncCallExpr:
+		return !ex.AdjustRet
+	case *ast.Comma3Expr:
+		return !ex.AdjustRet
+	}
+	return false
+}
+
+// list returns a Go expression of type []lua.LValue holding the values of exprs.
+func (g *generator) list(exprs []ast.Expr) string {
+	if n := len(exprs); n > 0 && isMulti(exprs[n-1]) {
+		last := g.multi(exprs[n-1])
+		if n == 1 {
+			return last
+		}
+		return "append([]lua.LValue{" + g.args(exprs[:n-1]) + "}, " + last + "...)"
+	}
+	return "[]lua.LValue{" + g.args(exprs) + "}"
+}
+
+// args returns the Go arguments of a variadic function called with the values of exprs.
+func (g *generator) args(exprs []ast.Expr) string {
+	if len(exprs) == 0 || !isMulti(exprs[len(exprs)-1]) {
+		values := make([]string, len(exprs))
+		for i, expr := range exprs {
+			values[i] = g.expr(expr)
+		}
+		return strings.Join(values, ", ")
+	}
+	return g.list(exprs) + "..."
+}
+
+// call returns a call of the runtime function name with the arguments first followed by the values of exprs.
+func (g *generator) call(name, first string, exprs []ast.Expr) string {
+	if args := g.args(exprs); args != "" {
+		return g.rt(name) + "(" + first + ", " + args + ")"
+	}
+	return g.rt(name) + "(" + first + ")"
+}
+
+// multi returns a Go expression of type []lua.LValue holding all results of the call expr.
+func (g *generator) multi(expr ast.Expr) string {
+	switch ex := expr.(type) {
+	case *ast.FuncCallExpr:
+		if ex.Func != nil {
+			return g.call("Call", "L, "+g.expr(ex.Func), ex.Args)
+		}
+		return g.call("Method", "L, "+g.expr(ex.Receiver)+", "+strconv.Quote(ex.Method), ex.Args)
+	case *ast.Comma3Expr:
+		g.fail(expr, "variable arguments are not supported")
+	}
+	return "[]lua.LValue{" + g.expr(expr) + "}"
+}
+
+var arithOps = map[string]string{
+	"+": "lua.OP_ADD", "-": "lua.OP_SUB", "*": "lua.OP_MUL", "/": "lua.OP_DIV", "%": "lua.OP_MOD", "^": "lua.OP_POW",
+}
+
+// expr returns a Go expression of type lua.LValue holding the value of expr.
+func (g *generator) expr(expr ast.Expr) string {
+	switch ex := expr.(type) {
+	case *ast.NilExpr:
+		return "lua.LNil"
+	case *ast.TrueExpr:
+		return "lua.LTrue"
+	case *ast.FalseExpr:
+		return "lua.LFalse"
+	case *ast.NumberExpr:
+		n := float64(lua.LVAsNumber(lua.LString(ex.Value)))
+		if math.IsInf(n, 0) || math.IsNaN(n) {
+			g.fail(expr, "number %s is out of range", ex.Value)
+		}
+		return "lua.LNumber(" + strconv.FormatFloat(n, 'g', -1, 64) + ")"
+	case *ast.StringExpr:
+		return "lua.LString(" + strconv.Quote(ex.Value) + ")"
+	case *ast.IdentExpr:
+		if goname, ok := g.lookup(ex.Value); ok {
+			return goname
+		}
+		return "L.GetGlobal(" + strconv.Quote(ex.Value) + ")"
+	case *ast.AttrGetExpr:
+		if key, ok := ex.Key.(*ast.StringExpr); ok {
+			return "L.GetField(" + g.expr(ex.Object) + ", " + strconv.Quote(key.Value) + ")"
+		}
+		return "L.GetTable(" + g.expr(ex.Object) + ", " + g.expr(ex.Key) + ")"
+	case *ast.FuncCallExpr:
+		if ex.Func != nil {
+			return g.call("Call1", "L, "+g.expr(ex.Func), ex.Args)
+		}
+		return g.call("Method1", "L, "+g.expr(ex.Receiver)+", "+strconv.Quote(ex.Method), ex.Args)
+	case *ast.Comma3Expr:
+		g.fail(expr, "variable arguments are not supported")
+	case *ast.TableExpr:
+		return g.table(ex)
+	case *ast.FunctionExpr:
+		return g.function(ex, false)
+	case *ast.LogicalOpExpr:
+		cond := "!lua.LVAsBool(v)"
+		if ex.Operator == "or" {
+			cond = "lua.LVAsBool(v)"
+		}
+		return fmt.Sprintf("func() lua.LValue {\nif v := %s; %s {\nreturn v\n}\nreturn %s\n}()", g.expr(ex.Lhs), cond,
+			g.expr(ex.Rhs))
+	case *ast.RelationalOpExpr, *ast.UnaryNotOpExpr:
+		return "lua.LBool(" + g.cond(expr) + ")"
+	case *ast.StringConcatOpExpr:
+		values := []string{g.expr(ex.Lhs)}
+		rhs := ex.Rhs
+		for {
+			concat, ok := rhs.(*ast.StringConcatOpExpr)
+			if !ok {
+				break
+			}
+			values = append(values, g.expr(concat.Lhs))
+			rhs = concat.Rhs
+		}
+		values = append(values, g.expr(rhs))
+		return "lua.LString(L.Concat(" + strings.Join(values, ", ") + "))"
+	case *ast.ArithmeticOpExpr:
+		return "L.Arith(" + arithOps[ex.Operator] + ", " + g.expr(ex.Lhs) + ", " + g.expr(ex.Rhs) + ")"
+	case *ast.UnaryMinusOpExpr:
+		return "L.Arith(lua.OP_UNM, " + g.expr(ex.Expr) + ", lua.LNil)"
+	case *ast.UnaryLenOpExpr:
+		return "lua.LNumber(L.ObjLen(" + g.expr(ex.Expr) + "))"
+	}
+	g.fail(expr, "unsupported expression %T", expr)
+	return ""
+}
+
+// cond returns a Go expression of type bool holding the truth value of expr.
+func (g *generator) cond(expr ast.Expr) string {
+	switch ex := expr.(type) {
+	case *ast.TrueExpr:
+		return "true"
+	case *ast.RelationalOpExpr:
+		lhs, rhs := g.expr(ex.Lhs), g.expr(ex.Rhs)
+		switch ex.Operator {
+		case "==":
+			return "L.Equal(" + lhs + ", " + rhs + ")"
+		case "~=":
+			return "!L.Equal(" + lhs + ", " + rhs + ")"
+		case "<":
+			return "L.LessThan(" + lhs + ", " + rhs + ")"
+		case "<=":
+			return "L.LessThanOrEqual(" + lhs + ", " + rhs + ")"
+		case ">":
+			return g.rt("Greater") + "(L, " + lhs + ", " + rhs + ")"
+		case ">=":
+			return g.rt("GreaterOrEqual") + "(L, " + lhs + ", " + rhs + ")"
+		}
+	case *ast.UnaryNotOpExpr:
+		return "!" + g.cond(ex.Expr)
+	}
+	return "lua.LVAsBool(" + g.expr(expr) + ")"
+}
+
+func (g *generator) table(ex *ast.TableExpr) string {
+	var fields []string
+	var rest string
+	for i, field := range ex.Fields {
+		switch {
+		case field.Key != nil:
+			fields = append(fields, g.expr(field.Key), g.expr(field.Value))
+		case i == len(ex.Fields)-1 && isMulti(field.Value):
+			rest = ", " + g.multi(field.Value) + "..."
+		default:
+			fields = append(fields, "nil", g.expr(field.Value))
+		}
+	}
+	if len(fields) == 0 {
+		return g.rt("Table") + "(L, nil" + rest + ")"
+	}
+	return g.rt("Table") + "(L, []lua.LValue{" + strings.Join(fields, ", ") + "}" + rest + ")"
+}
+
+// function returns a Go expression creating the function ex. Methods have an implicit self parameter.
+func (g *generator) function(ex *ast.FunctionExpr, method bool) string {
+	if ex.ParList.HasVargs {
+		g.fail(ex, "variable arguments are not supported")
+	}
+	params := ex.ParList.Names
+	if method {
+		params = append([]string{"self"}, params...)
+	}
+	return "L.NewFunction(func(L *lua.LState) int " + g.funcBody(params, ex.Stmts) + ")"
+}
+
+/* }}} */
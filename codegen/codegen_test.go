@@ -0,0 +1,76 @@
+package codegen
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/r0kyi/gopher-lua/parse"
+)
+
+var update = flag.Bool("update", false, "rewrite internal/example/example.go")
+
+// TestGenerateExample checks that internal/example/example.go, which is tested against the interpreter in its own
+// package, is the source generated from internal/example/example.lua.
+func TestGenerateExample(t *testing.T) {
+	lua, err := os.ReadFile("internal/example/example.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk, err := parse.Parse(bytes.NewReader(lua), "example.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := Generate(chunk, "example.lua", Options{Package: "example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		if err := os.WriteFile("internal/example/example.go", src, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := os.ReadFile("internal/example/example.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, expected) {
+		t.Errorf("internal/example/example.go is out of date, run go test ./codegen -update")
+	}
+}
+
+func TestGenerateUnsupported(t *testing.T) {
+	for src, msg := range map[string]string{
+		"local a = ...":                           "x.lua line:1: variable arguments are not supported",
+		"local f = function(...) end":             "x.lua line:1: variable arguments are not supported",
+		"\n::top:: goto top":                      "x.lua line:2: goto is not supported",
+		"return select('#', (function(...) end))": "x.lua line:1: variable arguments are not supported",
+	} {
+		chunk, err := parse.Parse(strings.NewReader(src), "x.lua")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Generate(chunk, "x.lua", Options{})
+		if err == nil || err.Error() != msg {
+			t.Errorf("%q: expected %q, got %v", src, msg, err)
+		}
+	}
+}
+
+func TestGenerateOptions(t *testing.T) {
+	chunk, err := parse.Parse(strings.NewReader("print(1)"), "x.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := Generate(chunk, "x.lua", Options{Package: "scripts", Func: "Print"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"package scripts\n", "func Print(L *lua.LState) int {"} {
+		if !strings.Contains(string(src), s) {
+			t.Errorf("%q not found in\n%s", s, src)
+		}
+	}
+}
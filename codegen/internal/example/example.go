@@ -0,0 +1,163 @@
+// Code generated by codegen from example.lua. DO NOT EDIT.
+
+package example
+
+import (
+	lua "github.com/r0kyi/gopher-lua"
+	"github.com/r0kyi/gopher-lua/codegen"
+)
+
+// Chunk runs example.lua.
+func Chunk(L *lua.LState) int {
+	var l_fib lua.LValue
+	l_fib = L.NewFunction(func(L *lua.LState) int {
+		var l_n lua.LValue = L.Get(1)
+		_ = l_n
+		if L.LessThan(l_n, lua.LNumber(2)) {
+			return codegen.Return(L, l_n)
+		}
+		return codegen.Return(L, L.Arith(lua.OP_ADD, codegen.Call1(L, l_fib, L.Arith(lua.OP_SUB, l_n, lua.LNumber(1))), codegen.Call1(L, l_fib, L.Arith(lua.OP_SUB, l_n, lua.LNumber(2)))))
+	})
+	_ = l_fib
+	var l_Account lua.LValue = codegen.Table(L, nil)
+	_ = l_Account
+	L.SetField(l_Account, "__index", l_Account)
+	L.SetField(l_Account, "new", L.NewFunction(func(L *lua.LState) int {
+		var l_balance lua.LValue = L.Get(1)
+		_ = l_balance
+		return codegen.Return(L, codegen.Call(L, L.GetGlobal("setmetatable"), codegen.Table(L, []lua.LValue{lua.LString("balance"), l_balance, lua.LString("log"), codegen.Table(L, nil)}), l_Account)...)
+	}))
+	L.SetField(l_Account, "deposit", L.NewFunction(func(L *lua.LState) int {
+		var l_self lua.LValue = L.Get(1)
+		_ = l_self
+		var l_amount lua.LValue = L.Get(2)
+		_ = l_amount
+		if L.LessThanOrEqual(l_amount, lua.LNumber(0)) {
+			codegen.Call(L, L.GetGlobal("error"), lua.LString("invalid amount"))
+		}
+		L.SetField(l_self, "balance", L.Arith(lua.OP_ADD, L.GetField(l_self, "balance"), l_amount))
+		L.SetTable(L.GetField(l_self, "log"), L.Arith(lua.OP_ADD, lua.LNumber(L.ObjLen(L.GetField(l_self, "log"))), lua.LNumber(1)), lua.LString(L.Concat(lua.LString("deposit "), l_amount)))
+		return 0
+	}))
+	var l_counter lua.LValue
+	l_counter = L.NewFunction(func(L *lua.LState) int {
+		var l2_n lua.LValue = lua.LNumber(0)
+		_ = l2_n
+		return codegen.Return(L, L.NewFunction(func(L *lua.LState) int {
+			l2_n = L.Arith(lua.OP_ADD, l2_n, lua.LNumber(1))
+			return codegen.Return(L, l2_n)
+		}))
+	})
+	_ = l_counter
+	t1 := codegen.Call(L, L.GetField(l_Account, "new"), lua.LNumber(10))
+	var l_acc lua.LValue = codegen.At(t1, 0)
+	_ = l_acc
+	codegen.Method(L, l_acc, "deposit", lua.LNumber(5))
+	t2 := codegen.Call(L, L.GetGlobal("pcall"), L.GetField(l_acc, "deposit"), l_acc, L.Arith(lua.OP_UNM, lua.LNumber(1), lua.LNil))
+	var l_ok, l_err lua.LValue = codegen.At(t2, 0), codegen.At(t2, 1)
+	_, _ = l_ok, l_err
+	var l_squares, l_sum lua.LValue = codegen.Table(L, nil), lua.LNumber(0)
+	_, _ = l_squares, l_sum
+	for t3, t4, t5 := lua.LNumber(1), lua.LNumber(10), lua.LNumber(1); t5 > 0 && t3 <= t4 || t5 <= 0 && t3 >= t4; t3 += t5 {
+		var l_i lua.LValue = t3
+		_ = l_i
+		L.SetTable(l_squares, l_i, L.Arith(lua.OP_MUL, l_i, l_i))
+	}
+	{
+		t6 := codegen.Call(L, L.GetGlobal("ipairs"), l_squares)
+		t7, t8, t9 := codegen.At(t6, 0), codegen.At(t6, 1), codegen.At(t6, 2)
+		for {
+			t10 := codegen.Call(L, t7, t8, t9)
+			if t9 = codegen.At(t10, 0); t9 == lua.LNil {
+				break
+			}
+			var l__, l_v lua.LValue = codegen.At(t10, 0), codegen.At(t10, 1)
+			_, _ = l__, l_v
+			l_sum = L.Arith(lua.OP_ADD, l_sum, l_v)
+		}
+	}
+	var l_keys lua.LValue = codegen.Table(L, nil)
+	_ = l_keys
+	{
+		t11 := codegen.Call(L, L.GetGlobal("pairs"), codegen.Table(L, []lua.LValue{lua.LString("a"), lua.LNumber(1), lua.LString("b"), lua.LNumber(2), lua.LString("c"), lua.LNumber(3)}))
+		t12, t13, t14 := codegen.At(t11, 0), codegen.At(t11, 1), codegen.At(t11, 2)
+		for {
+			t15 := codegen.Call(L, t12, t13, t14)
+			if t14 = codegen.At(t15, 0); t14 == lua.LNil {
+				break
+			}
+			var l_k lua.LValue = codegen.At(t15, 0)
+			_ = l_k
+			L.SetTable(l_keys, L.Arith(lua.OP_ADD, lua.LNumber(L.ObjLen(l_keys)), lua.LNumber(1)), l_k)
+		}
+	}
+	codegen.Call(L, L.GetField(L.GetGlobal("table"), "sort"), l_keys)
+	t16 := codegen.Call(L, l_counter)
+	var l_next_id lua.LValue = codegen.At(t16, 0)
+	_ = l_next_id
+	codegen.Call(L, l_next_id)
+	var l_x lua.LValue = lua.LNumber(1)
+	_ = l_x
+	{
+		var l2_x lua.LValue = L.Arith(lua.OP_ADD, l_x, lua.LNumber(1))
+		_ = l2_x
+		var l_x_2 lua.LValue = l2_x
+		_ = l_x_2
+		l2_x = L.Arith(lua.OP_MUL, l2_x, lua.LNumber(10))
+		l_sum = L.Arith(lua.OP_ADD, L.Arith(lua.OP_ADD, l_sum, l2_x), l_x_2)
+	}
+	var l2_i, l_evens lua.LValue = lua.LNumber(0), codegen.Table(L, nil)
+	_, _ = l2_i, l_evens
+	for {
+		l2_i = L.Arith(lua.OP_ADD, l2_i, lua.LNumber(1))
+		if codegen.Greater(L, l2_i, lua.LNumber(6)) {
+			break
+		} else if L.Equal(L.Arith(lua.OP_MOD, l2_i, lua.LNumber(2)), lua.LNumber(0)) {
+			L.SetTable(l_evens, L.Arith(lua.OP_ADD, lua.LNumber(L.ObjLen(l_evens)), lua.LNumber(1)), l2_i)
+		}
+	}
+	for {
+		var l_done lua.LValue = lua.LBool(codegen.GreaterOrEqual(L, l2_i, lua.LNumber(10)))
+		_ = l_done
+		l2_i = L.Arith(lua.OP_ADD, l2_i, lua.LNumber(1))
+		if lua.LVAsBool(l_done) {
+			break
+		}
+	}
+	t17 := codegen.Call(L, L.NewFunction(func(L *lua.LState) int {
+		return codegen.Return(L, lua.LNumber(1), lua.LNumber(2), lua.LNumber(3))
+	}))
+	var l_a, l_b, l_c lua.LValue = codegen.At(t17, 0), codegen.At(t17, 1), codegen.At(t17, 2)
+	_, _, _ = l_a, l_b, l_c
+	{
+		t18 := l_b
+		t19 := l_a
+		l_a = t18
+		l_b = t19
+	}
+	var l_t lua.LValue = codegen.Table(L, []lua.LValue{nil, l_a, nil, l_b, nil, l_c}, codegen.Call(L, L.GetField(L.GetGlobal("string"), "byte"), lua.LString("AB"), lua.LNumber(1), lua.LNumber(2))...)
+	_ = l_t
+	var l_order lua.LValue = codegen.Table(L, nil)
+	_ = l_order
+	var l_add lua.LValue
+	l_add = L.NewFunction(func(L *lua.LState) int {
+		var l2_v lua.LValue = L.Get(1)
+		_ = l2_v
+		L.SetTable(l_order, L.Arith(lua.OP_ADD, lua.LNumber(L.ObjLen(l_order)), lua.LNumber(1)), l2_v)
+		return codegen.Return(L, l2_v)
+	})
+	_ = l_add
+	var l_mixed lua.LValue = codegen.Table(L, []lua.LValue{codegen.Call1(L, l_add, lua.LNumber(1)), codegen.Call1(L, l_add, lua.LString("a")), nil, codegen.Call1(L, l_add, lua.LString("b")), codegen.Call1(L, l_add, lua.LString("k")), codegen.Call1(L, l_add, lua.LString("c")), nil, codegen.Call1(L, l_add, lua.LString("d"))}, codegen.Call(L, l_add, codegen.Call(L, L.GetField(L.GetGlobal("string"), "byte"), lua.LString("E"), lua.LNumber(1))...)...)
+	_ = l_mixed
+	return codegen.Return(L, codegen.Call1(L, l_fib, lua.LNumber(15)), L.GetField(l_acc, "balance"), L.GetTable(L.GetField(l_acc, "log"), lua.LNumber(1)), l_ok, lua.LBool(!L.Equal(l_err, lua.LNil)), l_sum, codegen.Call1(L, L.GetField(L.GetGlobal("table"), "concat"), l_keys, lua.LString(",")), codegen.Call1(L, l_next_id), l_x, codegen.Call1(L, L.GetField(L.GetGlobal("table"), "concat"), l_evens, lua.LString(",")), l2_i, lua.LNumber(L.ObjLen(l_t)), lua.LString(L.Concat(L.GetTable(l_t, lua.LNumber(1)), L.GetTable(l_t, lua.LNumber(5)))), lua.LBool(!lua.LVAsBool(lua.LNil)), L.Arith(lua.OP_UNM, l_x, lua.LNil), L.Arith(lua.OP_POW, lua.LNumber(2), lua.LNumber(10)), L.Arith(lua.OP_MOD, lua.LNumber(7), lua.LNumber(3)), func() lua.LValue {
+		if v := func() lua.LValue {
+			if v := lua.LNil; !lua.LVAsBool(v) {
+				return v
+			}
+			return lua.LNumber(1)
+		}(); lua.LVAsBool(v) {
+			return v
+		}
+		return lua.LString("default")
+	}(), codegen.Call1(L, L.GetField(L.GetGlobal("table"), "concat"), l_order, lua.LString(",")), lua.LString(L.Concat(L.GetTable(l_mixed, lua.LNumber(1)), L.GetTable(l_mixed, lua.LNumber(2)), L.GetTable(l_mixed, lua.LNumber(3)), L.GetField(l_mixed, "k"))))
+}
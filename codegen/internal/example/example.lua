@@ -0,0 +1,86 @@
+-- example.lua is compiled into example.go by the tests of the codegen package.
+local function fib(n)
+  if n < 2 then
+    return n
+  end
+  return fib(n - 1) + fib(n - 2)
+end
+
+local Account = {}
+Account.__index = Account
+
+function Account.new(balance)
+  return setmetatable({balance = balance, log = {}}, Account)
+end
+
+function Account:deposit(amount)
+  if amount <= 0 then
+    error("invalid amount")
+  end
+  self.balance = self.balance + amount
+  self.log[#self.log + 1] = "deposit " .. amount
+end
+
+local function counter()
+  local n = 0
+  return function()
+    n = n + 1
+    return n
+  end
+end
+
+local acc = Account.new(10)
+acc:deposit(5)
+local ok, err = pcall(acc.deposit, acc, -1)
+
+local squares, sum = {}, 0
+for i = 1, 10 do
+  squares[i] = i * i
+end
+for _, v in ipairs(squares) do
+  sum = sum + v
+end
+
+local keys = {}
+for k in pairs({a = 1, b = 2, c = 3}) do
+  keys[#keys + 1] = k
+end
+table.sort(keys)
+
+local next_id = counter()
+next_id()
+local x = 1
+do
+  local x = x + 1
+  local x_2 = x
+  x = x * 10
+  sum = sum + x + x_2
+end
+
+local i, evens = 0, {}
+while true do
+  i = i + 1
+  if i > 6 then
+    break
+  elseif i % 2 == 0 then
+    evens[#evens + 1] = i
+  end
+end
+repeat
+  local done = i >= 10
+  i = i + 1
+until done
+
+local a, b, c = (function() return 1, 2, 3 end)()
+a, b = b, a
+local t = {a, b, c, string.byte("AB", 1, 2)}
+local order = {}
+local function add(v)
+  order[#order + 1] = v
+  return v
+end
+local mixed = {[add(1)] = add("a"), add("b"), [add("k")] = add("c"), add("d"), add(string.byte("E", 1))}
+
+return fib(15), acc.balance, acc.log[1], ok, err ~= nil, sum, table.concat(keys, ","), next_id(), x,
+  table.concat(evens, ","), i, #t, t[1] .. t[5], not nil, -x, 2 ^ 10, 7 % 3, nil and 1 or "default",
+  table.concat(order, ","), mixed[1] .. mixed[2] .. mixed[3] .. mixed.k
@@ -0,0 +1,64 @@
+package example
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	lua "github.com/r0kyi/gopher-lua"
+)
+
+// runExample runs example.lua in a new state, interpreted or as the generated Chunk, and returns its results.
+func runExample(t testing.TB, src string, generated bool) []lua.LValue {
+	L := lua.NewState()
+	defer L.Close()
+	if generated {
+		L.Push(L.NewFunction(Chunk))
+	} else {
+		fn, err := L.LoadString(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		L.Push(fn)
+	}
+	if err := L.PCall(0, lua.MultRet, nil); err != nil {
+		t.Fatal(err)
+	}
+	values := make([]lua.LValue, L.GetTop())
+	for i := range values {
+		values[i] = L.Get(i + 1)
+	}
+	return values
+}
+
+func readExample(t testing.TB) string {
+	src, err := os.ReadFile("example.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(src)
+}
+
+// TestChunk checks that the generated Chunk returns the same values as the interpreter running example.lua.
+func TestChunk(t *testing.T) {
+	src := readExample(t)
+	expected := runExample(t, src, false)
+	actual := runExample(t, src, true)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func BenchmarkInterpreted(b *testing.B) {
+	src := readExample(b)
+	for i := 0; i < b.N; i++ {
+		runExample(b, src, false)
+	}
+}
+
+func BenchmarkGenerated(b *testing.B) {
+	src := readExample(b)
+	for i := 0; i < b.N; i++ {
+		runExample(b, src, true)
+	}
+}
@@ -0,0 +1,118 @@
+package codegen
+
+import (
+	lua "github.com/r0kyi/gopher-lua"
+)
+
+/* runtime {{{ */
+
+// The functions below are called by generated code. They are exported for generated code only, and may change
+// together with the generator.
+
+// Call calls fn with args and returns all its results.
+func Call(L *lua.LState, fn lua.LValue, args ...lua.LValue) []lua.LValue {
+	top := L.GetTop()
+	L.Push(fn)
+	for _, arg := range args {
+		L.Push(arg)
+	}
+	L.Call(len(args), lua.MultRet)
+	values := make([]lua.LValue, L.GetTop()-top)
+	for i := range values {
+		values[i] = L.Get(top + 1 + i)
+	}
+	L.SetTop(top)
+	return values
+}
+
+// Call1 calls fn with args and returns its first result.
+func Call1(L *lua.LState, fn lua.LValue, args ...lua.LValue) lua.LValue {
+	top := L.GetTop()
+	L.Push(fn)
+	for _, arg := range args {
+		L.Push(arg)
+	}
+	L.Call(len(args), 1)
+	ret := L.Get(-1)
+	L.SetTop(top)
+	return ret
+}
+
+// Method calls the method name of obj with args and returns all its results, like obj:name(...) does.
+func Method(L *lua.LState, obj lua.LValue, name string, args ...lua.LValue) []lua.LValue {
+	return Call(L, L.GetField(obj, name), append([]lua.LValue{obj}, args...)...)
+}
+
+// Method1 calls the method name of obj with args and returns its first result.
+func Method1(L *lua.LState, obj lua.LValue, name string, args ...lua.LValue) lua.LValue {
+	return Call1(L, L.GetField(obj, name), append([]lua.LValue{obj}, args...)...)
+}
+
+// At returns the i-th value of values, or nil.
+func At(values []lua.LValue, i int) lua.LValue {
+	if i < len(values) {
+		return values[i]
+	}
+	return lua.LNil
+}
+
+// Return pushes values and returns their number.
+func Return(L *lua.LState, values ...lua.LValue) int {
+	for _, value := range values {
+		L.Push(value)
+	}
+	return len(values)
+}
+
+// Table returns a new table with the fields of a table constructor in the order of the source. fields holds a key
+// and a value for every field, the key of positional fields is a Go nil, and rest the values of a trailing
+// multiple results expression. Positional values are stored after the keyed ones, as SETLIST does.
+func Table(L *lua.LState, fields []lua.LValue, rest ...lua.LValue) lua.LValue {
+	narray := len(rest)
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] == nil {
+			narray++
+		}
+	}
+	tb := L.CreateTable(narray, len(fields)/2+len(rest)-narray)
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] == lua.LNil {
+			L.RaiseError("table index is nil")
+		} else if fields[i] != nil {
+			tb.RawSet(fields[i], fields[i+1])
+		}
+	}
+	n := 0
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] == nil {
+			n++
+			tb.RawSetInt(n, fields[i+1])
+		}
+	}
+	for _, value := range rest {
+		n++
+		tb.RawSetInt(n, value)
+	}
+	return tb
+}
+
+// Greater reports whether lhs > rhs, evaluating the operands in the order of the source.
+func Greater(L *lua.LState, lhs, rhs lua.LValue) bool {
+	return L.LessThan(rhs, lhs)
+}
+
+// GreaterOrEqual reports whether lhs >= rhs.
+func GreaterOrEqual(L *lua.LState, lhs, rhs lua.LValue) bool {
+	return L.LessThanOrEqual(rhs, lhs)
+}
+
+// ForNumber returns the init value, the limit or the step of a numeric for loop.
+func ForNumber(L *lua.LState, v lua.LValue, what string) lua.LNumber {
+	n, ok := v.(lua.LNumber)
+	if !ok {
+		L.RaiseError("for statement %s must be a number", what)
+	}
+	return n
+}
+
+/* }}} */
@@ -0,0 +1,30 @@
+package lua
+
+/* string collation {{{ */
+
+// Collator orders strings for the comparison operators and table.sort(see `Options.Collator`). CompareString
+// returns a negative number if a sorts before b, a positive number if a sorts after b and 0 if they are equal.
+// A *collate.Collator of golang.org/x/text/collate can be used as is:
+//
+//	L := lua.NewState(lua.Options{Collator: collate.New(language.German)})
+type Collator interface {
+	CompareString(a, b string) int
+}
+
+// CollatorFunc is an adapter to use a function as a Collator.
+type CollatorFunc func(a, b string) int
+
+// CompareString calls fn.
+func (fn CollatorFunc) CompareString(a, b string) int {
+	return fn(a, b)
+}
+
+// compareStrings compares a and b with the Collator of the state, or byte by byte if it has none.
+func (ls *LState) compareStrings(a, b string) int {
+	if collator := ls.Options.Collator; collator != nil {
+		return collator.CompareString(a, b)
+	}
+	return strCmp(a, b)
+}
+
+/* }}} */
@@ -0,0 +1,33 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCollator(t *testing.T) {
+	L := NewState(Options{Collator: CollatorFunc(func(a, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	})})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	assert("apple" < "Banana")
+	assert("Banana" > "apple")
+	assert("APPLE" <= "apple" and "apple" <= "APPLE")
+	assert(not ("APPLE" < "apple"))
+	local names = {"banana", "Cherry", "apple", "Apple"}
+	table.sort(names)
+	assert(names[1]:lower() == "apple" and names[2]:lower() == "apple")
+	assert(names[3] == "banana" and names[4] == "Cherry")
+	`)
+	errorIfFalse(t, L.LessThan(LString("a"), LString("B")), "a < B expected")
+
+	L2 := NewState()
+	defer L2.Close()
+	errorIfScriptFail(t, L2, `
+	assert("Banana" < "apple")
+	local names = {"banana", "Cherry", "apple"}
+	table.sort(names)
+	assert(table.concat(names, ",") == "Cherry,apple,banana")
+	`)
+}
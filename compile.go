@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"strings"
 
 	"github.com/r0kyi/gopher-lua/ast"
 )
@@ -409,6 +410,10 @@ type funcContext struct {
 	labelPc         map[int]int
 	gotosCount      int
 	unresolvedGotos map[int]*gotoLabelDesc
+	limits          CompileLimits
+	// depth is the current nesting depth of statements and expressions. It is
+	// shared by all function contexts of a chunk.
+	depth *int
 }
 
 func newFuncContext(sourcename string, parent *funcContext) *funcContext {
@@ -424,6 +429,12 @@ func newFuncContext(sourcename string, parent *funcContext) *funcContext {
 		gotosCount:      0,
 		unresolvedGotos: map[int]*gotoLabelDesc{},
 	}
+	if parent != nil {
+		fc.limits = parent.limits
+		fc.depth = parent.depth
+	} else {
+		fc.depth = new(int)
+	}
 	fc.Blocks = []*codeBlock{fc.Block}
 	return fc
 }
@@ -530,8 +541,24 @@ func (fc *funcContext) ConstIndex(value LValue) int {
 	if v > opMaxArgBx {
 		raiseCompileError(fc, fc.Proto.LineDefined, "too many constants")
 	}
+	if max := fc.limits.MaxConstants; max > 0 && v >= max {
+		raiseCompileError(fc, fc.Proto.LineDefined, "too many constants(limit is %d)", max)
+	}
 	return v
 }
+
+// EnterNested increments the nesting depth and raises an error if it exceeds
+// the limit. Every call must be paired with a call to LeaveNested.
+func (fc *funcContext) EnterNested(line int) {
+	*fc.depth++
+	if max := fc.limits.MaxNestingDepth; max > 0 && *fc.depth > max {
+		raiseCompileError(fc, line, "chunk has too many syntax levels(limit is %d)", max)
+	}
+}
+
+func (fc *funcContext) LeaveNested() {
+	*fc.depth--
+}
 func (fc *funcContext) BlockLocalVarsCount() int {
 	count := 0
 	for block := fc.Block; block != nil; block = block.Parent {
@@ -651,6 +678,11 @@ func compileBlock(context *funcContext, chunk []ast.Stmt) { // {{{
 } // }}}
 
 func compileStmt(context *funcContext, stmt ast.Stmt, isLastStmt bool) { // {{{
+	context.EnterNested(sline(stmt))
+	defer context.LeaveNested()
+	if context.limits.Declarative {
+		checkDeclarativeStmt(context, stmt)
+	}
 	switch st := stmt.(type) {
 	case *ast.AssignStmt:
 		compileAssignStmt(context, st)
@@ -887,7 +919,9 @@ func compileReturnStmt(context *funcContext, stmt *ast.ReturnStmt) { // {{{
 				reg += compileExpr(context, reg, ex, ecnone(0))
 			} else {
 				reg += compileExpr(context, reg, ex, ecnone(-2))
-				code.SetOpCode(code.LastPC(), OP_TAILCALL)
+				if opGetOpCode(code.Last()) == OP_CALL { // SELECTVA is not turned into a tail call
+					code.SetOpCode(code.LastPC(), OP_TAILCALL)
+				}
 			}
 			code.AddABC(OP_RETURN, a, 0, 0, sline(stmt))
 			return
@@ -929,6 +963,8 @@ func compileIfStmt(context *funcContext, stmt *ast.IfStmt) { // {{{
 } // }}}
 
 func compileBranchCondition(context *funcContext, reg int, expr ast.Expr, thenlabel, elselabel int, hasnextcond bool) { // {{{
+	context.EnterNested(sline(expr))
+	defer context.LeaveNested()
 	// TODO folding constants?
 	code := context.Code
 	flip := 0
@@ -1114,12 +1150,31 @@ func compileGenericForStmt(context *funcContext, stmt *ast.GenericForStmt) { //
 	context.LeaveBlock()
 
 	context.SetLabelPc(fllabel, code.LastPC())
-	code.AddABC(OP_TFORLOOP, rgen, 0, nnames, sline(stmt))
+	forop := OP_TFORLOOP
+	if isIpairsCall(context, stmt.Exprs) {
+		forop = OP_TFORLOOPI
+	}
+	code.AddABC(forop, rgen, 0, nnames, sline(stmt))
 	code.AddASbx(OP_JMP, 0, bodylabel, sline(stmt))
 
 	context.SetLabelPc(endlabel, code.LastPC())
 } // }}}
 
+// isIpairsCall reports whether exprs is a single call to the global ipairs
+// with one argument. Whether the called function really is the builtin ipairs
+// can only be decided at runtime.
+func isIpairsCall(context *funcContext, exprs []ast.Expr) bool { // {{{
+	if len(exprs) != 1 {
+		return false
+	}
+	call, ok := exprs[0].(*ast.FuncCallExpr)
+	if !ok || call.Func == nil || len(call.Args) != 1 {
+		return false
+	}
+	ident, ok := call.Func.(*ast.IdentExpr)
+	return ok && ident.Value == "ipairs" && getIdentRefType(context, context, ident) == ecGlobal
+} // }}}
+
 func compileLabelStmt(context *funcContext, stmt *ast.LabelStmt, isLastStmt bool) { // {{{
 	labelId := context.NewLabel()
 	label := newLabelDesc(labelId, stmt.Name, context.Code.LastPC(), sline(stmt), context.BlockLocalVarsCount())
@@ -1139,6 +1194,8 @@ func compileGotoStmt(context *funcContext, stmt *ast.GotoStmt) { // {{{
 } // }}}
 
 func compileExpr(context *funcContext, reg int, expr ast.Expr, ec *expcontext) int { // {{{
+	context.EnterNested(sline(expr))
+	defer context.LeaveNested()
 	code := context.Code
 	sreg := savereg(ec, reg)
 	sused := 1
@@ -1223,6 +1280,9 @@ func compileExpr(context *funcContext, reg int, expr ast.Expr, ec *expcontext) i
 	case *ast.FuncCallExpr:
 		return compileFuncCallExpr(context, reg, ex, ec)
 	case *ast.FunctionExpr:
+		if context.limits.Declarative {
+			raiseCompileError(context, sline(ex), "function definitions are not allowed in declarative chunks")
+		}
 		childcontext := newFuncContext(context.Proto.SourceName, context)
 		compileFunctionExpr(childcontext, ex, ec)
 		protono := len(context.Proto.FunctionPrototypes)
@@ -1266,11 +1326,13 @@ func compileExprWithMVPropagation(context *funcContext, expr ast.Expr, reg *int,
 	compileExprWithPropagation(context, expr, reg, save, context.Code.PropagateMV)
 } // }}}
 
-func constFold(exp ast.Expr) ast.Expr { // {{{
+func constFold(context *funcContext, exp ast.Expr) ast.Expr { // {{{
+	context.EnterNested(sline(exp))
+	defer context.LeaveNested()
 	switch expr := exp.(type) {
 	case *ast.ArithmeticOpExpr:
-		lvalue, lisconst := lnumberValue(constFold(expr.Lhs))
-		rvalue, risconst := lnumberValue(constFold(expr.Rhs))
+		lvalue, lisconst := lnumberValue(constFold(context, expr.Lhs))
+		rvalue, risconst := lnumberValue(constFold(context, expr.Rhs))
 		if lisconst && risconst {
 			switch expr.Operator {
 			case "+":
@@ -1292,7 +1354,7 @@ func constFold(exp ast.Expr) ast.Expr { // {{{
 			return expr
 		}
 	case *ast.UnaryMinusOpExpr:
-		expr.Expr = constFold(expr.Expr)
+		expr.Expr = constFold(context, expr.Expr)
 		if value, ok := lnumberValue(expr.Expr); ok {
 			return &constLValueExpr{Value: LNumber(-value)}
 		}
@@ -1344,6 +1406,8 @@ func compileFunctionExpr(context *funcContext, funcexpr *ast.FunctionExpr, ec *e
 		context.Proto.stringConstants = append(context.Proto.stringConstants, sv)
 	}
 	patchCode(context)
+	context.Proto.fuseInstructions()
+	context.Proto.assignInlineCaches()
 } // }}}
 
 func compileTableExpr(context *funcContext, reg int, ex *ast.TableExpr, ec *expcontext) { // {{{
@@ -1361,6 +1425,7 @@ func compileTableExpr(context *funcContext, reg int, ex *ast.TableExpr, ec *expc
 	regbase := reg
 
 	arraycount := 0
+	hashcount := 0
 	lastvararg := false
 	for i, field := range ex.Fields {
 		islast := i == len(ex.Fields)-1
@@ -1373,6 +1438,7 @@ func compileTableExpr(context *funcContext, reg int, ex *ast.TableExpr, ec *expc
 				arraycount += 1
 			}
 		} else {
+			hashcount += 1
 			regorg := reg
 			b := reg
 			compileExprWithKMVPropagation(context, field.Key, &reg, &b)
@@ -1411,14 +1477,14 @@ func compileTableExpr(context *funcContext, reg int, ex *ast.TableExpr, ec *expc
 		}
 	}
 	code.SetB(tablepc, int2Fb(arraycount))
-	code.SetC(tablepc, int2Fb(len(ex.Fields)-arraycount))
+	code.SetC(tablepc, int2Fb(hashcount))
 	if shouldmove(ec, tablereg) {
 		code.AddABC(OP_MOVE, ec.reg, tablereg, 0, sline(ex))
 	}
 } // }}}
 
 func compileArithmeticOpExpr(context *funcContext, reg int, expr *ast.ArithmeticOpExpr, ec *expcontext) { // {{{
-	exp := constFold(expr)
+	exp := constFold(context, expr)
 	if ex, ok := exp.(*constLValueExpr); ok {
 		exp.SetLine(sline(expr))
 		compileExpr(context, reg, ex, ec)
@@ -1476,7 +1542,7 @@ func compileUnaryOpExpr(context *funcContext, reg int, expr ast.Expr, ec *expcon
 	var operandexpr ast.Expr
 	switch ex := expr.(type) {
 	case *ast.UnaryMinusOpExpr:
-		exp := constFold(ex)
+		exp := constFold(context, ex)
 		if lvexpr, ok := exp.(*constLValueExpr); ok {
 			exp.SetLine(sline(expr))
 			compileExpr(context, reg, lvexpr, ec)
@@ -1573,6 +1639,8 @@ func compileLogicalOpExpr(context *funcContext, reg int, expr *ast.LogicalOpExpr
 } // }}}
 
 func compileLogicalOpExprAux(context *funcContext, reg int, expr ast.Expr, ec *expcontext, thenlabel, elselabel int, hasnextcond bool, lb *lblabels) { // {{{
+	context.EnterNested(sline(expr))
+	defer context.LeaveNested()
 	// TODO folding constants?
 	code := context.Code
 	flip := 0
@@ -1677,6 +1745,9 @@ func compileLogicalOpExprAux(context *funcContext, reg int, expr ast.Expr, ec *e
 } // }}}
 
 func compileFuncCallExpr(context *funcContext, reg int, expr *ast.FuncCallExpr, ec *expcontext) int { // {{{
+	if context.limits.Declarative {
+		checkDeclarativeCall(context, expr)
+	}
 	funcreg := reg
 	if ec.ctype == ecLocal && ec.reg == (int(context.Proto.NumParameters)-1) {
 		funcreg = ec.reg
@@ -1686,6 +1757,15 @@ func compileFuncCallExpr(context *funcContext, reg int, expr *ast.FuncCallExpr,
 	islastvararg := false
 	name := "(anonymous)"
 
+	if isSelectVarArgCall(context, expr) { // select(n, ...)
+		reg += compileExpr(context, reg, expr.Func, ecnone(0))
+		compileExpr(context, reg, expr.Args[0], ecnone(0))
+		context.Proto.IsVarArg &= ^VarArgNeedsArg
+		context.Code.AddABC(OP_SELECTVA, funcreg, 0, ec.varargopt+2, sline(expr))
+		context.Proto.DbgCalls = append(context.Proto.DbgCalls, DbgCall{Pc: context.Code.LastPC(), Name: "select"})
+		return compileFuncCallResults(context, funcreg, expr, ec)
+	}
+
 	if expr.Func != nil { // hoge.func()
 		reg += compileExpr(context, reg, expr.Func, ecnone(0))
 		name = getExprName(context, expr.Func)
@@ -1718,7 +1798,10 @@ func compileFuncCallExpr(context *funcContext, reg int, expr *ast.FuncCallExpr,
 	}
 	context.Code.AddABC(OP_CALL, funcreg, b, ec.varargopt+2, sline(expr))
 	context.Proto.DbgCalls = append(context.Proto.DbgCalls, DbgCall{Pc: context.Code.LastPC(), Name: name})
+	return compileFuncCallResults(context, funcreg, expr, ec)
+} // }}}
 
+func compileFuncCallResults(context *funcContext, funcreg int, expr *ast.FuncCallExpr, ec *expcontext) int { // {{{
 	if ec.varargopt == 0 && shouldmove(ec, funcreg) {
 		context.Code.AddABC(OP_MOVE, ec.reg, funcreg, 0, sline(expr))
 		return 1
@@ -1729,6 +1812,21 @@ func compileFuncCallExpr(context *funcContext, reg int, expr *ast.FuncCallExpr,
 	return ec.varargopt + 1
 } // }}}
 
+// isSelectVarArgCall reports whether expr is a call to the global select with
+// the varargs of the enclosing function as its last argument, e.g.
+// select('#', ...). Whether the called function really is the builtin select
+// can only be decided at runtime.
+func isSelectVarArgCall(context *funcContext, expr *ast.FuncCallExpr) bool { // {{{
+	if context.Proto.IsVarArg == 0 || expr.Func == nil || len(expr.Args) != 2 {
+		return false
+	}
+	if _, ok := expr.Args[1].(*ast.Comma3Expr); !ok {
+		return false
+	}
+	ident, ok := expr.Func.(*ast.IdentExpr)
+	return ok && ident.Value == "select" && getIdentRefType(context, context, ident) == ecGlobal
+} // }}}
+
 func loadRk(context *funcContext, reg *int, expr ast.Expr, cnst LValue) int { // {{{
 	cindex := context.ConstIndex(cnst)
 	if cindex <= opMaxIndexRk {
@@ -1753,6 +1851,87 @@ func getIdentRefType(context *funcContext, current *funcContext, expr *ast.Ident
 	return getIdentRefType(context, current.Parent, expr)
 } // }}}
 
+func checkDeclarativeStmt(context *funcContext, stmt ast.Stmt) { // {{{
+	switch st := stmt.(type) {
+	case *ast.AssignStmt:
+		// allowed calls are checked by name, so the functions they name must not be replaced: fields can not be
+		// assigned, as a table may be reached through an alias, nor the globals allowed calls start at
+		for _, lhs := range st.Lhs {
+			switch ex := lhs.(type) {
+			case *ast.AttrGetExpr:
+				raiseCompileError(context, sline(stmt), "assignments to fields are not allowed in declarative chunks")
+			case *ast.IdentExpr:
+				if getIdentRefType(context, context, ex) == ecGlobal && isAllowedCallRoot(context, ex.Value) {
+					raiseCompileError(context, sline(stmt), "assignment of '%s' is not allowed in declarative chunks", ex.Value)
+				}
+			}
+		}
+	case *ast.WhileStmt, *ast.RepeatStmt, *ast.NumberForStmt, *ast.GenericForStmt:
+		raiseCompileError(context, sline(stmt), "loops are not allowed in declarative chunks")
+	case *ast.FuncDefStmt:
+		raiseCompileError(context, sline(stmt), "function definitions are not allowed in declarative chunks")
+	case *ast.LabelStmt, *ast.GotoStmt:
+		raiseCompileError(context, sline(stmt), "goto is not allowed in declarative chunks")
+	}
+} // }}}
+
+func checkDeclarativeCall(context *funcContext, expr *ast.FuncCallExpr) { // {{{
+	var name string
+	var ok bool
+	if expr.Func != nil {
+		name, ok = getGlobalPath(context, expr.Func)
+	} else if name, ok = getGlobalPath(context, expr.Receiver); ok {
+		name += ":" + expr.Method
+	}
+	if ok {
+		for _, allowed := range context.limits.AllowedCalls {
+			if allowed == name {
+				return
+			}
+		}
+	} else {
+		name = "?"
+	}
+	raiseCompileError(context, sline(expr), "call of '%s' is not allowed in declarative chunks", name)
+} // }}}
+
+// isAllowedCallRoot reports whether an allowed call of a declarative chunk
+// starts at the global name.
+func isAllowedCallRoot(context *funcContext, name string) bool { // {{{
+	for _, allowed := range context.limits.AllowedCalls {
+		if i := strings.IndexAny(allowed, ".:"); i >= 0 {
+			allowed = allowed[:i]
+		}
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+} // }}}
+
+// getGlobalPath returns the dotted path of an expression that is a global
+// variable or a chain of constant field accesses starting at one.
+func getGlobalPath(context *funcContext, expr ast.Expr) (string, bool) { // {{{
+	switch ex := expr.(type) {
+	case *ast.IdentExpr:
+		if getIdentRefType(context, context, ex) != ecGlobal {
+			return "", false
+		}
+		return ex.Value, true
+	case *ast.AttrGetExpr:
+		key, ok := ex.Key.(*ast.StringExpr)
+		if !ok {
+			return "", false
+		}
+		path, ok := getGlobalPath(context, ex.Object)
+		if !ok {
+			return "", false
+		}
+		return path + "." + key.Value, true
+	}
+	return "", false
+} // }}}
+
 func getExprName(context *funcContext, expr ast.Expr) string { // {{{
 	switch ex := expr.(type) {
 	case *ast.IdentExpr:
@@ -1783,13 +1962,17 @@ func patchCode(context *funcContext) { // {{{
 			moven = 0
 			continue
 		case OP_SETGLOBAL, OP_SETUPVAL, OP_EQ, OP_LT, OP_LE, OP_TEST,
-			OP_TAILCALL, OP_RETURN, OP_FORPREP, OP_FORLOOP, OP_TFORLOOP,
+			OP_TAILCALL, OP_RETURN, OP_FORPREP, OP_FORLOOP, OP_TFORLOOP, OP_TFORLOOPI,
 			OP_SETLIST, OP_CLOSE:
 			/* nothing to do */
 		case OP_CALL:
 			if reg := opGetArgA(inst) + opGetArgC(inst) - 2; reg > maxreg {
 				maxreg = reg
 			}
+		case OP_SELECTVA:
+			if reg := opGetArgA(inst) + max(1, opGetArgC(inst)-2); reg > maxreg {
+				maxreg = reg
+			}
 		case OP_VARARG:
 			if reg := opGetArgA(inst) + opGetArgB(inst) - 1; reg > maxreg {
 				maxreg = reg
@@ -1806,7 +1989,13 @@ func patchCode(context *funcContext) { // {{{
 			distance := 0
 			count := 0 // avoiding infinite loops
 			for jmp := inst; opGetOpCode(jmp) == OP_JMP && count < 5; jmp = context.Code.At(pc + distance + 1) {
-				d := context.GetLabelPc(opGetArgSbx(jmp)) - pc
+				var d int
+				if jpc := pc + distance + 1; count > 0 && jpc < pc {
+					// jumps before pc have been patched already, their operand is an offset instead of a label
+					d = jpc + opGetArgSbx(jmp) - pc
+				} else {
+					d = context.GetLabelPc(opGetArgSbx(jmp)) - pc
+				}
 				if d > opMaxArgSbx {
 					if distance == 0 {
 						raiseCompileError(context, context.Proto.LineDefined, "too long to jump.")
@@ -1817,7 +2006,9 @@ func patchCode(context *funcContext) { // {{{
 				count++
 			}
 			if distance == 0 {
+				// TFORLOOP adds the offset of the instruction that follows it to pc, so it is cleared as well
 				context.Code.SetOpCode(pc, OP_NOP)
+				context.Code.SetSbx(pc, 0)
 			} else {
 				context.Code.SetSbx(pc, distance)
 			}
@@ -1845,7 +2036,62 @@ func patchCode(context *funcContext) { // {{{
 	context.Proto.NumUsedRegisters = uint8(maxreg)
 } // }}}
 
+// CompileLimits restricts the chunks accepted by CompileWithLimits. Zero
+// values mean no limit.
+type CompileLimits struct {
+	// MaxNestingDepth is the maximum nesting depth of statements and
+	// expressions. The compiler is recursive, so this bounds the Go stack
+	// used to compile a chunk.
+	MaxNestingDepth int
+	// MaxConstants is the maximum number of constants of a single function.
+	MaxConstants int
+	// Declarative rejects function definitions, loops, gotos, calls of
+	// functions not listed in AllowedCalls and assignments that could
+	// replace them: assignments to fields and to the globals the allowed
+	// calls start at. Lua is then only used to describe data, e.g. in
+	// configuration files.
+	Declarative bool
+	// AllowedCalls lists the functions that may be called in declarative
+	// chunks. Functions are named by global names("print") or dotted field
+	// paths starting at a global("os.getenv"), and methods by the path of
+	// the receiver followed by a colon("config:set").
+	AllowedCalls []string
+}
+
 func Compile(chunk []ast.Stmt, name string) (proto *FunctionProto, err error) { // {{{
+	return CompileWithLimits(chunk, name, CompileLimits{})
+} // }}}
+
+// CompileExpr compiles a single expression, e.g. one parsed by parse.ParseExpr, into a chunk returning the values
+// of the expression. Like Compile, it does not run a Checker, which `LState.Eval` does.
+func CompileExpr(expr ast.Expr, name string) (*FunctionProto, error) { // {{{
+	return Compile([]ast.Stmt{exprReturnStmt(expr)}, name)
+} // }}}
+
+// exprReturnStmt returns the statement "return expr".
+func exprReturnStmt(expr ast.Expr) *ast.ReturnStmt { // {{{
+	stmt := &ast.ReturnStmt{Exprs: []ast.Expr{expr}}
+	stmt.SetLine(expr.Line())
+	stmt.SetLastLine(expr.LastLine())
+	return stmt
+} // }}}
+
+// CompileWithLimits is Compile with limits on the compiled chunk. A
+// *CompileError is returned if a limit is exceeded.
+func CompileWithLimits(chunk []ast.Stmt, name string, limits CompileLimits) (proto *FunctionProto, err error) { // {{{
+	proto, err = compileWithUpvalues(chunk, name, limits, nil)
+	if err == nil {
+		// share the names among the functions of the chunk
+		var names nameTable
+		names.internProto(proto)
+	}
+	return
+} // }}}
+
+// compileWithUpvalues compiles chunk as if it was nested into a function
+// with the local variables upvalues, so that these names refer to upvalues
+// of the compiled function(see proto.DbgUpvalues for their order).
+func compileWithUpvalues(chunk []ast.Stmt, name string, limits CompileLimits, upvalues []string) (proto *FunctionProto, err error) { // {{{
 	defer func() {
 		if rcv := recover(); rcv != nil {
 			if _, ok := rcv.(*CompileError); ok {
@@ -1862,7 +2108,16 @@ func Compile(chunk []ast.Stmt, name string) (proto *FunctionProto, err error) {
 		funcexpr.SetLastLine(sline(chunk[0]))
 		funcexpr.SetLastLine(eline(chunk[len(chunk)-1]) + 1)
 	}
-	context := newFuncContext(name, nil)
+	var parent *funcContext
+	if len(upvalues) > 0 {
+		parent = newFuncContext(name, nil)
+		parent.limits = limits
+		for _, upvalue := range upvalues {
+			parent.RegisterLocalVar(upvalue)
+		}
+	}
+	context := newFuncContext(name, parent)
+	context.limits = limits
 	compileFunctionExpr(context, funcexpr, ecnone(0))
 	proto = context.Proto
 	return
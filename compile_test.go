@@ -0,0 +1,206 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/r0kyi/gopher-lua/ast"
+	"github.com/r0kyi/gopher-lua/parse"
+)
+
+func compileString(t *testing.T, src string) *FunctionProto {
+	chunk, err := parse.Parse(strings.NewReader(src), "<string>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto, err := Compile(chunk, "<string>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proto
+}
+
+func countOpCode(proto *FunctionProto, op int) int {
+	n := 0
+	for _, inst := range proto.Code {
+		if opGetOpCode(inst) == op {
+			n++
+		}
+	}
+	return n
+}
+
+func TestCompileSuperInstructions(t *testing.T) {
+	src := `
+	local obj = {n = 0}
+	function obj:inc() self.n = self.n + 1 end
+	local function const(x) if x then return "yes" end return "no" end
+	local lt = setmetatable({}, {__lt = function() return true end, __le = function() return false end})
+	for i = 1, 10 do
+		if i < 5 then obj:inc() end
+		if i <= 2 then obj:inc() end
+		if i == 10 then obj:inc() end
+	end
+	assert(obj.n == 7)
+	assert(const(true) == "yes" and const(false) == "no")
+	assert(lt < lt and not (lt <= lt))
+	`
+	proto := compileString(t, src)
+	for _, op := range []int{OP_LTJMP, OP_LEJMP, OP_EQJMP, OP_SELFCALL} {
+		errorIfFalse(t, countOpCode(proto, op) > 0, "%v is not used", opProps[op].Name)
+	}
+	errorIfFalse(t, countOpCode(proto.FunctionPrototypes[1], OP_RETURNK) == 2, "RETURNK is not used")
+	errorIfFalse(t, countOpCode(proto.FunctionPrototypes[1], OP_TESTJMP) == 1, "TESTJMP is not used")
+
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, src)
+}
+
+func TestCompileLimits(t *testing.T) {
+	nested := strings.Repeat("{", 100) + strings.Repeat("}", 100)
+	blocks := strings.Repeat("do ", 100) + strings.Repeat("end ", 100)
+	unary := "x = " + strings.Repeat("- ", 100) + "1"
+	constants := "local t = {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j'}"
+
+	L := NewState(Options{MaxNestingDepth: 50, MaxConstants: 8, MaxChunkSize: 1024})
+	defer L.Close()
+	for _, src := range []string{"x = " + nested, blocks, unary, "if " + strings.Repeat("not ", 100) + "x then end"} {
+		_, err := L.LoadString(src)
+		errorIfFalse(t, err != nil && strings.Contains(err.Error(), "too many syntax levels(limit is 50)"), "unexpected error for %v: %v", src, err)
+	}
+	_, err := L.LoadString(constants)
+	errorIfFalse(t, err != nil && strings.Contains(err.Error(), "too many constants(limit is 8)"), "unexpected error: %v", err)
+	_, err = L.LoadString("x = 1 --" + strings.Repeat("-", 2048))
+	errorIfFalse(t, err != nil && strings.Contains(err.Error(), "chunk is too large(limit is 1024 bytes)"), "unexpected error: %v", err)
+
+	errorIfScriptFail(t, L, "x = "+strings.Repeat("(", 10)+"1"+strings.Repeat(")", 10)+"; for i = 1, 2 do if x then x = x + i end end")
+	errorIfScriptFail(t, L, strings.Repeat(" ", 1000))
+
+	L2 := NewState()
+	defer L2.Close()
+	errorIfScriptFail(t, L2, "x = "+nested)
+	errorIfScriptFail(t, L2, blocks)
+	errorIfScriptFail(t, L2, constants)
+}
+
+func TestCompileDeclarative(t *testing.T) {
+	L := NewState(Options{Declarative: true, AllowedCalls: []string{"os.getenv", "string.format", "config:set"}})
+	defer L.Close()
+	config := L.NewTable()
+	L.SetField(config, "set", L.NewFunction(func(L *LState) int {
+		L.SetField(L.CheckTable(1), L.CheckString(2), L.CheckAny(3))
+		return 0
+	}))
+	L.SetGlobal("config", config)
+	errorIfScriptFail(t, L, `
+local name = "app"
+server = {
+  host = os.getenv("HOST") or "localhost",
+  port = 8080 + 1,
+  name = string.format("%s-%d", name, 1),
+  tags = {"a", "b"},
+}
+if server.port > 8000 then debug_mode = false else debug_mode = true end
+config:set("x", os["getenv"]("HOME"))
+`)
+	for src, msg := range map[string]string{
+		`f = function() end`:                          "function definitions are not allowed in declarative chunks",
+		`function f() end`:                            "function definitions are not allowed in declarative chunks",
+		`while true do end`:                           "loops are not allowed in declarative chunks",
+		`repeat until false`:                          "loops are not allowed in declarative chunks",
+		`for i = 1, 10 do end`:                        "loops are not allowed in declarative chunks",
+		`for k in pairs({}) do end`:                   "loops are not allowed in declarative chunks",
+		`::top:: goto top`:                            "goto is not allowed in declarative chunks",
+		`print("x")`:                                  "call of 'print' is not allowed in declarative chunks",
+		`x = os.execute("ls")`:                        "call of 'os.execute' is not allowed in declarative chunks",
+		`x = ("x"):rep(10)`:                           "call of '\\?' is not allowed in declarative chunks",
+		`local os = {getenv = print}; os.getenv("x")`: "call of '\\?' is not allowed in declarative chunks",
+		`x = os[1]("x")`:                              "call of '\\?' is not allowed",
+		// allowed functions can not be replaced
+		`os.getenv = os.execute; x = os.getenv("ls")`: "assignments to fields are not allowed in declarative chunks",
+		`local o = os; o.getenv = print`:              "assignments to fields are not allowed",
+		`_G["os"] = {getenv = print}`:                 "assignments to fields are not allowed",
+		`os = {getenv = print}`:                       "assignment of 'os' is not allowed in declarative chunks",
+		`x, config = 1, {set = print}`:                "assignment of 'config' is not allowed",
+	} {
+		errorIfScriptNotFail(t, L, src, msg)
+	}
+}
+
+func TestCompileJumpToBreak(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local n = 0
+	for k in pairs({1}) do n = n + 1 end
+	for k in pairs({a = 1}) do break end
+	assert(n == 1)
+
+	local m = 0
+	for k in pairs({1, 2}) do m = m + 1 end
+	for i = 1, 3 do
+		for k in pairs({a = 1, b = 2}) do break end
+		m = m + 1
+	end
+	assert(m == 5)
+	`)
+}
+
+func TestParseAndCompileExpr(t *testing.T) {
+	expr, err := parse.ParseExpr(strings.NewReader("x.y +\n  f(2)"), "<watch>")
+	errorIfNotNil(t, err)
+	arith, ok := expr.(*ast.ArithmeticOpExpr)
+	errorIfFalse(t, ok, "arithmetic expression expected, got %T", expr)
+	errorIfNotEqual(t, 1, arith.Line())
+	errorIfNotEqual(t, 2, arith.Rhs.Line())
+
+	proto, err := CompileExpr(expr, "<watch>")
+	errorIfNotNil(t, err)
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `x = {y = 40} function f(n) return n, "dropped" end`)
+	L.Push(L.NewFunctionFromProto(proto))
+	errorIfNotNil(t, L.PCall(0, MultRet, nil))
+	errorIfNotEqual(t, 1, L.GetTop())
+	errorIfNotEqual(t, LNumber(42), L.Get(1))
+
+	_, err = parse.ParseExpr(strings.NewReader("1 + + 2"), "<watch>")
+	perr, ok := err.(*parse.Error)
+	errorIfFalse(t, ok, "*parse.Error expected, got %v", err)
+	errorIfNotEqual(t, 1, perr.Pos.Line)
+	errorIfNotEqual(t, 5, perr.Pos.Column)
+
+	for _, src := range []string{"", "a, b", "x = 1", "return 1", "1 end"} {
+		_, err = parse.ParseExpr(strings.NewReader(src), "<watch>")
+		errorIfFalse(t, err != nil, "error expected for %q", src)
+	}
+	_, err = parse.ParseExpr(strings.NewReader("a,\n b"), "<watch>")
+	errorIfFalse(t, err != nil && strings.Contains(err.Error(), "line:2") && strings.Contains(err.Error(), "single expression expected"), "unexpected error %v", err)
+}
+
+func sameString(a, b string) bool {
+	return a == b && unsafe.StringData(a) == unsafe.StringData(b)
+}
+
+func TestCompileInternsNames(t *testing.T) {
+	proto := compileString(t, `
+	local count = 0
+	local function f(count) return count end
+	local function g() count = count + 1; return f(count) end
+	`)
+	f, g := proto.FunctionPrototypes[0], proto.FunctionPrototypes[1]
+	errorIfFalse(t, sameString(proto.DbgLocals[0].Name, f.DbgLocals[0].Name), "locals must share names")
+	errorIfFalse(t, sameString(proto.DbgLocals[0].Name, g.DbgUpvalues[0]), "upvalues must share names")
+	errorIfFalse(t, sameString(proto.DbgLocals[1].Name, g.DbgCalls[0].Name), "calls must share names")
+
+	L := NewState()
+	defer L.Close()
+	fn1, err := L.LoadString("local count = 1")
+	errorIfNotNil(t, err)
+	fn2, err := L.LoadString("local count = 2")
+	errorIfNotNil(t, err)
+	errorIfFalse(t, sameString(fn1.Proto.DbgLocals[0].Name, fn2.Proto.DbgLocals[0].Name),
+		"chunks loaded by a state must share names")
+}
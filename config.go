@@ -9,6 +9,10 @@ var FieldsPerFlush = 50
 var RegistrySize = 256 * 20
 var RegistryGrowStep = 32
 var CallStackSize = 256
+
+// CoroutineRegistrySize is the initial registry size of threads created by `LState.NewThread`. Their registry
+// grows on demand up to the registry size limit of the parent state.
+var CoroutineRegistrySize = 256
 var MaxTableGetLoop = 100
 var MaxArrayIndex = 67108864
 
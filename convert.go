@@ -0,0 +1,196 @@
+package lua
+
+import (
+	"math"
+	"reflect"
+)
+
+/* Go value conversion {{{ */
+
+// GoValueOptions controls how `ToGoValue` converts Lua values.
+type GoValueOptions struct {
+	// Integers converts numbers that have an integral value in the range of int64 to int64 rather than float64.
+	Integers bool
+	// EmptyTableAsArray converts empty tables to empty []interface{} rather than empty map[string]interface{}.
+	EmptyTableAsArray bool
+	// BreakCycles converts a table that is referred to from inside itself to nil at the inner reference, so that
+	// the result can be walked without looping, e.g. by encoding/json. By default, such references refer to the
+	// converted outer map or slice.
+	BreakCycles bool
+}
+
+// ToGoValue converts v to a Go value: nil to nil, booleans to bool, numbers to float64(or int64, see
+// `GoValueOptions.Integers`), strings to string, objects and userdata to their values, tables whose keys are the
+// integers 1..n to []interface{}, and other tables to map[string]interface{}. Functions, threads and channels are
+// returned as is.
+//
+// The keys of maps are the string forms of numbers, strings and booleans; entries with keys of other types are
+// left out. A table referred to more than once is converted to one map or slice.
+func ToGoValue(v LValue, opts ...GoValueOptions) interface{} {
+	c := &goValueConverter{seen: make(map[*LTable]interface{})}
+	if len(opts) > 0 {
+		c.opts = opts[0]
+	}
+	return c.convert(v)
+}
+
+type goValueConverter struct {
+	opts     GoValueOptions
+	seen     map[*LTable]interface{}
+	visiting map[*LTable]bool
+}
+
+func (c *goValueConverter) convert(v LValue) interface{} {
+	switch lv := v.(type) {
+	case *LNilType:
+		return nil
+	case LBool:
+		return bool(lv)
+	case LNumber:
+		if f := float64(lv); c.opts.Integers && f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			return int64(f)
+		}
+		return float64(lv)
+	case LString:
+		return string(lv)
+	case *LObject:
+		return lv.Value
+	case *LUserData:
+		return lv.Value
+	case *LTable:
+		return c.convertTable(lv)
+	}
+	return v
+}
+
+func (c *goValueConverter) convertTable(tb *LTable) interface{} {
+	if c.opts.BreakCycles {
+		if c.visiting[tb] {
+			return nil
+		}
+		if c.visiting == nil {
+			c.visiting = make(map[*LTable]bool)
+		}
+		c.visiting[tb] = true
+		defer delete(c.visiting, tb)
+	} else if gv, ok := c.seen[tb]; ok {
+		return gv
+	}
+	if n, ok := tableArrayLen(tb); ok && (n > 0 || c.opts.EmptyTableAsArray) {
+		arr := make([]interface{}, n)
+		c.seen[tb] = arr
+		for i := range arr {
+			arr[i] = c.convert(tb.RawGetInt(i + 1))
+		}
+		return arr
+	}
+	m := make(map[string]interface{})
+	c.seen[tb] = m
+	tb.ForEach(func(key, value LValue) {
+		switch key.(type) {
+		case LString, LNumber, LBool:
+			m[key.String()] = c.convert(value)
+		}
+	})
+	return m
+}
+
+// tableArrayLen returns the number of elements of tb if its keys are the integers 1..n.
+func tableArrayLen(tb *LTable) (int, bool) {
+	count := 0
+	max := 0
+	array := true
+	tb.ForEach(func(key, value LValue) {
+		count++
+		n, ok := key.(LNumber)
+		if !ok || float64(n) != math.Trunc(float64(n)) || n < 1 {
+			array = false
+			return
+		}
+		if int(n) > max {
+			max = int(n)
+		}
+	})
+	return count, array && max == count
+}
+
+// FromGoValue converts v to a Lua value, the inverse of `ToGoValue`: nil to nil, booleans, integers and floats to
+// booleans and numbers, strings and []byte to strings, slices and arrays to array tables, and maps to tables whose
+// keys are converted like values. LValues are returned as is, and other values, e.g. structs, are converted like
+// `LState.NewObject` does. Maps and slices referred to more than once, including from inside themselves, are
+// converted to one table.
+func (ls *LState) FromGoValue(v interface{}) LValue {
+	if lv, ok := v.(LValue); ok {
+		return lv
+	}
+	return ls.fromGoValue(reflect.ValueOf(v), make(map[goValueIdentity]*LTable))
+}
+
+type goValueIdentity struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
+func (ls *LState) fromGoValue(rv reflect.Value, seen map[goValueIdentity]*LTable) LValue {
+	if !rv.IsValid() {
+		return LNil
+	}
+	switch rv.Kind() {
+	case reflect.Interface:
+		if rv.IsNil() {
+			return LNil
+		}
+		if lv, ok := rv.Interface().(LValue); ok {
+			return lv
+		}
+		return ls.fromGoValue(rv.Elem(), seen)
+	case reflect.Slice:
+		if rv.IsNil() {
+			return LNil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return LString(rv.Bytes())
+		}
+		id := goValueIdentity{rv.Pointer(), rv.Len(), rv.Type()}
+		if tb, ok := seen[id]; ok {
+			return tb
+		}
+		tb := ls.CreateTable(rv.Len(), 0)
+		seen[id] = tb
+		ls.fromGoArray(tb, rv, seen)
+		return tb
+	case reflect.Array:
+		tb := ls.CreateTable(rv.Len(), 0)
+		ls.fromGoArray(tb, rv, seen)
+		return tb
+	case reflect.Map:
+		if rv.IsNil() {
+			return LNil
+		}
+		id := goValueIdentity{rv.Pointer(), 0, rv.Type()}
+		if tb, ok := seen[id]; ok {
+			return tb
+		}
+		tb := ls.CreateTable(0, rv.Len())
+		seen[id] = tb
+		iter := rv.MapRange()
+		for iter.Next() {
+			key := ls.fromGoValue(iter.Key(), seen)
+			if key == LNil {
+				continue
+			}
+			tb.RawSet(key, ls.fromGoValue(iter.Value(), seen))
+		}
+		return tb
+	}
+	return goValueToLValue(ls, rv)
+}
+
+func (ls *LState) fromGoArray(tb *LTable, rv reflect.Value, seen map[goValueIdentity]*LTable) {
+	for i := 0; i < rv.Len(); i++ {
+		tb.RawSetInt(i+1, ls.fromGoValue(rv.Index(i), seen))
+	}
+}
+
+/* }}} */
@@ -0,0 +1,93 @@
+package lua
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestToGoValue(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  t = {name = "x", list = {1, 2.5, "three"}, flags = {[true] = 1}, empty = {}, sparse = {[1] = 1, [3] = 3}}
+	`)
+	v := ToGoValue(L.GetGlobal("t"))
+	m, ok := v.(map[string]interface{})
+	errorIfFalse(t, ok, "map expected, got %T", v)
+	errorIfNotEqual(t, "x", m["name"])
+	list, ok := m["list"].([]interface{})
+	errorIfFalse(t, ok, "slice expected, got %T", m["list"])
+	errorIfNotEqual(t, 3, len(list))
+	errorIfNotEqual(t, float64(1), list[0])
+	errorIfNotEqual(t, 2.5, list[1])
+	errorIfNotEqual(t, "three", list[2])
+	errorIfNotEqual(t, float64(1), m["flags"].(map[string]interface{})["true"])
+	_, ok = m["empty"].(map[string]interface{})
+	errorIfFalse(t, ok, "map expected, got %T", m["empty"])
+	errorIfNotEqual(t, float64(3), m["sparse"].(map[string]interface{})["3"])
+
+	v = ToGoValue(L.GetGlobal("t"), GoValueOptions{Integers: true, EmptyTableAsArray: true})
+	m = v.(map[string]interface{})
+	list = m["list"].([]interface{})
+	errorIfNotEqual(t, int64(1), list[0])
+	errorIfNotEqual(t, 2.5, list[1])
+	_, ok = m["empty"].([]interface{})
+	errorIfFalse(t, ok, "slice expected, got %T", m["empty"])
+	errorIfNotEqual(t, nil, ToGoValue(LNil))
+}
+
+func TestToGoValueCycles(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  shared = {1}
+	  t = {a = shared, b = shared}
+	  t.self = t
+	`)
+	m := ToGoValue(L.GetGlobal("t")).(map[string]interface{})
+	self := m["self"].(map[string]interface{})
+	self["marker"] = true
+	errorIfNotEqual(t, true, m["marker"])
+	a, b := m["a"].([]interface{}), m["b"].([]interface{})
+	a[0] = "changed"
+	errorIfNotEqual(t, "changed", b[0])
+
+	m = ToGoValue(L.GetGlobal("t"), GoValueOptions{BreakCycles: true}).(map[string]interface{})
+	errorIfNotEqual(t, nil, m["self"])
+	_, err := json.Marshal(m)
+	errorIfNotNil(t, err)
+}
+
+func TestFromGoValue(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	cyclic := map[string]interface{}{"n": 1}
+	cyclic["self"] = cyclic
+	L.SetGlobal("v", L.FromGoValue(map[string]interface{}{
+		"name":   "x",
+		"list":   []interface{}{1, 2.5, "three", true},
+		"ints":   []int{1, 2, 3},
+		"counts": map[string]int{"a": 1},
+		"keys":   map[int]string{1: "one", 2: "two"},
+		"bytes":  []byte("raw"),
+		"none":   nil,
+		"cyclic": cyclic,
+		"lvalue": LString("lv"),
+	}))
+	errorIfScriptFail(t, L, `
+	  assert(v.name == "x")
+	  assert(#v.list == 4 and v.list[1] == 1 and v.list[2] == 2.5 and v.list[3] == "three" and v.list[4] == true)
+	  assert(#v.ints == 3 and v.ints[3] == 3)
+	  assert(v.counts.a == 1)
+	  assert(#v.keys == 2 and v.keys[2] == "two")
+	  assert(v.bytes == "raw")
+	  assert(v.none == nil)
+	  assert(v.cyclic.self == v.cyclic and v.cyclic.n == 1)
+	  assert(v.lvalue == "lv")
+	`)
+
+	var data interface{}
+	errorIfNotNil(t, json.Unmarshal([]byte(`{"a": [1, {"b": null}], "c": "d"}`), &data))
+	roundtrip, _ := json.Marshal(ToGoValue(L.FromGoValue(data)))
+	errorIfNotEqual(t, `{"a":[1,{}],"c":"d"}`, string(roundtrip))
+}
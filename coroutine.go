@@ -0,0 +1,153 @@
+package lua
+
+import (
+	"iter"
+	"runtime"
+)
+
+/* goroutine backed coroutines {{{ */
+
+// goroutineThread runs a coroutine on a goroutine of its own(see `Options.GoroutineCoroutines`). Control is passed
+// back and forth over unbuffered channels, so the coroutine and the thread resuming it never run at the same time.
+type goroutineThread struct {
+	// resume receives true when the coroutine is resumed, and false when it is killed.
+	resume chan bool
+	// yield receives a value when the coroutine yields, returns or fails.
+	yield chan struct{}
+	// recovered holds a panic that escaped the coroutine. It is raised again on the resuming goroutine.
+	recovered interface{}
+	killed    bool
+}
+
+// threadRunGoroutine is threadRun for states with `Options.GoroutineCoroutines` set.
+func threadRunGoroutine(L *LState) {
+	gt := L.goroutine
+	if gt == nil {
+		gt = &goroutineThread{resume: make(chan bool), yield: make(chan struct{})}
+		L.goroutine = gt
+		L.G.addGoroutineThread(L)
+		go func() {
+			defer func() {
+				if gt.killed {
+					close(gt.yield)
+					return
+				}
+				gt.recovered = recover()
+				L.G.removeGoroutineThread(L)
+				L.goroutine = nil
+				gt.yield <- struct{}{}
+			}()
+			runThread(L)
+		}()
+	} else {
+		gt.resume <- true
+	}
+	<-gt.yield
+	if rcv := gt.recovered; rcv != nil {
+		gt.recovered = nil
+		panic(rcv)
+	}
+}
+
+// yieldGoroutine suspends a coroutine running on its own goroutine. The values above the current function base are
+// passed to the resuming thread. It returns the number of values passed in by the next resume, which are placed
+// above the current function base.
+func (ls *LState) yieldGoroutine() int {
+	parent := ls.Parent
+	if parent == nil {
+		ls.RaiseError("can not yield from outside of a coroutine")
+	}
+	ls.G.CurrentThread = parent
+	ls.Parent = nil
+	if !ls.wrapped {
+		parent.Push(LTrue)
+	}
+	ls.XMoveTo(parent, ls.GetTop())
+	gt := ls.goroutine
+	gt.yield <- struct{}{}
+	if !<-gt.resume {
+		gt.killed = true
+		runtime.Goexit()
+	}
+	return ls.GetTop()
+}
+
+// yieldsAcrossGoCall reports whether a Go function called from the frame cf is below cf on the call stack.
+func yieldsAcrossGoCall(cf *callFrame) bool {
+	for f := cf.Parent; f != nil; f = f.Parent {
+		if f.Fn.IsG {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Global) addGoroutineThread(L *LState) {
+	if g.goroutineThreads == nil {
+		g.goroutineThreads = make(map[*LState]struct{})
+	}
+	g.goroutineThreads[L] = struct{}{}
+}
+
+func (g *Global) removeGoroutineThread(L *LState) {
+	delete(g.goroutineThreads, L)
+}
+
+// killGoroutineThreads stops the goroutines of all suspended coroutines.
+func (g *Global) killGoroutineThreads() {
+	for L := range g.goroutineThreads {
+		L.killGoroutine()
+	}
+}
+
+// killGoroutine stops the goroutine of a suspended coroutine.
+func (ls *LState) killGoroutine() {
+	gt := ls.goroutine
+	if gt == nil {
+		return
+	}
+	ls.G.removeGoroutineThread(ls)
+	ls.goroutine = nil
+	gt.resume <- false
+	<-gt.yield
+}
+
+/* }}} */
+
+/* coroutine iterators {{{ */
+
+// CoroutineSeq runs fn with args in a new coroutine and returns an iterator over the values it yields, one
+// iteration per coroutine.yield:
+//
+//	for values, err := range L.CoroutineSeq(fn) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// The values returned by fn when it finishes are not part of the sequence. An error raised by the coroutine is
+// passed as the last iteration with nil values. The coroutine is closed when the iteration ends, including when the
+// loop is left early.
+func (ls *LState) CoroutineSeq(fn *LFunction, args ...LValue) iter.Seq2[[]LValue, error] {
+	return func(yield func([]LValue, error) bool) {
+		th, _ := ls.NewThread()
+		defer th.Close()
+		state, err, values := ls.Resume(th, fn, args...)
+		for {
+			switch state {
+			case ResumeError:
+				yield(nil, err)
+				return
+			case ResumeOK:
+				return
+			}
+			if !yield(values, nil) {
+				return
+			}
+			state, err, values = ls.Resume(th, nil)
+		}
+	}
+}
+
+/* }}} */
@@ -0,0 +1,154 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+const yieldAcrossGoScript = `
+local co = coroutine.create(function(a)
+  local b = gocall(function(x) return coroutine.yield(x) * 2 end, a)
+  local ok, c = pcall(function() return coroutine.yield(b) end)
+  assert(ok)
+  local t = {3, 1, 2}
+  table.sort(t, function(x, y) coroutine.yield("sort") return x < y end)
+  return c, table.concat(t, ",")
+end)
+local ok, v = coroutine.resume(co, 1)
+assert(ok and v == 1, v)
+ok, v = coroutine.resume(co, 21)
+assert(ok and v == 42, v)
+ok, v = coroutine.resume(co, "c")
+assert(ok and v == "sort", v)
+local c, sorted
+repeat
+  ok, c, sorted = coroutine.resume(co)
+  assert(ok, c)
+until coroutine.status(co) == "dead"
+assert(c == "c" and sorted == "1,2,3")
+`
+
+func gocallFunction(L *LState) int {
+	L.Push(L.CheckFunction(1))
+	L.Push(L.Get(2))
+	L.Call(1, 1)
+	return 1
+}
+
+func TestYieldAcrossGoCall(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("gocall", L.NewFunction(gocallFunction))
+	errorIfScriptNotFail(t, L, yieldAcrossGoScript, `attempt to yield across a Go function call`)
+
+	L = NewState(Options{GoroutineCoroutines: true})
+	defer L.Close()
+	L.SetGlobal("gocall", L.NewFunction(gocallFunction))
+	errorIfScriptFail(t, L, yieldAcrossGoScript)
+	errorIfScriptFail(t, L, `
+local co = coroutine.create(coroutine.yield)
+local ok, a, b = coroutine.resume(co, 1, 2)
+assert(ok and a == 1 and b == 2)
+ok, a = coroutine.resume(co, 3)
+assert(ok and a == 3 and coroutine.status(co) == "dead")
+
+local ok, msg = pcall(coroutine.wrap(function() gocall(error, "boom") end))
+assert(not ok and string.find(msg, "boom", 1, true))
+ok, msg = coroutine.resume(coroutine.create(function() gocall(error, "boom") end))
+assert(not ok and string.find(msg, "boom", 1, true))
+`)
+	errorIfNotEqual(t, 0, len(L.G.goroutineThreads))
+}
+
+func TestGoroutineCoroutinesClose(t *testing.T) {
+	L := NewState(Options{GoroutineCoroutines: true})
+	errorIfScriptFail(t, L, `
+cos = {}
+for i = 1, 10 do
+  cos[i] = coroutine.create(function() pcall(coroutine.yield) end)
+  coroutine.resume(cos[i])
+end
+`)
+	errorIfNotEqual(t, 10, len(L.G.goroutineThreads))
+	co := L.GetGlobal("cos").(*LTable).RawGetInt(1).(*LState)
+	co.Close()
+	errorIfNotEqual(t, 9, len(L.G.goroutineThreads))
+	L.Close()
+	errorIfNotEqual(t, 0, len(L.G.goroutineThreads))
+}
+
+func TestCoroutineWrapErrors(t *testing.T) {
+	for _, goroutines := range []bool{false, true} {
+		L := NewState(Options{GoroutineCoroutines: goroutines})
+		errorIfScriptFail(t, L, `
+local e = {code = 1}
+local co
+local f = coroutine.wrap(function() co = coroutine.running(); error(e) end)
+local ok, v = pcall(f)
+assert(not ok and v == e)
+assert(coroutine.status(co) == "dead")
+assert(coroutine.running() == nil)
+`)
+		err := L.DoString(`
+local function inner() error({code = 2}) end
+local function outer() coroutine.wrap(function() inner() end)() end
+outer()
+`)
+		errorIfNil(t, err)
+		aerr := err.(*ApiError)
+		tb, ok := aerr.Object.(*LTable)
+		errorIfFalse(t, ok, "error object should be preserved, got %v", aerr.Object)
+		errorIfNotEqual(t, LNumber(2), tb.RawGetString("code"))
+		errorIfFalse(t, strings.Contains(aerr.StackTrace, "in function 'inner'\n"), "coroutine traceback missing: %v", aerr.StackTrace)
+		errorIfFalse(t, strings.Contains(aerr.StackTrace, "in function 'outer'\n"), "caller traceback missing: %v", aerr.StackTrace)
+		errorIfNotEqual(t, 1, strings.Count(aerr.StackTrace, "[G]: ?"))
+		names := []string{}
+		for _, frame := range aerr.Frames {
+			names = append(names, frame.FunctionName)
+		}
+		errorIfNotEqual(t, "error,inner,corountine,<gopher-lua.wrapaux>,outer,main chunk", strings.Join(names, ","))
+		L.Close()
+	}
+}
+
+func TestCoroutineSeq(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	function gen(n)
+		for i = 1, n do coroutine.yield(i, i * i) end
+		return "done"
+	end
+	function fail()
+		coroutine.yield(1)
+		error("broken")
+	end
+	`)
+	gen := L.GetGlobal("gen").(*LFunction)
+	var got []string
+	for values, err := range L.CoroutineSeq(gen, LNumber(3)) {
+		errorIfNotNil(t, err)
+		got = append(got, values[0].String()+":"+values[1].String())
+	}
+	errorIfNotEqual(t, "1:1,2:4,3:9", strings.Join(got, ","))
+
+	got = nil
+	for values := range L.CoroutineSeq(gen, LNumber(100)) {
+		if got = append(got, values[0].String()); len(got) == 2 {
+			break
+		}
+	}
+	errorIfNotEqual(t, "1,2", strings.Join(got, ","))
+
+	var errs []error
+	for values, err := range L.CoroutineSeq(L.GetGlobal("fail").(*LFunction)) {
+		if err != nil {
+			errorIfFalse(t, values == nil, "values given with an error")
+		}
+		errs = append(errs, err)
+	}
+	errorIfNotEqual(t, 2, len(errs))
+	errorIfNotNil(t, errs[0])
+	errorIfFalse(t, strings.Contains(errs[1].Error(), "broken"), "unexpected error %v", errs[1])
+	errorIfNotEqual(t, 0, L.GetTop())
+}
@@ -72,6 +72,9 @@ func coResume(L *LState) int {
 		cf.NArgs = nargs
 		th.initCallFrame(cf)
 		th.Panic = panicWithoutTraceback
+		if th.hooks != nil {
+			th.hookCall(cf)
+		}
 	} else {
 		nargs := L.GetTop() - 1
 		L.XMoveTo(th, nargs)
@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package lua
+
+import "time"
+
+// processCPUTime is not available on this platform; os.clock falls back to the wall clock.
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,247 @@
+package lua
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+/* csv library {{{ */
+
+// OpenCsv opens the csv library for reading and writing comma separated values with encoding/csv:
+//
+//	csv.parse(src [, opts])         -- returns the rows of src, a string or a file, or nil and an error message
+//	csv.rows(src [, opts])          -- returns an iterator over the rows of src, reading them as they are needed
+//	csv.generate(rows [, opts])     -- returns rows as a string
+//	csv.write(file, rows [, opts])  -- writes rows to a file
+//
+// Rows are arrays of fields. If opts.header is true, csv.parse and csv.rows take the first row as the names of
+// the columns and return records, tables mapping the names to the fields. If opts.header is an array of names,
+// csv.generate and csv.write write it as the first row and take the fields of records by name.
+// opts.sep sets the field separator(default ","), opts.comment the character starting comment lines,
+// opts.trim removes leading white space from fields, opts.lazyquotes accepts quotes in unquoted fields, and
+// opts.crlf ends written rows with "\r\n". Rows may have different numbers of fields.
+func OpenCsv(L *LState) int {
+	mod := L.RegisterModule(CsvLibName, csvFuncs)
+	L.Push(mod)
+	return 1
+}
+
+var csvFuncs = map[string]LGFunction{
+	"parse":    csvParse,
+	"rows":     csvRows,
+	"generate": csvGenerate,
+	"write":    csvWrite,
+}
+
+// csvOptions returns the options table at n, or an empty table.
+func csvOptions(L *LState, n int) *LTable {
+	if L.Get(n) == LNil {
+		return L.NewTable()
+	}
+	return L.CheckTable(n)
+}
+
+// csvRune returns the single character of the option name, or def.
+func csvRune(L *LState, opts *LTable, name string, def rune) rune {
+	s := opts.GetString(name, "")
+	if s == "" {
+		return def
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size != len(s) {
+		L.RaiseError("csv option '%s' must be a single character", name)
+	}
+	return r
+}
+
+// newCsvReader returns a reader for the string or file at n.
+func newCsvReader(L *LState, n int, opts *LTable) *csv.Reader {
+	var src io.Reader
+	switch lv := L.Get(n).(type) {
+	case LString:
+		src = strings.NewReader(string(lv))
+	case *LUserData:
+		file, ok := lv.Value.(*lFile)
+		if !ok {
+			L.ArgError(n, "string or file expected")
+		}
+		if file.closed {
+			L.ArgError(n, "file is closed")
+		}
+		if file.reader == nil {
+			L.ArgError(n, file.Name()+" is opened for only writing")
+		}
+		src = file.reader
+	default:
+		L.ArgError(n, "string or file expected, got "+lv.Type().String())
+	}
+	r := csv.NewReader(src)
+	r.Comma = csvRune(L, opts, "sep", ',')
+	r.Comment = csvRune(L, opts, "comment", 0)
+	r.TrimLeadingSpace = opts.GetBool("trim", false)
+	r.LazyQuotes = opts.GetBool("lazyquotes", false)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	return r
+}
+
+// csvRowReader converts the rows of a csv reader to tables.
+type csvRowReader struct {
+	reader     *csv.Reader
+	header     []string
+	readHeader bool
+}
+
+func newCsvRowReader(L *LState, n int) *csvRowReader {
+	opts := csvOptions(L, n+1)
+	return &csvRowReader{reader: newCsvReader(L, n, opts), readHeader: opts.GetBool("header", false)}
+}
+
+// next returns the next row, or nil at the end of the input.
+func (rr *csvRowReader) next(L *LState) (*LTable, error) {
+	record, err := rr.reader.Read()
+	if err == nil && rr.readHeader {
+		rr.header = append([]string{}, record...)
+		rr.readHeader = false
+		record, err = rr.reader.Read()
+	}
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rr.header == nil {
+		row := L.CreateTable(len(record), 0)
+		for _, field := range record {
+			row.Append(LString(field))
+		}
+		return row, nil
+	}
+	row := L.CreateTable(0, len(rr.header))
+	for i, field := range record {
+		if i < len(rr.header) {
+			row.RawSetString(rr.header[i], LString(field))
+		} else {
+			row.RawSetInt(i+1, LString(field))
+		}
+	}
+	return row, nil
+}
+
+func csvParse(L *LState) int {
+	rr := newCsvRowReader(L, 1)
+	rows := L.NewTable()
+	for {
+		row, err := rr.next(L)
+		if err != nil {
+			L.Push(LNil)
+			L.Push(LString(err.Error()))
+			return 2
+		}
+		if row == nil {
+			break
+		}
+		rows.Append(row)
+	}
+	L.Push(rows)
+	return 1
+}
+
+func csvRows(L *LState) int {
+	rr := newCsvRowReader(L, 1)
+	L.Push(L.NewFunction(func(L *LState) int {
+		row, err := rr.next(L)
+		if err != nil {
+			L.RaiseError("%s", err.Error())
+		}
+		if row == nil {
+			return 0
+		}
+		L.Push(row)
+		return 1
+	}))
+	return 1
+}
+
+// writeCsvRows writes the rows at n to w with the options at n+1.
+func writeCsvRows(L *LState, w io.Writer, n int) error {
+	rows := L.CheckTable(n)
+	opts := csvOptions(L, n+1)
+	cw := csv.NewWriter(w)
+	cw.Comma = csvRune(L, opts, "sep", ',')
+	cw.UseCRLF = opts.GetBool("crlf", false)
+	var header []string
+	if names := opts.GetTable("header"); names != nil {
+		for i := 1; i <= names.Len(); i++ {
+			header = append(header, LVAsString(names.RawGetInt(i)))
+		}
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+	}
+	var record []string
+	for i := 1; i <= rows.Len(); i++ {
+		row, ok := rows.RawGetInt(i).(*LTable)
+		if !ok {
+			L.RaiseError("invalid row (%s) at index %d for csv", rows.RawGetInt(i).Type().String(), i)
+		}
+		record = record[:0]
+		nfields := row.Len()
+		if header != nil {
+			nfields = intMax(nfields, len(header))
+		}
+		for j := 1; j <= nfields; j++ {
+			var v LValue = LNil
+			if header != nil && j <= len(header) {
+				v = row.RawGetString(header[j-1])
+			}
+			if v == LNil {
+				v = row.RawGetInt(j)
+			}
+			switch v.(type) {
+			case *LNilType:
+				record = append(record, "")
+			case LString, LNumber, LBool:
+				record = append(record, v.String())
+			default:
+				L.RaiseError("invalid value (%s) in row %d for csv", v.Type().String(), i)
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvGenerate(L *LState) int {
+	var buf strings.Builder
+	if err := writeCsvRows(L, &buf, 1); err != nil {
+		L.Push(LNil)
+		L.Push(LString(err.Error()))
+		return 2
+	}
+	L.Push(LString(buf.String()))
+	return 1
+}
+
+func csvWrite(L *LState) int {
+	file := checkFile(L)
+	errorIfFileIsClosed(L, file)
+	if n := fileIsWritable(L, file); n != 0 {
+		return n
+	}
+	if err := writeCsvRows(L, file.writer, 2); err != nil {
+		L.Push(LNil)
+		L.Push(LString(err.Error()))
+		return 2
+	}
+	L.Push(LTrue)
+	return 1
+}
+
+/* }}} */
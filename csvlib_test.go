@@ -0,0 +1,74 @@
+package lua
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCsvLib(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.PreloadExtraLibs()
+	errorIfScriptFail(t, L, `assert(require("csv") == csv)`)
+	errorIfScriptFail(t, L, `
+local rows = csv.parse('a,b,c\n1,"x, y",3\n\n4,5\n')
+assert(#rows == 3 and #rows[1] == 3 and rows[2][2] == "x, y" and #rows[3] == 2 and rows[3][2] == "5")
+
+local recs = csv.parse('name;age\nalice;30\nbob;25;extra\n', {sep = ";", header = true})
+assert(#recs == 2 and recs[1].name == "alice" and recs[1].age == "30" and recs[2][3] == "extra")
+assert(#csv.parse("") == 0 and #csv.parse("name\n", {header = true}) == 0)
+
+rows = csv.parse('# comment\n  a,  b\n', {comment = "#", trim = true})
+assert(#rows == 1 and rows[1][1] == "a" and rows[1][2] == "b")
+
+local r, err = csv.parse('a,"b\n')
+assert(r == nil and string.find(err, "extraneous or missing"))
+
+assert(csv.generate({{"a", "b"}, {1, "x, y"}, {true, nil, "z"}}) == 'a,b\n1,"x, y"\ntrue,,z\n')
+assert(csv.generate({{name = "alice", age = 30}, {name = "bob"}}, {header = {"name", "age"}, sep = ";", crlf = true})
+  == "name;age\r\nalice;30\r\nbob;\r\n")
+`)
+	errorIfScriptNotFail(t, L, `csv.generate({{{}}})`, "invalid value \\(table\\) in row 1 for csv")
+	errorIfScriptNotFail(t, L, `csv.generate({1})`, "invalid row \\(number\\) at index 1 for csv")
+	errorIfScriptNotFail(t, L, `csv.parse("a", {sep = ";;"})`, "csv option 'sep' must be a single character")
+	errorIfScriptNotFail(t, L, `csv.parse(1)`, "string or file expected")
+}
+
+func TestCsvLibFiles(t *testing.T) {
+	skipIfNoSys(t)
+	path := filepath.Join(t.TempDir(), "data.csv")
+	L := NewState()
+	defer L.Close()
+	L.PreloadExtraLibs()
+	errorIfScriptFail(t, L, `assert(require("csv") == csv)`)
+	L.SetGlobal("path", LString(path))
+	errorIfScriptFail(t, L, `
+local f = io.open(path, "w")
+assert(csv.write(f, {{"a", "b"}, {"1", "2"}, {"3", "4"}}, {header = {"id", "value"}}))
+f:close()
+
+f = io.open(path)
+local n = 0
+for rec in csv.rows(f, {header = true}) do
+  n = n + 1
+  assert(rec.id and rec.value)
+end
+f:close()
+assert(n == 3)
+
+f = io.open(path)
+assert(f:read("*l") == "id,value")
+local rows = csv.parse(f)
+assert(#rows == 3 and rows[3][2] == "4")
+f:close()
+
+f = io.open(path)
+local ok, err = csv.write(f, {})
+assert(not ok and string.find(err, "only reading"))
+f:close()
+`)
+	data, err := os.ReadFile(path)
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, "id,value\na,b\n1,2\n3,4\n", string(data))
+}
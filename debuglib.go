@@ -2,7 +2,6 @@ package lua
 
 import (
 	"fmt"
-	"strings"
 )
 
 func OpenDebug(L *LState) int {
@@ -13,26 +12,38 @@ func OpenDebug(L *LState) int {
 
 var debugFuncs = map[string]LGFunction{
 	"getfenv":      debugGetFEnv,
+	"gethook":      debugGetHook,
 	"getinfo":      debugGetInfo,
 	"getlocal":     debugGetLocal,
 	"getmetatable": debugGetMetatable,
 	"getupvalue":   debugGetUpvalue,
 	"setfenv":      debugSetFEnv,
+	"sethook":      debugSetHook,
 	"setlocal":     debugSetLocal,
 	"setmetatable": debugSetMetatable,
 	"setupvalue":   debugSetUpvalue,
 	"traceback":    debugTraceback,
 }
 
+// debugThread returns the thread given as the optional first argument of the debug functions, or L, and the
+// number of arguments before the other arguments.
+func debugThread(L *LState) (*LState, int) {
+	if th, ok := L.Get(1).(*LState); ok {
+		return th, 1
+	}
+	return L, 0
+}
+
 func debugGetFEnv(L *LState) int {
 	L.Push(L.GetFEnv(L.CheckAny(1)))
 	return 1
 }
 
 func debugGetInfo(L *LState) int {
-	L.CheckTypes(1, LTFunction, LTNumber)
-	arg1 := L.Get(1)
-	what := L.OptString(2, "Slunf")
+	th, arg := debugThread(L)
+	L.CheckTypes(arg+1, LTFunction, LTNumber)
+	arg1 := L.Get(arg + 1)
+	what := L.OptString(arg+2, "Slunf")
 	var dbg *Debug
 	var fn LValue
 	var err error
@@ -40,14 +51,14 @@ func debugGetInfo(L *LState) int {
 	switch lv := arg1.(type) {
 	case *LFunction:
 		dbg = &Debug{}
-		fn, err = L.GetInfo(">"+what, dbg, lv)
+		fn, err = th.GetInfo(">"+what, dbg, lv)
 	case LNumber:
-		dbg, ok = L.GetStack(int(lv))
+		dbg, ok = th.GetStack(int(lv))
 		if !ok {
 			L.Push(LNil)
 			return 1
 		}
-		fn, err = L.GetInfo(what, dbg, LNil)
+		fn, err = th.GetInfo(what, dbg, LNil)
 	}
 
 	if err != nil {
@@ -72,13 +83,14 @@ func debugGetInfo(L *LState) int {
 }
 
 func debugGetLocal(L *LState) int {
-	level := L.CheckInt(1)
-	idx := L.CheckInt(2)
-	dbg, ok := L.GetStack(level)
+	th, arg := debugThread(L)
+	level := L.CheckInt(arg + 1)
+	idx := L.CheckInt(arg + 2)
+	dbg, ok := th.GetStack(level)
 	if !ok {
-		L.ArgError(1, "level out of range")
+		L.ArgError(arg+1, "level out of range")
 	}
-	name, value := L.GetLocal(dbg, idx)
+	name, value := th.GetLocal(dbg, idx)
 	if len(name) > 0 {
 		L.Push(LString(name))
 		L.Push(value)
@@ -112,14 +124,15 @@ func debugSetFEnv(L *LState) int {
 }
 
 func debugSetLocal(L *LState) int {
-	level := L.CheckInt(1)
-	idx := L.CheckInt(2)
-	value := L.CheckAny(3)
-	dbg, ok := L.GetStack(level)
+	th, arg := debugThread(L)
+	level := L.CheckInt(arg + 1)
+	idx := L.CheckInt(arg + 2)
+	value := L.CheckAny(arg + 3)
+	dbg, ok := th.GetStack(level)
 	if !ok {
-		L.ArgError(1, "level out of range")
+		L.ArgError(arg+1, "level out of range")
 	}
-	name := L.SetLocal(dbg, idx, value)
+	name := th.SetLocal(dbg, idx, value)
 	if len(name) > 0 {
 		L.Push(LString(name))
 	} else {
@@ -128,6 +141,35 @@ func debugSetLocal(L *LState) int {
 	return 1
 }
 
+func debugGetHook(L *LState) int {
+	th, _ := debugThread(L)
+	dh := th.debugHook
+	if dh == nil {
+		L.Push(LNil)
+		L.Push(emptyLString)
+		L.Push(LNumber(0))
+		return 3
+	}
+	L.Push(dh.fn)
+	L.Push(LString(dh.mask))
+	L.Push(LNumber(dh.count))
+	return 3
+}
+
+func debugSetHook(L *LState) int {
+	// debug.sethook([thread,] hook, mask [, count])
+	th, arg := debugThread(L)
+	if L.GetTop() <= arg || L.Get(arg+1) == LNil {
+		th.setDebugHook(LNil, "", 0)
+		return 0
+	}
+	fn := L.CheckFunction(arg + 1)
+	mask := L.CheckString(arg + 2)
+	count := L.OptInt(arg+3, 0)
+	th.setDebugHook(fn, mask, count)
+	return 0
+}
+
 func debugSetMetatable(L *LState) int {
 	L.CheckTypes(2, LTNil, LTTable)
 	obj := L.Get(1)
@@ -151,22 +193,23 @@ func debugSetUpvalue(L *LState) int {
 }
 
 func debugTraceback(L *LState) int {
-	msg := ""
-	level := L.OptInt(2, 1)
-	ls := L
-	if L.GetTop() > 0 {
-		if s, ok := L.Get(1).(LString); ok {
-			msg = string(s)
-		}
-		if l, ok := L.Get(1).(*LState); ok {
-			ls = l
-			msg = ""
-		}
+	// debug.traceback([thread,] [message [, level]])
+	ls, arg := debugThread(L)
+	level := 1
+	if ls != L {
+		level = 0 // debug.traceback itself does not run on the other thread
+	}
+	level = L.OptInt(arg+2, level)
+	msg := L.Get(arg + 1)
+	if msg != LNil && !LVCanConvToString(msg) {
+		// like Lua 5.2, other messages are returned untouched, e.g. error objects passed by xpcall
+		L.Push(msg)
+		return 1
 	}
 
-	traceback := strings.TrimSpace(ls.stackTrace(level))
-	if len(msg) > 0 {
-		traceback = fmt.Sprintf("%s\n%s", msg, traceback)
+	traceback := ls.stackTrace(level)
+	if msg != LNil {
+		traceback = fmt.Sprintf("%s\n%s", LVAsString(msg), traceback)
 	}
 	L.Push(LString(traceback))
 	return 1
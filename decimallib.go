@@ -0,0 +1,375 @@
+package lua
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+/* decimal library {{{ */
+
+const decimalClass = "decimal"
+
+// decimalDivisionScale is the number of decimal places of quotients computed by the division operator.
+const decimalDivisionScale = 16
+
+// decimalMaxExponent is the largest exponent of parsed decimals, which keeps "1e999999999" from taking all memory.
+const decimalMaxExponent = 10000
+
+// decimal is the fixed-point decimal number unscaled * 10^-scale.
+type decimal struct {
+	unscaled *big.Int
+	scale    int
+}
+
+var bigTen = big.NewInt(10)
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
+
+// rescale returns the unscaled value of d with scale s >= d.scale.
+func (d *decimal) rescale(s int) *big.Int {
+	if s == d.scale {
+		return d.unscaled
+	}
+	return new(big.Int).Mul(d.unscaled, pow10(s-d.scale))
+}
+
+// align returns the unscaled values of x and y with a common scale.
+func alignDecimals(x, y *decimal) (*big.Int, *big.Int, int) {
+	s := max(x.scale, y.scale)
+	return x.rescale(s), y.rescale(s), s
+}
+
+func (d *decimal) String() string {
+	s := new(big.Int).Abs(d.unscaled).String()
+	sign := ""
+	if d.unscaled.Sign() < 0 {
+		sign = "-"
+	}
+	if d.scale <= 0 {
+		return sign + s + strings.Repeat("0", -d.scale)
+	}
+	if len(s) <= d.scale {
+		s = strings.Repeat("0", d.scale-len(s)+1) + s
+	}
+	return sign + s[:len(s)-d.scale] + "." + s[len(s)-d.scale:]
+}
+
+// parseDecimal parses a decimal number like "-12.345" or "1.5e3".
+func parseDecimal(str string) (*decimal, bool) {
+	str = strings.TrimSpace(str)
+	exp := 0
+	if i := strings.IndexAny(str, "eE"); i >= 0 {
+		e, err := strconv.Atoi(str[i+1:])
+		if err != nil || e > decimalMaxExponent || e < -decimalMaxExponent {
+			return nil, false
+		}
+		exp, str = e, str[:i]
+	}
+	sign := ""
+	if len(str) > 0 && (str[0] == '-' || str[0] == '+') {
+		sign, str = str[:1], str[1:]
+	}
+	digits := str
+	scale := 0
+	if i := strings.IndexByte(str, '.'); i >= 0 {
+		digits = str[:i] + str[i+1:]
+		scale = len(str) - i - 1
+	}
+	if len(digits) == 0 {
+		return nil, false
+	}
+	for _, c := range []byte(digits) {
+		if c < '0' || c > '9' {
+			return nil, false
+		}
+	}
+	unscaled, _ := new(big.Int).SetString(sign+digits, 10)
+	return &decimal{unscaled: unscaled, scale: scale - exp}, true
+}
+
+var decimalRoundingModes = []string{"half_even", "half_up", "half_down", "up", "down", "ceiling", "floor"}
+
+// roundQuo returns num / den rounded to an integer with the rounding mode.
+func roundQuo(num, den *big.Int, mode string) *big.Int {
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if r.Sign() == 0 {
+		return q
+	}
+	sign := num.Sign() * den.Sign()
+	// half compares the remainder with half of the divisor
+	twice := new(big.Int).Abs(r)
+	half := twice.Lsh(twice, 1).Cmp(new(big.Int).Abs(den))
+	var away bool
+	switch mode {
+	case "half_even":
+		away = half > 0 || half == 0 && q.Bit(0) == 1
+	case "half_up":
+		away = half >= 0
+	case "half_down":
+		away = half > 0
+	case "up":
+		away = true
+	case "down":
+		away = false
+	case "ceiling":
+		away = sign > 0
+	case "floor":
+		away = sign < 0
+	}
+	if away {
+		q.Add(q, big.NewInt(int64(sign)))
+	}
+	return q
+}
+
+// round returns d rounded to scale decimal places.
+func (d *decimal) round(scale int, mode string) *decimal {
+	if scale >= d.scale {
+		return &decimal{unscaled: d.rescale(scale), scale: scale}
+	}
+	return &decimal{unscaled: roundQuo(d.unscaled, pow10(d.scale-scale), mode), scale: scale}
+}
+
+// div returns d / y rounded to scale decimal places. y must not be zero.
+func (d *decimal) div(y *decimal, scale int, mode string) *decimal {
+	num := new(big.Int).Set(d.unscaled)
+	den := new(big.Int).Set(y.unscaled)
+	// d / y * 10^scale = d.unscaled * 10^(scale+y.scale-d.scale) / y.unscaled
+	if e := scale + y.scale - d.scale; e >= 0 {
+		num.Mul(num, pow10(e))
+	} else {
+		den.Mul(den, pow10(-e))
+	}
+	return &decimal{unscaled: roundQuo(num, den, mode), scale: scale}
+}
+
+// OpenDecimal opens the decimal library, which provides fixed-point decimal numbers for calculations with money
+// and other values that must not be rounded like floats are:
+//
+//	local price = decimal.new("19.99")
+//	print(price * 3, (price / 3):round(2, "half_up"))
+//
+// Addition, subtraction, multiplication and exponentiation by integers are exact. The division operator rounds
+// the quotient to 16 decimal places with the half_even rounding mode; decimal.div rounds to a given scale and
+// mode. The rounding modes are half_even, half_up, half_down, up, down, ceiling and floor. Numbers and strings
+// are converted to decimals when they are mixed with them; numbers are converted from their shortest
+// representation, so 0.1 becomes decimal 0.1.
+func OpenDecimal(L *LState) int {
+	ut := RegisterType(L, decimalClass, decimalMethods)
+	L.SetFuncs(ut.Metatable, map[string]LGFunction{
+		"__add":      decimalArith(func(z, x, y *big.Int) *big.Int { return z.Add(x, y) }),
+		"__sub":      decimalArith(func(z, x, y *big.Int) *big.Int { return z.Sub(x, y) }),
+		"__mul":      decimalMul,
+		"__div":      decimalDivOp,
+		"__mod":      decimalMod,
+		"__pow":      decimalPow,
+		"__unm":      decimalUnm,
+		"__eq":       decimalCompareOp(func(c int) bool { return c == 0 }),
+		"__lt":       decimalCompareOp(func(c int) bool { return c < 0 }),
+		"__le":       decimalCompareOp(func(c int) bool { return c <= 0 }),
+		"__tostring": decimalToString,
+		"__concat":   decimalConcat,
+	})
+	mod := L.RegisterModule(DecimalLibName, decimalFuncs)
+	L.Push(mod)
+	return 1
+}
+
+var decimalFuncs = map[string]LGFunction{
+	"new": func(L *LState) int {
+		L.CheckAny(1)
+		pushDecimal(L, checkDecimal(L, 1))
+		return 1
+	},
+	"isdecimal": func(L *LState) int {
+		_, ok := decimalType(L).Test(L.Get(1))
+		L.Push(LBool(ok))
+		return 1
+	},
+	"div": func(L *LState) int {
+		x, y := checkDecimal(L, 1), checkDecimal(L, 2)
+		pushDecimal(L, decimalDiv(L, x, y, L.CheckInt(3), 4))
+		return 1
+	},
+	"round": func(L *LState) int {
+		pushDecimal(L, checkDecimal(L, 1).round(L.OptInt(2, 0), checkRoundingMode(L, 3)))
+		return 1
+	},
+	"cmp": func(L *LState) int {
+		L.Push(LNumber(compareDecimals(checkDecimal(L, 1), checkDecimal(L, 2))))
+		return 1
+	},
+}
+
+var decimalMethods = Methods[*decimal]{
+	"tostring": func(L *LState, d *decimal) int {
+		L.Push(LString(d.String()))
+		return 1
+	},
+	"tonumber": func(L *LState, d *decimal) int {
+		f, _ := strconv.ParseFloat(d.String(), 64)
+		L.Push(LNumber(f))
+		return 1
+	},
+	"scale": func(L *LState, d *decimal) int {
+		L.Push(LNumber(d.scale))
+		return 1
+	},
+	"sign": func(L *LState, d *decimal) int {
+		L.Push(LNumber(d.unscaled.Sign()))
+		return 1
+	},
+	"abs": func(L *LState, d *decimal) int {
+		pushDecimal(L, &decimal{unscaled: new(big.Int).Abs(d.unscaled), scale: d.scale})
+		return 1
+	},
+	"round": func(L *LState, d *decimal) int {
+		pushDecimal(L, d.round(L.OptInt(2, 0), checkRoundingMode(L, 3)))
+		return 1
+	},
+	"div": func(L *LState, d *decimal) int {
+		pushDecimal(L, decimalDiv(L, d, checkDecimal(L, 2), L.CheckInt(3), 4))
+		return 1
+	},
+	"cmp": func(L *LState, d *decimal) int {
+		L.Push(LNumber(compareDecimals(d, checkDecimal(L, 2))))
+		return 1
+	},
+}
+
+func decimalType(L *LState) *UserType[*decimal] {
+	return &UserType[*decimal]{Name: decimalClass, Metatable: L.GetTypeMetatable(decimalClass).(*LTable)}
+}
+
+func pushDecimal(L *LState, d *decimal) {
+	decimalType(L).Push(L, d)
+}
+
+func toDecimal(L *LState, lv LValue) (*decimal, bool) {
+	switch v := lv.(type) {
+	case *LUserData:
+		return decimalType(L).Test(v)
+	case LNumber:
+		f := float64(v)
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, false
+		}
+		return parseDecimal(strconv.FormatFloat(f, 'g', -1, 64))
+	case LString:
+		return parseDecimal(string(v))
+	}
+	return nil, false
+}
+
+func checkDecimal(L *LState, n int) *decimal {
+	d, ok := toDecimal(L, L.Get(n))
+	if !ok {
+		if L.Get(n).Type() == LTString {
+			L.ArgError(n, "invalid decimal")
+		}
+		L.ArgError(n, "decimal expected, got "+L.Get(n).Type().String())
+	}
+	return d
+}
+
+func checkRoundingMode(L *LState, n int) string {
+	if L.Get(n) == LNil {
+		return decimalRoundingModes[0]
+	}
+	return decimalRoundingModes[L.CheckOption(n, decimalRoundingModes)]
+}
+
+// decimalDiv returns x / y rounded to scale decimal places with the rounding mode given as argument n.
+func decimalDiv(L *LState, x, y *decimal, scale int, n int) *decimal {
+	if y.unscaled.Sign() == 0 {
+		L.RaiseError("attempt to divide by zero")
+	}
+	return x.div(y, scale, checkRoundingMode(L, n))
+}
+
+func compareDecimals(x, y *decimal) int {
+	ux, uy, _ := alignDecimals(x, y)
+	return ux.Cmp(uy)
+}
+
+func decimalArith(op func(z, x, y *big.Int) *big.Int) LGFunction {
+	return func(L *LState) int {
+		ux, uy, scale := alignDecimals(checkDecimal(L, 1), checkDecimal(L, 2))
+		pushDecimal(L, &decimal{unscaled: op(new(big.Int), ux, uy), scale: scale})
+		return 1
+	}
+}
+
+func decimalMul(L *LState) int {
+	x, y := checkDecimal(L, 1), checkDecimal(L, 2)
+	pushDecimal(L, &decimal{unscaled: new(big.Int).Mul(x.unscaled, y.unscaled), scale: x.scale + y.scale})
+	return 1
+}
+
+func decimalDivOp(L *LState) int {
+	x, y := checkDecimal(L, 1), checkDecimal(L, 2)
+	pushDecimal(L, decimalDiv(L, x, y, decimalDivisionScale, 0))
+	return 1
+}
+
+// decimalMod returns x - floor(x / y) * y, which is exact.
+func decimalMod(L *LState) int {
+	x, y := checkDecimal(L, 1), checkDecimal(L, 2)
+	if y.unscaled.Sign() == 0 {
+		L.RaiseError("attempt to divide by zero")
+	}
+	q := x.div(y, 0, "floor")
+	ux, uy, scale := alignDecimals(x, y)
+	pushDecimal(L, &decimal{unscaled: new(big.Int).Sub(ux, new(big.Int).Mul(q.unscaled, uy)), scale: scale})
+	return 1
+}
+
+func decimalPow(L *LState) int {
+	x := checkDecimal(L, 1)
+	e, ok := L.Get(2).(LNumber)
+	if !ok || e < 0 || e != LNumber(math.Trunc(float64(e))) {
+		L.RaiseError("attempt to raise a decimal to a power that is not a non-negative integer")
+	}
+	pushDecimal(L, &decimal{unscaled: new(big.Int).Exp(x.unscaled, big.NewInt(int64(e)), nil), scale: x.scale * int(e)})
+	return 1
+}
+
+func decimalUnm(L *LState) int {
+	d := checkDecimal(L, 1)
+	pushDecimal(L, &decimal{unscaled: new(big.Int).Neg(d.unscaled), scale: d.scale})
+	return 1
+}
+
+func decimalCompareOp(test func(int) bool) LGFunction {
+	return func(L *LState) int {
+		L.Push(LBool(test(compareDecimals(checkDecimal(L, 1), checkDecimal(L, 2)))))
+		return 1
+	}
+}
+
+func decimalToString(L *LState) int {
+	L.Push(LString(checkDecimal(L, 1).String()))
+	return 1
+}
+
+func decimalConcat(L *LState) int {
+	var buf []byte
+	for i := 1; i <= 2; i++ {
+		lv := L.Get(i)
+		if d, ok := decimalType(L).Test(lv); ok {
+			buf = append(buf, d.String()...)
+		} else if LVCanConvToString(lv) {
+			buf = append(buf, LVAsString(lv)...)
+		} else {
+			L.RaiseError("attempt to concatenate a %s value", lv.Type().String())
+		}
+	}
+	L.Push(LString(buf))
+	return 1
+}
+
+/* }}} */
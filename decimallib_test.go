@@ -0,0 +1,39 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestDecimalLib(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.PreloadExtraLibs()
+	errorIfScriptFail(t, L, `assert(require("decimal") == decimal)`)
+	errorIfScriptFail(t, L, `
+local d = decimal.new
+assert(tostring(d(0.1) + d(0.2)) == "0.3" and d(0.1) + 0.2 == d("0.3"))
+assert(tostring(d("19.99") * 3) == "59.97")
+assert(tostring(d("1.50")) == "1.50" and d("1.50") == d("1.5"))
+assert(tostring(d("1.5e3")) == "1500" and tostring(d("-12e-3")) == "-0.012")
+assert(tostring(d(1) / 3) == "0.3333333333333333")
+assert(tostring(decimal.div(2, 3, 2)) == "0.67" and tostring(d(2):div(3, 2, "down")) == "0.66")
+local cases = {
+  {"2.5", "half_even", "2"}, {"3.5", "half_even", "4"}, {"-2.5", "half_even", "-2"},
+  {"2.5", "half_up", "3"}, {"-2.5", "half_up", "-3"}, {"2.5", "half_down", "2"},
+  {"2.1", "up", "3"}, {"-2.1", "up", "-3"}, {"2.9", "down", "2"},
+  {"-2.1", "ceiling", "-2"}, {"2.1", "ceiling", "3"}, {"-2.1", "floor", "-3"},
+}
+for _, c in ipairs(cases) do
+  local r = tostring(d(c[1]):round(0, c[2]))
+  assert(r == c[3], c[1] .. " " .. c[2] .. " " .. r)
+end
+assert(tostring(d("1.005"):round(2, "half_up")) == "1.01" and tostring(decimal.round("1.2", 3)) == "1.200")
+assert(tostring(d("-7.5") % 2) == "0.5" and tostring(d("0.5") ^ 3) == "0.125" and tostring(-d("1.2")) == "-1.2")
+assert(d("1.1") < d("1.2") and d(2) <= d("2.00") and decimal.cmp("1", 0.5) == 1)
+assert(d("-3.25"):abs():tonumber() == 3.25 and d("-3.25"):sign() == -1 and d("3.250"):scale() == 3)
+assert("total: " .. d("9.90") == "total: 9.90" and decimal.isdecimal(d(1)) and not decimal.isdecimal(1))
+`)
+	errorIfScriptNotFail(t, L, `return decimal.new("1.2.3")`, "invalid decimal")
+	errorIfScriptNotFail(t, L, `return decimal.new(1) / 0`, "attempt to divide by zero")
+	errorIfScriptNotFail(t, L, `return decimal.new(1):round(0, "nearest")`, "invalid option")
+}
@@ -0,0 +1,65 @@
+package lua
+
+import (
+	"io"
+	"strings"
+
+	"github.com/r0kyi/gopher-lua/ast"
+	"github.com/r0kyi/gopher-lua/parse"
+)
+
+/* expression evaluation {{{ */
+
+// evalChunkName is the chunk name of expressions evaluated by Eval.
+const evalChunkName = "<eval>"
+
+// Eval evaluates the single Lua expression expr with the global environment and returns its value. Errors raised
+// while evaluating are returned as an *ApiError like PCall does; an *ApiError of type ApiErrorSyntax is returned
+// if expr is not exactly one expression, or if the Checker of the state(see `Options.Checker`) reports
+// diagnostics for it.
+func (ls *LState) Eval(expr string) (LValue, error) {
+	return ls.EvalWithEnv(expr, nil)
+}
+
+// EvalWithEnv works like Eval, but evaluates expr in env, so that the names in expr refer to the fields of env.
+// A nil env means the current environment.
+func (ls *LState) EvalWithEnv(expr string, env *LTable) (LValue, error) {
+	chunk, err := ls.parseExpr(expr)
+	if err != nil {
+		return LNil, err
+	}
+	fn, err := ls.compileChunk(chunk, evalChunkName, nil)
+	if err != nil {
+		return LNil, err
+	}
+	if env != nil {
+		fn.Env = env
+	}
+	base := ls.GetTop()
+	ls.Push(fn)
+	if err := ls.PCall(0, 1, nil); err != nil {
+		return LNil, err
+	}
+	value := ls.Get(-1)
+	ls.SetTop(base)
+	return value, nil
+}
+
+// parseExpr parses expr as the chunk "return expr", after making sure it does not contain anything but a single
+// expression, and runs the Checker of the state on the chunk like Load does.
+func (ls *LState) parseExpr(expr string) ([]ast.Stmt, error) {
+	chunk, err := ls.parseChecked(strings.NewReader(expr), evalChunkName,
+		func(reader io.Reader, name string) ([]ast.Stmt, error) {
+			e, err := parse.ParseExpr(reader, name)
+			if err != nil {
+				return nil, err
+			}
+			return []ast.Stmt{exprReturnStmt(e)}, nil
+		})
+	if err != nil {
+		return nil, newApiErrorE(ApiErrorSyntax, err)
+	}
+	return chunk, nil
+}
+
+/* }}} */
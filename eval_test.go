@@ -0,0 +1,34 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("x", LNumber(20))
+	v, err := L.Eval("x * 2 + 2 -- the answer")
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, LNumber(42), v)
+	errorIfNotEqual(t, 0, L.GetTop())
+
+	v, err = L.Eval(`string.format("%d-%d", 1, 2)`)
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, LString("1-2"), v)
+
+	env := L.NewTable()
+	env.RawSetString("age", LNumber(30))
+	v, err = L.EvalWithEnv("age >= 18 and x == nil", env)
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, LTrue, v)
+
+	for _, expr := range []string{"1), (2", "1) x = (2", "", "local x = 1"} {
+		_, err = L.Eval(expr)
+		errorIfFalse(t, err != nil && err.(*ApiError).Type == ApiErrorSyntax, "syntax error expected for %q, got %v", expr, err)
+	}
+	_, err = L.Eval("nil + 1")
+	errorIfFalse(t, err != nil && strings.Contains(err.Error(), "<eval>:1:"), "unexpected error: %v", err)
+	errorIfNotEqual(t, 0, L.GetTop())
+}
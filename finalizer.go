@@ -0,0 +1,196 @@
+package lua
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"weak"
+)
+
+/* finalizers {{{ */
+
+// finalizers tracks the objects to be finalized. Marked objects are only referenced weakly, so that they can be
+// collected: when an object becomes unreachable, the Go garbage collector queues it in pending, and the state
+// calls its handlers at the next safe point(see runPendingFinalizers). The objects still alive when the state is
+// closed are finalized by Close. Since the handlers need the object, it is kept by a Go finalizer
+// (runtime.SetFinalizer) rather than a cleanup, and Go never collects an object with a finalizer that can reach
+// itself, e.g. a table with t.self = t or a metatable that is its own metatable. Such objects are only finalized
+// by Close.
+type finalizers struct {
+	// ids maps the weak pointers of the marked objects to their marks, so that an object is marked at most once.
+	ids  map[interface{}]uint64
+	live map[uint64]finalizerRef
+	last uint64
+
+	mu       sync.Mutex
+	pending  []pendingFinalizer
+	npending atomic.Int32
+	closed   bool
+	running  bool
+}
+
+type finalizerRef struct {
+	key   interface{}
+	value func() LValue
+}
+
+type pendingFinalizer struct {
+	id  uint64
+	obj LValue
+}
+
+// markFinalizer marks obj for finalization if mt has a __gc or __close field. Like in Lua, an object is only
+// marked if the field is present when the metatable is set, and an object is marked at most once.
+func (g *Global) markFinalizer(obj LValue, mt LValue) {
+	tb, ok := mt.(*LTable)
+	if !ok || (tb.RawGetString("__gc") == LNil && tb.RawGetString("__close") == LNil) {
+		return
+	}
+	fz := &g.finalizers
+	var key interface{}
+	var value func() LValue
+	switch v := obj.(type) {
+	case *LTable:
+		key, value = weak.Make(v), weakValue(v)
+	case *LUserData:
+		key, value = weak.Make(v), weakValue(v)
+	default:
+		return
+	}
+	if _, ok := fz.ids[key]; ok {
+		return
+	}
+	if fz.ids == nil {
+		fz.ids = make(map[interface{}]uint64)
+		fz.live = make(map[uint64]finalizerRef)
+	}
+	fz.last++
+	id := fz.last
+	fz.ids[key] = id
+	fz.live[id] = finalizerRef{key: key, value: value}
+	switch v := obj.(type) {
+	case *LTable:
+		runtime.SetFinalizer(v, func(v *LTable) { fz.queue(id, v) })
+	case *LUserData:
+		runtime.SetFinalizer(v, func(v *LUserData) { fz.queue(id, v) })
+	}
+}
+
+// queue is called by the Go garbage collector when the marked object obj has become unreachable. obj stays alive
+// until its handlers have been called.
+func (fz *finalizers) queue(id uint64, obj LValue) {
+	fz.mu.Lock()
+	defer fz.mu.Unlock()
+	if fz.closed {
+		return
+	}
+	fz.pending = append(fz.pending, pendingFinalizer{id, obj})
+	fz.npending.Add(1)
+}
+
+// takePending returns the objects queued by the garbage collector in the order they were marked, and forgets
+// them.
+func (fz *finalizers) takePending() []pendingFinalizer {
+	fz.mu.Lock()
+	pending := fz.pending
+	fz.pending = nil
+	fz.npending.Store(0)
+	fz.mu.Unlock()
+	for _, p := range pending {
+		if ref, ok := fz.live[p.id]; ok {
+			delete(fz.ids, ref.key)
+			delete(fz.live, p.id)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].id < pending[j].id })
+	return pending
+}
+
+// runPendingFinalizers calls the handlers of the objects that have been found unreachable by the garbage
+// collector. It is called by the main thread between calls(see `LState.PCall`) and by collectgarbage, and does
+// nothing while handlers are running.
+func (ls *LState) runPendingFinalizers() {
+	fz := &ls.G.finalizers
+	if fz.npending.Load() == 0 || fz.running || ls != ls.G.MainThread {
+		return
+	}
+	fz.running = true
+	defer func() { fz.running = false }()
+	for pending := fz.takePending(); len(pending) > 0; pending = fz.takePending() {
+		for i := len(pending) - 1; i >= 0; i-- {
+			ls.finalize(pending[i].obj)
+		}
+	}
+}
+
+// runFinalizers calls the __close and __gc handlers of the marked objects that are still alive or have not been
+// finalized yet, in the reverse order they were marked. __close is called with the object and nil, as if a
+// to-be-closed variable went out of scope, and __gc with the object. The handlers are looked up in the current
+// metatable of the object. Errors raised by the handlers are ignored, and objects marked by the handlers are
+// finalized as well.
+func (ls *LState) runFinalizers() {
+	fz := &ls.G.finalizers
+	fz.running = true
+	objects := fz.takeAll(0)
+	for len(objects) > 0 {
+		p := objects[len(objects)-1]
+		objects = objects[:len(objects)-1]
+		last := fz.last
+		ls.finalize(p.obj)
+		// objects marked by the handler are finalized next
+		objects = append(objects, fz.takeAll(last)...)
+		if len(objects) == 0 {
+			objects = fz.takeAll(0)
+		}
+	}
+	fz.mu.Lock()
+	fz.closed = true
+	fz.pending = nil
+	fz.mu.Unlock()
+}
+
+// takeAll returns the queued objects and the live objects marked after the mark since in the order they were
+// marked, and forgets them.
+func (fz *finalizers) takeAll(since uint64) []pendingFinalizer {
+	objects := fz.takePending()
+	for id, ref := range fz.live {
+		if id <= since {
+			continue
+		}
+		if obj := ref.value(); obj != LNil {
+			objects = append(objects, pendingFinalizer{id, obj})
+			runtime.SetFinalizer(obj, nil)
+		}
+		delete(fz.ids, ref.key)
+		delete(fz.live, id)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].id < objects[j].id })
+	return objects
+}
+
+func (ls *LState) finalize(obj LValue) {
+	mt, ok := ls.metatable(obj, true).(*LTable)
+	if !ok {
+		return
+	}
+	if fn := mt.RawGetString("__close"); fn != LNil {
+		ls.callFinalizer(fn, obj, LNil)
+	}
+	if fn := mt.RawGetString("__gc"); fn != LNil {
+		ls.callFinalizer(fn, obj)
+	}
+}
+
+func (ls *LState) callFinalizer(fn LValue, args ...LValue) {
+	top := ls.GetTop()
+	ls.Push(fn)
+	for _, arg := range args {
+		ls.Push(arg)
+	}
+	// ignore errors in finalizers
+	ls.PCall(len(args), 0, nil)
+	ls.SetTop(top)
+}
+
+/* }}} */
@@ -2,7 +2,10 @@ package lua
 
 import (
 	"fmt"
+	"reflect"
+	"runtime"
 	"strings"
+	"sync"
 )
 
 const (
@@ -40,8 +43,179 @@ type FunctionProto struct {
 	DbgUpvalues        []string
 
 	stringConstants []string
+	// inlineCacheSlots maps a pc to the index of its inline cache entry
+	// in LFunction.inlineCaches, or -1 if the instruction is not cacheable.
+	inlineCacheSlots []int32
+	numInlineCaches  int
 }
 
+/* inlineCache {{{ */
+
+// inlineCache remembers the result of the last string keyed lookup executed by
+// a single GETGLOBAL, GETTABLEKS or SELF instruction. An entry is valid as long
+// as the instruction sees the same table with the same version. If meta is not
+// nil, value was found in table as the __index table of meta, and the entry is
+// also valid for other objects with this metatable as long as meta keeps its
+// version.
+type inlineCache struct {
+	table       *LTable
+	version     uint64
+	value       LValue
+	meta        *LTable
+	metaVersion uint64
+}
+
+func isInlineCacheable(inst uint32) bool {
+	switch opGetOpCode(inst) {
+	case OP_GETGLOBAL:
+		return true
+	case OP_GETTABLEKS, OP_SELF, OP_SELFCALL:
+		return opIsK(opGetArgC(inst))
+	}
+	return false
+}
+
+// assignInlineCaches assigns an inline cache slot to every cacheable
+// instruction of this prototype. It must be called again whenever Code changes.
+func (fp *FunctionProto) assignInlineCaches() {
+	fp.inlineCacheSlots = make([]int32, len(fp.Code))
+	fp.numInlineCaches = 0
+	for pc := 0; pc < len(fp.Code); pc++ {
+		inst := fp.Code[pc]
+		if opGetOpCode(inst) == OP_CLOSURE {
+			fp.inlineCacheSlots[pc] = -1
+			for i := 0; i < int(fp.FunctionPrototypes[opGetArgBx(inst)].NumUpvalues); i++ {
+				pc++
+				fp.inlineCacheSlots[pc] = -1
+			}
+			continue
+		}
+		if isInlineCacheable(inst) {
+			fp.inlineCacheSlots[pc] = int32(fp.numInlineCaches)
+			fp.numInlineCaches++
+		} else {
+			fp.inlineCacheSlots[pc] = -1
+		}
+	}
+}
+
+/* }}} */
+
+/* superinstructions {{{ */
+
+// operandWords returns the number of code words following the instruction at
+// pc that are operands of that instruction rather than instructions.
+func (fp *FunctionProto) operandWords(pc int) int {
+	inst := fp.Code[pc]
+	switch opGetOpCode(inst) {
+	case OP_MOVEN:
+		return opGetArgC(inst)
+	case OP_CLOSURE:
+		return int(fp.FunctionPrototypes[opGetArgBx(inst)].NumUpvalues)
+	case OP_SETLIST:
+		if opGetArgC(inst) == 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// fuseInstructions replaces common instruction pairs with superinstructions.
+// The second instruction of a pair is left in place, so jumps into it still
+// behave as before.
+func (fp *FunctionProto) fuseInstructions() {
+	code := fp.Code
+	for pc := 0; pc+1 < len(code); pc += fp.operandWords(pc) + 1 {
+		inst, next := code[pc], code[pc+1]
+		fused := -1
+		switch opGetOpCode(inst) {
+		case OP_EQ:
+			fused = OP_EQJMP
+		case OP_LT:
+			fused = OP_LTJMP
+		case OP_LE:
+			fused = OP_LEJMP
+		case OP_TEST:
+			fused = OP_TESTJMP
+		case OP_SELF:
+			if opGetArgA(next) == opGetArgA(inst) && opGetArgB(next) == 2 {
+				fused = OP_SELFCALL
+			}
+		case OP_LOADK:
+			if opGetArgA(next) == opGetArgA(inst) && opGetArgB(next) == 2 {
+				fused = OP_RETURNK
+			}
+		}
+		if fused >= 0 && opGetOpCode(next) == superInstructions[fused][1] {
+			opSetOpCode(&code[pc], fused)
+			pc++
+		}
+	}
+}
+
+// unfuseInstructions reverts fuseInstructions.
+func (fp *FunctionProto) unfuseInstructions() {
+	code := fp.Code
+	for pc := 0; pc < len(code); pc += fp.operandWords(pc) + 1 {
+		if pair, ok := superInstructions[opGetOpCode(code[pc])]; ok {
+			opSetOpCode(&code[pc], pair[0])
+		}
+	}
+}
+
+/* }}} */
+
+/* name table {{{ */
+
+// nameTable interns the names in the debug information of function prototypes, the names of local variables,
+// upvalues and called functions, and the source names. The parser allocates a string for every occurrence of a
+// name, so chunks compiled into the same nameTable share one string per distinct name instead. Every state has a
+// nameTable for the chunks it loads, and it only grows while the state lives.
+type nameTable struct {
+	mu    sync.Mutex
+	names map[string]string
+}
+
+// intern returns the string equal to name in the table, adding name if there is none.
+func (nt *nameTable) intern(name string) string {
+	if s, ok := nt.names[name]; ok {
+		return s
+	}
+	if nt.names == nil {
+		nt.names = make(map[string]string)
+	}
+	nt.names[name] = name
+	return name
+}
+
+// internProto replaces the names in the debug information of proto and its nested prototypes with the strings in
+// the table.
+func (nt *nameTable) internProto(proto *FunctionProto) {
+	nt.internNames(proto)
+	for _, child := range proto.FunctionPrototypes {
+		nt.internProto(child)
+	}
+}
+
+// internNames replaces the names in the debug information of proto, but not of its nested prototypes, with the
+// strings in the table.
+func (nt *nameTable) internNames(proto *FunctionProto) {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+	proto.SourceName = nt.intern(proto.SourceName)
+	for _, local := range proto.DbgLocals {
+		local.Name = nt.intern(local.Name)
+	}
+	for i := range proto.DbgCalls {
+		proto.DbgCalls[i].Name = nt.intern(proto.DbgCalls[i].Name)
+	}
+	for i, name := range proto.DbgUpvalues {
+		proto.DbgUpvalues[i] = nt.intern(name)
+	}
+}
+
+/* }}} */
+
 /* Upvalue {{{ */
 
 type Upvalue struct {
@@ -174,6 +348,28 @@ func newLFunctionG(gfunc LGFunction, env *LTable, nupvalue int) *LFunction {
 	}
 }
 
+// goFunctionName returns a name for a Go function to be shown in tracebacks:
+// the name it has been registered under, or else the name of the Go function
+// as reported by the runtime enclosed in angle brackets. It returns an empty
+// string for Lua functions.
+func (fn *LFunction) goFunctionName() string {
+	if !fn.IsG || fn.GFunction == nil {
+		return ""
+	}
+	if fn.name != "" {
+		return fn.name
+	}
+	f := runtime.FuncForPC(reflect.ValueOf(fn.GFunction).Pointer())
+	if f == nil {
+		return ""
+	}
+	name := f.Name()
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		name = name[i+1:]
+	}
+	return "<" + name + ">"
+}
+
 func (fn *LFunction) LocalName(regno, pc int) (string, bool) {
 	if fn.IsG {
 		return "", false
@@ -190,4 +386,123 @@ func (fn *LFunction) LocalName(regno, pc int) (string, bool) {
 	return "", false
 }
 
+// VariableName describes the value held by register regno(0-based) when the
+// instruction at pc is executed, by consulting debug information and
+// constants like PUC-Lua's getobjname. kind is one of "local", "global",
+// "field", "method", "upvalue" or "constant". ok is false if nothing is known
+// about the value.
+func (fn *LFunction) VariableName(regno, pc int) (kind string, name string, ok bool) {
+	if fn.IsG {
+		return "", "", false
+	}
+	for depth := 0; depth < 8; depth++ {
+		p := fn.Proto
+		if name, ok := p.activeLocalName(regno, pc); ok {
+			return "local", name, true
+		}
+		setpc := p.findSetReg(pc, regno)
+		if setpc < 0 {
+			return "", "", false
+		}
+		inst := p.Code[setpc]
+		switch opGetOpCode(inst) {
+		case OP_MOVE:
+			if b := opGetArgB(inst); b < opGetArgA(inst) {
+				regno, pc = b, setpc
+				continue
+			}
+		case OP_GETGLOBAL:
+			return p.constantName("global", opGetArgBx(inst))
+		case OP_GETTABLE, OP_GETTABLEKS:
+			if c := opGetArgC(inst); opIsK(c) {
+				return p.constantName("field", opIndexK(c))
+			}
+		case OP_GETUPVAL:
+			if b := opGetArgB(inst); b < len(p.DbgUpvalues) {
+				return "upvalue", p.DbgUpvalues[b], true
+			}
+		case OP_LOADK, OP_RETURNK:
+			return p.constantName("constant", opGetArgBx(inst))
+		case OP_SELF, OP_SELFCALL:
+			if c := opGetArgC(inst); opIsK(c) && regno == opGetArgA(inst) {
+				return p.constantName("method", opIndexK(c))
+			}
+		}
+		break
+	}
+	return "", "", false
+}
+
+// activeLocalName returns the name of the local variable held by register
+// regno(0-based) while the instruction at pc is executed.
+func (fp *FunctionProto) activeLocalName(regno, pc int) (string, bool) {
+	for _, local := range fp.DbgLocals {
+		if local.StartPc > pc {
+			break
+		}
+		if pc < local.EndPc {
+			if regno == 0 {
+				return local.Name, true
+			}
+			regno--
+		}
+	}
+	return "", false
+}
+
+func (fp *FunctionProto) constantName(kind string, idx int) (string, string, bool) {
+	if idx < len(fp.Constants) {
+		if s, ok := fp.Constants[idx].(LString); ok {
+			return kind, string(s), true
+		}
+	}
+	return "", "", false
+}
+
+// findSetReg returns the pc of the last instruction before lastpc that
+// unconditionally stores into reg, or -1.
+func (fp *FunctionProto) findSetReg(lastpc, reg int) int {
+	setreg := -1
+	jmptarget := 0
+	code := fp.Code
+	for pc := 0; pc < lastpc && pc < len(code); pc++ {
+		inst := code[pc]
+		op := opGetOpCode(inst)
+		a := opGetArgA(inst)
+		change := false
+		switch op {
+		case OP_LOADNIL:
+			change = a <= reg && reg <= opGetArgB(inst)
+		case OP_TFORLOOP, OP_TFORLOOPI:
+			change = reg >= a+2
+		case OP_CALL, OP_TAILCALL, OP_SELECTVA:
+			change = reg >= a
+		case OP_SELF, OP_SELFCALL:
+			change = reg == a || reg == a+1
+		case OP_FORLOOP:
+			change = reg == a || reg == a+3
+		case OP_VARARG:
+			change = reg >= a
+		case OP_JMP:
+			if dest := pc + 1 + opGetArgSbx(inst); pc < dest && dest <= lastpc && dest > jmptarget {
+				jmptarget = dest
+			}
+		case OP_TEST, OP_TESTJMP:
+		default:
+			change = opProps[op].SetRegA && reg == a
+		}
+		if change {
+			if pc < jmptarget {
+				setreg = -1
+			} else {
+				setreg = pc
+			}
+		}
+		if op != OP_MOVEN {
+			pc += fp.operandWords(pc)
+		}
+	}
+	return setreg
+}
+
 /* }}} */
@@ -2,7 +2,11 @@ module github.com/r0kyi/gopher-lua
 
 go 1.24.0
 
-require github.com/chzyer/readline v1.5.1
+require (
+	github.com/chzyer/readline v1.5.1
+	golang.org/x/text v0.32.0
+	google.golang.org/protobuf v1.36.10
+)
 
 require (
 	github.com/chzyer/logex v1.2.1 // indirect
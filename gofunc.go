@@ -0,0 +1,98 @@
+package lua
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+/* Go functions {{{ */
+
+// goSignature describes how a Go function is called from Lua.
+type goSignature struct {
+	// state is true if the first parameter is *LState and receives the calling state.
+	state bool
+	// in are the parameters taken from the stack; the last one is a slice if variadic is true.
+	in       []reflect.Type
+	variadic bool
+	// err is true if the last result is an error.
+	err bool
+}
+
+type goSignatureKey struct {
+	typ   reflect.Type
+	state bool
+}
+
+// goSignatures caches the signatures of Go function types, so that the types are inspected only once.
+var goSignatures sync.Map // map[goSignatureKey]*goSignature
+
+var lstateType = reflect.TypeOf((*LState)(nil))
+
+// goSignatureOf returns the signature of the function type ft. If state is true, a leading *LState parameter
+// receives the calling state.
+func goSignatureOf(ft reflect.Type, state bool) *goSignature {
+	key := goSignatureKey{ft, state}
+	if sig, ok := goSignatures.Load(key); ok {
+		return sig.(*goSignature)
+	}
+	sig := &goSignature{variadic: ft.IsVariadic()}
+	first := 0
+	if state && ft.NumIn() > 0 && ft.In(0) == lstateType {
+		sig.state = true
+		first = 1
+	}
+	for i := first; i < ft.NumIn(); i++ {
+		sig.in = append(sig.in, ft.In(i))
+	}
+	if n := ft.NumOut(); n > 0 && ft.Out(n-1) == errorType {
+		sig.err = true
+	}
+	cached, _ := goSignatures.LoadOrStore(key, sig)
+	return cached.(*goSignature)
+}
+
+// WrapGoFunction returns an LGFunction calling the Go function fn, which can have any signature. The arguments are
+// converted to the types of the parameters of fn, the same way as the arguments of methods of objects(see
+// `LState.NewObject`): booleans, numbers and strings to Go types of their kinds, strings also to []byte, tables to
+// slices and maps, objects and userdata to the types of their values, and any value to interface{} and LValue
+// parameters. A wrong number of arguments or an argument that can not be converted, e.g. a number that is not an
+// integer or out of range for an integer parameter, raises an error. If the first parameter of fn is *LState, it
+// receives the calling state and is not taken from the stack.
+//
+// The results of fn are returned, converted like values read from objects, except for a last result of type
+// error: a non-nil error is raised as a Lua error.
+//
+// WrapGoFunction panics if fn is not a function. The signature of fn is inspected once per function type.
+func WrapGoFunction(fn interface{}) LGFunction {
+	if lf, ok := fn.(func(*LState) int); ok {
+		return lf
+	}
+	if lf, ok := fn.(LGFunction); ok {
+		return lf
+	}
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func || rv.IsNil() {
+		panic(fmt.Sprintf("WrapGoFunction: function expected, got %T", fn))
+	}
+	sig := goSignatureOf(rv.Type(), true)
+	return func(L *LState) int {
+		return callGoFunction(L, rv, sig, 1)
+	}
+}
+
+// RegisterGo sets the global name to a Lua function calling the Go function fn(see `WrapGoFunction`):
+//
+//	L.RegisterGo("join", strings.Join)
+//	L.RegisterGo("readFile", func(name string) (string, error) {
+//		data, err := os.ReadFile(name)
+//		return string(data), err
+//	})
+//	L.DoString(`print(join({"a", "b"}, ","))`) -- a,b
+func (ls *LState) RegisterGo(name string, fn interface{}) {
+	cl := ls.NewFunction(WrapGoFunction(fn))
+	cl.name = name
+	ls.SetGlobal(name, cl)
+}
+
+/* }}} */
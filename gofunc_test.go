@@ -0,0 +1,85 @@
+package lua
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegisterGo(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.RegisterGo("join", strings.Join)
+	L.RegisterGo("add", func(a, b int) int { return a + b })
+	L.RegisterGo("sum", func(ns ...float64) (s float64) {
+		for _, n := range ns {
+			s += n
+		}
+		return
+	})
+	L.RegisterGo("keys", func(m map[string]int) int { return len(m) })
+	L.RegisterGo("bytes", func(b []byte) int { return len(b) })
+	L.RegisterGo("top", func(L *LState, s string) int { return L.GetTop() + len(s) })
+	L.RegisterGo("div", func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	})
+	L.RegisterGo("raw", LGFunction(func(L *LState) int { L.Push(LString("raw")); return 1 }))
+	L.RegisterGo("any", func(v interface{}, lv LValue) (interface{}, string) {
+		return v, lv.Type().String()
+	})
+	errorIfScriptFail(t, L, `
+	assert(join({"a", "b", "c"}, ",") == "a,b,c")
+	assert(add(1, 2) == 3)
+	assert(sum() == 0 and sum(1, 2, 3.5) == 6.5)
+	assert(keys({a = 1, b = 2}) == 2)
+	assert(bytes("abc") == 3)
+	assert(top("xy") == 3)
+	assert(div(6, 3) == 2)
+	assert(raw() == "raw")
+	local v, typ = any("s", {})
+	assert(v == "s" and typ == "table")
+	`)
+	errorIfScriptNotFail(t, L, `div(1, 0)`, "division by zero")
+	errorIfScriptNotFail(t, L, `add(1)`, `bad number of arguments\(expected 2, got 1\)`)
+	errorIfScriptNotFail(t, L, `add(1, "x")`, "bad argument #2 to add")
+	errorIfScriptNotFail(t, L, `join({"a", 1}, ",")`, "element 2: string expected, got number")
+	errorIfScriptNotFail(t, L, `keys({a = "x"})`, "value of a: int expected, got string")
+	errorIfScriptNotFail(t, L, `add(1, 1.5)`, "bad argument #2 to add \\(int expected, got 1.5\\)")
+	errorIfScriptNotFail(t, L, `add(1, 2^63)`, "is out of range for int")
+	errorIfScriptNotFail(t, L, `add(1, 0/0)`, "int expected, got NaN")
+	errorIfScriptNotFail(t, L, `bytes({1, 256})`, "element 2: 256 is out of range for uint8")
+	// errors name the function even when it is not called through its global
+	errorIfScriptNotFail(t, L, `local ok, err = pcall(add, 1, "x"); error(err)`, "bad argument #2 to add")
+}
+
+type goFuncPoint struct{ X, Y int }
+
+func TestRegisterGoUserData(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	ud := L.NewUserData()
+	ud.Value = &goFuncPoint{1, 2}
+	L.SetGlobal("p", ud)
+	L.RegisterGo("x", func(p *goFuncPoint) int { return p.X })
+	L.RegisterGo("point", func(x, y int) *goFuncPoint { return &goFuncPoint{x, y} })
+	errorIfScriptFail(t, L, `
+	assert(x(p) == 1)
+	assert(point(3, 4).Y == 4)
+	assert(x(point(5, 6)) == 5)
+	`)
+	errorIfScriptNotFail(t, L, `x({})`, `\*lua.goFuncPoint expected, got table`)
+}
+
+func TestWrapGoFunction(t *testing.T) {
+	defer func() {
+		errorIfNil(t, recover())
+	}()
+	ft := reflect.TypeOf(func(*LState, int) {})
+	errorIfFalse(t, goSignatureOf(ft, true) == goSignatureOf(ft, true), "signatures must be cached")
+	errorIfFalse(t, goSignatureOf(ft, true).state && !goSignatureOf(ft, false).state, "wrong signature")
+	WrapGoFunction(1)
+}
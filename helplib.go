@@ -0,0 +1,164 @@
+package lua
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* help library {{{ */
+
+// OpenHelp opens the help library describing the modules defined with `Module`, so that script authors can
+// discover the functions of the embedding program from the REPL:
+//
+//	help()                   -- prints the names of the modules
+//	help("mylib")            -- prints the documentation of a module and the signatures of its functions
+//	help("mylib.join")       -- prints the signature and the documentation of a function; a function value works too
+//	help.describe(name)      -- returns the description of a module or a function, or nil
+//	help.modules()           -- returns the descriptions of all modules
+//
+// Modules are described by tables {name = , doc = , functions = , constants = , submodules = }, where functions
+// is an array of function descriptions and constants and submodules are arrays of names. Functions are described
+// by tables {name = , signature = , doc = , nparams = , vararg = }, nparams is -1 for functions whose parameters
+// are not known, e.g. LGFunctions. Modules are described once they are preloaded with `ModuleBuilder.Preload` or
+// loaded.
+func OpenHelp(L *LState) int {
+	mod := L.RegisterModule(HelpLibName, helpFuncs).(*LTable)
+	// the metatable is registered, so that __call is found when persisting the state
+	mt := L.NewTypeMetatable(HelpLibName)
+	L.SetField(mt, "__call", L.NewFunction(helpCall))
+	L.SetMetatable(mod, mt)
+	L.Push(mod)
+	return 1
+}
+
+var helpFuncs = map[string]LGFunction{
+	"describe": helpDescribe,
+	"modules":  helpModules,
+}
+
+// helpLookup returns the module or the function named by the value at n.
+func helpLookup(L *LState, n int) (*ModuleInfo, *FuncInfo) {
+	lv := L.CheckAny(n)
+	if fn, ok := lv.(*LFunction); ok {
+		return nil, L.FuncInfo(fn)
+	}
+	name := L.CheckString(n)
+	if mi := L.ModuleInfo(name); mi != nil {
+		return mi, nil
+	}
+	if i := strings.LastIndexByte(name, '.'); i > 0 {
+		if mi := L.ModuleInfo(name[:i]); mi != nil {
+			for _, fi := range mi.Funcs {
+				if fi.Name == name {
+					return nil, fi
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+func helpModuleTable(L *LState, mi *ModuleInfo) *LTable {
+	funcs := L.CreateTable(len(mi.Funcs), 0)
+	for _, fi := range mi.Funcs {
+		funcs.Append(helpFuncTable(L, fi))
+	}
+	consts := L.CreateTable(len(mi.Consts), 0)
+	for _, name := range mi.Consts {
+		consts.Append(LString(name))
+	}
+	subs := L.CreateTable(len(mi.SubModules), 0)
+	for _, name := range mi.SubModules {
+		subs.Append(LString(name))
+	}
+	tb := L.CreateTable(0, 5)
+	tb.RawSetString("name", LString(mi.Name))
+	tb.RawSetString("doc", LString(mi.Doc))
+	tb.RawSetString("functions", funcs)
+	tb.RawSetString("constants", consts)
+	tb.RawSetString("submodules", subs)
+	return tb
+}
+
+func helpFuncTable(L *LState, fi *FuncInfo) *LTable {
+	tb := L.CreateTable(0, 5)
+	tb.RawSetString("name", LString(fi.Name))
+	tb.RawSetString("signature", LString(fi.Signature))
+	tb.RawSetString("doc", LString(fi.Doc))
+	tb.RawSetString("nparams", LNumber(fi.NumParams))
+	tb.RawSetString("vararg", LBool(fi.Variadic))
+	return tb
+}
+
+func helpDescribe(L *LState) int {
+	switch mi, fi := helpLookup(L, 1); {
+	case mi != nil:
+		L.Push(helpModuleTable(L, mi))
+	case fi != nil:
+		L.Push(helpFuncTable(L, fi))
+	default:
+		L.Push(LNil)
+	}
+	return 1
+}
+
+func helpModules(L *LState) int {
+	names := L.ModuleNames()
+	tb := L.CreateTable(len(names), 0)
+	for _, name := range names {
+		tb.Append(helpModuleTable(L, L.ModuleInfo(name)))
+	}
+	L.Push(tb)
+	return 1
+}
+
+// helpCall implements help(...), the first argument is the help table.
+func helpCall(L *LState) int {
+	out := L.stdout()
+	if L.GetTop() < 2 {
+		fmt.Fprintln(out, "modules:")
+		for _, name := range L.ModuleNames() {
+			fmt.Fprintln(out, "  "+name)
+		}
+		fmt.Fprintln(out, `use help("name") for the functions of a module`)
+		return 0
+	}
+	mi, fi := helpLookup(L, 2)
+	switch {
+	case mi != nil:
+		fmt.Fprintln(out, "module "+mi.Name)
+		if mi.Doc != "" {
+			fmt.Fprintln(out, "\n"+mi.Doc)
+		}
+		if len(mi.Funcs) > 0 {
+			fmt.Fprintln(out, "\nfunctions:")
+			for _, fi := range mi.Funcs {
+				fmt.Fprintln(out, "  "+helpQualifiedSignature(fi))
+			}
+		}
+		if len(mi.Consts) > 0 {
+			fmt.Fprintln(out, "\nconstants: "+strings.Join(mi.Consts, ", "))
+		}
+		if len(mi.SubModules) > 0 {
+			fmt.Fprintln(out, "\nsubmodules: "+strings.Join(mi.SubModules, ", "))
+		}
+	case fi != nil:
+		fmt.Fprintln(out, helpQualifiedSignature(fi))
+		if fi.Doc != "" {
+			fmt.Fprintln(out, "\n"+fi.Doc)
+		}
+	default:
+		fmt.Fprintf(out, "no help for %s\n", L.ToStringMeta(L.Get(2)).String())
+	}
+	return 0
+}
+
+// helpQualifiedSignature returns the signature of fi prefixed with the name of its module.
+func helpQualifiedSignature(fi *FuncInfo) string {
+	if i := strings.LastIndexByte(fi.Name, '.'); i >= 0 {
+		return fi.Name[:i+1] + fi.Signature
+	}
+	return fi.Signature
+}
+
+/* }}} */
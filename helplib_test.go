@@ -0,0 +1,51 @@
+package lua
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHelpLib(t *testing.T) {
+	var stdout bytes.Buffer
+	L := NewState(Options{Stdout: &stdout})
+	defer L.Close()
+	L.PreloadExtraLibs()
+	errorIfScriptFail(t, L, `assert(require("help") == help)`)
+	testModule().Preload(L)
+	errorIfScriptFail(t, L, `
+	local mylib = help.describe("mylib")
+	assert(mylib.name == "mylib" and mylib.doc == "Utilities.")
+	assert(#mylib.functions == 3 and mylib.constants[1] == "version" and mylib.submodules[1] == "mylib.text")
+	local join = help.describe("mylib.join")
+	assert(join.signature == "join(list, sep) string" and join.nparams == 2 and not join.vararg)
+	assert(help.describe("mylib.top").nparams == -1)
+	assert(help.describe(require("mylib").sum).name == "mylib.sum")
+	assert(help.describe("mylib.nothing") == nil and help.describe(print) == nil)
+	local modules = help.modules()
+	assert(#modules == 2 and modules[2].name == "mylib.text")
+	`)
+
+	errorIfScriptFail(t, L, `help("mylib.join")`)
+	errorIfNotEqual(t, "mylib.join(list, sep) string\n\nJoins the strings of list with sep.\n", stdout.String())
+	stdout.Reset()
+	errorIfScriptFail(t, L, `help("mylib")`)
+	errorIfNotEqual(t, `module mylib
+
+Utilities.
+
+functions:
+  mylib.join(list, sep) string
+  mylib.sum(number...) number
+  mylib.top(...)
+
+constants: version, limits
+
+submodules: mylib.text
+`, stdout.String())
+	stdout.Reset()
+	errorIfScriptFail(t, L, `help()`)
+	errorIfNotEqual(t, "modules:\n  mylib\n  mylib.text\nuse help(\"name\") for the functions of a module\n", stdout.String())
+	stdout.Reset()
+	errorIfScriptFail(t, L, `help("other")`)
+	errorIfNotEqual(t, "no help for other\n", stdout.String())
+}
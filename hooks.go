@@ -0,0 +1,234 @@
+package lua
+
+import (
+	"strings"
+	"time"
+)
+
+/* call hooks {{{ */
+
+// CallHooks are functions called at the boundaries of function calls when set in `Options.Hooks`, e.g. to
+// instrument the execution of scripts. Functions that are nil are not called, and the VM does not track calls
+// at all if no hook is set.
+type CallHooks struct {
+	// OnCall is called when a function is called, before it runs.
+	OnCall func(L *LState, ev *CallEvent)
+	// OnReturn is called when a function returns.
+	OnReturn func(L *LState, ev *CallEvent)
+	// OnError is called for each function an error unwinds up to the pcall or coroutine catching it, innermost
+	// function first.
+	OnError func(L *LState, ev *CallEvent)
+	// OnYield is called when a coroutine yields, with the function that called coroutine.yield.
+	OnYield func(L *LState, ev *CallEvent)
+}
+
+func (hooks *CallHooks) enabled() bool {
+	return hooks.OnCall != nil || hooks.OnReturn != nil || hooks.OnError != nil || hooks.OnYield != nil
+}
+
+// CallEvent describes a function call passed to `CallHooks`. The event is reused by later calls of the hooks, so
+// it is only valid until the hook returns.
+type CallEvent struct {
+	// Frame describes the function. Line is the line the function is defined at when it is called, and the line
+	// being executed otherwise.
+	Frame
+	// Fn is the function.
+	Fn *LFunction
+	// Depth is the number of functions on the call stack of the thread below the function.
+	Depth int
+	// Start is the time the function has been called at.
+	Start time.Time
+	// Elapsed is the wall time elapsed since the function has been called. It is zero for OnCall.
+	Elapsed time.Duration
+	// Err is the error unwinding the function for OnError.
+	Err error
+}
+
+// hookState holds the start times of the functions on the call stack of a thread with call hooks, indexed by the
+// index of their frames.
+type hookState struct {
+	event  CallEvent
+	starts []time.Time
+}
+
+func newHookState(options *Options) *hookState {
+	if !options.Hooks.enabled() && !options.Metrics {
+		return nil
+	}
+	return &hookState{}
+}
+
+func (ls *LState) hookCall(cf *callFrame) {
+	hs := ls.hooks
+	now := time.Now()
+	if cf.Idx < len(hs.starts) {
+		hs.starts[cf.Idx] = now
+		hs.starts = hs.starts[:cf.Idx+1]
+	} else {
+		for len(hs.starts) < cf.Idx {
+			hs.starts = append(hs.starts, now)
+		}
+		hs.starts = append(hs.starts, now)
+	}
+	if hook := ls.Options.Hooks.OnCall; hook != nil {
+		ev := ls.hookEvent(cf, now, nil)
+		if proto := cf.Fn.Proto; proto != nil {
+			ev.Line = proto.LineDefined
+		}
+		ev.Elapsed = 0
+		hook(ls, ev)
+		hs.event = CallEvent{}
+	}
+	if dh := ls.debugHook; dh != nil && dh.call {
+		ls.callDebugHook("call", LNil)
+	}
+}
+
+func (ls *LState) hookReturn(cf *callFrame) {
+	if ls.Options.Metrics {
+		ls.countCall(cf)
+	}
+	ls.callHook(ls.Options.Hooks.OnReturn, cf, nil)
+	if dh := ls.debugHook; dh != nil && dh.ret {
+		ls.callDebugHook("return", LNil)
+	}
+}
+
+func (ls *LState) hookYield(cf *callFrame) {
+	if cf.Parent != nil {
+		cf = cf.Parent
+	}
+	ls.callHook(ls.Options.Hooks.OnYield, cf, nil)
+}
+
+// hookUnwind calls OnError for the frames above sp, which are unwound by err.
+func (ls *LState) hookUnwind(sp int, err error) {
+	hook := ls.Options.Hooks.OnError
+	if hook == nil && !ls.Options.Metrics {
+		return
+	}
+	for cf := ls.currentFrame; cf != nil && cf.Idx >= sp; cf = cf.Parent {
+		if ls.Options.Metrics {
+			ls.countCall(cf)
+		}
+		ls.callHook(hook, cf, err)
+	}
+}
+
+func (ls *LState) callHook(hook func(*LState, *CallEvent), cf *callFrame, err error) {
+	if hook == nil {
+		return
+	}
+	hook(ls, ls.hookEvent(cf, time.Now(), err))
+	ls.hooks.event = CallEvent{}
+}
+
+func (ls *LState) hookEvent(cf *callFrame, now time.Time, err error) *CallEvent {
+	hs := ls.hooks
+	ev := &hs.event
+	ev.Frame = ls.frameOf(cf)
+	ev.Fn = cf.Fn
+	ev.Depth = cf.Idx
+	ev.Start = now
+	if cf.Idx < len(hs.starts) {
+		ev.Start = hs.starts[cf.Idx]
+	}
+	ev.Elapsed = now.Sub(ev.Start)
+	ev.Err = err
+	return ev
+}
+
+/* }}} */
+
+/* debug hooks {{{ */
+
+// debugHook is the hook of a thread set by debug.sethook. Call and return events are raised by the call hooks
+// above, line and count events by mainLoopWithContext.
+type debugHook struct {
+	fn    LValue
+	mask  string
+	count int
+	call  bool
+	ret   bool
+	line  bool
+	// left is the number of instructions until the next count event.
+	left int
+	// frame, pc and lineNo are the position of the last line event.
+	frame   *callFrame
+	pc      int
+	lineNo  int
+	running bool
+}
+
+// instructions reports whether the hook needs to see every instruction.
+func (dh *debugHook) instructions() bool {
+	return dh.line || dh.count > 0
+}
+
+// setDebugHook sets the hook of the thread, or removes it if fn is nil or there are no events. Line and count
+// events start with the next call or return if the thread is running.
+func (ls *LState) setDebugHook(fn LValue, mask string, count int) {
+	if fn == LNil || (mask == "" && count <= 0) {
+		ls.debugHook = nil
+		return
+	}
+	dh := &debugHook{
+		fn:    fn,
+		mask:  mask,
+		count: intMax(count, 0),
+		call:  strings.Contains(mask, "c"),
+		ret:   strings.Contains(mask, "r"),
+		line:  strings.Contains(mask, "l"),
+		left:  count,
+	}
+	ls.debugHook = dh
+	if (dh.call || dh.ret) && ls.hooks == nil {
+		ls.hooks = &hookState{}
+	}
+	if dh.instructions() {
+		ls.mainLoop = mainLoopWithContext
+	}
+}
+
+// debugHookInstruction raises the line and count events of the instruction at cf.Pc.
+func (ls *LState) debugHookInstruction(cf *callFrame) {
+	dh := ls.debugHook
+	if dh.running {
+		return
+	}
+	if dh.count > 0 {
+		if dh.left--; dh.left <= 0 {
+			dh.left = dh.count
+			ls.callDebugHook("count", LNil)
+		}
+	}
+	if !dh.line || ls.debugHook != dh {
+		return
+	}
+	positions := cf.Fn.Proto.DbgSourcePositions
+	if cf.Pc >= len(positions) {
+		return
+	}
+	// a new line is reached, or a loop jumped back to the start of the line
+	line := positions[cf.Pc]
+	if cf != dh.frame || line != dh.lineNo || cf.Pc <= dh.pc {
+		dh.frame, dh.lineNo = cf, line
+		ls.callDebugHook("line", LNumber(line))
+	}
+	dh.pc = cf.Pc
+}
+
+func (ls *LState) callDebugHook(event string, line LValue) {
+	dh := ls.debugHook
+	if dh.running {
+		return
+	}
+	dh.running = true
+	defer func() { dh.running = false }()
+	ls.Push(dh.fn)
+	ls.Push(LString(event))
+	ls.Push(line)
+	ls.Call(2, 0)
+}
+
+/* }}} */
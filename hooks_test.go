@@ -0,0 +1,105 @@
+package lua
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newHookRecorder(events *[]string) CallHooks {
+	record := func(kind string) func(L *LState, ev *CallEvent) {
+		return func(L *LState, ev *CallEvent) {
+			if ev.IsGo && ev.FunctionName != "yield" && ev.FunctionName != "error" {
+				return
+			}
+			s := fmt.Sprintf("%s %s:%d %d", kind, ev.FunctionName, ev.Line, ev.Depth)
+			if ev.Err != nil {
+				s += " " + strings.SplitN(ev.Err.Error(), "\n", 2)[0]
+			}
+			*events = append(*events, s)
+		}
+	}
+	return CallHooks{OnCall: record("call"), OnReturn: record("return"), OnError: record("error"), OnYield: record("yield")}
+}
+
+func TestCallHooks(t *testing.T) {
+	var events []string
+	L := NewState(Options{Hooks: newHookRecorder(&events)})
+	defer L.Close()
+	errorIfNotNil(t, L.DoString(`
+local function inner(x)
+  return x + 1
+end
+function outer(x)
+  local y = inner(x)
+  return y
+end
+outer(1)
+`))
+	errorIfNotEqual(t, strings.Join([]string{
+		"call main chunk:0 0",
+		"call outer:5 1",
+		"call inner:2 2",
+		"return inner:3 2",
+		"return outer:7 1",
+		"return main chunk:10 0",
+	}, "\n"), strings.Join(events, "\n"))
+
+	events = nil
+	errorIfNotNil(t, L.DoString(`
+local function fail()
+  error("boom")
+end
+pcall(function()
+  fail()
+end)
+`))
+	errorIfNotEqual(t, strings.Join([]string{
+		"call main chunk:0 0",
+		"call <<string>:5>:5 2",
+		"call fail:2 3",
+		"call error:0 4",
+		"error error:0 4 <string>:3: boom",
+		"error fail:3 3 <string>:3: boom",
+		"error <<string>:5>:6 2 <string>:3: boom",
+		"return main chunk:6 0",
+	}, "\n"), strings.Join(events, "\n"))
+
+	events = nil
+	errorIfNotNil(t, L.DoString(`
+local co = coroutine.wrap(function()
+  coroutine.yield(1)
+end)
+co()
+co()
+`))
+	errorIfNotEqual(t, strings.Join([]string{
+		"call main chunk:0 0",
+		"call corountine:2 0",
+		"call yield:0 1",
+		"yield corountine:3 0",
+		"return corountine:4 0",
+		"return main chunk:7 0",
+	}, "\n"), strings.Join(events, "\n"))
+}
+
+func TestCallHooksElapsed(t *testing.T) {
+	var elapsed time.Duration
+	L := NewState(Options{Hooks: CallHooks{OnReturn: func(L *LState, ev *CallEvent) {
+		if ev.FunctionName == "slow" {
+			elapsed = ev.Elapsed
+			errorIfFalse(t, !ev.Start.IsZero(), "start time is not set")
+		}
+	}}})
+	defer L.Close()
+	L.SetGlobal("sleep", L.NewFunction(func(L *LState) int {
+		time.Sleep(10 * time.Millisecond)
+		return 0
+	}))
+	errorIfScriptFail(t, L, `
+	local function slow() sleep() end
+	slow()
+	`)
+	errorIfFalse(t, elapsed >= 10*time.Millisecond, "elapsed time %v is too short", elapsed)
+}
@@ -0,0 +1,228 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	randv2 "math/rand/v2"
+	"os"
+	"time"
+)
+
+/* host interfaces {{{ */
+
+// HostFS opens files on behalf of the io library, and the script files of dofile, loadfile, require and
+// `LState.LoadFile`. *os.Root implements HostFS, which confines scripts to a directory. If a HostFS also has the
+// Remove and Rename methods of *os.Root, they remove and rename files for os.remove and os.rename, unless
+// HostInterfaces.Remove and HostInterfaces.Rename are set. Otherwise these functions fail, so that a state with a
+// HostFS never touches the file system of the process. The temporary files of os.tmpname and io.tmpfile are
+// created by OpenFile too.
+type HostFS interface {
+	OpenFile(name string, flag int, perm os.FileMode) (*os.File, error)
+}
+
+type hostStater interface {
+	Stat(name string) (os.FileInfo, error)
+}
+
+type hostRemover interface {
+	Remove(name string) error
+}
+
+type hostRenamer interface {
+	Rename(oldpath, newpath string) error
+}
+
+// HostInterfaces replaces the services of the host the standard libraries use(see `Options.Host`). Nil fields
+// keep the services of the process.
+type HostInterfaces struct {
+	// Clock returns the current time for os.time and os.date.
+	Clock func() time.Time
+	// Rand is the source of math.random. math.randomseed does not reseed Rand.
+	Rand randv2.Source
+	// Getenv looks up an environment variable for os.getenv, like os.LookupEnv.
+	Getenv func(key string) (string, bool)
+	// Setenv sets an environment variable for os.setenv.
+	Setenv func(key, value string) error
+	// FS opens the files of io.open, io.lines, io.input and io.output, and the scripts of dofile, loadfile and
+	// require.
+	FS HostFS
+	// Remove removes a file for os.remove.
+	Remove func(name string) error
+	// Rename renames a file for os.rename.
+	Rename func(oldpath, newpath string) error
+}
+
+func (ls *LState) hostNow() time.Time {
+	if clock := ls.Options.Host.Clock; clock != nil {
+		return clock()
+	}
+	return time.Now()
+}
+
+// randUint64 returns the next random integer of Host.Rand or the generator of the state.
+func (ls *LState) randUint64() uint64 {
+	if src := ls.Options.Host.Rand; src != nil {
+		return src.Uint64()
+	}
+	return ls.G.random.Uint64()
+}
+
+// seedRandom seeds the generator of the state. Host.Rand is not reseeded.
+func (ls *LState) seedRandom(n1, n2 uint64) {
+	if ls.Options.Host.Rand != nil {
+		return
+	}
+	ls.G.random.Seed(n1, n2)
+}
+
+func (ls *LState) getenv(key string) (string, bool) {
+	if environ := ls.G.environ; environ != nil {
+		v, ok := environ[key]
+		return v, ok
+	}
+	if getenv := ls.Options.Host.Getenv; getenv != nil {
+		return getenv(key)
+	}
+	return os.LookupEnv(key)
+}
+
+func (ls *LState) setenv(key, value string) error {
+	if environ := ls.G.environ; environ != nil {
+		environ[key] = value
+		return nil
+	}
+	if setenv := ls.Options.Host.Setenv; setenv != nil {
+		return setenv(key, value)
+	}
+	return os.Setenv(key, value)
+}
+
+func (ls *LState) openFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	if fsys := ls.Options.Host.FS; fsys != nil {
+		return fsys.OpenFile(name, flag, perm)
+	}
+	return sysOpenFile(name, flag, perm)
+}
+
+// statFile returns the FileInfo of a file in Host.FS, using its Stat method if it has one like *os.Root, or of a
+// file of the process.
+func (ls *LState) statFile(name string) (os.FileInfo, error) {
+	fsys := ls.Options.Host.FS
+	if fsys == nil {
+		return sysStat(name)
+	}
+	if stater, ok := fsys.(hostStater); ok {
+		return stater.Stat(name)
+	}
+	file, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return file.Stat()
+}
+
+func (ls *LState) removeFile(name string) error {
+	if remove := ls.Options.Host.Remove; remove != nil {
+		return remove(name)
+	}
+	if fsys := ls.Options.Host.FS; fsys != nil {
+		if remover, ok := fsys.(hostRemover); ok {
+			return remover.Remove(name)
+		}
+		return &os.PathError{Op: "remove", Path: name, Err: errors.ErrUnsupported}
+	}
+	return sysRemove(name)
+}
+
+func (ls *LState) renameFile(oldpath, newpath string) error {
+	if rename := ls.Options.Host.Rename; rename != nil {
+		return rename(oldpath, newpath)
+	}
+	if fsys := ls.Options.Host.FS; fsys != nil {
+		if renamer, ok := fsys.(hostRenamer); ok {
+			return renamer.Rename(oldpath, newpath)
+		}
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errors.ErrUnsupported}
+	}
+	return sysRename(oldpath, newpath)
+}
+
+/* }}} */
+
+/* temporary files {{{ */
+
+// tempFile is a temporary file created by os.tmpname or io.tmpfile. file is nil for os.tmpname, which only
+// returns the name.
+type tempFile struct {
+	name string
+	file *os.File
+}
+
+// createTempFile creates a new temporary file in the temporary directory of the process, or in the root of
+// Host.FS, and keeps track of it until the state is closed.
+func (ls *LState) createTempFile(keepOpen bool) (*os.File, string, error) {
+	var file *os.File
+	var name string
+	var err error
+	if fsys := ls.Options.Host.FS; fsys != nil {
+		for i := 0; i < 10000; i++ {
+			name = fmt.Sprintf("lua_%d", randv2.Uint32())
+			file, err = fsys.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+			if !errors.Is(err, os.ErrExist) {
+				break
+			}
+		}
+	} else if file, err = sysCreateTemp(); err == nil {
+		name = file.Name()
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	tf := tempFile{name: name, file: file}
+	if !keepOpen {
+		file.Close()
+		tf.file = nil
+	}
+	ls.G.tempFiles = append(ls.G.tempFiles, tf)
+	return tf.file, name, nil
+}
+
+// forgetTempFile stops keeping track of the temporary file name after a script removed it.
+func (ls *LState) forgetTempFile(name string) {
+	for i, tf := range ls.G.tempFiles {
+		if tf.name == name && tf.file == nil {
+			ls.G.tempFiles = append(ls.G.tempFiles[:i], ls.G.tempFiles[i+1:]...)
+			return
+		}
+	}
+}
+
+// TempFiles returns the names of the temporary files created by os.tmpname and io.tmpfile that have not been
+// removed yet. They are removed when the state is closed.
+func (ls *LState) TempFiles() []string {
+	names := make([]string, len(ls.G.tempFiles))
+	for i, tf := range ls.G.tempFiles {
+		names[i] = tf.name
+	}
+	return names
+}
+
+// RemoveTempFiles closes and removes the temporary files created by os.tmpname and io.tmpfile, through
+// `HostInterfaces` like os.remove. Files of io.tmpfile that are still used by scripts are closed too. It returns
+// the first error other than a file that does not exist anymore.
+func (ls *LState) RemoveTempFiles() error {
+	var first error
+	for _, tf := range ls.G.tempFiles {
+		if tf.file != nil {
+			tf.file.Close()
+		}
+		if err := ls.removeFile(tf.name); err != nil && !errors.Is(err, os.ErrNotExist) && first == nil {
+			first = err
+		}
+	}
+	ls.G.tempFiles = nil
+	return first
+}
+
+/* }}} */
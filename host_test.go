@@ -0,0 +1,164 @@
+package lua
+
+import (
+	randv2 "math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHostInterfaces(t *testing.T) {
+	dir := t.TempDir()
+	root, err := os.OpenRoot(dir)
+	errorIfNotNil(t, err)
+	defer root.Close()
+	env := map[string]string{"HOME": "/home/lua"}
+	var removed, renamed []string
+	newState := func() *LState {
+		return NewState(Options{Host: HostInterfaces{
+			Clock: func() time.Time { return time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC) },
+			Rand:  randv2.NewPCG(1, 2),
+			Getenv: func(key string) (string, bool) {
+				v, ok := env[key]
+				return v, ok
+			},
+			Setenv: func(key, value string) error {
+				env[key] = value
+				return nil
+			},
+			FS:     root,
+			Remove: func(name string) error { removed = append(removed, name); return nil },
+			Rename: func(oldpath, newpath string) error { renamed = append(renamed, oldpath, newpath); return nil },
+		}})
+	}
+	L := newState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+assert(os.time() == 981173106)
+assert(os.date("!%Y-%m-%d %H:%M:%S") == "2001-02-03 04:05:06")
+assert(os.getenv("HOME") == "/home/lua" and os.getenv("PATH") == nil)
+assert(os.setenv("PATH", "/bin") and os.getenv("PATH") == "/bin")
+local f = assert(io.open("data.txt", "w"))
+f:write("data")
+f:close()
+assert(io.open("../data.txt") == nil)
+for l in io.lines("data.txt") do assert(l == "data") end
+assert(os.remove("data.txt") and os.rename("a", "b"))
+numbers = {}
+for i = 1, 5 do numbers[i] = math.random(1000) end
+`)
+	errorIfNotEqual(t, "/bin", env["PATH"])
+	data, err := os.ReadFile(filepath.Join(dir, "data.txt"))
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, "data", string(data))
+	errorIfNotEqual(t, 1, len(removed))
+	errorIfNotEqual(t, 2, len(renamed))
+
+	L2 := newState()
+	defer L2.Close()
+	L2.SetGlobal("numbers", L.GetGlobal("numbers"))
+	errorIfScriptFail(t, L2, `for i = 1, 5 do assert(math.random(1000) == numbers[i]) end`)
+}
+
+// openOnlyFS is a HostFS without the Remove and Rename methods.
+type openOnlyFS struct{ root *os.Root }
+
+func (fsys openOnlyFS) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return fsys.root.OpenFile(name, flag, perm)
+}
+
+func TestHostTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	root, err := os.OpenRoot(dir)
+	errorIfNotNil(t, err)
+	defer root.Close()
+	L := NewState(Options{Host: HostInterfaces{FS: root}})
+	errorIfScriptFail(t, L, `
+	name = os.tmpname()
+	local f = assert(io.open(name, "w"))
+	f:write("tmp")
+	f:close()
+	removed = os.tmpname()
+	assert(os.remove(removed))
+	tmp = assert(io.tmpfile())
+	tmp:write("data")
+	local f = assert(io.open("data.txt", "w"))
+	f:close()
+	assert(os.rename("data.txt", "moved.txt"))
+	assert(os.remove("moved.txt"))
+	`)
+	names := L.TempFiles()
+	errorIfNotEqual(t, 2, len(names))
+	errorIfFalse(t, !filepath.IsAbs(names[0]) && !filepath.IsAbs(names[1]), "relative names expected, got %v", names)
+	errorIfNotEqual(t, LString(names[0]), L.GetGlobal("name"))
+	_, err = os.Stat(filepath.Join(dir, names[0]))
+	errorIfNotNil(t, err)
+	L.Close()
+	entries, err := os.ReadDir(dir)
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, 0, len(entries))
+
+	L = NewState(Options{Host: HostInterfaces{FS: openOnlyFS{root}}})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local name = os.tmpname()
+	local ok, err = os.remove(name)
+	assert(not ok and err:find("unsupported"), err)
+	ok, err = os.rename(name, "other")
+	assert(not ok and err:find("unsupported"), err)
+	`)
+	errorIfNotEqual(t, 1, len(L.TempFiles()))
+	// the file cannot be removed without the file system of the process
+	errorIfNil(t, L.RemoveTempFiles())
+	entries, err = os.ReadDir(dir)
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, 1, len(entries))
+}
+
+func TestEnviron(t *testing.T) {
+	environ := map[string]string{"LANG": "C"}
+	L := NewState(Options{Environ: environ})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	assert(os.getenv("LANG") == "C")
+	assert(os.getenv("PATH") == nil)
+	assert(os.setenv("APP_MODE", "test"))
+	assert(os.getenv("APP_MODE") == "test")
+	`)
+	_, ok := environ["APP_MODE"]
+	errorIfFalse(t, !ok, "the map of Options.Environ must not be changed")
+	_, ok = os.LookupEnv("APP_MODE")
+	errorIfFalse(t, !ok, "the environment of the process must not be changed")
+
+	L2 := NewState(Options{Environ: map[string]string{}})
+	defer L2.Close()
+	errorIfScriptFail(t, L2, `assert(os.getenv("HOME") == nil and os.getenv("LANG") == nil)`)
+}
+
+func TestHostScripts(t *testing.T) {
+	dir, outside := t.TempDir(), t.TempDir()
+	errorIfNotNil(t, os.WriteFile(filepath.Join(dir, "inside.lua"), []byte("return 'inside'"), 0o644))
+	errorIfNotNil(t, os.WriteFile(filepath.Join(dir, "mod.lua"), []byte("return 'mod'"), 0o644))
+	errorIfNotNil(t, os.WriteFile(filepath.Join(outside, "outside.lua"), []byte("return 'outside'"), 0o644))
+	errorIfNotNil(t, os.WriteFile(filepath.Join(outside, "omod.lua"), []byte("return 'omod'"), 0o644))
+	root, err := os.OpenRoot(dir)
+	errorIfNotNil(t, err)
+	defer root.Close()
+	for _, fsys := range []HostFS{root, openOnlyFS{root}} {
+		L := NewState(Options{Host: HostInterfaces{FS: fsys}})
+		L.SetGlobal("outside", LString(outside))
+		errorIfScriptFail(t, L, `
+		assert(dofile("inside.lua") == "inside")
+		assert(loadfile("inside.lua")() == "inside")
+		package.path = "?.lua;" .. outside .. "/?.lua"
+		assert(require("mod") == "mod")
+		assert(not pcall(require, "omod"))
+		assert(loadfile(outside .. "/outside.lua") == nil)
+		`)
+		errorIfScriptNotFail(t, L, `dofile(outside .. "/outside.lua")`, "outside.lua")
+		_, err := L.LoadFile(filepath.Join(outside, "outside.lua"))
+		errorIfNil(t, err)
+		L.Close()
+	}
+}
@@ -2,12 +2,10 @@ package lua
 
 import (
 	"bufio"
-	"errors"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
-	"syscall"
 )
 
 var ioFuncs = map[string]LGFunction{
@@ -28,8 +26,10 @@ const lFileClass = "FILE*"
 
 type lFile struct {
 	fp     *os.File
-	pp     *exec.Cmd
+	pp     *process
 	writer io.Writer
+	// sink is the unbuffered destination of writer.
+	sink   io.Writer
 	reader *bufio.Reader
 	stdout io.ReadCloser
 	closed bool
@@ -40,8 +40,20 @@ type lFileType int
 const (
 	lFileFile lFileType = iota
 	lFileProcess
+	// lFileStream is a standard stream redirected to a Go reader or writer(see `Options.Stdout`).
+	lFileStream
 )
 
+func (ft lFileType) String() string {
+	switch ft {
+	case lFileProcess:
+		return "process"
+	case lFileStream:
+		return "stream"
+	}
+	return "file"
+}
+
 const fileDefOutIndex = 1
 const fileDefInIndex = 2
 const fileDefaultWriteBuffer = 4096
@@ -66,7 +78,7 @@ func newFile(L *LState, file *os.File, path string, flag int, perm os.FileMode,
 	ud := L.NewUserData()
 	var err error
 	if file == nil {
-		file, err = os.OpenFile(path, flag, perm)
+		file, err = L.openFile(path, flag, perm)
 		if err != nil {
 			return nil, err
 		}
@@ -75,6 +87,7 @@ func newFile(L *LState, file *os.File, path string, flag int, perm os.FileMode,
 	ud.Value = lfile
 	if writable {
 		lfile.writer = file
+		lfile.sink = file
 	}
 	if readable {
 		lfile.reader = bufio.NewReaderSize(file, fileDefaultReadBuffer)
@@ -83,27 +96,33 @@ func newFile(L *LState, file *os.File, path string, flag int, perm os.FileMode,
 	return ud, nil
 }
 
+// newStream returns a file reading from reader or writing to writer. Closing it does not close reader or writer.
+func newStream(L *LState, writer io.Writer, reader io.Reader) *LUserData {
+	ud := L.NewUserData()
+	lfile := &lFile{fp: nil, pp: nil, writer: writer, sink: writer, reader: nil, stdout: nil, closed: false}
+	if reader != nil {
+		lfile.reader = bufio.NewReaderSize(reader, fileDefaultReadBuffer)
+	}
+	ud.Value = lfile
+	L.SetMetatable(ud, L.GetTypeMetatable(lFileClass))
+	return ud
+}
+
 func newProcess(L *LState, cmd string, writable, readable bool) (*LUserData, error) {
 	ud := L.NewUserData()
-	c, args := popenArgs(cmd)
-	pp := exec.Command(c, args...)
+	pp, stdin, stdout, err := startProcess(cmd, writable, readable)
+	if err != nil {
+		return nil, err
+	}
 	lfile := &lFile{fp: nil, pp: pp, writer: nil, reader: nil, stdout: nil, closed: false}
 	ud.Value = lfile
-
-	var err error
 	if writable {
-		lfile.writer, err = pp.StdinPipe()
+		lfile.sink = stdin
+		lfile.writer = stdin
 	}
 	if readable {
-		lfile.stdout, err = pp.StdoutPipe()
-		lfile.reader = bufio.NewReaderSize(lfile.stdout, fileDefaultReadBuffer)
-	}
-	if err != nil {
-		return nil, err
-	}
-	err = pp.Start()
-	if err != nil {
-		return nil, err
+		lfile.stdout = stdout
+		lfile.reader = bufio.NewReaderSize(stdout, fileDefaultReadBuffer)
 	}
 
 	L.SetMetatable(ud, L.GetTypeMetatable(lFileClass))
@@ -111,8 +130,11 @@ func newProcess(L *LState, cmd string, writable, readable bool) (*LUserData, err
 }
 
 func (file *lFile) Type() lFileType {
-	if file.fp == nil {
+	switch {
+	case file.pp != nil:
 		return lFileProcess
+	case file.fp == nil:
+		return lFileStream
 	}
 	return lFileFile
 }
@@ -122,9 +144,9 @@ func (file *lFile) Name() string {
 	case lFileFile:
 		return fmt.Sprintf("file %s", file.fp.Name())
 	case lFileProcess:
-		return fmt.Sprintf("process %s", file.pp.Path)
+		return fmt.Sprintf("process %s", file.pp.path())
 	}
-	return ""
+	return file.Type().String()
 }
 
 func (file *lFile) AbandonReadBuffer() error {
@@ -138,6 +160,35 @@ func (file *lFile) AbandonReadBuffer() error {
 	return nil
 }
 
+// FlushWriteBuffer writes the data buffered by setvbuf("full") or setvbuf("line") to the file.
+func (file *lFile) FlushWriteBuffer() error {
+	if bwriter, ok := file.writer.(interface{ Flush() error }); ok {
+		return bwriter.Flush()
+	}
+	return nil
+}
+
+// lineWriter is a buffered writer that flushes its buffer whenever a newline is written.
+type lineWriter struct {
+	*bufio.Writer
+}
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if err == nil && bytes.IndexByte(p, '\n') >= 0 {
+		err = w.Flush()
+	}
+	return n, err
+}
+
+// flushBufferedFiles flushes the write buffers of all files that are still open, ignoring errors.
+func (g *Global) flushBufferedFiles() {
+	for file := range g.bufferedFiles {
+		file.FlushWriteBuffer()
+	}
+	g.bufferedFiles = nil
+}
+
 func fileDefOut(L *LState) *LUserData {
 	return L.Get(UpvalueIndex(1)).(*LTable).RawGetInt(fileDefOutIndex).(*LUserData)
 }
@@ -167,14 +218,27 @@ func fileIsReadable(L *LState, file *lFile) int {
 }
 
 var stdFiles = []struct {
-	name     string
-	file     *os.File
-	writable bool
-	readable bool
+	name   string
+	writer func(*LState) io.Writer
+	reader func(*LState) io.Reader
 }{
-	{"stdout", os.Stdout, true, false},
-	{"stdin", os.Stdin, false, true},
-	{"stderr", os.Stderr, true, false},
+	{"stdout", (*LState).stdout, nil},
+	{"stdin", nil, (*LState).stdin},
+	{"stderr", (*LState).stderr, nil},
+}
+
+// newStdFile returns the file for a standard stream of the state, which is a regular file unless the stream is
+// redirected to a Go reader or writer.
+func newStdFile(L *LState, writer io.Writer, reader io.Reader) *LUserData {
+	if fp, ok := writer.(*os.File); ok && reader == nil {
+		file, _ := newFile(L, fp, "", 0, os.FileMode(0), true, false)
+		return file
+	}
+	if fp, ok := reader.(*os.File); ok && writer == nil {
+		file, _ := newFile(L, fp, "", 0, os.FileMode(0), false, true)
+		return file
+	}
+	return newStream(L, writer, reader)
 }
 
 func OpenIo(L *LState) int {
@@ -182,11 +246,17 @@ func OpenIo(L *LState) int {
 	mt := L.NewTypeMetatable(lFileClass)
 	mt.RawSetString("__index", mt)
 	L.SetFuncs(mt, fileMethods)
-	mt.RawSetString("lines", L.NewClosure(fileLines, L.NewFunction(fileLinesIter)))
 
 	for _, finfo := range stdFiles {
-		file, _ := newFile(L, finfo.file, "", 0, os.FileMode(0), finfo.writable, finfo.readable)
-		mod.RawSetString(finfo.name, file)
+		var writer io.Writer
+		var reader io.Reader
+		if finfo.writer != nil {
+			writer = finfo.writer(L)
+		}
+		if finfo.reader != nil {
+			reader = finfo.reader(L)
+		}
+		mod.RawSetString(finfo.name, newStdFile(L, writer, reader))
 	}
 	uv := L.CreateTable(2, 0)
 	uv.RawSetInt(fileDefOutIndex, mod.RawGetString("stdout"))
@@ -194,7 +264,6 @@ func OpenIo(L *LState) int {
 	for name, fn := range ioFuncs {
 		mod.RawSetString(name, L.NewClosure(fn, uv))
 	}
-	mod.RawSetString("lines", L.NewClosure(ioLines, uv, L.NewClosure(ioLinesIter, uv)))
 	// Modifications are being made in-place rather than returned?
 	L.Push(mod)
 	return 1
@@ -209,11 +278,12 @@ var fileMethods = map[string]LGFunction{
 	"read":       fileRead,
 	"seek":       fileSeek,
 	"setvbuf":    fileSetVBuf,
+	"truncate":   fileTruncate,
 }
 
 func fileToString(L *LState) int {
 	file := checkFile(L)
-	if file.Type() == lFileFile {
+	if file.Type() != lFileProcess {
 		if file.closed {
 			L.Push(LString("file (closed)"))
 		} else {
@@ -259,13 +329,10 @@ errreturn:
 
 func fileCloseAux(L *LState, file *lFile) int {
 	file.closed = true
+	delete(L.G.bufferedFiles, file)
 	var err error
-	if file.writer != nil {
-		if bwriter, ok := file.writer.(*bufio.Writer); ok {
-			if err = bwriter.Flush(); err != nil {
-				goto errreturn
-			}
-		}
+	if err = file.FlushWriteBuffer(); err != nil {
+		goto errreturn
 	}
 	file.AbandonReadBuffer()
 
@@ -280,20 +347,10 @@ func fileCloseAux(L *LState, file *lFile) int {
 		if file.stdout != nil {
 			file.stdout.Close() // ignore errors
 		}
-		err = file.pp.Wait()
-		var exitStatus int // Initialised to zero value = 0
-		if err != nil {
-			if e2, ok := err.(*exec.ExitError); ok {
-				if s, ok := e2.Sys().(syscall.WaitStatus); ok {
-					exitStatus = s.ExitStatus()
-				} else {
-					err = errors.New("Unimplemented for system where exec.ExitError.Sys() is not syscall.WaitStatus.")
-				}
-			}
-		} else {
-			exitStatus = 0
-		}
-		L.Push(LNumber(exitStatus))
+		L.Push(LNumber(file.pp.wait()))
+		return 1
+	case lFileStream:
+		L.Push(LTrue)
 		return 1
 	}
 
@@ -308,12 +365,10 @@ func fileFlushAux(L *LState, file *lFile) int {
 	}
 	errorIfFileIsClosed(L, file)
 
-	if bwriter, ok := file.writer.(*bufio.Writer); ok {
-		if err := bwriter.Flush(); err != nil {
-			L.Push(LNil)
-			L.Push(LString(err.Error()))
-			return 2
-		}
+	if err := file.FlushWriteBuffer(); err != nil {
+		L.Push(LNil)
+		L.Push(LString(err.Error()))
+		return 2
 	}
 	L.Push(LTrue)
 	return 1
@@ -324,89 +379,87 @@ func fileReadAux(L *LState, file *lFile, idx int) int {
 		return n
 	}
 	errorIfFileIsClosed(L, file)
+	n, err := fileReadFormats(L, file, idx)
+	if err != nil {
+		L.Push(LNil)
+		L.Push(LString(err.Error()))
+		L.Push(LNumber(1)) // C-Lua compatibility: Original Lua pushes errno to the stack
+		return 3
+	}
+	return n
+}
+
+// fileReadFormats reads the formats from idx to the top of the stack and pushes the values read. Reading stops
+// with a nil value at the end of the file or if a number can not be read. A format is a number of bytes, or one of
+// "n"(a number), "a"(the rest of the file), "l"(a line without the end of line) and "L"(a line with the end of
+// line), optionally prefixed with "*". The default format is "l".
+func fileReadFormats(L *LState, file *lFile, idx int) (int, error) {
 	if L.GetTop() == idx-1 {
-		L.Push(LString("*l"))
+		L.Push(LString("l"))
 	}
-	var err error
 	top := L.GetTop()
 	for i := idx; i <= top; i++ {
 		switch lv := L.Get(i).(type) {
 		case LNumber:
 			size := int64(lv)
 			if size == 0 {
-				_, err = file.reader.ReadByte()
-				if err == io.EOF {
+				if _, err := file.reader.Peek(1); err == io.EOF {
 					L.Push(LNil)
-					goto normalreturn
+					return L.GetTop() - top, nil
 				}
-				file.reader.UnreadByte()
+				L.Push(emptyLString)
+				continue
 			}
-			var buf []byte
-			var iseof bool
-			buf, err, iseof = readBufioSize(file.reader, size)
+			buf, err, iseof := readBufioSize(file.reader, size)
 			if iseof {
 				L.Push(LNil)
-				goto normalreturn
+				return L.GetTop() - top, nil
 			}
 			if err != nil {
-				goto errreturn
+				return 0, err
 			}
 			L.Push(LString(string(buf)))
-		case LString:
+		default:
 			options := L.CheckString(i)
-			if len(options) > 0 && options[0] != '*' {
-				L.ArgError(2, "invalid options:"+options)
+			if len(options) > 0 && options[0] == '*' {
+				options = options[1:]
+			}
+			if len(options) == 0 {
+				L.ArgError(i, "invalid format")
 			}
-			for _, opt := range options[1:] {
-				switch opt {
-				case 'n':
-					var v LNumber
-					_, err = fmt.Fscanf(file.reader, LNumberScanFormat, &v)
-					if err == io.EOF {
-						L.Push(LNil)
-						goto normalreturn
-					}
-					if err != nil {
-						goto errreturn
-					}
-					L.Push(v)
-				case 'a':
-					var buf []byte
-					buf, err = io.ReadAll(file.reader)
-					if err == io.EOF {
-						L.Push(emptyLString)
-						goto normalreturn
-					}
-					if err != nil {
-						goto errreturn
-					}
-					L.Push(LString(string(buf)))
-				case 'l':
-					var buf []byte
-					var iseof bool
-					buf, err, iseof = readBufioLine(file.reader)
-					if iseof {
-						L.Push(LNil)
-						goto normalreturn
-					}
-					if err != nil {
-						goto errreturn
-					}
-					L.Push(LString(string(buf)))
-				default:
-					L.ArgError(2, "invalid options:"+string(opt))
+			switch options[0] {
+			case 'n':
+				v, ok, err := readBufioNumber(file.reader)
+				if err != nil {
+					return 0, err
 				}
+				if !ok {
+					L.Push(LNil)
+					return L.GetTop() - top, nil
+				}
+				L.Push(v)
+			case 'a':
+				buf, err := io.ReadAll(file.reader)
+				if err != nil {
+					return 0, err
+				}
+				L.Push(LString(string(buf)))
+			case 'l', 'L':
+				line, ok, err := readBufioLineKeep(file.reader, options[0] == 'L')
+				if err != nil {
+					return 0, err
+				}
+				if !ok {
+					L.Push(LNil)
+					return L.GetTop() - top, nil
+				}
+				L.Push(LString(line))
+			default:
+				L.ArgError(i, "invalid format")
 			}
 		}
 	}
-normalreturn:
-	return L.GetTop() - top
-
-errreturn:
-	L.Push(LNil)
-	L.Push(LString(err.Error()))
-	L.Push(LNumber(1)) // C-Lua compatibility: Original Lua pushes errno to the stack
-	return 3
+	return L.GetTop() - top, nil
 }
 
 var fileSeekOptions = []string{"set", "cur", "end"}
@@ -415,7 +468,7 @@ func fileSeek(L *LState) int {
 	file := checkFile(L)
 	if file.Type() != lFileFile {
 		L.Push(LNil)
-		L.Push(LString("can not seek a process."))
+		L.Push(LString(fmt.Sprintf("can not seek a %s.", file.Type())))
 		return 2
 	}
 
@@ -430,6 +483,10 @@ func fileSeek(L *LState) int {
 	var pos int64
 	var err error
 
+	// positions are relative to the data written so far, so buffered writes must reach the file first
+	if err = file.FlushWriteBuffer(); err != nil {
+		goto errreturn
+	}
 	err = file.AbandonReadBuffer()
 	if err != nil {
 		goto errreturn
@@ -449,6 +506,43 @@ errreturn:
 	return 2
 }
 
+// fileTruncate truncates or extends the file to the given size, which defaults to the current position. Extending a
+// file creates a hole on file systems supporting sparse files. The position of the file is not changed.
+func fileTruncate(L *LState) int {
+	file := checkFile(L)
+	errorIfFileIsClosed(L, file)
+	if file.Type() != lFileFile {
+		L.Push(LNil)
+		L.Push(LString(fmt.Sprintf("can not truncate a %s.", file.Type())))
+		return 2
+	}
+	var size int64
+	var err error
+	if err = file.FlushWriteBuffer(); err != nil {
+		goto errreturn
+	}
+	if err = file.AbandonReadBuffer(); err != nil {
+		goto errreturn
+	}
+	if L.Get(2) == LNil {
+		if size, err = file.fp.Seek(0, io.SeekCurrent); err != nil {
+			goto errreturn
+		}
+	} else if size = L.CheckInt64(2); size < 0 {
+		L.ArgError(2, "size must not be negative")
+	}
+	if err = file.fp.Truncate(size); err != nil {
+		goto errreturn
+	}
+	L.Push(LTrue)
+	return 1
+
+errreturn:
+	L.Push(LNil)
+	L.Push(LString(err.Error()))
+	return 2
+}
+
 func fileWrite(L *LState) int {
 	return fileWriteAux(L, checkFile(L), 2)
 }
@@ -461,23 +555,41 @@ func fileFlush(L *LState) int {
 	return fileFlushAux(L, checkFile(L))
 }
 
-func fileLinesIter(L *LState) int {
-	var file *lFile
-	if ud, ok := L.Get(1).(*LUserData); ok {
-		file = ud.Value.(*lFile)
-	} else {
-		file = L.Get(UpvalueIndex(2)).(*LUserData).Value.(*lFile)
+// linesIter is the iterator returned by file:lines and io.lines. Its upvalues are the file, whether the file is
+// closed at its end, and the formats to read.
+func linesIter(L *LState) int {
+	ud := L.Get(UpvalueIndex(1)).(*LUserData)
+	file := ud.Value.(*lFile)
+	if file.closed {
+		L.RaiseError("file is already closed")
+	}
+	toclose := L.Get(UpvalueIndex(2)) == LTrue
+	L.SetTop(0)
+	for i := 3; ; i++ {
+		format := L.Get(UpvalueIndex(i))
+		if format == LNil {
+			break
+		}
+		L.Push(format)
 	}
-	buf, _, err := file.reader.ReadLine()
+	n, err := fileReadFormats(L, file, 1)
 	if err != nil {
-		if err == io.EOF {
-			L.Push(LNil)
-			return 1
-		}
 		L.RaiseError("%s", err.Error())
 	}
-	L.Push(LString(string(buf)))
-	return 1
+	if toclose && L.Get(-n) == LNil {
+		fileCloseAux(L, file)
+		L.Pop(1)
+	}
+	return n
+}
+
+// newLinesIter returns an iterator over the file ud reading the formats from idx to the top of the stack.
+func newLinesIter(L *LState, ud *LUserData, toclose bool, idx int) *LFunction {
+	upvalues := []LValue{ud, LBool(toclose)}
+	for i := idx; i <= L.GetTop(); i++ {
+		upvalues = append(upvalues, L.Get(i))
+	}
+	return L.NewClosure(linesIter, upvalues...)
 }
 
 func fileLines(L *LState) int {
@@ -486,7 +598,7 @@ func fileLines(L *LState) int {
 	if n := fileIsReadable(L, file); n != 0 {
 		return 0
 	}
-	L.Push(L.NewClosure(fileLinesIter, L.Get(UpvalueIndex(1)), ud))
+	L.Push(newLinesIter(L, ud, false, 2))
 	return 1
 }
 
@@ -494,45 +606,44 @@ func fileRead(L *LState) int {
 	return fileReadAux(L, checkFile(L), 2)
 }
 
-var filebufOptions = []string{"no", "full"}
+var filebufOptions = []string{"no", "full", "line"}
 
+// fileSetVBuf sets the buffering mode of a file. Data buffered in the previous mode is written first. Buffers of
+// files that are still open are flushed when the state is closed.
 func fileSetVBuf(L *LState) int {
-	var err error
-	var writer io.Writer
 	file := checkFile(L)
 	if n := fileIsWritable(L, file); n != 0 {
 		return n
 	}
-	switch filebufOptions[L.CheckOption(2, filebufOptions)] {
+	errorIfFileIsClosed(L, file)
+	mode := filebufOptions[L.CheckOption(2, filebufOptions)]
+	bufsize := L.OptInt(3, fileDefaultWriteBuffer)
+	if bufsize <= 0 {
+		bufsize = fileDefaultWriteBuffer
+	}
+	if err := file.FlushWriteBuffer(); err != nil {
+		L.Push(LNil)
+		L.Push(LString(err.Error()))
+		return 2
+	}
+	switch mode {
 	case "no":
-		switch file.Type() {
-		case lFileFile:
-			file.writer = file.fp
-		case lFileProcess:
-			file.writer, err = file.pp.StdinPipe()
-			if err != nil {
-				goto errreturn
-			}
+		file.writer = file.sink
+		delete(L.G.bufferedFiles, file)
+	case "full", "line":
+		bwriter := bufio.NewWriterSize(file.sink, bufsize)
+		if mode == "line" {
+			file.writer = lineWriter{bwriter}
+		} else {
+			file.writer = bwriter
 		}
-	case "full", "line": // TODO line buffer not supported
-		bufsize := L.OptInt(3, fileDefaultWriteBuffer)
-		switch file.Type() {
-		case lFileFile:
-			file.writer = bufio.NewWriterSize(file.fp, bufsize)
-		case lFileProcess:
-			writer, err = file.pp.StdinPipe()
-			if err != nil {
-				goto errreturn
-			}
-			file.writer = bufio.NewWriterSize(writer, bufsize)
+		if L.G.bufferedFiles == nil {
+			L.G.bufferedFiles = make(map[*lFile]struct{})
 		}
+		L.G.bufferedFiles[file] = struct{}{}
 	}
 	L.Push(LTrue)
 	return 1
-errreturn:
-	L.Push(LNil)
-	L.Push(LString(err.Error()))
-	return 2
 }
 
 func ioInput(L *LState) int {
@@ -542,6 +653,7 @@ func ioInput(L *LState) int {
 	}
 	switch lv := L.Get(1).(type) {
 	case LString:
+		L.enforcePolicy("io.open", lv, LString("r"))
 		file, err := newFile(L, nil, string(lv), os.O_RDONLY, 0600, false, true)
 		if err != nil {
 			L.RaiseError("%s", err.Error())
@@ -572,43 +684,19 @@ func ioFlush(L *LState) int {
 	return fileFlushAux(L, fileDefOut(L).Value.(*lFile))
 }
 
-func ioLinesIter(L *LState) int {
-	var file *lFile
-	toclose := false
-	if ud, ok := L.Get(1).(*LUserData); ok {
-		file = ud.Value.(*lFile)
-	} else {
-		file = L.Get(UpvalueIndex(2)).(*LUserData).Value.(*lFile)
-		toclose = true
-	}
-	buf, _, err := file.reader.ReadLine()
-	if err != nil {
-		if err == io.EOF {
-			if toclose {
-				fileCloseAux(L, file)
-			}
-			L.Push(LNil)
-			return 1
-		}
-		L.RaiseError("%s", err.Error())
-	}
-	L.Push(LString(buf))
-	return 1
-}
-
 func ioLines(L *LState) int {
-	if L.GetTop() == 0 {
-		L.Push(L.Get(UpvalueIndex(2)))
-		L.Push(fileDefIn(L))
-		return 2
+	if L.Get(1) == LNil {
+		L.Push(newLinesIter(L, fileDefIn(L), false, 2))
+		return 1
 	}
 
 	path := L.CheckString(1)
+	L.enforcePolicy("io.open", LString(path), LString("r"))
 	ud, err := newFile(L, nil, path, os.O_RDONLY, os.FileMode(0600), false, true)
 	if err != nil {
-		return 0
+		L.RaiseError("%s", err.Error())
 	}
-	L.Push(L.NewClosure(ioLinesIter, L.Get(UpvalueIndex(1)), ud))
+	L.Push(newLinesIter(L, ud, true, 2))
 	return 1
 }
 
@@ -623,7 +711,9 @@ func ioOpenFile(L *LState) int {
 	perm := 0600
 	writable := true
 	readable := true
-	switch ioOpenOpions[L.CheckOption(2, ioOpenOpions)] {
+	option := ioOpenOpions[L.CheckOption(2, ioOpenOpions)]
+	L.enforcePolicy("io.open", LString(path), LString(option))
+	switch option {
 	case "r", "rb":
 		mode = os.O_RDONLY
 		writable = false
@@ -664,7 +754,9 @@ func ioPopen(L *LState) int {
 	var file *LUserData
 	var err error
 
-	switch ioPopenOptions[L.CheckOption(2, ioPopenOptions)] {
+	mode := ioPopenOptions[L.CheckOption(2, ioPopenOptions)]
+	L.enforcePolicy("io.popen", LString(cmd), LString(mode))
+	switch mode {
 	case "r":
 		file, err = newProcess(L, cmd, false, true)
 	case "w":
@@ -703,13 +795,13 @@ func ioType(L *LState) int {
 }
 
 func ioTmpFile(L *LState) int {
-	file, err := os.CreateTemp("", "")
+	L.enforcePolicy("io.tmpfile")
+	file, _, err := L.createTempFile(true)
 	if err != nil {
 		L.Push(LNil)
 		L.Push(LString(err.Error()))
 		return 2
 	}
-	L.G.tempFiles = append(L.G.tempFiles, file)
 	ud, _ := newFile(L, file, "", 0, os.FileMode(0), true, true)
 	L.Push(ud)
 	return 1
@@ -722,6 +814,7 @@ func ioOutput(L *LState) int {
 	}
 	switch lv := L.Get(1).(type) {
 	case LString:
+		L.enforcePolicy("io.open", lv, LString("w"))
 		file, err := newFile(L, nil, string(lv), os.O_WRONLY|os.O_CREATE, 0600, true, false)
 		if err != nil {
 			L.RaiseError("%s", err.Error())
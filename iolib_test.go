@@ -0,0 +1,136 @@
+package lua
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIoReadFormats(t *testing.T) {
+	skipIfNoSys(t)
+	path := filepath.Join(t.TempDir(), "data.txt")
+	errorIfNotNil(t, os.WriteFile(path, []byte("12 0x1F -3.5e2 0x1p4 abc\nline 2\r\nline 3\nend"), 0644))
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("path", LString(path))
+	errorIfScriptFail(t, L, `
+local f = assert(io.open(path))
+local a, b, c, d = f:read("n", "*n", "n", "n")
+assert(a == 12 and b == 31 and c == -350 and d == 16)
+local x, y = f:read("n", "l")
+assert(x == nil and y == nil)
+assert(f:read("l") == "abc")
+assert(f:read("L") == "line 2\r\n")
+assert(f:read(0) == "" and f:read(4) == "line")
+assert(f:read("*l") == " 3")
+assert(f:read("a") == "end")
+assert(f:read("a") == "" and f:read("l") == nil and f:read(0) == nil and f:read(1) == nil)
+f:close()
+
+local lines = {}
+for l in io.lines(path) do lines[#lines + 1] = l end
+assert(#lines == 4 and lines[2] == "line 2\r" and lines[4] == "end")
+lines = {}
+for a, b in io.lines(path, 2, "L") do lines[#lines + 1] = a .. "|" .. b end
+assert(lines[1] == "12| 0x1F -3.5e2 0x1p4 abc\n" and lines[2] == "li|ne 2\r\n", lines[1])
+f = assert(io.open(path))
+local n = 0
+for num in f:lines("n") do n = n + num end
+assert(n == 12 + 31 - 350 + 16)
+f:close()
+`)
+	errorIfScriptNotFail(t, L, `io.open(path):read("x")`, "invalid format")
+	errorIfScriptNotFail(t, L, `io.lines(path .. ".missing")`, "no such file or directory")
+}
+
+func TestIoLargeFiles(t *testing.T) {
+	skipIfNoSys(t)
+	path := filepath.Join(t.TempDir(), "sparse.bin")
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("path", LString(path))
+	errorIfScriptFail(t, L, `
+local f = assert(io.open(path, "wb+"))
+assert(f:setvbuf("full"))
+assert(f:seek("set", 3 * 2^30) == 3 * 2^30)
+assert(f:write("tail"))
+assert(f:seek("end") == 3 * 2^30 + 4)
+assert(f:seek("end", -4) == 3 * 2^30)
+assert(f:read(100) == "tail")
+assert(f:seek("set", 3 * 2^30 - 2) and f:read(3) == "\0\0t")
+assert(f:truncate(5 * 2^30))
+assert(f:seek("end") == 5 * 2^30)
+assert(f:seek("set", 2) and f:truncate())
+assert(f:seek("cur") == 2 and f:seek("end") == 2)
+assert(f:read("a") == "")
+f:close()
+`)
+	fi, err := os.Stat(path)
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, int64(2), fi.Size())
+	errorIfScriptNotFail(t, L, `io.tmpfile():truncate(-1)`, "size must not be negative")
+}
+
+func TestIoSetVBuf(t *testing.T) {
+	skipIfNoSys(t)
+	path := filepath.Join(t.TempDir(), "out.txt")
+	size := func() LNumber {
+		fi, err := os.Stat(path)
+		errorIfNotNil(t, err)
+		return LNumber(fi.Size())
+	}
+	L := NewState()
+	L.SetGlobal("path", LString(path))
+	L.SetGlobal("size", L.NewFunction(func(L *LState) int {
+		L.Push(size())
+		return 1
+	}))
+	errorIfScriptFail(t, L, `
+f = assert(io.open(path, "w"))
+assert(f:setvbuf("line"))
+f:write("a", "b")
+assert(size() == 0)
+f:write("c\n", "d")
+assert(size() == 4)
+assert(f:setvbuf("no"))
+assert(size() == 5)
+f:write("e")
+assert(size() == 6)
+assert(f:setvbuf("full", 8))
+f:write("0123456")
+assert(size() == 6)
+f:write("789")
+assert(size() == 14)
+f:write("tail")
+`)
+	errorIfScriptNotFail(t, L, `f:setvbuf("some")`, "invalid option")
+	errorIfNotEqual(t, LNumber(14), size())
+	L.Close()
+	errorIfNotEqual(t, LNumber(20), size())
+}
+
+func TestIoRedirectedStreams(t *testing.T) {
+	skipIfNoSys(t)
+	var stdout, stderr bytes.Buffer
+	L := NewState(Options{Stdout: &stdout, Stderr: &stderr, Stdin: strings.NewReader("12 line\nrest")})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+print("a", 1, nil)
+io.write("b", 2, "\n")
+io.stderr:write("c")
+assert(io.read("n") == 12 and io.read("l") == " line" and io.stdin:read("a") == "rest")
+assert(io.stdout:setvbuf("full"))
+io.stdout:write("d")
+local ok, msg = io.stdout:seek("set")
+assert(ok == nil and msg == "can not seek a stream.")
+assert(io.write("e"))
+assert(io.stdout:close() and tostring(io.stdout) == "file (closed)")
+assert(os.execute("echo f") == 0)
+`)
+	out, executed, _ := strings.Cut(stdout.String(), "de")
+	errorIfNotEqual(t, "a\t1\tnil\nb2\n", out)
+	errorIfNotEqual(t, "f", strings.TrimSpace(executed))
+	errorIfNotEqual(t, "c", stderr.String())
+}
@@ -21,6 +21,26 @@ const (
 	ChannelLibName = "channel"
 	// CoroutineLibName is the name of the coroutine Library.
 	CoroutineLibName = "coroutine"
+	// BigLibName is the name of the big integer Library.
+	BigLibName = "big"
+	// DecimalLibName is the name of the decimal Library.
+	DecimalLibName = "decimal"
+	// Bit32LibName is the name of the bit32 Library.
+	Bit32LibName = "bit32"
+	// BytesLibName is the name of the bytes Library.
+	BytesLibName = "bytes"
+	// LogLibName is the name of the log Library.
+	LogLibName = "log"
+	// UStringLibName is the name of the ustring Library.
+	UStringLibName = "ustring"
+	// CsvLibName is the name of the csv Library.
+	CsvLibName = "csv"
+	// HelpLibName is the name of the help Library.
+	HelpLibName = "help"
+	// NormLibName is the name of the norm Library, which is only built with the "norm" build tag.
+	NormLibName = "norm"
+	// CharsetLibName is the name of the charset Library, which is only built with the "charset" build tag.
+	CharsetLibName = "charset"
 )
 
 type luaLib struct {
@@ -41,6 +61,19 @@ var luaLibs = []luaLib{
 	luaLib{CoroutineLibName, OpenCoroutine},
 }
 
+// extraLibs are the built-in libraries that are not part of Lua 5.1. OpenLibs does not open them, so that the
+// globals of a state stay the standard ones(see PreloadExtraLibs).
+var extraLibs = []luaLib{
+	luaLib{BigLibName, OpenBig},
+	luaLib{DecimalLibName, OpenDecimal},
+	luaLib{Bit32LibName, OpenBit32},
+	luaLib{BytesLibName, OpenBytes},
+	luaLib{LogLibName, OpenLog},
+	luaLib{UStringLibName, OpenUString},
+	luaLib{CsvLibName, OpenCsv},
+	luaLib{HelpLibName, OpenHelp},
+}
+
 // OpenLibs loads the built-in libraries. It is equivalent to running OpenLoad,
 // then OpenBase, then iterating over the other OpenXXX functions in any order.
 func (ls *LState) OpenLibs() {
@@ -52,3 +85,17 @@ func (ls *LState) OpenLibs() {
 		ls.Call(1, 0)
 	}
 }
+
+// PreloadExtraLibs preloads the built-in libraries that are not part of Lua 5.1: big, decimal, bit32, bytes, log,
+// ustring, csv and help. Scripts load them with require, which also sets the global of the library:
+//
+//	L.PreloadExtraLibs()
+//	L.DoString(`local csv = require("csv")`)
+//
+// A single library is opened as a global by calling its OpenXXX function like OpenLibs does, or preloaded with
+// `LState.PreloadModule`, e.g. L.PreloadModule("csv", lua.OpenCsv).
+func (ls *LState) PreloadExtraLibs() {
+	for _, lib := range extraLibs {
+		ls.PreloadModule(lib.libName, lib.libFunc)
+	}
+}
@@ -37,7 +37,7 @@ func loFindFile(L *LState, name, pname string) (string, string) {
 	messages := []string{}
 	for _, pattern := range strings.Split(string(path), ";") {
 		luapath := strings.Replace(pattern, "?", name, -1)
-		if _, err := os.Stat(luapath); err == nil {
+		if _, err := L.statFile(luapath); err == nil {
 			return luapath, ""
 		} else {
 			messages = append(messages, err.Error())
@@ -0,0 +1,103 @@
+package lua
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* log library {{{ */
+
+// LogLevel is the level of a message logged by the log library.
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelNames = [...]string{"debug", "info", "warn", "error"}
+
+func (lv LogLevel) String() string {
+	if lv < LogDebug || lv > LogError {
+		return "unknown"
+	}
+	return logLevelNames[lv]
+}
+
+// LogRecord is a message logged by a script. It is passed to `Options.Log`.
+type LogRecord struct {
+	Level   LogLevel
+	Message string
+	// Source and Line are the chunk name and the line of the innermost Lua function, if any.
+	Source string
+	Line   int
+}
+
+// OpenLog opens the log library: log.debug, log.info, log.warn and log.error log their arguments like print
+// does, or format them like string.format does if more than one argument is given and the first is a string
+// containing a '%'.
+// Messages are passed to `Options.Log`, or are written to the standard error stream of the state if it is not set.
+func OpenLog(L *LState) int {
+	mod := L.RegisterModule(LogLibName, logFuncs)
+	L.Push(mod)
+	return 1
+}
+
+var logFuncs = map[string]LGFunction{
+	"debug": logFunc(LogDebug),
+	"info":  logFunc(LogInfo),
+	"warn":  logFunc(LogWarn),
+	"error": logFunc(LogError),
+}
+
+func logFunc(level LogLevel) LGFunction {
+	return func(L *LState) int {
+		top := L.GetTop()
+		if s, ok := L.Get(1).(LString); ok && top > 1 && strings.Contains(string(s), "%") {
+			args := make([]interface{}, top-1)
+			for i := 2; i <= top; i++ {
+				args[i-2] = L.Get(i)
+			}
+			L.log(level, formatValues(string(s), args))
+			return 0
+		}
+		L.log(level, L.joinArgs())
+		return 0
+	}
+}
+
+// joinArgs returns the arguments of the running function converted by tostring and separated by tabs, like print
+// writes them.
+func (ls *LState) joinArgs() string {
+	top := ls.GetTop()
+	buf := make([]string, 0, top)
+	for i := 1; i <= top; i++ {
+		buf = append(buf, ls.ToStringMeta(ls.Get(i)).String())
+	}
+	return strings.Join(buf, "\t")
+}
+
+// log passes a message to `Options.Log`, or writes it to the standard error stream of the state.
+func (ls *LState) log(level LogLevel, msg string) {
+	rec := &LogRecord{Level: level, Message: msg}
+	for cf := ls.currentFrame; cf != nil; cf = cf.Parent {
+		if !cf.Fn.IsG {
+			frame := ls.frameOf(cf)
+			rec.Source, rec.Line = frame.Source, frame.Line
+			break
+		}
+	}
+	if ls.Options.Log != nil {
+		ls.Options.Log(ls, rec)
+		return
+	}
+	if rec.Source != "" {
+		fmt.Fprintf(ls.stderr(), "%s: %s:%d: %s\n", strings.ToUpper(level.String()), rec.Source, rec.Line, msg)
+	} else {
+		fmt.Fprintf(ls.stderr(), "%s: %s\n", strings.ToUpper(level.String()), msg)
+	}
+}
+
+/* }}} */
@@ -0,0 +1,42 @@
+package lua
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLogLib(t *testing.T) {
+	var records []LogRecord
+	L := NewState(Options{Log: func(L *LState, rec *LogRecord) {
+		records = append(records, *rec)
+	}, PrintToLog: true})
+	defer L.Close()
+	L.PreloadExtraLibs()
+	errorIfScriptFail(t, L, `assert(require("log") == log)`)
+	errorIfScriptFail(t, L, `
+	log.debug("a", 1, nil)
+	log.info("%d items in %s", 3, "cart")
+	local function warn() log.warn("%s", "low") end
+	warn()
+	log.error(setmetatable({}, {__tostring = function() return "object" end}))
+	print("printed", true)
+	`)
+	errorIfNotEqual(t, 5, len(records))
+	errorIfNotEqual(t, LogRecord{Level: LogDebug, Message: "a\t1\tnil", Source: "<string>", Line: 2}, records[0])
+	errorIfNotEqual(t, LogRecord{Level: LogInfo, Message: "3 items in cart", Source: "<string>", Line: 3}, records[1])
+	errorIfNotEqual(t, LogRecord{Level: LogWarn, Message: "low", Source: "<string>", Line: 4}, records[2])
+	errorIfNotEqual(t, LogRecord{Level: LogError, Message: "object", Source: "<string>", Line: 6}, records[3])
+	errorIfNotEqual(t, LogRecord{Level: LogInfo, Message: "printed\ttrue", Source: "<string>", Line: 7}, records[4])
+	errorIfNotEqual(t, "warn", LogWarn.String())
+}
+
+func TestLogLibStderr(t *testing.T) {
+	var stderr, stdout bytes.Buffer
+	L := NewState(Options{Stderr: &stderr, Stdout: &stdout})
+	defer L.Close()
+	L.PreloadExtraLibs()
+	errorIfScriptFail(t, L, `assert(require("log") == log)`)
+	errorIfScriptFail(t, L, `log.warn("disk %d%% full", 90) print("out")`)
+	errorIfNotEqual(t, "WARN: <string>:1: disk 90% full\n", stderr.String())
+	errorIfNotEqual(t, "out\n", stdout.String())
+}
@@ -2,7 +2,6 @@ package lua
 
 import (
 	"math"
-	"math/rand"
 )
 
 func OpenMath(L *LState) int {
@@ -46,84 +45,84 @@ var mathFuncs = map[string]LGFunction{
 }
 
 func mathAbs(L *LState) int {
-	L.Push(LNumber(math.Abs(float64(L.CheckNumber(1)))))
+	L.PushNumber(LNumber(math.Abs(float64(L.CheckNumber(1)))))
 	return 1
 }
 
 func mathAcos(L *LState) int {
-	L.Push(LNumber(math.Acos(float64(L.CheckNumber(1)))))
+	L.PushNumber(LNumber(math.Acos(float64(L.CheckNumber(1)))))
 	return 1
 }
 
 func mathAsin(L *LState) int {
-	L.Push(LNumber(math.Asin(float64(L.CheckNumber(1)))))
+	L.PushNumber(LNumber(math.Asin(float64(L.CheckNumber(1)))))
 	return 1
 }
 
 func mathAtan(L *LState) int {
-	L.Push(LNumber(math.Atan(float64(L.CheckNumber(1)))))
+	L.PushNumber(LNumber(math.Atan(float64(L.CheckNumber(1)))))
 	return 1
 }
 
 func mathAtan2(L *LState) int {
-	L.Push(LNumber(math.Atan2(float64(L.CheckNumber(1)), float64(L.CheckNumber(2)))))
+	L.PushNumber(LNumber(math.Atan2(float64(L.CheckNumber(1)), float64(L.CheckNumber(2)))))
 	return 1
 }
 
 func mathCeil(L *LState) int {
-	L.Push(LNumber(math.Ceil(float64(L.CheckNumber(1)))))
+	L.PushNumber(LNumber(math.Ceil(float64(L.CheckNumber(1)))))
 	return 1
 }
 
 func mathCos(L *LState) int {
-	L.Push(LNumber(math.Cos(float64(L.CheckNumber(1)))))
+	L.PushNumber(LNumber(math.Cos(float64(L.CheckNumber(1)))))
 	return 1
 }
 
 func mathCosh(L *LState) int {
-	L.Push(LNumber(math.Cosh(float64(L.CheckNumber(1)))))
+	L.PushNumber(LNumber(math.Cosh(float64(L.CheckNumber(1)))))
 	return 1
 }
 
 func mathDeg(L *LState) int {
-	L.Push(LNumber(float64(L.CheckNumber(1)) * 180 / math.Pi))
+	L.PushNumber(LNumber(float64(L.CheckNumber(1)) * 180 / math.Pi))
 	return 1
 }
 
 func mathExp(L *LState) int {
-	L.Push(LNumber(math.Exp(float64(L.CheckNumber(1)))))
+	L.PushNumber(LNumber(math.Exp(float64(L.CheckNumber(1)))))
 	return 1
 }
 
 func mathFloor(L *LState) int {
-	L.Push(LNumber(math.Floor(float64(L.CheckNumber(1)))))
+	L.PushNumber(LNumber(math.Floor(float64(L.CheckNumber(1)))))
 	return 1
 }
 
 func mathFmod(L *LState) int {
-	L.Push(LNumber(math.Mod(float64(L.CheckNumber(1)), float64(L.CheckNumber(2)))))
+	L.PushNumber(LNumber(math.Mod(float64(L.CheckNumber(1)), float64(L.CheckNumber(2)))))
 	return 1
 }
 
 func mathFrexp(L *LState) int {
 	v1, v2 := math.Frexp(float64(L.CheckNumber(1)))
-	L.Push(LNumber(v1))
-	L.Push(LNumber(v2))
+	L.PushNumber(LNumber(v1))
+	L.PushNumber(LNumber(v2))
 	return 2
 }
 
 func mathLdexp(L *LState) int {
-	L.Push(LNumber(math.Ldexp(float64(L.CheckNumber(1)), L.CheckInt(2))))
+	L.PushNumber(LNumber(math.Ldexp(float64(L.CheckNumber(1)), L.CheckInt(2))))
 	return 1
 }
 
 func mathLog(L *LState) int {
-	L.Push(LNumber(math.Log(float64(L.CheckNumber(1)))))
+	L.PushNumber(LNumber(math.Log(float64(L.CheckNumber(1)))))
 	return 1
 }
 
 func mathLog10(L *LState) int {
-	L.Push(LNumber(math.Log10(float64(L.CheckNumber(1)))))
+	L.PushNumber(LNumber(math.Log10(float64(L.CheckNumber(1)))))
 	return 1
 }
 
@@ -168,63 +167,99 @@ func mathMod(L *LState) int {
 
 func mathModf(L *LState) int {
 	v1, v2 := math.Modf(float64(L.CheckNumber(1)))
-	L.Push(LNumber(v1))
-	L.Push(LNumber(v2))
+	L.PushNumber(LNumber(v1))
+	L.PushNumber(LNumber(v2))
 	return 2
 }
 
 func mathPow(L *LState) int {
-	L.Push(LNumber(math.Pow(float64(L.CheckNumber(1)), float64(L.CheckNumber(2)))))
+	L.PushNumber(LNumber(math.Pow(float64(L.CheckNumber(1)), float64(L.CheckNumber(2)))))
 	return 1
 }
 
 func mathRad(L *LState) int {
-	L.Push(LNumber(float64(L.CheckNumber(1)) * math.Pi / 180))
+	L.PushNumber(LNumber(float64(L.CheckNumber(1)) * math.Pi / 180))
 	return 1
 }
 
+// mathRandom implements math.random of Lua 5.4: math.random() returns a float in [0, 1), math.random(m, n) an
+// integer in [m, n], math.random(n) an integer in [1, n] and math.random(0) a random integer of 64 bits. Integers
+// beyond 2^53 are rounded to the nearest number.
 func mathRandom(L *LState) int {
-	switch L.GetTop() {
+	var low, up int64
+	top := L.GetTop()
+	switch top {
 	case 0:
-		L.Push(LNumber(rand.Float64()))
 	case 1:
-		n := L.CheckInt(1)
-		L.Push(LNumber(rand.Intn(n) + 1))
+		low, up = 1, L.CheckInt64(1)
+	case 2:
+		low, up = L.CheckInt64(1), L.CheckInt64(2)
 	default:
-		min := L.CheckInt(1)
-		max := L.CheckInt(2) + 1
-		L.Push(LNumber(rand.Intn(max-min) + min))
+		L.RaiseError("wrong number of arguments")
 	}
+	raw := top == 1 && up == 0
+	if top > 0 && !raw && low > up {
+		L.ArgError(1, "interval is empty")
+	}
+	L.Push(L.Nondeterministic("math.random", func() []LValue {
+		rv := L.randUint64()
+		switch {
+		case top == 0:
+			return []LValue{LNumber(randomFloat(rv))}
+		case raw:
+			return []LValue{LNumber(int64(rv))}
+		}
+		return []LValue{LNumber(int64(projectRandom(rv, uint64(up)-uint64(low), L.randUint64) + uint64(low)))}
+	})[0])
 	return 1
 }
 
+// mathRandomseed seeds the generator with the seed pair n1, n2(default 0), or a random pair if no seed is given,
+// and returns the pair.
 func mathRandomseed(L *LState) int {
-	rand.Seed(L.CheckInt64(1))
-	return 0
+	var n1, n2 int64
+	if L.GetTop() == 0 {
+		seeds := L.Nondeterministic("math.randomseed", func() []LValue {
+			n1, n2 := randomSeeds()
+			return []LValue{LNumber(n1), LNumber(n2)}
+		})
+		if len(seeds) != 2 {
+			L.RaiseError("replay: invalid event for math.randomseed")
+		}
+		s1, _ := seeds[0].(LNumber)
+		s2, _ := seeds[1].(LNumber)
+		n1, n2 = int64(s1), int64(s2)
+	} else {
+		n1, n2 = L.CheckInt64(1), L.OptInt64(2, 0)
+	}
+	L.seedRandom(uint64(n1), uint64(n2))
+	L.PushNumber(LNumber(n1))
+	L.PushNumber(LNumber(n2))
+	return 2
 }
 
 func mathSin(L *LState) int {
-	L.Push(LNumber(math.Sin(float64(L.CheckNumber(1)))))
+	L.PushNumber(LNumber(math.Sin(float64(L.CheckNumber(1)))))
 	return 1
 }
 
 func mathSinh(L *LState) int {
-	L.Push(LNumber(math.Sinh(float64(L.CheckNumber(1)))))
+	L.PushNumber(LNumber(math.Sinh(float64(L.CheckNumber(1)))))
 	return 1
 }
 
 func mathSqrt(L *LState) int {
-	L.Push(LNumber(math.Sqrt(float64(L.CheckNumber(1)))))
+	L.PushNumber(LNumber(math.Sqrt(float64(L.CheckNumber(1)))))
 	return 1
 }
 
 func mathTan(L *LState) int {
-	L.Push(LNumber(math.Tan(float64(L.CheckNumber(1)))))
+	L.PushNumber(LNumber(math.Tan(float64(L.CheckNumber(1)))))
 	return 1
 }
 
 func mathTanh(L *LState) int {
-	L.Push(LNumber(math.Tanh(float64(L.CheckNumber(1)))))
+	L.PushNumber(LNumber(math.Tanh(float64(L.CheckNumber(1)))))
 	return 1
 }
 
@@ -0,0 +1,73 @@
+package lua
+
+import (
+	"sort"
+	"time"
+)
+
+/* function metrics {{{ */
+
+// FunctionMetrics holds the counters of a Lua function collected with `Options.Metrics`. Closures created from the
+// same function definition share their counters.
+type FunctionMetrics struct {
+	// Source and Line are the chunk and the line the function is defined at.
+	Source string
+	Line   int
+	// Name is the name the function has been called by first, as in tracebacks.
+	Name string
+	// Calls is the number of calls that returned or have been unwound by errors.
+	Calls int64
+	// Total is the wall time spent in the calls, including the functions they called.
+	Total time.Duration
+	// Max is the longest call.
+	Max time.Duration
+}
+
+// countCall adds the call of the function of cf, which is returning, to the metrics of the state.
+func (ls *LState) countCall(cf *callFrame) {
+	proto := cf.Fn.Proto
+	if proto == nil || cf.Idx >= len(ls.hooks.starts) {
+		return
+	}
+	elapsed := time.Since(ls.hooks.starts[cf.Idx])
+	if ls.G.metrics == nil {
+		ls.G.metrics = make(map[*FunctionProto]*FunctionMetrics)
+	}
+	m := ls.G.metrics[proto]
+	if m == nil {
+		m = &FunctionMetrics{Source: proto.SourceName, Line: proto.LineDefined, Name: ls.rawFrameFuncName(cf)}
+		ls.G.metrics[proto] = m
+	}
+	m.Calls++
+	m.Total += elapsed
+	if elapsed > m.Max {
+		m.Max = elapsed
+	}
+}
+
+// Metrics returns the counters of the Lua functions called by the state and its coroutines since it has been
+// created or the counters have been reset, the functions with the largest total time first. Counters are only
+// collected with `Options.Metrics`.
+func (ls *LState) Metrics() []FunctionMetrics {
+	report := make([]FunctionMetrics, 0, len(ls.G.metrics))
+	for _, m := range ls.G.metrics {
+		report = append(report, *m)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Total != report[j].Total {
+			return report[i].Total > report[j].Total
+		}
+		if report[i].Source != report[j].Source {
+			return report[i].Source < report[j].Source
+		}
+		return report[i].Line < report[j].Line
+	})
+	return report
+}
+
+// ResetMetrics clears the counters reported by `LState.Metrics`.
+func (ls *LState) ResetMetrics() {
+	ls.G.metrics = nil
+}
+
+/* }}} */
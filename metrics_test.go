@@ -0,0 +1,52 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	L := NewState(Options{Metrics: true})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local function leaf(n)
+	  local s = 0
+	  for i = 1, n do s = s + i end
+	  return s
+	end
+	function outer()
+	  for i = 1, 10 do leaf(1000) end
+	end
+	local function fails() error("x") end
+	outer()
+	pcall(fails)
+	local co = coroutine.wrap(function() leaf(1) coroutine.yield() end)
+	co()
+	`)
+	byLine := make(map[int]FunctionMetrics)
+	for _, m := range L.Metrics() {
+		byLine[m.Line] = m
+	}
+	leaf, outer, fails := byLine[2], byLine[7], byLine[10]
+	errorIfNotEqual(t, int64(11), leaf.Calls)
+	errorIfNotEqual(t, "leaf", leaf.Name)
+	errorIfNotEqual(t, "<string>", leaf.Source)
+	errorIfNotEqual(t, int64(1), outer.Calls)
+	errorIfNotEqual(t, "outer", outer.Name)
+	errorIfFalse(t, outer.Total >= leaf.Total-leaf.Max, "outer should include the time of leaf")
+	errorIfFalse(t, leaf.Max > 0 && leaf.Max <= leaf.Total, "unexpected max %v", leaf.Max)
+	errorIfNotEqual(t, int64(1), fails.Calls)
+
+	report := L.Metrics()
+	for i := 1; i < len(report); i++ {
+		errorIfFalse(t, report[i-1].Total >= report[i].Total, "report should be sorted by total time")
+	}
+	L.ResetMetrics()
+	errorIfNotEqual(t, 0, len(L.Metrics()))
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `local function f() end f()`)
+	errorIfNotEqual(t, 0, len(L.Metrics()))
+}
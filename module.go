@@ -0,0 +1,316 @@
+package lua
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/* module builder {{{ */
+
+// ModuleBuilder defines a module of Go functions and constants, with documentation that can be looked up at
+// runtime(see `LState.ModuleInfo` and `LState.FuncInfo`). Modules are defined by chaining calls on `Module`:
+//
+//	mylib := lua.Module("mylib").
+//		Doc("Utilities for scripts.").
+//		Func("join", strings.Join, "join(list, sep) string\nJoins the strings of list with sep.").
+//		Const("version", "1.0").
+//		SubModule(lua.Module("text").Func("upper", strings.ToUpper, "Returns s in upper case."))
+//	mylib.Preload(L)
+//
+// Scripts then require the module, and submodules either through their parent or by their full names:
+//
+//	local mylib = require("mylib")
+//	print(mylib.join({"a", "b"}, ","), mylib.text.upper("a"), require("mylib.text").upper("b"))
+type ModuleBuilder struct {
+	name    string
+	doc     string
+	members []moduleMember
+	subs    []*ModuleBuilder
+}
+
+type moduleMember struct {
+	name  string
+	fn    LGFunction // nil for constants
+	value interface{}
+	info  FuncInfo
+}
+
+// FuncInfo describes a function of a module defined with `Module`.
+type FuncInfo struct {
+	// Name is the full name of the function, e.g. "mylib.text.upper".
+	Name string
+	// Signature is the first line of the documentation if it starts with the name of the function followed by a
+	// parenthesis, e.g. "join(list, sep) string". Otherwise it is built from the Go types of the parameters and
+	// results, e.g. "join(table, string) string".
+	Signature string
+	// Doc is the documentation of the function, without the signature.
+	Doc string
+	// NumParams is the number of parameters, not counting the variadic one, or -1 for an LGFunction.
+	NumParams int
+	// Variadic is true if the function takes a variable number of arguments. It is true for LGFunctions.
+	Variadic bool
+}
+
+// ModuleInfo describes a module defined with `Module`.
+type ModuleInfo struct {
+	// Name is the full name of the module, e.g. "mylib.text".
+	Name string
+	Doc  string
+	// Funcs are the functions of the module in the order they were defined.
+	Funcs []*FuncInfo
+	// Consts are the names of the constants of the module in the order they were defined.
+	Consts []string
+	// SubModules are the full names of the submodules.
+	SubModules []string
+}
+
+// Module starts the definition of the module name.
+func Module(name string) *ModuleBuilder {
+	return &ModuleBuilder{name: name}
+}
+
+// Name returns the name of the module.
+func (mb *ModuleBuilder) Name() string { return mb.name }
+
+// Doc sets the documentation of the module.
+func (mb *ModuleBuilder) Doc(doc string) *ModuleBuilder {
+	mb.doc = doc
+	return mb
+}
+
+// Func adds the function name. fn is an LGFunction or a Go function of any signature(see `WrapGoFunction`). The
+// first line of doc is the signature of the function if it starts with name followed by a parenthesis.
+func (mb *ModuleBuilder) Func(name string, fn interface{}, doc string) *ModuleBuilder {
+	info := FuncInfo{Doc: strings.TrimSpace(doc), NumParams: -1, Variadic: true}
+	if line, rest, _ := strings.Cut(info.Doc, "\n"); strings.HasPrefix(line, name+"(") {
+		info.Signature, info.Doc = strings.TrimSpace(line), strings.TrimSpace(rest)
+	}
+	lf := WrapGoFunction(fn)
+	params, results := "...", ""
+	if _, ok := fn.(LGFunction); !ok {
+		if _, ok := fn.(func(*LState) int); !ok {
+			ft := reflect.TypeOf(fn)
+			sig := goSignatureOf(ft, true)
+			info.NumParams, info.Variadic = len(sig.in), sig.variadic
+			if sig.variadic {
+				info.NumParams--
+			}
+			params, results = goSignatureString(ft, sig)
+		}
+	}
+	if info.Signature == "" {
+		info.Signature = name + "(" + params + ")" + results
+	}
+	mb.members = append(mb.members, moduleMember{name: name, fn: lf, info: info})
+	return mb
+}
+
+// Const adds the constant name. value is converted with `LState.FromGoValue` when the module is loaded.
+func (mb *ModuleBuilder) Const(name string, value interface{}) *ModuleBuilder {
+	mb.members = append(mb.members, moduleMember{name: name, value: value})
+	return mb
+}
+
+// SubModule adds sub as the field of the module named after sub. Its full name is the name of the module, a dot
+// and the name of sub.
+func (mb *ModuleBuilder) SubModule(sub *ModuleBuilder) *ModuleBuilder {
+	mb.subs = append(mb.subs, sub)
+	return mb
+}
+
+// Loader returns the loader of the module, e.g. for `LState.PreloadModule`. The loader also registers the
+// submodules in package.loaded under their full names, and records the documentation of the module in the state.
+func (mb *ModuleBuilder) Loader() LGFunction {
+	return func(L *LState) int {
+		L.Push(mb.build(L, mb.name))
+		return 1
+	}
+}
+
+// Preload sets the loader of the module to package.preload, and records the documentation of the module in L
+// before the module is loaded.
+func (mb *ModuleBuilder) Preload(L *LState) {
+	L.PreloadModule(mb.name, mb.Loader())
+	mb.register(L, mb.name)
+}
+
+// register records the documentation of the module named fullName and of its submodules in L.
+func (mb *ModuleBuilder) register(L *LState, fullName string) {
+	mb.registerInfo(L, fullName)
+	for _, sub := range mb.subs {
+		sub.register(L, fullName+"."+sub.name)
+	}
+}
+
+// registerInfo records the documentation of the module named fullName in L and returns it.
+func (mb *ModuleBuilder) registerInfo(L *LState, fullName string) *ModuleInfo {
+	if L.G.modules == nil {
+		L.G.modules = make(map[string]*ModuleInfo)
+	}
+	mi := &ModuleInfo{Name: fullName, Doc: mb.doc}
+	for i := range mb.members {
+		m := &mb.members[i]
+		if m.fn == nil {
+			mi.Consts = append(mi.Consts, m.name)
+			continue
+		}
+		info := m.info
+		info.Name = fullName + "." + m.name
+		mi.Funcs = append(mi.Funcs, &info)
+	}
+	for _, sub := range mb.subs {
+		mi.SubModules = append(mi.SubModules, fullName+"."+sub.name)
+	}
+	L.G.modules[fullName] = mi
+	return mi
+}
+
+// build creates the table of the module named fullName.
+func (mb *ModuleBuilder) build(L *LState, fullName string) *LTable {
+	mi := mb.registerInfo(L, fullName)
+	if L.G.funcInfos == nil {
+		L.G.funcInfos = make(map[*LFunction]*FuncInfo)
+	}
+	mod := L.CreateTable(0, len(mb.members)+len(mb.subs))
+	funcs := mi.Funcs
+	for _, m := range mb.members {
+		if m.fn == nil {
+			mod.RawSetString(m.name, L.FromGoValue(m.value))
+			continue
+		}
+		fn := L.NewFunction(m.fn)
+		fn.name = m.name
+		L.G.funcInfos[fn] = funcs[0]
+		funcs = funcs[1:]
+		mod.RawSetString(m.name, fn)
+	}
+	loaded := L.FindTable(L.Get(RegistryIndex).(*LTable), "_LOADED", 1)
+	for _, sub := range mb.subs {
+		subName := fullName + "." + sub.name
+		tb := sub.build(L, subName)
+		mod.RawSetString(sub.name, tb)
+		L.SetField(loaded, subName, tb)
+	}
+	return mod
+}
+
+// goSignatureString returns the parameters and results of the function type ft with the names of the Lua types
+// that are converted to its Go types.
+func goSignatureString(ft reflect.Type, sig *goSignature) (string, string) {
+	params := make([]string, len(sig.in))
+	for i, t := range sig.in {
+		if sig.variadic && i == len(sig.in)-1 {
+			params[i] = goLuaTypeName(t.Elem()) + "..."
+		} else {
+			params[i] = goLuaTypeName(t)
+		}
+	}
+	var results []string
+	for i := 0; i < ft.NumOut(); i++ {
+		if sig.err && i == ft.NumOut()-1 {
+			break
+		}
+		results = append(results, goLuaTypeName(ft.Out(i)))
+	}
+	var ret string
+	switch len(results) {
+	case 0:
+	case 1:
+		ret = " " + results[0]
+	default:
+		ret = " (" + strings.Join(results, ", ") + ")"
+	}
+	return strings.Join(params, ", "), ret
+}
+
+// goLuaTypeName returns the name of the Lua type values of the Go type t are converted from and to.
+func goLuaTypeName(t reflect.Type) string {
+	switch {
+	case t == lvalueType || t.Kind() == reflect.Interface && t.NumMethod() == 0:
+		return "any"
+	case t.Implements(lvalueType):
+		switch t {
+		case reflect.TypeOf(LString("")):
+			return LTString.String()
+		case reflect.TypeOf(LNumber(0)):
+			return LTNumber.String()
+		case reflect.TypeOf(LBool(false)):
+			return LTBool.String()
+		case reflect.TypeOf((*LTable)(nil)):
+			return LTTable.String()
+		case reflect.TypeOf((*LFunction)(nil)):
+			return LTFunction.String()
+		case reflect.TypeOf((*LUserData)(nil)):
+			return LTUserData.String()
+		}
+		return "any"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return LTBool.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return LTNumber.String()
+	case reflect.String:
+		return LTString.String()
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return LTString.String()
+		}
+		return LTTable.String()
+	case reflect.Map:
+		return LTTable.String()
+	}
+	return t.String()
+}
+
+// ModuleInfo returns the documentation of the module name defined with `Module`, or nil if no such module was
+// preloaded or loaded.
+func (ls *LState) ModuleInfo(name string) *ModuleInfo {
+	return ls.G.modules[name]
+}
+
+// ModuleNames returns the sorted full names of the modules and submodules defined with `Module` that were
+// preloaded or loaded.
+func (ls *LState) ModuleNames() []string {
+	names := make([]string, 0, len(ls.G.modules))
+	for name := range ls.G.modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FuncInfo returns the documentation of fn if it is a function of a module defined with `Module`, or nil.
+func (ls *LState) FuncInfo(fn LValue) *FuncInfo {
+	if lf, ok := fn.(*LFunction); ok {
+		return ls.G.funcInfos[lf]
+	}
+	return nil
+}
+
+// Completions returns the sorted full names of the modules, functions and constants defined with `Module` that
+// start with prefix, e.g. for completion in a REPL.
+func (ls *LState) Completions(prefix string) []string {
+	var names []string
+	add := func(name string) {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	for name, mi := range ls.G.modules {
+		add(name)
+		for _, fi := range mi.Funcs {
+			add(fi.Name)
+		}
+		for _, c := range mi.Consts {
+			add(name + "." + c)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+/* }}} */
@@ -0,0 +1,74 @@
+package lua
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testModule() *ModuleBuilder {
+	return Module("mylib").
+		Doc("Utilities.").
+		Func("join", strings.Join, "join(list, sep) string\nJoins the strings of list with sep.").
+		Func("sum", func(ns ...float64) (s float64, err error) {
+			for _, n := range ns {
+				s += n
+			}
+			return
+		}, "Adds numbers.").
+		Func("top", func(L *LState) int {
+			L.Push(LNumber(L.GetTop()))
+			return 1
+		}, "").
+		Const("version", "1.0").
+		Const("limits", map[string]int{"max": 10}).
+		SubModule(Module("text").Func("upper", strings.ToUpper, "Returns s in upper case."))
+}
+
+func TestModuleBuilder(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	testModule().Preload(L)
+	errorIfScriptFail(t, L, `
+	local mylib = require("mylib")
+	assert(mylib.join({"a", "b"}, ",") == "a,b")
+	assert(mylib.sum(1, 2) == 3)
+	assert(mylib.top(1, 2, 3) == 3)
+	assert(mylib.version == "1.0" and mylib.limits.max == 10)
+	assert(mylib.text.upper("a") == "A")
+	assert(require("mylib.text") == mylib.text)
+	`)
+
+	errorIfNotEqual(t, "mylib,mylib.text", strings.Join(L.ModuleNames(), ","))
+	mi := L.ModuleInfo("mylib")
+	errorIfNotEqual(t, "Utilities.", mi.Doc)
+	errorIfFalse(t, reflect.DeepEqual([]string{"version", "limits"}, mi.Consts), "wrong constants %v", mi.Consts)
+	errorIfFalse(t, reflect.DeepEqual([]string{"mylib.text"}, mi.SubModules), "wrong submodules %v", mi.SubModules)
+	errorIfNotEqual(t, 3, len(mi.Funcs))
+	errorIfFalse(t, reflect.DeepEqual(FuncInfo{Name: "mylib.join", Signature: "join(list, sep) string",
+		Doc: "Joins the strings of list with sep.", NumParams: 2}, *mi.Funcs[0]), "wrong info %+v", *mi.Funcs[0])
+	errorIfFalse(t, reflect.DeepEqual(FuncInfo{Name: "mylib.sum", Signature: "sum(number...) number",
+		Doc: "Adds numbers.", NumParams: 0, Variadic: true}, *mi.Funcs[1]), "wrong info %+v", *mi.Funcs[1])
+	errorIfFalse(t, reflect.DeepEqual(FuncInfo{Name: "mylib.top", Signature: "top(...)", NumParams: -1,
+		Variadic: true}, *mi.Funcs[2]), "wrong info %+v", *mi.Funcs[2])
+	errorIfNil(t, L.ModuleInfo("mylib.text"))
+	errorIfFalse(t, L.ModuleInfo("other") == nil, "unknown module must have no info")
+
+	errorIfScriptFail(t, L, `upper = require("mylib.text").upper`)
+	fi := L.FuncInfo(L.GetGlobal("upper"))
+	errorIfNotEqual(t, "mylib.text.upper", fi.Name)
+	errorIfNotEqual(t, "upper(string) string", fi.Signature)
+	errorIfFalse(t, L.FuncInfo(L.GetGlobal("print")) == nil, "print must have no info")
+
+	errorIfNotEqual(t, "mylib.text,mylib.text.upper,mylib.top", strings.Join(L.Completions("mylib.t"), ","))
+	errorIfScriptNotFail(t, L, `local ok, err = pcall(require("mylib").sum, "x"); error(err)`, "bad argument #1 to sum")
+}
+
+func TestModuleLoader(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.PreloadModule("mylib", testModule().Loader())
+	errorIfFalse(t, L.ModuleInfo("mylib") == nil, "module must have no info before it is loaded")
+	errorIfScriptFail(t, L, `assert(require("mylib").text.upper("a") == "A")`)
+	errorIfNotEqual(t, "mylib,mylib.text", strings.Join(L.ModuleNames(), ","))
+}
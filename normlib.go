@@ -0,0 +1,57 @@
+//go:build norm
+
+package lua
+
+import (
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+/* norm library {{{ */
+
+// The norm library is only built with the "norm" build tag, since it depends on golang.org/x/text.
+func init() {
+	luaLibs = append(luaLibs, luaLib{NormLibName, OpenNorm})
+}
+
+// OpenNorm opens the norm library for the Unicode normalization forms and case folding of golang.org/x/text, so
+// that scripts normalize strings exactly like Go code using that package does:
+//
+//	norm.nfc("e\u{301}") == "\u{e9}"
+//	norm.casefold("Straße") == norm.casefold("STRASSE")
+func OpenNorm(L *LState) int {
+	mod := L.RegisterModule(NormLibName, normFuncs)
+	L.Push(mod)
+	return 1
+}
+
+var normFuncs = map[string]LGFunction{
+	"nfc":      normForm(norm.NFC),
+	"nfd":      normForm(norm.NFD),
+	"nfkc":     normForm(norm.NFKC),
+	"nfkd":     normForm(norm.NFKD),
+	"isnfc":    normIsForm(norm.NFC),
+	"isnfd":    normIsForm(norm.NFD),
+	"casefold": normCasefold,
+}
+
+func normForm(form norm.Form) LGFunction {
+	return func(L *LState) int {
+		L.Push(LString(form.String(L.CheckString(1))))
+		return 1
+	}
+}
+
+func normIsForm(form norm.Form) LGFunction {
+	return func(L *LState) int {
+		L.Push(LBool(form.IsNormalString(L.CheckString(1))))
+		return 1
+	}
+}
+
+func normCasefold(L *LState) int {
+	L.Push(LString(cases.Fold().String(L.CheckString(1))))
+	return 1
+}
+
+/* }}} */
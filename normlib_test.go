@@ -0,0 +1,22 @@
+//go:build norm
+
+package lua
+
+import (
+	"testing"
+)
+
+func TestNormLib(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+local composed, decomposed = "\195\169", "e\204\129" -- é
+assert(norm.nfc(decomposed) == composed and norm.nfd(composed) == decomposed)
+assert(norm.nfc(composed) == composed and norm.nfd("abc") == "abc")
+assert(norm.isnfc(composed) and not norm.isnfc(decomposed) and norm.isnfd(decomposed))
+assert(norm.nfkc("\239\172\129") == "fi" and norm.nfkd("\194\178") == "2") -- the ligature fi, superscript two
+assert(norm.casefold("Straße") == norm.casefold("STRASSE") and norm.casefold("ABC") == "abc")
+assert(norm.casefold(norm.nfc("E\204\129")) == composed)
+`)
+	errorIfScriptNotFail(t, L, `norm.nfc({})`, "string expected")
+}
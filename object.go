@@ -0,0 +1,787 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/* objects {{{ */
+
+// Object is implemented by Go values that are exposed to Lua as values of type "object"(see `LState.NewObject`).
+// Objects do not have metatables; indexing, assigning fields, calling and the # operator are passed to the object
+// directly. Errors should be raised with `LState.RaiseError` and friends.
+//
+// Embed BaseObject to implement only some of the methods.
+type Object interface {
+	// Index returns the value of the field key, or LNil.
+	Index(L *LState, key string) LValue
+	// SetIndex assigns value to the field key.
+	SetIndex(L *LState, key string, value LValue)
+	// Call is called when the object is called like a function. It works like an LGFunction: the arguments are on
+	// the stack of L and the number of results pushed onto the stack is returned.
+	Call(L *LState) int
+	// Len returns the length of the object for the # operator.
+	Len(L *LState) int
+	// Iterate returns a function that returns the next key/value pair each time it is called, and LNil, LNil when
+	// there are no more pairs. It is used by pairs. Iterate returns nil if the object can not be iterated.
+	Iterate(L *LState) func() (LValue, LValue)
+}
+
+// ValueIndexer is implemented by objects(values of type LTObject and Objects) that accept keys other than strings,
+// e.g. array like objects that are indexed by numbers. Indexing with a string key always calls Index or SetIndex;
+// ValueIndexer is used for all other keys. Indexing an object that does not implement ValueIndexer with a key
+// that is not a string raises an error.
+type ValueIndexer interface {
+	// IndexValue returns the value for key, or LNil.
+	IndexValue(L *LState, key LValue) LValue
+	// SetIndexValue assigns value to key.
+	SetIndexValue(L *LState, key LValue, value LValue)
+}
+
+// Equaler is implemented by objects(values of type LTObject and Objects) that decide by themselves whether they are
+// equal to another value. It is consulted by the == and ~= operators when two values of type LTObject are not the
+// same value, but not by rawequal. This lets two objects wrapping the same Go entity compare equal.
+//
+// An object can also implement fmt.Stringer to provide the string used by tostring and the .. operator.
+type Equaler interface {
+	// Equals reports whether the object is equal to other, which is always of type LTObject.
+	Equals(L *LState, other LValue) bool
+}
+
+// BaseObject implements Object for objects that have no fields, can not be called and have no length. Embed it
+// into a type to implement only the methods that are needed.
+type BaseObject struct{}
+
+// Index implements Object.
+func (BaseObject) Index(L *LState, key string) LValue { return LNil }
+
+// SetIndex implements Object.
+func (BaseObject) SetIndex(L *LState, key string, value LValue) {
+	L.RaiseError("can not set field '%s' of an object", key)
+}
+
+// Call implements Object.
+func (BaseObject) Call(L *LState) int {
+	L.RaiseError("attempt to call an object value")
+	return 0
+}
+
+// Len implements Object.
+func (BaseObject) Len(L *LState) int {
+	L.RaiseError("attempt to get length of an object value")
+	return 0
+}
+
+// Iterate implements Object.
+func (BaseObject) Iterate(L *LState) func() (LValue, LValue) { return nil }
+
+// NewObject returns a Lua object for value. If value implements Object, it is used as is. Otherwise the object
+// exposes value through reflection:
+//
+//   - the exported fields of a struct or a pointer to a struct can be read and, for pointers, assigned. A field
+//     can be renamed with a `lua:"name"` tag, or hidden with `lua:"-"`.
+//   - the exported methods of value can be called with the colon syntax(obj:Method(...)). A last result of type
+//     error is raised as a Lua error if it is not nil.
+//   - the entries of a map can be read and assigned.
+//   - the elements of a slice or an array can be read and, for slices and pointers to arrays, assigned. Indices
+//     start at 1 like in Lua.
+//   - a function can be called.
+//   - the length of a map, slice, array, string or channel is returned by the # operator.
+//   - the fields of a struct, the elements of a slice or an array and the entries of a map can be iterated with
+//     pairs and next. Maps are iterated in key order.
+//   - objects for the same pointer, or for equal comparable values, are equal.
+//   - a value implementing fmt.Stringer is converted to a string by tostring and the .. operator.
+//
+// Go values read from an object are converted to Lua values: booleans, numbers and strings to their Lua
+// counterparts, nil pointers, maps, slices and interfaces to nil, LValues are left as is, and any other value is
+// wrapped into an object.
+func (ls *LState) NewObject(value interface{}) *LObject {
+	if obj, ok := value.(Object); ok {
+		return &LObject{Object: obj, Value: value}
+	}
+	return &LObject{Object: &reflectObject{value: reflect.ValueOf(value)}, Value: value}
+}
+
+// IndexValue implements ValueIndexer.
+func (obj *LObject) IndexValue(L *LState, key LValue) LValue {
+	if vi, ok := obj.Object.(ValueIndexer); ok {
+		return vi.IndexValue(L, key)
+	}
+	raiseObjectKeyError(L, key)
+	return LNil
+}
+
+// SetIndexValue implements ValueIndexer.
+func (obj *LObject) SetIndexValue(L *LState, key LValue, value LValue) {
+	if vi, ok := obj.Object.(ValueIndexer); ok {
+		vi.SetIndexValue(L, key, value)
+		return
+	}
+	raiseObjectKeyError(L, key)
+}
+
+func raiseObjectKeyError(L *LState, key LValue) {
+	L.RaiseError("attempt to index an object with a %s key", key.Type().String())
+}
+
+// indexObject returns obj[key] for a value of type LTObject.
+func (ls *LState) indexObject(obj LValue, key LValue) LValue {
+	if s, ok := key.(LString); ok {
+		return obj.Index(ls, string(s))
+	}
+	if vi, ok := obj.(ValueIndexer); ok {
+		return vi.IndexValue(ls, key)
+	}
+	raiseObjectKeyError(ls, key)
+	return LNil
+}
+
+// setIndexObject assigns obj[key] = value for a value of type LTObject.
+func (ls *LState) setIndexObject(obj LValue, key LValue, value LValue) {
+	if s, ok := key.(LString); ok {
+		obj.SetIndex(ls, string(s), value)
+		return
+	}
+	if vi, ok := obj.(ValueIndexer); ok {
+		vi.SetIndexValue(ls, key, value)
+		return
+	}
+	raiseObjectKeyError(ls, key)
+}
+
+// Equals implements Equaler.
+func (obj *LObject) Equals(L *LState, other LValue) bool {
+	if eq, ok := obj.Object.(Equaler); ok {
+		return eq.Equals(L, other)
+	}
+	return false
+}
+
+// stringer returns the fmt.Stringer of the object, if any.
+func (obj *LObject) stringer() (fmt.Stringer, bool) {
+	if s, ok := obj.Object.(fmt.Stringer); ok {
+		return s, true
+	}
+	s, ok := obj.Value.(fmt.Stringer)
+	return s, ok
+}
+
+// concatValue returns the string representation of objects that implement fmt.Stringer, so that they can be
+// concatenated like strings. Any other value is returned as is.
+func concatValue(v LValue) LValue {
+	if obj, ok := v.(*LObject); ok {
+		if s, ok := obj.stringer(); ok {
+			return LString(s.String())
+		}
+	}
+	return v
+}
+
+// Iterate implements Iterable.
+func (obj *LObject) Iterate(L *LState) func() (LValue, LValue) {
+	return obj.Object.Iterate(L)
+}
+
+// callFunction returns a Go function that calls the object.
+func (obj *LObject) callFunction(L *LState) *LFunction {
+	if obj.call == nil {
+		call := obj.Object.Call
+		obj.call = L.NewFunction(func(L *LState) int { return call(L) })
+	}
+	return obj.call
+}
+
+// reflectObject is the Object NewObject creates for Go values that do not implement Object.
+type reflectObject struct {
+	value   reflect.Value
+	methods map[string]*LFunction
+}
+
+// structValue returns the struct ro refers to, if any.
+func (ro *reflectObject) structValue() (reflect.Value, bool) {
+	rv := ro.value
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return reflect.Value{}, false
+		}
+		rv = rv.Elem()
+	}
+	return rv, rv.Kind() == reflect.Struct
+}
+
+// objectFieldName returns the name a struct field is exposed as, or false if it is not exposed.
+func objectFieldName(f reflect.StructField) (string, bool) {
+	if !f.IsExported() {
+		return "", false
+	}
+	if tag, ok := f.Tag.Lookup("lua"); ok {
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			return "", false
+		}
+		if name != "" {
+			return name, true
+		}
+	}
+	return f.Name, true
+}
+
+// objectField returns the field of the struct rv that is exposed as key.
+func objectField(rv reflect.Value, key string) (reflect.Value, bool) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		if name, ok := objectFieldName(rt.Field(i)); ok && name == key {
+			return rv.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func (ro *reflectObject) Index(L *LState, key string) LValue {
+	if !ro.value.IsValid() {
+		// the object of a nil interface has no fields and no methods
+		return LNil
+	}
+	if sv, ok := ro.structValue(); ok {
+		if fv, ok := objectField(sv, key); ok {
+			return goValueToLValue(L, fv)
+		}
+	}
+	if ro.value.Kind() == reflect.Map && ro.value.Type().Key().Kind() == reflect.String {
+		v := ro.value.MapIndex(reflect.ValueOf(key).Convert(ro.value.Type().Key()))
+		if !v.IsValid() {
+			return LNil
+		}
+		return goValueToLValue(L, v)
+	}
+	if fn, ok := ro.methods[key]; ok {
+		return fn
+	}
+	if m := ro.value.MethodByName(key); m.IsValid() {
+		fn := L.NewFunction(func(L *LState) int {
+			// skip the object passed by the colon syntax
+			if obj, ok := L.Get(1).(*LObject); ok && obj.Object == ro {
+				return callGoFunction(L, m, goSignatureOf(m.Type(), false), 2)
+			}
+			return callGoFunction(L, m, goSignatureOf(m.Type(), false), 1)
+		})
+		if ro.methods == nil {
+			ro.methods = make(map[string]*LFunction)
+		}
+		ro.methods[key] = fn
+		return fn
+	}
+	return LNil
+}
+
+func (ro *reflectObject) SetIndex(L *LState, key string, value LValue) {
+	if sv, ok := ro.structValue(); ok {
+		if fv, ok := objectField(sv, key); ok {
+			if !fv.CanSet() {
+				L.RaiseError("can not set field '%s' of an object", key)
+			}
+			gv, err := lvalueToGoValue(L, value, fv.Type())
+			if err != nil {
+				L.RaiseError("can not set field '%s' of an object: %s", key, err.Error())
+			}
+			fv.Set(gv)
+			return
+		}
+	}
+	if ro.value.Kind() == reflect.Map && ro.value.Type().Key().Kind() == reflect.String {
+		ro.setMapIndex(L, reflect.ValueOf(key).Convert(ro.value.Type().Key()), key, value)
+		return
+	}
+	L.RaiseError("object has no field '%s'", key)
+}
+
+// elements returns the slice or array ro refers to, if any.
+func (ro *reflectObject) elements() (reflect.Value, bool) {
+	rv := ro.value
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Kind() == reflect.Array {
+		rv = rv.Elem()
+	}
+	return rv, rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array
+}
+
+// elementIndex returns the Go index of the element key, or -1 if key is not the index of an element of ev.
+func elementIndex(ev reflect.Value, key LValue) int {
+	n, ok := key.(LNumber)
+	if !ok || n != LNumber(int(n)) || n < 1 || int(n) > ev.Len() {
+		return -1
+	}
+	return int(n) - 1
+}
+
+func (ro *reflectObject) IndexValue(L *LState, key LValue) LValue {
+	if ev, ok := ro.elements(); ok {
+		if i := elementIndex(ev, key); i >= 0 {
+			return goValueToLValue(L, ev.Index(i))
+		}
+		return LNil
+	}
+	if ro.value.Kind() == reflect.Map {
+		mk, err := lvalueToGoValue(L, key, ro.value.Type().Key())
+		if err != nil {
+			return LNil
+		}
+		v := ro.value.MapIndex(mk)
+		if !v.IsValid() {
+			return LNil
+		}
+		return goValueToLValue(L, v)
+	}
+	raiseObjectKeyError(L, key)
+	return LNil
+}
+
+func (ro *reflectObject) SetIndexValue(L *LState, key LValue, value LValue) {
+	if ev, ok := ro.elements(); ok {
+		i := elementIndex(ev, key)
+		if i < 0 {
+			L.RaiseError("index %s is out of range(length is %d)", key.String(), ev.Len())
+		}
+		if !ev.Index(i).CanSet() {
+			L.RaiseError("can not set elements of an array value")
+		}
+		gv, err := lvalueToGoValue(L, value, ev.Type().Elem())
+		if err != nil {
+			L.RaiseError("can not set element %s of an object: %s", key.String(), err.Error())
+		}
+		ev.Index(i).Set(gv)
+		return
+	}
+	if ro.value.Kind() == reflect.Map {
+		mk, err := lvalueToGoValue(L, key, ro.value.Type().Key())
+		if err != nil {
+			L.RaiseError("invalid key for an object: %s", err.Error())
+		}
+		ro.setMapIndex(L, mk, key.String(), value)
+		return
+	}
+	raiseObjectKeyError(L, key)
+}
+
+func (ro *reflectObject) setMapIndex(L *LState, mk reflect.Value, key string, value LValue) {
+	if value == LNil {
+		ro.value.SetMapIndex(mk, reflect.Value{})
+		return
+	}
+	gv, err := lvalueToGoValue(L, value, ro.value.Type().Elem())
+	if err != nil {
+		L.RaiseError("can not set field '%s' of an object: %s", key, err.Error())
+	}
+	ro.value.SetMapIndex(mk, gv)
+}
+
+func (ro *reflectObject) Call(L *LState) int {
+	if ro.value.Kind() != reflect.Func || ro.value.IsNil() {
+		L.RaiseError("attempt to call an object value")
+	}
+	return callGoFunction(L, ro.value, goSignatureOf(ro.value.Type(), false), 1)
+}
+
+func (ro *reflectObject) Len(L *LState) int {
+	switch ro.value.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.String, reflect.Chan:
+		return ro.value.Len()
+	case reflect.Ptr:
+		if ev := ro.value.Elem(); ev.Kind() == reflect.Array {
+			return ev.Len()
+		}
+	}
+	L.RaiseError("attempt to get length of an object value")
+	return 0
+}
+
+func (ro *reflectObject) Iterate(L *LState) func() (LValue, LValue) {
+	if sv, ok := ro.structValue(); ok {
+		rt := sv.Type()
+		i := 0
+		return func() (LValue, LValue) {
+			for ; i < rt.NumField(); i++ {
+				if name, ok := objectFieldName(rt.Field(i)); ok {
+					i++
+					return LString(name), goValueToLValue(L, sv.Field(i-1))
+				}
+			}
+			return LNil, LNil
+		}
+	}
+	if ev, ok := ro.elements(); ok {
+		i := 0
+		return func() (LValue, LValue) {
+			if i >= ev.Len() {
+				return LNil, LNil
+			}
+			i++
+			return LNumber(i), goValueToLValue(L, ev.Index(i-1))
+		}
+	}
+	if ro.value.Kind() == reflect.Map {
+		// iterate in key order, so that next visits the keys in the same order every time
+		keys := ro.value.MapKeys()
+		lkeys := make([]LValue, len(keys))
+		for i, k := range keys {
+			lkeys[i] = goValueToLValue(L, k)
+		}
+		sort.Sort(keysByOrder{keys, lkeys})
+		i := 0
+		return func() (LValue, LValue) {
+			for ; i < len(keys); i++ {
+				// skip keys deleted while iterating
+				if v := ro.value.MapIndex(keys[i]); v.IsValid() {
+					i++
+					return lkeys[i-1], goValueToLValue(L, v)
+				}
+			}
+			return LNil, LNil
+		}
+	}
+	return nil
+}
+
+// Equals implements Equaler. Objects are equal if they refer to the same pointer or to equal comparable values.
+func (ro *reflectObject) Equals(L *LState, other LValue) bool {
+	obj, ok := other.(*LObject)
+	if !ok {
+		return false
+	}
+	oro, ok := obj.Object.(*reflectObject)
+	if !ok || !ro.value.IsValid() || !oro.value.IsValid() || ro.value.Type() != oro.value.Type() {
+		return false
+	}
+	switch ro.value.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Chan, reflect.UnsafePointer:
+		return ro.value.Pointer() == oro.value.Pointer()
+	case reflect.Slice:
+		// slices are equal if they share the same backing array and have the same length
+		return ro.value.Pointer() == oro.value.Pointer() && ro.value.Len() == oro.value.Len()
+	case reflect.Func:
+		return false
+	}
+	return ro.value.Comparable() && oro.value.Comparable() && ro.value.Equal(oro.value)
+}
+
+// keysByOrder sorts the keys of a map: numbers in numerical order first, then strings, then all other keys by
+// their string representation.
+type keysByOrder struct {
+	keys  []reflect.Value
+	lkeys []LValue
+}
+
+func (ko keysByOrder) Len() int { return len(ko.keys) }
+
+func (ko keysByOrder) Swap(i, j int) {
+	ko.keys[i], ko.keys[j] = ko.keys[j], ko.keys[i]
+	ko.lkeys[i], ko.lkeys[j] = ko.lkeys[j], ko.lkeys[i]
+}
+
+func (ko keysByOrder) Less(i, j int) bool {
+	a, b := ko.lkeys[i], ko.lkeys[j]
+	if ra, rb := keyRank(a), keyRank(b); ra != rb {
+		return ra < rb
+	}
+	switch av := a.(type) {
+	case LNumber:
+		return av < b.(LNumber)
+	case LString:
+		return av < b.(LString)
+	}
+	return a.String() < b.String()
+}
+
+func keyRank(v LValue) int {
+	switch v.Type() {
+	case LTNumber:
+		return 0
+	case LTString:
+		return 1
+	}
+	return 2
+}
+
+// Iterable is implemented by values of type LTObject that can be iterated with pairs and next. LObject implements
+// it with Object.Iterate.
+type Iterable interface {
+	// Iterate returns a function that returns the next key/value pair each time it is called, and LNil, LNil when
+	// there are no more pairs, or nil if the value can not be iterated.
+	Iterate(L *LState) func() (LValue, LValue)
+}
+
+// objectIteration is the iteration continued by next if it is called with the last key returned for an object.
+type objectIteration struct {
+	obj  LValue
+	key  LValue
+	iter func() (LValue, LValue)
+}
+
+// iterateObject returns an iterator over obj, or raises an error if obj can not be iterated.
+func (ls *LState) iterateObject(obj LValue, argn int) func() (LValue, LValue) {
+	var iter func() (LValue, LValue)
+	if it, ok := obj.(Iterable); ok {
+		iter = it.Iterate(ls)
+	}
+	if iter == nil {
+		ls.ArgError(argn, "object can not be iterated")
+	}
+	return iter
+}
+
+// nextObject returns the pair following key in the iteration order of obj. Iterating with next is linear as long
+// as each call passes the key returned by the previous call for the same object. Otherwise the iteration is
+// restarted and the pairs are skipped until key is found.
+func (ls *LState) nextObject(obj LValue, key LValue) (LValue, LValue) {
+	it := &ls.G.objectIteration
+	if key == LNil || it.obj != obj || it.key != key {
+		iter := ls.iterateObject(obj, 1)
+		if key != LNil {
+			for {
+				k, _ := iter()
+				if k == LNil {
+					ls.RaiseError("invalid key to 'next'")
+				}
+				if k == key {
+					break
+				}
+			}
+		}
+		it.obj, it.iter = obj, iter
+	}
+	k, v := it.iter()
+	if k == LNil {
+		*it = objectIteration{}
+		return LNil, LNil
+	}
+	it.key = k
+	return k, v
+}
+
+// callGoFunction calls the Go function fn with the arguments from the stack position start on, and pushes its
+// results. sig is the signature of fn.
+func callGoFunction(L *LState, fn reflect.Value, sig *goSignature, start int) int {
+	nargs := L.GetTop() - start + 1
+	if nargs < 0 {
+		nargs = 0
+	}
+	nin := len(sig.in)
+	if sig.variadic {
+		nin--
+		if nargs < nin {
+			L.RaiseError("bad number of arguments(expected at least %d, got %d)", nin, nargs)
+		}
+	} else if nargs != nin {
+		L.RaiseError("bad number of arguments(expected %d, got %d)", nin, nargs)
+	}
+	args := make([]reflect.Value, 0, nargs+1)
+	if sig.state {
+		args = append(args, reflect.ValueOf(L))
+	}
+	for i := 0; i < nargs; i++ {
+		var t reflect.Type
+		if i < nin {
+			t = sig.in[i]
+		} else {
+			t = sig.in[nin].Elem()
+		}
+		arg, err := lvalueToGoValue(L, L.Get(start+i), t)
+		if err != nil {
+			L.ArgError(start+i, err.Error())
+		}
+		args = append(args, arg)
+	}
+	results := fn.Call(args)
+	if sig.err {
+		n := len(results)
+		if err := results[n-1]; !err.IsNil() {
+			L.RaiseError("%s", err.Interface().(error).Error())
+		}
+		results = results[:n-1]
+	}
+	for _, result := range results {
+		L.Push(goValueToLValue(L, result))
+	}
+	return len(results)
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+var lvalueType = reflect.TypeOf((*LValue)(nil)).Elem()
+
+// goValueToLValue converts a Go value read from an object to an LValue.
+func goValueToLValue(L *LState, rv reflect.Value) LValue {
+	if !rv.IsValid() {
+		return LNil
+	}
+	if rv.Type().Implements(lvalueType) {
+		if rv.Kind() == reflect.Interface && rv.IsNil() {
+			return LNil
+		}
+		return rv.Interface().(LValue)
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		return LBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return LNumber(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return LNumber(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return LNumber(rv.Float())
+	case reflect.String:
+		return LString(rv.String())
+	case reflect.Interface:
+		if rv.IsNil() {
+			return LNil
+		}
+		return goValueToLValue(L, rv.Elem())
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		if rv.IsNil() {
+			return LNil
+		}
+	}
+	if !rv.CanInterface() {
+		return LNil
+	}
+	if rv.Kind() == reflect.Struct && rv.CanAddr() {
+		// refer to the struct rather than to a copy, so that its fields can be assigned
+		rv = rv.Addr()
+	}
+	return L.NewObject(rv.Interface())
+}
+
+// lvalueToGoValue converts an LValue assigned to or passed into an object or a Go function to a Go value of type
+// t. Tables are converted to slices and maps, the values of userdata to their Go types.
+func lvalueToGoValue(L *LState, lv LValue, t reflect.Type) (reflect.Value, error) {
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		var v interface{}
+		switch cv := lv.(type) {
+		case *LNilType:
+		case LBool:
+			v = bool(cv)
+		case LNumber:
+			v = float64(cv)
+		case LString:
+			v = string(cv)
+		case *LObject:
+			v = cv.Value
+		default:
+			v = lv
+		}
+		return reflect.ValueOf(&v).Elem(), nil
+	}
+	if obj, ok := lv.(*LObject); ok && obj.Value != nil {
+		if gv := reflect.ValueOf(obj.Value); gv.Type().AssignableTo(t) {
+			return gv, nil
+		}
+	}
+	if reflect.TypeOf(lv).AssignableTo(t) {
+		return reflect.ValueOf(lv), nil
+	}
+	if ud, ok := lv.(*LUserData); ok && ud.Value != nil {
+		if gv := reflect.ValueOf(ud.Value); gv.Type().AssignableTo(t) {
+			return gv, nil
+		}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, ok := lv.(LBool); ok {
+			return reflect.ValueOf(bool(b)).Convert(t), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n, ok := lv.(LNumber); ok {
+			return numberToGoInteger(n, t)
+		}
+	case reflect.Float32, reflect.Float64:
+		if n, ok := lv.(LNumber); ok {
+			return reflect.ValueOf(float64(n)).Convert(t), nil
+		}
+	case reflect.String:
+		if s, ok := lv.(LString); ok {
+			return reflect.ValueOf(string(s)).Convert(t), nil
+		}
+	case reflect.Slice:
+		switch v := lv.(type) {
+		case LString:
+			if t.Elem().Kind() == reflect.Uint8 {
+				return reflect.ValueOf([]byte(v)).Convert(t), nil
+			}
+		case *LTable:
+			return tableToGoSlice(L, v, t)
+		}
+	case reflect.Map:
+		if tb, ok := lv.(*LTable); ok {
+			return tableToGoMap(L, tb, t)
+		}
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		if lv == LNil {
+			return reflect.Zero(t), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("%s expected, got %s", t.String(), lv.Type().String())
+}
+
+// numberToGoInteger converts n to a value of the integer type t, failing if n is not an integer or out of the
+// range of t rather than truncating or wrapping it.
+func numberToGoInteger(n LNumber, t reflect.Type) (reflect.Value, error) {
+	f := float64(n)
+	if f != math.Trunc(f) {
+		return reflect.Value{}, fmt.Errorf("%s expected, got %s", t.String(), n.String())
+	}
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if f >= -(1<<63) && f < 1<<63 && !v.OverflowInt(int64(f)) {
+			v.SetInt(int64(f))
+			return v, nil
+		}
+	default:
+		if f >= 0 && f < 1<<64 && !v.OverflowUint(uint64(f)) {
+			v.SetUint(uint64(f))
+			return v, nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("%s is out of range for %s", n.String(), t.String())
+}
+
+// tableToGoSlice converts the elements 1..#tb of tb to a slice of type t.
+func tableToGoSlice(L *LState, tb *LTable, t reflect.Type) (reflect.Value, error) {
+	n := tb.Len()
+	slice := reflect.MakeSlice(t, n, n)
+	for i := 0; i < n; i++ {
+		ev, err := lvalueToGoValue(L, tb.RawGetInt(i+1), t.Elem())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("element %d: %w", i+1, err)
+		}
+		slice.Index(i).Set(ev)
+	}
+	return slice, nil
+}
+
+// tableToGoMap converts the keys and values of tb to a map of type t.
+func tableToGoMap(L *LState, tb *LTable, t reflect.Type) (reflect.Value, error) {
+	m := reflect.MakeMapWithSize(t, 0)
+	err := tb.ForEachE(func(key, value LValue) error {
+		kv, err := lvalueToGoValue(L, key, t.Key())
+		if err != nil {
+			return fmt.Errorf("key %s: %w", key.String(), err)
+		}
+		vv, err := lvalueToGoValue(L, value, t.Elem())
+		if err != nil {
+			return fmt.Errorf("value of %s: %w", key.String(), err)
+		}
+		m.SetMapIndex(kv, vv)
+		return nil
+	})
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return m, nil
+}
+
+/* }}} */
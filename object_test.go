@@ -0,0 +1,312 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCounter struct {
+	BaseObject
+	n int
+}
+
+func (c *testCounter) Index(L *LState, key string) LValue {
+	if key == "n" {
+		return LNumber(c.n)
+	}
+	return LNil
+}
+
+func (c *testCounter) SetIndex(L *LState, key string, value LValue) {
+	if key != "n" {
+		L.RaiseError("counter has no field '%s'", key)
+	}
+	n, ok := value.(LNumber)
+	if !ok {
+		L.RaiseError("counter.n must be a number")
+	}
+	c.n = int(n)
+}
+
+func (c *testCounter) Call(L *LState) int {
+	c.n += L.OptInt(1, 1)
+	L.Push(LNumber(c.n))
+	return 1
+}
+
+func (c *testCounter) Len(L *LState) int { return c.n }
+
+func (c *testCounter) Iterate(L *LState) func() (LValue, LValue) {
+	done := false
+	return func() (LValue, LValue) {
+		if done {
+			return LNil, LNil
+		}
+		done = true
+		return LString("n"), LNumber(c.n)
+	}
+}
+
+func TestObject(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	c := &testCounter{}
+	L.SetGlobal("counter", L.NewObject(c))
+	errorIfScriptFail(t, L, `
+assert(type(counter) == "object")
+assert(counter.n == 0 and counter.x == nil)
+assert(counter() == 1 and counter(10) == 11)
+counter.n = 3
+assert(counter.n == 3 and #counter == 3)
+assert(select(2, pcall(counter)) == 4)
+for k, v in pairs(counter) do
+  assert(k == "n" and v == 4)
+end
+`)
+	errorIfNotEqual(t, 4, c.n)
+	errorIfScriptNotFail(t, L, `counter.x = 1`, "counter has no field 'x'")
+	errorIfScriptNotFail(t, L, `local x = counter[1]`, "attempt to index an object with a number key")
+
+	L.SetGlobal("empty", L.NewObject(&struct{ BaseObject }{}))
+	errorIfScriptNotFail(t, L, `empty()`, "attempt to call an object value")
+	errorIfScriptNotFail(t, L, `local n = #empty`, "attempt to get length of an object value")
+	errorIfScriptNotFail(t, L, `pairs(empty)`, "object can not be iterated")
+}
+
+type testPoint struct {
+	X, Y   float64
+	Name   string `lua:"name"`
+	Secret string `lua:"-"`
+	Next   *testPoint
+	hidden int
+}
+
+func (p *testPoint) Move(dx, dy float64) *testPoint {
+	p.X += dx
+	p.Y += dy
+	return p
+}
+
+func (p *testPoint) Check() error {
+	if p.X < 0 {
+		return errors.New("negative x")
+	}
+	return nil
+}
+
+func TestReflectObject(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	p := &testPoint{X: 1, Y: 2, Name: "p", Secret: "s", Next: &testPoint{X: 5}, hidden: 1}
+	L.SetGlobal("p", L.NewObject(p))
+	L.SetGlobal("m", L.NewObject(map[string]int{"a": 1}))
+	L.SetGlobal("join", L.NewObject(func(sep string, parts ...string) string {
+		return strings.Join(parts, sep)
+	}))
+	L.SetGlobal("list", L.NewObject([]int{1, 2, 3}))
+	errorIfScriptFail(t, L, `
+assert(p.X == 1 and p.Y == 2 and p.name == "p")
+assert(p.Secret == nil and p.hidden == nil and p.Name == nil)
+p.X = 10
+assert(p:Move(1, 1).X == 11 and p.Y == 3)
+assert(p.Move(2, 0).X == 13)
+assert(p.Next.X == 5)
+p.Next.X = 6
+assert(p:Check() == nil)
+assert(m.a == 1 and m.b == nil and #m == 1)
+m.b = 2
+m.a = nil
+assert(m.b == 2 and m.a == nil)
+assert(join(",", "a", "b", "c") == "a,b,c")
+assert(#list == 3)
+`)
+	errorIfNotEqual(t, float64(13), p.X)
+	errorIfNotEqual(t, float64(6), p.Next.X)
+	errorIfScriptNotFail(t, L, `p.X = "x"`, "can not set field 'X' of an object: float64 expected, got string")
+	errorIfScriptNotFail(t, L, `list[1] = 1.5`, "can not set element 1 of an object: int expected, got 1.5")
+	errorIfScriptNotFail(t, L, `p.Z = 1`, "object has no field 'Z'")
+	errorIfScriptNotFail(t, L, `p.X = -1; p:Check()`, "negative x")
+	errorIfScriptNotFail(t, L, `join()`, "bad number of arguments")
+
+	L.SetGlobal("none", L.NewObject(nil))
+	errorIfScriptFail(t, L, `assert(none.x == nil and not pcall(function() none.x = 1 end) and not pcall(none))`)
+}
+
+// testConfig implements LValue directly as a value of type LTObject.
+type testConfig struct {
+	name string
+}
+
+func (c *testConfig) String() string                     { return "config" }
+func (c *testConfig) Type() LValueType                   { return LTObject }
+func (c *testConfig) AssertFunction() (*LFunction, bool) { return nil, false }
+func (c *testConfig) Index(L *LState, key string) LValue {
+	if key == "name" {
+		return LString(c.name)
+	}
+	return LNil
+}
+func (c *testConfig) SetIndex(L *LState, key string, value LValue) {
+	s, ok := value.(LString)
+	if key != "name" || !ok || len(s) == 0 {
+		L.RaiseError("invalid value for config.%s", key)
+	}
+	c.name = string(s)
+}
+
+func TestObjectSetIndex(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	c := &testConfig{name: "a"}
+	L.SetGlobal("config", c)
+	errorIfScriptFail(t, L, `
+assert(config.name == "a")
+config.name = "x"
+local k = "name"
+config[k] = config[k] .. "y"
+`)
+	errorIfNotEqual(t, "xy", c.name)
+	errorIfScriptNotFail(t, L, `config.name = ""`, "invalid value for config.name")
+	errorIfScriptNotFail(t, L, `config.other = "b"`, "invalid value for config.other")
+	errorIfGFuncNotFail(t, L, func(L *LState) int {
+		L.SetField(L.GetGlobal("config"), "name", LNumber(1))
+		return 0
+	}, "invalid value for config.name")
+	errorIfNotEqual(t, "xy", c.name)
+}
+
+// testRing is a fixed size ring buffer. Index 1 is the oldest element.
+type testRing struct {
+	BaseObject
+	items []LValue
+	start int
+}
+
+func (r *testRing) IndexValue(L *LState, key LValue) LValue {
+	n, ok := key.(LNumber)
+	if !ok || n < 1 || int(n) > len(r.items) {
+		return LNil
+	}
+	return r.items[(r.start+int(n)-1)%len(r.items)]
+}
+
+func (r *testRing) SetIndexValue(L *LState, key LValue, value LValue) {
+	L.RaiseError("ring elements are read only")
+}
+
+func (r *testRing) Index(L *LState, key string) LValue {
+	if key == "push" {
+		return L.NewFunction(func(L *LState) int {
+			r.items[r.start] = L.CheckAny(2)
+			r.start = (r.start + 1) % len(r.items)
+			return 0
+		})
+	}
+	return LNil
+}
+
+func (r *testRing) Len(L *LState) int { return len(r.items) }
+
+func TestObjectValueKeys(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("ring", L.NewObject(&testRing{items: []LValue{LNumber(1), LNumber(2), LNumber(3)}}))
+	slice := []string{"a", "b"}
+	L.SetGlobal("slice", L.NewObject(slice))
+	L.SetGlobal("array", L.NewObject(&[2]int{1, 2}))
+	m := map[int]string{1: "one"}
+	L.SetGlobal("m", L.NewObject(m))
+	errorIfScriptFail(t, L, `
+assert(#ring == 3 and ring[1] == 1 and ring[3] == 3 and ring[4] == nil and ring.x == nil)
+ring:push(4)
+assert(ring[1] == 2 and ring[3] == 4)
+local t = {}
+for i = 1, #ring do t[i] = ring[i] end
+assert(table.concat(t, ",") == "2,3,4")
+assert(#slice == 2 and slice[1] == "a" and slice[2] == "b" and slice[0] == nil and slice[3] == nil)
+slice[2] = "c"
+array[1] = 10
+assert(array[1] == 10 and #array == 2)
+assert(m[1] == "one" and m[2] == nil and m.x == nil)
+m[2] = "two"
+m[1] = nil
+`)
+	errorIfNotEqual(t, "c", slice[1])
+	errorIfNotEqual(t, "two", m[2])
+	errorIfNotEqual(t, 1, len(m))
+	errorIfScriptNotFail(t, L, `ring[1] = 0`, "ring elements are read only")
+	errorIfScriptNotFail(t, L, `slice[3] = "d"`, "index 3 is out of range\\(length is 2\\)")
+	errorIfScriptNotFail(t, L, `slice[1] = 1`, "string expected, got number")
+	errorIfScriptNotFail(t, L, `m.x = "x"`, "object has no field 'x'")
+}
+
+func TestObjectIterate(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("m", L.NewObject(map[string]int{"b": 2, "a": 1, "c": 3}))
+	L.SetGlobal("p", L.NewObject(&testPoint{X: 1, Y: 2, Name: "p"}))
+	L.SetGlobal("slice", L.NewObject([]string{"x", "y"}))
+	L.SetGlobal("counter", L.NewObject(&testCounter{n: 5}))
+	errorIfScriptFail(t, L, `
+local function collect(obj, iter)
+  local t = {}
+  for k, v in iter(obj) do t[#t+1] = tostring(k) .. "=" .. tostring(v) end
+  return table.concat(t, ",")
+end
+local function nextpairs(obj) return next, obj, nil end
+for _, iter in ipairs({pairs, nextpairs}) do
+  assert(collect(m, iter) == "a=1,b=2,c=3")
+  assert(collect(slice, iter) == "1=x,2=y")
+  assert(collect(counter, iter) == "n=5")
+end
+local keys = {}
+for k, v in pairs(p) do keys[k] = v end
+assert(keys.X == 1 and keys.Y == 2 and keys.name == "p" and keys.Secret == nil and keys.hidden == nil)
+assert(next(m) == "a" and next(m, "b") == "c" and next(m, "c") == nil)
+`)
+	errorIfScriptNotFail(t, L, `next(m, "d")`, "invalid key to 'next'")
+	L.SetGlobal("empty", L.NewObject(&struct{ BaseObject }{}))
+	errorIfScriptNotFail(t, L, `next(empty)`, "object can not be iterated")
+}
+
+type testHandle struct {
+	BaseObject
+	id int
+}
+
+func (h *testHandle) String() string { return fmt.Sprintf("handle #%d", h.id) }
+
+func (h *testHandle) Equals(L *LState, other LValue) bool {
+	if obj, ok := other.(*LObject); ok {
+		if oh, ok := obj.Object.(*testHandle); ok {
+			return oh.id == h.id
+		}
+	}
+	return false
+}
+
+func TestObjectEquals(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	p := &testPoint{X: 1, Next: &testPoint{}}
+	L.SetGlobal("h1", L.NewObject(&testHandle{id: 1}))
+	L.SetGlobal("h2", L.NewObject(&testHandle{id: 1}))
+	L.SetGlobal("h3", L.NewObject(&testHandle{id: 3}))
+	L.SetGlobal("p1", L.NewObject(p))
+	L.SetGlobal("p2", L.NewObject(p))
+	L.SetGlobal("q", L.NewObject(&testPoint{X: 1}))
+	L.SetGlobal("d", L.NewObject(time.Second))
+	errorIfScriptFail(t, L, `
+assert(h1 == h2 and h1 ~= h3 and not rawequal(h1, h2))
+assert(tostring(h1) == "handle #1" and "got " .. h3 .. "!" == "got handle #3!")
+assert(p1 == p2 and p1 ~= q and p1.Next == p2.Next)
+assert(tostring(d) == "1s" and d .. "" == "1s")
+assert(string.find(tostring(p1), "^object: 0x"))
+local t = {[h1] = true}
+assert(t[h2] == nil)
+`)
+	errorIfScriptNotFail(t, L, `local s = "x" .. p1`, "cannot perform concat operation between string and object")
+}
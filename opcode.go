@@ -53,7 +53,7 @@ const (
 	OP_SETTABLE   /*  A B C   R(A)[RK(B)] := RK(C)                            */
 	OP_SETTABLEKS /*  A B C   R(A)[RK(B)] := RK(C) ; RK(B) is constant string */
 
-	OP_NEWTABLE /*  A B C   R(A) := {} (size = BC)                         */
+	OP_NEWTABLE /*  A B C   R(A) := {} (size = fb2Int(B), fb2Int(C))        */
 
 	OP_SELF /*      A B C   R(A+1) := R(B); R(A) := R(B)[RK(C)]             */
 
@@ -95,6 +95,18 @@ const (
 
 	OP_VARARG /*     A B     R(A) R(A+1) ... R(A+B-1) = vararg            */
 
+	OP_TFORLOOPI /* A C     same as TFORLOOP; walks the array part directly when R(A) is ipairs' iterator */
+	OP_SELECTVA  /*  A C     R(A) ... R(A+C-2) := R(A)(R(A+1), ...); reads the varargs in place when R(A) is select */
+
+	/* superinstructions: same operands as the first instruction of the fused
+	   pair, the second instruction stays in place and is executed inline */
+	OP_EQJMP    /*    A B C   EQ followed by JMP                              */
+	OP_LTJMP    /*    A B C   LT followed by JMP                              */
+	OP_LEJMP    /*    A B C   LE followed by JMP                              */
+	OP_TESTJMP  /*  A C     TEST followed by JMP                            */
+	OP_SELFCALL /* A B C   SELF followed by CALL R(A) with no arguments     */
+	OP_RETURNK  /*  A Bx    LOADK followed by RETURN R(A)                   */
+
 	OP_NOP /* NOP */
 )
 const opCodeMax = OP_NOP
@@ -167,6 +179,14 @@ var opProps = []opProp{
 	opProp{"CLOSE", false, false, opArgModeN, opArgModeN, opTypeABC},
 	opProp{"CLOSURE", false, true, opArgModeU, opArgModeN, opTypeABx},
 	opProp{"VARARG", false, true, opArgModeU, opArgModeN, opTypeABC},
+	opProp{"TFORLOOPI", true, false, opArgModeN, opArgModeU, opTypeABC},
+	opProp{"SELECTVA", false, true, opArgModeN, opArgModeU, opTypeABC},
+	opProp{"EQJMP", true, false, opArgModeK, opArgModeK, opTypeABC},
+	opProp{"LTJMP", true, false, opArgModeK, opArgModeK, opTypeABC},
+	opProp{"LEJMP", true, false, opArgModeK, opArgModeK, opTypeABC},
+	opProp{"TESTJMP", true, true, opArgModeR, opArgModeU, opTypeABC},
+	opProp{"SELFCALL", false, true, opArgModeR, opArgModeK, opTypeABC},
+	opProp{"RETURNK", false, true, opArgModeK, opArgModeN, opTypeABx},
 	opProp{"NOP", false, false, opArgModeR, opArgModeN, opTypeASbx},
 }
 
@@ -309,7 +329,7 @@ func opToString(inst uint32) string {
 	case OP_SETTABLEKS:
 		buf += fmt.Sprintf("; R(%v)[RK(%v)] := RK(%v) ; RK(%v) is constant string", arga, argb, argc, argb)
 	case OP_NEWTABLE:
-		buf += fmt.Sprintf("; R(%v) := {} (size = BC)", arga)
+		buf += fmt.Sprintf("; R(%v) := {} (size = %v, %v)", arga, fb2Int(argb), fb2Int(argc))
 	case OP_SELF:
 		buf += fmt.Sprintf("; R(%v+1) := R(%v); R(%v) := R(%v)[RK(%v)]", arga, argb, arga, argb, argc)
 	case OP_ADD:
@@ -356,6 +376,10 @@ func opToString(inst uint32) string {
 		buf += fmt.Sprintf("; R(%v)-=R(%v+2); pc+=%v", arga, arga, argsbx)
 	case OP_TFORLOOP:
 		buf += fmt.Sprintf("; R(%v+3) ... R(%v+3+%v) := R(%v)(R(%v+1) R(%v+2)); if R(%v+3) ~= nil then { pc++; R(%v+2)=R(%v+3); }", arga, arga, argc, arga, arga, arga, arga, arga, arga)
+	case OP_TFORLOOPI:
+		buf += fmt.Sprintf("; R(%v+3) ... R(%v+3+%v) := R(%v)(R(%v+1) R(%v+2)); if R(%v+3) ~= nil then { pc++; R(%v+2)=R(%v+3); } ; ipairs fast path", arga, arga, argc, arga, arga, arga, arga, arga, arga)
+	case OP_SELECTVA:
+		buf += fmt.Sprintf("; R(%v) ... R(%v+%v-2) := R(%v)(R(%v+1), ...) ; select fast path", arga, arga, argc, arga, arga)
 	case OP_SETLIST:
 		buf += fmt.Sprintf("; R(%v)[(%v-1)*FPF+i] := R(%v+i) 1 <= i <= %v", arga, argc, arga, argb)
 	case OP_CLOSE:
@@ -364,8 +388,31 @@ func opToString(inst uint32) string {
 		buf += fmt.Sprintf("; R(%v) := closure(KPROTO[%v] R(%v) ... R(%v+n))", arga, argbx, arga, arga)
 	case OP_VARARG:
 		buf += fmt.Sprintf(";  R(%v) R(%v+1) ... R(%v+%v-1) = vararg", arga, arga, arga, argb)
+	case OP_EQJMP:
+		buf += fmt.Sprintf("; if ((RK(%v) == RK(%v)) ~= %v) then pc++ else JMP", argb, argc, arga)
+	case OP_LTJMP:
+		buf += fmt.Sprintf("; if ((RK(%v) <  RK(%v)) ~= %v) then pc++ else JMP", argb, argc, arga)
+	case OP_LEJMP:
+		buf += fmt.Sprintf("; if ((RK(%v) <= RK(%v)) ~= %v) then pc++ else JMP", argb, argc, arga)
+	case OP_TESTJMP:
+		buf += fmt.Sprintf("; if not (R(%v) <=> %v) then pc++ else JMP", arga, argc)
+	case OP_SELFCALL:
+		buf += fmt.Sprintf("; R(%v+1) := R(%v); R(%v) := R(%v)[RK(%v)]; CALL", arga, argb, arga, argb, argc)
+	case OP_RETURNK:
+		buf += fmt.Sprintf("; R(%v) := Kst(%v); RETURN", arga, argbx)
 	case OP_NOP:
 		/* nothing to do */
 	}
 	return buf
 }
+
+// superInstructions maps a fused opcode to the opcodes of the instruction
+// pair it replaces.
+var superInstructions = map[int][2]int{
+	OP_EQJMP:    {OP_EQ, OP_JMP},
+	OP_LTJMP:    {OP_LT, OP_JMP},
+	OP_LEJMP:    {OP_LE, OP_JMP},
+	OP_TESTJMP:  {OP_TEST, OP_JMP},
+	OP_SELFCALL: {OP_SELF, OP_CALL},
+	OP_RETURNK:  {OP_LOADK, OP_RETURN},
+}
@@ -0,0 +1,356 @@
+package lua
+
+import (
+	"math"
+)
+
+/* peephole optimizer {{{ */
+
+// maxJumpThreading limits how many jumps are followed when threading a jump
+// chain, so that jump cycles (e.g. `while true do end`) terminate.
+const maxJumpThreading = 32
+
+// Optimize runs a peephole optimization pass over the bytecode of proto and
+// all of its nested prototypes. The pass folds arithmetic and concatenation on
+// constants, threads jumps to jumps, removes dead stores and merges adjacent
+// LOADNIL instructions. Instructions that become no-ops are removed and jump
+// offsets and debug information are adjusted accordingly.
+//
+// Optimize is applied automatically by LState.Load when
+// Options.OptimizeBytecode is set. It must not be called on a prototype that
+// is being executed. Numbers in folded concatenations are converted to
+// strings without the suffix of Options.FloatSuffix.
+func Optimize(proto *FunctionProto) {
+	optimize(proto, false)
+}
+
+func optimize(proto *FunctionProto, floatSuffix bool) {
+	for _, p := range proto.FunctionPrototypes {
+		optimize(p, floatSuffix)
+	}
+	proto.unfuseInstructions()
+	opt := newProtoOptimizer(proto)
+	opt.floatSuffix = floatSuffix
+	opt.threadJumps()
+	opt.foldConstants()
+	opt.removeDeadStores()
+	opt.mergeLoadNils()
+	opt.removeNops()
+	proto.fuseInstructions()
+	proto.assignInlineCaches()
+}
+
+type protoOptimizer struct {
+	proto *FunctionProto
+	code  []uint32
+	// attached marks instructions that are operands of the previous
+	// instruction (MOVEN runs, CLOSURE upvalue pseudo instructions and the
+	// extra SETLIST word). They must be left untouched.
+	attached []bool
+	// target marks instructions that can be reached by a jump or a skip.
+	target []bool
+	// floatSuffix converts integral numbers like Options.FloatSuffix when
+	// concatenations are folded.
+	floatSuffix bool
+}
+
+func newProtoOptimizer(proto *FunctionProto) *protoOptimizer {
+	opt := &protoOptimizer{
+		proto:    proto,
+		code:     proto.Code,
+		attached: make([]bool, len(proto.Code)+1),
+		target:   make([]bool, len(proto.Code)+1),
+	}
+	opt.analyze()
+	return opt
+}
+
+func (opt *protoOptimizer) analyze() {
+	code := opt.code
+	for pc := 0; pc < len(code); pc++ {
+		inst := code[pc]
+		n := opt.proto.operandWords(pc)
+		switch opGetOpCode(inst) {
+		case OP_JMP, OP_FORLOOP, OP_FORPREP:
+			if t := pc + 1 + opGetArgSbx(inst); t >= 0 && t <= len(code) {
+				opt.target[t] = true
+			}
+		}
+		if isSkipInstruction(inst) && pc+2 <= len(code) {
+			opt.target[pc+2] = true
+		}
+		for i := 1; i <= n && pc+i < len(code); i++ {
+			opt.attached[pc+i] = true
+		}
+		pc += n
+	}
+}
+
+// isSkipInstruction reports whether inst may skip the instruction that
+// follows it.
+func isSkipInstruction(inst uint32) bool {
+	switch opGetOpCode(inst) {
+	case OP_EQ, OP_LT, OP_LE, OP_TEST, OP_TESTSET, OP_TFORLOOP, OP_TFORLOOPI:
+		return true
+	case OP_LOADBOOL:
+		return opGetArgC(inst) != 0
+	}
+	return false
+}
+
+// editable reports whether the instruction at pc may be rewritten or removed.
+func (opt *protoOptimizer) editable(pc int) bool {
+	if opt.attached[pc] {
+		return false
+	}
+	return pc == 0 || !isSkipInstruction(opt.code[pc-1])
+}
+
+func (opt *protoOptimizer) nop(pc int) {
+	opt.code[pc] = opCreateABC(OP_NOP, 0, 0, 0)
+}
+
+func (opt *protoOptimizer) threadJumps() {
+	code := opt.code
+	for pc, inst := range code {
+		if opt.attached[pc] || opGetOpCode(inst) != OP_JMP {
+			continue
+		}
+		t := pc + 1 + opGetArgSbx(inst)
+		for i := 0; i < maxJumpThreading && t < len(code); i++ {
+			next := code[t]
+			if opt.attached[t] {
+				break
+			}
+			if op := opGetOpCode(next); op == OP_NOP {
+				t++
+			} else if op == OP_JMP {
+				t = t + 1 + opGetArgSbx(next)
+			} else {
+				break
+			}
+		}
+		if sbx := t - pc - 1; sbx != opGetArgSbx(inst) {
+			opSetArgSbx(&code[pc], sbx)
+			opt.target[t] = true
+		}
+	}
+}
+
+func (opt *protoOptimizer) constant(rk int) (LValue, bool) {
+	if !opIsK(rk) {
+		return nil, false
+	}
+	return opt.proto.Constants[opIndexK(rk)], true
+}
+
+// constIndex returns the index of v in the constant table, adding it if
+// necessary. It returns -1 if the index does not fit into a Bx operand.
+func (opt *protoOptimizer) constIndex(v LValue) int {
+	proto := opt.proto
+	for i, c := range proto.Constants {
+		if c.Type() == v.Type() && c == v {
+			return i
+		}
+	}
+	if len(proto.Constants) > opMaxArgBx {
+		return -1
+	}
+	proto.Constants = append(proto.Constants, v)
+	s := ""
+	if sv, ok := v.(LString); ok {
+		s = string(sv)
+	}
+	proto.stringConstants = append(proto.stringConstants, s)
+	return len(proto.Constants) - 1
+}
+
+func (opt *protoOptimizer) foldConstants() {
+	code := opt.code
+	for pc, inst := range code {
+		if !opt.editable(pc) {
+			continue
+		}
+		switch op := opGetOpCode(inst); op {
+		case OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_POW:
+			lv, lok := opt.constant(opGetArgB(inst))
+			rv, rok := opt.constant(opGetArgC(inst))
+			if !lok || !rok {
+				continue
+			}
+			lhs, lok := lv.(LNumber)
+			rhs, rok := rv.(LNumber)
+			if !lok || !rok {
+				continue
+			}
+			v := numberArith(nil, op, lhs, rhs)
+			if f := float64(v); math.IsNaN(f) || math.IsInf(f, 0) {
+				continue
+			}
+			if idx := opt.constIndex(v); idx >= 0 {
+				code[pc] = opCreateABx(OP_LOADK, opGetArgA(inst), idx)
+			}
+		case OP_CONCAT:
+			opt.foldConcat(pc)
+		}
+	}
+}
+
+// foldConcat folds a CONCAT whose operands are all loaded by the LOADK
+// instructions immediately preceding it.
+func (opt *protoOptimizer) foldConcat(pc int) {
+	code := opt.code
+	inst := code[pc]
+	b, c := opGetArgB(inst), opGetArgC(inst)
+	n := c - b + 1
+	start := pc - n
+	if start < 0 || opt.target[pc] {
+		return
+	}
+	buf := make([]byte, 0, 32)
+	for i := 0; i < n; i++ {
+		lpc := start + i
+		load := code[lpc]
+		if !opt.editable(lpc) || (i > 0 && opt.target[lpc]) ||
+			opGetOpCode(load) != OP_LOADK || opGetArgA(load) != b+i {
+			return
+		}
+		v := opt.proto.Constants[opGetArgBx(load)]
+		if !LVCanConvToString(v) {
+			return
+		}
+		if nm, ok := v.(LNumber); ok && opt.floatSuffix {
+			buf = append(buf, floatString(nm)...)
+		} else {
+			buf = append(buf, LVAsString(v)...)
+		}
+	}
+	idx := opt.constIndex(LString(buf))
+	if idx < 0 {
+		return
+	}
+	for i := 0; i < n; i++ {
+		opt.nop(start + i)
+	}
+	code[pc] = opCreateABx(OP_LOADK, opGetArgA(inst), idx)
+}
+
+// storeTarget returns the register written by inst if inst only stores into
+// a single register without reading any other state that could have side
+// effects.
+func storeTarget(inst uint32) (int, bool) {
+	switch opGetOpCode(inst) {
+	case OP_MOVE, OP_LOADK, OP_GETUPVAL:
+		return opGetArgA(inst), true
+	case OP_LOADBOOL:
+		return opGetArgA(inst), opGetArgC(inst) == 0
+	case OP_LOADNIL:
+		return opGetArgA(inst), opGetArgA(inst) == opGetArgB(inst)
+	}
+	return 0, false
+}
+
+// overwrites reports whether inst stores into reg without reading it first.
+func overwrites(inst uint32, reg int) bool {
+	a := opGetArgA(inst)
+	switch opGetOpCode(inst) {
+	case OP_LOADK, OP_GETUPVAL, OP_NEWTABLE:
+		return a == reg
+	case OP_LOADBOOL:
+		return a == reg && opGetArgC(inst) == 0
+	case OP_MOVE:
+		return a == reg && opGetArgB(inst) != reg
+	case OP_LOADNIL:
+		return a <= reg && reg <= opGetArgB(inst)
+	}
+	return false
+}
+
+func (opt *protoOptimizer) removeDeadStores() {
+	code := opt.code
+	for pc := 0; pc+1 < len(code); pc++ {
+		if !opt.editable(pc) || opt.attached[pc+1] || opt.target[pc+1] {
+			continue
+		}
+		if reg, ok := storeTarget(code[pc]); ok && overwrites(code[pc+1], reg) {
+			opt.nop(pc)
+		}
+	}
+}
+
+func (opt *protoOptimizer) mergeLoadNils() {
+	code := opt.code
+	last := -1
+	for pc, inst := range code {
+		switch op := opGetOpCode(inst); {
+		case op == OP_NOP && !opt.target[pc]:
+			continue
+		case op == OP_LOADNIL && last >= 0 && opt.editable(pc) && opt.editable(last) && !opt.target[pc]:
+			prev := code[last]
+			a, b := opGetArgA(inst), opGetArgB(inst)
+			pa, pb := opGetArgA(prev), opGetArgB(prev)
+			if a <= pb+1 && pa <= b+1 {
+				opSetArgA(&code[last], min(a, pa))
+				opSetArgB(&code[last], max(b, pb))
+				opt.nop(pc)
+				continue
+			}
+		}
+		last = -1
+		if opGetOpCode(inst) == OP_LOADNIL && !opt.attached[pc] {
+			last = pc
+		}
+	}
+}
+
+// removeNops deletes NOP instructions and relocates jumps and debug
+// information.
+func (opt *protoOptimizer) removeNops() {
+	proto := opt.proto
+	code := opt.code
+	newpc := make([]int, len(code)+1)
+	keep := make([]bool, len(code))
+	n := 0
+	for pc, inst := range code {
+		newpc[pc] = n
+		if keep[pc] = opGetOpCode(inst) != OP_NOP || !opt.editable(pc); keep[pc] {
+			n++
+		}
+	}
+	newpc[len(code)] = n
+	if n == len(code) {
+		return
+	}
+
+	newcode := make([]uint32, 0, n)
+	newpos := make([]int, 0, n)
+	for pc := 0; pc < len(code); pc++ {
+		inst := code[pc]
+		if !keep[pc] {
+			continue
+		}
+		switch opGetOpCode(inst) {
+		case OP_JMP, OP_FORLOOP, OP_FORPREP:
+			if !opt.attached[pc] {
+				t := pc + 1 + opGetArgSbx(inst)
+				opSetArgSbx(&inst, newpc[t]-newpc[pc]-1)
+			}
+		}
+		newcode = append(newcode, inst)
+		if pc < len(proto.DbgSourcePositions) {
+			newpos = append(newpos, proto.DbgSourcePositions[pc])
+		}
+	}
+	proto.Code = newcode
+	proto.DbgSourcePositions = newpos
+	for _, local := range proto.DbgLocals {
+		local.StartPc = newpc[min(local.StartPc, len(code))]
+		local.EndPc = newpc[min(local.EndPc, len(code))]
+	}
+	for i := range proto.DbgCalls {
+		proto.DbgCalls[i].Pc = newpc[min(proto.DbgCalls[i].Pc, len(code))]
+	}
+	opt.code = newcode
+}
+
+/* }}} */
@@ -0,0 +1,67 @@
+package lua
+
+import (
+	"testing"
+)
+
+func compileOptimized(t *testing.T, src string) *FunctionProto {
+	proto := compileString(t, src)
+	Optimize(proto)
+	return proto
+}
+
+func TestOptimizeFoldsConcat(t *testing.T) {
+	proto := compileOptimized(t, `local s = "a" .. 1 .. "b"; return s`)
+	errorIfNotEqual(t, 0, countOpCode(proto, OP_CONCAT))
+	errorIfNotEqual(t, len(proto.Code), len(proto.DbgSourcePositions))
+
+	L := NewState(Options{OptimizeBytecode: true})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local s = "a" .. 1 .. "b"
+	assert(s == "a1b")
+	local x = "x"
+	assert("a" .. x .. "b" == "axb")
+	`)
+}
+
+func TestOptimizeThreadsJumps(t *testing.T) {
+	src := `
+	local n = 0
+	for i = 1, 10 do
+		if i % 2 == 0 then
+			if i > 4 then
+				n = n + i
+			end
+		end
+	end
+	assert(n == 24)
+	`
+	proto := compileOptimized(t, src)
+	for pc, inst := range proto.Code {
+		if opGetOpCode(inst) == OP_JMP {
+			target := pc + 1 + opGetArgSbx(inst)
+			errorIfFalse(t, opGetOpCode(proto.Code[target]) != OP_JMP, "jump to jump at pc %v was not threaded", pc)
+		}
+	}
+	L := NewState(Options{OptimizeBytecode: true})
+	defer L.Close()
+	errorIfScriptFail(t, L, src)
+}
+
+func TestOptimizeDeadStoresAndLoadNil(t *testing.T) {
+	proto := compileOptimized(t, `local a = 1; a = 2; local b; local c; return a, b, c`)
+	errorIfNotEqual(t, 1, countOpCode(proto, OP_LOADK))
+	errorIfFalse(t, countOpCode(proto, OP_LOADNIL) <= 1, "LOADNIL instructions were not merged")
+
+	L := NewState(Options{OptimizeBytecode: true})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local function f()
+		local a = 1; a = 2; local b; local c
+		return a, b, c
+	end
+	local a, b, c = f()
+	assert(a == 2 and b == nil and c == nil)
+	`)
+}
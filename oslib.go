@@ -38,14 +38,6 @@ func getIntField(L *LState, tb *LTable, key string, v int) int {
 	return v
 }
 
-func getBoolField(L *LState, tb *LTable, key string, v bool) bool {
-	ret := tb.RawGetString(key)
-	if lb, ok := ret.(LBool); ok {
-		return bool(lb)
-	}
-	return v
-}
-
 func OpenOs(L *LState) int {
 	osmod := L.RegisterModule(OsLibName, osFuncs)
 	L.Push(osmod)
@@ -68,49 +60,60 @@ var osFuncs = map[string]LGFunction{
 }
 
 func osClock(L *LState) int {
-	L.Push(LNumber(float64(time.Now().Sub(startedAt)) / float64(time.Second)))
+	L.Push(L.Nondeterministic("os.clock", func() []LValue {
+		return []LValue{LNumber(float64(L.cpuTime()) / float64(time.Second))}
+	})[0])
 	return 1
 }
 
+// cpuTime returns the CPU time reported by os.clock(see `Options.Clock` and `Options.WallClock`).
+func (ls *LState) cpuTime() time.Duration {
+	if ls.Options.Clock != nil {
+		return ls.Options.Clock()
+	}
+	if !ls.Options.WallClock {
+		if d, ok := processCPUTime(); ok {
+			return d
+		}
+	}
+	return time.Since(startedAt)
+}
+
 func osDiffTime(L *LState) int {
-	L.Push(LNumber(L.CheckInt64(1) - L.CheckInt64(2)))
+	L.Push(L.CheckNumber(1) - L.OptNumber(2, 0))
 	return 1
 }
 
 func osExecute(L *LState) int {
-	var procAttr os.ProcAttr
-	procAttr.Files = []*os.File{os.Stdin, os.Stdout, os.Stderr}
-	cmd, args := popenArgs(L.CheckString(1))
-	args = append([]string{cmd}, args...)
-	process, err := os.StartProcess(cmd, args, &procAttr)
-	if err != nil {
-		L.Push(LNumber(1))
+	command := L.CheckString(1)
+	L.enforcePolicy("os.execute", LString(command))
+	if err := runCommand(L, command); err != nil {
+		L.PushNumber(LNumber(1))
 		return 1
 	}
-
-	ps, err := process.Wait()
-	if err != nil || !ps.Success() {
-		L.Push(LNumber(1))
-		return 1
-	}
-	L.Push(LNumber(0))
+	L.PushNumber(LNumber(0))
 	return 1
 }
 
 func osExit(L *LState) int {
+	code := L.OptInt(1, 0)
+	L.enforcePolicy("os.exit", LNumber(code))
 	L.Close()
-	os.Exit(L.OptInt(1, 0))
+	os.Exit(code)
 	return 1
 }
 
 func osDate(L *LState) int {
-	t := time.Now()
+	var t time.Time
+	if L.GetTop() < 2 {
+		t = L.now("os.date")
+	}
 	isUTC := false
 	cfmt := "%c"
 	if L.GetTop() >= 1 {
 		cfmt = L.CheckString(1)
 		if strings.HasPrefix(cfmt, "!") {
-			cfmt = strings.TrimLeft(cfmt, "!")
+			cfmt = cfmt[1:]
 			isUTC = true
 		}
 		if L.GetTop() >= 2 {
@@ -128,9 +131,8 @@ func osDate(L *LState) int {
 			ret.RawSetString("min", LNumber(t.Minute()))
 			ret.RawSetString("sec", LNumber(t.Second()))
 			ret.RawSetString("wday", LNumber(t.Weekday()+1))
-			// TODO yday & dst
-			ret.RawSetString("yday", LNumber(0))
-			ret.RawSetString("isdst", LFalse)
+			ret.RawSetString("yday", LNumber(t.YearDay()))
+			ret.RawSetString("isdst", LBool(t.IsDST()))
 			L.Push(ret)
 			return 1
 		}
@@ -140,7 +142,9 @@ func osDate(L *LState) int {
 }
 
 func osGetEnv(L *LState) int {
-	v := os.Getenv(L.CheckString(1))
+	name := L.CheckString(1)
+	L.enforcePolicy("os.getenv", LString(name))
+	v, _ := L.getenv(name)
 	if len(v) == 0 {
 		L.Push(LNil)
 	} else {
@@ -150,19 +154,24 @@ func osGetEnv(L *LState) int {
 }
 
 func osRemove(L *LState) int {
-	err := os.Remove(L.CheckString(1))
+	path := L.CheckString(1)
+	L.enforcePolicy("os.remove", LString(path))
+	err := L.removeFile(path)
 	if err != nil {
 		L.Push(LNil)
 		L.Push(LString(err.Error()))
 		return 2
 	} else {
+		L.forgetTempFile(path)
 		L.Push(LTrue)
 		return 1
 	}
 }
 
 func osRename(L *LState) int {
-	err := os.Rename(L.CheckString(1), L.CheckString(2))
+	oldpath, newpath := L.CheckString(1), L.CheckString(2)
+	L.enforcePolicy("os.rename", LString(oldpath), LString(newpath))
+	err := L.renameFile(oldpath, newpath)
 	if err != nil {
 		L.Push(LNil)
 		L.Push(LString(err.Error()))
@@ -180,7 +189,9 @@ func osSetLocale(L *LState) int {
 }
 
 func osSetEnv(L *LState) int {
-	err := os.Setenv(L.CheckString(1), L.CheckString(2))
+	name, value := L.CheckString(1), L.CheckString(2)
+	L.enforcePolicy("os.setenv", LString(name), LString(value))
+	err := L.setenv(name, value)
 	if err != nil {
 		L.Push(LNil)
 		L.Push(LString(err.Error()))
@@ -193,42 +204,68 @@ func osSetEnv(L *LState) int {
 
 func osTime(L *LState) int {
 	if L.GetTop() == 0 {
-		L.Push(LNumber(time.Now().Unix()))
+		L.PushNumber(LNumber(L.now("os.time").Unix()))
 	} else {
 		lv := L.CheckAny(1)
 		if lv == LNil {
-			L.Push(LNumber(time.Now().Unix()))
+			L.PushNumber(LNumber(L.now("os.time").Unix()))
 		} else {
 			tbl, ok := lv.(*LTable)
 			if !ok {
 				L.TypeError(1, LTTable)
 			}
+			for _, key := range []string{"day", "month", "year"} {
+				if tbl.RawGetString(key) == LNil {
+					L.RaiseError("field '%s' missing in date table", key)
+				}
+			}
 			sec := getIntField(L, tbl, "sec", 0)
 			min := getIntField(L, tbl, "min", 0)
 			hour := getIntField(L, tbl, "hour", 12)
 			day := getIntField(L, tbl, "day", -1)
 			month := getIntField(L, tbl, "month", -1)
 			year := getIntField(L, tbl, "year", -1)
-			isdst := getBoolField(L, tbl, "isdst", false)
-			t := time.Date(year, time.Month(month), day, hour, min, sec, 0, time.Local)
-			// TODO dst
-			if false {
-				print(isdst)
+			// nsec is an extension: os.time returns fractional seconds if it is given
+			nsec := getIntField(L, tbl, "nsec", 0)
+			// out of range fields are normalized like mktime does, e.g. month 13 is January of the next year
+			t := time.Date(year, time.Month(month), day, hour, min, sec, nsec, time.Local)
+			// like mktime, a given isdst that does not match the date shifts the time by the daylight saving offset
+			if lv, ok := tbl.RawGetString("isdst").(LBool); ok && bool(lv) != t.IsDST() {
+				if lv {
+					t = t.Add(-dstOffset(t))
+				} else {
+					t = t.Add(dstOffset(t))
+				}
+			}
+			if nsec != 0 {
+				L.PushNumber(LNumber(float64(t.UnixNano()) / float64(time.Second)))
+			} else {
+				L.PushNumber(LNumber(t.Unix()))
 			}
-			L.Push(LNumber(t.Unix()))
 		}
 	}
 	return 1
 }
 
+// dstOffset returns the difference between the daylight saving time and the standard time of the time zone of t
+// in the year of t, which is 0 for time zones without daylight saving time.
+func dstOffset(t time.Time) time.Duration {
+	_, jan := time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location()).Zone()
+	_, jul := time.Date(t.Year(), time.July, 1, 0, 0, 0, 0, t.Location()).Zone()
+	d := jan - jul
+	if d < 0 {
+		d = -d
+	}
+	return time.Duration(d) * time.Second
+}
+
 func osTmpname(L *LState) int {
-	file, err := os.CreateTemp("", "")
+	L.enforcePolicy("os.tmpname")
+	_, name, err := L.createTempFile(false)
 	if err != nil {
 		L.RaiseError("unable to generate a unique filename")
 	}
-	file.Close()
-	os.Remove(file.Name()) // ignore errors
-	L.Push(LString(file.Name()))
+	L.Push(LString(name))
 	return 1
 }
 
@@ -2,10 +2,12 @@ package lua
 
 import (
 	"testing"
+	"time"
 )
 
 // correctly gc-ed. There was a bug in gopher lua where local vars were not being gc-ed in all circumstances.
 func TestOsWrite(t *testing.T) {
+	skipIfNoSys(t)
 	s := `
 		local function write(filename, content)
 		local f = assert(io.open(filename, "w"))
@@ -31,3 +33,80 @@ func TestOsWrite(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestOsDateSpecifiers(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	// Sunday, 2006-01-01 15:04:05 UTC
+	L.SetGlobal("t", LNumber(time.Date(2006, 1, 1, 15, 4, 5, 0, time.UTC).Unix()))
+	errorIfScriptFail(t, L, `
+local cases = {
+  {"%a %A %b %B %h", "Sun Sunday Jan January Jan"},
+  {"%c", "Sun Jan  1 15:04:05 2006"},
+  {"%C %y %Y %g %G", "20 06 2006 05 2005"},
+  {"%d %e %j %m", "01  1 001 01"},
+  {"%D %x %F", "01/01/06 01/01/06 2006-01-01"},
+  {"%H %I %M %S %p %P", "15 03 04 05 PM pm"},
+  {"%r %R %T %X", "03:04:05 PM 15:04 15:04:05 15:04:05"},
+  {"%u %w %U %W %V", "7 0 01 00 52"},
+  {"%n%t%%", "\n\t%"},
+  {"%Ey %OH", "06 15"},
+  {"%q", "%q"},
+  {"%", "%"},
+}
+for _, c in ipairs(cases) do
+  local s = os.date("!" .. c[1], t)
+  assert(s == c[2], c[1] .. ": " .. s)
+end
+local d = os.date("!*t", t + 86400 * 40)
+assert(d.year == 2006 and d.month == 2 and d.day == 10 and d.yday == 41 and d.wday == 6 and d.isdst == false)
+assert(os.date("!%j", t + 86400 * 364) == "365")
+`)
+}
+
+func TestOsTimeNormalization(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("expected", LNumber(time.Date(2007, 1, 31, 12, 0, 0, 0, time.Local).Unix()))
+	errorIfScriptFail(t, L, `
+assert(os.time({year = 2006, month = 13, day = 31}) == expected)
+assert(os.time({year = 2007, month = 2, day = 0}) == expected)
+assert(os.time({year = 2007, month = 1, day = 31, hour = 11, min = 59, sec = 60}) == expected)
+assert(os.time({year = 2007, month = 1, day = 31, nsec = 500000000}) == expected + 0.5)
+assert(os.difftime(expected + 0.25, expected) == 0.25)
+assert(os.difftime(expected) == expected)
+local d = os.date("*t", expected)
+assert(os.time(d) == expected)
+`)
+	errorIfScriptNotFail(t, L, `os.time({year = 2007, month = 1})`, "field 'day' missing in date table")
+}
+
+func TestDstOffset(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	errorIfNotEqual(t, time.Hour, dstOffset(time.Date(2020, 3, 1, 0, 0, 0, 0, ny)))
+	errorIfNotEqual(t, time.Duration(0), dstOffset(time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestOsClock(t *testing.T) {
+	L := NewState(Options{Clock: func() time.Duration { return 1500 * time.Millisecond }})
+	defer L.Close()
+	errorIfScriptFail(t, L, `assert(os.clock() == 1.5)`)
+
+	L2 := NewState()
+	defer L2.Close()
+	errorIfScriptFail(t, L2, `
+local start = os.clock()
+assert(start >= 0)
+local x = 0
+for i = 1, 2000000 do x = x + i end
+assert(os.clock() > start)
+`)
+	L3 := NewState(Options{WallClock: true})
+	defer L3.Close()
+	before := time.Since(startedAt).Seconds()
+	errorIfNotNil(t, L3.DoString(`clock = os.clock()`))
+	errorIfFalse(t, float64(L3.GetGlobal("clock").(LNumber)) >= before, "os.clock must return the wall clock time")
+}
@@ -0,0 +1,99 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+/* panic handling {{{ */
+
+// PanicMode controls how internal errors are handled. Internal errors are Go panics that are not Lua
+// errors(runtime errors in Go functions or in the VM itself) and overflows of the call stack and the registry.
+type PanicMode int
+
+const (
+	// PanicModeError converts internal errors into Lua errors. Go panics are reported by `PCall` as an *ApiError
+	// of type ApiErrorPanic. This is the default.
+	PanicModeError PanicMode = iota
+	// PanicModePropagate lets Go panics propagate through `PCall`, `DoString` and the Lua pcall function with their
+	// original value, and turns stack overflows into Go panics. Use this if the host recovers panics by itself or
+	// prefers to crash.
+	PanicModePropagate
+	// PanicModeHandler calls `Options.PanicHandler` with the state of the failing function before handling the
+	// error as PanicModeError does.
+	PanicModeHandler
+)
+
+// PanicInfo describes an internal error. It is passed to `Options.PanicHandler`.
+type PanicInfo struct {
+	// Value is the recovered value of a Go panic, or the error message of an overflow.
+	Value interface{}
+	// GoStackTrace is the stack of the goroutine where a Go panic has been recovered. It is empty for overflows.
+	GoStackTrace string
+	// Frames is the Lua call stack at the time of the error.
+	Frames []Frame
+}
+
+// PanicHandler is a function called on internal errors when `Options.PanicMode` is PanicModeHandler. The call stack
+// of L is still intact when the handler is called, so it can be inspected with `LState.GetStack` and friends.
+type PanicHandler func(L *LState, info *PanicInfo)
+
+// SafeGFunction wraps fn so that Go panics inside fn are raised as Lua errors, which can be caught by pcall in the
+// calling script. Lua errors raised by fn are passed through unchanged. If `Options.PanicMode` is
+// PanicModePropagate, panics are passed through as well. If the error is not caught by the script, the
+// *ApiError returned by `PCall` holds the Go stack of the panic in GoStackTrace. See also
+// `Options.SafeGFunctions`.
+func SafeGFunction(fn LGFunction) LGFunction {
+	return func(L *LState) int {
+		defer func() {
+			if rcv := recover(); rcv != nil {
+				if _, ok := rcv.(*ApiError); ok || L.Options.PanicMode == PanicModePropagate {
+					panic(rcv)
+				}
+				err := L.goPanicError(rcv)
+				L.goPanicStack = err.GoStackTrace
+				L.RaiseError("%v", err.Object)
+			}
+		}()
+		return fn(L)
+	}
+}
+
+// goPanicError converts a recovered Go panic into an error, calling the panic handler first if there is one.
+func (ls *LState) goPanicError(rcv interface{}) *ApiError {
+	err := newApiErrorS(ApiErrorPanic, fmt.Sprint(rcv))
+	handler := ls.Options.PanicHandler
+	if ls.Options.PanicMode != PanicModeHandler {
+		handler = nil
+	}
+	buf := make([]byte, 4096)
+	runtime.Stack(buf, false)
+	gostack := strings.Trim(string(buf), "\000")
+	err.GoStackTrace = gostack
+	if ls.Options.IncludeGoStackTrace {
+		err.StackTrace = gostack + "\n" + ls.stackTrace(0)
+		err.Frames = ls.StackTrace()
+	}
+	if handler != nil {
+		handler(ls, &PanicInfo{Value: rcv, GoStackTrace: gostack, Frames: ls.StackTrace()})
+	}
+	return err
+}
+
+// internalError raises an overflow error according to `Options.PanicMode`.
+func (ls *LState) internalError(msg string) {
+	ls.audit(AuditQuota, msg, errors.New(msg))
+	switch ls.Options.PanicMode {
+	case PanicModePropagate:
+		panic("lua " + msg)
+	case PanicModeHandler:
+		if ls.Options.PanicHandler != nil {
+			ls.Options.PanicHandler(ls, &PanicInfo{Value: msg, Frames: ls.StackTrace()})
+		}
+	}
+	ls.RaiseError("%s", msg)
+}
+
+/* }}} */
@@ -0,0 +1,136 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func panickingGFunction(L *LState) int {
+	var tb *LTable
+	return tb.Len()
+}
+
+func TestPanicModeError(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("bad", L.NewFunction(panickingGFunction))
+	err := L.DoString(`bad()`)
+	errorIfNil(t, err)
+	errorIfNotEqual(t, ApiErrorPanic, err.(*ApiError).Type)
+	errorIfFalse(t, strings.Contains(err.Error(), "nil pointer dereference"), "unexpected error: %v", err)
+	errorIfScriptFail(t, L, `
+local ok, msg = pcall(bad)
+assert(not ok and string.find(msg, "nil pointer dereference", 1, true))
+`)
+}
+
+func TestPanicModePropagate(t *testing.T) {
+	L := NewState(Options{PanicMode: PanicModePropagate})
+	defer L.Close()
+	L.SetGlobal("bad", L.NewFunction(panickingGFunction))
+	L.SetGlobal("safebad", L.NewFunction(SafeGFunction(panickingGFunction)))
+	for _, script := range []string{`bad()`, `pcall(bad)`, `pcall(safebad)`, `coroutine.wrap(bad)()`} {
+		func() {
+			defer func() {
+				rcv := recover()
+				errorIfNil(t, rcv)
+				_, ok := rcv.(*ApiError)
+				errorIfFalse(t, !ok, "%v: expected a Go panic, got %v", script, rcv)
+			}()
+			L.DoString(script)
+		}()
+		errorIfNotEqual(t, 0, L.GetTop())
+	}
+
+	func() {
+		defer func() {
+			errorIfNotEqual(t, "lua stack overflow", recover())
+		}()
+		L.DoString(`local function f() return 1 + f() end; pcall(f)`)
+	}()
+	errorIfScriptFail(t, L, `assert(not pcall(error, "lua errors are still caught"))`)
+}
+
+func TestPanicModeHandler(t *testing.T) {
+	var infos []PanicInfo
+	var topFunctions []string
+	L := NewState(Options{
+		PanicMode: PanicModeHandler,
+		PanicHandler: func(L *LState, info *PanicInfo) {
+			infos = append(infos, *info)
+			dbg, ok := L.GetStack(0)
+			errorIfFalse(t, ok, "stack should be available")
+			L.GetInfo("n", dbg, LNil)
+			topFunctions = append(topFunctions, dbg.Name)
+		},
+	})
+	defer L.Close()
+	L.SetGlobal("bad", L.NewFunction(panickingGFunction))
+	err := L.DoString(`bad()`)
+	errorIfNil(t, err)
+	errorIfNotEqual(t, ApiErrorPanic, err.(*ApiError).Type)
+	errorIfNotEqual(t, 1, len(infos))
+	errorIfFalse(t, strings.Contains(infos[0].GoStackTrace, "panickingGFunction"), "unexpected Go stack: %v", infos[0].GoStackTrace)
+	errorIfNotEqual(t, "bad", infos[0].Frames[0].FunctionName)
+	errorIfNotEqual(t, "bad", topFunctions[0])
+
+	errorIfScriptFail(t, L, `
+local function f() return 1 + f() end
+local ok, msg = pcall(f)
+assert(not ok and string.find(msg, "stack overflow", 1, true))
+`)
+	errorIfNotEqual(t, 2, len(infos))
+	errorIfNotEqual(t, "stack overflow", infos[1].Value)
+	errorIfNotEqual(t, "", infos[1].GoStackTrace)
+	errorIfNotEqual(t, "f", infos[1].Frames[0].FunctionName)
+}
+
+func TestSafeGFunction(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("bad", L.NewFunction(SafeGFunction(panickingGFunction)))
+	L.SetGlobal("fail", L.NewFunction(SafeGFunction(func(L *LState) int {
+		L.RaiseError("failed")
+		return 0
+	})))
+	L.SetGlobal("inc", L.NewFunction(SafeGFunction(func(L *LState) int {
+		L.Push(LNumber(L.CheckInt(1) + 1))
+		return 1
+	})))
+	errorIfScriptFail(t, L, `
+local ok, msg = pcall(bad)
+assert(not ok and string.find(msg, "nil pointer dereference", 1, true))
+local ok, msg = pcall(fail)
+assert(not ok and string.find(msg, "failed", 1, true))
+assert(inc(1) == 2)
+`)
+	err := L.DoString(`bad()`)
+	errorIfNil(t, err)
+	errorIfNotEqual(t, ApiErrorRun, err.(*ApiError).Type)
+	errorIfFalse(t, strings.Contains(err.(*ApiError).GoStackTrace, "panickingGFunction"), "Go stack expected, got %q", err.(*ApiError).GoStackTrace)
+	err = L.DoString(`fail()`)
+	errorIfNil(t, err)
+	errorIfNotEqual(t, "", err.(*ApiError).GoStackTrace)
+}
+
+func TestSafeGFunctionsOption(t *testing.T) {
+	L := NewState(Options{SafeGFunctions: true})
+	defer L.Close()
+	L.Register("bad", panickingGFunction)
+	L.SetGlobal("mod", L.SetFuncs(L.NewTable(), map[string]LGFunction{"bad": panickingGFunction}))
+	errorIfScriptFail(t, L, `
+	local ok, msg = pcall(bad)
+	assert(not ok and string.find(msg, "nil pointer dereference", 1, true))
+	ok, msg = pcall(mod.bad)
+	assert(not ok and string.find(msg, "nil pointer dereference", 1, true))
+	assert(string.upper("ok") == "OK")
+	assert(not pcall(error, "lua errors are still raised"))
+	`)
+	// the panics are raised as Lua errors by the functions instead of being recovered by PCall
+	for _, script := range []string{`bad()`, `mod.bad()`} {
+		err := L.DoString(script)
+		errorIfNil(t, err)
+		errorIfNotEqual(t, ApiErrorRun, err.(*ApiError).Type)
+		errorIfFalse(t, strings.Contains(err.(*ApiError).GoStackTrace, "panickingGFunction"), "Go stack expected")
+	}
+}
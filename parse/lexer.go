@@ -67,6 +67,8 @@ func (sc *Scanner) readNext() int {
 	ch, err := sc.reader.ReadByte()
 	if err == io.EOF {
 		return EOF
+	} else if err != nil {
+		panic(sc.Error("", err.Error()))
 	}
 	return int(ch)
 }
@@ -441,11 +443,19 @@ type Lexer struct {
 	PNewLine      bool
 	Token         ast.Token
 	PrevTokenType int
+	// pending are tokens returned before the tokens of the scanner.
+	pending []ast.Token
 }
 
 func (lx *Lexer) Lex(lval *yySymType) int {
 	lx.PrevTokenType = lx.Token.Type
-	tok, err := lx.scanner.Scan(lx)
+	var tok ast.Token
+	var err error
+	if len(lx.pending) > 0 {
+		tok, lx.pending = lx.pending[0], lx.pending[1:]
+	} else {
+		tok, err = lx.scanner.Scan(lx)
+	}
 	if err != nil {
 		panic(err)
 	}
@@ -466,7 +476,7 @@ func (lx *Lexer) TokenError(tok ast.Token, message string) {
 }
 
 func Parse(reader io.Reader, name string) (chunk []ast.Stmt, err error) {
-	lexer := &Lexer{NewScanner(reader, name), nil, false, ast.Token{Str: ""}, TNil}
+	lexer := &Lexer{NewScanner(reader, name), nil, false, ast.Token{Str: ""}, TNil, nil}
 	chunk = nil
 	defer func() {
 		if e := recover(); e != nil {
@@ -478,6 +488,34 @@ func Parse(reader io.Reader, name string) (chunk []ast.Stmt, err error) {
 	return
 }
 
+// ParseExpr parses a single expression, e.g. "x.y + 1". The positions of the nodes and of errors refer to the
+// expression itself, and an *Error is returned if the source contains anything but one expression.
+func ParseExpr(reader io.Reader, name string) (expr ast.Expr, err error) {
+	// the expression is parsed as the chunk "return expr" with a return token that is not in the source
+	ret := ast.Token{Type: TReturn, Name: "return", Str: "return", Pos: ast.Position{Source: name, Line: 1}}
+	lexer := &Lexer{NewScanner(reader, name), nil, false, ast.Token{Str: ""}, TNil, []ast.Token{ret}}
+	defer func() {
+		if e := recover(); e != nil {
+			expr = nil
+			err, _ = e.(error)
+		}
+	}()
+	yyParse(lexer)
+	chunk := lexer.Stmts
+	if len(chunk) == 1 {
+		if stmt, ok := chunk[0].(*ast.ReturnStmt); ok && len(stmt.Exprs) == 1 {
+			return stmt.Exprs[0], nil
+		}
+	}
+	pos := ast.Position{Source: name, Line: EOF}
+	if len(chunk) == 1 {
+		if stmt, ok := chunk[0].(*ast.ReturnStmt); ok && len(stmt.Exprs) > 1 {
+			pos.Line = stmt.Exprs[1].Line()
+		}
+	}
+	return nil, &Error{Pos: pos, Message: "single expression expected", Token: ","}
+}
+
 // }}}
 
 // Dump {{{
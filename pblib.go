@@ -0,0 +1,297 @@
+//go:build pb
+
+package lua
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+/* pb library {{{ */
+
+// NewPBLoader returns the loader of a pb library converting protocol buffer messages of the types described by
+// files between the wire format and tables. If files is nil, the types linked into the program are used
+// (protoregistry.GlobalFiles). Register it with `LState.PreloadModule`:
+//
+//	L.PreloadModule("pb", lua.NewPBLoader(files))
+//
+// Scripts then decode and encode messages by the full names of their types:
+//
+//	local pb = require("pb")
+//	local msg, err = pb.decode("shop.Order", payload) -- {id = 1, items = {{sku = "a", count = 2}}, status = "PAID"}
+//	msg.status = "SHIPPED"
+//	local payload, err = pb.encode("shop.Order", msg)
+//
+// Fields are keyed by their names, repeated fields are arrays and map fields are tables. Numbers of all sizes are
+// converted to Lua numbers, so 64-bit integers beyond 2^53 lose precision. Enum values are decoded to their names,
+// and encoded from names or numbers. Bytes fields are strings. Encoding is deterministic, map entries are written
+// in the order of their keys. Only fields that are set are decoded, as with proto.Message.Range. Encoding a
+// table that contains itself raises an error.
+// The library is only built with the "pb" build tag, since it depends on google.golang.org/protobuf.
+func NewPBLoader(files *protoregistry.Files) LGFunction {
+	if files == nil {
+		files = protoregistry.GlobalFiles
+	}
+	return func(L *LState) int {
+		mod := L.SetFuncs(L.NewTable(), map[string]LGFunction{
+			"decode": func(L *LState) int { return pbDecode(L, files) },
+			"encode": func(L *LState) int { return pbEncode(L, files) },
+		})
+		L.Push(mod)
+		return 1
+	}
+}
+
+func checkMessageType(L *LState, files *protoregistry.Files, n int) protoreflect.MessageDescriptor {
+	name := L.CheckString(n)
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if err != nil || !ok {
+		L.ArgError(n, "unknown message type '"+name+"'")
+	}
+	return md
+}
+
+func pbDecode(L *LState, files *protoregistry.Files) int {
+	md := checkMessageType(L, files, 1)
+	msg := dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal([]byte(L.CheckString(2)), msg); err != nil {
+		L.Push(LNil)
+		L.Push(LString(err.Error()))
+		return 2
+	}
+	L.Push(pbMessageToTable(L, msg))
+	return 1
+}
+
+func pbEncode(L *LState, files *protoregistry.Files) int {
+	md := checkMessageType(L, files, 1)
+	msg := dynamicpb.NewMessage(md)
+	err := pbTableToMessage(L.CheckTable(2), msg, map[*LTable]bool{})
+	if errors.Is(err, errPBCycle) {
+		L.RaiseError("%s", err.Error())
+	}
+	var data []byte
+	if err == nil {
+		data, err = proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	}
+	if err != nil {
+		L.Push(LNil)
+		L.Push(LString(err.Error()))
+		return 2
+	}
+	L.Push(LString(data))
+	return 1
+}
+
+func pbMessageToTable(L *LState, msg protoreflect.Message) *LTable {
+	tb := L.NewTable()
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList():
+			list := v.List()
+			arr := L.CreateTable(list.Len(), 0)
+			for i := 0; i < list.Len(); i++ {
+				arr.Append(pbValueToLValue(L, fd, list.Get(i)))
+			}
+			tb.RawSetString(string(fd.Name()), arr)
+		case fd.IsMap():
+			m := L.NewTable()
+			v.Map().Range(func(key protoreflect.MapKey, value protoreflect.Value) bool {
+				m.RawSet(pbValueToLValue(L, fd.MapKey(), key.Value()), pbValueToLValue(L, fd.MapValue(), value))
+				return true
+			})
+			tb.RawSetString(string(fd.Name()), m)
+		default:
+			tb.RawSetString(string(fd.Name()), pbValueToLValue(L, fd, v))
+		}
+		return true
+	})
+	return tb
+}
+
+func pbValueToLValue(L *LState, fd protoreflect.FieldDescriptor, v protoreflect.Value) LValue {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return LBool(v.Bool())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return LNumber(v.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return LNumber(v.Uint())
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return LNumber(v.Float())
+	case protoreflect.StringKind:
+		return LString(v.String())
+	case protoreflect.BytesKind:
+		return LString(v.Bytes())
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return LString(ev.Name())
+		}
+		return LNumber(v.Enum())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return pbMessageToTable(L, v.Message())
+	}
+	return LNil
+}
+
+// errPBCycle is returned when a table to encode contains itself.
+var errPBCycle = errors.New("cyclic tables can not be encoded")
+
+// pbTableToMessage sets the fields of msg from tb. visiting holds the tables being converted, to detect cycles.
+func pbTableToMessage(tb *LTable, msg protoreflect.Message, visiting map[*LTable]bool) error {
+	if visiting[tb] {
+		return errPBCycle
+	}
+	visiting[tb] = true
+	defer delete(visiting, tb)
+	fields := msg.Descriptor().Fields()
+	return tb.ForEachE(func(key, value LValue) error {
+		name, ok := key.(LString)
+		if !ok {
+			return fmt.Errorf("%s: field names must be strings, got %s", msg.Descriptor().FullName(), key.Type().String())
+		}
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			return fmt.Errorf("%s: unknown field '%s'", msg.Descriptor().FullName(), string(name))
+		}
+		if value == LNil {
+			return nil
+		}
+		if err := pbSetField(msg, fd, value, visiting); err != nil {
+			return fmt.Errorf("%s: %w", fd.FullName(), err)
+		}
+		return nil
+	})
+}
+
+func pbSetField(msg protoreflect.Message, fd protoreflect.FieldDescriptor, value LValue,
+	visiting map[*LTable]bool) error {
+	switch {
+	case fd.IsList():
+		tb, ok := value.(*LTable)
+		if !ok {
+			return fmt.Errorf("table expected, got %s", value.Type().String())
+		}
+		list := msg.Mutable(fd).List()
+		for i := 1; i <= tb.Len(); i++ {
+			v, err := pbLValueToValue(fd, tb.RawGetInt(i), list.NewElement, visiting)
+			if err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+			list.Append(v)
+		}
+	case fd.IsMap():
+		tb, ok := value.(*LTable)
+		if !ok {
+			return fmt.Errorf("table expected, got %s", value.Type().String())
+		}
+		m := msg.Mutable(fd).Map()
+		return tb.ForEachE(func(key, value LValue) error {
+			k, err := pbLValueToValue(fd.MapKey(), key, nil, visiting)
+			if err != nil {
+				return fmt.Errorf("key %s: %w", key.String(), err)
+			}
+			v, err := pbLValueToValue(fd.MapValue(), value, m.NewValue, visiting)
+			if err != nil {
+				return fmt.Errorf("value of %s: %w", key.String(), err)
+			}
+			m.Set(k.MapKey(), v)
+			return nil
+		})
+	default:
+		v, err := pbLValueToValue(fd, value, func() protoreflect.Value { return msg.NewField(fd) }, visiting)
+		if err != nil {
+			return err
+		}
+		msg.Set(fd, v)
+	}
+	return nil
+}
+
+// pbLValueToValue converts lv to a value of the field fd. newMessage returns an empty message for message fields.
+func pbLValueToValue(fd protoreflect.FieldDescriptor, lv LValue, newMessage func() protoreflect.Value,
+	visiting map[*LTable]bool) (protoreflect.Value, error) {
+	mismatch := func(expected string) (protoreflect.Value, error) {
+		return protoreflect.Value{}, fmt.Errorf("%s expected, got %s", expected, lv.Type().String())
+	}
+	integer := func(min, max float64) (float64, error) {
+		n, ok := lv.(LNumber)
+		if !ok {
+			return 0, fmt.Errorf("number expected, got %s", lv.Type().String())
+		}
+		f := float64(n)
+		if f != math.Trunc(f) || f < min || f >= max+1 {
+			return 0, fmt.Errorf("%v is out of range for %s", lv, fd.Kind())
+		}
+		return f, nil
+	}
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		if b, ok := lv.(LBool); ok {
+			return protoreflect.ValueOfBool(bool(b)), nil
+		}
+		return mismatch("boolean")
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		f, err := integer(math.MinInt32, math.MaxInt32)
+		return protoreflect.ValueOfInt32(int32(f)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		f, err := integer(math.MinInt64, math.MaxInt64)
+		return protoreflect.ValueOfInt64(int64(f)), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		f, err := integer(0, math.MaxUint32)
+		return protoreflect.ValueOfUint32(uint32(f)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		f, err := integer(0, math.MaxUint64)
+		return protoreflect.ValueOfUint64(uint64(f)), err
+	case protoreflect.FloatKind:
+		if n, ok := lv.(LNumber); ok {
+			return protoreflect.ValueOfFloat32(float32(n)), nil
+		}
+		return mismatch("number")
+	case protoreflect.DoubleKind:
+		if n, ok := lv.(LNumber); ok {
+			return protoreflect.ValueOfFloat64(float64(n)), nil
+		}
+		return mismatch("number")
+	case protoreflect.StringKind:
+		if s, ok := lv.(LString); ok {
+			return protoreflect.ValueOfString(string(s)), nil
+		}
+		return mismatch("string")
+	case protoreflect.BytesKind:
+		if s, ok := lv.(LString); ok {
+			return protoreflect.ValueOfBytes([]byte(s)), nil
+		}
+		return mismatch("string")
+	case protoreflect.EnumKind:
+		switch v := lv.(type) {
+		case LString:
+			if ev := fd.Enum().Values().ByName(protoreflect.Name(v)); ev != nil {
+				return protoreflect.ValueOfEnum(ev.Number()), nil
+			}
+			return protoreflect.Value{}, fmt.Errorf("unknown value '%s' of %s", string(v), fd.Enum().FullName())
+		case LNumber:
+			f, err := integer(math.MinInt32, math.MaxInt32)
+			return protoreflect.ValueOfEnum(protoreflect.EnumNumber(f)), err
+		}
+		return mismatch("string or number")
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		tb, ok := lv.(*LTable)
+		if !ok {
+			return mismatch("table")
+		}
+		v := newMessage()
+		return v, pbTableToMessage(tb, v.Message(), visiting)
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported field kind %s", fd.Kind())
+}
+
+/* }}} */
@@ -0,0 +1,122 @@
+//go:build pb
+
+package lua
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+)
+
+func testPBFiles(t *testing.T) *protoregistry.Files {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		fd := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Type: typ.Enum(), Label: label.Enum()}
+		if typeName != "" {
+			fd.TypeName = proto.String(typeName)
+		}
+		return fd
+	}
+	optional, repeated := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("shop.proto"),
+		Package: proto.String("shop"),
+		Syntax:  proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Status"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("NEW"), Number: proto.Int32(0)},
+				{Name: proto.String("PAID"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Item"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("sku", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+					field("count", 2, descriptorpb.FieldDescriptorProto_TYPE_UINT32, optional, ""),
+				},
+			},
+			{
+				Name: proto.String("Order"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, optional, ""),
+					field("items", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, repeated, ".shop.Item"),
+					field("status", 3, descriptorpb.FieldDescriptorProto_TYPE_ENUM, optional, ".shop.Status"),
+					field("tags", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING, repeated, ""),
+					field("attrs", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, repeated, ".shop.Order.AttrsEntry"),
+					field("payload", 6, descriptorpb.FieldDescriptorProto_TYPE_BYTES, optional, ""),
+					field("total", 7, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, optional, ""),
+					field("gift", 8, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".shop.Item"),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{{
+					Name: proto.String("AttrsEntry"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+						field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, optional, ""),
+					},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				}},
+			},
+		},
+	}
+	fd, err := protodesc.NewFile(file, nil)
+	errorIfNotNil(t, err)
+	files := new(protoregistry.Files)
+	errorIfNotNil(t, files.RegisterFile(fd))
+	return files
+}
+
+func TestPBLib(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.PreloadModule("pb", NewPBLoader(testPBFiles(t)))
+	errorIfScriptFail(t, L, `
+local pb = require("pb")
+local order = {
+  id = 12345, status = "PAID", tags = {"a", "b"}, attrs = {x = 1, y = -2}, payload = "\0\1\2", total = 9.5,
+  items = {{sku = "apple", count = 2}, {sku = "pear"}}, gift = {sku = "card", count = 1},
+}
+local data = assert(pb.encode("shop.Order", order))
+local decoded = assert(pb.decode("shop.Order", data))
+assert(decoded.id == 12345 and decoded.status == "PAID" and decoded.total == 9.5 and decoded.payload == "\0\1\2")
+assert(#decoded.tags == 2 and decoded.tags[2] == "b" and decoded.attrs.x == 1 and decoded.attrs.y == -2)
+assert(#decoded.items == 2 and decoded.items[1].sku == "apple" and decoded.items[1].count == 2)
+assert(decoded.items[2].count == nil and decoded.gift.sku == "card")
+assert(pb.encode("shop.Order", decoded) == data)
+
+local empty = assert(pb.decode("shop.Order", ""))
+assert(next(empty) == nil)
+assert(pb.decode("shop.Order", assert(pb.encode("shop.Order", {status = 1}))).status == "PAID")
+
+local s, err = pb.encode("shop.Order", {nope = 1})
+assert(s == nil and string.find(err, "unknown field 'nope'", 1, true))
+s, err = pb.encode("shop.Order", {items = {{count = -1}}})
+assert(s == nil and string.find(err, "shop.Order.items: element 1: shop.Item.count: -1 is out of range for uint32", 1, true))
+s, err = pb.encode("shop.Order", {status = "LOST"})
+assert(s == nil and string.find(err, "unknown value 'LOST' of shop.Status", 1, true))
+s, err = pb.encode("shop.Order", {id = "x"})
+assert(s == nil and string.find(err, "number expected, got string", 1, true))
+s, err = pb.decode("shop.Order", "\255\255")
+assert(s == nil and err)
+`)
+	errorIfScriptNotFail(t, L, `require("pb").decode("shop.Missing", "")`, "unknown message type 'shop.Missing'")
+}
+
+func TestPBLibCycle(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.PreloadModule("pb", NewPBLoader(nil))
+	errorIfScriptFail(t, L, `
+local pb = require("pb")
+local v = {struct_value = {fields = {a = {list_value = {values = {{string_value = "x"}}}}}}}
+assert(pb.encode("google.protobuf.Value", {list_value = {values = {v, v}}}))
+`)
+	errorIfScriptNotFail(t, L, `
+local v = {}
+v.struct_value = {fields = {x = v}}
+require("pb").encode("google.protobuf.Value", v)`, "google.protobuf.Struct.fields: value of x: cyclic tables can not be encoded")
+}
@@ -0,0 +1,928 @@
+package lua
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+/* persistence {{{ */
+
+// PersistOptions configures `LState.Persist` and `LState.Unpersist`.
+type PersistOptions struct {
+	// Permanents maps the values that are not serialized to names. Unpersist maps the names back to the values it
+	// is given as Permanents. If Permanents is nil, the permanents of the state are used(see `LState.Permanents`).
+	Permanents map[LValue]string
+	// PersistUserData serializes the value of a userdata. Userdata can not be persisted if it is nil.
+	PersistUserData func(L *LState, ud *LUserData) ([]byte, error)
+	// UnpersistUserData restores the value of a userdata serialized by PersistUserData. The metatable of the
+	// userdata is restored by Unpersist.
+	UnpersistUserData func(L *LState, data []byte) (interface{}, error)
+}
+
+// persistMagic starts the data written by Persist. The last byte is the version of the format.
+const persistMagic = "\x1bGLP\x01"
+
+const (
+	persistNil byte = iota
+	persistFalse
+	persistTrue
+	persistNumber
+	persistString
+	persistRef
+	persistPermanent
+	persistTable
+	persistFunction
+	persistUserData
+	persistThread
+	persistUpvalue
+	persistOpenUpvalue
+	persistProto
+)
+
+// Permanents returns the values of this state that `LState.Persist` does not serialize but refers to by name: the
+// global table, the registry, and the tables, functions and userdata reachable from them and from the metatables
+// of the basic types through string and integer keys, named by the shortest path that leads to them, e.g.
+// "_G.string.format". Values persisted by one state are restored by another state with the same permanents, e.g.
+// a state that has been set up by the same code.
+func (ls *LState) Permanents() map[LValue]string {
+	return ls.namePermanents(func(v LValue, name string) bool {
+		switch v.(type) {
+		case *LTable, *LFunction, *LUserData:
+			return true
+		}
+		return false
+	})
+}
+
+// namePermanents walks the tables reachable from the global table, the registry and the metatables of the basic
+// types through string and integer keys breadth-first, in the order of the keys, and names the values permanent
+// returns true for by their path, e.g. "_REGISTRY._LOADERS[1]" or "_METATABLE.channel.__index.receive".
+func (ls *LState) namePermanents(permanent func(v LValue, name string) bool) map[LValue]string {
+	permanents := map[LValue]string{ls.G.Global: "_G", ls.G.Registry: "_REGISTRY"}
+	type entry struct {
+		tb   *LTable
+		name string
+	}
+	queue := []entry{{ls.G.Global, "_G"}, {ls.G.Registry, "_REGISTRY"}}
+	visited := map[*LTable]bool{ls.G.Global: true, ls.G.Registry: true}
+	types := make([]int, 0, len(ls.G.builtinMts))
+	for tp := range ls.G.builtinMts {
+		types = append(types, tp)
+	}
+	sort.Ints(types)
+	for _, tp := range types {
+		if mt, ok := ls.G.builtinMts[tp].(*LTable); ok {
+			queue = append(queue, entry{mt, "_METATABLE." + LValueType(tp).String()})
+		}
+	}
+	for len(queue) > 0 {
+		e := queue[0]
+		queue = queue[1:]
+		if visited[e.tb] && e.tb != ls.G.Global && e.tb != ls.G.Registry {
+			continue
+		}
+		visited[e.tb] = true
+		keys := []string{}
+		indices := []int{}
+		e.tb.ForEach(func(key, value LValue) {
+			switch k := key.(type) {
+			case LString:
+				keys = append(keys, string(k))
+			case LNumber:
+				if i := int(k); LNumber(i) == k {
+					indices = append(indices, i)
+				}
+			}
+		})
+		sort.Strings(keys)
+		sort.Ints(indices)
+		names := make([]string, 0, len(keys)+len(indices))
+		values := make([]LValue, 0, len(keys)+len(indices))
+		for _, key := range keys {
+			names = append(names, e.name+"."+key)
+			values = append(values, e.tb.RawGetString(key))
+		}
+		for _, i := range indices {
+			names = append(names, fmt.Sprintf("%s[%d]", e.name, i))
+			values = append(values, e.tb.RawGetInt(i))
+		}
+		for i, value := range values {
+			name := names[i]
+			if _, ok := permanents[value]; !ok && permanent(value, name) {
+				permanents[value] = name
+			}
+			if tb, ok := value.(*LTable); ok && !visited[tb] {
+				queue = append(queue, entry{tb, name})
+			}
+		}
+	}
+	return permanents
+}
+
+// Persist serializes v and the values reachable from it, except the permanents, in the spirit of Pluto. Tables,
+// Lua functions and their upvalues, userdata(see `PersistOptions.PersistUserData`) and coroutines are supported.
+// Coroutines can be persisted while they are suspended, before they have been started and after they have died,
+// so a long running script can yield, be persisted, and be resumed in another process after it has been
+// restored by `LState.Unpersist`:
+//
+//	data, err := L.Persist(co, nil)
+//	...
+//	v, err := L2.Unpersist(data, nil)
+//	st, err, values := L2.Resume(v.(*lua.LState), nil)
+//
+// Go functions, channels and objects can only be persisted as permanents, and coroutines are not persistable
+// while they are running, are suspended in a Go function or run on goroutines of their own. v itself is
+// serialized even if it is a permanent.
+func (ls *LState) Persist(v LValue, opts *PersistOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &PersistOptions{}
+	}
+	permanents := opts.Permanents
+	if permanents == nil {
+		permanents = ls.Permanents()
+	}
+	return ls.persist(v, opts, permanents, persistMagic)
+}
+
+func (ls *LState) persist(v LValue, opts *PersistOptions, permanents map[LValue]string, magic string) (data []byte, err error) {
+	p := &persister{L: ls, opts: opts, permanents: permanents, ids: map[interface{}]int{}, buf: []byte(magic)}
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			perr, ok := rcv.(persistError)
+			if !ok {
+				panic(rcv)
+			}
+			data, err = nil, perr.err
+		}
+	}()
+	p.root = v
+	p.value(v)
+	return p.buf, nil
+}
+
+// Unpersist restores a value serialized by `LState.Persist` in this state. The code of persisted functions and
+// the call frames of persisted threads are validated, so malformed data makes Unpersist fail instead of crashing the VM, but restored functions run with
+// the upvalues and globals the data gives them, so it should still only come from trusted sources.
+func (ls *LState) Unpersist(data []byte, opts *PersistOptions) (LValue, error) {
+	if opts == nil {
+		opts = &PersistOptions{}
+	}
+	if !bytes.HasPrefix(data, []byte(persistMagic)) {
+		return LNil, errors.New("not a persisted value")
+	}
+	permanents := opts.Permanents
+	if permanents == nil {
+		permanents = ls.Permanents()
+	}
+	return ls.unpersist(data[len(persistMagic):], opts, permanents)
+}
+
+func (ls *LState) unpersist(data []byte, opts *PersistOptions, permanents map[LValue]string) (v LValue, err error) {
+	u := &unpersister{L: ls, opts: opts, data: data, permanents: map[string]LValue{}}
+	for value, name := range permanents {
+		u.permanents[name] = value
+	}
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			perr, ok := rcv.(persistError)
+			if !ok {
+				panic(rcv)
+			}
+			v, err = LNil, perr.err
+		}
+	}()
+	v = u.value()
+	if u.pos != len(u.data) {
+		u.fail("trailing data")
+	}
+	for _, uv := range u.upvalues {
+		if uv.index >= uv.reg.Top() {
+			u.fail("malformed upvalue")
+		}
+	}
+	return v, nil
+}
+
+type persistError struct{ err error }
+
+type persister struct {
+	L          *LState
+	opts       *PersistOptions
+	permanents map[LValue]string
+	root       LValue
+	ids        map[interface{}]int
+	buf        []byte
+}
+
+func (p *persister) fail(format string, args ...interface{}) {
+	panic(persistError{fmt.Errorf("can not persist "+format, args...)})
+}
+
+func (p *persister) byte(b byte) {
+	p.buf = append(p.buf, b)
+}
+
+func (p *persister) int(n int) {
+	p.buf = binary.AppendVarint(p.buf, int64(n))
+}
+
+func (p *persister) string(s string) {
+	p.int(len(s))
+	p.buf = append(p.buf, s...)
+}
+
+// ref writes a reference to obj if it has been written before. Otherwise obj is assigned the next id.
+func (p *persister) ref(obj interface{}) bool {
+	if id, ok := p.ids[obj]; ok {
+		p.byte(persistRef)
+		p.int(id)
+		return true
+	}
+	p.ids[obj] = len(p.ids)
+	return false
+}
+
+func (p *persister) value(v LValue) {
+	switch lv := v.(type) {
+	case nil, *LNilType:
+		p.byte(persistNil)
+		return
+	case LBool:
+		if lv {
+			p.byte(persistTrue)
+		} else {
+			p.byte(persistFalse)
+		}
+		return
+	case LNumber:
+		p.byte(persistNumber)
+		p.buf = binary.LittleEndian.AppendUint64(p.buf, math.Float64bits(float64(lv)))
+		return
+	case LString:
+		p.byte(persistString)
+		p.string(string(lv))
+		return
+	}
+	if name, ok := p.permanents[v]; ok && v != p.root {
+		p.byte(persistPermanent)
+		p.string(name)
+		return
+	}
+	switch lv := v.(type) {
+	case *LTable:
+		if p.ref(lv) {
+			return
+		}
+		p.byte(persistTable)
+		p.value(lv.Metatable)
+		lv.ForEach(func(key, value LValue) {
+			p.value(key)
+			p.value(value)
+		})
+		p.byte(persistNil)
+	case *LFunction:
+		if lv.IsG {
+			p.fail("Go function %s", lv.goFunctionName())
+		}
+		if p.ref(lv) {
+			return
+		}
+		p.byte(persistFunction)
+		p.proto(lv.Proto)
+		if lv.Env == nil {
+			p.value(LNil)
+		} else {
+			p.value(lv.Env)
+		}
+		p.int(len(lv.Upvalues))
+		for _, uv := range lv.Upvalues {
+			p.upvalue(uv)
+		}
+	case *LUserData:
+		if p.opts.PersistUserData == nil {
+			p.fail("userdata without PersistOptions.PersistUserData")
+		}
+		if p.ref(lv) {
+			return
+		}
+		data, err := p.opts.PersistUserData(p.L, lv)
+		if err != nil {
+			panic(persistError{err})
+		}
+		p.byte(persistUserData)
+		p.string(string(data))
+		p.value(lv.Metatable)
+	case *LState:
+		p.thread(lv)
+	default:
+		p.fail("a %s", v.Type().String())
+	}
+}
+
+func (p *persister) thread(th *LState) {
+	switch {
+	case th.goroutine != nil:
+		p.fail("a coroutine running on its own goroutine")
+	case th == p.L.G.CurrentThread || th.Parent != nil || th == p.L.G.MainThread:
+		p.fail("a running coroutine")
+	}
+	if p.ref(th) {
+		return
+	}
+	p.byte(persistThread)
+	p.value(boolValue(th.Dead))
+	p.value(boolValue(th.wrapped))
+	p.value(boolValue(th.isStarted()))
+	p.value(th.Env)
+	sp := 0
+	if !th.Dead {
+		sp = th.stack.Sp()
+	}
+	p.int(sp)
+	for i := 0; i < sp; i++ {
+		cf := th.stack.At(i)
+		if cf.Fn.IsG && th.isStarted() {
+			p.fail("a coroutine suspended in a Go function")
+		}
+		p.value(cf.Fn)
+		for _, n := range [...]int{cf.Pc, cf.Base, cf.LocalBase, cf.ReturnBase, cf.NArgs, cf.NRet, cf.TailCall} {
+			p.int(n)
+		}
+	}
+	top := 0
+	if !th.Dead {
+		top = th.reg.Top()
+	}
+	p.int(top)
+	for i := 0; i < top; i++ {
+		p.value(th.reg.array[i])
+	}
+}
+
+func (p *persister) upvalue(uv *Upvalue) {
+	if uv == nil {
+		p.byte(persistNil)
+		return
+	}
+	if p.ref(uv) {
+		return
+	}
+	if uv.IsClosed() {
+		p.byte(persistUpvalue)
+		p.value(uv.value)
+		return
+	}
+	th, ok := uv.reg.handler.(*LState)
+	if !ok || th == p.L.G.CurrentThread || th.Parent != nil || th == p.L.G.MainThread {
+		p.fail("an upvalue of a running function")
+	}
+	p.byte(persistOpenUpvalue)
+	p.thread(th)
+	p.int(uv.index)
+}
+
+func (p *persister) proto(proto *FunctionProto) {
+	if p.ref(proto) {
+		return
+	}
+	p.byte(persistProto)
+	p.string(proto.SourceName)
+	for _, n := range [...]int{proto.LineDefined, proto.LastLineDefined, int(proto.NumUpvalues), int(proto.NumParameters), int(proto.IsVarArg), int(proto.NumUsedRegisters)} {
+		p.int(n)
+	}
+	p.int(len(proto.Code))
+	for _, inst := range proto.Code {
+		p.buf = binary.AppendUvarint(p.buf, uint64(inst))
+	}
+	p.int(len(proto.Constants))
+	for _, c := range proto.Constants {
+		p.value(c)
+	}
+	p.int(len(proto.FunctionPrototypes))
+	for _, fp := range proto.FunctionPrototypes {
+		p.proto(fp)
+	}
+	p.int(len(proto.DbgSourcePositions))
+	for _, pos := range proto.DbgSourcePositions {
+		p.int(pos)
+	}
+	p.int(len(proto.DbgLocals))
+	for _, local := range proto.DbgLocals {
+		p.string(local.Name)
+		p.int(local.StartPc)
+		p.int(local.EndPc)
+	}
+	p.int(len(proto.DbgCalls))
+	for _, call := range proto.DbgCalls {
+		p.string(call.Name)
+		p.int(call.Pc)
+	}
+	p.int(len(proto.DbgUpvalues))
+	for _, name := range proto.DbgUpvalues {
+		p.string(name)
+	}
+}
+
+func boolValue(b bool) LValue {
+	return LBool(b)
+}
+
+type unpersister struct {
+	L          *LState
+	opts       *PersistOptions
+	permanents map[string]LValue
+	objects    []interface{}
+	data       []byte
+	pos        int
+	// upvalues are the open upvalues, checked against the registers of their threads once all are restored.
+	upvalues []*Upvalue
+}
+
+func (u *unpersister) fail(format string, args ...interface{}) {
+	panic(persistError{fmt.Errorf("can not unpersist: "+format, args...)})
+}
+
+func (u *unpersister) byte() byte {
+	if u.pos >= len(u.data) {
+		u.fail("unexpected end of data")
+	}
+	b := u.data[u.pos]
+	u.pos++
+	return b
+}
+
+func (u *unpersister) int() int {
+	n, size := binary.Varint(u.data[u.pos:])
+	if size <= 0 {
+		u.fail("malformed integer")
+	}
+	u.pos += size
+	return int(n)
+}
+
+func (u *unpersister) count() int {
+	n := u.int()
+	if n < 0 || n > len(u.data)-u.pos {
+		u.fail("malformed length")
+	}
+	return n
+}
+
+func (u *unpersister) string() string {
+	n := u.count()
+	s := string(u.data[u.pos : u.pos+n])
+	u.pos += n
+	return s
+}
+
+func (u *unpersister) object() interface{} {
+	id := u.int()
+	if id < 0 || id >= len(u.objects) {
+		u.fail("malformed reference")
+	}
+	return u.objects[id]
+}
+
+func (u *unpersister) value() LValue {
+	switch tag := u.byte(); tag {
+	case persistNil:
+		return LNil
+	case persistFalse:
+		return LFalse
+	case persistTrue:
+		return LTrue
+	case persistNumber:
+		if len(u.data)-u.pos < 8 {
+			u.fail("unexpected end of data")
+		}
+		n := math.Float64frombits(binary.LittleEndian.Uint64(u.data[u.pos:]))
+		u.pos += 8
+		return LNumber(n)
+	case persistString:
+		return LString(u.string())
+	case persistRef:
+		v, ok := u.object().(LValue)
+		if !ok {
+			u.fail("malformed reference")
+		}
+		return v
+	case persistPermanent:
+		name := u.string()
+		v, ok := u.permanents[name]
+		if !ok {
+			u.fail("permanent %s not found", name)
+		}
+		return v
+	case persistTable:
+		tb := u.L.NewTable()
+		u.objects = append(u.objects, tb)
+		tb.Metatable = u.table(u.value())
+		for {
+			key := u.value()
+			if key == LNil {
+				break
+			}
+			tb.RawSet(key, u.value())
+		}
+		return tb
+	case persistFunction:
+		fn := &LFunction{}
+		u.objects = append(u.objects, fn)
+		fn.Proto = u.proto()
+		if env := u.value(); env != LNil {
+			fn.Env = u.table(env).(*LTable)
+		}
+		fn.Upvalues = make([]*Upvalue, u.count())
+		for i := range fn.Upvalues {
+			fn.Upvalues[i] = u.upvalue()
+		}
+		return fn
+	case persistUserData:
+		if u.opts.UnpersistUserData == nil {
+			u.fail("userdata without PersistOptions.UnpersistUserData")
+		}
+		ud := u.L.NewUserData()
+		u.objects = append(u.objects, ud)
+		value, err := u.opts.UnpersistUserData(u.L, []byte(u.string()))
+		if err != nil {
+			panic(persistError{err})
+		}
+		ud.Value = value
+		ud.Metatable = u.table(u.value())
+		return ud
+	case persistThread:
+		return u.thread()
+	default:
+		u.fail("unknown value type %d", tag)
+	}
+	return LNil
+}
+
+// table returns v as a metatable or environment.
+func (u *unpersister) table(v LValue) LValue {
+	if v == LNil {
+		return v
+	}
+	tb, ok := v.(*LTable)
+	if !ok {
+		u.fail("a table expected, got %s", v.Type().String())
+	}
+	return tb
+}
+
+func (u *unpersister) thread() *LState {
+	th, _ := u.L.NewThread()
+	u.objects = append(u.objects, th)
+	th.Dead = u.value() == LTrue
+	th.wrapped = u.value() == LTrue
+	started := u.value() == LTrue
+	if env, ok := u.table(u.value()).(*LTable); ok {
+		th.Env = env
+	}
+	sp := u.count()
+	if started && sp == 0 || !started && sp > 1 || sp > th.Options.CallStackSize {
+		u.fail("malformed call stack")
+	}
+	for i := 0; i < sp; i++ {
+		fn, ok := u.value().(*LFunction)
+		if !ok {
+			u.fail("a function expected in a call frame")
+		}
+		cf := callFrame{Fn: fn, Parent: th.stack.Last()}
+		for _, n := range [...]*int{&cf.Pc, &cf.Base, &cf.LocalBase, &cf.ReturnBase, &cf.NArgs, &cf.NRet, &cf.TailCall} {
+			*n = u.int()
+		}
+		if !validCallFrame(&cf, started) {
+			u.fail("malformed call frame")
+		}
+		th.stack.Push(cf)
+	}
+	top := u.count()
+	if started {
+		// the suspended function accesses its registers directly, also those above the top
+		last := th.stack.Last()
+		size := last.LocalBase + int(last.Fn.Proto.NumUsedRegisters)
+		if top < last.LocalBase || top > size || size > th.reg.maxSize {
+			u.fail("malformed registers")
+		}
+		th.reg.checkSize(size)
+	} else if top != 0 {
+		u.fail("malformed registers")
+	}
+	for i := 0; i < top; i++ {
+		th.reg.Push(u.value())
+	}
+	if started {
+		th.currentFrame = th.stack.Last()
+	}
+	return th
+}
+
+func (u *unpersister) upvalue() *Upvalue {
+	switch tag := u.byte(); tag {
+	case persistNil:
+		return nil
+	case persistRef:
+		uv, ok := u.object().(*Upvalue)
+		if !ok {
+			u.fail("malformed reference")
+		}
+		return uv
+	case persistUpvalue:
+		uv := &Upvalue{closed: true}
+		u.objects = append(u.objects, uv)
+		uv.value = u.value()
+		return uv
+	case persistOpenUpvalue:
+		uv := &Upvalue{}
+		u.objects = append(u.objects, uv)
+		th, ok := u.value().(*LState)
+		if !ok {
+			u.fail("a thread expected for an open upvalue")
+		}
+		uv.reg = th.reg
+		uv.index = u.int()
+		if uv.index < 0 {
+			u.fail("malformed upvalue")
+		}
+		u.upvalues = append(u.upvalues, uv)
+		th.insertUpvalue(uv)
+		return uv
+	default:
+		u.fail("unknown upvalue type %d", tag)
+	}
+	return nil
+}
+
+// validCallFrame reports whether cf has the layout the VM gives the frames of a thread, started or not. A thread
+// that is not started has the frame of its function only, and started threads have the frames of the Lua
+// functions they are suspended in, each calling the next from one of its registers.
+func validCallFrame(cf *callFrame, started bool) bool {
+	if !started {
+		return cf.Pc == 0 && cf.Base == 0 && cf.LocalBase == 1 && cf.ReturnBase == 0 && cf.NArgs == 0 &&
+			cf.NRet == MultRet && cf.TailCall == 0
+	}
+	proto := cf.Fn.Proto
+	if proto == nil || cf.Pc < 1 || cf.Pc > len(proto.Code) || cf.Base < 0 || cf.ReturnBase < 0 ||
+		cf.ReturnBase > cf.Base || cf.NArgs < 0 || cf.NRet < MultRet || cf.TailCall < 0 {
+		return false
+	}
+	// the arguments of functions with variable arguments are moved above their parameters
+	shift := 0
+	if proto.IsVarArg&VarArgIsVarArg != 0 {
+		shift = intMax(cf.NArgs, int(proto.NumParameters))
+	}
+	if cf.LocalBase != cf.Base+1+shift {
+		return false
+	}
+	parent := cf.Parent
+	return parent == nil ||
+		cf.Base >= parent.LocalBase && cf.Base < parent.LocalBase+int(parent.Fn.Proto.NumUsedRegisters)
+}
+
+// insertUpvalue adds the open upvalue uv to the open upvalues of ls, which are sorted by their index.
+func (ls *LState) insertUpvalue(uv *Upvalue) {
+	var prev *Upvalue
+	for next := ls.uvcache; next != nil && next.index < uv.index; next = next.next {
+		prev = next
+	}
+	if prev == nil {
+		uv.next = ls.uvcache
+		ls.uvcache = uv
+	} else {
+		uv.next = prev.next
+		prev.next = uv
+	}
+}
+
+func (u *unpersister) proto() *FunctionProto {
+	switch tag := u.byte(); tag {
+	case persistRef:
+		proto, ok := u.object().(*FunctionProto)
+		if !ok {
+			u.fail("malformed reference")
+		}
+		return proto
+	case persistProto:
+	default:
+		u.fail("a function prototype expected")
+	}
+	proto := &FunctionProto{}
+	u.objects = append(u.objects, proto)
+	proto.SourceName = u.string()
+	proto.LineDefined = u.int()
+	proto.LastLineDefined = u.int()
+	proto.NumUpvalues = uint8(u.int())
+	proto.NumParameters = uint8(u.int())
+	proto.IsVarArg = uint8(u.int())
+	proto.NumUsedRegisters = uint8(u.int())
+	proto.Code = make([]uint32, u.count())
+	for i := range proto.Code {
+		inst, size := binary.Uvarint(u.data[u.pos:])
+		if size <= 0 || inst > math.MaxUint32 {
+			u.fail("malformed instruction")
+		}
+		u.pos += size
+		proto.Code[i] = uint32(inst)
+	}
+	proto.Constants = make([]LValue, u.count())
+	proto.stringConstants = make([]string, len(proto.Constants))
+	for i := range proto.Constants {
+		proto.Constants[i] = u.value()
+		if s, ok := proto.Constants[i].(LString); ok {
+			proto.stringConstants[i] = string(s)
+		}
+	}
+	proto.FunctionPrototypes = make([]*FunctionProto, u.count())
+	for i := range proto.FunctionPrototypes {
+		proto.FunctionPrototypes[i] = u.proto()
+	}
+	proto.DbgSourcePositions = make([]int, u.count())
+	for i := range proto.DbgSourcePositions {
+		proto.DbgSourcePositions[i] = u.int()
+	}
+	proto.DbgLocals = make([]*DbgLocalInfo, u.count())
+	for i := range proto.DbgLocals {
+		proto.DbgLocals[i] = &DbgLocalInfo{Name: u.string(), StartPc: u.int(), EndPc: u.int()}
+	}
+	proto.DbgCalls = make([]DbgCall, u.count())
+	for i := range proto.DbgCalls {
+		proto.DbgCalls[i] = DbgCall{Name: u.string(), Pc: u.int()}
+	}
+	proto.DbgUpvalues = make([]string, u.count())
+	for i := range proto.DbgUpvalues {
+		proto.DbgUpvalues[i] = u.string()
+	}
+	u.L.G.names.internNames(proto)
+	if err := validateProto(proto); err != nil {
+		u.fail("%v", err)
+	}
+	proto.assignInlineCaches()
+	return proto
+}
+
+// validateProto checks that the code of an unpersisted function prototype only refers to its constants,
+// upvalues and nested prototypes, jumps to instructions of its code, passes variable numbers of values from the
+// instruction that produces them to the one that consumes them like the compiler does and has table size hints
+// its code can fill, so that malformed data can not make the VM index out of range or allocate huge tables. The
+// number of registers of the prototype is raised to cover every register its code refers to. The nested
+// prototypes are checked when they are unpersisted.
+func validateProto(proto *FunctionProto) error {
+	code := proto.Code
+	nregs, nconsts := int(proto.NumUsedRegisters), len(proto.Constants)
+	if len(code) == 0 || opGetOpCode(code[len(code)-1]) != OP_RETURN {
+		return fmt.Errorf("function at line %d does not end with a return", proto.LineDefined)
+	}
+	if len(proto.DbgSourcePositions) != len(code) {
+		return fmt.Errorf("function at line %d has %d source positions for %d instructions",
+			proto.LineDefined, len(proto.DbgSourcePositions), len(code))
+	}
+	if int(proto.NumParameters) > nregs || int(proto.NumUpvalues) != len(proto.DbgUpvalues) {
+		return fmt.Errorf("function at line %d has malformed parameters or upvalues", proto.LineDefined)
+	}
+	// the largest table size hints the code of the function can fill: an array item for every register flushed
+	// by a SETLIST, and a hash item for every SETTABLE. The encoded hints are compared as they grow with the sizes
+	// and large ones overflow when decoded.
+	maxArray, maxHash := int2Fb(FieldsPerFlush*len(code)), int2Fb(len(code))
+	// instructions that take their values up to the top of the stack and words that are not instructions can
+	// not be jumped to
+	nojump := make([]bool, len(code))
+	var jumps [][2]int
+	// the first register of the values up to the top left by the previous instruction, or -1
+	top := -1
+	for pc := 0; pc < len(code); pc++ {
+		inst := code[pc]
+		op := opGetOpCode(inst)
+		if op > opCodeMax {
+			return fmt.Errorf("malformed instruction at %d", pc)
+		}
+		a, b, c, bx := opGetArgA(inst), opGetArgB(inst), opGetArgC(inst), opGetArgBx(inst)
+		reg := func(r int) bool {
+			if r >= nregs && r < maxRegisters {
+				nregs = r + 1
+			}
+			return r < maxRegisters
+		}
+		rk := func(r int) bool {
+			if opIsK(r) {
+				return opIndexK(r) < nconsts
+			}
+			return reg(r)
+		}
+		jump := func(offset int) bool {
+			jumps = append(jumps, [2]int{pc, pc + 1 + offset})
+			return pc+1+offset >= 0 && pc+1+offset < len(code)
+		}
+		upto := top
+		fromTop := func(first int) bool {
+			nojump[pc] = true
+			return upto >= first
+		}
+		top = -1
+		next := pc+1 < len(code)
+		// instructions fused with a jump and loops take the offset of the jump that follows them
+		jumpNext := next && (opGetOpCode(code[pc+1]) == OP_JMP || opGetOpCode(code[pc+1]) == OP_NOP)
+		ok := true
+		switch op {
+		case OP_MOVE, OP_UNM, OP_NOT, OP_LEN:
+			ok = reg(a) && reg(b)
+		case OP_TESTSET:
+			ok = reg(a) && reg(b) && jump(1)
+		case OP_MOVEN:
+			ok = reg(a) && reg(b) && pc+c < len(code)
+		case OP_LOADK, OP_GETGLOBAL, OP_SETGLOBAL:
+			ok = reg(a) && bx < nconsts
+		case OP_RETURNK:
+			ok = reg(a) && bx < nconsts && next && opGetOpCode(code[pc+1]) == OP_RETURN
+		case OP_LOADBOOL:
+			ok = reg(a) && (c == 0 || jump(1))
+		case OP_LOADNIL, OP_CONCAT:
+			ok = reg(a) && reg(b) && reg(c)
+		case OP_GETUPVAL, OP_SETUPVAL:
+			ok = reg(a) && b < int(proto.NumUpvalues)
+		case OP_GETTABLE, OP_GETTABLEKS:
+			ok = reg(a) && reg(b) && rk(c)
+		case OP_SETTABLE, OP_SETTABLEKS:
+			ok = reg(a) && rk(b) && rk(c)
+		case OP_NEWTABLE:
+			ok = reg(a) && b <= maxArray && c <= maxHash
+		case OP_SELF, OP_SELFCALL:
+			ok = reg(a+1) && reg(b) && rk(c) &&
+				(op == OP_SELF || next && opGetOpCode(code[pc+1]) == OP_CALL && opGetArgA(code[pc+1]) == a)
+		case OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_POW:
+			ok = reg(a) && rk(b) && rk(c)
+		case OP_EQ, OP_LT, OP_LE, OP_EQJMP, OP_LTJMP, OP_LEJMP:
+			ok = rk(b) && rk(c) && jump(1) && (op != OP_EQJMP && op != OP_LTJMP && op != OP_LEJMP || jumpNext)
+		case OP_TEST, OP_TESTJMP:
+			ok = reg(a) && jump(1) && (op == OP_TEST || jumpNext)
+		case OP_JMP, OP_NOP:
+			ok = jump(opGetArgSbx(inst))
+		case OP_FORPREP:
+			ok = reg(a+2) && jump(opGetArgSbx(inst))
+		case OP_FORLOOP:
+			ok = reg(a+3) && jump(opGetArgSbx(inst))
+		case OP_CALL, OP_TAILCALL:
+			ok = reg(a) && (b != 0 && reg(a+b-1) || b == 0 && fromTop(a+1))
+			// calls leave the top of the stack after their results
+			top = a
+		case OP_RETURN:
+			ok = b == 1 || b > 1 && reg(a+b-2) || b == 0 && fromTop(a)
+		case OP_VARARG:
+			// variable numbers of values start at the first free register
+			ok = a < maxRegisters
+			if b == 0 {
+				top = a
+			}
+		case OP_CLOSE:
+			ok = a < maxRegisters
+		case OP_TFORLOOP, OP_TFORLOOPI:
+			ok = reg(a+2+c) && jump(1) && jumpNext
+		case OP_SELECTVA:
+			ok = reg(a)
+			if c == 0 {
+				top = a
+			}
+		case OP_SETLIST:
+			ok = reg(a) && (b != 0 && reg(a+b) || b == 0 && fromTop(a+1))
+			if c == 0 {
+				// the index of the batch is stored in the next word
+				ok = ok && next
+				if next {
+					nojump[pc+1] = true
+				}
+				pc++
+			}
+		case OP_CLOSURE:
+			ok = reg(a) && bx < len(proto.FunctionPrototypes)
+			// the upvalues of the closure are described by the instructions that follow it
+			for i := 1; ok && i <= int(proto.FunctionPrototypes[bx].NumUpvalues); i++ {
+				if ok = pc+i < len(code); ok {
+					switch uv := code[pc+i]; opGetOpCode(uv) {
+					case OP_MOVE:
+						ok = reg(opGetArgB(uv))
+					case OP_GETUPVAL:
+						ok = opGetArgB(uv) < int(proto.NumUpvalues)
+					default:
+						ok = false
+					}
+				}
+			}
+		}
+		if !ok {
+			return fmt.Errorf("malformed instruction at %d", pc)
+		}
+	}
+	for _, jump := range jumps {
+		if nojump[jump[1]] {
+			return fmt.Errorf("malformed instruction at %d", jump[0])
+		}
+	}
+	proto.NumUsedRegisters = uint8(nregs)
+	return nil
+}
+
+/* }}} */
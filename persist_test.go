@@ -0,0 +1,238 @@
+package lua
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const persistWorkflow = `
+function workflow(name)
+  local steps = {}
+  local count = 0
+  local function step(s)
+    count = count + 1
+    steps[#steps + 1] = s
+    return coroutine.yield(string.format("%s:%d", s, count))
+  end
+  local answer = step("ask")
+  step("got " .. tostring(answer))
+  local f = function() return count end
+  step("done")
+  return name, table.concat(steps, ","), f()
+end
+`
+
+func TestPersistCoroutine(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, persistWorkflow)
+	co, _ := L.NewThread()
+	st, err, values := L.Resume(co, L.GetGlobal("workflow").(*LFunction), LString("w"))
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, ResumeYield, st)
+	errorIfNotEqual(t, LString("ask:1"), values[0])
+
+	data, err := L.Persist(co, nil)
+	errorIfNotNil(t, err)
+
+	for i := 0; i < 2; i++ {
+		// each restored copy continues independently
+		L2 := NewState()
+		errorIfScriptFail(t, L2, persistWorkflow)
+		v, err := L2.Unpersist(data, nil)
+		errorIfNotNil(t, err)
+		co2 := v.(*LState)
+		errorIfNotEqual(t, "suspended", L2.Status(co2))
+		st, err, values = L2.Resume(co2, nil, LNumber(42))
+		errorIfNotNil(t, err)
+		errorIfNotEqual(t, ResumeYield, st)
+		errorIfNotEqual(t, LString("got 42:2"), values[0])
+		_, _, values = L2.Resume(co2, nil)
+		errorIfNotEqual(t, LString("done:3"), values[0])
+		st, err, values = L2.Resume(co2, nil)
+		errorIfNotNil(t, err)
+		errorIfNotEqual(t, ResumeOK, st)
+		errorIfNotEqual(t, "w ask,got 42,done 3", values[0].String()+" "+values[1].String()+" "+values[2].String())
+		L2.Close()
+	}
+}
+
+func TestPersistFromLua(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("persist", L.NewFunction(func(L *LState) int {
+		data, err := L.Persist(L.CheckAny(1), nil)
+		if err != nil {
+			L.RaiseError("%s", err.Error())
+		}
+		L.Push(LString(data))
+		return 1
+	}))
+	L.SetGlobal("unpersist", L.NewFunction(func(L *LState) int {
+		v, err := L.Unpersist([]byte(L.CheckString(1)), nil)
+		if err != nil {
+			L.RaiseError("%s", err.Error())
+		}
+		L.Push(v)
+		return 1
+	}))
+	errorIfScriptFail(t, L, `
+	-- a coroutine that has not been started and shared, cyclic tables
+	local shared = {n = 1}
+	local function make()
+	  local t = {a = shared, b = shared, print = print}
+	  t.self = t
+	  setmetatable(t, {__index = function(_, k) return k .. "!" end})
+	  return {t = t, co = coroutine.create(function(x) coroutine.yield(x + t.a.n) end)}
+	end
+	local copy = unpersist(persist(make()))
+	assert(copy.t.a == copy.t.b and copy.t.self == copy.t and copy.t.a ~= shared)
+	assert(copy.t.print == print and copy.t.missing == "missing!")
+	local ok, v = coroutine.resume(copy.co, 1)
+	assert(ok and v == 2, tostring(v))
+
+	-- dead coroutines stay dead
+	local dead = coroutine.create(function() end)
+	coroutine.resume(dead)
+	assert(coroutine.status(unpersist(persist(dead))) == "dead")
+	`)
+	errorIfScriptNotFail(t, L, `coroutine.wrap(function() persist(coroutine.running()) end)()`, "running coroutine")
+	errorIfScriptNotFail(t, L, `persist({f = function() end, g = coroutine.wrap(function() end)})`, "Go function")
+	errorIfScriptNotFail(t, L, `local x = 1; persist(function() return x end)`, "upvalue of a running function")
+	errorIfScriptNotFail(t, L, `unpersist("junk")`, "not a persisted value")
+}
+
+func TestPersistUserData(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	mt := L.NewTypeMetatable("counter")
+	ud := L.NewUserData()
+	ud.Value = 7
+	ud.Metatable = mt
+	opts := &PersistOptions{
+		PersistUserData: func(L *LState, ud *LUserData) ([]byte, error) {
+			return []byte(strconv.Itoa(ud.Value.(int))), nil
+		},
+		UnpersistUserData: func(L *LState, data []byte) (interface{}, error) {
+			return strconv.Atoi(string(data))
+		},
+	}
+	tb := L.NewTable()
+	tb.RawSetString("ud", ud)
+	data, err := L.Persist(tb, opts)
+	errorIfNotNil(t, err)
+	v, err := L.Unpersist(data, opts)
+	errorIfNotNil(t, err)
+	ud2 := v.(*LTable).RawGetString("ud").(*LUserData)
+	errorIfNotEqual(t, 7, ud2.Value)
+	errorIfNotEqual(t, mt, ud2.Metatable)
+	errorIfFalse(t, ud2 != ud, "userdata is not copied")
+	_, err = L.Unpersist(data[:len(data)-2], opts)
+	errorIfNil(t, err)
+	_, err = L.Persist(tb, nil)
+	errorIfNotEqual(t, "can not persist userdata without PersistOptions.PersistUserData", err.Error())
+}
+
+func TestUnpersistMalformedCode(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	tamper := func(src string, patch func(proto *FunctionProto)) error {
+		fn, err := L.LoadString(src)
+		errorIfNotNil(t, err)
+		proto := *fn.Proto
+		proto.Code = append([]uint32(nil), proto.Code...)
+		patch(&proto)
+		data, err := L.Persist(L.NewFunctionFromProto(&proto), nil)
+		errorIfNotNil(t, err)
+		_, err = L.Unpersist(data, nil)
+		return err
+	}
+	errorIfNotNil(t, tamper(`local t = {1, 2, 3}; return #t`, func(*FunctionProto) {}))
+
+	cases := []struct {
+		src   string
+		op    int
+		patch func(inst *uint32)
+	}{
+		// a table size hint of about two billion array items
+		{`return {}`, OP_NEWTABLE, func(inst *uint32) { opSetArgB(inst, 0x1ff) }},
+		{`local a = 1; return a + 1`, OP_ADD, func(inst *uint32) { opSetArgB(inst, 255) }},
+		{`return print`, OP_GETGLOBAL, func(inst *uint32) { opSetArgBx(inst, 1000) }},
+		{`local t = {}; return t.x`, OP_GETTABLEKS, func(inst *uint32) { opSetArgC(inst, opRkAsk(100)) }},
+		{`while true do end`, OP_JMP, func(inst *uint32) { opSetArgSbx(inst, -100) }},
+		{`return function() end`, OP_CLOSURE, func(inst *uint32) { opSetArgBx(inst, 3) }},
+	}
+	for _, c := range cases {
+		err := tamper(c.src, func(proto *FunctionProto) {
+			for i := range proto.Code {
+				if opGetOpCode(proto.Code[i]) == c.op {
+					c.patch(&proto.Code[i])
+					return
+				}
+			}
+			t.Fatalf("%q has no %s", c.src, opProps[c.op].Name)
+		})
+		errorIfFalse(t, err != nil && strings.Contains(err.Error(), "malformed instruction"), "%q: %v", c.src, err)
+	}
+}
+
+func TestUnpersistMutatedData(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	fn, err := L.LoadString(`
+	local t = {1, 2, x = 3}
+	for i = 1, #t do t[i] = t[i] .. "a" end
+	local function f(a, ...) return select('#', ...), a, {...} end
+	return f(t, unpack(t))`)
+	errorIfNotNil(t, err)
+	data, err := L.Persist(fn, nil)
+	errorIfNotNil(t, err)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		mutated := append([]byte(nil), data...)
+		for j := 0; j < 1+rnd.Intn(3); j++ {
+			mutated[len(persistMagic)+rnd.Intn(len(data)-len(persistMagic))] = byte(rnd.Intn(256))
+		}
+		v, err := L.Unpersist(mutated, nil)
+		if f, ok := v.(*LFunction); ok && err == nil {
+			// whatever the restored function does, it must fail with an error instead of crashing
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			L.SetContext(ctx)
+			L.Push(f)
+			L.PCall(0, 0, nil)
+			L.RemoveContext()
+			cancel()
+		}
+	}
+}
+
+func TestUnpersistMutatedThread(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, persistWorkflow)
+	co, _ := L.NewThread()
+	L.Resume(co, L.GetGlobal("workflow").(*LFunction), LString("w"))
+	data, err := L.Persist(co, nil)
+	errorIfNotNil(t, err)
+	for i := len(persistMagic); i < len(data); i++ {
+		for _, b := range []byte{0, 1, 2, 3, 4, 0x7f, 0x80, 0xff} {
+			mutated := append([]byte(nil), data...)
+			mutated[i] = b
+			v, err := L.Unpersist(mutated, nil)
+			if th, ok := v.(*LState); ok && err == nil && L.Status(th) == "suspended" {
+				// the frames and registers of the restored thread are validated, so resuming it must not fail in Go
+				ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+				L.SetContext(ctx)
+				_, err, _ := L.Resume(th, nil, LNumber(1))
+				errorIfFalse(t, err == nil || !strings.Contains(err.Error(), "runtime error"),
+					"byte %d set to %d: %v", i, b, err)
+				L.RemoveContext()
+				cancel()
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package lua
+
+import (
+	"fmt"
+)
+
+/* security policy {{{ */
+
+// Policy decides whether scripts may perform sensitive operations(see `Options.Policy`). Allow is called before
+// the operation op is performed with its arguments, and returns nil to allow it or an error to deny it. A denied
+// operation raises an error in the script. The operations are:
+//
+//	io.open(path, mode)     opening a file by io.open, io.lines, io.input and io.output
+//	io.popen(command, mode) starting a process by io.popen
+//	io.tmpfile()            creating a temporary file
+//	os.execute(command)     starting a process by os.execute
+//	os.exit(code)           exiting the process
+//	os.getenv(name)         reading an environment variable
+//	os.setenv(name, value)  setting an environment variable
+//	os.remove(path)         removing a file
+//	os.rename(old, new)     renaming a file
+//	os.tmpname()            creating a temporary file name
+//	load(chunkname, mode)   loading a chunk by load, loadstring, loadfile, dofile, require and LState.LoadFile
+//	channel.make(buffer)    creating a channel
+//	sql.query(query)        running a query by sql.query and sql.queryrow(see `NewSQLLoader`)
+//	sql.exec(query)         running a statement by sql.exec
+type Policy interface {
+	Allow(L *LState, op string, args ...LValue) error
+}
+
+// PolicyFunc is an adapter to use a function as a Policy.
+type PolicyFunc func(L *LState, op string, args ...LValue) error
+
+// Allow calls fn.
+func (fn PolicyFunc) Allow(L *LState, op string, args ...LValue) error {
+	return fn(L, op, args...)
+}
+
+// PolicyError is the error raised when a policy denies an operation.
+type PolicyError struct {
+	Op   string
+	Args []LValue
+	Err  error
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s denied: %v", e.Op, e.Err)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// enforcePolicy raises an error if the policy of the state denies the operation op.
+func (ls *LState) enforcePolicy(op string, args ...LValue) {
+	if err := ls.checkPolicy(op, args...); err != nil {
+		ls.RaiseError("%s", err.Error())
+	}
+}
+
+// checkPolicy returns a *PolicyError if the policy of the state denies the operation op, for functions that
+// return errors instead of raising them.
+func (ls *LState) checkPolicy(op string, args ...LValue) error {
+	policy := ls.Options.Policy
+	if policy == nil {
+		return nil
+	}
+	if err := policy.Allow(ls, op, args...); err != nil {
+		ls.audit(AuditDenied, op, err, args...)
+		return &PolicyError{Op: op, Args: args, Err: err}
+	}
+	return nil
+}
+
+/* }}} */
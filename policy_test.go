@@ -0,0 +1,69 @@
+package lua
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	skipIfNoSys(t)
+	var ops []string
+	L := NewState(Options{Policy: PolicyFunc(func(L *LState, op string, args ...LValue) error {
+		strs := []string{op}
+		for _, arg := range args {
+			strs = append(strs, arg.String())
+		}
+		ops = append(ops, strings.Join(strs, " "))
+		switch {
+		case op == "os.execute", op == "channel.make" && args[0] == LNumber(0):
+			return errors.New("not allowed")
+		case op == "load" && strings.Contains(args[1].String(), "b"):
+			return errors.New("binary chunks are not allowed")
+		}
+		return nil
+	})})
+	defer L.Close()
+	L.SetGlobal("path", LString(filepath.Join(t.TempDir(), "out.txt")))
+	errorIfScriptFail(t, L, `
+local ok, msg = pcall(os.execute, "echo")
+assert(not ok and msg:find("os.execute denied: not allowed", 1, true))
+assert(io.open(path, "w")):close()
+assert(loadstring("return 1", "chunk", "t")() == 1)
+ok, msg = pcall(loadstring, "return 1")
+assert(not ok and msg:find("load denied: binary chunks are not allowed", 1, true))
+assert(channel.make(1))
+assert(not pcall(channel.make))
+`)
+	errorIfNotEqual(t, "os.execute echo|io.open "+L.GetGlobal("path").String()+" w|load chunk t|load <string> bt|channel.make 1|channel.make 0", strings.Join(ops, "|"))
+}
+
+func TestPolicyScriptFiles(t *testing.T) {
+	skipIfNoSys(t)
+	dir := t.TempDir()
+	errorIfNotNil(t, os.WriteFile(filepath.Join(dir, "mod.lua"), []byte("return 'mod'"), 0o644))
+	var loads []string
+	L := NewState(Options{Policy: PolicyFunc(func(L *LState, op string, args ...LValue) error {
+		if op == "load" {
+			loads = append(loads, args[0].String())
+			return errors.New("no files")
+		}
+		return nil
+	})})
+	defer L.Close()
+	L.SetGlobal("dir", LString(dir))
+	errorIfScriptFail(t, L, `
+package.path = dir .. "/?.lua"
+local ok, msg = pcall(require, "mod")
+assert(not ok and msg:find("load denied: no files", 1, true), msg)
+ok, msg = pcall(dofile, dir .. "/mod.lua")
+assert(not ok and msg:find("load denied: no files", 1, true), msg)
+`)
+	_, err := L.LoadFile(filepath.Join(dir, "mod.lua"))
+	var policyErr *PolicyError
+	errorIfFalse(t, errors.As(err.(*ApiError).Cause, &policyErr), "PolicyError expected, got %v", err)
+	path := filepath.Join(dir, "mod.lua")
+	errorIfNotEqual(t, path+"|"+path+"|"+path, strings.Join(loads, "|"))
+}
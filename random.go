@@ -0,0 +1,84 @@
+package lua
+
+import (
+	"math/bits"
+	randv2 "math/rand/v2"
+	"time"
+)
+
+/* random numbers {{{ */
+
+// xoshiro256 is the xoshiro256** generator math.random uses, like Lua 5.4.
+type xoshiro256 struct {
+	s [4]uint64
+}
+
+func (x *xoshiro256) Uint64() uint64 {
+	s := &x.s
+	result := bits.RotateLeft64(s[1]*5, 7) * 9
+	t := s[1] << 17
+	s[2] ^= s[0]
+	s[3] ^= s[1]
+	s[1] ^= s[2]
+	s[0] ^= s[3]
+	s[2] ^= t
+	s[3] = bits.RotateLeft64(s[3], 45)
+	return result
+}
+
+// Seed sets the state of the generator from the seed pair n1, n2, like math.randomseed(n1, n2) of Lua 5.4.
+func (x *xoshiro256) Seed(n1, n2 uint64) {
+	x.s = [4]uint64{n1, 0xff, n2, 0}
+	for i := 0; i < 16; i++ {
+		x.Uint64() // discard initial values to "spread" the seed
+	}
+}
+
+// newRandom returns the generator of a state, seeded with `Options.RandomSeed` or a random seed pair.
+func newRandom(seed []int64) *xoshiro256 {
+	var n1, n2 int64
+	switch len(seed) {
+	case 0:
+		n1, n2 = randomSeeds()
+	case 1:
+		n1 = seed[0]
+	default:
+		n1, n2 = seed[0], seed[1]
+	}
+	x := &xoshiro256{}
+	x.Seed(uint64(n1), uint64(n2))
+	return x
+}
+
+// randomSeeds returns a seed pair for math.randomseed called without arguments. Both seeds are exactly
+// representable as numbers.
+func randomSeeds() (int64, int64) {
+	return time.Now().Unix(), randv2.Int64N(1 << 53)
+}
+
+// randomFloat converts a random integer into a float in [0, 1).
+func randomFloat(ran uint64) float64 {
+	return float64(ran>>11) * (0.5 / (1 << 52))
+}
+
+// projectRandom projects a random integer into the interval [0, n], drawing more integers from next if needed
+// to keep the result uniform.
+func projectRandom(ran, n uint64, next func() uint64) uint64 {
+	if n&(n+1) == 0 { // n+1 is a power of 2
+		return ran & n
+	}
+	lim := n
+	// compute the smallest 2^b-1 not smaller than n
+	lim |= lim >> 1
+	lim |= lim >> 2
+	lim |= lim >> 4
+	lim |= lim >> 8
+	lim |= lim >> 16
+	lim |= lim >> 32
+	for ran &= lim; ran > n; ran &= lim {
+		ran = next()
+	}
+	return ran
+}
+
+/* }}} */
@@ -0,0 +1,64 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestXoshiro256(t *testing.T) {
+	x := &xoshiro256{s: [4]uint64{1, 2, 3, 4}}
+	for _, expected := range []uint64{11520, 0, 1509978240, 1215971899390074240} {
+		errorIfNotEqual(t, expected, x.Uint64())
+	}
+}
+
+func TestMathRandom(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+local a, b = math.randomseed(7)
+assert(a == 7 and b == 0)
+local first = {math.random(), math.random(0), math.random(10), math.random(-2^40, 2^40)}
+math.randomseed(7, 0)
+local second = {math.random(), math.random(0), math.random(10), math.random(-2^40, 2^40)}
+for i = 1, #first do assert(first[i] == second[i]) end
+assert(first[1] >= 0 and first[1] < 1)
+assert(first[2] == math.floor(first[2]))
+assert(first[3] >= 1 and first[3] <= 10 and first[3] == math.floor(first[3]))
+assert(first[4] >= -2^40 and first[4] <= 2^40)
+for i = 1, 100 do
+  local v = math.random(-3, 3)
+  assert(v >= -3 and v <= 3)
+end
+assert(math.random(3, 3) == 3)
+a, b = math.randomseed()
+assert(type(a) == "number" and type(b) == "number")
+`)
+	errorIfScriptNotFail(t, L, `math.random(2, 1)`, "interval is empty")
+	errorIfScriptNotFail(t, L, `math.random(1, 2, 3)`, "wrong number of arguments")
+}
+
+func TestStateRandom(t *testing.T) {
+	run := func(L *LState) LValue {
+		errorIfNotNil(t, L.DoString(`return math.random(0)`))
+		v := L.Get(-1)
+		L.Pop(1)
+		return v
+	}
+	L1 := NewState(Options{RandomSeed: []int64{42, 1}})
+	defer L1.Close()
+	L2 := NewState(Options{RandomSeed: []int64{42, 1}})
+	defer L2.Close()
+	first := run(L1)
+	errorIfNotEqual(t, first, run(L2))
+
+	// seeding a state does not affect other states
+	errorIfNotNil(t, L2.DoString(`math.randomseed(1)`))
+	second := run(L1)
+	errorIfNotNil(t, L2.DoString(`math.randomseed(42, 1)`))
+	errorIfNotEqual(t, first, run(L2))
+	errorIfNotEqual(t, second, run(L2))
+
+	co, _ := L1.NewThread()
+	errorIfNotNil(t, L1.DoString(`math.randomseed(42, 1)`))
+	errorIfNotEqual(t, first, run(co))
+}
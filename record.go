@@ -0,0 +1,191 @@
+package lua
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+/* record & replay {{{ */
+
+const (
+	recordModeNone = iota
+	recordModeRecord
+	recordModeReplay
+)
+
+// RecordedEvent is a nondeterministic input captured while recording.
+type RecordedEvent struct {
+	// Source identifies where the input came from, e.g. "os.time" or "math.random".
+	Source string
+	// Values are the values that have been observed.
+	Values []LValue
+}
+
+// Recording is a sequence of nondeterministic inputs(the current time, random numbers, channel receives and
+// results of Go functions wrapped with `RecordedGFunction`) of a script execution. A recording made with
+// `LState.StartRecording` can be fed back with `LState.StartReplay` to reproduce the execution.
+//
+// Recordings that only contain nil, booleans, numbers and strings can be encoded to and decoded from JSON.
+type Recording struct {
+	Events []RecordedEvent
+	pos    int
+}
+
+type jsonRecordedEvent struct {
+	Source string        `json:"source"`
+	Values []interface{} `json:"values"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (rec *Recording) MarshalJSON() ([]byte, error) {
+	events := make([]jsonRecordedEvent, 0, len(rec.Events))
+	for _, ev := range rec.Events {
+		values := make([]interface{}, len(ev.Values))
+		for i, v := range ev.Values {
+			switch lv := v.(type) {
+			case *LNilType:
+				values[i] = nil
+			case LBool:
+				values[i] = bool(lv)
+			case LString:
+				values[i] = string(lv)
+			case LNumber:
+				if math.IsNaN(float64(lv)) || math.IsInf(float64(lv), 0) {
+					return nil, fmt.Errorf("can not encode %v recorded by %s", lv, ev.Source)
+				}
+				values[i] = float64(lv)
+			default:
+				return nil, fmt.Errorf("can not encode a %s value recorded by %s", v.Type().String(), ev.Source)
+			}
+		}
+		events = append(events, jsonRecordedEvent{Source: ev.Source, Values: values})
+	}
+	return json.Marshal(events)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (rec *Recording) UnmarshalJSON(data []byte) error {
+	var events []jsonRecordedEvent
+	if err := json.Unmarshal(data, &events); err != nil {
+		return err
+	}
+	rec.Events = make([]RecordedEvent, 0, len(events))
+	rec.pos = 0
+	for _, ev := range events {
+		values := make([]LValue, len(ev.Values))
+		for i, v := range ev.Values {
+			switch gv := v.(type) {
+			case nil:
+				values[i] = LNil
+			case bool:
+				values[i] = LBool(gv)
+			case string:
+				values[i] = LString(gv)
+			case float64:
+				values[i] = LNumber(gv)
+			default:
+				return fmt.Errorf("invalid value recorded by %s", ev.Source)
+			}
+		}
+		rec.Events = append(rec.Events, RecordedEvent{Source: ev.Source, Values: values})
+	}
+	return nil
+}
+
+// StartRecording starts capturing the nondeterministic inputs of this state and all coroutines sharing its
+// globals. It returns the recording that events are appended to.
+func (ls *LState) StartRecording() *Recording {
+	rec := &Recording{}
+	ls.G.recording = rec
+	ls.G.recordMode = recordModeRecord
+	return rec
+}
+
+// StartReplay feeds the events of rec back instead of reading the current time, generating random numbers,
+// receiving from channels and calling recorded Go functions. An error is raised if the script asks for an input
+// that does not match the next recorded event.
+func (ls *LState) StartReplay(rec *Recording) {
+	rec.pos = 0
+	ls.G.recording = rec
+	ls.G.recordMode = recordModeReplay
+}
+
+// StopRecording stops recording or replaying and returns the recording that was in use, if any.
+func (ls *LState) StopRecording() *Recording {
+	rec := ls.G.recording
+	ls.G.recording = nil
+	ls.G.recordMode = recordModeNone
+	return rec
+}
+
+// Nondeterministic returns the values computed by fn. While recording, the values are appended to the recording
+// as an event of the given source. While replaying, fn is not called and the values of the next recorded event
+// are returned instead.
+func (ls *LState) Nondeterministic(source string, fn func() []LValue) []LValue {
+	values, _ := ls.recordOrReplay(source, fn)
+	return values
+}
+
+func (ls *LState) recordOrReplay(source string, fn func() []LValue) ([]LValue, bool) {
+	rec := ls.G.recording
+	switch ls.G.recordMode {
+	case recordModeRecord:
+		values := fn()
+		rec.Events = append(rec.Events, RecordedEvent{Source: source, Values: append([]LValue(nil), values...)})
+		return values, false
+	case recordModeReplay:
+		if rec.pos >= len(rec.Events) {
+			ls.RaiseError("replay: no recorded event left for %s", source)
+		}
+		ev := rec.Events[rec.pos]
+		if ev.Source != source {
+			ls.RaiseError("replay: expected an event for %s, but the next recorded event is for %s", source, ev.Source)
+		}
+		rec.pos++
+		return ev.Values, true
+	}
+	return fn(), false
+}
+
+// RecordedGFunction wraps fn so that its results are captured while recording and fed back while replaying. fn
+// is not called while replaying, so it should be used for Go functions whose results depend on the outside
+// world(I/O, clocks, ...).
+func RecordedGFunction(source string, fn LGFunction) LGFunction {
+	return func(L *LState) int {
+		values, replayed := L.recordOrReplay(source, func() []LValue {
+			n := fn(L)
+			values := make([]LValue, n)
+			for i := 0; i < n; i++ {
+				values[i] = L.Get(-n + i)
+			}
+			return values
+		})
+		if replayed {
+			for _, v := range values {
+				L.Push(v)
+			}
+		}
+		return len(values)
+	}
+}
+
+// now returns the current time, or the recorded time while replaying.
+func (ls *LState) now(source string) time.Time {
+	if ls.G.recordMode == recordModeNone {
+		return ls.hostNow()
+	}
+	values := ls.Nondeterministic(source, func() []LValue {
+		t := ls.hostNow()
+		return []LValue{LNumber(t.Unix()), LNumber(t.Nanosecond())}
+	})
+	if len(values) != 2 {
+		ls.RaiseError("replay: invalid event for %s", source)
+	}
+	sec, _ := values[0].(LNumber)
+	nsec, _ := values[1].(LNumber)
+	return time.Unix(int64(sec), int64(nsec))
+}
+
+/* }}} */
@@ -0,0 +1,63 @@
+package lua
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const recordScript = `
+local ch = channel.make(1)
+ch:send(42)
+local ok, v = ch:receive()
+result = table.concat({os.time(), math.random(), math.random(10), os.date("%Y"), tostring(ok), v, fetch("x")}, ",")
+`
+
+func TestRecordReplay(t *testing.T) {
+	calls := 0
+	fetch := RecordedGFunction("fetch", func(L *LState) int {
+		calls++
+		L.Push(LString(L.CheckString(1) + strings.Repeat("!", calls)))
+		return 1
+	})
+
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("fetch", L.NewFunction(fetch))
+	rec := L.StartRecording()
+	errorIfScriptFail(t, L, recordScript)
+	errorIfNotEqual(t, rec, L.StopRecording())
+	recorded := L.GetGlobal("result").String()
+	errorIfNotEqual(t, 1, calls)
+
+	data, err := json.Marshal(rec)
+	errorIfNotNil(t, err)
+	replay := &Recording{}
+	errorIfNotNil(t, json.Unmarshal(data, replay))
+
+	L2 := NewState()
+	defer L2.Close()
+	L2.SetGlobal("fetch", L2.NewFunction(fetch))
+	L2.StartReplay(replay)
+	errorIfScriptFail(t, L2, recordScript)
+	errorIfNotEqual(t, recorded, L2.GetGlobal("result").String())
+	errorIfNotEqual(t, 1, calls)
+
+	L2.StartReplay(replay)
+	err = L2.DoString(`os.clock()`)
+	errorIfFalse(t, err != nil && strings.Contains(err.Error(), "expected an event for os.clock"), "unexpected error: %v", err)
+	L2.StartReplay(&Recording{})
+	err = L2.DoString(`os.time()`)
+	errorIfFalse(t, err != nil && strings.Contains(err.Error(), "no recorded event left for os.time"), "unexpected error: %v", err)
+	L2.StopRecording()
+	errorIfScriptFail(t, L2, `assert(os.time() > 0)`)
+}
+
+func TestRecordingEncodeError(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	rec := L.StartRecording()
+	L.Nondeterministic("table", func() []LValue { return []LValue{L.NewTable()} })
+	_, err := json.Marshal(rec)
+	errorIfFalse(t, err != nil && strings.Contains(err.Error(), "can not encode a table value recorded by table"), "unexpected error: %v", err)
+}
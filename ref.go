@@ -0,0 +1,120 @@
+package lua
+
+import (
+	"weak"
+)
+
+/* references {{{ */
+
+// Ref is a reference to a Lua value held by Go code(see `LState.Ref`).
+type Ref int
+
+const (
+	// NoRef is a reference to no value. Deref returns LNil for it, and Unref ignores it.
+	NoRef Ref = -2
+	// RefNil is the reference returned for nil values.
+	RefNil Ref = -1
+)
+
+// refTable holds the values referenced by Go code. Released references are reused by later ones.
+type refTable struct {
+	entries []refEntry
+	free    []Ref
+}
+
+type refEntry struct {
+	value LValue
+	// weak returns the value of a weak reference, or LNil if the value has been collected.
+	weak func() LValue
+}
+
+// Ref returns a reference to v that keeps v alive until it is released by `LState.Unref`, like luaL_ref. Go code
+// can hold the reference instead of the value, e.g. to call a Lua callback later, without storing the value in
+// the Registry table. A reference to nil is RefNil.
+func (ls *LState) Ref(v LValue) Ref {
+	if v == LNil {
+		return RefNil
+	}
+	return ls.G.refs.add(refEntry{value: v})
+}
+
+// WeakRef returns a reference to v that does not keep v alive. Once v is no longer reachable otherwise, it may
+// be collected, and Deref returns LNil for the reference. Strings, numbers, booleans and channels are not
+// collectable and are referenced like `LState.Ref` does. The reference itself must still be released by
+// `LState.Unref`.
+func (ls *LState) WeakRef(v LValue) Ref {
+	var w func() LValue
+	switch lv := v.(type) {
+	case *LNilType:
+		return RefNil
+	case *LTable:
+		w = weakValue(lv)
+	case *LFunction:
+		w = weakValue(lv)
+	case *LUserData:
+		w = weakValue(lv)
+	case *LState:
+		w = weakValue(lv)
+	case *LObject:
+		w = weakValue(lv)
+	default:
+		return ls.Ref(v)
+	}
+	return ls.G.refs.add(refEntry{weak: w})
+}
+
+// Deref returns the value referenced by r. Released references, NoRef, RefNil and weak references to collected
+// values return LNil.
+func (ls *LState) Deref(r Ref) LValue {
+	refs := &ls.G.refs
+	if r < 0 || int(r) >= len(refs.entries) {
+		return LNil
+	}
+	entry := refs.entries[r]
+	if entry.weak != nil {
+		return entry.weak()
+	}
+	if entry.value == nil {
+		return LNil
+	}
+	return entry.value
+}
+
+// Unref releases the reference r. r may be reused by later references and must not be used anymore.
+func (ls *LState) Unref(r Ref) {
+	refs := &ls.G.refs
+	if r < 0 || int(r) >= len(refs.entries) {
+		return
+	}
+	if entry := refs.entries[r]; entry.value == nil && entry.weak == nil {
+		return
+	}
+	refs.entries[r] = refEntry{}
+	refs.free = append(refs.free, r)
+}
+
+func (refs *refTable) add(entry refEntry) Ref {
+	if n := len(refs.free); n > 0 {
+		r := refs.free[n-1]
+		refs.free = refs.free[:n-1]
+		refs.entries[r] = entry
+		return r
+	}
+	refs.entries = append(refs.entries, entry)
+	return Ref(len(refs.entries) - 1)
+}
+
+func weakValue[T any, P interface {
+	*T
+	LValue
+}](v P) func() LValue {
+	p := weak.Make((*T)(v))
+	return func() LValue {
+		if v := p.Value(); v != nil {
+			return P(v)
+		}
+		return LNil
+	}
+}
+
+/* }}} */
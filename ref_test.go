@@ -0,0 +1,49 @@
+package lua
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestRef(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `function callback(x) return x * 2 end`)
+	r := L.Ref(L.GetGlobal("callback"))
+	L.SetGlobal("callback", LNil)
+	errorIfNotNil(t, L.CallByParam(P{Fn: L.Deref(r), NRet: 1}, LNumber(21)))
+	errorIfNotEqual(t, LNumber(42), L.Get(-1))
+	L.Pop(1)
+
+	errorIfNotEqual(t, RefNil, L.Ref(LNil))
+	errorIfNotEqual(t, LNil, L.Deref(RefNil))
+	errorIfNotEqual(t, LNil, L.Deref(NoRef))
+	s := L.Ref(LString("value"))
+	errorIfNotEqual(t, LString("value"), L.Deref(s))
+
+	L.Unref(r)
+	L.Unref(r)
+	L.Unref(NoRef)
+	errorIfNotEqual(t, LNil, L.Deref(r))
+	r2 := L.Ref(LNumber(1))
+	errorIfNotEqual(t, r, r2)
+	errorIfNotEqual(t, LNumber(1), L.Deref(r2))
+	errorIfNotEqual(t, LString("value"), L.Deref(s))
+}
+
+func TestWeakRef(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	kept := L.NewTable()
+	rkept := L.WeakRef(kept)
+	rcollected := L.WeakRef(L.NewTable())
+	rnum := L.WeakRef(LNumber(3))
+	runtime.GC()
+	runtime.GC()
+	errorIfNotEqual(t, kept, L.Deref(rkept))
+	errorIfNotEqual(t, LNil, L.Deref(rcollected))
+	errorIfNotEqual(t, LNumber(3), L.Deref(rnum))
+	runtime.KeepAlive(kept)
+	L.Unref(rcollected)
+	errorIfNotEqual(t, rcollected, L.Ref(LTrue))
+}
@@ -65,6 +65,10 @@ func testScriptCompile(t *testing.T, script string) {
 }
 
 func testScriptDir(t *testing.T, tests []string, directory string) {
+	testScriptDirOptions(t, tests, directory, false)
+}
+
+func testScriptDirOptions(t *testing.T, tests []string, directory string, optimize bool) {
 	if err := os.Chdir(directory); err != nil {
 		t.Error(err)
 	}
@@ -76,6 +80,7 @@ func testScriptDir(t *testing.T, tests []string, directory string) {
 			RegistrySize:        1024 * 20,
 			CallStackSize:       1024,
 			IncludeGoStackTrace: true,
+			OptimizeBytecode:    optimize,
 		})
 		L.SetMx(maxMemory)
 		if err := L.DoFile(script); err != nil {
@@ -141,13 +146,27 @@ func TestLocalVarFree(t *testing.T) {
 }
 
 func TestGlua(t *testing.T) {
+	skipIfNoSys(t)
 	testScriptDir(t, gluaTests, "_glua-tests")
 }
 
 func TestLua(t *testing.T) {
+	skipIfNoSys(t)
 	testScriptDir(t, luaTests, "_lua5.1-tests")
 }
 
+func TestGluaOptimized(t *testing.T) {
+	skipIfNoSys(t)
+	// os.lua expects this variable to be unset, but TestGlua may have set it already
+	os.Unsetenv("_____GLUATEST______")
+	testScriptDirOptions(t, gluaTests, "_glua-tests", true)
+}
+
+func TestLuaOptimized(t *testing.T) {
+	skipIfNoSys(t)
+	testScriptDirOptions(t, luaTests, "_lua5.1-tests", true)
+}
+
 func TestMergingLoadNilBug(t *testing.T) {
 	// there was a bug where a multiple load nils were being incorrectly merged, and the following code exposed it
 	s := `
@@ -230,3 +249,97 @@ func TestMergingLoadNil(t *testing.T) {
 		t.Fatalf("expected 1 LOADNIL instruction, found %d", count)
 	}
 }
+
+func TestIpairsFastLoop(t *testing.T) {
+	s := `
+		local t = {10, 20, 30, nil, 50}
+		local sum, n = 0, 0
+		for i, v, extra in ipairs(t) do
+			assert(extra == nil)
+			sum = sum + v
+			n = i
+			extra = "x"
+		end
+		assert(sum == 60 and n == 3)
+
+		local calls = 0
+		local orig = ipairs
+		ipairs = function(tb)
+			calls = calls + 1
+			return orig(tb)
+		end
+		for i in ipairs(t) do end
+		assert(calls == 1)
+		ipairs = function() return function(_, i) if i < 2 then return i + 1 end end, nil, 0 end
+		n = 0
+		for i in ipairs(t) do n = i end
+		assert(n == 2)
+	`
+	L := NewState()
+	defer L.Close()
+	if err := L.DoString(s); err != nil {
+		t.Error(err)
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(`for i, v in ipairs({}) do end`), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled, err := Compile(chunk, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, instr := range compiled.Code {
+		if opGetOpCode(instr) == OP_TFORLOOPI {
+			found = true
+		}
+	}
+	errorIfFalse(t, found, "expected a TFORLOOPI instruction")
+}
+
+func TestSelectVarArgFastPath(t *testing.T) {
+	s := `
+		local function count(...) return select('#', ...) end
+		assert(count() == 0 and count(nil, nil) == 2)
+		local function from(n, ...) return select(n, ...) end
+		local a, b, c = from(2, 1, 2, 3)
+		assert(a == 2 and b == 3 and c == nil)
+		assert(from(-1, 1, 2, 3) == 3)
+		assert(from(5, 1, 2) == nil)
+		local function second(...) local x = select(2, ...) return x end
+		assert(second(1, 2, 3) == 2 and second(1) == nil)
+		assert(not pcall(from, 0, 1))
+		assert(not pcall(from, -3, 1))
+		local ok, err = pcall(from, {}, 1)
+		assert(not ok and err:find("number or string expected"))
+
+		local orig = select
+		select = function(n, ...) return "other", n end
+		local x, y = count(1, 2)
+		assert(x == "other" and y == "#")
+		select = orig
+		assert(count(1, 2) == 2)
+	`
+	L := NewState()
+	defer L.Close()
+	if err := L.DoString(s); err != nil {
+		t.Error(err)
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(`local function f(...) return select('#', ...) end`), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled, err := Compile(chunk, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, instr := range compiled.FunctionPrototypes[0].Code {
+		if opGetOpCode(instr) == OP_SELECTVA {
+			found = true
+		}
+	}
+	errorIfFalse(t, found, "expected a SELECTVA instruction")
+}
@@ -0,0 +1,141 @@
+package lua
+
+import (
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/r0kyi/gopher-lua/ast"
+)
+
+/* sessions {{{ */
+
+// sessionChunkName is the chunk name of sources run by Session.DoString.
+const sessionChunkName = "<session>"
+
+// Session runs successive chunks that share their top level local variables, like the chunks entered into an
+// interactive interpreter or the cells of a notebook:
+//
+//	s := L.NewSession()
+//	s.DoString(`local x = 1`)
+//	s.DoString(`x = x + 1`) -- x is the local variable of the previous chunk
+//
+// Local variables declared at the top level of a chunk are kept by the session after the chunk returns, and
+// functions defined in a chunk see later assignments to them. Local variables declared in nested blocks are not
+// kept.
+type Session struct {
+	L      *LState
+	locals map[string]*Upvalue
+}
+
+// NewSession returns a new session running chunks in this state.
+func (ls *LState) NewSession() *Session {
+	return &Session{L: ls, locals: make(map[string]*Upvalue)}
+}
+
+// Load loads a chunk named name from reader, binding the top level local variables of earlier chunks of the
+// session to it. The local variables declared by the chunk are added to the session when the chunk is loaded.
+func (s *Session) Load(reader io.Reader, name string) (*LFunction, error) {
+	chunk, err := s.L.parseChunk(reader, name)
+	if err != nil {
+		return nil, newApiErrorE(ApiErrorSyntax, err)
+	}
+	return s.compile(chunk, name)
+}
+
+// DoString runs source in the session and returns its results. If source is an expression, e.g. "x + 1", its
+// value is returned, like interactive interpreters do.
+func (s *Session) DoString(source string) ([]LValue, error) {
+	fn, err := s.Load(strings.NewReader("return "+source), sessionChunkName)
+	if err != nil {
+		if fn, err = s.Load(strings.NewReader(source), sessionChunkName); err != nil {
+			return nil, err
+		}
+	}
+	return s.L.doFunction(fn)
+}
+
+// Get returns the value of the local variable name of the session, or LNil.
+func (s *Session) Get(name string) LValue {
+	if uv, ok := s.locals[name]; ok {
+		return uv.Value()
+	}
+	return LNil
+}
+
+// Set sets the local variable name of the session, declaring it if needed.
+func (s *Session) Set(name string, value LValue) {
+	s.local(name).SetValue(value)
+}
+
+// Names returns the names of the local variables of the session in alphabetical order.
+func (s *Session) Names() []string {
+	names := make([]string, 0, len(s.locals))
+	for name := range s.locals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Session) local(name string) *Upvalue {
+	uv, ok := s.locals[name]
+	if !ok {
+		uv = &Upvalue{value: LNil, closed: true}
+		s.locals[name] = uv
+	}
+	return uv
+}
+
+// compile compiles chunk with the top level local declarations turned into assignments of session locals, and
+// binds the session locals to the upvalues of the compiled function.
+func (s *Session) compile(chunk []ast.Stmt, name string) (*LFunction, error) {
+	declared := map[string]bool{}
+	stmts := make([]ast.Stmt, len(chunk))
+	for i, stmt := range chunk {
+		stmts[i] = stmt
+		local, ok := stmt.(*ast.LocalAssignStmt)
+		if !ok {
+			continue
+		}
+		assign := &ast.AssignStmt{Rhs: local.Exprs}
+		for _, lname := range local.Names {
+			declared[lname] = true
+			ident := &ast.IdentExpr{Value: lname}
+			ident.SetLine(local.Line())
+			ident.SetLastLine(local.Line())
+			assign.Lhs = append(assign.Lhs, ident)
+		}
+		if len(assign.Rhs) == 0 {
+			nilexpr := &ast.NilExpr{}
+			nilexpr.SetLine(local.Line())
+			nilexpr.SetLastLine(local.Line())
+			assign.Rhs = []ast.Expr{nilexpr}
+		}
+		assign.SetLine(local.Line())
+		assign.SetLastLine(local.LastLine())
+		stmts[i] = assign
+	}
+	names := make([]string, 0, len(s.locals)+len(declared))
+	for lname := range s.locals {
+		names = append(names, lname)
+	}
+	for lname := range declared {
+		if _, ok := s.locals[lname]; !ok {
+			names = append(names, lname)
+		}
+	}
+	fn, err := s.L.compileChunk(stmts, name, names)
+	if err != nil {
+		return nil, err
+	}
+	for lname := range declared {
+		s.local(lname)
+	}
+	for i, uvname := range fn.Proto.DbgUpvalues {
+		fn.Upvalues[i] = s.local(uvname)
+	}
+	return fn, nil
+}
+
+/* }}} */
@@ -0,0 +1,51 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	s := L.NewSession()
+	for _, src := range []string{
+		`local x, y = 1`,
+		`x = x + 1`,
+		`local function inc(n) x = x + (n or 1); return x end`,
+		`inc(10)`,
+		`local z`,
+		`do local hidden = 1 end`,
+	} {
+		_, err := s.DoString(src)
+		errorIfNotNil(t, err)
+	}
+	results, err := s.DoString(`x, y, z, hidden`)
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, 4, len(results))
+	errorIfNotEqual(t, LNumber(12), results[0])
+	errorIfNotEqual(t, LNil, results[1])
+	errorIfNotEqual(t, LNil, results[3])
+	errorIfNotEqual(t, LNumber(12), s.Get("x"))
+	errorIfNotEqual(t, "inc,x,y,z", strings.Join(s.Names(), ","))
+	errorIfNotEqual(t, LNil, L.GetGlobal("x"))
+
+	s.Set("w", LString("go"))
+	results, err = s.DoString(`w .. inc()`)
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, LString("go13"), results[0])
+
+	_, err = s.DoString(`local broken = (`)
+	errorIfFalse(t, err != nil && err.(*ApiError).Type == ApiErrorSyntax, "syntax error expected, got %v", err)
+	errorIfNotEqual(t, 5, len(s.Names()))
+	_, err = s.DoString(`error("failed")`)
+	errorIfFalse(t, err != nil && strings.Contains(err.Error(), "<session>:1: failed"), "unexpected error: %v", err)
+	errorIfNotEqual(t, 0, L.GetTop())
+
+	fn, err := s.Load(strings.NewReader("local a = x\nreturn a"), "cell")
+	errorIfNotNil(t, err)
+	L.Push(fn)
+	errorIfNotNil(t, L.PCall(0, 1, nil))
+	errorIfNotEqual(t, LNumber(13), L.Get(-1))
+	errorIfNotEqual(t, LNumber(13), s.Get("a"))
+}
@@ -0,0 +1,128 @@
+package lua
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+/* snapshots {{{ */
+
+// snapshotMagic starts the data written by Snapshot. The last byte is the version of the format.
+const snapshotMagic = "\x1bGLS\x01"
+
+// Snapshot captures the globals, the registry with the loaded modules, and the metatables of the basic types of
+// this state into a binary image, e.g. to set up a sandbox once and to instantiate it by `LState.RestoreSnapshot`
+// instead of running the set up code again:
+//
+//	image, err := warm.Snapshot(nil)
+//	...
+//	L := lua.NewState()
+//	err = L.RestoreSnapshot(image, nil)
+//
+// Tables and Lua functions are copied into the image, while Go functions, the metatables the libraries register
+// in the registry and, unless `PersistOptions.PersistUserData` is set, userdata are referred to by the path they
+// have in the globals or the registry, e.g. "_G.string.format". See `LState.Persist` for the values that can be
+// captured.
+func (ls *LState) Snapshot(opts *PersistOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &PersistOptions{}
+	}
+	permanents := opts.Permanents
+	if permanents == nil {
+		permanents = ls.snapshotPermanents(opts)
+	}
+	image := newLTable(0, 3)
+	image.RawSetString("globals", shallowCopy(ls.G.Global))
+	image.RawSetString("registry", shallowCopy(ls.G.Registry))
+	mts := newLTable(len(ls.G.builtinMts), 0)
+	for tp, mt := range ls.G.builtinMts {
+		mts.RawSetInt(tp, mt)
+	}
+	image.RawSetString("metatables", mts)
+	return ls.persist(image, opts, permanents, snapshotMagic)
+}
+
+// RestoreSnapshot replaces the globals, the registry and the metatables of the basic types of this state with the
+// ones captured by `LState.Snapshot`. This state must provide the Go functions and the values referred to by the
+// image under the same paths, e.g. by having been created with the same options and the same Go functions
+// registered. The image must only come from trusted sources.
+func (ls *LState) RestoreSnapshot(data []byte, opts *PersistOptions) error {
+	if opts == nil {
+		opts = &PersistOptions{}
+	}
+	if !bytes.HasPrefix(data, []byte(snapshotMagic)) {
+		return errors.New("not a snapshot")
+	}
+	if ls.currentFrame != nil {
+		return errors.New("can not restore a snapshot while the state is running")
+	}
+	permanents := opts.Permanents
+	if permanents == nil {
+		permanents = ls.snapshotPermanents(opts)
+	}
+	v, err := ls.unpersist(data[len(snapshotMagic):], opts, permanents)
+	if err != nil {
+		return err
+	}
+	image, ok := v.(*LTable)
+	if !ok {
+		return errors.New("malformed snapshot")
+	}
+	globals, ok1 := image.RawGetString("globals").(*LTable)
+	registry, ok2 := image.RawGetString("registry").(*LTable)
+	mts, ok3 := image.RawGetString("metatables").(*LTable)
+	if !ok1 || !ok2 || !ok3 {
+		return errors.New("malformed snapshot")
+	}
+	replaceContents(ls.G.Global, globals)
+	replaceContents(ls.G.Registry, registry)
+	ls.G.builtinMts = make(map[int]LValue)
+	mts.ForEach(func(key, mt LValue) {
+		if tp, ok := key.(LNumber); ok {
+			ls.G.builtinMts[int(tp)] = mt
+		}
+	})
+	return nil
+}
+
+// snapshotPermanents names the values of this state Snapshot does not copy.
+func (ls *LState) snapshotPermanents(opts *PersistOptions) map[LValue]string {
+	return ls.namePermanents(func(v LValue, name string) bool {
+		switch lv := v.(type) {
+		case *LFunction:
+			return lv.IsG
+		case *LUserData:
+			return opts.PersistUserData == nil
+		case *LTable:
+			// the metatables registered by the libraries, e.g. "_REGISTRY.FILE*"
+			key, ok := strings.CutPrefix(name, "_REGISTRY.")
+			return ok && !strings.Contains(key, ".") && key != "_LOADED" && key != "_LOADERS"
+		}
+		return false
+	})
+}
+
+func shallowCopy(tb *LTable) *LTable {
+	copied := newLTable(0, 0)
+	tb.ForEach(func(key, value LValue) {
+		copied.RawSet(key, value)
+	})
+	return copied
+}
+
+// replaceContents replaces the fields of tb by the fields of src.
+func replaceContents(tb, src *LTable) {
+	keys := []LValue{}
+	tb.ForEach(func(key, _ LValue) {
+		keys = append(keys, key)
+	})
+	for _, key := range keys {
+		tb.RawSet(key, LNil)
+	}
+	src.ForEach(func(key, value LValue) {
+		tb.RawSet(key, value)
+	})
+}
+
+/* }}} */
@@ -0,0 +1,61 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	warm := NewState()
+	defer warm.Close()
+	hello := func(L *LState) int {
+		L.Push(LString("hello " + L.CheckString(1)))
+		return 1
+	}
+	warm.SetGlobal("hello", warm.NewFunction(hello))
+	greet := func(L *LState) int {
+		errorIfNotNil(t, L.DoString(`return {greet = function(name) return hello(name) .. "!" end}`))
+		return 1
+	}
+	warm.PreloadModule("greet", greet)
+	errorIfScriptFail(t, warm, `
+	local greet = require("greet")
+	config = {limit = 10, tags = {"a", "b"}}
+	config.self = config
+	function string.shout(s) return s:upper() .. "!" end
+	local calls = 0
+	function count() calls = calls + 1; return calls end
+	count()
+	os.execute = nil
+	`)
+	image, err := warm.Snapshot(nil)
+	errorIfNotNil(t, err)
+
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("hello", L.NewFunction(hello))
+	L.PreloadModule("greet", greet)
+	L.SetGlobal("leftover", LTrue)
+	errorIfNotNil(t, L.RestoreSnapshot(image, nil))
+	errorIfScriptFail(t, L, `
+	assert(leftover == nil)
+	assert(config.limit == 10 and config.tags[2] == "b" and config.self == config)
+	assert(("hi"):shout() == "HI!")
+	assert(count() == 2)
+	assert(os.execute == nil)
+	assert(require("greet").greet("lua") == "hello lua!")
+	assert(package.loaded.greet == require("greet"))
+	assert(_G == _G._G and package.loaded._G == _G)
+	io.stdout:write("")
+	`)
+	// the original state is left alone
+	errorIfScriptFail(t, warm, `assert(count() == 2)`)
+
+	errorIfNotNil(t, L.RestoreSnapshot(image, nil))
+	errorIfScriptFail(t, L, `assert(count() == 2)`)
+
+	bare := NewState()
+	defer bare.Close()
+	bare.PreloadModule("greet", greet)
+	errorIfNotEqual(t, "can not unpersist: permanent _G.hello not found", bare.RestoreSnapshot(image, nil).Error())
+	errorIfNotEqual(t, "not a snapshot", bare.RestoreSnapshot([]byte("junk"), nil).Error())
+}
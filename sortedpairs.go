@@ -0,0 +1,96 @@
+package lua
+
+import (
+	"sort"
+)
+
+/* sorted iteration {{{ */
+
+// maxSortedTraversals limits the number of tables whose sorted keys are kept for traversals that have not reached
+// their end yet, e.g. loops left with break.
+const maxSortedTraversals = 16
+
+// sortedTraversal is the key order of a table traversed by next() or pairs() when `Options.SortedPairs` is set.
+// pos is the index of the key returned last, so that a traversal with next() takes linear time.
+type sortedTraversal struct {
+	keys []LValue
+	pos  int
+}
+
+// sortedNext is LTable.next visiting the keys of tb in sorted order: numbers in ascending order first, then
+// strings in byte order, then false and true, then other keys in the order of LTable.Next.
+func (ls *LState) sortedNext(tb *LTable, key LValue) (LValue, LValue, bool) {
+	g := ls.G
+	st := g.sortedTraversals[tb]
+	if key == LNil || st == nil || st.pos >= len(st.keys) || st.keys[st.pos] != key {
+		if g.sortedTraversals == nil || len(g.sortedTraversals) >= maxSortedTraversals {
+			g.sortedTraversals = make(map[*LTable]*sortedTraversal)
+		}
+		st = &sortedTraversal{keys: sortedTableKeys(tb), pos: -1}
+		g.sortedTraversals[tb] = st
+		if key != LNil {
+			st.pos = -1
+			for i, k := range st.keys {
+				if k == key {
+					st.pos = i
+					break
+				}
+			}
+			if st.pos < 0 && tableKeyRank(key) < 3 {
+				// key has been cleared since, the traversal continues with the keys sorted after it
+				st.pos = sort.Search(len(st.keys), func(i int) bool { return lessTableKey(key, st.keys[i]) }) - 1
+			} else if st.pos < 0 {
+				delete(g.sortedTraversals, tb)
+				return LNil, LNil, false
+			}
+		}
+	}
+	for st.pos++; st.pos < len(st.keys); st.pos++ {
+		k := st.keys[st.pos]
+		if v := tb.RawGet(k); v != LNil {
+			return k, v, true
+		}
+	}
+	delete(g.sortedTraversals, tb)
+	return LNil, LNil, true
+}
+
+func sortedTableKeys(tb *LTable) []LValue {
+	keys := make([]LValue, 0, len(tb.array)+len(tb.hash.entries))
+	tb.ForEach(func(key, _ LValue) {
+		keys = append(keys, key)
+	})
+	sort.SliceStable(keys, func(i, j int) bool {
+		return lessTableKey(keys[i], keys[j])
+	})
+	return keys
+}
+
+func tableKeyRank(key LValue) int {
+	switch key.Type() {
+	case LTNumber:
+		return 0
+	case LTString:
+		return 1
+	case LTBool:
+		return 2
+	}
+	return 3
+}
+
+func lessTableKey(a, b LValue) bool {
+	if ra, rb := tableKeyRank(a), tableKeyRank(b); ra != rb {
+		return ra < rb
+	}
+	switch av := a.(type) {
+	case LNumber:
+		return av < b.(LNumber)
+	case LString:
+		return av < b.(LString)
+	case LBool:
+		return !bool(av) && bool(b.(LBool))
+	}
+	return false
+}
+
+/* }}} */
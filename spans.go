@@ -0,0 +1,145 @@
+package lua
+
+import (
+	"context"
+	"sync"
+)
+
+/* spans {{{ */
+
+// Tracer starts spans of a distributed tracing system. It has the shape of the Start method of OpenTelemetry
+// tracers, so an OpenTelemetry tracer is adapted with a few lines:
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, lua.Span) {
+//		ctx, span := t.Tracer.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SetAttribute sets an attribute of the span. Values are strings or ints.
+	SetAttribute(key string, value interface{})
+	// RecordError records an error that ended the span.
+	RecordError(err error)
+	// End ends the span.
+	End()
+}
+
+// SpanTracer turns the call hooks of states into spans: a span for every top level call(DoString, PCall, ...)
+// and child spans for the Go functions called by scripts. The top level spans are children of the context of
+// the state(see `LState.SetContext`). Spans carry the attributes "lua.function", "lua.source" and "lua.line".
+//
+//	st := lua.NewSpanTracer(tracer, nil)
+//	L := lua.NewState(lua.Options{Hooks: st.Hooks()})
+type SpanTracer struct {
+	tracer Tracer
+	filter func(ev *CallEvent) bool
+
+	mu    sync.Mutex
+	spans map[*Global][]activeSpan
+}
+
+type activeSpan struct {
+	L     *LState
+	depth int
+	ctx   context.Context
+	span  Span
+}
+
+// NewSpanTracer returns a SpanTracer starting spans with tracer. filter selects the Go functions child spans are
+// started for; a nil filter selects all Go functions.
+func NewSpanTracer(tracer Tracer, filter func(ev *CallEvent) bool) *SpanTracer {
+	return &SpanTracer{tracer: tracer, filter: filter, spans: make(map[*Global][]activeSpan)}
+}
+
+// Hooks returns the call hooks to set in `Options.Hooks`. A SpanTracer may be shared by several states.
+func (st *SpanTracer) Hooks() CallHooks {
+	return CallHooks{
+		OnCall: st.onCall,
+		OnReturn: func(L *LState, ev *CallEvent) {
+			st.end(L, ev, nil)
+		},
+		OnError: func(L *LState, ev *CallEvent) {
+			st.end(L, ev, ev.Err)
+		},
+		OnYield: func(L *LState, ev *CallEvent) {
+			// the function calling coroutine.yield is reported, so the span of yield itself is ended here
+			st.end(L, &CallEvent{Depth: ev.Depth + 1}, nil)
+		},
+	}
+}
+
+// Context returns the context of the innermost span of L, or the context of L if no span is active. Go functions
+// called by scripts can pass it on to make their own spans children of the span of the script.
+func (st *SpanTracer) Context(L *LState) context.Context {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if spans := st.spans[L.G]; len(spans) > 0 {
+		return spans[len(spans)-1].ctx
+	}
+	return st.parentContext(L)
+}
+
+func (st *SpanTracer) parentContext(L *LState) context.Context {
+	if ctx := L.Context(); ctx != nil {
+		return ctx
+	}
+	return context.Background()
+}
+
+func (st *SpanTracer) onCall(L *LState, ev *CallEvent) {
+	toplevel := ev.Depth == 0 && L.Parent == nil
+	if !toplevel && (!ev.IsGo || (st.filter != nil && !st.filter(ev))) {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	spans := st.spans[L.G]
+	var ctx context.Context
+	if toplevel {
+		// spans left by calls that did not return to a hook, e.g. because of an unprotected error
+		for i := len(spans) - 1; i >= 0; i-- {
+			spans[i].span.End()
+		}
+		spans = spans[:0]
+		ctx = st.parentContext(L)
+	} else if len(spans) > 0 {
+		ctx = spans[len(spans)-1].ctx
+	} else {
+		ctx = st.parentContext(L)
+	}
+	ctx, span := st.tracer.Start(ctx, ev.FunctionName)
+	span.SetAttribute("lua.function", ev.FunctionName)
+	span.SetAttribute("lua.source", ev.Source)
+	span.SetAttribute("lua.line", ev.Line)
+	st.spans[L.G] = append(spans, activeSpan{L: L, depth: ev.Depth, ctx: ctx, span: span})
+}
+
+func (st *SpanTracer) end(L *LState, ev *CallEvent, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	spans := st.spans[L.G]
+	if len(spans) == 0 {
+		return
+	}
+	top := spans[len(spans)-1]
+	if top.L != L || top.depth != ev.Depth {
+		return
+	}
+	if err != nil {
+		top.span.RecordError(err)
+	}
+	top.span.End()
+	if spans = spans[:len(spans)-1]; len(spans) == 0 {
+		delete(st.spans, L.G)
+	} else {
+		st.spans[L.G] = spans
+	}
+}
+
+/* }}} */
@@ -0,0 +1,84 @@
+package lua
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type testSpanKey struct{}
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+type testSpan struct {
+	name, parent string
+	attrs        map[string]interface{}
+	err          error
+	ended        bool
+}
+
+func (t *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(testSpanKey{}).(string)
+	span := &testSpan{name: name, parent: parent, attrs: map[string]interface{}{}}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, testSpanKey{}, name), span
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) { s.attrs[key] = value }
+func (s *testSpan) RecordError(err error)                      { s.err = err }
+func (s *testSpan) End()                                       { s.ended = true }
+
+func (t *testTracer) String() string {
+	buf := []string{}
+	for _, s := range t.spans {
+		line := fmt.Sprintf("%s<-%s", s.name, s.parent)
+		if s.err != nil {
+			line += " error"
+		}
+		if !s.ended {
+			line += " open"
+		}
+		buf = append(buf, line)
+	}
+	return strings.Join(buf, "\n")
+}
+
+func TestSpanTracer(t *testing.T) {
+	tracer := &testTracer{}
+	st := NewSpanTracer(tracer, func(ev *CallEvent) bool {
+		return ev.FunctionName != "tostring"
+	})
+	L := NewState(Options{Hooks: st.Hooks()})
+	defer L.Close()
+	tracer.spans = nil // the libraries opened by NewState
+	L.SetContext(context.WithValue(context.Background(), testSpanKey{}, "request"))
+	L.SetGlobal("fetch", L.NewFunction(func(L *LState) int {
+		parent, _ := st.Context(L).Value(testSpanKey{}).(string)
+		L.Push(LString(parent))
+		return 1
+	}))
+	errorIfScriptFail(t, L, `
+	assert(fetch() == "fetch")
+	local co = coroutine.wrap(function() coroutine.yield(tostring(1)) end)
+	co()
+	co()
+	`)
+	errorIfScriptNotFail(t, L, `error("boom")`, "boom")
+	errorIfNotEqual(t, strings.Join([]string{
+		"main chunk<-request",
+		"fetch<-main chunk",
+		"assert<-main chunk",
+		"wrap<-main chunk",
+		"co<-main chunk",
+		"yield<-co",
+		"co<-main chunk",
+		"main chunk<-request error",
+		"error<-main chunk error",
+	}, "\n"), tracer.String())
+	errorIfNotEqual(t, "<string>", tracer.spans[0].attrs["lua.source"])
+	errorIfNotEqual(t, "[G]", tracer.spans[1].attrs["lua.source"])
+	errorIfNotEqual(t, "request", st.Context(L).Value(testSpanKey{}))
+}
@@ -0,0 +1,277 @@
+package lua
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/* sql library {{{ */
+
+// SQLOptions configures the sql library returned by `NewSQLLoader`.
+type SQLOptions struct {
+	// If `Timeout` is greater than 0, statements are canceled after `Timeout`. Statements are also canceled when
+	// the context of the state(see `LState.SetContext`) is done.
+	Timeout time.Duration
+	// If `NoDDL` is set, only statements starting with SELECT, INSERT, UPDATE, DELETE or WITH are sent to the
+	// database. Every statement of a query separated by ';' is checked. Queries with constructs that dialects
+	// read differently, i.e. '#' and '/*!' comments, '--' not followed by a space, dollar quotes and backslashes in
+	// quoted strings, are rejected too. The check is lexical and best-effort: a SELECT can still call functions
+	// that change the schema, so use the permissions of the database user to enforce it.
+	NoDDL bool
+}
+
+// NewSQLLoader returns the loader of a sql library running queries against db, which is not closed by the
+// library. Register it with `LState.PreloadModule`:
+//
+//	L.PreloadModule("sql", lua.NewSQLLoader(db, lua.SQLOptions{Timeout: time.Second, NoDDL: true}))
+//
+// Scripts then run parameterized statements, with the placeholders of the driver of db:
+//
+//	local sql = require("sql")
+//	local rows, err = sql.query("SELECT id, name FROM users WHERE age > ?", 30) -- {{id = 1, name = "alice"}, ...}
+//	local row = sql.queryrow("SELECT count(*) AS n FROM users")                  -- {n = 2}, or nil
+//	local affected, lastid = sql.exec("UPDATE users SET name = ? WHERE id = ?", "bob", 2)
+//
+// Parameters may be nil, booleans, numbers and strings. Rows are tables mapping column names to values. Database
+// errors are returned as nil and an error message. exec returns the number of affected rows, and the last
+// inserted id if the driver supports it.
+func NewSQLLoader(db *sql.DB, opts SQLOptions) LGFunction {
+	lib := &sqlLib{db: db, opts: opts}
+	return func(L *LState) int {
+		mod := L.SetFuncs(L.NewTable(), map[string]LGFunction{
+			"query":    lib.query,
+			"queryrow": lib.queryRow,
+			"exec":     lib.exec,
+		})
+		L.Push(mod)
+		return 1
+	}
+}
+
+type sqlLib struct {
+	db   *sql.DB
+	opts SQLOptions
+}
+
+// dataKeywords are the first keywords of statements accepted by `SQLOptions.NoDDL`.
+var dataKeywords = map[string]bool{
+	"DELETE": true, "INSERT": true, "SELECT": true, "UPDATE": true, "WITH": true,
+}
+
+func isSQLIdentChar(c byte) bool {
+	return c == '_' || c == '$' || '0' <= c && c <= '9' || 'a' <= c|0x20 && c|0x20 <= 'z'
+}
+
+// sqlStatementKeywords returns the first keyword of every statement of query, skipping comments and the contents
+// of quoted strings and identifiers. The keyword of a statement that does not start with a word is empty. It
+// fails on constructs that are comments, strings or code depending on the dialect.
+func sqlStatementKeywords(query string) ([]string, error) {
+	var keywords []string
+	start := true
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			if i+2 < len(query) && query[i+2] != ' ' && query[i+2] != '\t' && query[i+2] != '\n' && query[i+2] != '\r' {
+				return nil, fmt.Errorf("'--' comments must be followed by a space")
+			}
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case c == '#':
+			return nil, fmt.Errorf("'#' comments are not allowed")
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			if i+2 < len(query) && query[i+2] == '!' {
+				return nil, fmt.Errorf("executable comments are not allowed")
+			}
+			if end := strings.Index(query[i+2:], "*/"); end < 0 {
+				i = len(query)
+			} else {
+				i += end + 3
+			}
+		case c == '$' && (i == 0 || !isSQLIdentChar(query[i-1])) && i+1 < len(query) &&
+			(query[i+1] == '$' || isSQLIdentChar(query[i+1]) && (query[i+1] < '0' || query[i+1] > '9')):
+			return nil, fmt.Errorf("dollar quoted strings are not allowed")
+		case c == '\'' || c == '"' || c == '`':
+			for i++; i < len(query) && query[i] != c; i++ {
+				if query[i] == '\\' {
+					return nil, fmt.Errorf("backslashes in quoted strings are not allowed")
+				}
+			}
+			if start {
+				keywords = append(keywords, "")
+			}
+			start = false
+		case c == ';':
+			start = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '(':
+		default:
+			if start {
+				j := i
+				for j < len(query) && (query[j] == '_' || 'a' <= query[j]|0x20 && query[j]|0x20 <= 'z') {
+					j++
+				}
+				keywords = append(keywords, strings.ToUpper(query[i:j]))
+				start = false
+				if j > i {
+					i = j - 1
+				}
+			}
+		}
+	}
+	return keywords, nil
+}
+
+// prepare checks the statement at 1 and returns it with its parameters and the context to run it in.
+func (lib *sqlLib) prepare(L *LState, op string) (string, []interface{}, context.Context, context.CancelFunc) {
+	query := L.CheckString(1)
+	L.enforcePolicy(op, LString(query))
+	if lib.opts.NoDDL {
+		keywords, err := sqlStatementKeywords(query)
+		for _, keyword := range keywords {
+			if err == nil && !dataKeywords[keyword] {
+				if keyword == "" {
+					err = fmt.Errorf("statements must start with a keyword")
+				} else {
+					err = fmt.Errorf("%s statements are not allowed", keyword)
+				}
+			}
+		}
+		if err != nil {
+			L.audit(AuditDenied, op, err, LString(query))
+			L.RaiseError("%s", err.Error())
+		}
+	}
+	top := L.GetTop()
+	args := make([]interface{}, 0, top-1)
+	for i := 2; i <= top; i++ {
+		switch lv := L.Get(i).(type) {
+		case *LNilType:
+			args = append(args, nil)
+		case LBool:
+			args = append(args, bool(lv))
+		case LNumber:
+			if f := float64(lv); f == float64(int64(f)) {
+				args = append(args, int64(f))
+			} else {
+				args = append(args, f)
+			}
+		case LString:
+			args = append(args, string(lv))
+		default:
+			L.ArgError(i, "nil, boolean, number or string expected, got "+lv.Type().String())
+		}
+	}
+	ctx := L.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cancel := context.CancelFunc(func() {})
+	if lib.opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, lib.opts.Timeout)
+	}
+	return query, args, ctx, cancel
+}
+
+// sqlValue converts a value scanned from a column to an LValue.
+func sqlValue(v interface{}) LValue {
+	switch cv := v.(type) {
+	case nil:
+		return LNil
+	case bool:
+		return LBool(cv)
+	case int64:
+		return LNumber(cv)
+	case float64:
+		return LNumber(cv)
+	case string:
+		return LString(cv)
+	case []byte:
+		return LString(cv)
+	case time.Time:
+		return LString(cv.Format(time.RFC3339Nano))
+	}
+	return LString(fmt.Sprint(v))
+}
+
+// queryRows runs the statement at 1 and returns up to limit rows, or all rows if limit is negative.
+func (lib *sqlLib) queryRows(L *LState, op string, limit int) (*LTable, error) {
+	query, args, ctx, cancel := lib.prepare(L, op)
+	defer cancel()
+	rows, err := lib.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := L.NewTable()
+	values := make([]interface{}, len(columns))
+	dests := make([]interface{}, len(columns))
+	for i := range values {
+		dests[i] = &values[i]
+	}
+	for (limit < 0 || result.Len() < limit) && rows.Next() {
+		if err := rows.Scan(dests...); err != nil {
+			return nil, err
+		}
+		row := L.CreateTable(0, len(columns))
+		for i, column := range columns {
+			row.RawSetString(column, sqlValue(values[i]))
+		}
+		result.Append(row)
+	}
+	return result, rows.Err()
+}
+
+func (lib *sqlLib) query(L *LState) int {
+	rows, err := lib.queryRows(L, "sql.query", -1)
+	if err != nil {
+		L.Push(LNil)
+		L.Push(LString(err.Error()))
+		return 2
+	}
+	L.Push(rows)
+	return 1
+}
+
+func (lib *sqlLib) queryRow(L *LState) int {
+	rows, err := lib.queryRows(L, "sql.query", 1)
+	if err != nil {
+		L.Push(LNil)
+		L.Push(LString(err.Error()))
+		return 2
+	}
+	L.Push(rows.RawGetInt(1))
+	return 1
+}
+
+func (lib *sqlLib) exec(L *LState) int {
+	query, args, ctx, cancel := lib.prepare(L, "sql.exec")
+	defer cancel()
+	result, err := lib.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		L.Push(LNil)
+		L.Push(LString(err.Error()))
+		return 2
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		L.Push(LNil)
+		L.Push(LString(err.Error()))
+		return 2
+	}
+	L.Push(LNumber(affected))
+	if id, err := result.LastInsertId(); err == nil {
+		L.Push(LNumber(id))
+		return 2
+	}
+	return 1
+}
+
+/* }}} */
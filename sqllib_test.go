@@ -0,0 +1,163 @@
+package lua
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSQLDriver is a database/sql driver answering every query with the same rows. Queries containing "sleep"
+// block until they are canceled.
+type testSQLDriver struct {
+	statements []string
+	args       [][]driver.Value
+}
+
+func (d *testSQLDriver) Open(name string) (driver.Conn, error) { return &testSQLConn{d}, nil }
+
+type testSQLConn struct{ d *testSQLDriver }
+
+func (c *testSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return &testSQLStmt{c.d, query}, nil
+}
+func (c *testSQLConn) Close() error              { return nil }
+func (c *testSQLConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type testSQLStmt struct {
+	d     *testSQLDriver
+	query string
+}
+
+func (s *testSQLStmt) Close() error  { return nil }
+func (s *testSQLStmt) NumInput() int { return -1 }
+
+func (s *testSQLStmt) run(ctx context.Context, args []driver.NamedValue) error {
+	s.d.statements = append(s.d.statements, s.query)
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	s.d.args = append(s.d.args, values)
+	if strings.Contains(s.query, "sleep") {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if strings.Contains(s.query, "fail") {
+		return errors.New("syntax error")
+	}
+	return nil
+}
+
+func (s *testSQLStmt) Exec(args []driver.Value) (driver.Result, error) { panic("not used") }
+func (s *testSQLStmt) Query(args []driver.Value) (driver.Rows, error)  { panic("not used") }
+
+func (s *testSQLStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if err := s.run(ctx, args); err != nil {
+		return nil, err
+	}
+	return testSQLResult{}, nil
+}
+
+func (s *testSQLStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if err := s.run(ctx, args); err != nil {
+		return nil, err
+	}
+	return &testSQLRows{values: [][]driver.Value{
+		{int64(1), "alice", 1.5, []byte("raw"), time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), nil},
+		{int64(2), "bob", 2.5, []byte(""), time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), true},
+	}}, nil
+}
+
+type testSQLResult struct{}
+
+func (testSQLResult) LastInsertId() (int64, error) { return 42, nil }
+func (testSQLResult) RowsAffected() (int64, error) { return 3, nil }
+
+type testSQLRows struct {
+	values [][]driver.Value
+}
+
+func (r *testSQLRows) Columns() []string {
+	return []string{"id", "name", "score", "data", "created", "flag"}
+}
+func (r *testSQLRows) Close() error { return nil }
+func (r *testSQLRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+var testSQL = &testSQLDriver{}
+
+func init() {
+	sql.Register("luatest", testSQL)
+}
+
+func TestSQLLib(t *testing.T) {
+	db, err := sql.Open("luatest", "")
+	errorIfNotNil(t, err)
+	defer db.Close()
+	L := NewState()
+	defer L.Close()
+	L.PreloadModule("sql", NewSQLLoader(db, SQLOptions{Timeout: 20 * time.Millisecond, NoDDL: true}))
+	errorIfScriptFail(t, L, `
+local sql = require("sql")
+local rows = assert(sql.query("SELECT * FROM users WHERE age > ? AND name = ?", 30, "x"))
+assert(#rows == 2 and rows[1].id == 1 and rows[1].name == "alice" and rows[1].score == 1.5 and rows[1].data == "raw")
+assert(rows[1].created == "2024-01-02T03:04:05Z" and rows[1].flag == nil and rows[2].flag == true)
+local row = sql.queryrow("SELECT * FROM users")
+assert(row.name == "alice")
+local affected, id = sql.exec("UPDATE users SET name = ?", nil)
+assert(affected == 3 and id == 42)
+local r, err = sql.query("SELECT fail")
+assert(r == nil and err == "syntax error")
+r, err = sql.query("SELECT sleep(10)")
+assert(r == nil and string.find(err, "deadline exceeded"))
+`)
+	errorIfFalse(t, reflect.DeepEqual([]driver.Value{int64(30), "x"}, testSQL.args[0]), "unexpected args %v", testSQL.args[0])
+	errorIfFalse(t, reflect.DeepEqual([]driver.Value{nil}, testSQL.args[2]), "unexpected args %v", testSQL.args[2])
+
+	n := len(testSQL.statements)
+	errorIfScriptNotFail(t, L, `require("sql").exec("create table x (id int)")`, "CREATE statements are not allowed")
+	errorIfScriptNotFail(t, L, `require("sql").exec("SELECT ';'; /* x */ -- y\n DROP TABLE users")`, "DROP statements are not allowed")
+	errorIfScriptNotFail(t, L, `require("sql").exec("DO $$ EXECUTE 'DROP TABLE users' $$")`, "dollar quoted strings are not allowed")
+	errorIfScriptNotFail(t, L, `require("sql").exec("SELECT 1 /*! ; DROP TABLE users */")`, "executable comments are not allowed")
+	errorIfScriptNotFail(t, L, `require("sql").exec("SELECT 1 --1; DROP TABLE users")`, "'--' comments must be followed by a space")
+	errorIfScriptNotFail(t, L, `require("sql").exec("SELECT 1; 'x'")`, "statements must start with a keyword")
+	errorIfScriptNotFail(t, L, `require("sql").exec("EXECUTE x")`, "EXECUTE statements are not allowed")
+	errorIfScriptNotFail(t, L, `require("sql").query("SELECT ?", {})`, "nil, boolean, number or string expected, got table")
+	errorIfNotEqual(t, n, len(testSQL.statements))
+	errorIfScriptFail(t, L, `assert(require("sql").exec("SELECT 'drop table x' -- ; drop"))`)
+}
+
+func TestSQLStatementKeywords(t *testing.T) {
+	keywords, err := sqlStatementKeywords(" select 1; (insert into x values ('a;drop'))")
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, "SELECT,INSERT", strings.Join(keywords, ","))
+	keywords, err = sqlStatementKeywords("/* drop */ update x set y = \"; drop\" -- ; drop")
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, "UPDATE", strings.Join(keywords, ","))
+	keywords, err = sqlStatementKeywords("SELECT a$b FROM x WHERE y = $1; 'x'")
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, "SELECT,", strings.Join(keywords, ","))
+	for _, query := range []string{
+		"SELECT 1 /*! ; DROP TABLE x */",
+		"SELECT 'a\\''; DROP TABLE x; -- '",
+		"SELECT 1 --1; DROP TABLE x",
+		"SELECT 1 # ; DROP TABLE x",
+		"SELECT $$'$$; DROP TABLE x; -- '",
+		"SELECT $q$'$q$; DROP TABLE x; -- '",
+	} {
+		_, err := sqlStatementKeywords(query)
+		errorIfNil(t, err)
+	}
+}
@@ -1,7 +1,10 @@
 package lua
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -13,6 +16,125 @@ func TestLStateIsClosed(t *testing.T) {
 	errorIfNotEqual(t, true, L.IsClosed())
 }
 
+func TestLStateCloseFinalizers(t *testing.T) {
+	L := NewState()
+	var calls []string
+	L.SetGlobal("record", L.NewFunction(func(L *LState) int {
+		calls = append(calls, L.CheckString(1))
+		return 0
+	}))
+	errorIfScriptFail(t, L, `
+	local function resource(name)
+	  return setmetatable({name = name}, {
+	    __close = function(self, err) assert(err == nil); record("close " .. self.name) end,
+	    __gc = function(self) record("gc " .. self.name) end,
+	  })
+	end
+	a = resource("a")
+	b = resource("b")
+	setmetatable(b, getmetatable(b)) -- marked only once
+	setmetatable({}, {__gc = function() error("ignored") end})
+	setmetatable({}, {__gc = function() c = resource("c") end})
+	setmetatable({}, {}).__gc = true -- not marked
+	`)
+	L.Close()
+	errorIfNotEqual(t, "close c,gc c,close b,gc b,close a,gc a", strings.Join(calls, ","))
+	errorIfNotEqual(t, true, L.IsClosed())
+}
+
+func TestFinalizersOfUnreachableObjects(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	collected := 0
+	L.SetGlobal("collected", L.NewFunction(func(L *LState) int {
+		collected++
+		return 0
+	}))
+	errorIfScriptFail(t, L, `
+	local mt = {__gc = function(self) assert(self.n); collected() end}
+	for i = 1, 10000 do setmetatable({n = i}, mt) end
+	keep = setmetatable({n = 0}, mt)
+	`)
+	for i := 0; i < 200 && collected < 9900; i++ {
+		errorIfScriptFail(t, L, `collectgarbage()`)
+		time.Sleep(time.Millisecond)
+	}
+	errorIfFalse(t, collected >= 9900, "only %d objects finalized", collected)
+	// finalized objects are forgotten, the reachable one is kept
+	errorIfFalse(t, len(L.G.finalizers.live) == 10001-collected, "%d objects tracked", len(L.G.finalizers.live))
+	errorIfNotEqual(t, len(L.G.finalizers.live), len(L.G.finalizers.ids))
+	errorIfScriptFail(t, L, `assert(keep.n == 0)`)
+}
+
+func TestFinalizersOfCycles(t *testing.T) {
+	L := NewState()
+	var calls []string
+	L.SetGlobal("record", L.NewFunction(func(L *LState) int {
+		calls = append(calls, L.CheckString(1))
+		return 0
+	}))
+	errorIfScriptFail(t, L, `
+	local mt = {__gc = function(self) record(self.name) end}
+	local t = setmetatable({name = "cycle"}, mt)
+	t.self = t
+	setmetatable({name = "plain"}, mt)
+	`)
+	for i := 0; i < 200 && len(calls) == 0; i++ {
+		errorIfScriptFail(t, L, `collectgarbage()`)
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		errorIfScriptFail(t, L, `collectgarbage()`)
+	}
+	// the Go garbage collector does not collect objects with finalizers that reference themselves, they are only
+	// finalized by Close
+	errorIfNotEqual(t, "plain", strings.Join(calls, ","))
+	L.Close()
+	errorIfNotEqual(t, "plain,cycle", strings.Join(calls, ","))
+}
+
+func TestLStateCloseIdempotent(t *testing.T) {
+	L := NewState()
+	calls := 0
+	L.SetGlobal("record", L.NewFunction(func(L *LState) int {
+		calls++
+		return 0
+	}))
+	errorIfScriptFail(t, L, `setmetatable({}, {__gc = record})`)
+	ctx, cancel := context.WithCancel(context.Background())
+	co, _ := L.NewThread()
+	L.SetContext(ctx)
+	cancel()
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func() {
+			L.Close()
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+	L.Close()
+	co.Close()
+	co.Close()
+	errorIfNotEqual(t, 1, calls)
+}
+
+func TestThreadCloseCancelsContext(t *testing.T) {
+	skipIfNoSys(t)
+	L := NewState()
+	defer L.Close()
+	L.SetContext(context.Background())
+	errorIfScriptFail(t, L, `f = io.tmpfile()`)
+	co, cancel := L.NewThread()
+	defer cancel()
+	co.Close()
+	errorIfNotEqual(t, context.Canceled, co.Context().Err())
+	// closing a thread leaves the files of the state alone
+	errorIfScriptFail(t, L, `assert(f:write("x"))`)
+}
+
 func TestCallStackOverflowWhenFixed(t *testing.T) {
 	L := NewState(Options{
 		CallStackSize: 3,
@@ -66,6 +188,20 @@ func TestCallStackOverflowWhenAutoGrow(t *testing.T) {
     `, "stack overflow")
 }
 
+func TestPreloadExtraLibs(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	names := []string{"big", "decimal", "bit32", "bytes", "log", "ustring", "csv", "help"}
+	for _, name := range names {
+		errorIfNotEqual(t, LNil, L.GetGlobal(name))
+	}
+	errorIfScriptNotFail(t, L, `require("csv")`, "module csv not found")
+	L.PreloadExtraLibs()
+	for _, name := range names {
+		errorIfScriptFail(t, L, `assert(type(require("`+name+`")) == "table")`)
+	}
+}
+
 func TestSkipOpenLibs(t *testing.T) {
 	L := NewState(Options{SkipOpenLibs: true})
 	defer L.Close()
@@ -276,6 +412,22 @@ func TestConcat(t *testing.T) {
 	errorIfNotEqual(t, "a1c", L.Concat(LString("a"), LNumber(1), LString("c")))
 }
 
+func TestArith(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfNotEqual(t, LNumber(7), L.Arith(OP_ADD, LNumber(3), LNumber(4)))
+	errorIfNotEqual(t, LNumber(1), L.Arith(OP_MOD, LNumber(-5), LString("3")))
+	errorIfNotEqual(t, LNumber(-2), L.Arith(OP_UNM, LString("2"), LNil))
+	errorIfScriptFail(t, L, `mt = {__add = function(a, b) return "added" end, __unm = function(a) return "negated" end}`)
+	tb := L.NewTable()
+	L.SetMetatable(tb, L.GetGlobal("mt"))
+	errorIfNotEqual(t, LString("added"), L.Arith(OP_ADD, LNumber(1), tb))
+	errorIfNotEqual(t, LString("negated"), L.Arith(OP_UNM, tb, LNil))
+	errorIfFalse(t, L.LessThanOrEqual(LNumber(1), LNumber(1)), "1 <= 1 expected")
+	L.Register("f", func(L *LState) int { L.Arith(OP_SUB, LNumber(1), LTrue); return 0 })
+	errorIfScriptNotFail(t, L, `f()`, "cannot perform sub operation between number and boolean")
+}
+
 func TestPCall(t *testing.T) {
 	L := NewState()
 	defer L.Close()
@@ -398,6 +550,43 @@ func TestCoroutineApi1(t *testing.T) {
 
 }
 
+func TestLightweightThreads(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	co, _ := L.NewThread()
+	errorIfNotEqual(t, CoroutineRegistrySize, co.RegistryStats().Allocated)
+	errorIfNotEqual(t, RegistrySize, co.RegistryStats().Max)
+	errorIfFalse(t, co.CallStackStats().Allocated < L.CallStackStats().Allocated, "thread call stack should start small")
+
+	// threads grow up to the limits of the main thread
+	errorIfScriptFail(t, L, `
+local function deep(n, a, b, c, d, e, f, g, h)
+  if n == 0 then
+    coroutine.yield("bottom")
+    return 0
+  end
+  return 1 + deep(n - 1, a, b, c, d, e, f, g, h)
+end
+local co = coroutine.create(deep)
+local ok, v = coroutine.resume(co, 200)
+assert(ok and v == "bottom")
+ok, v = coroutine.resume(co)
+assert(ok and v == 200)
+
+local function overflow() return 1 + overflow() end
+ok, v = coroutine.resume(coroutine.create(overflow))
+assert(not ok and string.find(v, "stack overflow", 1, true))
+
+local cos = {}
+for i = 1, 10000 do
+  cos[i] = coroutine.wrap(function(x) while true do x = coroutine.yield(x + i) end end)
+end
+for i = 1, 10000 do
+  assert(cos[i](i) == 2 * i)
+end
+`)
+}
+
 func TestContextTimeout(t *testing.T) {
 	L := NewState()
 	defer L.Close()
@@ -582,6 +771,62 @@ func TestUninitializedVarAccess(t *testing.T) {
 	`)
 }
 
+func TestInlineCacheInvalidation(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+		local t = {name = "a"}
+		local mt = setmetatable({}, {__index = function() return "meta" end})
+		local results = {}
+		for i = 1, 4 do
+			results[i] = t.name
+			counter = (counter or 0) + 1
+			if i == 1 then t.name = "b" end
+			if i == 2 then t.name = nil end
+			if i == 3 then t = mt end
+		end
+		assert(results[1] == "a")
+		assert(results[2] == "b")
+		assert(results[3] == nil)
+		assert(results[4] == "meta")
+		assert(counter == 4)
+	`)
+	L.SetGlobal("counter", LNumber(10))
+	errorIfScriptFail(t, L, `assert(counter == 10)`)
+}
+
+func TestInlineCacheMethodInvalidation(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+		local A = {}
+		A.__index = A
+		function A:name() return "a" end
+		local B = {__index = {name = function() return "b" end}}
+		local p, q = setmetatable({}, A), setmetatable({}, A)
+		local results = {}
+		for i = 1, 7 do
+			local obj = i % 2 == 0 and q or p
+			results[i] = obj:name()
+			if i == 2 then function A:name() return "a2" end end
+			if i == 3 then p.name = function() return "own" end end
+			if i == 4 then p.name = nil; setmetatable(p, B) end
+			if i == 5 then A.__index = {name = function() return "c" end} end
+		end
+		assert(table.concat(results, ",") == "a,a,a2,a2,b,c,b")
+
+		local s = "abc"
+		local upper = string.upper
+		local r = {}
+		for i = 1, 2 do
+			r[i] = s:upper()
+			string.upper = function() return "x" end
+		end
+		string.upper = upper
+		assert(r[1] == "ABC" and r[2] == "x")
+	`)
+}
+
 func BenchmarkCallFrameStackPushPopAutoGrow(t *testing.B) {
 	stack := newAutoGrowingCallFrameStack(256)
 
@@ -760,3 +1005,360 @@ func BenchmarkRegistrySetTop(t *testing.B) {
 		reg.SetTop(0)
 	}
 }
+
+func TestTailCallDoesNotGrowCallStack(t *testing.T) {
+	for _, minimize := range []bool{false, true} {
+		L := NewState(Options{CallStackSize: 16, RegistrySize: 256, MinimizeStackMemory: minimize})
+		errorIfScriptFail(t, L, `
+		local function f(n) if n == 0 then return "done" end return f(n-1) end
+		assert(f(100000) == "done")
+
+		local a, b
+		function a(n) if n == 0 then return "a" end return b(n-1) end
+		function b(n) if n == 0 then return "b" end return a(n-1) end
+		assert(a(100001) == "b")
+
+		local obj = {}
+		function obj:m(n) if n == 0 then return "m" end return self:m(n-1) end
+		assert(obj:m(100000) == "m")
+
+		local callable = setmetatable({}, {__call = function(self, n)
+			if n == 0 then return "call" end
+			return self(n-1)
+		end})
+		assert(callable(100000) == "call")
+
+		local function g(n, ...) if n == 0 then return select('#', ...) end return g(n-1, ...) end
+		assert(g(100000, 1, 2, 3) == 3)
+
+		local co = coroutine.wrap(function(n)
+			local function h(n) if n == 0 then return "co" end return h(n-1) end
+			return h(n)
+		end)
+		assert(co(100000) == "co")
+		`)
+		L.Close()
+	}
+}
+
+func TestCallByParamDoesNotAllocate(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `function handler(a, b) return a + 1000.5, b end`)
+	fns := []LValue{
+		L.GetGlobal("handler"),
+		L.NewFunction(func(L *LState) int {
+			L.Push(L.Get(1))
+			return 1
+		}),
+	}
+	handler := L.NewFunction(func(L *LState) int { return 1 })
+	a, b := LValue(LNumber(3000.5)), LValue(LString("event"))
+	for _, fn := range fns {
+		for _, cp := range []P{
+			{Fn: fn, NRet: 1},
+			{Fn: fn, NRet: MultRet},
+			{Fn: fn, NRet: 1, Protect: true},
+			{Fn: fn, NRet: 1, Protect: true, Handler: handler},
+		} {
+			allocs := testing.AllocsPerRun(1000, func() {
+				if err := L.CallByParam(cp, a, b); err != nil {
+					t.Fatal(err)
+				}
+				L.SetTop(0)
+			})
+			errorIfFalse(t, allocs == 0, "CallByParam allocated %v times", allocs)
+		}
+	}
+}
+
+func BenchmarkCallByParam(b *testing.B) {
+	L := NewState()
+	defer L.Close()
+	if err := L.DoString(`function handler(a, b) return a end`); err != nil {
+		b.Fatal(err)
+	}
+	fn := L.GetGlobal("handler")
+	arg := LValue(LString("event"))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		L.CallByParam(P{Fn: fn, NRet: 1, Protect: true}, arg)
+		L.Pop(1)
+	}
+}
+
+func TestCallStackShrinksAfterDeepRecursion(t *testing.T) {
+	L := NewState(Options{CallStackSize: 1024, MinimizeStackMemory: true})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local function deep(n) if n == 0 then return 0 end return 1 + deep(n-1) end
+	assert(deep(500) == 500)
+	`)
+	stats := L.CallStackStats()
+	errorIfNotEqual(t, 0, stats.Depth)
+	errorIfFalse(t, stats.HighWaterMark > 500, "high water mark should be above 500, got %v", stats.HighWaterMark)
+	errorIfFalse(t, stats.Allocated <= 2*FramesPerSegment, "call stack did not shrink, %v frames allocated", stats.Allocated)
+	errorIfFalse(t, stats.Max >= 1024, "unexpected max %v", stats.Max)
+
+	L.ResetCallStackHighWaterMark()
+	errorIfNotEqual(t, 0, L.CallStackStats().HighWaterMark)
+
+	fixed := NewState(Options{CallStackSize: 64})
+	defer fixed.Close()
+	errorIfScriptFail(t, fixed, `
+	local function deep(n) if n == 0 then return 0 end return 1 + deep(n-1) end
+	assert(deep(10) == 10)
+	`)
+	stats = fixed.CallStackStats()
+	errorIfFalse(t, stats.HighWaterMark > 10, "high water mark should be above 10, got %v", stats.HighWaterMark)
+	errorIfNotEqual(t, 64, stats.Allocated)
+}
+
+func TestAutoGrowingCallFrameStackSpareSegment(t *testing.T) {
+	stack := newAutoGrowingCallFrameStack(256).(*autoGrowingCallFrameStack)
+	for i := 0; i < FramesPerSegment+1; i++ {
+		stack.Push(callFrame{})
+	}
+	stack.Pop()
+	stack.Pop()
+	// the segment that was just left is kept as a spare
+	errorIfNotNil(t, stack.segments[2])
+	errorIfFalse(t, stack.segments[1] != nil, "spare segment was freed")
+	errorIfNotEqual(t, 2*FramesPerSegment, stack.Stats().Allocated)
+	stack.SetSp(0)
+	errorIfNotEqual(t, 2*FramesPerSegment, stack.Stats().Allocated)
+	stack.Clear()
+	errorIfNotEqual(t, FramesPerSegment, stack.Stats().Allocated)
+}
+
+func TestRegistryStatsAndCompaction(t *testing.T) {
+	L := NewState(Options{RegistrySize: 256, RegistryMaxSize: 1024 * 16, RegistryGrowStep: 32})
+	defer L.Close()
+	L.SetTop(0)
+	// simulate Go code that leaks values onto the stack
+	for i := 0; i < 2000; i++ {
+		L.Push(LString("leak"))
+	}
+	stats := L.RegistryStats()
+	errorIfNotEqual(t, 2000, stats.Top)
+	errorIfFalse(t, stats.Grows > 0, "registry should have grown")
+	errorIfFalse(t, stats.Allocated >= 2000, "unexpected allocated size %v", stats.Allocated)
+	errorIfNotEqual(t, 1024*16, stats.Max)
+
+	L.SetTop(0)
+	L.reg.array[10] = LString("stale")
+	errorIfNotEqual(t, 1, L.RegistryStats().Stale)
+	L.CompactRegistry()
+	stats = L.RegistryStats()
+	errorIfNotEqual(t, 0, stats.Stale)
+	errorIfNotEqual(t, 256, stats.Allocated)
+
+	// values below the top are not reclaimed
+	L.Push(LString("kept"))
+	L.CompactRegistry()
+	errorIfNotEqual(t, 1, L.GetTop())
+	errorIfNotEqual(t, LString("kept"), L.Get(1))
+	L.SetTop(0)
+
+	auto := NewState(Options{RegistrySize: 256, RegistryMaxSize: 1024 * 16, RegistryGrowStep: 32, RegistryAutoCompact: true})
+	defer auto.Close()
+	errorIfScriptFail(t, auto, `
+	local function deep(n, ...) if n == 0 then return select('#', ...) end return deep(n-1, n, ...) end
+	assert(deep(1500) == 1500)
+	`)
+	errorIfNotEqual(t, 256, auto.RegistryStats().Allocated)
+
+	errorIfGFuncNotFail(t, auto, func(L *LState) int {
+		L.CompactRegistry()
+		return 0
+	}, "can not compact the registry while a function is running")
+}
+
+func TestErrorVariableNames(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptNotFail(t, L, `local x = config.name`, `with key 'name' \(global 'config'\)`)
+	errorIfScriptNotFail(t, L, `local t = {}; t.a.b = 1`, `with key 'b' \(field 'a'\)`)
+	errorIfScriptNotFail(t, L, `local s; s:run()`, `with key 'run' \(local 's'\)`)
+	errorIfScriptNotFail(t, L, `local t = {}; t:run()`, `attempt to call a non-function object \(method 'run'\)`)
+	errorIfScriptNotFail(t, L, `undefined_function()`, `attempt to call a non-function object \(global 'undefined_function'\)`)
+	errorIfScriptNotFail(t, L, `local up; (function() return up + 1 end)()`, `between nil and number \(upvalue 'up'\)`)
+	errorIfScriptNotFail(t, L, `local t = {}; return "a" .. t.x`, `between string and nil \(field 'x'\)`)
+	errorIfScriptNotFail(t, L, `local t = setmetatable({}, {__index = function() return nil end}); t.x.y = 1`, `with key 'y' \(field 'x'\)`)
+}
+
+func stackTraceTestHelper(L *LState) int {
+	L.Push(L.CheckFunction(1))
+	L.Call(0, 0)
+	return 0
+}
+
+func TestStackTraceGoFunctionNames(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("helper", L.NewFunction(stackTraceTestHelper))
+	L.SetFuncs(L.G.Global, map[string]LGFunction{"fail": func(L *LState) int {
+		L.RaiseError("fail")
+		return 0
+	}})
+	err := L.DoString(`local t = {helper}; t[1](fail)`)
+	errorIfNil(t, err)
+	errorIfFalse(t, strings.Contains(err.Error(), "[G]: in function 'fail'\n"), "unexpected traceback: %v", err)
+	errorIfFalse(t, strings.Contains(err.Error(), "[G]: in function <gopher-lua.stackTraceTestHelper>\n"), "unexpected traceback: %v", err)
+}
+
+func TestArgErrorGoFunctionNames(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	// functions of modules are named after their fields when they are not called through a name
+	errorIfScriptNotFail(t, L, `local ok, err = pcall(string.rep); error(err)`, `bad argument #1 to rep \(string expected`)
+	errorIfScriptNotFail(t, L, `local ok, err = pcall(table.insert); error(err)`, `bad argument #1 to insert \(table expected`)
+	errorIfScriptNotFail(t, L, `local r = string.rep; r()`, `bad argument #1 to r \(`)
+}
+
+func TestStackTraceFrames(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	var frames []Frame
+	L.SetGlobal("capture", L.NewFunction(func(L *LState) int {
+		frames = L.StackTrace()
+		return 0
+	}))
+	errorIfScriptFail(t, L, `
+local function inner()
+  capture()
+end
+inner()
+`)
+	errorIfNotEqual(t, 3, len(frames))
+	errorIfNotEqual(t, Frame{Source: "[G]", FunctionName: "capture", IsGo: true}, frames[0])
+	errorIfNotEqual(t, Frame{Source: "<string>", Line: 3, FunctionName: "inner"}, frames[1])
+	errorIfNotEqual(t, Frame{Source: "<string>", Line: 5, FunctionName: "main chunk"}, frames[2])
+
+	err := L.DoString(`
+local function fail()
+  error("fail")
+end
+local function call() fail() end
+call()
+`)
+	errorIfNil(t, err)
+	aerr, ok := err.(*ApiError)
+	errorIfFalse(t, ok, "expected an ApiError, got %T", err)
+	errorIfNotEqual(t, 4, len(aerr.Frames))
+	errorIfNotEqual(t, "error", aerr.Frames[0].FunctionName)
+	errorIfFalse(t, aerr.Frames[0].IsGo, "error should be a Go function")
+	errorIfNotEqual(t, Frame{Source: "<string>", Line: 3, FunctionName: "fail"}, aerr.Frames[1])
+	errorIfNotEqual(t, Frame{Source: "<string>", Line: 5, FunctionName: "call"}, aerr.Frames[2])
+	errorIfNotEqual(t, Frame{Source: "<string>", Line: 6, FunctionName: "main chunk"}, aerr.Frames[3])
+}
+
+func TestDefaultMetatable(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	strmt := L.GetDefaultMetatable(LTString).(*LTable)
+	L.SetField(strmt, "trim", L.NewFunction(func(L *LState) int {
+		L.Push(LString(strings.TrimSpace(L.CheckString(1))))
+		return 1
+	}))
+	nummt := L.NewTable()
+	methods := L.NewTable()
+	L.SetField(methods, "double", L.NewFunction(func(L *LState) int {
+		L.Push(L.CheckNumber(1) * 2)
+		return 1
+	}))
+	L.SetField(nummt, "__index", methods)
+	L.SetDefaultMetatable(LTNumber, nummt)
+	errorIfNotEqual(t, nummt, L.GetDefaultMetatable(LTNumber))
+	errorIfScriptFail(t, L, `
+assert(("  abc "):trim() == "abc" and ("abc"):upper() == "ABC")
+local n = 21
+assert(n:double() == 42)
+`)
+	L.SetDefaultMetatable(LTNumber, nil)
+	errorIfNotEqual(t, LNil, L.GetDefaultMetatable(LTNumber))
+	errorIfScriptNotFail(t, L, `local n = 1; n:double()`, "attempt to index a non-table object\\(number\\)")
+	errorIfGFuncNotFail(t, L, func(L *LState) int {
+		L.SetDefaultMetatable(LTTable, L.NewTable())
+		return 0
+	}, "can not set the default metatable of table values")
+}
+
+func TestFloatSuffix(t *testing.T) {
+	for _, optimized := range []bool{false, true} {
+		var buf bytes.Buffer
+		L := NewState(Options{FloatSuffix: true, OptimizeBytecode: optimized, Stdout: &buf})
+		errorIfScriptFail(t, L, `
+		assert(tostring(3) == "3.0")
+		assert(tostring(1.5) == "1.5")
+		assert(tostring(1e100) == "1e+100")
+		assert(tostring(0/0):find("nan", 1, true) or tostring(0/0):find("NaN", 1, true))
+		local n = 2
+		assert("n=" .. n == "n=2.0")
+		assert("n=" .. 2 == "n=2.0")
+		assert(string.format("%s %d %5.1f %q", 4, 4, 4, 4) == '4.0 4   4.0 "4.0"')
+		-- every integral number has the suffix, also those that are integers in Lua 5.3
+		assert(tostring(#{1, 2}) == "2.0")
+		assert(tostring(select('#', 1, 2)) == "2.0")
+		local s = ""
+		for i = 1, 2 do s = s .. i .. " " end
+		assert(s == "1.0 2.0 ", s)
+		`)
+		errorIfScriptFail(t, L, `print(10, 0.25)`)
+		errorIfNotEqual(t, "10.0\t0.25\n", buf.String())
+		errorIfNotEqual(t, LString("7.0"), L.ToStringMeta(LNumber(7)))
+		L.Close()
+	}
+
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	assert(tostring(3) == "3")
+	assert("n=" .. 2 == "n=2")
+	assert(string.format("%s", 4) == "4")
+	`)
+}
+
+func TestOnUnhandledError(t *testing.T) {
+	var reports []string
+	L := NewState(Options{OnUnhandledError: func(L *LState, err error) {
+		dbg, ok := L.GetStack(1)
+		errorIfFalse(t, ok, "the stack must not be unwound")
+		name, value := L.GetLocal(dbg, 2)
+		reports = append(reports, fmt.Sprintf("%s=%v %d", name, value, len(L.StackTrace())))
+		L.RaiseError("ignored")
+	}})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	function fail(x)
+		local secret = x * 2
+		error("boom")
+	end
+	assert(not pcall(fail, 1))
+	`)
+	errorIfNotEqual(t, 0, len(reports))
+
+	err := L.DoString(`fail(21)`)
+	errorIfNil(t, err)
+	errorIfFalse(t, strings.Contains(err.Error(), "boom"), "boom expected, got %v", err)
+	errorIfFalse(t, reflect.DeepEqual([]string{"secret=42 3"}, reports), "unexpected reports %v", reports)
+
+	// errors of Go functions calling PCall are handled by them
+	L.SetGlobal("gopcall", L.NewFunction(func(L *LState) int {
+		L.Push(L.GetGlobal("fail"))
+		L.Push(LNumber(1))
+		L.Push(LBool(L.PCall(1, 0, nil) != nil))
+		return 1
+	}))
+	errorIfScriptFail(t, L, `assert(gopcall())`)
+	errorIfNotEqual(t, 1, len(reports))
+
+	func() {
+		defer func() { errorIfNil(t, recover()) }()
+		L.Push(L.GetGlobal("fail"))
+		L.Push(LNumber(2))
+		L.Call(1, 0)
+	}()
+	errorIfFalse(t, reflect.DeepEqual([]string{"secret=42 3", "secret=4 2"}, reports), "unexpected reports %v", reports)
+}
@@ -0,0 +1,103 @@
+package lua
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+/* Stats {{{ */
+
+// Stats is a snapshot of the resource usage of an LState and all coroutines sharing its globals.
+//
+// The allocation counters, the live object counters and VMTime are only collected if the state has been created
+// with `Options.CollectStats`; otherwise they are always zero.
+type Stats struct {
+	// Tables is the number of tables created.
+	Tables int64
+	// Functions is the number of functions(closures and Go functions) created.
+	Functions int64
+	// UserData is the number of userdata created.
+	UserData int64
+	// Threads is the number of threads(coroutines) created.
+	Threads int64
+	// Numbers is the number of numbers that needed a heap allocation to be stored.
+	Numbers int64
+
+	// LiveTables is the number of created tables that have not been garbage collected yet.
+	LiveTables int64
+	// LiveUserData is the number of created userdata that have not been garbage collected yet.
+	LiveUserData int64
+
+	// VMTime is the total time spent in top level calls.
+	VMTime time.Duration
+
+	// Registry describes the registry of this state.
+	Registry RegistryStats
+	// CallStack describes the call stack of this state.
+	CallStack CallStackStats
+}
+
+// vmStats holds the counters behind Stats. Counters are updated atomically since live object counters are
+// decremented from the garbage collector.
+type vmStats struct {
+	tables    atomic.Int64
+	functions atomic.Int64
+	userdata  atomic.Int64
+	threads   atomic.Int64
+	numbers   atomic.Int64
+
+	liveTables   atomic.Int64
+	liveUserData atomic.Int64
+
+	vmTime atomic.Int64
+}
+
+func (st *vmStats) newTable(tb *LTable) {
+	st.tables.Add(1)
+	st.liveTables.Add(1)
+	runtime.AddCleanup(tb, func(st *vmStats) { st.liveTables.Add(-1) }, st)
+}
+
+func (st *vmStats) newUserData(ud *LUserData) {
+	st.userdata.Add(1)
+	st.liveUserData.Add(1)
+	runtime.AddCleanup(ud, func(st *vmStats) { st.liveUserData.Add(-1) }, st)
+}
+
+func (st *vmStats) newFunction() {
+	st.functions.Add(1)
+}
+
+func (st *vmStats) newThread() {
+	st.threads.Add(1)
+}
+
+func (st *vmStats) boxedNumber() {
+	st.numbers.Add(1)
+}
+
+func (st *vmStats) addVMTime(start time.Time) {
+	st.vmTime.Add(int64(time.Since(start)))
+}
+
+// Stats returns a snapshot of the resource usage of this state.
+func (ls *LState) Stats() Stats {
+	stats := Stats{
+		Registry:  ls.RegistryStats(),
+		CallStack: ls.CallStackStats(),
+	}
+	if st := ls.G.stats; st != nil {
+		stats.Tables = st.tables.Load()
+		stats.Functions = st.functions.Load()
+		stats.UserData = st.userdata.Load()
+		stats.Threads = st.threads.Load()
+		stats.Numbers = st.numbers.Load()
+		stats.LiveTables = st.liveTables.Load()
+		stats.LiveUserData = st.liveUserData.Load()
+		stats.VMTime = time.Duration(st.vmTime.Load())
+	}
+	return stats
+}
+
+/* }}} */
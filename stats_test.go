@@ -0,0 +1,50 @@
+package lua
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	L := NewState(Options{CollectStats: true})
+	defer L.Close()
+	before := L.Stats()
+	errorIfScriptFail(t, L, `
+	t = {}
+	for i = 1, 100 do t[i] = {i + 0.5} end
+	local fns = {}
+	for i = 1, 10 do fns[i] = function() return i end end
+	local co = coroutine.create(function() end)
+	coroutine.resume(co)
+	`)
+	L.NewUserData()
+	stats := L.Stats()
+	errorIfFalse(t, stats.Tables-before.Tables >= 102, "unexpected number of tables %v", stats.Tables-before.Tables)
+	errorIfFalse(t, stats.Functions-before.Functions >= 12, "unexpected number of functions %v", stats.Functions-before.Functions)
+	errorIfFalse(t, stats.Numbers-before.Numbers >= 100, "unexpected number of numbers %v", stats.Numbers-before.Numbers)
+	errorIfNotEqual(t, int64(1), stats.UserData-before.UserData)
+	errorIfNotEqual(t, int64(1), stats.Threads-before.Threads)
+	errorIfFalse(t, stats.VMTime > 0, "VMTime should be positive")
+	errorIfFalse(t, stats.Registry.Allocated > 0, "registry stats are missing")
+	errorIfFalse(t, stats.CallStack.Max > 0, "call stack stats are missing")
+
+	L.SetGlobal("t", LNil)
+	live := stats.LiveTables
+	deadline := time.Now().Add(5 * time.Second)
+	for L.Stats().LiveTables >= live && time.Now().Before(deadline) {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	errorIfFalse(t, L.Stats().LiveTables < live, "garbage collected tables are still counted as live")
+}
+
+func TestStatsDisabled(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `local t = {{}, {}}`)
+	stats := L.Stats()
+	errorIfNotEqual(t, int64(0), stats.Tables)
+	errorIfNotEqual(t, time.Duration(0), stats.VMTime)
+	errorIfFalse(t, stats.Registry.Allocated > 0, "registry stats are missing")
+}
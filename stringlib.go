@@ -2,6 +2,7 @@ package lua
 
 import (
 	"fmt"
+	"math"
 	"strings"
 
 	"github.com/r0kyi/gopher-lua/pm"
@@ -56,7 +57,7 @@ func strByte(L *LState) int {
 		if start < 0 || start >= l {
 			return 0
 		}
-		L.Push(LNumber(str[start]))
+		L.PushNumber(LNumber(str[start]))
 		return 1
 	}
 
@@ -67,7 +68,7 @@ func strByte(L *LState) int {
 	}
 
 	for i := start; i < end; i++ {
-		L.Push(LNumber(str[i]))
+		L.PushNumber(LNumber(str[i]))
 	}
 	return end - start
 }
@@ -91,8 +92,8 @@ func strFind(L *LState) int {
 	str := L.CheckString(1)
 	pattern := L.CheckString(2)
 	if len(pattern) == 0 {
-		L.Push(LNumber(1))
-		L.Push(LNumber(0))
+		L.PushNumber(LNumber(1))
+		L.PushNumber(LNumber(0))
 		return 2
 	}
 	init := luaIndex2StringIndex(str, L.OptInt(3, 1), true)
@@ -107,8 +108,8 @@ func strFind(L *LState) int {
 			L.Push(LNil)
 			return 1
 		}
-		L.Push(LNumber(init+pos) + 1)
-		L.Push(LNumber(init + pos + len(pattern)))
+		L.PushNumber(LNumber(init+pos) + 1)
+		L.PushNumber(LNumber(init + pos + len(pattern)))
 		return 2
 	}
 
@@ -121,11 +122,11 @@ func strFind(L *LState) int {
 		return 1
 	}
 	md := mds[0]
-	L.Push(LNumber(md.Capture(0) + 1))
-	L.Push(LNumber(md.Capture(1)))
+	L.PushNumber(LNumber(md.Capture(0) + 1))
+	L.PushNumber(LNumber(md.Capture(1)))
 	for i := 2; i < md.CaptureLength(); i += 2 {
 		if md.IsPosCapture(i) {
-			L.Push(LNumber(md.Capture(i)))
+			L.PushNumber(LNumber(md.Capture(i)))
 		} else {
 			L.Push(LString(str[md.Capture(i):md.Capture(i+1)]))
 		}
@@ -139,12 +140,32 @@ func strFormat(L *LState) int {
 	top := L.GetTop()
 	for i := 2; i <= top; i++ {
 		args[i-2] = L.Get(i)
+		if nm, ok := args[i-2].(LNumber); ok && L.Options.FloatSuffix {
+			args[i-2] = suffixedNumber(nm)
+		}
 	}
-	npat := strings.Count(str, "%") - strings.Count(str, "%%")
-	L.Push(LString(fmt.Sprintf(str, args[:intMin(npat, len(args))]...)))
+	L.Push(LString(formatValues(str, args)))
 	return 1
 }
 
+// suffixedNumber is a number formatted by %s and %q with the suffix of `Options.FloatSuffix`.
+type suffixedNumber LNumber
+
+func (nm suffixedNumber) Format(f fmt.State, c rune) {
+	switch c {
+	case 'q', 's':
+		defaultFormat(floatString(LNumber(nm)), f, c)
+	default:
+		LNumber(nm).Format(f, c)
+	}
+}
+
+// formatValues formats args like string.format does. Arguments beyond the verbs of str are ignored.
+func formatValues(str string, args []interface{}) string {
+	npat := strings.Count(str, "%") - strings.Count(str, "%%")
+	return fmt.Sprintf(str, args[:intMin(npat, len(args))]...)
+}
+
 func strGsub(L *LState) int {
 	str := L.CheckString(1)
 	pat := L.CheckString(2)
@@ -158,18 +179,20 @@ func strGsub(L *LState) int {
 	}
 	if len(mds) == 0 {
 		L.SetTop(1)
-		L.Push(LNumber(0))
+		L.PushNumber(LNumber(0))
 		return 2
 	}
+	out := newGsubOutput(L, str, len(mds))
 	switch lv := repl.(type) {
 	case LString:
-		L.Push(LString(strGsubStr(L, str, string(lv), mds)))
+		strGsubStr(L, out, string(lv), mds)
 	case *LTable:
-		L.Push(LString(strGsubTable(L, str, lv, mds)))
+		strGsubTable(L, out, lv, mds)
 	case *LFunction:
-		L.Push(LString(strGsubFunc(L, str, lv, mds)))
+		strGsubFunc(L, out, lv, mds)
 	}
-	L.Push(LNumber(len(mds)))
+	L.Push(LString(out.String()))
+	L.PushNumber(LNumber(len(mds)))
 	return 2
 }
 
@@ -200,26 +223,59 @@ func capturedString(L *LState, m *pm.MatchData, str string, idx int) string {
 
 }
 
-func strGsubDoReplace(str string, info []replaceInfo) string {
-	offset := 0
-	buf := []byte(str)
-	for _, replace := range info {
-		oldlen := len(buf)
-		b1 := append([]byte(""), buf[0:offset+replace.Indicies[0]]...)
-		b2 := []byte("")
-		index2 := offset + replace.Indicies[1]
-		if index2 <= len(buf) {
-			b2 = append(b2, buf[index2:len(buf)]...)
+// gsubOutput collects the replacements of string.gsub and keeps the size of the result within
+// `Options.MaxStringSize` and `Options.MaxGsubExpansion` times the size of the subject.
+type gsubOutput struct {
+	L         *LState
+	str       string
+	info      []replaceInfo
+	size      int
+	limit     int
+	expansion bool
+}
+
+func newGsubOutput(L *LState, str string, nmatches int) *gsubOutput {
+	out := &gsubOutput{L: L, str: str, info: make([]replaceInfo, 0, nmatches), size: len(str), limit: L.Options.MaxStringSize}
+	if factor := L.Options.MaxGsubExpansion; factor > 0 && factor <= math.MaxInt/(len(str)+1) {
+		if max := factor * (len(str) + 1); out.limit <= 0 || max < out.limit {
+			out.limit = max
+			out.expansion = true
 		}
-		buf = append(b1, replace.String...)
-		buf = append(buf, b2...)
-		offset += len(buf) - oldlen
 	}
-	return string(buf)
+	return out
 }
 
-func strGsubStr(L *LState, str string, repl string, matches []*pm.MatchData) string {
-	infoList := make([]replaceInfo, 0, len(matches))
+// replace replaces str[start:end] with repl, raising an error if the result gets too large.
+func (out *gsubOutput) replace(start, end int, repl string) {
+	out.info = append(out.info, replaceInfo{[]int{start, end}, repl})
+	out.size += len(repl) - (end - start)
+	if out.limit > 0 && out.size > out.limit {
+		var err error
+		if out.expansion {
+			err = fmt.Errorf("resulting string too large(more than %d times the subject)", out.L.Options.MaxGsubExpansion)
+		} else {
+			err = fmt.Errorf("resulting string too large(more than %d bytes)", out.limit)
+		}
+		out.L.audit(AuditQuota, "string size", err)
+		out.L.RaiseError("%s", err.Error())
+	}
+}
+
+func (out *gsubOutput) String() string {
+	var buf strings.Builder
+	buf.Grow(out.size)
+	pos := 0
+	for _, replace := range out.info {
+		buf.WriteString(out.str[pos:replace.Indicies[0]])
+		buf.WriteString(replace.String)
+		pos = replace.Indicies[1]
+	}
+	buf.WriteString(out.str[pos:])
+	return buf.String()
+}
+
+func strGsubStr(L *LState, out *gsubOutput, repl string, matches []*pm.MatchData) {
+	str := out.str
 	for _, match := range matches {
 		start, end := match.Capture(0), match.Capture(1)
 		sc := newFlagScanner('%', "", "", repl)
@@ -238,14 +294,12 @@ func strGsubStr(L *LState, str string, repl string, matches []*pm.MatchData) str
 				}
 			}
 		}
-		infoList = append(infoList, replaceInfo{[]int{start, end}, sc.String()})
+		out.replace(start, end, sc.String())
 	}
-
-	return strGsubDoReplace(str, infoList)
 }
 
-func strGsubTable(L *LState, str string, repl *LTable, matches []*pm.MatchData) string {
-	infoList := make([]replaceInfo, 0, len(matches))
+func strGsubTable(L *LState, out *gsubOutput, repl *LTable, matches []*pm.MatchData) {
+	str := out.str
 	for _, match := range matches {
 		idx := 0
 		if match.CaptureLength() > 2 { // has captures
@@ -258,14 +312,13 @@ func strGsubTable(L *LState, str string, repl *LTable, matches []*pm.MatchData)
 			value = L.GetField(repl, str[match.Capture(idx):match.Capture(idx+1)])
 		}
 		if !LVIsFalse(value) {
-			infoList = append(infoList, replaceInfo{[]int{match.Capture(0), match.Capture(1)}, LVAsString(value)})
+			out.replace(match.Capture(0), match.Capture(1), LVAsString(value))
 		}
 	}
-	return strGsubDoReplace(str, infoList)
 }
 
-func strGsubFunc(L *LState, str string, repl *LFunction, matches []*pm.MatchData) string {
-	infoList := make([]replaceInfo, 0, len(matches))
+func strGsubFunc(L *LState, out *gsubOutput, repl *LFunction, matches []*pm.MatchData) {
+	str := out.str
 	for _, match := range matches {
 		start, end := match.Capture(0), match.Capture(1)
 		L.Push(repl)
@@ -273,7 +326,7 @@ func strGsubFunc(L *LState, str string, repl *LFunction, matches []*pm.MatchData
 		if match.CaptureLength() > 2 { // has captures
 			for i := 2; i < match.CaptureLength(); i += 2 {
 				if match.IsPosCapture(i) {
-					L.Push(LNumber(match.Capture(i)))
+					L.PushNumber(LNumber(match.Capture(i)))
 				} else {
 					L.Push(LString(capturedString(L, match, str, i)))
 				}
@@ -286,10 +339,9 @@ func strGsubFunc(L *LState, str string, repl *LFunction, matches []*pm.MatchData
 		L.Call(nargs, 1)
 		ret := L.reg.Pop()
 		if !LVIsFalse(ret) {
-			infoList = append(infoList, replaceInfo{[]int{start, end}, LVAsString(ret)})
+			out.replace(start, end, LVAsString(ret))
 		}
 	}
-	return strGsubDoReplace(str, infoList)
 }
 
 type strMatchData struct {
@@ -316,7 +368,7 @@ func strGmatchIter(L *LState) int {
 
 	for i := 2; i < match.CaptureLength(); i += 2 {
 		if match.IsPosCapture(i) {
-			L.Push(LNumber(match.Capture(i)))
+			L.PushNumber(LNumber(match.Capture(i)))
 		} else {
 			L.Push(LString(str[match.Capture(i):match.Capture(i+1)]))
 		}
@@ -340,7 +392,7 @@ func strGmatch(L *LState) int {
 
 func strLen(L *LState) int {
 	str := L.CheckString(1)
-	L.Push(LNumber(len(str)))
+	L.PushNumber(LNumber(len(str)))
 	return 1
 }
 
@@ -380,7 +432,7 @@ func strMatch(L *LState) int {
 	default:
 		for i := 2; i < md.CaptureLength(); i += 2 {
 			if md.IsPosCapture(i) {
-				L.Push(LNumber(md.Capture(i)))
+				L.PushNumber(LNumber(md.Capture(i)))
 			} else {
 				L.Push(LString(str[md.Capture(i):md.Capture(i+1)]))
 			}
@@ -392,11 +444,33 @@ func strMatch(L *LState) int {
 func strRep(L *LState) int {
 	str := L.CheckString(1)
 	n := L.CheckInt(2)
-	if n < 0 {
+	sep := L.OptString(3, "")
+	if n <= 0 {
 		L.Push(emptyLString)
-	} else {
+		return 1
+	}
+	if unit := len(str) + len(sep); unit > 0 && n-1 > (math.MaxInt-len(str))/unit {
+		L.RaiseError("resulting string too large")
+	}
+	size := (len(str)+len(sep))*(n-1) + len(str)
+	if limit := L.Options.MaxStringSize; limit > 0 && size > limit {
+		err := fmt.Errorf("resulting string too large(%d bytes, limit is %d)", size, limit)
+		L.audit(AuditQuota, "string size", err)
+		L.RaiseError("%s", err.Error())
+	}
+	if len(sep) == 0 {
 		L.Push(LString(strings.Repeat(str, n)))
+		return 1
+	}
+	var buf strings.Builder
+	buf.Grow(size)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf.WriteString(sep)
+		}
+		buf.WriteString(str)
 	}
+	L.Push(LString(buf.String()))
 	return 1
 }
 
@@ -0,0 +1,36 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGsubLimits(t *testing.T) {
+	L := NewState(Options{MaxGsubExpansion: 4})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  assert(string.gsub("abc", "%w", "%0%0") == "aabbcc")
+	  assert(string.gsub("hello world", "o", {o = "0"}) == "hell0 w0rld")
+	  assert(string.gsub("abc", "", "-") == "-a-b-c-")
+	`)
+	errorIfScriptNotFail(t, L, `string.gsub("abc", "%w", string.rep("x", 100))`, "more than 4 times the subject")
+	errorIfScriptNotFail(t, L, `
+	  local s = string.rep("a", 1000)
+	  s = string.gsub(s, "a", "aaaa") -- allowed
+	  string.gsub(s, "", "xxxx")
+	`, "resulting string too large")
+
+	calls := 0
+	L.SetGlobal("count", L.NewFunction(func(L *LState) int {
+		calls++
+		L.Push(LString(strings.Repeat("x", 10)))
+		return 1
+	}))
+	errorIfScriptNotFail(t, L, `string.gsub(string.rep("a", 100), "a", count)`, "resulting string too large")
+	errorIfFalse(t, calls < 100, "gsub should stop calling the replacement function, called %d times", calls)
+
+	L2 := NewState(Options{MaxStringSize: 10})
+	defer L2.Close()
+	errorIfScriptFail(t, L2, `assert(string.gsub("abc", "b", "12345") == "a12345c")`)
+	errorIfScriptNotFail(t, L2, `string.gsub("abc", "b", "123456789")`, "more than 10 bytes")
+}
@@ -0,0 +1,87 @@
+//go:build !nosys
+
+package lua
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+/* system services {{{ */
+
+// The functions below access the file system and start processes for the standard libraries when the host
+// interfaces of the state(see `HostInterfaces`) do not replace them. Building with the "nosys" tag replaces them
+// with functions that fail(see sys_nosys.go), for environments without a file system or processes like GOOS=js and
+// TinyGo.
+
+func sysOpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func sysRemove(name string) error {
+	return os.Remove(name)
+}
+
+func sysRename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func sysCreateTemp() (*os.File, error) {
+	return os.CreateTemp("", "")
+}
+
+func sysStat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// process is a process started by io.popen.
+type process struct {
+	cmd *exec.Cmd
+}
+
+// startProcess starts the shell command cmd. stdin and stdout are the pipes to the process if writable and
+// readable are set.
+func startProcess(cmd string, writable, readable bool) (pp *process, stdin io.WriteCloser, stdout io.ReadCloser, err error) {
+	c, args := popenArgs(cmd)
+	pp = &process{cmd: exec.Command(c, args...)}
+	if writable {
+		if stdin, err = pp.cmd.StdinPipe(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if readable {
+		if stdout, err = pp.cmd.StdoutPipe(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if err := pp.cmd.Start(); err != nil {
+		return nil, nil, nil, err
+	}
+	return pp, stdin, stdout, nil
+}
+
+func (pp *process) path() string {
+	return pp.cmd.Path
+}
+
+// wait waits for the process to exit and returns its exit status, or 0 if it is not known.
+func (pp *process) wait() int {
+	if err, ok := pp.cmd.Wait().(*exec.ExitError); ok {
+		if s, ok := err.Sys().(syscall.WaitStatus); ok {
+			return s.ExitStatus()
+		}
+	}
+	return 0
+}
+
+// runCommand runs the shell command cmd for os.execute with the standard streams of the state.
+func runCommand(L *LState, cmd string) error {
+	c, args := popenArgs(cmd)
+	ecmd := exec.Command(c, args...)
+	ecmd.Stdin, ecmd.Stdout, ecmd.Stderr = L.stdin(), L.stdout(), L.stderr()
+	return ecmd.Run()
+}
+
+/* }}} */
@@ -0,0 +1,59 @@
+//go:build nosys
+
+package lua
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+/* system services {{{ */
+
+// In builds with the "nosys" tag, the standard libraries can only access files through the host interfaces of the
+// state(see `HostInterfaces`), and can not start processes.
+
+var (
+	errNoFileSystem = errors.New("no file system is available(see Options.Host)")
+	errNoProcesses  = errors.New("processes are not supported")
+)
+
+func sysOpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return nil, &os.PathError{Op: "open", Path: name, Err: errNoFileSystem}
+}
+
+func sysRemove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: errNoFileSystem}
+}
+
+func sysRename(oldpath, newpath string) error {
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errNoFileSystem}
+}
+
+func sysCreateTemp() (*os.File, error) {
+	return nil, errNoFileSystem
+}
+
+func sysStat(name string) (os.FileInfo, error) {
+	return nil, &os.PathError{Op: "stat", Path: name, Err: errNoFileSystem}
+}
+
+type process struct{}
+
+func startProcess(cmd string, writable, readable bool) (*process, io.WriteCloser, io.ReadCloser, error) {
+	return nil, nil, nil, errNoProcesses
+}
+
+func (pp *process) path() string {
+	return ""
+}
+
+func (pp *process) wait() int {
+	return 0
+}
+
+func runCommand(L *LState, cmd string) error {
+	return errNoProcesses
+}
+
+/* }}} */
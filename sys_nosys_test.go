@@ -0,0 +1,44 @@
+//go:build nosys
+
+package lua
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func init() {
+	noSys = true
+}
+
+func TestNoSys(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local f, err = io.open("data.txt")
+	assert(f == nil and err:find("no file system is available"))
+	f, err = io.popen("echo hello")
+	assert(f == nil and err == "processes are not supported")
+	assert(os.execute("echo hello") == 1)
+	assert(not pcall(dofile, "data.txt"))
+	assert(io.tmpfile() == nil)
+	`)
+	_, err := L.LoadFile("data.txt")
+	errorIfNil(t, err)
+}
+
+func TestNoSysHostFS(t *testing.T) {
+	dir := t.TempDir()
+	errorIfNotNil(t, os.WriteFile(filepath.Join(dir, "data.txt"), []byte("hello"), 0644))
+	root, err := os.OpenRoot(dir)
+	errorIfNotNil(t, err)
+	defer root.Close()
+	L := NewState(Options{Host: HostInterfaces{FS: root}})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local f = assert(io.open("data.txt"))
+	assert(f:read("*a") == "hello")
+	f:close()
+	`)
+}
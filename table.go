@@ -1,5 +1,14 @@
 package lua
 
+import (
+	"fmt"
+	"io"
+	"iter"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
 const defaultArrayCap = 32
 const defaultHashCap = 32
 
@@ -28,6 +37,88 @@ func (lv lValueArraySorter) Less(i, j int) bool {
 	return lessThan(lv.L, lv.Values[i], lv.Values[j])
 }
 
+var metaEventNames = [...]string{
+	"__index", "__newindex", "__call", "__metatable", "__tostring", "__len",
+	"__unm", "__add", "__sub", "__mul", "__div", "__mod", "__pow", "__concat",
+	"__eq", "__lt", "__le", "__gc", "__mode", "__close",
+}
+
+func metaEventIndex(event string) int {
+	switch event {
+	case "__index":
+		return 0
+	case "__newindex":
+		return 1
+	case "__call":
+		return 2
+	case "__metatable":
+		return 3
+	case "__tostring":
+		return 4
+	case "__len":
+		return 5
+	case "__unm":
+		return 6
+	case "__add":
+		return 7
+	case "__sub":
+		return 8
+	case "__mul":
+		return 9
+	case "__div":
+		return 10
+	case "__mod":
+		return 11
+	case "__pow":
+		return 12
+	case "__concat":
+		return 13
+	case "__eq":
+		return 14
+	case "__lt":
+		return 15
+	case "__le":
+		return 16
+	case "__gc":
+		return 17
+	case "__mode":
+		return 18
+	case "__close":
+		return 19
+	}
+	return -1
+}
+
+// metaCache holds metamethods resolved from a table that is used as a metatable.
+// Entries are valid only while version matches the version of the table.
+type metaCache struct {
+	version uint64
+	values  [len(metaEventNames)]LValue
+}
+
+// metaField returns the raw value associated with the metamethod name `event`.
+// Results are cached until a string key of this table is modified.
+func (tb *LTable) metaField(event string) LValue {
+	idx := metaEventIndex(event)
+	if idx < 0 {
+		return tb.RawGetString(event)
+	}
+	mc := tb.metaCache
+	if mc == nil {
+		mc = &metaCache{version: tb.version}
+		tb.metaCache = mc
+	} else if mc.version != tb.version {
+		mc.values = [len(metaEventNames)]LValue{}
+		mc.version = tb.version
+	}
+	v := mc.values[idx]
+	if v == nil {
+		v = tb.RawGetString(event)
+		mc.values[idx] = v
+	}
+	return v
+}
+
 func newLTable(acap int, hcap int) *LTable {
 	if acap < 0 {
 		acap = 0
@@ -40,9 +131,7 @@ func newLTable(acap int, hcap int) *LTable {
 	if acap != 0 {
 		tb.array = make([]LValue, 0, acap)
 	}
-	if hcap != 0 {
-		tb.strdict = make(map[string]LValue, hcap)
-	}
+	tb.hash = newHashPart(hcap)
 	return tb
 }
 
@@ -81,6 +170,7 @@ func (tb *LTable) Append(value LValue) {
 		}
 		tb.array[i+1] = value
 	}
+	tb.migrateFromHash()
 }
 
 // Insert inserts a given LValue at position `i` in this table.
@@ -100,6 +190,7 @@ func (tb *LTable) Insert(i int, value LValue) {
 	tb.array = append(tb.array, LNil)
 	copy(tb.array[i+1:], tb.array[i:])
 	tb.array[i] = value
+	tb.migrateFromHash()
 }
 
 // MaxN returns a maximum number key that nil value does not exist before it.
@@ -131,6 +222,7 @@ func (tb *LTable) Remove(pos int) LValue {
 		// nothing to do
 	case i == larray-1 || i < 0:
 		oldval = tb.array[larray-1]
+		tb.array[larray-1] = nil
 		tb.array = tb.array[:larray-1]
 	default:
 		oldval = tb.array[i]
@@ -138,9 +230,141 @@ func (tb *LTable) Remove(pos int) LValue {
 		tb.array[larray-1] = nil
 		tb.array = tb.array[:larray-1]
 	}
+	tb.trimArray()
 	return oldval
 }
 
+// trimArray drops trailing nils from the array part and releases the backing
+// array once less than a quarter of its capacity is in use, so tables used as
+// stacks or queues give memory back after they drain.
+func (tb *LTable) trimArray() {
+	n := len(tb.array)
+	for n > 0 && tb.array[n-1] == LNil {
+		n--
+	}
+	for i := n; i < len(tb.array); i++ {
+		tb.array[i] = nil
+	}
+	tb.array = tb.array[:n]
+	if c := cap(tb.array); c > defaultArrayCap && n < c/4 {
+		newarray := make([]LValue, n, intMax(n*2, defaultArrayCap))
+		copy(newarray, tb.array)
+		tb.array = newarray
+	}
+}
+
+// migrateFromHash moves integer keys that directly follow the array part from
+// the hash part into the array part. This keeps the invariant that the hash
+// part never holds a key in the range 1..len(array)+1.
+func (tb *LTable) migrateFromHash() {
+	for tb.hashIntKeys > 0 {
+		v := tb.hash.set(LNumber(len(tb.array)+1), LNil)
+		if v == LNil {
+			break
+		}
+		tb.hashIntKeys--
+		tb.array = append(tb.array, v)
+	}
+	tb.noteMovedKeys()
+}
+
+// noteMovedKeys is called after integer keys are added to the array part. If the hash part has deleted entries,
+// they may be for these keys, and a traversal started later must not take them for the position of the keys in
+// the hash part(see next).
+func (tb *LTable) noteMovedKeys() {
+	if len(tb.hash.entries) != tb.hash.live {
+		tb.movedKeys = true
+	}
+}
+
+// arraySizeLimit returns the maximum length the array part may grow to when a
+// key beyond its end is assigned. Keys beyond this limit go to the hash part,
+// so sparse integer keys do not allocate huge, mostly empty arrays.
+func (tb *LTable) arraySizeLimit() int {
+	return intMax(2*len(tb.array), defaultArrayCap)
+}
+
+// Compact shrinks the memory used by this table. The array part is resized to
+// the largest n such that more than half of the slots 1..n are in use, integer
+// keys are moved between the array part and the hash part accordingly, and
+// keys that have been deleted are dropped from the hash part.
+// Compact must not be called while the table is being traversed by Next.
+func (tb *LTable) Compact() {
+	var nums [32]int
+	total := 0
+	alen := 0
+	count := func(k int) {
+		nums[bits.Len(uint(k-1))]++
+		total++
+	}
+	for i, v := range tb.array {
+		if v != LNil {
+			count(i + 1)
+		}
+	}
+	for _, e := range tb.hash.entries {
+		if n, ok := e.key.(LNumber); ok && e.value != LNil && isArrayKey(n) {
+			count(int(n))
+		}
+	}
+	size := 0
+	for i, a, twotoi := 0, 0, 1; i < len(nums) && twotoi/2 < total; i, twotoi = i+1, twotoi*2 {
+		a += nums[i]
+		if a > twotoi/2 {
+			size = twotoi
+		}
+	}
+	for i, v := range tb.array {
+		if v != LNil && i+1 <= size {
+			alen = i + 1
+		}
+	}
+	for _, e := range tb.hash.entries {
+		if n, ok := e.key.(LNumber); ok && e.value != LNil && isArrayKey(n) && int(n) <= size {
+			alen = intMax(alen, int(n))
+		}
+	}
+
+	var array []LValue
+	if alen > 0 || tb.array != nil {
+		array = make([]LValue, alen)
+	}
+	for i := range array {
+		array[i] = LNil
+	}
+	hash := newHashPart(tb.hash.live + len(tb.array) - alen)
+	hashIntKeys := 0
+	for _, e := range tb.hash.entries {
+		if e.value == LNil {
+			continue
+		}
+		if n, ok := e.key.(LNumber); ok && isArrayKey(n) {
+			if int(n) <= alen {
+				array[int(n)-1] = e.value
+				continue
+			}
+			hashIntKeys++
+		}
+		hash.setAt(-1, e.key, e.hash, e.value)
+	}
+	for i, v := range tb.array {
+		if v == LNil {
+			continue
+		}
+		if i < alen {
+			array[i] = v
+		} else {
+			hash.set(LNumber(i+1), v)
+			hashIntKeys++
+		}
+	}
+	tb.array = array
+	tb.hash = hash
+	tb.hashIntKeys = hashIntKeys
+	tb.version++
+	tb.migrateFromHash()
+}
+
 // RawSet sets a given LValue to a given index without the __newindex metamethod.
 // It is recommended to use `RawSetString` or `RawSetInt` for performance
 // if you already know the given LValue is a string or number.
@@ -148,22 +372,7 @@ func (tb *LTable) RawSet(key LValue, value LValue) {
 	switch v := key.(type) {
 	case LNumber:
 		if isArrayKey(v) {
-			if tb.array == nil {
-				tb.array = make([]LValue, 0, defaultArrayCap)
-			}
-			index := int(v) - 1
-			alen := len(tb.array)
-			switch {
-			case index == alen:
-				tb.array = append(tb.array, value)
-			case index > alen:
-				for i := 0; i < (index - alen); i++ {
-					tb.array = append(tb.array, LNil)
-				}
-				tb.array = append(tb.array, value)
-			case index < alen:
-				tb.array[index] = value
-			}
+			tb.RawSetInt(int(v), value)
 			return
 		}
 	case LString:
@@ -180,45 +389,61 @@ func (tb *LTable) RawSetInt(key int, value LValue) {
 		tb.RawSetH(LNumber(key), value)
 		return
 	}
-	if tb.array == nil {
-		tb.array = make([]LValue, 0, 32)
-	}
 	index := key - 1
 	alen := len(tb.array)
 	switch {
-	case index == alen:
-		tb.array = append(tb.array, value)
-	case index > alen:
-		for i := 0; i < (index - alen); i++ {
-			tb.array = append(tb.array, LNil)
-		}
-		tb.array = append(tb.array, value)
 	case index < alen:
 		tb.array[index] = value
+		if value == LNil && index == alen-1 {
+			tb.trimArray()
+		}
+	case value == LNil:
+		if tb.hashIntKeys > 0 {
+			tb.RawSetH(LNumber(key), LNil)
+		}
+	case tb.hashIntKeys > 0 && tb.hash.get(LNumber(key)) != LNil:
+		// an existing field is updated in place, so that assigning fields does not move keys between the parts
+		// of a table that is being traversed
+		tb.hash.set(LNumber(key), value)
+	case index < tb.arraySizeLimit():
+		if tb.array == nil {
+			tb.array = make([]LValue, 0, defaultArrayCap)
+		}
+		for i := alen; i < index; i++ {
+			tb.array = append(tb.array, tb.takeFromHash(i+1))
+		}
+		tb.takeFromHash(key)
+		tb.array = append(tb.array, value)
+		tb.migrateFromHash()
+	default:
+		tb.RawSetH(LNumber(key), value)
 	}
 }
 
-// RawSetString sets a given LValue to a given string index without the __newindex metamethod.
-func (tb *LTable) RawSetString(key string, value LValue) {
-	if tb.strdict == nil {
-		tb.strdict = make(map[string]LValue, defaultHashCap)
+// takeFromHash removes an integer key from the hash part and returns its value.
+func (tb *LTable) takeFromHash(key int) LValue {
+	if tb.hashIntKeys == 0 {
+		return LNil
 	}
-	if tb.keys == nil {
-		tb.keys = []LValue{}
-		tb.k2i = map[LValue]int{}
+	v := tb.hash.set(LNumber(key), LNil)
+	if v != LNil {
+		tb.hashIntKeys--
 	}
+	return v
+}
 
-	if value == LNil {
-		// TODO tb.keys and tb.k2i should also be removed
-		delete(tb.strdict, key)
-	} else {
-		tb.strdict[key] = value
-		lkey := LString(key)
-		if _, ok := tb.k2i[lkey]; !ok {
-			tb.k2i[lkey] = len(tb.keys)
-			tb.keys = append(tb.keys, lkey)
-		}
-	}
+// RawSetString sets a given LValue to a given string index without the __newindex metamethod.
+func (tb *LTable) RawSetString(key string, value LValue) {
+	tb.version++
+	tb.hash.setString(key, value)
+}
+
+// RawSetBytes is RawSetString with a key given as a byte slice. The key is
+// only copied if it is not in the table yet, so updating existing fields does
+// not allocate.
+func (tb *LTable) RawSetBytes(key []byte, value LValue) {
+	tb.version++
+	tb.hash.setBytes(key, value)
 }
 
 // RawSetH sets a given LValue to a given index without the __newindex metamethod.
@@ -227,22 +452,13 @@ func (tb *LTable) RawSetH(key LValue, value LValue) {
 		tb.RawSetString(string(s), value)
 		return
 	}
-	if tb.dict == nil {
-		tb.dict = make(map[LValue]LValue, len(tb.strdict))
-	}
-	if tb.keys == nil {
-		tb.keys = []LValue{}
-		tb.k2i = map[LValue]int{}
-	}
-
-	if value == LNil {
-		// TODO tb.keys and tb.k2i should also be removed
-		delete(tb.dict, key)
-	} else {
-		tb.dict[key] = value
-		if _, ok := tb.k2i[key]; !ok {
-			tb.k2i[key] = len(tb.keys)
-			tb.keys = append(tb.keys, key)
+	old := tb.hash.set(key, value)
+	if n, ok := key.(LNumber); ok && isArrayKey(n) {
+		switch {
+		case old == LNil && value != LNil:
+			tb.hashIntKeys++
+		case old != LNil && value == LNil:
+			tb.hashIntKeys--
 		}
 	}
 }
@@ -252,135 +468,275 @@ func (tb *LTable) RawGet(key LValue) LValue {
 	switch v := key.(type) {
 	case LNumber:
 		if isArrayKey(v) {
-			if tb.array == nil {
-				return LNil
-			}
 			index := int(v) - 1
-			if index >= len(tb.array) {
+			if index < len(tb.array) {
+				return tb.array[index]
+			}
+			if tb.hashIntKeys == 0 {
 				return LNil
 			}
-			return tb.array[index]
 		}
 	case LString:
-		if tb.strdict == nil {
-			return LNil
-		}
-		if ret, ok := tb.strdict[string(v)]; ok {
-			return ret
-		}
-		return LNil
-	}
-	if tb.dict == nil {
-		return LNil
-	}
-	if v, ok := tb.dict[key]; ok {
-		return v
+		return tb.hash.getString(string(v))
 	}
-	return LNil
+	return tb.hash.get(key)
 }
 
 // RawGetInt returns an LValue at position `key` without __index metamethod.
 func (tb *LTable) RawGetInt(key int) LValue {
-	if tb.array == nil {
-		return LNil
+	index := key - 1
+	if index < len(tb.array) && index >= 0 {
+		return tb.array[index]
 	}
-	index := int(key) - 1
-	if index >= len(tb.array) || index < 0 {
+	if tb.hash.live == 0 || (tb.hashIntKeys == 0 && key >= 1 && key < MaxArrayIndex) {
 		return LNil
 	}
-	return tb.array[index]
+	return tb.hash.get(LNumber(key))
 }
 
 // RawGet returns an LValue associated with a given key without __index metamethod.
 func (tb *LTable) RawGetH(key LValue) LValue {
-	if s, sok := key.(LString); sok {
-		if tb.strdict == nil {
-			return LNil
-		}
-		if v, vok := tb.strdict[string(s)]; vok {
-			return v
+	if s, ok := key.(LString); ok {
+		return tb.hash.getString(string(s))
+	}
+	return tb.hash.get(key)
+}
+
+// RawGetString returns an LValue associated with a given key without __index metamethod.
+func (tb *LTable) RawGetString(key string) LValue {
+	return tb.hash.getString(key)
+}
+
+// RawGetBytes is RawGetString with a key given as a byte slice. It does not
+// allocate, so Go code processing []byte payloads can look up fields without
+// converting keys to strings first.
+func (tb *LTable) RawGetBytes(key []byte) LValue {
+	return tb.hash.getBytes(key)
+}
+
+// GetString returns the value of the field key if it is a string or a number, converted to a string, or def.
+// Like the other typed getters, it does not call metamethods.
+func (tb *LTable) GetString(key string, def string) string {
+	if v := tb.RawGetString(key); LVCanConvToString(v) {
+		return LVAsString(v)
+	}
+	return def
+}
+
+// GetInt returns the value of the field key if it is a number or a string convertible to a number, truncated to
+// an int, or def.
+func (tb *LTable) GetInt(key string, def int) int {
+	switch v := tb.RawGetString(key).(type) {
+	case LNumber:
+		return int(v)
+	case LString:
+		if n, err := parseNumber(string(v)); err == nil {
+			return int(n)
 		}
-		return LNil
 	}
-	if tb.dict == nil {
-		return LNil
+	return def
+}
+
+// GetBool returns the value of the field key if it is a boolean, or def.
+func (tb *LTable) GetBool(key string, def bool) bool {
+	if v, ok := tb.RawGetString(key).(LBool); ok {
+		return bool(v)
 	}
-	if v, ok := tb.dict[key]; ok {
+	return def
+}
+
+// GetTable returns the value of the field key if it is a table, or nil.
+func (tb *LTable) GetTable(key string) *LTable {
+	if v, ok := tb.RawGetString(key).(*LTable); ok {
 		return v
 	}
-	return LNil
+	return nil
 }
 
-// RawGetString returns an LValue associated with a given key without __index metamethod.
-func (tb *LTable) RawGetString(key string) LValue {
-	if tb.strdict == nil {
-		return LNil
+// GetPath returns the value at a dot separated path of fields, e.g. "server.tls.cert", or LNil if a table on the
+// way is missing. Path elements that are integers index the array part, as in "servers.1.host".
+func (tb *LTable) GetPath(path string) LValue {
+	var v LValue = tb
+	for _, name := range strings.Split(path, ".") {
+		cur, ok := v.(*LTable)
+		if !ok {
+			return LNil
+		}
+		v = cur.RawGetString(name)
+		if i, err := strconv.Atoi(name); err == nil && v == LNil {
+			v = cur.RawGetInt(i)
+		}
 	}
-	if v, vok := tb.strdict[string(key)]; vok {
-		return v
+	return v
+}
+
+// ConcatTo writes the elements i to j of the array part separated by sep to w, like table.concat(tb, sep, i, j)
+// does, without building the whole string in memory. It returns the number of bytes written and stops at the
+// first element that is not a string or a number.
+func (tb *LTable) ConcatTo(w io.Writer, sep string, i, j int) (int64, error) {
+	var n int64
+	for k := i; k <= j; k++ {
+		v := tb.RawGetInt(k)
+		if !LVCanConvToString(v) {
+			return n, fmt.Errorf("invalid value (%s) at index %d in table for concat", v.Type().String(), k)
+		}
+		if k != i {
+			m, err := io.WriteString(w, sep)
+			n += int64(m)
+			if err != nil {
+				return n, err
+			}
+		}
+		m, err := io.WriteString(w, LVAsString(v))
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
 	}
-	return LNil
+	return n, nil
 }
 
 // ForEach iterates over this table of elements, yielding each in turn to a given function.
+// The array part is visited first, followed by the other keys in insertion order.
 func (tb *LTable) ForEach(cb func(LValue, LValue)) {
 	if tb.array != nil {
 		for i, v := range tb.array {
 			if v != LNil {
-				cb(LNumber(i+1), v)
+				cb(numberValue(LNumber(i+1)), v)
 			}
 		}
 	}
-	if tb.strdict != nil {
-		for k, v := range tb.strdict {
-			if v != LNil {
-				cb(LString(k), v)
+	for i := 0; i < len(tb.hash.entries); i++ {
+		if e := tb.hash.entries[i]; e.value != LNil {
+			cb(e.key, e.value)
+		}
+	}
+}
+
+// ForEachE is ForEach with a callback that can stop the iteration. The iteration stops at the first non-nil
+// error returned by cb, and ForEachE returns that error.
+func (tb *LTable) ForEachE(cb func(LValue, LValue) error) error {
+	for i := 0; i < len(tb.array); i++ {
+		if v := tb.array[i]; v != LNil {
+			if err := cb(numberValue(LNumber(i+1)), v); err != nil {
+				return err
 			}
 		}
 	}
-	if tb.dict != nil {
-		for k, v := range tb.dict {
-			if v != LNil {
-				cb(k, v)
+	for i := 0; i < len(tb.hash.entries); i++ {
+		if e := tb.hash.entries[i]; e.value != LNil {
+			if err := cb(e.key, e.value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TableIterator walks the keys of a table in the order of next() and pairs():
+//
+//	it := tbl.Iterator()
+//	for key, value, ok := it.Next(); ok; key, value, ok = it.Next() {
+//		...
+//	}
+//
+// Like next(), it may assign or clear existing fields of the table while iterating, but not add new keys.
+type TableIterator struct {
+	tb   *LTable
+	key  LValue
+	done bool
+}
+
+// Iterator returns a new iterator over the keys and values of this table.
+func (tb *LTable) Iterator() *TableIterator {
+	return &TableIterator{tb: tb, key: LNil}
+}
+
+// Next returns the next key and value of the table, or false if there are no more keys.
+func (it *TableIterator) Next() (LValue, LValue, bool) {
+	if it.done {
+		return LNil, LNil, false
+	}
+	key, value := it.tb.Next(it.key)
+	if key == LNil {
+		it.done = true
+		return LNil, LNil, false
+	}
+	it.key = key
+	return key, value, true
+}
+
+// Seq2 returns an iterator over the keys and values of this table in the order of next():
+//
+//	for key, value := range tbl.Seq2() {
+//		...
+//	}
+//
+// The same rules as for TableIterator apply to modifying the table while iterating.
+func (tb *LTable) Seq2() iter.Seq2[LValue, LValue] {
+	return func(yield func(LValue, LValue) bool) {
+		for key, value := tb.Next(LNil); key != LNil; key, value = tb.Next(key) {
+			if !yield(key, value) {
+				return
 			}
 		}
 	}
 }
 
 // This function is equivalent to lua_next ( http://www.lua.org/manual/5.1/manual.html#lua_next ).
+//
+// Assigning and clearing existing fields while a table is traversed is safe: every key is visited exactly once.
+// If keys are added during a traversal, the behavior is undefined like in Lua: the new keys may or may not be
+// visited, and integer keys moved from the hash part to the array part may be skipped or visited again. Next
+// returns LNil if key is not in the table anymore, e.g. because it was cleared and new keys were added since;
+// next() raises an error in this case.
 func (tb *LTable) Next(key LValue) (LValue, LValue) {
+	k, v, _ := tb.next(key)
+	return k, v
+}
+
+// next is Next reporting whether key was found in the table.
+func (tb *LTable) next(key LValue) (LValue, LValue, bool) {
 	init := false
 	if key == LNil {
 		key = LNumber(0)
 		init = true
 	}
 
-	if init || key != LNumber(0) {
-		if kv, ok := key.(LNumber); ok && isInteger(kv) && int(kv) >= 0 && kv < LNumber(MaxArrayIndex) {
-			index := int(kv)
-			if tb.array != nil {
-				for ; index < len(tb.array); index++ {
-					if v := tb.array[index]; v != LNil {
-						return LNumber(index + 1), v
-					}
-				}
-			}
-			if tb.array == nil || index == len(tb.array) {
-				if (tb.dict == nil || len(tb.dict) == 0) && (tb.strdict == nil || len(tb.strdict) == 0) {
-					return LNil, LNil
-				}
-				key = tb.keys[0]
-				if v := tb.RawGetH(key); v != LNil {
-					return key, v
+	if kv, ok := key.(LNumber); ok && isInteger(kv) && kv >= 0 && kv < LNumber(MaxArrayIndex) {
+		index := int(kv)
+		// an integer key found in the hash part within the range of the array part has been moved to the array
+		// part during the traversal. The traversal continues in the hash part, so that keys are not visited again.
+		// Deleted entries of keys moved before the traversal are dropped when it starts.
+		if init && tb.movedKeys {
+			tb.hash.rebuild(tb.hash.live)
+			tb.movedKeys = false
+		}
+		inhash := tb.hash.find(key, hashNumber(kv)) >= 0
+		if init || (!inhash && index >= 1) {
+			// integer keys beyond the array part that are not in the hash part have been cleared and dropped from
+			// the end of the array part
+			for ; index < len(tb.array); index++ {
+				if v := tb.array[index]; v != LNil {
+					return numberValue(LNumber(index + 1)), v, true
 				}
 			}
+			k, v := tb.nextHash(0)
+			return k, v, true
 		}
 	}
+	i := tb.hash.find(key, hashValue(key))
+	if i < 0 {
+		return LNil, LNil, false
+	}
+	k, v := tb.nextHash(i + 1)
+	return k, v, true
+}
 
-	for i := tb.k2i[key] + 1; i < len(tb.keys); i++ {
-		key := tb.keys[i]
-		if v := tb.RawGetH(key); v != LNil {
-			return key, v
+func (tb *LTable) nextHash(start int) (LValue, LValue) {
+	for i := start; i < len(tb.hash.entries); i++ {
+		if e := tb.hash.entries[i]; e.value != LNil {
+			return e.key, e.value
 		}
 	}
 	return LNil, LNil
@@ -1,6 +1,10 @@
 package lua
 
 import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
 	"testing"
 )
 
@@ -156,13 +160,13 @@ func TestTableRawSetH(t *testing.T) {
 	tbl := newLTable(0, 0)
 	tbl.RawSetH(LString("key"), LTrue)
 	tbl.RawSetH(LString("key"), LNil)
-	_, found := tbl.dict[LString("key")]
-	errorIfNotEqual(t, false, found)
+	errorIfNotEqual(t, 0, tbl.hash.live)
+	errorIfNotEqual(t, LNil, tbl.RawGetH(LString("key")))
 
 	tbl.RawSetH(LTrue, LTrue)
 	tbl.RawSetH(LTrue, LNil)
-	_, foundb := tbl.dict[LTrue]
-	errorIfNotEqual(t, false, foundb)
+	errorIfNotEqual(t, 0, tbl.hash.live)
+	errorIfNotEqual(t, LNil, tbl.RawGetH(LTrue))
 }
 
 func TestTableRawGetH(t *testing.T) {
@@ -231,3 +235,367 @@ func TestTableForEach(t *testing.T) {
 		}
 	})
 }
+
+func TestTableMetaFieldCache(t *testing.T) {
+	mt := newLTable(0, 0)
+	fn := &LFunction{}
+	errorIfNotEqual(t, LNil, mt.metaField("__index"))
+	mt.RawSetString("__index", fn)
+	errorIfNotEqual(t, fn, mt.metaField("__index"))
+	errorIfNotEqual(t, LNil, mt.metaField("__add"))
+	mt.RawSetString("__index", LNil)
+	errorIfNotEqual(t, LNil, mt.metaField("__index"))
+	for i, name := range metaEventNames {
+		errorIfNotEqual(t, i, metaEventIndex(name))
+	}
+	errorIfNotEqual(t, -1, metaEventIndex("__unknown"))
+}
+
+func TestTableSparseIntKeys(t *testing.T) {
+	tbl := newLTable(0, 0)
+	tbl.RawSetInt(1000000, LTrue)
+	errorIfNotEqual(t, 0, len(tbl.array))
+	errorIfNotEqual(t, LTrue, tbl.RawGetInt(1000000))
+	errorIfNotEqual(t, LTrue, tbl.RawGet(LNumber(1000000)))
+
+	tbl = newLTable(0, 0)
+	for i := 100; i >= 1; i-- {
+		tbl.RawSetInt(i, LNumber(i))
+	}
+	errorIfNotEqual(t, 100, len(tbl.array))
+	errorIfNotEqual(t, 0, tbl.hashIntKeys)
+	errorIfNotEqual(t, 100, tbl.Len())
+	for i := 1; i <= 100; i++ {
+		errorIfNotEqual(t, LNumber(i), tbl.RawGetInt(i))
+	}
+}
+
+func TestTableShrink(t *testing.T) {
+	tbl := newLTable(0, 0)
+	for i := 1; i <= 1000; i++ {
+		tbl.Append(LNumber(i))
+	}
+	for i := 1000; i > 10; i-- {
+		tbl.RawSetInt(i, LNil)
+	}
+	errorIfNotEqual(t, 10, len(tbl.array))
+	errorIfFalse(t, cap(tbl.array) < 100, "array part should shrink, but cap is %v", cap(tbl.array))
+
+	for i := 0; i < 10; i++ {
+		tbl.Remove(1)
+	}
+	errorIfNotEqual(t, 0, tbl.Len())
+	errorIfFalse(t, cap(tbl.array) <= defaultArrayCap, "array part should shrink, but cap is %v", cap(tbl.array))
+}
+
+func TestTableForEachE(t *testing.T) {
+	tbl := newLTable(0, 0)
+	tbl.Append(LNumber(1))
+	tbl.Append(LNumber(2))
+	tbl.RawSetString("a", LString("x"))
+	tbl.RawSetString("b", LString("y"))
+
+	var keys []string
+	errorIfNotNil(t, tbl.ForEachE(func(key, value LValue) error {
+		keys = append(keys, key.String())
+		return nil
+	}))
+	errorIfNotEqual(t, "1,2,a,b", strings.Join(keys, ","))
+
+	stop := fmt.Errorf("stop")
+	keys = nil
+	err := tbl.ForEachE(func(key, value LValue) error {
+		keys = append(keys, key.String())
+		if key == LString("a") {
+			return stop
+		}
+		return nil
+	})
+	errorIfNotEqual(t, stop, err)
+	errorIfNotEqual(t, "1,2,a", strings.Join(keys, ","))
+}
+
+func TestTableIterator(t *testing.T) {
+	tbl := newLTable(0, 0)
+	tbl.Append(LNumber(1))
+	tbl.RawSetInt(3, LNumber(3))
+	tbl.RawSetString("a", LString("x"))
+	tbl.RawSetString("b", LString("y"))
+
+	var pairs []string
+	it := tbl.Iterator()
+	for key, value, ok := it.Next(); ok; key, value, ok = it.Next() {
+		pairs = append(pairs, key.String()+"="+value.String())
+		tbl.RawSet(key, LNil)
+	}
+	errorIfNotEqual(t, "1=1,3=3,a=x,b=y", strings.Join(pairs, ","))
+	_, _, ok := it.Next()
+	errorIfFalse(t, !ok, "exhausted iterator returned a key")
+	errorIfNotEqual(t, 0, tbl.Len())
+
+	_, _, ok = newLTable(0, 0).Iterator().Next()
+	errorIfFalse(t, !ok, "empty table returned a key")
+}
+
+func TestTableNextWhileClearing(t *testing.T) {
+	tbl := newLTable(0, 0)
+	tbl.Append(LNumber(1))
+	tbl.Append(LNumber(2))
+	tbl.RawSetString("a", LTrue)
+	tbl.RawSetString("b", LTrue)
+	seen := 0
+	for k, v := tbl.Next(LNil); k != LNil; k, v = tbl.Next(k) {
+		errorIfNotEqual(t, false, v == LNil)
+		tbl.RawSet(k, LNil)
+		seen++
+	}
+	errorIfNotEqual(t, 4, seen)
+}
+
+func TestTableNextAfterMigration(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local t = {}
+	t[25] = true; t[75] = true; t[47] = true; t[79] = true
+	local n = 0
+	for k in pairs(t) do n = n + 1 end
+	assert(n == 4)
+	`)
+
+	rnd := rand.New(rand.NewSource(1))
+	for round := 0; round < 200; round++ {
+		tbl := newLTable(0, 0)
+		model := map[LValue]LValue{}
+		for op := 0; op < 100; op++ {
+			var key LValue = LNumber(rnd.Intn(64) + 1)
+			if rnd.Intn(8) == 0 {
+				key = LString(fmt.Sprint("k", rnd.Intn(16)))
+			}
+			switch r := rnd.Intn(10); {
+			case r < 6:
+				tbl.RawSet(key, LNumber(op))
+				model[key] = LNumber(op)
+			case r < 9:
+				tbl.RawSet(key, LNil)
+				delete(model, key)
+			default:
+				tbl.Compact()
+			}
+		}
+		// assigning existing fields while traversing must not skip or repeat keys
+		seen := map[LValue]int{}
+		for k, v := tbl.Next(LNil); k != LNil; k, v = tbl.Next(k) {
+			errorIfFalse(t, model[k] == v, "round %d: %v = %v, expected %v", round, k, v, model[k])
+			seen[k]++
+			if rnd.Intn(2) == 0 {
+				tbl.RawSet(k, LTrue)
+				model[k] = LTrue
+			}
+			if rnd.Intn(4) == 0 {
+				for other := range model {
+					if seen[other] > 0 && other != k {
+						tbl.RawSet(other, LNil)
+						delete(model, other)
+						delete(seen, other)
+						break
+					}
+				}
+			}
+		}
+		errorIfNotEqual(t, len(model), len(seen))
+		for k, n := range seen {
+			errorIfFalse(t, n == 1, "round %d: %v visited %d times", round, k, n)
+		}
+	}
+}
+
+func TestTableCompact(t *testing.T) {
+	tbl := newLTable(0, 0)
+	tbl.RawSetInt(1, LNumber(1))
+	tbl.RawSetInt(2, LNumber(2))
+	tbl.RawSetInt(100, LNumber(100))
+	tbl.RawSetString("a", LTrue)
+	tbl.RawSetString("b", LTrue)
+	tbl.RawSetString("a", LNil)
+	for i := 3; i <= 40; i++ {
+		tbl.RawSetH(LNumber(i*1000), LTrue)
+		tbl.RawSetH(LNumber(i*1000), LNil)
+	}
+	tbl.Compact()
+	errorIfNotEqual(t, 2, len(tbl.array))
+	errorIfNotEqual(t, 2, len(tbl.hash.entries))
+	errorIfNotEqual(t, LNumber(100), tbl.RawGetInt(100))
+	errorIfNotEqual(t, LTrue, tbl.RawGetString("b"))
+
+	count := 0
+	tbl.ForEach(func(LValue, LValue) { count++ })
+	errorIfNotEqual(t, 4, count)
+
+	tbl = newLTable(0, 0)
+	for i := 1; i <= 8; i++ {
+		tbl.RawSetH(LNumber(i), LNumber(i))
+	}
+	tbl.Compact()
+	errorIfNotEqual(t, 8, len(tbl.array))
+	errorIfNotEqual(t, 0, tbl.hashIntKeys)
+	errorIfNotEqual(t, 8, tbl.Len())
+}
+
+func TestTableConstructorSizeHints(t *testing.T) {
+	for _, n := range []int{0, 1, 7, 8, 15, 16, 17, 100, 1000, 65537} {
+		errorIfFalse(t, fb2Int(int2Fb(n)) >= n, "fb2Int(int2Fb(%v)) = %v", n, fb2Int(int2Fb(n)))
+	}
+
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `t = {1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, a = 1, b = 2}`)
+	tbl := L.GetGlobal("t").(*LTable)
+	errorIfNotEqual(t, 17, tbl.Len())
+	errorIfFalse(t, cap(tbl.array) >= 17 && cap(tbl.array) < defaultArrayCap, "unexpected array cap %v", cap(tbl.array))
+}
+
+func TestTableHashOrder(t *testing.T) {
+	tbl := newLTable(0, 0)
+	keys := []LValue{LString("z"), LTrue, LNumber(1.5), LString("a"), LNumber(-3), LFalse}
+	for i, k := range keys {
+		tbl.RawSet(k, LNumber(i))
+	}
+	tbl.RawSet(LString("a"), LNil)
+	tbl.RawSet(LString("a"), LNumber(3))
+	i := 0
+	for k, v := tbl.Next(LNil); k != LNil; k, v = tbl.Next(k) {
+		errorIfNotEqual(t, keys[i], k)
+		errorIfNotEqual(t, LNumber(i), v)
+		i++
+	}
+	errorIfNotEqual(t, len(keys), i)
+
+	tbl.RawSetH(LNumber(math.Copysign(0, -1)), LTrue)
+	errorIfNotEqual(t, LTrue, tbl.RawGetH(LNumber(0)))
+	tbl.RawSetH(LNumber(0), LNil)
+	errorIfNotEqual(t, LNil, tbl.RawGetH(LNumber(math.Copysign(0, -1))))
+}
+
+func TestTableHashRebuild(t *testing.T) {
+	tbl := newLTable(0, 0)
+	for i := 0; i < 10000; i++ {
+		tbl.RawSetString(fmt.Sprint("key", i), LNumber(i))
+		if i%2 == 0 {
+			tbl.RawSetString(fmt.Sprint("key", i/2), LNil)
+		}
+	}
+	count := 0
+	tbl.ForEach(func(k, v LValue) {
+		errorIfNotEqual(t, LString(fmt.Sprint("key", int(v.(LNumber)))), k)
+		count++
+	})
+	errorIfNotEqual(t, tbl.hash.live, count)
+	errorIfFalse(t, len(tbl.hash.index) >= 2*len(tbl.hash.entries), "load factor is too high: %v/%v", len(tbl.hash.entries), len(tbl.hash.index))
+	errorIfFalse(t, len(tbl.hash.entries) < 2*count, "deleted keys should be dropped: %v/%v", len(tbl.hash.entries), count)
+
+	// deleting and adding keys in turns must not grow the hash part
+	tbl = newLTable(0, 0)
+	for i := 0; i < 10000; i++ {
+		tbl.RawSetString(fmt.Sprint("key", i), LTrue)
+		tbl.RawSetString(fmt.Sprint("key", i), LNil)
+	}
+	errorIfFalse(t, len(tbl.hash.index) <= minHashIndexSize, "hash part should not grow, index size is %v", len(tbl.hash.index))
+}
+
+func TestTableRawBytes(t *testing.T) {
+	tbl := newLTable(0, 0)
+	key := []byte("name")
+	tbl.RawSetBytes(key, LString("value"))
+	key[0] = 'N'
+	errorIfNotEqual(t, LString("value"), tbl.RawGetString("name"))
+	errorIfNotEqual(t, LNil, tbl.RawGetBytes(key))
+	errorIfNotEqual(t, LString("value"), tbl.RawGetBytes([]byte("name")))
+
+	payload := []byte("name")
+	allocs := testing.AllocsPerRun(100, func() {
+		tbl.RawGetBytes(payload)
+		tbl.RawSetBytes(payload, LTrue)
+	})
+	errorIfNotEqual(t, float64(0), allocs)
+	errorIfNotEqual(t, LTrue, tbl.RawGetString("name"))
+
+	tbl.RawSetBytes(payload, LNil)
+	errorIfNotEqual(t, LNil, tbl.RawGetString("name"))
+	errorIfNotEqual(t, 0, tbl.hash.live)
+}
+
+func TestTableTypedGetters(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	config = {
+		name = "app", port = 8080, workers = "4", debug = true, ratio = 0.5,
+		server = {tls = {cert = "a.pem"}, hosts = {"a", "b"}},
+	}
+	`)
+	config := L.GetGlobal("config").(*LTable)
+	errorIfNotEqual(t, "app", config.GetString("name", "x"))
+	errorIfNotEqual(t, "8080", config.GetString("port", "x"))
+	errorIfNotEqual(t, "x", config.GetString("debug", "x"))
+	errorIfNotEqual(t, "x", config.GetString("missing", "x"))
+	errorIfNotEqual(t, 8080, config.GetInt("port", 1))
+	errorIfNotEqual(t, 4, config.GetInt("workers", 1))
+	errorIfNotEqual(t, 0, config.GetInt("ratio", 1))
+	errorIfNotEqual(t, 1, config.GetInt("name", 1))
+	errorIfNotEqual(t, true, config.GetBool("debug", false))
+	errorIfNotEqual(t, true, config.GetBool("port", true))
+	errorIfFalse(t, config.GetTable("name") == nil, "GetTable returned a non-table")
+	errorIfNotEqual(t, LString("a.pem"), config.GetTable("server").GetTable("tls").RawGetString("cert"))
+
+	errorIfNotEqual(t, LString("a.pem"), config.GetPath("server.tls.cert"))
+	errorIfNotEqual(t, LString("b"), config.GetPath("server.hosts.2"))
+	errorIfNotEqual(t, LNumber(8080), config.GetPath("port"))
+	errorIfNotEqual(t, LNil, config.GetPath("server.missing.cert"))
+	errorIfNotEqual(t, LNil, config.GetPath("name.length"))
+}
+
+func TestTableSeq2(t *testing.T) {
+	tbl := newLTable(0, 0)
+	tbl.Append(LString("x"))
+	tbl.Append(LString("y"))
+	tbl.RawSetString("a", LNumber(1))
+	tbl.RawSetString("b", LNumber(2))
+	var pairs []string
+	for key, value := range tbl.Seq2() {
+		pairs = append(pairs, key.String()+"="+value.String())
+	}
+	errorIfNotEqual(t, "1=x,2=y,a=1,b=2", strings.Join(pairs, ","))
+
+	pairs = nil
+	for key := range tbl.Seq2() {
+		if pairs = append(pairs, key.String()); len(pairs) == 3 {
+			break
+		}
+	}
+	errorIfNotEqual(t, "1,2,a", strings.Join(pairs, ","))
+}
+
+func TestTableConcatTo(t *testing.T) {
+	tbl := newLTable(0, 0)
+	tbl.Append(LString("a"))
+	tbl.Append(LNumber(2))
+	tbl.Append(LString("c"))
+	var buf strings.Builder
+	n, err := tbl.ConcatTo(&buf, ", ", 1, 3)
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, "a, 2, c", buf.String())
+	errorIfNotEqual(t, int64(len("a, 2, c")), n)
+
+	buf.Reset()
+	_, err = tbl.ConcatTo(&buf, ",", 3, 2)
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, "", buf.String())
+
+	tbl.Append(LTrue)
+	buf.Reset()
+	_, err = tbl.ConcatTo(&buf, ",", 1, 4)
+	errorIfNil(t, err)
+	errorIfNotEqual(t, "invalid value (boolean) at index 4 in table for concat", err.Error())
+	errorIfNotEqual(t, "a,2,c", buf.String())
+}
@@ -0,0 +1,264 @@
+package lua
+
+import (
+	"hash/maphash"
+	"math"
+)
+
+/* hash part {{{ */
+
+// hashSeed is the seed used to hash the keys of all tables in the process.
+var hashSeed = maphash.MakeSeed()
+
+// numberHashSeed is mixed into number keys so that their hashes are not predictable either.
+var numberHashSeed = maphash.String(hashSeed, "number")
+
+const minHashIndexSize = 8
+
+// hashEntry is a key/value pair stored in the hash part of a table.
+// An entry whose value is LNil has been deleted. It stays in place until the hash part is rebuilt, so that Next
+// can continue a traversal from a key that has been cleared.
+type hashEntry struct {
+	key   LValue
+	value LValue
+	hash  uint64
+}
+
+// hashPart is an insertion ordered hash table used for the non-array keys of an LTable.
+//
+// Entries are appended to entries in insertion order, which is also the traversal order. index is an open
+// addressing table (linear probing, power of two size) that maps a hash to a position in entries. A slot holds
+// the position plus one; zero marks an empty slot. The zero value is an empty hash part.
+type hashPart struct {
+	entries []hashEntry
+	index   []int32
+	live    int
+}
+
+func hashString(s string) uint64 {
+	return maphash.String(hashSeed, s)
+}
+
+func hashNumber(n LNumber) uint64 {
+	f := float64(n)
+	if f == 0 {
+		// -0 and 0 are the same key
+		f = 0
+	}
+	h := math.Float64bits(f) ^ numberHashSeed
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func hashValue(key LValue) uint64 {
+	switch k := key.(type) {
+	case LString:
+		return hashString(string(k))
+	case LNumber:
+		return hashNumber(k)
+	}
+	return maphash.Comparable(hashSeed, key)
+}
+
+func newHashPart(hcap int) hashPart {
+	if hcap <= 0 {
+		return hashPart{}
+	}
+	return hashPart{
+		entries: make([]hashEntry, 0, hcap),
+		index:   make([]int32, hashIndexSize(hcap)),
+	}
+}
+
+// hashIndexSize returns the size of an index that can hold n entries while keeping the load factor at most 1/2.
+func hashIndexSize(n int) int {
+	size := minHashIndexSize
+	for size < 2*n {
+		size <<= 1
+	}
+	return size
+}
+
+// find returns the position of the entry for key in entries, or -1 if key has never been stored since the last
+// rebuild.
+func (hp *hashPart) find(key LValue, h uint64) int {
+	if len(hp.index) == 0 {
+		return -1
+	}
+	mask := uint64(len(hp.index) - 1)
+	for i := h & mask; ; i = (i + 1) & mask {
+		slot := hp.index[i]
+		if slot == 0 {
+			return -1
+		}
+		if e := &hp.entries[slot-1]; e.hash == h && e.key == key {
+			return int(slot - 1)
+		}
+	}
+}
+
+// findString is find for string keys. It does not need to box the key.
+func (hp *hashPart) findString(key string, h uint64) int {
+	if len(hp.index) == 0 {
+		return -1
+	}
+	mask := uint64(len(hp.index) - 1)
+	for i := h & mask; ; i = (i + 1) & mask {
+		slot := hp.index[i]
+		if slot == 0 {
+			return -1
+		}
+		if e := &hp.entries[slot-1]; e.hash == h {
+			if s, ok := e.key.(LString); ok && string(s) == key {
+				return int(slot - 1)
+			}
+		}
+	}
+}
+
+// findBytes is findString for a key given as a byte slice. It does not allocate.
+func (hp *hashPart) findBytes(key []byte, h uint64) int {
+	if len(hp.index) == 0 {
+		return -1
+	}
+	mask := uint64(len(hp.index) - 1)
+	for i := h & mask; ; i = (i + 1) & mask {
+		slot := hp.index[i]
+		if slot == 0 {
+			return -1
+		}
+		if e := &hp.entries[slot-1]; e.hash == h {
+			if s, ok := e.key.(LString); ok && string(s) == string(key) {
+				return int(slot - 1)
+			}
+		}
+	}
+}
+
+func (hp *hashPart) get(key LValue) LValue {
+	if hp.live == 0 {
+		return LNil
+	}
+	if i := hp.find(key, hashValue(key)); i >= 0 {
+		return hp.entries[i].value
+	}
+	return LNil
+}
+
+func (hp *hashPart) getString(key string) LValue {
+	if hp.live == 0 {
+		return LNil
+	}
+	if i := hp.findString(key, hashString(key)); i >= 0 {
+		return hp.entries[i].value
+	}
+	return LNil
+}
+
+func (hp *hashPart) getBytes(key []byte) LValue {
+	if hp.live == 0 {
+		return LNil
+	}
+	if i := hp.findBytes(key, maphash.Bytes(hashSeed, key)); i >= 0 {
+		return hp.entries[i].value
+	}
+	return LNil
+}
+
+// set stores value for key and returns the previous value. Storing LNil deletes the key.
+func (hp *hashPart) set(key LValue, value LValue) LValue {
+	h := hashValue(key)
+	return hp.setAt(hp.find(key, h), key, h, value)
+}
+
+func (hp *hashPart) setString(key string, value LValue) LValue {
+	h := hashString(key)
+	i := hp.findString(key, h)
+	if i < 0 && value == LNil {
+		return LNil
+	}
+	return hp.setAt(i, LString(key), h, value)
+}
+
+// setBytes is setString for a key given as a byte slice. The key is copied into a string only if it is added to
+// the hash part.
+func (hp *hashPart) setBytes(key []byte, value LValue) LValue {
+	h := maphash.Bytes(hashSeed, key)
+	i := hp.findBytes(key, h)
+	if i >= 0 {
+		return hp.setAt(i, hp.entries[i].key, h, value)
+	}
+	if value == LNil {
+		return LNil
+	}
+	return hp.setAt(i, LString(key), h, value)
+}
+
+func (hp *hashPart) setAt(i int, key LValue, h uint64, value LValue) LValue {
+	if i >= 0 {
+		e := &hp.entries[i]
+		old := e.value
+		e.value = value
+		switch {
+		case old == LNil && value != LNil:
+			hp.live++
+		case old != LNil && value == LNil:
+			hp.live--
+		}
+		return old
+	}
+	if value == LNil {
+		return LNil
+	}
+	if 2*(len(hp.entries)+1) > len(hp.index) {
+		// leave room for live/2 more keys, so that a table where keys are deleted and added in turns is not
+		// rebuilt on every insertion
+		hp.rebuild(hp.live + hp.live/2 + 1)
+	}
+	hp.entries = append(hp.entries, hashEntry{key: key, value: value, hash: h})
+	hp.insertIndex(h, len(hp.entries))
+	hp.live++
+	return LNil
+}
+
+func (hp *hashPart) insertIndex(h uint64, slot int) {
+	mask := uint64(len(hp.index) - 1)
+	i := h & mask
+	for hp.index[i] != 0 {
+		i = (i + 1) & mask
+	}
+	hp.index[i] = int32(slot)
+}
+
+// rebuild drops deleted entries and resizes the hash part so that it can hold at least n entries.
+func (hp *hashPart) rebuild(n int) {
+	size := hashIndexSize(n)
+	inplace := cap(hp.entries) >= size/2 && cap(hp.entries) <= 2*size
+	entries := hp.entries[:0]
+	if !inplace {
+		entries = make([]hashEntry, 0, size/2)
+	}
+	for _, e := range hp.entries {
+		if e.value != LNil {
+			entries = append(entries, e)
+		}
+	}
+	if inplace {
+		clear(hp.entries[len(entries):])
+	}
+	hp.entries = entries
+	if len(hp.index) == size {
+		clear(hp.index)
+	} else {
+		hp.index = make([]int32, size)
+	}
+	for i, e := range hp.entries {
+		hp.insertIndex(e.hash, i+1)
+	}
+}
+
+/* }}} */
@@ -2,6 +2,7 @@ package lua
 
 import (
 	"sort"
+	"strings"
 )
 
 func OpenTable(L *LState) int {
@@ -21,21 +22,32 @@ var tableFuncs = map[string]LGFunction{
 
 func tableSort(L *LState) int {
 	tbl := L.CheckTable(1)
-	sorter := lValueArraySorter{L, nil, tbl.array}
-	if L.GetTop() != 1 {
+	// sort a copy, so that the table is left unchanged if the comparator raises an error or modifies the table
+	sorter := lValueArraySorter{L, nil, append([]LValue(nil), tbl.array...)}
+	if L.GetTop() > 1 && L.Get(2) != LNil {
 		sorter.Fn = L.CheckFunction(2)
 	}
-	sort.Sort(sorter)
+	if LVAsBool(L.Get(3)) {
+		sort.Stable(sorter)
+	} else {
+		sort.Sort(sorter)
+	}
+	for i := 1; i < sorter.Len(); i++ {
+		if sorter.Less(i, i-1) {
+			L.RaiseError("invalid order function for sorting")
+		}
+	}
+	copy(tbl.array, sorter.Values)
 	return 0
 }
 
 func tableGetN(L *LState) int {
-	L.Push(LNumber(L.CheckTable(1).Len()))
+	L.PushNumber(LNumber(L.CheckTable(1).Len()))
 	return 1
 }
 
 func tableMaxN(L *LState) int {
-	L.Push(LNumber(L.CheckTable(1).MaxN()))
+	L.PushNumber(LNumber(L.CheckTable(1).MaxN()))
 	return 1
 }
 
@@ -51,7 +63,7 @@ func tableRemove(L *LState) int {
 
 func tableConcat(L *LState) int {
 	tbl := L.CheckTable(1)
-	sep := LString(L.OptString(2, ""))
+	sep := L.OptString(2, "")
 	i := L.OptInt(3, 1)
 	j := L.OptInt(4, tbl.Len())
 	if L.GetTop() == 3 {
@@ -66,19 +78,24 @@ func tableConcat(L *LState) int {
 		L.Push(emptyLString)
 		return 1
 	}
-	//TODO should flushing?
-	retbottom := L.GetTop()
-	for ; i <= j; i++ {
-		v := tbl.RawGetInt(i)
-		if !LVCanConvToString(v) {
-			L.RaiseError("invalid value (%s) at index %d in table for concat", v.Type().String(), i)
-		}
-		L.Push(v)
-		if i != j {
-			L.Push(sep)
+	// size the buffer up front, so that long tables are concatenated without growing it repeatedly
+	size := len(sep) * (j - i)
+	for k := i; k <= j; k++ {
+		switch v := tbl.RawGetInt(k).(type) {
+		case LString:
+			size += len(v)
+		case LNumber:
+			size += 8
+		default:
+			L.RaiseError("invalid value (%s) at index %d in table for concat", v.Type().String(), k)
 		}
 	}
-	L.Push(stringConcat(L, L.GetTop()-retbottom, L.reg.Top()-1))
+	var buf strings.Builder
+	buf.Grow(size)
+	if _, err := tbl.ConcatTo(&buf, sep, i, j); err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+	L.Push(LString(buf.String()))
 	return 1
 }
 
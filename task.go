@@ -0,0 +1,95 @@
+package lua
+
+import (
+	"errors"
+)
+
+/* tasks {{{ */
+
+// Task runs a function in slices of a limited number of instructions, so that single threaded programs, e.g. in
+// wasm, can interleave scripts with an event loop without goroutines:
+//
+//	task := L.NewTask(fn)
+//	for {
+//		done, err := task.RunFor(10000)
+//		if done || err != nil {
+//			break
+//		}
+//		// handle events
+//	}
+//
+// The function runs in a thread of its own, like a coroutine. A task can only be paused while no Go function runs
+// in its thread, so Go functions called by the function, including pcall, coroutine.resume and functions they
+// call, run until they return. Calling coroutine.yield in the thread of the task ends the current slice early;
+// the values passed to it are discarded.
+type Task struct {
+	L       *LState
+	thread  *LState
+	cancel  func()
+	fn      *LFunction
+	args    []LValue
+	started bool
+	done    bool
+	err     error
+	results []LValue
+}
+
+// NewTask returns a task calling fn with args. The task does not run until `Task.RunFor` is called.
+func (ls *LState) NewTask(fn *LFunction, args ...LValue) *Task {
+	thread, cancel := ls.NewThread()
+	thread.mainLoop = mainLoopWithContext
+	return &Task{L: ls, thread: thread, cancel: cancel, fn: fn, args: args}
+}
+
+// RunFor runs the task for n more instructions, or until it returns. It reports whether the function has returned,
+// and the error the function raised. A slice can be longer than n instructions, since Go functions are not
+// interrupted(see `Task`).
+func (t *Task) RunFor(n int) (bool, error) {
+	if t.done {
+		return true, t.err
+	}
+	if t.L.Options.GoroutineCoroutines {
+		return false, errors.New("tasks can not run with Options.GoroutineCoroutines")
+	}
+	t.thread.slice = n
+	t.thread.sliced = true
+	var state ResumeState
+	var err error
+	var values []LValue
+	if t.started {
+		state, err, values = t.L.Resume(t.thread, nil)
+	} else {
+		t.started = true
+		state, err, values = t.L.Resume(t.thread, t.fn, t.args...)
+	}
+	t.thread.sliced = false
+	if state == ResumeYield {
+		return false, nil
+	}
+	t.done, t.err, t.results = true, err, values
+	if t.cancel != nil {
+		t.cancel()
+	}
+	return true, err
+}
+
+// Done reports whether the function of the task has returned.
+func (t *Task) Done() bool {
+	return t.done
+}
+
+// Results returns the values the function of the task returned, or nil if it has not returned.
+func (t *Task) Results() []LValue {
+	return t.results
+}
+
+// pauseTask ends the current slice of the task running in the thread and switches to the thread that runs the task.
+// The thread resumes at the next instruction.
+func (ls *LState) pauseTask() {
+	parent := ls.Parent
+	ls.G.CurrentThread = parent
+	ls.Parent = nil
+	parent.Push(LTrue)
+}
+
+/* }}} */
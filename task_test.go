@@ -0,0 +1,88 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTaskRunFor(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	function sum(n)
+	  local s = 0
+	  for i = 1, n do
+	    s = s + i
+	    progress = i
+	  end
+	  return s, "done"
+	end
+	`)
+	task := L.NewTask(L.GetGlobal("sum").(*LFunction), LNumber(10000))
+	slices := 0
+	for {
+		done, err := task.RunFor(1000)
+		errorIfNotNil(t, err)
+		slices++
+		if done {
+			break
+		}
+		// the state can run other code between the slices
+		progress := L.GetGlobal("progress").(LNumber)
+		errorIfFalse(t, progress > 0 && progress < 10000, "unexpected progress %v", progress)
+	}
+	errorIfFalse(t, slices > 10, "more than 10 slices expected, got %d", slices)
+	errorIfFalse(t, task.Done(), "task should be done")
+	results := task.Results()
+	errorIfNotEqual(t, 2, len(results))
+	errorIfNotEqual(t, LNumber(50005000), results[0])
+	errorIfNotEqual(t, LString("done"), results[1])
+	done, err := task.RunFor(1000)
+	errorIfFalse(t, done && err == nil, "a finished task should stay done")
+}
+
+func TestTaskErrors(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	function fail()
+	  for i = 1, 100 do end
+	  error("failed")
+	end
+	`)
+	task := L.NewTask(L.GetGlobal("fail").(*LFunction))
+	done, err := task.RunFor(10)
+	for !done {
+		done, err = task.RunFor(10)
+	}
+	errorIfFalse(t, err != nil && strings.Contains(err.Error(), "failed"), "unexpected error %v", err)
+}
+
+func TestTaskGoFunctions(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	// the loop runs inside pcall and can not be paused, yield ends the slice early
+	errorIfScriptFail(t, L, `
+	function f()
+	  pcall(function() for i = 1, 1000 do end end)
+	  coroutine.yield()
+	  return 1
+	end
+	`)
+	task := L.NewTask(L.GetGlobal("f").(*LFunction))
+	done, err := task.RunFor(100000)
+	errorIfFalse(t, !done && err == nil, "the task should be paused by yield")
+	done, err = task.RunFor(1)
+	errorIfFalse(t, done && err == nil, "the task should be done")
+	errorIfNotEqual(t, LNumber(1), task.Results()[0])
+
+	// a slice ending inside pcall ends once pcall returns
+	task = L.NewTask(L.GetGlobal("f").(*LFunction))
+	done, err = task.RunFor(10)
+	errorIfFalse(t, !done && err == nil, "the task should be paused")
+	for !done && err == nil {
+		done, err = task.RunFor(10)
+	}
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, LNumber(1), task.Results()[0])
+}
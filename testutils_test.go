@@ -8,6 +8,15 @@ import (
 	"testing"
 )
 
+// noSys is set in builds with the "nosys" tag, where the standard libraries can not access the file system.
+var noSys bool
+
+func skipIfNoSys(t *testing.T) {
+	if noSys {
+		t.Skip("the file system is not available with the nosys tag")
+	}
+}
+
 func positionString(level int) string {
 	_, file, line, _ := runtime.Caller(level + 1)
 	return fmt.Sprintf("%v:%v:", filepath.Base(file), line)
@@ -0,0 +1,106 @@
+package lua
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+/* trace {{{ */
+
+// TraceInfo describes an instruction that is about to be executed. It is passed to `Options.Trace`.
+type TraceInfo struct {
+	// Fn is the running function.
+	Fn *LFunction
+	// Pc is the index of the instruction in Fn.Proto.Code.
+	Pc int
+	// Line is the source line of the instruction, or 0 if unknown.
+	Line int
+	// Instruction is the raw instruction.
+	Instruction uint32
+	// OpCode is the opcode of the instruction(OP_MOVE, OP_LOADK, ...).
+	OpCode int
+	// Registers holds the registers of the running function, starting from register 0. The slice refers to the
+	// registry of the state, so it is only valid until the trace function returns and must not be modified.
+	Registers []LValue
+}
+
+// OpName returns the name of the opcode of the instruction.
+func (ti *TraceInfo) OpName() string {
+	if ti.OpCode > opCodeMax {
+		return "UNKNOWN"
+	}
+	return opProps[ti.OpCode].Name
+}
+
+// String returns a human readable representation of the instruction.
+func (ti *TraceInfo) String() string {
+	src := "?"
+	if ti.Fn != nil && ti.Fn.Proto != nil {
+		src = ti.Fn.Proto.SourceName
+	}
+	return fmt.Sprintf("%s:%d [%d] %s", src, ti.Line, ti.Pc, strings.Replace(opToString(ti.Instruction), "      |", " |", 1))
+}
+
+// TraceFunc is a function called before an instruction is executed when `Options.Trace` is set.
+type TraceFunc func(L *LState, info *TraceInfo)
+
+// NewTraceWriter returns a TraceFunc that writes one line per traced instruction, followed by the registers of the
+// running function, to w. At most limit instructions are written; a limit of 0 means no limit. Once the limit is
+// reached a final line is written and further instructions are ignored.
+func NewTraceWriter(w io.Writer, limit int) TraceFunc {
+	n := 0
+	return func(L *LState, info *TraceInfo) {
+		if limit > 0 && n >= limit {
+			if n == limit {
+				fmt.Fprintf(w, "trace limit of %d instructions reached\n", limit)
+				n++
+			}
+			return
+		}
+		n++
+		buf := make([]string, 0, len(info.Registers))
+		for i, v := range info.Registers {
+			if v == nil {
+				v = LNil
+			}
+			buf = append(buf, fmt.Sprintf("R%d=%s", i, traceValue(v)))
+		}
+		fmt.Fprintf(w, "%s\t%s\n", info.String(), strings.Join(buf, " "))
+	}
+}
+
+func traceValue(v LValue) string {
+	if s, ok := v.(LString); ok {
+		if len(s) > 32 {
+			s = s[:32] + "..."
+		}
+		return fmt.Sprintf("%q", string(s))
+	}
+	return v.String()
+}
+
+// traceInstruction calls the trace function with the instruction at the current pc of cf.
+func (ls *LState) traceInstruction(cf *callFrame, inst uint32) {
+	proto := cf.Fn.Proto
+	info := &ls.traceInfo
+	info.Fn = cf.Fn
+	info.Pc = cf.Pc
+	info.Line = 0
+	if cf.Pc < len(proto.DbgSourcePositions) {
+		info.Line = proto.DbgSourcePositions[cf.Pc]
+	}
+	info.Instruction = inst
+	info.OpCode = opGetOpCode(inst)
+	base := cf.LocalBase
+	end := intMin(base+int(proto.NumUsedRegisters), ls.reg.top)
+	if end < base {
+		end = base
+	}
+	info.Registers = ls.reg.array[base:end]
+	ls.Options.Trace(ls, info)
+	info.Registers = nil
+	info.Fn = nil
+}
+
+/* }}} */
@@ -0,0 +1,37 @@
+package lua
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	ops := []string{}
+	L := NewState(Options{Trace: func(L *LState, info *TraceInfo) {
+		ops = append(ops, info.OpName())
+		if info.OpCode == OP_RETURN && len(info.Registers) > 1 {
+			errorIfNotEqual(t, LNumber(3), info.Registers[1])
+		}
+	}})
+	defer L.Close()
+	errorIfScriptFail(t, L, `local a = 1; local b = a + 2`)
+	errorIfNotEqual(t, "LOADK,ADD,RETURN", strings.Join(ops, ","))
+
+	ops = ops[:0]
+	L.Options.TraceInterval = 2
+	errorIfScriptFail(t, L, `local a = 1; local b = a + 2`)
+	errorIfNotEqual(t, "LOADK,RETURN", strings.Join(ops, ","))
+}
+
+func TestTraceWriter(t *testing.T) {
+	var buf bytes.Buffer
+	L := NewState(Options{Trace: NewTraceWriter(&buf, 2)})
+	defer L.Close()
+	errorIfScriptFail(t, L, `local a = "x"; local b = a .. "y"; local c = b`)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	errorIfNotEqual(t, 3, len(lines))
+	errorIfFalse(t, strings.HasPrefix(lines[0], `<string>:1 [0] LOADK`), "unexpected trace line: %v", lines[0])
+	errorIfFalse(t, strings.Contains(lines[1], `R0="x"`), "unexpected trace line: %v", lines[1])
+	errorIfNotEqual(t, "trace limit of 2 instructions reached", lines[2])
+}
@@ -0,0 +1,74 @@
+package lua
+
+/* typed userdata {{{ */
+
+// Methods maps method names to Go functions called with the userdata value passed as self(obj:method(...)). The
+// arguments following self start at index 2 of the stack.
+type Methods[T any] map[string]func(L *LState, self T) int
+
+// UserType binds a userdata type registered with `RegisterType` to the Go type T.
+type UserType[T any] struct {
+	// Name is the name the metatable is registered under(see `LState.NewTypeMetatable`).
+	Name string
+	// Metatable is the metatable of the userdata. More metamethods, e.g. __tostring, can be added to it.
+	Metatable *LTable
+}
+
+// RegisterType creates the metatable for userdata of the Go type T under name, with an __index table dispatching
+// to methods. The returned UserType pushes values of type T as userdata with this metatable and checks arguments
+// of type T without further type assertions:
+//
+//	person := lua.RegisterType(L, "person", lua.Methods[*Person]{
+//		"name": func(L *lua.LState, p *Person) int {
+//			L.Push(lua.LString(p.Name))
+//			return 1
+//		},
+//	})
+//	person.Push(L, &Person{Name: "Alice"})
+func RegisterType[T any](L *LState, name string, methods Methods[T]) *UserType[T] {
+	ut := &UserType[T]{Name: name, Metatable: L.NewTypeMetatable(name)}
+	index := L.CreateTable(0, len(methods))
+	for mname, method := range methods {
+		index.RawSetString(mname, L.NewFunction(func(L *LState) int {
+			return method(L, ut.Check(L, 1))
+		}))
+	}
+	L.SetField(ut.Metatable, "__index", index)
+	return ut
+}
+
+// New returns a userdata for v.
+func (ut *UserType[T]) New(L *LState, v T) *LUserData {
+	ud := L.NewUserData()
+	ud.Value = v
+	ud.Metatable = ut.Metatable
+	return ud
+}
+
+// Push pushes a userdata for v onto the stack.
+func (ut *UserType[T]) Push(L *LState, v T) {
+	L.Push(ut.New(L, v))
+}
+
+// Check checks whether the given argument is a userdata of this type and returns its value.
+func (ut *UserType[T]) Check(L *LState, n int) T {
+	if v, ok := ut.Test(L.Get(n)); ok {
+		return v
+	}
+	L.RaiseError("bad argument #%v to %v (%v expected, got %v)", n, L.rawFrameFuncName(L.currentFrame), ut.Name, L.Get(n).Type().String())
+	var zero T
+	return zero
+}
+
+// Test returns the value of lv if it is a userdata of this type.
+func (ut *UserType[T]) Test(lv LValue) (T, bool) {
+	if ud, ok := lv.(*LUserData); ok && ud.Metatable == ut.Metatable {
+		if v, ok := ud.Value.(T); ok {
+			return v, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+/* }}} */
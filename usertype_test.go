@@ -0,0 +1,50 @@
+package lua
+
+import (
+	"testing"
+)
+
+type testPerson struct {
+	Name string
+	Age  int
+}
+
+func TestRegisterType(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	person := RegisterType(L, "person", Methods[*testPerson]{
+		"name": func(L *LState, p *testPerson) int {
+			L.Push(LString(p.Name))
+			return 1
+		},
+		"birthday": func(L *LState, p *testPerson) int {
+			p.Age += L.OptInt(2, 1)
+			return 0
+		},
+	})
+	L.SetField(person.Metatable, "__tostring", L.NewFunction(func(L *LState) int {
+		L.Push(LString("person " + person.Check(L, 1).Name))
+		return 1
+	}))
+	L.SetGlobal("age", L.NewFunction(func(L *LState) int {
+		L.Push(LNumber(person.Check(L, 1).Age))
+		return 1
+	}))
+	p := &testPerson{Name: "alice", Age: 30}
+	person.Push(L, p)
+	L.SetGlobal("alice", L.Get(-1))
+	L.Pop(1)
+	errorIfScriptFail(t, L, `
+assert(alice:name() == "alice" and tostring(alice) == "person alice")
+alice:birthday()
+alice:birthday(2)
+assert(age(alice) == 33)
+`)
+	errorIfNotEqual(t, 33, p.Age)
+	errorIfNotEqual(t, person.Metatable, L.GetTypeMetatable("person"))
+	_, ok := person.Test(LString("alice"))
+	errorIfFalse(t, !ok, "a string must not be a person")
+	errorIfScriptNotFail(t, L, `age("alice")`, "bad argument #1 to age \\(person expected, got string\\)")
+	errorIfScriptNotFail(t, L, `age(newproxy())`, "person expected, got userdata")
+	errorIfScriptNotFail(t, L, `alice.name({})`, "person expected, got table")
+}
@@ -0,0 +1,138 @@
+package lua
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/* ustring library {{{ */
+
+// OpenUString opens the ustring library. Its functions are the counterparts of string.len, string.sub,
+// string.upper, string.lower, string.reverse, string.char and string.byte operating on the code points of UTF-8
+// encoded strings rather than on bytes:
+//
+//	ustring.len("héllo") -- 5
+//	ustring.sub("héllo", 2, 3) -- "él"
+//
+// Bytes that are not part of a valid UTF-8 sequence count as one code point each and are kept as they are.
+func OpenUString(L *LState) int {
+	mod := L.RegisterModule(UStringLibName, ustringFuncs)
+	L.Push(mod)
+	return 1
+}
+
+var ustringFuncs = map[string]LGFunction{
+	"len":       ustrLen,
+	"sub":       ustrSub,
+	"upper":     ustrUpper,
+	"lower":     ustrLower,
+	"reverse":   ustrReverse,
+	"char":      ustrChar,
+	"codepoint": ustrCodepoint,
+}
+
+// ustrOffsets returns the byte offsets of the code points of str, followed by len(str).
+func ustrOffsets(str string) []int {
+	offsets := make([]int, 0, len(str)+1)
+	for i := 0; i < len(str); {
+		offsets = append(offsets, i)
+		_, size := utf8.DecodeRuneInString(str[i:])
+		i += size
+	}
+	return append(offsets, len(str))
+}
+
+// ustrRange converts the positions i and j of n code points, which may be negative like the positions of
+// string.sub, to the range [start, end) of code point indices.
+func ustrRange(n, i, j int) (int, int) {
+	if i < 0 {
+		i = n + i + 1
+	}
+	if j < 0 {
+		j = n + j + 1
+	}
+	return intMax(i, 1) - 1, intMin(j, n)
+}
+
+// ustrMap applies mapping to the code points of str, keeping invalid bytes.
+func ustrMap(str string, mapping func(rune) rune) string {
+	var buf strings.Builder
+	buf.Grow(len(str))
+	for i := 0; i < len(str); {
+		r, size := utf8.DecodeRuneInString(str[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf.WriteByte(str[i])
+		} else {
+			buf.WriteRune(mapping(r))
+		}
+		i += size
+	}
+	return buf.String()
+}
+
+func ustrLen(L *LState) int {
+	L.Push(LNumber(utf8.RuneCountInString(L.CheckString(1))))
+	return 1
+}
+
+func ustrSub(L *LState) int {
+	str := L.CheckString(1)
+	offsets := ustrOffsets(str)
+	start, end := ustrRange(len(offsets)-1, L.CheckInt(2), L.OptInt(3, -1))
+	if start >= end {
+		L.Push(emptyLString)
+	} else {
+		L.Push(LString(str[offsets[start]:offsets[end]]))
+	}
+	return 1
+}
+
+func ustrUpper(L *LState) int {
+	L.Push(LString(ustrMap(L.CheckString(1), unicode.ToUpper)))
+	return 1
+}
+
+func ustrLower(L *LState) int {
+	L.Push(LString(ustrMap(L.CheckString(1), unicode.ToLower)))
+	return 1
+}
+
+func ustrReverse(L *LState) int {
+	str := L.CheckString(1)
+	offsets := ustrOffsets(str)
+	buf := make([]byte, 0, len(str))
+	for i := len(offsets) - 2; i >= 0; i-- {
+		buf = append(buf, str[offsets[i]:offsets[i+1]]...)
+	}
+	L.Push(LString(buf))
+	return 1
+}
+
+func ustrChar(L *LState) int {
+	top := L.GetTop()
+	buf := make([]byte, 0, top)
+	for i := 1; i <= top; i++ {
+		c := L.CheckInt(i)
+		if c < 0 || c > unicode.MaxRune || !utf8.ValidRune(rune(c)) {
+			L.ArgError(i, "value out of range")
+		}
+		buf = utf8.AppendRune(buf, rune(c))
+	}
+	L.Push(LString(buf))
+	return 1
+}
+
+func ustrCodepoint(L *LState) int {
+	str := L.CheckString(1)
+	i := L.OptInt(2, 1)
+	offsets := ustrOffsets(str)
+	start, end := ustrRange(len(offsets)-1, i, L.OptInt(3, i))
+	for k := start; k < end; k++ {
+		r, _ := utf8.DecodeRuneInString(str[offsets[k]:])
+		L.Push(LNumber(r))
+	}
+	return intMax(end-start, 0)
+}
+
+/* }}} */
@@ -0,0 +1,29 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestUStringLib(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.PreloadExtraLibs()
+	errorIfScriptFail(t, L, `assert(require("ustring") == ustring)`)
+	errorIfScriptFail(t, L, `
+assert(ustring.len("héllo") == 5 and string.len("héllo") == 6 and ustring.len("") == 0)
+assert(ustring.sub("héllo", 2, 3) == "él" and ustring.sub("héllo", -3) == "llo" and ustring.sub("héllo", 2) == "éllo")
+assert(ustring.sub("héllo", 0) == "héllo" and ustring.sub("héllo", 4, 2) == "" and ustring.sub("héllo", 10) == "")
+assert(ustring.upper("straße ñ") == "STRAßE Ñ")
+assert(ustring.lower("ÀÉÎ") == "àéî")
+assert(ustring.reverse("añb日") == "日bña")
+assert(ustring.char(104, 233, 0x65e5) == "hé日" and ustring.char() == "")
+assert(ustring.codepoint("héllo", 2) == 233)
+local a, b, c = ustring.codepoint("日本語", 1, -1)
+assert(a == 0x65e5 and b == 0x672c and c == 0x8a9e)
+assert(select("#", ustring.codepoint("abc", 3, 2)) == 0)
+-- invalid bytes count as one code point and are kept
+assert(ustring.len("a\255b") == 3 and ustring.reverse("a\255é") == "é\255a" and ustring.upper("a\255") == "A\255")
+`)
+	errorIfScriptNotFail(t, L, `ustring.char(-1)`, "value out of range")
+	errorIfScriptNotFail(t, L, `ustring.char(0xd800)`, "value out of range")
+}
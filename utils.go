@@ -99,35 +99,135 @@ func (fs *flagScanner) Next() (byte, bool) {
 	return c, false
 }
 
-var cDateFlagToGo = map[byte]string{
-	'a': "mon", 'A': "Monday", 'b': "Jan", 'B': "January", 'c': "02 Jan 06 15:04 MST", 'd': "02",
-	'F': "2006-01-02", 'H': "15", 'I': "03", 'm': "01", 'M': "04", 'p': "PM", 'P': "pm", 'S': "05",
-	'x': "15/04/05", 'X': "15:04:05", 'y': "06", 'Y': "2006", 'z': "-0700", 'Z': "MST"}
-
+// strftime formats t like the C function strftime in the C locale.
 func strftime(t time.Time, cfmt string) string {
-	sc := newFlagScanner('%', "", "", cfmt)
-	for c, eos := sc.Next(); !eos; c, eos = sc.Next() {
-		if !sc.ChangeFlag {
-			if sc.HasFlag {
-				if v, ok := cDateFlagToGo[c]; ok {
-					sc.AppendString(t.Format(v))
-				} else {
-					switch c {
-					case 'w':
-						sc.AppendString(fmt.Sprint(int(t.Weekday())))
-					default:
-						sc.AppendChar('%')
-						sc.AppendChar(c)
-					}
-				}
-				sc.HasFlag = false
-			} else {
-				sc.AppendChar(c)
-			}
+	buf := make([]byte, 0, len(cfmt)+16)
+	for i := 0; i < len(cfmt); i++ {
+		c := cfmt[i]
+		if c != '%' || i == len(cfmt)-1 {
+			buf = append(buf, c)
+			continue
+		}
+		i++
+		c = cfmt[i]
+		// the E and O modifiers select alternative representations, which are the same in the C locale
+		if (c == 'E' || c == 'O') && i < len(cfmt)-1 {
+			i++
+			c = cfmt[i]
 		}
+		buf = appendStrftime(buf, t, c)
 	}
+	return string(buf)
+}
 
-	return sc.String()
+func appendStrftime(buf []byte, t time.Time, c byte) []byte {
+	switch c {
+	case 'a':
+		return append(buf, t.Weekday().String()[:3]...)
+	case 'A':
+		return append(buf, t.Weekday().String()...)
+	case 'b', 'h':
+		return append(buf, t.Month().String()[:3]...)
+	case 'B':
+		return append(buf, t.Month().String()...)
+	case 'c':
+		return append(buf, strftime(t, "%a %b %e %H:%M:%S %Y")...)
+	case 'C':
+		return appendPadded(buf, floorDiv(t.Year(), 100), 2, '0')
+	case 'd':
+		return appendPadded(buf, t.Day(), 2, '0')
+	case 'D', 'x':
+		return append(buf, strftime(t, "%m/%d/%y")...)
+	case 'e':
+		return appendPadded(buf, t.Day(), 2, ' ')
+	case 'F':
+		return append(buf, strftime(t, "%Y-%m-%d")...)
+	case 'g':
+		year, _ := t.ISOWeek()
+		return appendPadded(buf, floorMod(year, 100), 2, '0')
+	case 'G':
+		year, _ := t.ISOWeek()
+		return strconv.AppendInt(buf, int64(year), 10)
+	case 'H':
+		return appendPadded(buf, t.Hour(), 2, '0')
+	case 'I':
+		return appendPadded(buf, (t.Hour()+11)%12+1, 2, '0')
+	case 'j':
+		return appendPadded(buf, t.YearDay(), 3, '0')
+	case 'm':
+		return appendPadded(buf, int(t.Month()), 2, '0')
+	case 'M':
+		return appendPadded(buf, t.Minute(), 2, '0')
+	case 'n':
+		return append(buf, '\n')
+	case 'p':
+		if t.Hour() < 12 {
+			return append(buf, "AM"...)
+		}
+		return append(buf, "PM"...)
+	case 'P':
+		if t.Hour() < 12 {
+			return append(buf, "am"...)
+		}
+		return append(buf, "pm"...)
+	case 'r':
+		return append(buf, strftime(t, "%I:%M:%S %p")...)
+	case 'R':
+		return append(buf, strftime(t, "%H:%M")...)
+	case 's':
+		return strconv.AppendInt(buf, t.Unix(), 10)
+	case 'S':
+		return appendPadded(buf, t.Second(), 2, '0')
+	case 't':
+		return append(buf, '\t')
+	case 'T', 'X':
+		return append(buf, strftime(t, "%H:%M:%S")...)
+	case 'u':
+		return strconv.AppendInt(buf, int64((int(t.Weekday())+6)%7+1), 10)
+	case 'U':
+		// weeks starting on Sunday, days before the first Sunday are in week 0
+		return appendPadded(buf, (t.YearDay()-1-int(t.Weekday())+7)/7, 2, '0')
+	case 'V':
+		_, week := t.ISOWeek()
+		return appendPadded(buf, week, 2, '0')
+	case 'w':
+		return strconv.AppendInt(buf, int64(t.Weekday()), 10)
+	case 'W':
+		// weeks starting on Monday, days before the first Monday are in week 0
+		return appendPadded(buf, (t.YearDay()-1-(int(t.Weekday())+6)%7+7)/7, 2, '0')
+	case 'y':
+		return appendPadded(buf, floorMod(t.Year(), 100), 2, '0')
+	case 'Y':
+		return strconv.AppendInt(buf, int64(t.Year()), 10)
+	case 'z':
+		return append(buf, t.Format("-0700")...)
+	case 'Z':
+		return append(buf, t.Format("MST")...)
+	case '%':
+		return append(buf, '%')
+	}
+	return append(buf, '%', c)
+}
+
+// appendPadded appends n padded to width digits with pad.
+func appendPadded(buf []byte, n int, width int, pad byte) []byte {
+	s := strconv.Itoa(n)
+	for i := len(s); i < width; i++ {
+		buf = append(buf, pad)
+	}
+	return append(buf, s...)
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+func floorMod(a, b int) int {
+	return a - floorDiv(a, b)*b
 }
 
 func isInteger(v LNumber) bool {
@@ -182,9 +282,10 @@ func readBufioSize(reader *bufio.Reader, size int64) ([]byte, error, bool) {
 	read := int64(0)
 	var err error
 	var n int
+	// read in chunks, so that large sizes do not allocate more memory than the input is long
+	buf := make([]byte, int(min(size, 64*1024)))
 	for read != size {
-		buf := make([]byte, size-read)
-		n, err = reader.Read(buf)
+		n, err = reader.Read(buf[:int(min(size-read, int64(len(buf))))])
 		if err != nil {
 			break
 		}
@@ -219,6 +320,80 @@ func readBufioLine(reader *bufio.Reader) ([]byte, error, bool) {
 	return result, e, len(result) == 0 && err == io.EOF
 }
 
+// readBufioLineKeep reads a line, keeping the end of line if keep is set. It returns false at the end of the input.
+func readBufioLineKeep(reader *bufio.Reader, keep bool) (string, bool, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", false, err
+	}
+	if err == io.EOF && len(line) == 0 {
+		return "", false, nil
+	}
+	if !keep {
+		line = strings.TrimSuffix(line, "\n")
+	}
+	return line, true, nil
+}
+
+// maxNumeralLength is the maximum length of a numeral read by readBufioNumber.
+const maxNumeralLength = 200
+
+// readBufioNumber reads a numeral like the "n" format of C Lua does: leading whitespace is skipped, and the longest
+// prefix of the input that looks like a decimal or hexadecimal numeral is read. It returns false if the prefix is
+// not a valid number.
+func readBufioNumber(reader *bufio.Reader) (LNumber, bool, error) {
+	buf := make([]byte, 0, 32)
+	peek := func() (byte, bool, error) {
+		c, err := reader.ReadByte()
+		if err == io.EOF {
+			return 0, false, nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		return c, true, nil
+	}
+	c, ok, err := peek()
+	for ok && (c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v') {
+		c, ok, err = peek()
+	}
+	// accept adds c to the numeral if it is one of chars and reads the next byte
+	accept := func(chars string) bool {
+		if !ok || err != nil || len(buf) >= maxNumeralLength || strings.IndexByte(chars, c) < 0 {
+			return false
+		}
+		buf = append(buf, c)
+		c, ok, err = peek()
+		return true
+	}
+	digits := "0123456789"
+	exponent := "eE"
+	accept("+-")
+	if accept("0") && accept("xX") {
+		digits = "0123456789abcdefABCDEF"
+		exponent = "pP"
+	}
+	for accept(digits) {
+	}
+	if accept(".") {
+		for accept(digits) {
+		}
+	}
+	if accept(exponent) {
+		accept("+-")
+		for accept("0123456789") {
+		}
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if ok {
+		reader.UnreadByte()
+	}
+	v, perr := parseNumber(string(buf))
+	return v, perr == nil, nil
+}
+
 func int2Fb(val int) int {
 	e := 0
 	x := val
@@ -232,6 +407,16 @@ func int2Fb(val int) int {
 	return ((e + 1) << 3) | (x - 8)
 }
 
+// fb2Int decodes a "floating point byte" produced by int2Fb.
+// The result is always equal to or greater than the value originally encoded.
+func fb2Int(x int) int {
+	e := (x >> 3) & 0x3f
+	if e == 0 {
+		return x
+	}
+	return ((x & 7) + 8) << uint(e-1)
+}
+
 func strCmp(s1, s2 string) int {
 	len1 := len(s1)
 	len2 := len(s2)
@@ -263,3 +448,24 @@ func unsafeFastStringToReadOnlyBytes(s string) (bs []byte) {
 	bh.Len = sh.Len
 	return
 }
+
+// UnsafeBytesToLString returns an LString that aliases b instead of copying it, e.g. to hand a large payload to a
+// script. This is unsafe: b must not be modified while the string or any value derived from it(a substring, a
+// table key, ...) is reachable, since Lua strings are immutable and are hashed and compared by their contents.
+// Only use it if you control the lifetime of b; otherwise convert with LString(b).
+func UnsafeBytesToLString(b []byte) LString {
+	if len(b) == 0 {
+		return emptyLString
+	}
+	return LString(unsafe.String(unsafe.SliceData(b), len(b)))
+}
+
+// LStringBytes returns the bytes of s without copying them. This is unsafe: the returned slice aliases the string
+// and must not be modified; writing to it changes every value sharing the string, and crashes the program if the
+// string is a constant. Use []byte(s) for a copy that can be modified.
+func LStringBytes(s LString) []byte {
+	if len(s) == 0 {
+		return nil
+	}
+	return unsafe.Slice(unsafe.StringData(string(s)), len(s))
+}
@@ -0,0 +1,19 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestUnsafeStringConversions(t *testing.T) {
+	payload := []byte("multi-megabyte payload")
+	s := UnsafeBytesToLString(payload)
+	errorIfNotEqual(t, LString("multi-megabyte payload"), s)
+	errorIfNotEqual(t, &payload[0], &LStringBytes(s)[0])
+	errorIfNotEqual(t, emptyLString, UnsafeBytesToLString(nil))
+	errorIfNotEqual(t, 0, len(LStringBytes("")))
+
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("payload", s)
+	errorIfScriptFail(t, L, `assert(#payload == 22 and payload:sub(1, 5) == "multi")`)
+}
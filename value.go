@@ -3,7 +3,7 @@ package lua
 import (
 	"context"
 	"fmt"
-	"os"
+	"strings"
 )
 
 type LValueType int
@@ -32,6 +32,13 @@ type LValue interface {
 	Type() LValueType
 	AssertFunction() (*LFunction, bool)
 	Index(L *LState, key string) LValue
+	// SetIndex assigns value to the field key. It is called for assignments to fields of values of type
+	// LTObject; the builtin types raise an error.
+	SetIndex(L *LState, key string, value LValue)
+}
+
+func setIndexNotSupported(L *LState, v LValue, key string) {
+	L.RaiseError("attempt to set field '%s' of a %s value", key, v.Type().String())
 }
 
 // LVIsFalse returns true if a given LValue is a nil or false otherwise false.
@@ -86,6 +93,9 @@ func (nl *LNilType) Index(L *LState, key string) LValue {
 		return LNil
 	}
 }
+func (nl *LNilType) SetIndex(L *LState, key string, value LValue) {
+	setIndexNotSupported(L, nl, key)
+}
 
 var LNil = LValue(&LNilType{})
 
@@ -105,6 +115,9 @@ func (bl LBool) Index(L *LState, key string) LValue {
 		return LNil
 	}
 }
+func (bl LBool) SetIndex(L *LState, key string, value LValue) {
+	setIndexNotSupported(L, bl, key)
+}
 
 var LTrue = LBool(true)
 var LFalse = LBool(false)
@@ -120,6 +133,9 @@ func (st LString) Index(L *LState, key string) LValue {
 		return LNil
 	}
 }
+func (st LString) SetIndex(L *LState, key string, value LValue) {
+	setIndexNotSupported(L, st, key)
+}
 
 // fmt.Formatter interface
 func (st LString) Format(f fmt.State, c rune) {
@@ -142,6 +158,15 @@ func (nm LNumber) String() string {
 	return fmt.Sprint(float64(nm))
 }
 
+// floatString returns the string representation of nm with a ".0" suffix if it looks like an integer.
+func floatString(nm LNumber) string {
+	s := nm.String()
+	if !strings.ContainsAny(s, ".eEnN") {
+		s += ".0"
+	}
+	return s
+}
+
 func (nm LNumber) Type() LValueType                   { return LTNumber }
 func (nm LNumber) AssertFunction() (*LFunction, bool) { return nil, false }
 func (nm LNumber) Index(L *LState, key string) LValue {
@@ -150,6 +175,9 @@ func (nm LNumber) Index(L *LState, key string) LValue {
 		return LNil
 	}
 }
+func (nm LNumber) SetIndex(L *LState, key string, value LValue) {
+	setIndexNotSupported(L, nm, key)
+}
 
 // fmt.Formatter interface
 func (nm LNumber) Format(f fmt.State, c rune) {
@@ -174,11 +202,17 @@ func (nm LNumber) Format(f fmt.State, c rune) {
 type LTable struct {
 	Metatable LValue
 
-	array   []LValue
-	dict    map[LValue]LValue
-	strdict map[string]LValue
-	keys    []LValue
-	k2i     map[LValue]int
+	array []LValue
+	hash  hashPart
+	// version is bumped whenever a string key is modified. It lets inline
+	// caches detect stale entries without rehashing the key.
+	version   uint64
+	metaCache *metaCache
+	// hashIntKeys is the number of keys in hash that could live in the array part.
+	hashIntKeys int
+	// movedKeys is true if integer keys may have been added to the array part while hash has deleted entries
+	// for them.
+	movedKeys bool
 }
 
 func (tb *LTable) String() string                     { return fmt.Sprintf("table: %p", tb) }
@@ -190,6 +224,9 @@ func (tb *LTable) Index(L *LState, key string) LValue {
 		return LNil
 	}
 }
+func (tb *LTable) SetIndex(L *LState, key string, value LValue) {
+	setIndexNotSupported(L, tb, key)
+}
 
 type LFunction struct {
 	IsG       bool
@@ -197,6 +234,10 @@ type LFunction struct {
 	Proto     *FunctionProto
 	GFunction LGFunction
 	Upvalues  []*Upvalue
+
+	inlineCaches []inlineCache
+	// name is the name a Go function has been registered under(see SetFuncs and RegisterModule).
+	name string
 }
 type LGFunction func(*LState) int
 
@@ -209,6 +250,9 @@ func (fn *LFunction) Index(L *LState, key string) LValue {
 		return LNil
 	}
 }
+func (fn *LFunction) SetIndex(L *LState, key string, value LValue) {
+	setIndexNotSupported(L, fn, key)
+}
 
 type Global struct {
 	MainThread    *LState
@@ -217,8 +261,26 @@ type Global struct {
 	Global        *LTable
 
 	builtinMts map[int]LValue
-	tempFiles  []*os.File
+	tempFiles  []tempFile
 	gccount    int32
+	ipairsaux  *LFunction
+	selectfn   *LFunction
+	stats      *vmStats
+	recording  *Recording
+	recordMode int
+
+	goroutineThreads map[*LState]struct{}
+	objectIteration  objectIteration
+	bufferedFiles    map[*lFile]struct{}
+	random           *xoshiro256
+	environ          map[string]string
+	finalizers       finalizers
+	refs             refTable
+	sortedTraversals map[*LTable]*sortedTraversal
+	metrics          map[*FunctionProto]*FunctionMetrics
+	modules          map[string]*ModuleInfo
+	funcInfos        map[*LFunction]*FuncInfo
+	names            nameTable
 }
 
 type LState struct {
@@ -230,6 +292,7 @@ type LState struct {
 	Options Options
 
 	stop         int32
+	closed       int32
 	reg          *registry
 	stack        callFrameStack
 	alloc        *allocator
@@ -237,9 +300,18 @@ type LState struct {
 	wrapped      bool
 	uvcache      *Upvalue
 	hasErrorFunc bool
+	// goPanicStack is the Go stack of the panic SafeGFunction is raising as a Lua error.
+	goPanicStack string
 	mainLoop     func(*LState, *callFrame)
 	ctx          context.Context
 	ctxCancelFn  context.CancelFunc
+	traceInfo    TraceInfo
+	goroutine    *goroutineThread
+	hooks        *hookState
+	debugHook    *debugHook
+	// slice is the number of instructions left in the current slice of a task if sliced is set(see `Task`).
+	slice  int
+	sliced bool
 }
 
 func (ls *LState) String() string                     { return fmt.Sprintf("thread: %p", ls) }
@@ -251,6 +323,9 @@ func (ls *LState) Index(L *LState, key string) LValue {
 		return LNil
 	}
 }
+func (ls *LState) SetIndex(L *LState, key string, value LValue) {
+	setIndexNotSupported(L, ls, key)
+}
 
 type LUserData struct {
 	Value     interface{}
@@ -267,6 +342,32 @@ func (ud *LUserData) Index(L *LState, key string) LValue {
 		return LNil
 	}
 }
+func (ud *LUserData) SetIndex(L *LState, key string, value LValue) {
+	setIndexNotSupported(L, ud, key)
+}
+
+// LObject is a value of type "object". It passes indexing, calls and the # operator to a Go Object(see
+// `LState.NewObject`).
+type LObject struct {
+	Object Object
+	// Value is the Go value the object has been created for.
+	Value interface{}
+
+	call *LFunction
+}
+
+func (obj *LObject) String() string {
+	if s, ok := obj.stringer(); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("object: %p", obj)
+}
+func (obj *LObject) Type() LValueType                   { return LTObject }
+func (obj *LObject) AssertFunction() (*LFunction, bool) { return nil, false }
+func (obj *LObject) Index(L *LState, key string) LValue { return obj.Object.Index(L, key) }
+func (obj *LObject) SetIndex(L *LState, key string, value LValue) {
+	obj.Object.SetIndex(L, key, value)
+}
 
 type LChannel chan LValue
 
@@ -279,3 +380,6 @@ func (ch LChannel) Index(L *LState, key string) LValue {
 		return LNil
 	}
 }
+func (ch LChannel) SetIndex(L *LState, key string, value LValue) {
+	setIndexNotSupported(L, ch, key)
+}
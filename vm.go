@@ -5,14 +5,24 @@ package lua
 ////////////////////////////////////////////////////////
 
 import (
-	"fmt"
 	"math"
 	"strings"
 )
 
+// contextCheckInterval is the number of instructions executed between two
+// checks of the LState context in mainLoopWithContext. The context is also
+// checked after every instruction that may call a (possibly blocking) Go
+// function.
+//
+// mainLoopWithContext is also used to run states that have a trace function
+// (Options.Trace), with or without a context, and the threads of tasks(see Task).
+const contextCheckInterval = 256
+
 func mainLoop(L *LState, baseframe *callFrame) {
 	var inst uint32
 	var cf *callFrame
+	var fn *LFunction
+	var code []uint32
 
 	if L.stack.IsEmpty() {
 		return
@@ -24,19 +34,35 @@ func mainLoop(L *LState, baseframe *callFrame) {
 		return
 	}
 
+	// cf and code are only reloaded when an instruction switches to another
+	// frame or replaces the function of the current frame(tail calls).
+	cf = L.currentFrame
+	fn = cf.Fn
+	code = fn.Proto.Code
 	for {
-		cf = L.currentFrame
-		inst = cf.Fn.Proto.Code[cf.Pc]
+		inst = code[cf.Pc]
 		cf.Pc++
-		if jumpTable[int(inst>>26)](L, inst, baseframe) == 1 {
+		if jumpTable[inst>>26](L, inst, baseframe) == 1 {
 			return
 		}
+		if L.currentFrame != cf || cf.Fn != fn {
+			if L.debugHook != nil && L.debugHook.instructions() {
+				// debug.sethook has been called in this loop
+				mainLoopWithContext(L, baseframe)
+				return
+			}
+			cf = L.currentFrame
+			fn = cf.Fn
+			code = fn.Proto.Code
+		}
 	}
 }
 
 func mainLoopWithContext(L *LState, baseframe *callFrame) {
 	var inst uint32
 	var cf *callFrame
+	var fn *LFunction
+	var code []uint32
 
 	if L.stack.IsEmpty() {
 		return
@@ -48,19 +74,56 @@ func mainLoopWithContext(L *LState, baseframe *callFrame) {
 		return
 	}
 
-	for {
-		cf = L.currentFrame
-		inst = cf.Fn.Proto.Code[cf.Pc]
-		cf.Pc++
-		select {
-		case <-L.ctx.Done():
-			L.RaiseError("%s", L.ctx.Err().Error())
-			return
-		default:
-			if jumpTable[int(inst>>26)](L, inst, baseframe) == 1 {
+	var done <-chan struct{}
+	if L.ctx != nil {
+		done = L.ctx.Done()
+	}
+	trace := L.Options.Trace != nil
+	interval := L.Options.TraceInterval
+	traced := 0
+	cf = L.currentFrame
+	fn = cf.Fn
+	code = fn.Proto.Code
+	for count := 0; ; count++ {
+		if L.sliced {
+			// tasks can only be paused when no Go function is running in the thread
+			if L.slice <= 0 && baseframe == nil {
+				L.pauseTask()
+				return
+			}
+			L.slice--
+		}
+		if count&(contextCheckInterval-1) == 0 {
+			select {
+			case <-done:
+				L.audit(AuditQuota, "context", L.ctx.Err())
+				L.RaiseError("%s", L.ctx.Err().Error())
 				return
+			default:
+			}
+		}
+		if L.debugHook != nil && L.debugHook.instructions() {
+			L.debugHookInstruction(cf)
+		}
+		inst = code[cf.Pc]
+		if trace {
+			if traced++; interval <= 1 || traced%interval == 1 {
+				L.traceInstruction(cf, inst)
 			}
 		}
+		cf.Pc++
+		op := int(inst >> 26)
+		if jumpTable[op](L, inst, baseframe) == 1 {
+			return
+		}
+		if op == OP_CALL || op == OP_TAILCALL || op == OP_SELFCALL || op == OP_TFORLOOP || op == OP_TFORLOOPI || op == OP_SELECTVA {
+			count = -1
+		}
+		if L.currentFrame != cf || cf.Fn != fn {
+			cf = L.currentFrame
+			fn = cf.Fn
+			code = fn.Proto.Code
+		}
 	}
 }
 
@@ -200,6 +263,18 @@ func switchToParentThread(L *LState, nargs int, haserror bool, kill bool) {
 func callGFunction(L *LState, tailcall bool) bool {
 	frame := L.currentFrame
 	gfnret := frame.Fn.GFunction(L)
+	if gfnret < 0 {
+		if L.hooks != nil {
+			L.hookYield(frame)
+		}
+		if L.goroutine != nil {
+			gfnret = L.yieldGoroutine()
+		} else if L.Parent != nil && yieldsAcrossGoCall(frame) {
+			L.RaiseError("attempt to yield across a Go function call(see Options.GoroutineCoroutines)")
+		}
+	} else if L.hooks != nil {
+		L.hookReturn(frame)
+	}
 	if tailcall {
 		L.currentFrame = L.RemoveCallerFrame()
 	}
@@ -214,7 +289,9 @@ func callGFunction(L *LState, tailcall bool) bool {
 		wantret = gfnret
 	}
 
-	if tailcall && L.Parent != nil && L.stack.Sp() == 1 {
+	if L.Parent != nil && L.stack.Sp() == 1 {
+		// returning from the function of a coroutine
+
 		switchToParentThread(L, wantret, false, true)
 		return true
 	}
@@ -268,19 +345,49 @@ func threadRun(L *LState) {
 	if L.stack.IsEmpty() {
 		return
 	}
+	if L.Options.GoroutineCoroutines {
+		threadRunGoroutine(L)
+		return
+	}
+	runThread(L)
+}
+
+func runThread(L *LState) {
 
 	defer func() {
 		if rcv := recover(); rcv != nil {
 			var lv LValue
 			if v, ok := rcv.(*ApiError); ok {
 				lv = v.Object
+			} else if L.Options.PanicMode == PanicModePropagate {
+				panic(rcv)
 			} else {
-				lv = LString(fmt.Sprint(rcv))
+				lv = L.goPanicError(rcv).Object
+			}
+			if L.hooks != nil {
+				err, ok := rcv.(*ApiError)
+				if !ok {
+					err = newApiError(ApiErrorRun, lv)
+				}
+				L.hookUnwind(0, err)
 			}
 			if parent := L.Parent; parent != nil {
 				if L.wrapped {
-					L.Push(lv)
-					parent.Panic(L)
+					// raise the error in the resuming thread, keeping the original error object and the traceback
+					// of this coroutine
+					err, ok := rcv.(*ApiError)
+					if !ok {
+						err = newApiError(ApiErrorRun, lv)
+					}
+					err.addStackTrace(L)
+					err.threadTrace = true
+					L.G.CurrentThread = parent
+					L.Parent = nil
+					L.kill()
+					if !parent.hasErrorFunc {
+						parent.closeAllUpvalues()
+					}
+					panic(err)
 				} else {
 					L.SetTop(0)
 					L.Push(lv)
@@ -545,7 +652,7 @@ func init() {
 			RA := lbase + A
 			Bx := int(inst & 0x3ffff) //GETBX
 			//reg.Set(RA, L.getField(cf.Fn.Env, cf.Fn.Proto.Constants[Bx]))
-			v := L.getFieldString(cf.Fn.Env, cf.Fn.Proto.stringConstants[Bx])
+			v := L.getFieldStringCached(cf.Fn, cf.Pc-1, cf.Fn.Env, cf.Fn.Proto.stringConstants[Bx])
 			// this section is inlined by go-inline
 			// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
 			{
@@ -607,7 +714,7 @@ func init() {
 			RA := lbase + A
 			B := int(inst & 0x1ff)    //GETB
 			C := int(inst>>9) & 0x1ff //GETC
-			v := L.getFieldString(reg.Get(lbase+B), L.rkString(C))
+			v := L.getFieldStringCached(cf.Fn, cf.Pc-1, reg.Get(lbase+B), L.rkString(C))
 			// this section is inlined by go-inline
 			// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
 			{
@@ -681,7 +788,10 @@ func init() {
 			RA := lbase + A
 			B := int(inst & 0x1ff)    //GETB
 			C := int(inst>>9) & 0x1ff //GETC
-			v := newLTable(B, C)
+			v := newLTable(fb2Int(B), fb2Int(C))
+			if L.G.stats != nil {
+				L.G.stats.newTable(v)
+			}
 			// this section is inlined by go-inline
 			// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
 			{
@@ -713,7 +823,7 @@ func init() {
 			B := int(inst & 0x1ff)    //GETB
 			C := int(inst>>9) & 0x1ff //GETC
 			selfobj := reg.Get(lbase + B)
-			v := L.getFieldString(selfobj, L.rkString(C))
+			v := L.getFieldStringCached(cf.Fn, cf.Pc-1, selfobj, L.rkString(C))
 			// this section is inlined by go-inline
 			// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
 			{
@@ -1001,6 +1111,27 @@ func init() {
 							rg.top = regi + 1
 						}
 					}
+				} else if lo, ok := lv.(*LObject); ok {
+					// this section is inlined by go-inline
+					// source function is 'func (rg *registry) SetNumber(regi int, vali LNumber) ' in '_state.go'
+					{
+						rg := reg
+						regi := RA
+						vali := LNumber(lo.Object.Len(L))
+						newSize := regi + 1
+						// this section is inlined by go-inline
+						// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+						{
+							requiredSize := newSize
+							if requiredSize > cap(rg.array) {
+								rg.resize(requiredSize)
+							}
+						}
+						rg.array[regi] = rg.alloc.LNumber2I(vali)
+						if regi >= rg.top {
+							rg.top = regi + 1
+						}
+					}
 				} else {
 					L.RaiseError("__len undefined")
 				}
@@ -1081,35 +1212,7 @@ func init() {
 			A := int(inst>>18) & 0xff //GETA
 			B := int(inst & 0x1ff)    //GETB
 			C := int(inst>>9) & 0x1ff //GETC
-			lhs := L.rkValue(B)
-			rhs := L.rkValue(C)
-			ret := false
-
-			if v1, ok1 := lhs.(LNumber); ok1 {
-				if v2, ok2 := rhs.(LNumber); ok2 {
-					ret = v1 <= v2
-				} else {
-					L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
-				}
-			} else {
-				if lhs.Type() != rhs.Type() {
-					L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
-				}
-				switch lhs.Type() {
-				case LTString:
-					ret = strCmp(string(lhs.(LString)), string(rhs.(LString))) <= 0
-				default:
-					switch objectRational(L, lhs, rhs, "__le") {
-					case 1:
-						ret = true
-					case 0:
-						ret = false
-					default:
-						ret = !objectRationalWithError(L, rhs, lhs, "__lt")
-					}
-				}
-			}
-
+			ret := lessThanOrEqual(L, L.rkValue(B), L.rkValue(C))
 			v := 1
 			if ret {
 				v = 0
@@ -1198,10 +1301,10 @@ func init() {
 					ls.reg.Insert(fn, cf.LocalBase)
 				}
 				if cf.Fn == nil {
-					ls.RaiseError("attempt to call a non-function object")
+					ls.RaiseError("attempt to call a non-function object%s", ls.varInfo(fn))
 				}
 				if ls.stack.IsFull() {
-					ls.RaiseError("stack overflow")
+					ls.internalError("stack overflow")
 				}
 				ls.stack.Push(cf)
 				newcf := ls.stack.Last()
@@ -1304,6 +1407,9 @@ func init() {
 					}
 				}
 				ls.currentFrame = newcf
+				if ls.hooks != nil {
+					ls.hookCall(newcf)
+				}
 			}
 			if callable.IsG && callGFunction(L, false) {
 				return 1
@@ -1331,7 +1437,7 @@ func init() {
 				callable, meta = L.metaCall(lv)
 			}
 			if callable == nil {
-				L.RaiseError("attempt to call a non-function object")
+				L.RaiseError("attempt to call a non-function object%s", L.varInfo(lv))
 			}
 			// this section is inlined by go-inline
 			// source function is 'func (ls *LState) closeUpvalues(idx int) ' in '_state.go'
@@ -1353,6 +1459,9 @@ func init() {
 					}
 				}
 			}
+			if L.hooks != nil {
+				L.hookReturn(cf)
+			}
 			if callable.IsG {
 				luaframe := cf
 				L.pushCallFrame(callFrame{
@@ -1373,14 +1482,14 @@ func init() {
 					return 1
 				}
 			} else {
+				// the callee replaces the caller in its frame and its arguments are moved down to the base of the
+				// caller, so tail calls grow neither the call stack nor the registry
 				base := cf.Base
 				cf.Fn = callable
 				cf.Pc = 0
 				cf.Base = RA
 				cf.LocalBase = RA + 1
-				cf.ReturnBase = cf.ReturnBase
 				cf.NArgs = nargs
-				cf.NRet = cf.NRet
 				cf.TailCall++
 				lbase := cf.LocalBase
 				if meta {
@@ -1527,6 +1636,9 @@ func init() {
 				}
 				cf.Base = base
 				cf.LocalBase = base + (cf.LocalBase - lbase + 1)
+				if L.hooks != nil {
+					L.hookCall(cf)
+				}
 			}
 			return 0
 		},
@@ -1565,6 +1677,9 @@ func init() {
 			if cf.NRet == MultRet {
 				n = nret
 			}
+			if L.hooks != nil {
+				L.hookReturn(cf)
+			}
 
 			if L.Parent != nil && L.stack.Sp() == 1 {
 				// this section is inlined by go-inline
@@ -1799,9 +1914,10 @@ func init() {
 				if limit, ok2 := reg.Get(RA + 1).(LNumber); ok2 {
 					if step, ok3 := reg.Get(RA + 2).(LNumber); ok3 {
 						init += step
-						v := LNumber(init)
+						// box the new value once and share it between the counter and the loop variable
+						v := reg.alloc.LNumber2I(init)
 						// this section is inlined by go-inline
-						// source function is 'func (rg *registry) SetNumber(regi int, vali LNumber) ' in '_state.go'
+						// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
 						{
 							rg := reg
 							regi := RA
@@ -1815,7 +1931,7 @@ func init() {
 									rg.resize(requiredSize)
 								}
 							}
-							rg.array[regi] = rg.alloc.LNumber2I(vali)
+							rg.array[regi] = vali
 							if regi >= rg.top {
 								rg.top = regi + 1
 							}
@@ -1824,7 +1940,7 @@ func init() {
 							Sbx := int(inst&0x3ffff) - opMaxArgSbx //GETSBX
 							cf.Pc += Sbx
 							// this section is inlined by go-inline
-							// source function is 'func (rg *registry) SetNumber(regi int, vali LNumber) ' in '_state.go'
+							// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
 							{
 								rg := reg
 								regi := RA + 3
@@ -1838,7 +1954,7 @@ func init() {
 										rg.resize(requiredSize)
 									}
 								}
-								rg.array[regi] = rg.alloc.LNumber2I(vali)
+								rg.array[regi] = vali
 								if regi >= rg.top {
 									rg.top = regi + 1
 								}
@@ -2109,6 +2225,9 @@ func init() {
 			Bx := int(inst & 0x3ffff) //GETBX
 			proto := cf.Fn.Proto.FunctionPrototypes[Bx]
 			closure := newLFunctionL(proto, cf.Fn.Env, int(proto.NumUpvalues))
+			if L.G.stats != nil {
+				L.G.stats.newFunction()
+			}
 			// this section is inlined by go-inline
 			// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
 			{
@@ -2200,62 +2319,631 @@ func init() {
 			}
 			return 0
 		},
-		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_NOP
-			return 0
-		},
-	}
-}
-
-func opArith(L *LState, inst uint32, baseframe *callFrame) int { //OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_POW
-	reg := L.reg
-	cf := L.currentFrame
-	lbase := cf.LocalBase
-	A := int(inst>>18) & 0xff //GETA
-	RA := lbase + A
-	opcode := int(inst >> 26) //GETOPCODE
-	B := int(inst & 0x1ff)    //GETB
-	C := int(inst>>9) & 0x1ff //GETC
-	lhs := L.rkValue(B)
-	rhs := L.rkValue(C)
-	v1, ok1 := lhs.(LNumber)
-	v2, ok2 := rhs.(LNumber)
-	if ok1 && ok2 {
-		v := numberArith(L, opcode, LNumber(v1), LNumber(v2))
-		// this section is inlined by go-inline
-		// source function is 'func (rg *registry) SetNumber(regi int, vali LNumber) ' in '_state.go'
-		{
-			rg := reg
-			regi := RA
-			vali := v
-			newSize := regi + 1
-			// this section is inlined by go-inline
-			// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
-			{
-				requiredSize := newSize
-				if requiredSize > cap(rg.array) {
-					rg.resize(requiredSize)
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_TFORLOOPI
+			reg := L.reg
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			C := int(inst>>9) & 0x1ff //GETC
+			nret := C
+			tb, istable := reg.Get(RA + 1).(*LTable)
+			ctrl, isnumber := reg.Get(RA + 2).(LNumber)
+			if fn, ok := reg.Get(RA).(*LFunction); ok && fn == L.G.ipairsaux && istable && isnumber {
+				// same as calling ipairsaux, but without pushing a call frame
+				i := int(ctrl) + 1
+				var value LValue
+				if i > 0 && i <= len(tb.array) {
+					value = tb.array[i-1]
+				} else {
+					value = tb.RawGetInt(i)
 				}
+				// this section is inlined by go-inline
+				// source function is 'func (rg *registry) SetTop(topi int) ' in '_state.go'
+				{
+					rg := reg
+					topi := RA + 3 + nret
+					// this section is inlined by go-inline
+					// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+					{
+						requiredSize := topi
+						if requiredSize > cap(rg.array) {
+							rg.resize(requiredSize)
+						}
+					}
+					oldtopi := rg.top
+					rg.top = topi
+					for i := oldtopi; i < rg.top; i++ {
+						rg.array[i] = LNil
+					}
+					// values beyond top don't need to be valid LValues, so setting them to nil is fine
+					// setting them to nil rather than LNil lets us invoke the golang memclr opto
+					if rg.top < oldtopi {
+						nilRange := rg.array[rg.top:oldtopi]
+						for i := range nilRange {
+							nilRange[i] = nil
+						}
+					}
+					//for i := rg.top; i < oldtop; i++ {
+					//	rg.array[i] = LNil
+					//}
+				}
+				if value != LNil {
+					index := reg.alloc.LNumber2I(LNumber(i))
+					// this section is inlined by go-inline
+					// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
+					{
+						rg := reg
+						regi := RA + 3
+						vali := index
+						newSize := regi + 1
+						// this section is inlined by go-inline
+						// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+						{
+							requiredSize := newSize
+							if requiredSize > cap(rg.array) {
+								rg.resize(requiredSize)
+							}
+						}
+						rg.array[regi] = vali
+						if regi >= rg.top {
+							rg.top = regi + 1
+						}
+					}
+					if nret > 1 {
+						// this section is inlined by go-inline
+						// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
+						{
+							rg := reg
+							regi := RA + 4
+							vali := value
+							newSize := regi + 1
+							// this section is inlined by go-inline
+							// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+							{
+								requiredSize := newSize
+								if requiredSize > cap(rg.array) {
+									rg.resize(requiredSize)
+								}
+							}
+							rg.array[regi] = vali
+							if regi >= rg.top {
+								rg.top = regi + 1
+							}
+						}
+					}
+					for j := RA + 5; j < RA+3+nret; j++ {
+						// this section is inlined by go-inline
+						// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
+						{
+							rg := reg
+							regi := j
+							vali := LNil
+							newSize := regi + 1
+							// this section is inlined by go-inline
+							// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+							{
+								requiredSize := newSize
+								if requiredSize > cap(rg.array) {
+									rg.resize(requiredSize)
+								}
+							}
+							rg.array[regi] = vali
+							if regi >= rg.top {
+								rg.top = regi + 1
+							}
+						}
+					}
+					// this section is inlined by go-inline
+					// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
+					{
+						rg := reg
+						regi := RA + 2
+						vali := index
+						newSize := regi + 1
+						// this section is inlined by go-inline
+						// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+						{
+							requiredSize := newSize
+							if requiredSize > cap(rg.array) {
+								rg.resize(requiredSize)
+							}
+						}
+						rg.array[regi] = vali
+						if regi >= rg.top {
+							rg.top = regi + 1
+						}
+					}
+					pc := cf.Fn.Proto.Code[cf.Pc]
+					cf.Pc += int(pc&0x3ffff) - opMaxArgSbx
+				}
+				cf.Pc++
+				return 0
 			}
-			rg.array[regi] = rg.alloc.LNumber2I(vali)
-			if regi >= rg.top {
-				rg.top = regi + 1
-			}
-		}
-	} else {
-		v := objectArith(L, opcode, lhs, rhs)
-		// this section is inlined by go-inline
-		// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
-		{
-			rg := reg
-			regi := RA
-			vali := v
-			newSize := regi + 1
 			// this section is inlined by go-inline
-			// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+			// source function is 'func (rg *registry) SetTop(topi int) ' in '_state.go'
 			{
-				requiredSize := newSize
-				if requiredSize > cap(rg.array) {
-					rg.resize(requiredSize)
+				rg := reg
+				topi := RA + 3 + 2
+				// this section is inlined by go-inline
+				// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+				{
+					requiredSize := topi
+					if requiredSize > cap(rg.array) {
+						rg.resize(requiredSize)
+					}
+				}
+				oldtopi := rg.top
+				rg.top = topi
+				for i := oldtopi; i < rg.top; i++ {
+					rg.array[i] = LNil
+				}
+				// values beyond top don't need to be valid LValues, so setting them to nil is fine
+				// setting them to nil rather than LNil lets us invoke the golang memclr opto
+				if rg.top < oldtopi {
+					nilRange := rg.array[rg.top:oldtopi]
+					for i := range nilRange {
+						nilRange[i] = nil
+					}
+				}
+				//for i := rg.top; i < oldtop; i++ {
+				//	rg.array[i] = LNil
+				//}
+			}
+			// this section is inlined by go-inline
+			// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
+			{
+				rg := reg
+				regi := RA + 3 + 2
+				vali := reg.Get(RA + 2)
+				newSize := regi + 1
+				// this section is inlined by go-inline
+				// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+				{
+					requiredSize := newSize
+					if requiredSize > cap(rg.array) {
+						rg.resize(requiredSize)
+					}
+				}
+				rg.array[regi] = vali
+				if regi >= rg.top {
+					rg.top = regi + 1
+				}
+			}
+			// this section is inlined by go-inline
+			// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
+			{
+				rg := reg
+				regi := RA + 3 + 1
+				vali := reg.Get(RA + 1)
+				newSize := regi + 1
+				// this section is inlined by go-inline
+				// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+				{
+					requiredSize := newSize
+					if requiredSize > cap(rg.array) {
+						rg.resize(requiredSize)
+					}
+				}
+				rg.array[regi] = vali
+				if regi >= rg.top {
+					rg.top = regi + 1
+				}
+			}
+			// this section is inlined by go-inline
+			// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
+			{
+				rg := reg
+				regi := RA + 3
+				vali := reg.Get(RA)
+				newSize := regi + 1
+				// this section is inlined by go-inline
+				// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+				{
+					requiredSize := newSize
+					if requiredSize > cap(rg.array) {
+						rg.resize(requiredSize)
+					}
+				}
+				rg.array[regi] = vali
+				if regi >= rg.top {
+					rg.top = regi + 1
+				}
+			}
+			L.callR(2, nret, RA+3)
+			if value := reg.Get(RA + 3); value != LNil {
+				// this section is inlined by go-inline
+				// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
+				{
+					rg := reg
+					regi := RA + 2
+					vali := value
+					newSize := regi + 1
+					// this section is inlined by go-inline
+					// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+					{
+						requiredSize := newSize
+						if requiredSize > cap(rg.array) {
+							rg.resize(requiredSize)
+						}
+					}
+					rg.array[regi] = vali
+					if regi >= rg.top {
+						rg.top = regi + 1
+					}
+				}
+				pc := cf.Fn.Proto.Code[cf.Pc]
+				cf.Pc += int(pc&0x3ffff) - opMaxArgSbx
+			}
+			cf.Pc++
+			return 0
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_SELECTVA
+			reg := L.reg
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			C := int(inst>>9) & 0x1ff //GETC
+			nret := C - 1
+			nparams := int(cf.Fn.Proto.NumParameters)
+			nvarargs := cf.NArgs - nparams
+			if nvarargs < 0 {
+				nvarargs = 0
+			}
+			if fn, ok := reg.Get(RA).(*LFunction); ok && fn == L.G.selectfn {
+				// same as calling select, but the varargs are read where they are instead of being copied as
+				// arguments. Invalid arguments are left to the call below to report.
+				switch lv := reg.Get(RA + 1).(type) {
+				case LNumber:
+					idx := int(lv)
+					if idx < 0 {
+						idx += nvarargs + 1
+					} else if idx > nvarargs+1 {
+						idx = nvarargs + 1
+					}
+					if idx >= 1 {
+						nwant := nret
+						if nret < 0 {
+							nwant = nvarargs - idx + 1
+						}
+						// this section is inlined by go-inline
+						// source function is 'func (rg *registry) CopyRange(regv, start, limit, n int) ' in '_state.go'
+						{
+							rg := reg
+							regv := RA
+							start := cf.Base + nparams + idx
+							limit := cf.LocalBase
+							n := nwant
+							newSize := regv + n
+							// this section is inlined by go-inline
+							// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+							{
+								requiredSize := newSize
+								if requiredSize > cap(rg.array) {
+									rg.resize(requiredSize)
+								}
+							}
+							if limit == -1 || limit > rg.top {
+								limit = rg.top
+							}
+							for i := 0; i < n; i++ {
+								srcIdx := start + i
+								if srcIdx >= limit || srcIdx < 0 {
+									rg.array[regv+i] = LNil
+								} else {
+									rg.array[regv+i] = rg.array[srcIdx]
+								}
+							}
+
+							// values beyond top don't need to be valid LValues, so setting them to nil is fine
+							// setting them to nil rather than LNil lets us invoke the golang memclr opto
+							oldtop := rg.top
+							rg.top = regv + n
+							if rg.top < oldtop {
+								nilRange := rg.array[rg.top:oldtop]
+								for i := range nilRange {
+									nilRange[i] = nil
+								}
+							}
+						}
+						return 0
+					}
+				case LString:
+					if lv == "#" {
+						nwant := nret
+						if nret < 0 {
+							nwant = 1
+						}
+						// this section is inlined by go-inline
+						// source function is 'func (rg *registry) FillNil(regm, n int) ' in '_state.go'
+						{
+							rg := reg
+							regm := RA
+							n := nwant
+							newSize := regm + n
+							// this section is inlined by go-inline
+							// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+							{
+								requiredSize := newSize
+								if requiredSize > cap(rg.array) {
+									rg.resize(requiredSize)
+								}
+							}
+							for i := 0; i < n; i++ {
+								rg.array[regm+i] = LNil
+							}
+							// values beyond top don't need to be valid LValues, so setting them to nil is fine
+							// setting them to nil rather than LNil lets us invoke the golang memclr opto
+							oldtop := rg.top
+							rg.top = regm + n
+							if rg.top < oldtop {
+								nilRange := rg.array[rg.top:oldtop]
+								for i := range nilRange {
+									nilRange[i] = nil
+								}
+							}
+						}
+						if nwant > 0 {
+							// this section is inlined by go-inline
+							// source function is 'func (rg *registry) SetNumber(regi int, vali LNumber) ' in '_state.go'
+							{
+								rg := reg
+								regi := RA
+								vali := LNumber(nvarargs)
+								newSize := regi + 1
+								// this section is inlined by go-inline
+								// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+								{
+									requiredSize := newSize
+									if requiredSize > cap(rg.array) {
+										rg.resize(requiredSize)
+									}
+								}
+								rg.array[regi] = rg.alloc.LNumber2I(vali)
+								if regi >= rg.top {
+									rg.top = regi + 1
+								}
+							}
+						}
+						return 0
+					}
+				}
+			}
+			// this section is inlined by go-inline
+			// source function is 'func (rg *registry) CopyRange(regv, start, limit, n int) ' in '_state.go'
+			{
+				rg := reg
+				regv := RA + 2
+				start := cf.Base + nparams + 1
+				limit := cf.LocalBase
+				n := nvarargs
+				newSize := regv + n
+				// this section is inlined by go-inline
+				// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+				{
+					requiredSize := newSize
+					if requiredSize > cap(rg.array) {
+						rg.resize(requiredSize)
+					}
+				}
+				if limit == -1 || limit > rg.top {
+					limit = rg.top
+				}
+				for i := 0; i < n; i++ {
+					srcIdx := start + i
+					if srcIdx >= limit || srcIdx < 0 {
+						rg.array[regv+i] = LNil
+					} else {
+						rg.array[regv+i] = rg.array[srcIdx]
+					}
+				}
+
+				// values beyond top don't need to be valid LValues, so setting them to nil is fine
+				// setting them to nil rather than LNil lets us invoke the golang memclr opto
+				oldtop := rg.top
+				rg.top = regv + n
+				if rg.top < oldtop {
+					nilRange := rg.array[rg.top:oldtop]
+					for i := range nilRange {
+						nilRange[i] = nil
+					}
+				}
+			}
+			return jumpTable[OP_CALL](L, opCreateABC(OP_CALL, A, 0, C), baseframe)
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_EQJMP
+			cf := L.currentFrame
+			A := int(inst>>18) & 0xff //GETA
+			B := int(inst & 0x1ff)    //GETB
+			C := int(inst>>9) & 0x1ff //GETC
+			if equals(L, L.rkValue(B), L.rkValue(C), false) == (A != 0) {
+				jmp := cf.Fn.Proto.Code[cf.Pc]
+				cf.Pc += int(jmp&0x3ffff) - opMaxArgSbx
+			}
+			cf.Pc++
+			return 0
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_LTJMP
+			cf := L.currentFrame
+			A := int(inst>>18) & 0xff //GETA
+			B := int(inst & 0x1ff)    //GETB
+			C := int(inst>>9) & 0x1ff //GETC
+			if lessThan(L, L.rkValue(B), L.rkValue(C)) == (A != 0) {
+				jmp := cf.Fn.Proto.Code[cf.Pc]
+				cf.Pc += int(jmp&0x3ffff) - opMaxArgSbx
+			}
+			cf.Pc++
+			return 0
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_LEJMP
+			cf := L.currentFrame
+			A := int(inst>>18) & 0xff //GETA
+			B := int(inst & 0x1ff)    //GETB
+			C := int(inst>>9) & 0x1ff //GETC
+			if lessThanOrEqual(L, L.rkValue(B), L.rkValue(C)) == (A != 0) {
+				jmp := cf.Fn.Proto.Code[cf.Pc]
+				cf.Pc += int(jmp&0x3ffff) - opMaxArgSbx
+			}
+			cf.Pc++
+			return 0
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_TESTJMP
+			reg := L.reg
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			C := int(inst>>9) & 0x1ff //GETC
+			if LVAsBool(reg.Get(RA)) != (C == 0) {
+				jmp := cf.Fn.Proto.Code[cf.Pc]
+				cf.Pc += int(jmp&0x3ffff) - opMaxArgSbx
+			}
+			cf.Pc++
+			return 0
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_SELFCALL
+			reg := L.reg
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			B := int(inst & 0x1ff)    //GETB
+			C := int(inst>>9) & 0x1ff //GETC
+			selfobj := reg.Get(lbase + B)
+			v := L.getFieldStringCached(cf.Fn, cf.Pc-1, selfobj, L.rkString(C))
+			// this section is inlined by go-inline
+			// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
+			{
+				rg := reg
+				regi := RA
+				vali := v
+				newSize := regi + 1
+				// this section is inlined by go-inline
+				// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+				{
+					requiredSize := newSize
+					if requiredSize > cap(rg.array) {
+						rg.resize(requiredSize)
+					}
+				}
+				rg.array[regi] = vali
+				if regi >= rg.top {
+					rg.top = regi + 1
+				}
+			}
+			// this section is inlined by go-inline
+			// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
+			{
+				rg := reg
+				regi := RA + 1
+				vali := selfobj
+				newSize := regi + 1
+				// this section is inlined by go-inline
+				// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+				{
+					requiredSize := newSize
+					if requiredSize > cap(rg.array) {
+						rg.resize(requiredSize)
+					}
+				}
+				rg.array[regi] = vali
+				if regi >= rg.top {
+					rg.top = regi + 1
+				}
+			}
+			inst = cf.Fn.Proto.Code[cf.Pc]
+			cf.Pc++
+			return jumpTable[OP_CALL](L, inst, baseframe)
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_RETURNK
+			reg := L.reg
+			cf := L.currentFrame
+			lbase := cf.LocalBase
+			A := int(inst>>18) & 0xff //GETA
+			RA := lbase + A
+			Bx := int(inst & 0x3ffff) //GETBX
+			v := cf.Fn.Proto.Constants[Bx]
+			// this section is inlined by go-inline
+			// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
+			{
+				rg := reg
+				regi := RA
+				vali := v
+				newSize := regi + 1
+				// this section is inlined by go-inline
+				// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+				{
+					requiredSize := newSize
+					if requiredSize > cap(rg.array) {
+						rg.resize(requiredSize)
+					}
+				}
+				rg.array[regi] = vali
+				if regi >= rg.top {
+					rg.top = regi + 1
+				}
+			}
+			inst = cf.Fn.Proto.Code[cf.Pc]
+			cf.Pc++
+			return jumpTable[OP_RETURN](L, inst, baseframe)
+		},
+		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_NOP
+			return 0
+		},
+	}
+}
+
+func opArith(L *LState, inst uint32, baseframe *callFrame) int { //OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_POW
+	reg := L.reg
+	cf := L.currentFrame
+	lbase := cf.LocalBase
+	A := int(inst>>18) & 0xff //GETA
+	RA := lbase + A
+	opcode := int(inst >> 26) //GETOPCODE
+	B := int(inst & 0x1ff)    //GETB
+	C := int(inst>>9) & 0x1ff //GETC
+	lhs := L.rkValue(B)
+	rhs := L.rkValue(C)
+	v1, ok1 := lhs.(LNumber)
+	v2, ok2 := rhs.(LNumber)
+	if ok1 && ok2 {
+		v := numberArith(L, opcode, LNumber(v1), LNumber(v2))
+		// this section is inlined by go-inline
+		// source function is 'func (rg *registry) SetNumber(regi int, vali LNumber) ' in '_state.go'
+		{
+			rg := reg
+			regi := RA
+			vali := v
+			newSize := regi + 1
+			// this section is inlined by go-inline
+			// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+			{
+				requiredSize := newSize
+				if requiredSize > cap(rg.array) {
+					rg.resize(requiredSize)
+				}
+			}
+			rg.array[regi] = rg.alloc.LNumber2I(vali)
+			if regi >= rg.top {
+				rg.top = regi + 1
+			}
+		}
+	} else {
+		v := objectArith(L, opcode, lhs, rhs)
+		// this section is inlined by go-inline
+		// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
+		{
+			rg := reg
+			regi := RA
+			vali := v
+			newSize := regi + 1
+			// this section is inlined by go-inline
+			// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+			{
+				requiredSize := newSize
+				if requiredSize > cap(rg.array) {
+					rg.resize(requiredSize)
 				}
 			}
 			rg.array[regi] = vali
@@ -2337,17 +3025,21 @@ func objectArith(L *LState, opcode int, lhs, rhs LValue) LValue {
 			return numberArith(L, opcode, LNumber(v1), LNumber(v2))
 		}
 	}
-	L.RaiseError("cannot perform %v operation between %v and %v",
-		strings.TrimLeft(event, "_"), lhs.Type().String(), rhs.Type().String())
+	bad := lhs
+	if _, ok := lhs.(LNumber); ok {
+		bad = rhs
+	}
+	L.RaiseError("cannot perform %v operation between %v and %v%s",
+		strings.TrimLeft(event, "_"), lhs.Type().String(), rhs.Type().String(), L.varInfo(bad))
 
 	return LNil
 }
 
 func stringConcat(L *LState, total, last int) LValue {
-	rhs := L.reg.Get(last)
+	rhs := concatValue(L.reg.Get(last))
 	total--
 	for i := last - 1; total > 0; {
-		lhs := L.reg.Get(i)
+		lhs := concatValue(L.reg.Get(i))
 		if !(LVCanConvToString(lhs) && LVCanConvToString(rhs)) {
 			op := L.metaOp2(lhs, rhs, "__concat")
 			if op.Type() == LTFunction {
@@ -2359,18 +3051,22 @@ func stringConcat(L *LState, total, last int) LValue {
 				total--
 				i--
 			} else {
-				L.RaiseError("cannot perform concat operation between %v and %v", lhs.Type().String(), rhs.Type().String())
+				bad := lhs
+				if LVCanConvToString(lhs) {
+					bad = rhs
+				}
+				L.RaiseError("cannot perform concat operation between %v and %v%s", lhs.Type().String(), rhs.Type().String(), L.varInfo(bad))
 				return LNil
 			}
 		} else {
 			buf := make([]string, total+1)
-			buf[total] = LVAsString(rhs)
+			buf[total] = L.lvToString(rhs)
 			for total > 0 {
-				lhs = L.reg.Get(i)
+				lhs = concatValue(L.reg.Get(i))
 				if !LVCanConvToString(lhs) {
 					break
 				}
-				buf[total-1] = LVAsString(lhs)
+				buf[total-1] = L.lvToString(lhs)
 				i--
 				total--
 			}
@@ -2395,13 +3091,42 @@ func lessThan(L *LState, lhs, rhs LValue) bool {
 	ret := false
 	switch lhs.Type() {
 	case LTString:
-		ret = strCmp(string(lhs.(LString)), string(rhs.(LString))) < 0
+		ret = L.compareStrings(string(lhs.(LString)), string(rhs.(LString))) < 0
 	default:
 		ret = objectRationalWithError(L, lhs, rhs, "__lt")
 	}
 	return ret
 }
 
+func lessThanOrEqual(L *LState, lhs, rhs LValue) bool {
+	// optimization for numbers
+	if v1, ok1 := lhs.(LNumber); ok1 {
+		if v2, ok2 := rhs.(LNumber); ok2 {
+			return v1 <= v2
+		}
+		L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
+	}
+	if lhs.Type() != rhs.Type() {
+		L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
+		return false
+	}
+	ret := false
+	switch lhs.Type() {
+	case LTString:
+		ret = L.compareStrings(string(lhs.(LString)), string(rhs.(LString))) <= 0
+	default:
+		switch objectRational(L, lhs, rhs, "__le") {
+		case 1:
+			ret = true
+		case 0:
+			ret = false
+		default:
+			ret = !objectRationalWithError(L, rhs, lhs, "__lt")
+		}
+	}
+	return ret
+}
+
 func equals(L *LState, lhs, rhs LValue, raw bool) bool {
 	lt := lhs.Type()
 	if lt != rhs.Type() {
@@ -2431,6 +3156,12 @@ func equals(L *LState, lhs, rhs LValue, raw bool) bool {
 				ret = false
 			}
 		}
+	case LTObject:
+		if lhs == rhs {
+			ret = true
+		} else if eq, ok := lhs.(Equaler); ok && !raw {
+			ret = eq.Equals(L, rhs)
+		}
 	default:
 		ret = lhs == rhs
 	}
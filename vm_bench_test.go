@@ -0,0 +1,123 @@
+package lua
+
+import (
+	"context"
+	"testing"
+)
+
+const benchLoopScript = `
+local s, t = 0, {1, 2, 3}
+for i = 1, 1000000 do
+	if i > 2 then
+		s = s + t[3] * 2
+	else
+		s = s - 1
+	end
+end
+local function fib(n) if n < 2 then return n end return fib(n-1) + fib(n-2) end
+fib(20)
+`
+
+func benchmarkMainLoop(b *testing.B, withContext bool) {
+	L := NewState()
+	defer L.Close()
+	fn, err := L.LoadString(benchLoopScript)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if withContext {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		L.SetContext(ctx)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		L.Push(fn)
+		L.Call(0, 0)
+	}
+}
+
+func BenchmarkMainLoop(b *testing.B) {
+	benchmarkMainLoop(b, false)
+}
+
+func BenchmarkMainLoopWithContext(b *testing.B) {
+	benchmarkMainLoop(b, true)
+}
+
+func benchmarkScript(b *testing.B, script string) {
+	L := NewState()
+	defer L.Close()
+	fn, err := L.LoadString(script)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		L.Push(fn)
+		L.Call(0, 0)
+	}
+}
+
+func BenchmarkMultiReturnLua(b *testing.B) {
+	benchmarkScript(b, `
+local function f(a) return a, a + 1, a + 2 end
+local s = 0
+for i = 1, 1000 do
+	local x, y, z = f(i)
+	s = s + x + y + z
+end
+`)
+}
+
+func BenchmarkMultiReturnGo(b *testing.B) {
+	benchmarkScript(b, `
+local find = string.find
+local s = 0
+for i = 1, 1000 do
+	local x, y = find("hello world", "wor", 1, true)
+	s = s + x + y
+end
+`)
+}
+
+func BenchmarkVarArgSelect(b *testing.B) {
+	benchmarkScript(b, `
+local function sum(...)
+	local s = 0
+	for i = 1, select('#', ...) do
+		s = s + select(i, ...)
+	end
+	return s
+end
+local s = 0
+for i = 1, 1000 do
+	s = s + sum(i, 1, 2, 3, 4, 5, 6, 7)
+end
+`)
+}
+
+func BenchmarkMethodCall(b *testing.B) {
+	benchmarkScript(b, `
+local Point = {}
+Point.__index = Point
+function Point:move(dx) self.x = self.x + dx end
+function Point:get() return self.x end
+local p = setmetatable({x = 0}, Point)
+for i = 1, 1000 do
+	p:move(1)
+	p:get()
+end
+`)
+}
+
+func BenchmarkTableConcat(b *testing.B) {
+	benchmarkScript(b, `
+local t = {}
+for i = 1, 10000 do
+	t[i] = i % 2 == 0 and "item" or i
+end
+local s = table.concat(t, ",")
+`)
+}